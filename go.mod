@@ -3,6 +3,7 @@ module github.com/bitop-dev/agent
 go 1.26.0
 
 require (
+	github.com/klauspost/compress v1.18.0
 	gopkg.in/yaml.v3 v3.0.1
 	modernc.org/sqlite v1.47.0
 )
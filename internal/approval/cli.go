@@ -14,6 +14,8 @@ type CLIResolver struct {
 	Mode   approval.Mode
 	Reader io.Reader
 	Writer io.Writer
+	// Color highlights the diff of a file-writing call with ANSI colors.
+	Color bool
 }
 
 func (r CLIResolver) Resolve(_ context.Context, req approval.Request) (approval.Decision, error) {
@@ -24,6 +26,11 @@ func (r CLIResolver) Resolve(_ context.Context, req approval.Request) (approval.
 		return approval.Decision{Approved: false, Reason: "denied by mode=never"}, nil
 	default:
 		reader := bufio.NewReader(r.Reader)
+		if req.Diff != "" {
+			if _, err := io.WriteString(r.Writer, renderDiff(req.Diff, r.Color)); err != nil {
+				return approval.Decision{}, err
+			}
+		}
 		if _, err := fmt.Fprintf(r.Writer, "Approve %s for tool %s? [y/N]: ", req.Action, req.ToolID); err != nil {
 			return approval.Decision{}, err
 		}
@@ -36,3 +43,42 @@ func (r CLIResolver) Resolve(_ context.Context, req approval.Request) (approval.
 		return approval.Decision{Approved: approved}, nil
 	}
 }
+
+// ANSI colors of diff lines.
+const (
+	colorBold  = "\x1b[1m"
+	colorCyan  = "\x1b[36m"
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+	colorReset = "\x1b[0m"
+)
+
+// renderDiff returns a unified diff for the terminal, colored as git
+// colors it when color is set.
+func renderDiff(diff string, color bool) string {
+	if !strings.HasSuffix(diff, "\n") {
+		diff += "\n"
+	}
+	if !color {
+		return diff
+	}
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(diff, "\n") {
+		if line == "" {
+			continue
+		}
+		text := strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(text, "--- "), strings.HasPrefix(text, "+++ "):
+			text = colorBold + text + colorReset
+		case strings.HasPrefix(text, "@@"):
+			text = colorCyan + text + colorReset
+		case strings.HasPrefix(text, "-"):
+			text = colorRed + text + colorReset
+		case strings.HasPrefix(text, "+"):
+			text = colorGreen + text + colorReset
+		}
+		b.WriteString(text + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,34 @@
+package approval
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/bitop-dev/agent/pkg/approval"
+)
+
+func TestCLIResolverShowsDiffBeforePrompt(t *testing.T) {
+	diff := "--- a/x.txt\n+++ b/x.txt\n@@ -1 +1 @@\n-old\n+new\n"
+	var out bytes.Buffer
+	resolver := CLIResolver{Mode: approval.ModeOnRequest, Reader: strings.NewReader("y\n"), Writer: &out}
+	decision, err := resolver.Resolve(context.Background(), approval.Request{ToolID: "core/write", Action: "write", Diff: diff})
+	if err != nil || !decision.Approved {
+		t.Fatalf("expected approval, got %+v, %v", decision, err)
+	}
+	if !strings.HasPrefix(out.String(), diff+"Approve write") {
+		t.Fatalf("expected plain diff before the prompt, got %q", out.String())
+	}
+
+	out.Reset()
+	resolver.Reader, resolver.Color = strings.NewReader("n\n"), true
+	if _, err := resolver.Resolve(context.Background(), approval.Request{ToolID: "core/write", Action: "write", Diff: diff}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{colorRed + "-old" + colorReset, colorGreen + "+new" + colorReset, colorCyan + "@@ -1 +1 @@" + colorReset, colorBold + "--- a/x.txt" + colorReset} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected %q in colored output %q", want, out.String())
+		}
+	}
+}
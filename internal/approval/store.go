@@ -0,0 +1,172 @@
+package approval
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Pending approval states.
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusDenied   = "denied"
+	StatusExpired  = "expired"
+)
+
+// Pending is a persisted approval request awaiting a human decision. Records
+// outlive the process, so a call retried while its request is still pending
+// waits on the same record instead of notifying reviewers again.
+type Pending struct {
+	ID         string         `json:"id"`
+	Status     string         `json:"status"`
+	Session    string         `json:"session,omitempty"`
+	ToolID     string         `json:"toolId"`
+	ToolCallID string         `json:"toolCallId,omitempty"`
+	Action     string         `json:"action,omitempty"`
+	Reason     string         `json:"reason,omitempty"`
+	Risk       string         `json:"risk,omitempty"`
+	Arguments  map[string]any `json:"arguments,omitempty"`
+	Diff       string         `json:"diff,omitempty"` // the change a file-writing call would make
+	CreatedAt  time.Time      `json:"createdAt"`
+	ExpiresAt  time.Time      `json:"expiresAt"`
+	DecidedAt  time.Time      `json:"decidedAt,omitzero"`
+	DecidedBy  string         `json:"decidedBy,omitempty"`
+	Decision   string         `json:"decision,omitempty"` // reviewer's reason
+}
+
+// FileStore keeps one JSON file per approval under Dir.
+type FileStore struct {
+	Dir string
+}
+
+func (s FileStore) Create(p *Pending) error {
+	if p.ID == "" {
+		var b [6]byte
+		_, _ = rand.Read(b[:])
+		p.ID = "apr_" + hex.EncodeToString(b[:])
+	}
+	if p.Status == "" {
+		p.Status = StatusPending
+	}
+	return s.write(*p)
+}
+
+func (s FileStore) Get(id string) (Pending, error) {
+	if strings.ContainsAny(id, `/\`) || id == "" {
+		return Pending{}, fmt.Errorf("invalid approval id %q", id)
+	}
+	data, err := os.ReadFile(filepath.Join(s.Dir, id+".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Pending{}, fmt.Errorf("approval %q not found", id)
+	}
+	if err != nil {
+		return Pending{}, err
+	}
+	var p Pending
+	if err := json.Unmarshal(data, &p); err != nil {
+		return Pending{}, fmt.Errorf("approval %q: %w", id, err)
+	}
+	return p, nil
+}
+
+// List returns all records, oldest first.
+func (s FileStore) List() ([]Pending, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []Pending
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		p, err := s.Get(id)
+		if err != nil {
+			continue
+		}
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+// FindOpen returns the undecided record of the same call as call: one with
+// its session, tool call ID, tool, and arguments. Tool call IDs are only
+// unique within a conversation, so a match on the ID alone could hand one
+// call another's approval.
+func (s FileStore) FindOpen(call Pending) (Pending, bool) {
+	if call.ToolCallID == "" {
+		return Pending{}, false
+	}
+	arguments, err := json.Marshal(call.Arguments)
+	if err != nil {
+		return Pending{}, false
+	}
+	all, _ := s.List()
+	for _, p := range all {
+		if p.Status != StatusPending || p.Session != call.Session || p.ToolCallID != call.ToolCallID || p.ToolID != call.ToolID {
+			continue
+		}
+		// Compare as JSON: the stored arguments have been through it.
+		if stored, err := json.Marshal(p.Arguments); err == nil && bytes.Equal(stored, arguments) {
+			return p, true
+		}
+	}
+	return Pending{}, false
+}
+
+// Decide records a decision. Only pending approvals can be decided.
+func (s FileStore) Decide(id string, approved bool, reason, by string) (Pending, error) {
+	p, err := s.Get(id)
+	if err != nil {
+		return Pending{}, err
+	}
+	if p.Status != StatusPending {
+		return p, fmt.Errorf("approval %s is already %s", id, p.Status)
+	}
+	p.Status = StatusDenied
+	if approved {
+		p.Status = StatusApproved
+	}
+	p.Decision = reason
+	p.DecidedBy = by
+	p.DecidedAt = time.Now().UTC()
+	return p, s.write(p)
+}
+
+func (s FileStore) expire(p Pending) error {
+	p.Status = StatusExpired
+	p.DecidedAt = time.Now().UTC()
+	p.Decision = "timed out waiting for approval"
+	return s.write(p)
+}
+
+func (s FileStore) write(p Pending) error {
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.Dir, p.ID+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,166 @@
+package approval
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/approval"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// WebhookResolver pauses a run on each approval request, posts the call
+// details to a webhook (generic JSON or a Slack incoming webhook), and waits
+// for a human to decide via `agent approvals approve|deny` or the serve
+// worker's /v1/approvals endpoint. Requests that are not decided before
+// Timeout are denied.
+type WebhookResolver struct {
+	URL          string
+	Format       string // "json" (default) or "slack"
+	Secret       string // optional; signs payloads with X-Agent-Signature
+	CallbackURL  string // optional; serve worker base URL included in notifications
+	Timeout      time.Duration
+	PollInterval time.Duration
+	Store        FileStore
+	Client       *http.Client
+}
+
+func (r WebhookResolver) Resolve(ctx context.Context, req approval.Request) (approval.Decision, error) {
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Minute
+	}
+	poll := r.PollInterval
+	if poll <= 0 {
+		poll = 2 * time.Second
+	}
+	// A retried call (e.g. after a restart) waits on its undecided record
+	// so the reviewer is not notified twice.
+	session, _ := tool.SessionFrom(ctx)
+	pending, ok := r.Store.FindOpen(Pending{Session: session, ToolID: req.ToolID, ToolCallID: req.ToolCallID, Arguments: req.Arguments})
+	if !ok {
+		now := time.Now().UTC()
+		pending = Pending{
+			Session:    session,
+			ToolID:     req.ToolID,
+			ToolCallID: req.ToolCallID,
+			Action:     req.Action,
+			Reason:     req.Reason,
+			Risk:       req.Risk,
+			Arguments:  req.Arguments,
+			Diff:       req.Diff,
+			CreatedAt:  now,
+			ExpiresAt:  now.Add(timeout),
+		}
+		if err := r.Store.Create(&pending); err != nil {
+			return approval.Decision{}, fmt.Errorf("persist approval: %w", err)
+		}
+		if err := r.notify(ctx, pending); err != nil {
+			_, _ = r.Store.Decide(pending.ID, false, "notification failed: "+err.Error(), "system")
+			return approval.Decision{Approved: false, Reason: fmt.Sprintf("approval webhook failed: %v", err)}, nil
+		}
+	}
+
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		current, err := r.Store.Get(pending.ID)
+		if err != nil {
+			return approval.Decision{}, err
+		}
+		switch current.Status {
+		case StatusApproved:
+			return approval.Decision{Approved: true, Reason: decisionReason(current)}, nil
+		case StatusDenied, StatusExpired:
+			return approval.Decision{Approved: false, Reason: decisionReason(current)}, nil
+		}
+		if time.Now().After(current.ExpiresAt) {
+			_ = r.Store.expire(current)
+			return approval.Decision{Approved: false, Reason: fmt.Sprintf("approval %s timed out", current.ID)}, nil
+		}
+		select {
+		case <-ctx.Done():
+			return approval.Decision{}, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func decisionReason(p Pending) string {
+	reason := fmt.Sprintf("%s %s", p.ID, p.Status)
+	if p.DecidedBy != "" {
+		reason += " by " + p.DecidedBy
+	}
+	if p.Decision != "" {
+		reason += ": " + p.Decision
+	}
+	return reason
+}
+
+func (r WebhookResolver) notify(ctx context.Context, p Pending) error {
+	if r.URL == "" {
+		return errors.New("approvals.webhookURL is not configured")
+	}
+	var payload any = p
+	if r.Format == "slack" {
+		payload = map[string]any{"text": slackText(p, r.CallbackURL)}
+	} else if r.CallbackURL != "" {
+		payload = struct {
+			Pending
+			CallbackURL string `json:"callbackURL"`
+		}{p, strings.TrimRight(r.CallbackURL, "/") + "/v1/approvals/" + p.ID}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, r.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if r.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(r.Secret))
+		mac.Write(body)
+		httpReq.Header.Set("X-Agent-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	client := r.Client
+	if client == nil {
+		client = &http.Client{Timeout: 15 * time.Second}
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func slackText(p Pending, callbackURL string) string {
+	args, _ := json.MarshalIndent(p.Arguments, "", "  ")
+	var b strings.Builder
+	fmt.Fprintf(&b, ":warning: *Approval required* for `%s`", p.ToolID)
+	if p.Risk != "" {
+		fmt.Fprintf(&b, " (risk: %s)", p.Risk)
+	}
+	if p.Reason != "" {
+		fmt.Fprintf(&b, "\n%s", p.Reason)
+	}
+	fmt.Fprintf(&b, "\n```%s```", args)
+	fmt.Fprintf(&b, "\nRespond before %s with `agent approvals approve %s` or `agent approvals deny %s`", p.ExpiresAt.Format(time.RFC3339), p.ID, p.ID)
+	if callbackURL != "" {
+		fmt.Fprintf(&b, ", or POST {\"approved\": true} to %s/v1/approvals/%s", strings.TrimRight(callbackURL, "/"), p.ID)
+	}
+	return b.String()
+}
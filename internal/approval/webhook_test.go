@@ -0,0 +1,121 @@
+package approval
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/approval"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+func TestWebhookResolverWaitsForDecision(t *testing.T) {
+	store := FileStore{Dir: t.TempDir()}
+	posted := make(chan Pending, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p Pending
+		_ = json.NewDecoder(r.Body).Decode(&p)
+		posted <- p
+	}))
+	defer hook.Close()
+
+	resolver := WebhookResolver{URL: hook.URL, Store: store, Timeout: time.Minute, PollInterval: 5 * time.Millisecond}
+	go func() {
+		p := <-posted
+		_, _ = store.Decide(p.ID, true, "looks fine", "alice")
+	}()
+	decision, err := resolver.Resolve(context.Background(), approval.Request{ToolID: "core/bash", ToolCallID: "call_1", Risk: "high", Arguments: map[string]any{"command": "rm -rf build"}})
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if !decision.Approved {
+		t.Fatalf("expected approval, got %+v", decision)
+	}
+
+	// A decided record is not reused: the same call ID asks again.
+	go func() {
+		p := <-posted
+		_, _ = store.Decide(p.ID, false, "", "bob")
+	}()
+	decision, err = resolver.Resolve(context.Background(), approval.Request{ToolID: "core/bash", ToolCallID: "call_1", Arguments: map[string]any{"command": "rm -rf build"}})
+	if err != nil || decision.Approved {
+		t.Fatalf("second call = %+v, %v", decision, err)
+	}
+	if records, _ := store.List(); len(records) != 2 {
+		t.Fatalf("expected a second record, got %+v", records)
+	}
+}
+
+func TestWebhookResolverReusesOnlyTheSamePendingCall(t *testing.T) {
+	store := FileStore{Dir: t.TempDir()}
+	posted := make(chan Pending, 2)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p Pending
+		_ = json.NewDecoder(r.Body).Decode(&p)
+		posted <- p
+	}))
+	defer hook.Close()
+
+	// A record left pending by a run that stopped, for session s1.
+	open := Pending{Session: "s1", ToolID: "core/bash", ToolCallID: "call_1", Arguments: map[string]any{"command": "make", "jobs": 4}, ExpiresAt: time.Now().Add(time.Minute)}
+	if err := store.Create(&open); err != nil {
+		t.Fatal(err)
+	}
+	resolver := WebhookResolver{URL: hook.URL, Store: store, Timeout: time.Minute, PollInterval: 5 * time.Millisecond}
+
+	// The same call in another session, or with other arguments, asks anew.
+	for _, tc := range []struct {
+		session string
+		args    map[string]any
+	}{
+		{"s2", map[string]any{"command": "make", "jobs": 4}},
+		{"s1", map[string]any{"command": "make install", "jobs": 4}},
+	} {
+		go func() {
+			p := <-posted
+			_, _ = store.Decide(p.ID, false, "", "alice")
+		}()
+		ctx := tool.WithSession(context.Background(), tc.session)
+		if decision, err := resolver.Resolve(ctx, approval.Request{ToolID: "core/bash", ToolCallID: "call_1", Arguments: tc.args}); err != nil || decision.Approved {
+			t.Fatalf("%s %v = %+v, %v", tc.session, tc.args, decision, err)
+		}
+	}
+	if current, _ := store.Get(open.ID); current.Status != StatusPending {
+		t.Fatalf("pending record was decided: %+v", current)
+	}
+
+	// A retry of the same call waits on its pending record without posting.
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_, _ = store.Decide(open.ID, true, "", "alice")
+	}()
+	ctx := tool.WithSession(context.Background(), "s1")
+	decision, err := resolver.Resolve(ctx, approval.Request{ToolID: "core/bash", ToolCallID: "call_1", Arguments: map[string]any{"command": "make", "jobs": 4}})
+	if err != nil || !decision.Approved {
+		t.Fatalf("retry = %+v, %v", decision, err)
+	}
+	if len(posted) != 0 {
+		t.Fatal("retried call was posted again")
+	}
+}
+
+func TestWebhookResolverTimesOut(t *testing.T) {
+	hook := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer hook.Close()
+	store := FileStore{Dir: t.TempDir()}
+	resolver := WebhookResolver{URL: hook.URL, Store: store, Timeout: 20 * time.Millisecond, PollInterval: 5 * time.Millisecond}
+	decision, err := resolver.Resolve(context.Background(), approval.Request{ToolID: "core/bash", ToolCallID: "call_2"})
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if decision.Approved {
+		t.Fatal("expected timeout denial")
+	}
+	records, _ := store.List()
+	if len(records) != 1 || records[0].Status != StatusExpired {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+}
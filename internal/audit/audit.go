@@ -0,0 +1,149 @@
+// Package audit keeps an append-only, tamper-evident log of tool calls.
+//
+// Each line of the log is a JSON pkg/runtime.AuditRecord with two more
+// fields: prev, the hash of the line before it, and hash, the SHA-256 of
+// prev and the line's record. Editing, removing, or reordering a line
+// breaks the chain from there on, which Verify reports; lines cut from the
+// end show only against a copy of the last hash kept elsewhere.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/bitop-dev/agent/internal/filelock"
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+)
+
+// tailBytes is how much of the end of the log is read to find the hash of
+// its last line; records are far shorter.
+const tailBytes = 64 * 1024
+
+// line is a record as written to the log.
+type line struct {
+	pkgruntime.AuditRecord
+	Prev string `json:"prev"`
+	Hash string `json:"hash,omitempty"`
+}
+
+// FileLog appends audit records to a JSONL file. Each append locks the
+// file and reads its last line again, so runs and processes sharing one
+// file extend a single chain.
+type FileLog struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (l *FileLog) Record(_ context.Context, record pkgruntime.AuditRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(l.Path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.Path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := filelock.LockFile(f); err != nil {
+		return err
+	}
+	prev, err := lastHash(f)
+	if err != nil {
+		return err
+	}
+	data, err := seal(line{AuditRecord: record, Prev: prev})
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// seal sets the hash of l and returns its JSON.
+func seal(l line) ([]byte, error) {
+	l.Hash = ""
+	unsealed, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	l.Hash = hashOf(unsealed)
+	return json.Marshal(l)
+}
+
+func hashOf(unsealed []byte) string {
+	sum := sha256.Sum256(unsealed)
+	return hex.EncodeToString(sum[:])
+}
+
+// lastHash returns the hash of the last line of f, or "" if it is empty.
+func lastHash(f *os.File) (string, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	offset := max(info.Size()-tailBytes, 0)
+	tail := make([]byte, info.Size()-offset)
+	if _, err := f.ReadAt(tail, offset); err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	tail = bytes.TrimRight(tail, "\n")
+	if len(tail) == 0 {
+		return "", nil
+	}
+	var last line
+	if err := json.Unmarshal(tail[bytes.LastIndexByte(tail, '\n')+1:], &last); err != nil || last.Hash == "" {
+		return "", fmt.Errorf("audit log %s: last line is not a sealed record", f.Name())
+	}
+	return last.Hash, nil
+}
+
+// Verify checks the chain of the log at path, returning the number of
+// records in it or an error naming the first line that does not follow
+// from the one before it.
+func Verify(path string) (int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	prev, n := "", 0
+	for scanner.Scan() {
+		n++
+		var l line
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			return n - 1, fmt.Errorf("line %d: %w", n, err)
+		}
+		if l.Prev != prev {
+			return n - 1, fmt.Errorf("line %d: chain broken: does not follow line %d", n, n-1)
+		}
+		sealed, err := seal(l)
+		if err != nil {
+			return n - 1, fmt.Errorf("line %d: %w", n, err)
+		}
+		if !bytes.Equal(sealed, scanner.Bytes()) {
+			return n - 1, fmt.Errorf("line %d: hash mismatch: record was altered", n)
+		}
+		prev = l.Hash
+	}
+	return n, scanner.Err()
+}
@@ -0,0 +1,71 @@
+package audit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+)
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "audit", "audit.jsonl")
+	for _, tool := range []string{"core/read", "core/write", "core/bash"} {
+		log := &FileLog{Path: path} // a new process each time continues the chain
+		if err := log.Record(ctx, pkgruntime.AuditRecord{Time: time.Now(), ToolID: tool, Decision: pkgruntime.AuditAuto, Status: "ok"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := Verify(path); err != nil || n != 3 {
+		t.Fatalf("expected 3 intact records, got %d, %v", n, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+
+	tamper := func(content string) error {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := Verify(path)
+		return err
+	}
+	if err := tamper(lines[0] + strings.Replace(lines[1], `"auto"`, `"allowed"`, 1) + lines[2]); err == nil || !strings.Contains(err.Error(), "line 2: hash mismatch") {
+		t.Fatalf("expected an edited record found, got %v", err)
+	}
+	if err := tamper(lines[0] + lines[2]); err == nil || !strings.Contains(err.Error(), "line 2: chain broken") {
+		t.Fatalf("expected a removed record found, got %v", err)
+	}
+	if err := tamper(lines[0] + lines[1]); err != nil {
+		t.Fatalf("a log cut at a line end still verifies, got %v", err)
+	}
+	if n, err := Verify(filepath.Join(t.TempDir(), "missing.jsonl")); err != nil || n != 0 {
+		t.Fatalf("expected an empty log for a missing file, got %d, %v", n, err)
+	}
+}
+
+func TestConcurrentWritersExtendOneChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			log := &FileLog{Path: path} // a log apiece, as separate processes would have
+			if err := log.Record(context.Background(), pkgruntime.AuditRecord{Time: time.Now(), ToolID: "core/read", Decision: pkgruntime.AuditAuto, Status: "ok"}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n, err := Verify(path); err != nil || n != 50 {
+		t.Fatalf("expected 50 chained records, got %d, %v", n, err)
+	}
+}
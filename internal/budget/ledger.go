@@ -0,0 +1,98 @@
+// Package budget persists model spend so daily budgets hold across sessions.
+package budget
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bitop-dev/agent/internal/filelock"
+)
+
+// retention is how long entries are kept; longer than the largest window.
+const retention = 48 * time.Hour
+
+type entry struct {
+	At  time.Time `json:"at"`
+	USD float64   `json:"usd"`
+}
+
+// FileLedger stores spend entries in a small JSON file. Entries older than
+// two days are pruned on every write. Writers lock a sibling ".lock" file,
+// so processes sharing the ledger do not lose each other's spend.
+type FileLedger struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (l *FileLedger) Spent(_ context.Context, since time.Time) (float64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries, err := l.load()
+	if err != nil {
+		return 0, err
+	}
+	var total float64
+	for _, e := range entries {
+		if !e.At.Before(since) {
+			total += e.USD
+		}
+	}
+	return total, nil
+}
+
+func (l *FileLedger) Record(_ context.Context, at time.Time, usd float64) error {
+	if usd <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	unlock, err := filelock.Lock(l.Path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	entries, err := l.load()
+	if err != nil {
+		return err
+	}
+	cutoff := at.Add(-retention)
+	kept := entries[:0]
+	for _, e := range entries {
+		if e.At.After(cutoff) {
+			kept = append(kept, e)
+		}
+	}
+	kept = append(kept, entry{At: at.UTC(), USD: usd})
+	data, err := json.Marshal(map[string]any{"entries": kept})
+	if err != nil {
+		return err
+	}
+	tmp := l.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.Path)
+}
+
+func (l *FileLedger) load() ([]entry, error) {
+	data, err := os.ReadFile(l.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Entries []entry `json:"entries"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc.Entries, nil
+}
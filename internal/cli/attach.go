@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+)
+
+// attachFiles loads paths and returns prompt with the text files inlined and
+// the images and documents to send with it.
+func attachFiles(prompt string, paths []string) (pkgruntime.Prepared, error) {
+	if len(paths) == 0 {
+		return pkgruntime.Prepared{Text: prompt}, nil
+	}
+	attachments := make([]pkgruntime.Attachment, 0, len(paths))
+	for _, path := range paths {
+		attachment, err := pkgruntime.LoadAttachment(path)
+		if err != nil {
+			return pkgruntime.Prepared{}, err
+		}
+		attachments = append(attachments, attachment)
+	}
+	return pkgruntime.PrepareAttachments(prompt, attachments)
+}
+
+// attachChatFiles is attachFiles for the files queued with /attach, which
+// are sent once.
+func attachChatFiles(state *chatState, line string) (pkgruntime.Prepared, error) {
+	paths := state.Attachments
+	state.Attachments = nil
+	return attachFiles(line, paths)
+}
+
+// attachChatCommand handles /attach: with a path it queues the file for the
+// next message, "clear" drops the queue, and no argument lists it.
+func attachChatCommand(state *chatState, args []string) error {
+	path := strings.Join(args, " ")
+	switch path {
+	case "":
+		if len(state.Attachments) == 0 {
+			fmt.Fprintln(os.Stdout, "no attachments; usage: /attach <file>")
+		}
+		for _, p := range state.Attachments {
+			fmt.Fprintln(os.Stdout, p)
+		}
+		return nil
+	case "clear":
+		fmt.Fprintf(os.Stdout, "cleared %d attachment(s)\n", len(state.Attachments))
+		state.Attachments = nil
+		return nil
+	}
+	// Check the file now so a typo is reported before the message is sent.
+	attachment, err := pkgruntime.LoadAttachment(path)
+	if err == nil {
+		_, err = pkgruntime.PrepareAttachments("", []pkgruntime.Attachment{attachment})
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stdout, "cannot attach: %v\n", err)
+		return nil
+	}
+	state.Attachments = append(state.Attachments, path)
+	fmt.Fprintf(os.Stdout, "attached %s (%d bytes); it is sent with your next message\n", path, len(attachment.Data))
+	return nil
+}
@@ -0,0 +1,31 @@
+package cli
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/bitop-dev/agent/internal/audit"
+	"github.com/bitop-dev/agent/internal/service"
+)
+
+// runAudit handles `agent audit verify [file]`, which checks that no line
+// of the tool audit log was altered, removed, or reordered.
+func runAudit(app service.App, args []string) error {
+	if len(args) == 0 || args[0] != "verify" || len(args) > 2 {
+		return errors.New("usage: agent audit verify [file]")
+	}
+	path := cmp.Or(app.Config.Audit.Path, app.Paths.AuditFile)
+	if len(args) == 2 {
+		path = args[1]
+	} else if !filepath.IsAbs(path) {
+		path = filepath.Join(app.Paths.ConfigDir, path)
+	}
+	n, err := audit.Verify(path)
+	if err != nil {
+		return fmt.Errorf("%s: %d records verified, then %w", path, n, err)
+	}
+	fmt.Printf("%s: %d records, chain intact\n", path, n)
+	return nil
+}
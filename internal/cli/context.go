@@ -0,0 +1,65 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bitop-dev/agent/internal/service"
+	"github.com/bitop-dev/agent/pkg/config"
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+)
+
+// contextChatCommand handles `/context`: what the next turn would send,
+// in estimated tokens, and how close the transcript is to compaction.
+func contextChatCommand(ctx context.Context, app service.App, state *chatState) error {
+	manifest, tools := state.Manifest, state.Tools
+	model := config.ResolveModel(app.Config, manifest.Spec.Provider.Default, manifest.Metadata.Name, manifest.Spec.Provider.Model, state.Model)
+	if reload, ok := state.Reloader.Current(); ok {
+		manifest, tools, model = reload.Profile, reload.Tools, reload.Model
+	}
+	systemPrompt, err := buildSystemPrompt(ctx, app, runInput{Manifest: manifest, ProfilePath: state.ProfilePath, Tools: tools, CWD: state.CWD, Workspace: state.Workspace})
+	if err != nil {
+		return err
+	}
+	printContextReport(os.Stdout, pkgruntime.ExplainContext(pkgruntime.RunRequest{
+		SystemPrompt:  systemPrompt,
+		Profile:       manifest,
+		Tools:         tools,
+		Toggles:       state.Toggles,
+		Transcript:    state.Transcript,
+		ModelOverride: model,
+	}))
+	return nil
+}
+
+func printContextReport(w io.Writer, r pkgruntime.ContextReport) {
+	fmt.Fprintf(w, "context for %s, in estimated tokens:\n", r.Model)
+	row := func(label string, tokens int) { fmt.Fprintf(w, "  %-16s%8d\n", label, tokens) }
+	row("system prompt", r.System)
+	row(fmt.Sprintf("tools (%d)", r.ToolCount), r.Tools)
+	row(fmt.Sprintf("messages (%d)", len(r.Messages)), r.Transcript)
+	row("total", r.Total)
+	if r.CachedPrefix > 0 {
+		row("cacheable", r.CachedPrefix)
+	}
+	for i, msg := range r.Messages {
+		mark := " "
+		switch {
+		case msg.Summary:
+			mark = "S"
+		case msg.Pinned:
+			mark = "*"
+		}
+		fmt.Fprintf(w, "%s%3d %-9s %6d  %s\n", mark, i+1, msg.Role, msg.Tokens, compactText(msg.Content, 60))
+	}
+	switch {
+	case !r.Compaction:
+		fmt.Fprintf(w, "compaction is off for this profile; messages would compact past %d tokens\n", r.CompactAt)
+	case r.Headroom < 0:
+		fmt.Fprintf(w, "messages are %d tokens past the compaction point of %d; the next turn compacts them\n", -r.Headroom, r.CompactAt)
+	default:
+		fmt.Fprintf(w, "compaction at %d message tokens: %d to go\n", r.CompactAt, r.Headroom)
+	}
+}
@@ -0,0 +1,156 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bitop-dev/agent/internal/service"
+	"github.com/bitop-dev/agent/internal/transcript"
+	"github.com/bitop-dev/agent/pkg/provider"
+)
+
+// debugPreview bounds each message line unless --full is given.
+const debugPreview = 160
+
+// runDebug handles `agent debug <session-id> [--step N] [--full]`: it shows
+// each provider call of a recorded session with the exact messages it was
+// sent and what came back. On a terminal it pauses between steps.
+func runDebug(ctx context.Context, app service.App, args []string) error {
+	var sessionID string
+	step, full := 0, false
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--step":
+			if i+1 >= len(args) {
+				return errors.New("--step requires a value")
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n < 1 {
+				return errors.New("--step must be a positive integer")
+			}
+			step = n
+			i++
+		case "--full":
+			full = true
+		default:
+			if sessionID != "" || strings.HasPrefix(args[i], "--") {
+				return fmt.Errorf("unknown debug argument %q", args[i])
+			}
+			sessionID = args[i]
+		}
+	}
+	if sessionID == "" {
+		return errors.New("debug requires a session id")
+	}
+	if app.Sessions == nil {
+		return errors.New("session store is not configured")
+	}
+	loaded, err := app.Sessions.Load(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	steps := transcript.Steps(loaded.Entries)
+	if len(steps) == 0 {
+		return fmt.Errorf("session %s has no recorded provider calls (it predates context recording)", sessionID)
+	}
+	if step > len(steps) {
+		return fmt.Errorf("session %s has %d steps", sessionID, len(steps))
+	}
+	if step > 0 {
+		printDebugStep(plainWriter(os.Stdout), steps, step-1, full)
+		return nil
+	}
+	if !stdinIsTerminal() {
+		for i := range steps {
+			printDebugStep(plainWriter(os.Stdout), steps, i, full)
+		}
+		return nil
+	}
+	out := plainWriter(os.Stdout)
+	input := bufio.NewScanner(os.Stdin)
+	for i := 0; i < len(steps); {
+		printDebugStep(out, steps, i, full)
+		fmt.Fprintf(out, "\nstep %d/%d — [enter] next, p previous, <n> jump, q quit: ", i+1, len(steps))
+		if !input.Scan() {
+			return input.Err()
+		}
+		switch answer := strings.TrimSpace(input.Text()); answer {
+		case "":
+			i++
+		case "q":
+			return nil
+		case "p":
+			i = max(i-1, 0)
+		default:
+			if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(steps) {
+				i = n - 1
+			}
+		}
+	}
+	return nil
+}
+
+func printDebugStep(w io.Writer, steps []transcript.Step, i int, full bool) {
+	step := steps[i]
+	tc := step.Context
+	fmt.Fprintf(w, "── step %d/%d · turn %d · %s · %s\n", i+1, len(steps), tc.Turn, tc.Model, step.At.Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(w, "system (%d chars): %s\n", len(step.System), debugText(step.System, full))
+	fmt.Fprintf(w, "tools (%d): %s\n", len(tc.Tools), strings.Join(tc.Tools, ", "))
+	fmt.Fprintf(w, "context (%d messages", len(step.Messages))
+	if len(tc.Prefix) > 0 {
+		fmt.Fprintf(w, ", starting with %d compaction summary", len(tc.Prefix))
+	}
+	if len(tc.Suffix) > 0 {
+		fmt.Fprintf(w, ", ending with %d unsaved nudge", len(tc.Suffix))
+	}
+	fmt.Fprintln(w, "):")
+	for n, msg := range step.Messages {
+		fmt.Fprintf(w, "  [%d] %s\n", n+1, debugMessage(msg, full))
+	}
+	fmt.Fprintln(w, "response:")
+	if len(step.Response) == 0 {
+		fmt.Fprintln(w, "  (nothing recorded)")
+	}
+	for _, msg := range step.Response {
+		fmt.Fprintf(w, "  %s\n", debugMessage(msg, full))
+	}
+}
+
+func debugMessage(msg provider.Message, full bool) string {
+	label := msg.Role
+	if msg.Role == "tool" {
+		label = "tool " + msg.ToolName
+	}
+	line := label + ": " + debugText(msg.Content, full)
+	for _, call := range msg.ToolCalls {
+		args, _ := json.Marshal(call.Arguments)
+		line += fmt.Sprintf("\n      → %s %s", call.ToolID, debugText(string(args), full))
+	}
+	for _, raw := range msg.Raw {
+		line += fmt.Sprintf("\n      + %s %s block", raw.Provider, raw.Type)
+	}
+	return line
+}
+
+func debugText(text string, full bool) string {
+	if full {
+		return text
+	}
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) > debugPreview {
+		return text[:debugPreview-3] + "..."
+	}
+	return text
+}
+
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/bitop-dev/agent/internal/service"
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+)
+
+// pinChatCommand handles /pin and /unpin: with a message number it pins or
+// unpins that message of the chat, and with none it lists the messages by
+// number, marking the pinned ones.
+func pinChatCommand(ctx context.Context, app service.App, state *chatState, args []string, pinned bool) error {
+	if len(args) == 0 {
+		if len(state.Transcript) == 0 {
+			fmt.Fprintln(os.Stdout, "no messages yet")
+			return nil
+		}
+		for i, msg := range state.Transcript {
+			mark := " "
+			if pkgruntime.IsPinned(msg) {
+				mark = "*"
+			}
+			fmt.Fprintf(os.Stdout, "%s%3d %-9s %s\n", mark, i+1, msg.Role, compactText(msg.Content, 70))
+		}
+		return nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || len(args) != 1 {
+		fmt.Fprintln(os.Stdout, "usage: /pin [message number], /unpin <message number>")
+		return nil
+	}
+	sessionID := state.SessionID
+	if state.NoSession || app.Sessions == nil {
+		sessionID = ""
+	}
+	if err := pkgruntime.PinMessage(ctx, app.Sessions, sessionID, state.Transcript, n-1, pinned); err != nil {
+		fmt.Fprintf(os.Stdout, "cannot pin: %v\n", err)
+		return nil
+	}
+	if pinned {
+		fmt.Fprintf(os.Stdout, "pinned message %d; compaction keeps it verbatim\n", n)
+	} else {
+		fmt.Fprintf(os.Stdout, "unpinned message %d\n", n)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/events"
+	"github.com/bitop-dev/agent/pkg/session"
+)
+
+// plainOutput is set by --plain, AGENT_PLAIN=1, or TERM=dumb. Output is then
+// line-oriented for screen readers, dumb terminals, and CI logs: every event
+// is one labeled line, assistant text is written a full line at a time, and
+// escape sequences, carriage returns, and box-drawing characters are
+// replaced or removed.
+var plainOutput bool
+
+// takePlainFlag removes --plain from args and reports whether plain output
+// is wanted.
+func takePlainFlag(args []string) ([]string, bool) {
+	plain := os.Getenv("AGENT_PLAIN") == "1" || os.Getenv("TERM") == "dumb"
+	out := args[:0:0]
+	for _, arg := range args {
+		if arg == "--plain" || arg == "-plain" {
+			plain = true
+			continue
+		}
+		out = append(out, arg)
+	}
+	return out, plain
+}
+
+// newStreamSink prints run events to w, in plain form when plainOutput is set.
+func newStreamSink(w *os.File) events.Sink {
+	if plainOutput {
+		return &plainSink{w: plainWriter(w)}
+	}
+	return streamSink{Writer: w, argsLine: new(bool)}
+}
+
+// plainSink is streamSink for plain output.
+type plainSink struct {
+	mu      sync.Mutex
+	w       io.Writer
+	pending strings.Builder // assistant text not yet ended by a newline
+	talking bool            // the "Assistant:" label has been written
+}
+
+func (s *plainSink) Publish(_ context.Context, event events.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if event.Type == events.TypeAssistantDelta {
+		if !s.talking {
+			s.talking = true
+			if _, err := fmt.Fprintln(s.w, "Assistant:"); err != nil {
+				return err
+			}
+		}
+		s.pending.WriteString(event.Message)
+		text := s.pending.String()
+		end := strings.LastIndex(text, "\n")
+		if end < 0 {
+			return nil
+		}
+		s.pending.Reset()
+		s.pending.WriteString(text[end+1:])
+		_, err := fmt.Fprintln(s.w, text[:end])
+		return err
+	}
+	var line string
+	switch event.Type {
+	case events.TypeRunStarted:
+		line = "Run started: " + event.Time.Format(time.RFC3339)
+	case events.TypeToolRequested:
+		line = "Tool request: " + event.Message
+	case events.TypeToolFinished:
+		line = "Tool result: " + summarizeToolEvent(event)
+	case events.TypeToolLog:
+		line = "Tool log: " + event.Message
+	case events.TypeHostedTool:
+		line = "Hosted tool: " + event.Message
+	case events.TypePolicyDecision:
+		line = "Policy: " + event.Message
+	case events.TypeApprovalRequest:
+		line = "Approval needed: " + event.Message
+	case events.TypeError:
+		line = "Error: " + event.Message
+	case events.TypeEmptyResponse:
+		line = "Empty response: " + event.Message
+	case events.TypeStreamResumed:
+		line = "Stream resumed: " + event.Message
+	case events.TypeStreamStalled:
+		line = "Stream stalled: " + event.Message
+	case events.TypeBudgetWarning, events.TypeBudgetExceeded:
+		line = "Budget: " + event.Message
+	case events.TypeDeadlineExceeded:
+		line = "Time limit: " + event.Message
+	case events.TypeSessionTitled:
+		line = "Session: " + event.Message
+	case events.TypeModelDeprecated, events.TypeModelFallback, events.TypeProviderSwitched:
+		line = "Model: " + event.Message
+	case events.TypeToolsetChanged:
+		line = "Tools: " + event.Message
+	case events.TypeConfigReloaded:
+		line = "Config: " + event.Message
+	case events.TypeGuardrailTriggered:
+		line = "Guardrail: " + event.Message
+	case events.TypeSecurityWarning:
+		line = "Security warning: " + event.Message
+	case events.TypeThinkingAdjusted:
+		line = "Thinking: " + event.Message
+	case events.TypeSteering:
+		line = "Steering: " + event.Message
+	case events.TypeFollowUp:
+		line = "Follow-up: " + event.Message
+	case events.TypeFollowUpWaiting:
+		line = "Follow-up: " + event.Message
+	case events.TypeWorkflowStep:
+		line = "Workflow: " + event.Message
+	case events.TypeTurnFinished:
+		// Only with workspace.watch in the profile.
+		data, _ := event.Data.(map[string]any)
+		var lines []string
+		if changes, ok := data["filesChanged"].([]session.FileChange); ok {
+			for _, c := range changes {
+				lines = append(lines, "File "+c.Change+": "+c.Path)
+			}
+		}
+		if msg, ok := data["watchError"].(string); ok {
+			lines = append(lines, "Watch error: "+msg)
+		}
+		line = strings.Join(lines, "\n")
+	case events.TypeRunFinished:
+	default:
+		// Partial tool arguments are left out: streamSink redraws them in
+		// place on one line, which plain output does not do.
+		return nil
+	}
+	if err := s.endText(); err != nil {
+		return err
+	}
+	if line == "" {
+		return nil
+	}
+	_, err := fmt.Fprintln(s.w, line)
+	return err
+}
+
+// endText writes the rest of the assistant's text before another event.
+func (s *plainSink) endText() error {
+	if !s.talking {
+		return nil
+	}
+	s.talking = false
+	text := s.pending.String()
+	s.pending.Reset()
+	if text == "" {
+		return nil
+	}
+	_, err := fmt.Fprintln(s.w, text)
+	return err
+}
+
+// plainWriter returns w, or in plain mode a writer that strips terminal
+// escape sequences and carriage returns and spells box-drawing and other
+// decorative characters in ASCII.
+func plainWriter(w io.Writer) io.Writer {
+	if !plainOutput {
+		return w
+	}
+	return asciiWriter{w}
+}
+
+type asciiWriter struct{ w io.Writer }
+
+var (
+	escapeSequence = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]|\x1b\][^\x07]*\x07`)
+	decorations    = strings.NewReplacer(
+		"\r\n", "\n", "\r", "",
+		"─", "-", "━", "-", "│", "|", "┃", "|",
+		"┌", "+", "┐", "+", "└", "+", "┘", "+", "├", "+", "┤", "+", "┬", "+", "┴", "+", "┼", "+",
+		"→", "->", "←", "<-", "·", "-", "•", "*", "—", "-", "…", "...", "✓", "ok", "✗", "x",
+	)
+)
+
+func (a asciiWriter) Write(p []byte) (int, error) {
+	text := decorations.Replace(escapeSequence.ReplaceAllString(string(p), ""))
+	if _, err := io.WriteString(a.w, text); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,191 @@
+package cli
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitop-dev/agent/internal/models"
+	"github.com/bitop-dev/agent/internal/service"
+	"github.com/bitop-dev/agent/pkg/session"
+)
+
+// runReport handles `agent report`: the usage and cost recorded in the
+// stored sessions, grouped by day, month, project, profile, or model. It
+// covers every directory and the current month unless told otherwise.
+func runReport(ctx context.Context, app service.App, args []string) error {
+	if app.Sessions == nil {
+		return errors.New("session store is not configured")
+	}
+	now := time.Now()
+	query := session.AggregateQuery{
+		Since: time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local),
+		Price: models.CostWithCache,
+	}
+	format, cwd := "table", ""
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--all":
+			query.Since = time.Time{}
+		case "--since", "--until", "--by", "--format", "--cwd":
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s requires a value", args[i])
+			}
+			value := args[i+1]
+			i++
+			switch args[i-1] {
+			case "--since", "--until":
+				at, err := parseDateArg(value)
+				if err != nil {
+					return fmt.Errorf("%s: %w", args[i-1], err)
+				}
+				if args[i-1] == "--since" {
+					query.Since = at
+				} else {
+					query.Until = at
+				}
+			case "--by":
+				switch value {
+				case session.GroupByDay, session.GroupByMonth, session.GroupByProject, session.GroupByProfile, session.GroupByModel:
+					query.GroupBy = value
+				default:
+					return fmt.Errorf("--by must be day, month, project, profile, or model, got %q", value)
+				}
+			case "--format":
+				if value != "table" && value != "json" && value != "csv" {
+					return fmt.Errorf("--format must be table, json, or csv, got %q", value)
+				}
+				format = value
+			case "--cwd":
+				abs, err := filepath.Abs(value)
+				if err != nil {
+					return err
+				}
+				cwd = abs
+			}
+		default:
+			return fmt.Errorf("unknown report flag %q", args[i])
+		}
+	}
+	sessions, err := loadSessionsSince(ctx, app.Sessions, cwd, query.Since)
+	if err != nil {
+		return err
+	}
+	report := session.Aggregate(sessions, query)
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case "csv":
+		return writeReportCSV(os.Stdout, report)
+	}
+	printReport(report)
+	return nil
+}
+
+// loadSessionsSince loads the sessions in cwd ("" for every directory)
+// updated at or after since, which are the only ones with turns since.
+func loadSessionsSince(ctx context.Context, store session.Store, cwd string, since time.Time) ([]session.Session, error) {
+	count, err := store.Count(ctx, cwd)
+	if err != nil || count == 0 {
+		return nil, err
+	}
+	metas, err := store.List(ctx, cwd, count)
+	if err != nil {
+		return nil, err
+	}
+	var sessions []session.Session
+	for _, meta := range metas {
+		if !since.IsZero() && meta.UpdatedAt.Before(since) {
+			continue
+		}
+		loaded, err := store.Load(ctx, meta.ID)
+		if err != nil {
+			return nil, fmt.Errorf("load session %s: %w", meta.ID, err)
+		}
+		sessions = append(sessions, loaded)
+	}
+	return sessions, nil
+}
+
+func printReport(report session.Report) {
+	if report.Total.Turns == 0 && len(report.Total.Tools) == 0 {
+		fmt.Println("no usage recorded in this period")
+		return
+	}
+	w := newTabWriter()
+	fmt.Fprintf(w, "%s\tSESSIONS\tTURNS\tINPUT\tOUTPUT\tCOST\n", strings.ToUpper(report.GroupBy))
+	for _, row := range append(report.Groups, report.Total) {
+		key := row.Key
+		if key == "" {
+			key = "-"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\n", key, row.Sessions, row.Turns, row.InputTokens, row.OutputTokens, reportCost(row))
+	}
+	w.Flush()
+	if len(report.Total.Models) > 0 {
+		fmt.Printf("\nmodels: %s\n", reportCounts(report.Total.Models))
+	}
+	if len(report.Total.Tools) > 0 {
+		fmt.Printf("tools: %s\n", reportCounts(report.Total.Tools))
+	}
+}
+
+func reportCost(row session.UsageTotals) string {
+	cost := fmt.Sprintf("$%.4f", row.CostUSD)
+	if row.UnpricedTurns > 0 {
+		cost += fmt.Sprintf(" (+%d unpriced turns)", row.UnpricedTurns)
+	}
+	return cost
+}
+
+// reportCounts formats counts as "name=n" pairs, the largest first.
+func reportCounts(counts map[string]int) string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%s=%d", name, counts[name])
+	}
+	return strings.Join(pairs, " ")
+}
+
+func writeReportCSV(out io.Writer, report session.Report) error {
+	w := csv.NewWriter(out)
+	_ = w.Write([]string{report.GroupBy, "sessions", "turns", "input_tokens", "output_tokens", "reasoning_tokens", "cache_read_tokens", "cost_usd", "unpriced_turns", "models", "tools"})
+	for _, row := range append(report.Groups, report.Total) {
+		_ = w.Write([]string{
+			row.Key,
+			strconv.Itoa(row.Sessions),
+			strconv.Itoa(row.Turns),
+			strconv.Itoa(row.InputTokens),
+			strconv.Itoa(row.OutputTokens),
+			strconv.Itoa(row.ReasoningTokens),
+			strconv.Itoa(row.CacheReadTokens),
+			strconv.FormatFloat(row.CostUSD, 'f', 6, 64),
+			strconv.Itoa(row.UnpricedTurns),
+			reportCounts(row.Models),
+			reportCounts(row.Tools),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -3,9 +3,9 @@ package cli
 import (
 	"bufio"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -15,12 +15,18 @@ import (
 	"text/tabwriter"
 	"time"
 
+	internalapproval "github.com/bitop-dev/agent/internal/approval"
 	internalmcp "github.com/bitop-dev/agent/internal/mcp"
+	"github.com/bitop-dev/agent/internal/models"
 	internalplugin "github.com/bitop-dev/agent/internal/plugin"
-	"github.com/bitop-dev/agent/internal/registry"
+	"github.com/bitop-dev/agent/internal/queue"
+	"github.com/bitop-dev/agent/internal/runstatus"
 	"github.com/bitop-dev/agent/internal/service"
+	"github.com/bitop-dev/agent/internal/sysprompt"
+	"github.com/bitop-dev/agent/internal/transcript"
 	"github.com/bitop-dev/agent/pkg/config"
 	"github.com/bitop-dev/agent/pkg/events"
+	"github.com/bitop-dev/agent/pkg/memory"
 	pkgplugin "github.com/bitop-dev/agent/pkg/plugin"
 	"github.com/bitop-dev/agent/pkg/profile"
 	"github.com/bitop-dev/agent/pkg/provider"
@@ -35,17 +41,51 @@ func Run(ctx context.Context, args []string) error {
 	if err != nil {
 		return err
 	}
-	app, err := service.Bootstrap(cwd)
+	args, environment, err := takeEnvFlag(args)
 	if err != nil {
 		return err
 	}
+	app, err := service.Bootstrap(cwd, environment)
+	if err != nil {
+		return err
+	}
+	// Stop MCP plugin servers gracefully instead of leaving them to die
+	// with the process.
+	defer app.MCPManager.Close()
+	args, plainOutput = takePlainFlag(args)
 	if len(args) == 0 {
 		printUsage()
 		return nil
 	}
+	autoSessionsGC(ctx, app)
 	return dispatch(ctx, app, args)
 }
 
+// takeEnvFlag removes --env <name> from args and returns the config
+// environment it selects, AGENT_ENV by default. Arguments after "--" are
+// left alone, for commands that pass them on.
+func takeEnvFlag(args []string) ([]string, string, error) {
+	environment := os.Getenv("AGENT_ENV")
+	out := args[:0:0]
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--":
+			return append(out, args[i:]...), environment, nil
+		case arg == "--env":
+			if i+1 >= len(args) {
+				return nil, "", errors.New("--env requires a value")
+			}
+			i++
+			environment = args[i]
+		case strings.HasPrefix(arg, "--env="):
+			environment = strings.TrimPrefix(arg, "--env=")
+		default:
+			out = append(out, arg)
+		}
+	}
+	return out, environment, nil
+}
+
 func dispatch(ctx context.Context, app service.App, args []string) error {
 	switch args[0] {
 	case "help", "--help", "-h":
@@ -65,10 +105,24 @@ func dispatch(ctx context.Context, app service.App, args []string) error {
 		return runPlugins(ctx, app, args[1:])
 	case "sessions":
 		return runSessions(ctx, app, args[1:])
+	case "workflow":
+		return runWorkflow(ctx, app, args[1:])
+	case "skills":
+		return runSkills(app, args[1:])
+	case "approvals":
+		return runApprovals(app, args[1:])
 	case "config":
 		return runConfig(app, args[1:])
+	case "queue":
+		return runQueue(ctx, args[1:])
 	case "doctor":
-		return runDoctor(ctx, app)
+		return runDoctor(ctx, app, args[1:])
+	case "debug":
+		return runDebug(ctx, app, args[1:])
+	case "report":
+		return runReport(ctx, app, args[1:])
+	case "audit":
+		return runAudit(app, args[1:])
 	default:
 		return fmt.Errorf("unknown command %q", args[0])
 	}
@@ -149,11 +203,15 @@ func serveMCPStdio(ctx context.Context, app service.App, profileRef string) erro
 
 // serveResult holds the output and usage from a served task.
 type serveResult struct {
-	Output       string
-	Model        string
-	InputTokens  int
-	OutputTokens int
-	ToolSteps    []pkgruntime.ToolStep
+	Output           string
+	Model            string
+	InputTokens      int
+	OutputTokens     int
+	ReasoningTokens  int
+	CacheReadTokens  int
+	CacheWriteTokens int
+	CostUSD          float64
+	ToolSteps        []pkgruntime.ToolStep
 }
 
 // runTaskForServe executes a task using a named profile. Shared by MCP and HTTP modes.
@@ -176,6 +234,8 @@ func runTaskForServe(ctx context.Context, app service.App, profileRef string, ar
 	}
 	workspaceRef, _ := workspace.Resolve(app.Paths.CWD)
 	taskID, _ := arguments["_taskId"].(string)
+	history, _ := arguments["_history"].([]provider.Message)
+	sink, _ := arguments["_events"].(events.Sink)
 	result, err := executeServeRun(ctx, app, runInput{
 		Prompt:        task,
 		Manifest:      m,
@@ -187,16 +247,22 @@ func runTaskForServe(ctx context.Context, app service.App, profileRef string, ar
 		CWD:           app.Paths.CWD,
 		ModelOverride: config.ResolveModel(app.Config, m.Spec.Provider.Default, m.Metadata.Name, m.Spec.Provider.Model, ""),
 		TaskID:        taskID,
+		Transcript:    history,
+		Events:        sink,
 	})
 	if err != nil {
 		return serveResult{}, err
 	}
 	return serveResult{
-		Output:       result.Output,
-		Model:        result.Model,
-		InputTokens:  result.InputTokens,
-		OutputTokens: result.OutputTokens,
-		ToolSteps:    result.ToolSteps,
+		Output:           result.Output,
+		Model:            result.Model,
+		InputTokens:      result.InputTokens,
+		OutputTokens:     result.OutputTokens,
+		ReasoningTokens:  result.ReasoningTokens,
+		CacheReadTokens:  result.CacheReadTokens,
+		CacheWriteTokens: result.CacheWriteTokens,
+		CostUSD:          result.CostUSD,
+		ToolSteps:        result.ToolSteps,
 	}, nil
 }
 
@@ -205,9 +271,76 @@ func runCommand(ctx context.Context, app service.App, args []string) error {
 	approvalMode := ""
 	modelFlag := ""
 	noSession := false
+	steeringURL, followUpsURL := "", ""
+	schedule := &queue.Schedule{Dir: app.Paths.CWD}
+	runManifest := os.Getenv("AGENT_RUN_MANIFEST")
+	labels := map[string]string{}
+	var toolChoice provider.ToolChoice
+	var prefill string
+	var artifacts, attachments []string
 	var promptParts []string
 	for i := 0; i < len(args); i++ {
 		switch args[i] {
+		case "--attach":
+			if i+1 >= len(args) {
+				return errors.New("--attach requires a value")
+			}
+			attachments = append(attachments, args[i+1])
+			i++
+		case "--manifest":
+			if i+1 >= len(args) {
+				return errors.New("--manifest requires a value")
+			}
+			runManifest = args[i+1]
+			i++
+		case "--label":
+			if i+1 >= len(args) {
+				return errors.New("--label requires a value")
+			}
+			key, value, ok := strings.Cut(args[i+1], "=")
+			if !ok || key == "" {
+				return fmt.Errorf("--label expects key=value, got %q", args[i+1])
+			}
+			labels[key] = value
+			i++
+		case "--tool-choice":
+			if i+1 >= len(args) {
+				return errors.New("--tool-choice requires a value")
+			}
+			toolChoice = provider.ToolChoice(args[i+1])
+			i++
+		case "--prefill":
+			if i+1 >= len(args) {
+				return errors.New("--prefill requires a value")
+			}
+			prefill = args[i+1]
+			i++
+		case "--artifact":
+			if i+1 >= len(args) {
+				return errors.New("--artifact requires a value")
+			}
+			artifacts = append(artifacts, args[i+1])
+			i++
+		case "--steering":
+			if i+1 >= len(args) {
+				return errors.New("--steering requires a value")
+			}
+			steeringURL = args[i+1]
+			i++
+		case "--follow-ups":
+			if i+1 >= len(args) {
+				return errors.New("--follow-ups requires a value")
+			}
+			followUpsURL = args[i+1]
+			i++
+		case "--follow-up":
+			if i+1 >= len(args) {
+				return errors.New("--follow-up requires a value")
+			}
+			if _, err := schedule.AddSpec(args[i+1]); err != nil {
+				return fmt.Errorf("--follow-up: %w", err)
+			}
+			i++
 		case "--profile":
 			if i+1 >= len(args) {
 				return errors.New("--profile requires a value")
@@ -238,7 +371,10 @@ func runCommand(ctx context.Context, app service.App, args []string) error {
 	if len(promptParts) == 0 {
 		return errors.New("run requires a prompt")
 	}
-	prompt := strings.Join(promptParts, " ")
+	prepared, err := attachFiles(strings.Join(promptParts, " "), attachments)
+	if err != nil {
+		return err
+	}
 	manifest, path, err := app.Profiles.Load(ctx, profileRef)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
@@ -258,8 +394,38 @@ func runCommand(ctx context.Context, app service.App, args []string) error {
 	if err != nil {
 		return err
 	}
+	steering, err := openQueue(steeringURL)
+	if err != nil {
+		return err
+	}
+	followUps, err := openQueue(followUpsURL)
+	if err != nil {
+		return err
+	}
+	if steering != nil {
+		defer steering.Close()
+	}
+	if followUps != nil {
+		defer followUps.Close()
+	}
+	runFollowUps := pkgruntime.MessageQueue(nil)
+	if followUps != nil {
+		runFollowUps = followUps
+	}
+	if len(schedule.Waiting()) > 0 {
+		schedule.Queue = runFollowUps
+		runFollowUps = schedule
+	}
+	started := time.Now()
 	result, err := executeRun(ctx, app, runInput{
-		Prompt:        prompt,
+		Steering:      steering,
+		FollowUps:     runFollowUps,
+		Prompt:        prepared.Text,
+		Images:        prepared.Images,
+		Documents:     prepared.Documents,
+		Labels:        labels,
+		ToolChoice:    toolChoice,
+		Prefill:       prefill,
 		Manifest:      manifest,
 		ProfilePath:   path,
 		ProviderImpl:  providerImpl,
@@ -270,6 +436,13 @@ func runCommand(ctx context.Context, app service.App, args []string) error {
 		CWD:           app.Paths.CWD,
 		ModelOverride: config.ResolveModel(app.Config, manifest.Spec.Provider.Default, manifest.Metadata.Name, manifest.Spec.Provider.Model, modelFlag),
 	})
+	if runManifest != "" {
+		summary := pkgruntime.NewRunManifest(manifest.Metadata.Name, labels, started, result, err)
+		summary.Artifacts = collectArtifacts(app.Paths.CWD, artifacts)
+		if writeErr := writeRunManifest(runManifest, summary); writeErr != nil && err == nil {
+			return writeErr
+		}
+	}
 	if err != nil {
 		return err
 	}
@@ -281,6 +454,7 @@ func chatCommand(ctx context.Context, app service.App, args []string) error {
 	approvalMode := ""
 	modelFlag := ""
 	noSession := false
+	preflight := true
 	sessionID := ""
 	for i := 0; i < len(args); i++ {
 		switch args[i] {
@@ -290,6 +464,8 @@ func chatCommand(ctx context.Context, app service.App, args []string) error {
 			}
 			profileRef = args[i+1]
 			i++
+		case "--no-preflight":
+			preflight = false
 		case "--approval":
 			if i+1 >= len(args) {
 				return errors.New("--approval requires a value")
@@ -314,19 +490,37 @@ func chatCommand(ctx context.Context, app service.App, args []string) error {
 			return fmt.Errorf("unknown chat argument %q", args[i])
 		}
 	}
-	// Store model flag for use in chat loop
-	_ = modelFlag // passed via initializeChatState below
-
 	state, err := initializeChatState(ctx, app, profileRef, sessionID, approvalMode, noSession)
 	if err != nil {
 		return err
 	}
+	if modelFlag != "" {
+		state.Model = modelFlag
+	}
+	if preflight {
+		// Report a bad key, model, or base URL now rather than when the
+		// first prompt fails.
+		model := preflightModel(app, state.Manifest, state.Model)
+		if err := provider.Preflight(ctx, state.ProviderImpl, model); preflightUnverified(err) {
+			fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+		} else if err != nil {
+			return fmt.Errorf("%w (pass --no-preflight to start anyway)", err)
+		}
+	}
+	state.Reloader = watchChatConfig(ctx, app, state)
 
 	fmt.Fprintf(os.Stdout, "Chat started with profile %s\n", state.Manifest.Metadata.Name)
 	if state.SessionID != "" && !state.NoSession {
 		fmt.Fprintf(os.Stdout, "Session: %s\n", state.SessionID)
 	}
 	fmt.Fprintln(os.Stdout, "Type /help for commands. Type /quit to exit.")
+	// The tools keep their per-session state, such as a persistent shell,
+	// from one prompt to the next until the chat ends.
+	defer func() {
+		if state.SessionID != "" {
+			tool.CloseSession(state.Tools, state.SessionID)
+		}
+	}()
 
 	scanner := bufio.NewScanner(os.Stdin)
 	for {
@@ -343,7 +537,7 @@ func chatCommand(ctx context.Context, app service.App, args []string) error {
 			continue
 		}
 		if strings.HasPrefix(line, "/") {
-			done, err := handleChatCommand(state, line)
+			done, err := handleChatCommand(ctx, app, state, line)
 			if err != nil {
 				return err
 			}
@@ -352,19 +546,35 @@ func chatCommand(ctx context.Context, app service.App, args []string) error {
 			}
 			continue
 		}
+		modelOverride := config.ResolveModel(app.Config, state.Manifest.Spec.Provider.Default, state.Manifest.Metadata.Name, state.Manifest.Spec.Provider.Model, state.Model)
+		if reload, ok := state.Reloader.Current(); ok {
+			// Continue from the configuration reloaded during an earlier prompt.
+			state.Manifest, state.Tools, modelOverride = reload.Profile, reload.Tools, reload.Model
+		}
+		prepared, err := attachChatFiles(state, line)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			continue
+		}
 		result, err := executeRun(ctx, app, runInput{
-			Prompt:        line,
-			Manifest:      state.Manifest,
-			ProfilePath:   state.ProfilePath,
-			ProviderImpl:  state.ProviderImpl,
-			Tools:         state.Tools,
-			Workspace:     state.Workspace,
-			ApprovalMode:  state.ApprovalMode,
-			SessionID:     state.SessionID,
-			Transcript:    state.Transcript,
-			NoSession:     state.NoSession,
-			CWD:           state.CWD,
-			ModelOverride: config.ResolveModel(app.Config, state.Manifest.Spec.Provider.Default, state.Manifest.Metadata.Name, state.Manifest.Spec.Provider.Model, modelFlag),
+			Prompt:           prepared.Text,
+			Images:           prepared.Images,
+			Documents:        prepared.Documents,
+			Manifest:         state.Manifest,
+			ProfilePath:      state.ProfilePath,
+			ProviderImpl:     state.ProviderImpl,
+			Tools:            state.Tools,
+			Workspace:        state.Workspace,
+			ApprovalMode:     state.ApprovalMode,
+			SessionID:        state.SessionID,
+			Transcript:       state.Transcript,
+			NoSession:        state.NoSession,
+			CWD:              state.CWD,
+			ModelOverride:    modelOverride,
+			Toggles:          state.Toggles,
+			ToolCache:        state.ToolCache,
+			Reloader:         state.Reloader,
+			KeepToolSessions: true,
 		})
 		if err != nil {
 			return err
@@ -374,6 +584,7 @@ func chatCommand(ctx context.Context, app service.App, args []string) error {
 		}
 		state.SessionID = result.SessionID
 		state.Transcript = result.Transcript
+		printArtifacts(result.Artifacts)
 		fmt.Fprintln(os.Stdout)
 	}
 }
@@ -444,8 +655,10 @@ func resumeCommand(ctx context.Context, app service.App, args []string) error {
 		Workspace:    workspaceRef,
 		ApprovalMode: approvalMode,
 		SessionID:    existingSession.ID,
-		Transcript:   transcriptFromEntries(existingSession.Entries),
+		Transcript:   transcript.FromEntries(existingSession.Entries),
 		CWD:          existingSession.CWD,
+		// A handoff may have pinned the session to a specific model.
+		ModelOverride: config.ResolveModel(app.Config, manifest.Spec.Provider.Default, manifest.Metadata.Name, manifest.Spec.Provider.Model, existingSession.Model),
 	})
 	if err != nil {
 		return err
@@ -749,6 +962,21 @@ func runPlugins(ctx context.Context, app service.App, args []string) error {
 			len(manifest.Spec.Contributes.ProfileTemplates),
 			len(manifest.Spec.Contributes.Policies),
 		)
+		// A malformed descriptor is reported in its place, after which the
+		// rest are still listed.
+		failed := 0
+		for _, contribution := range manifest.Spec.Contributes.Tools {
+			descriptor, err := internalplugin.ToolDescriptor(path, contribution)
+			if err != nil {
+				fmt.Printf("tool: %s\terror: %v\n", contribution.ID, err)
+				failed++
+				continue
+			}
+			fmt.Printf("tool: %s\t%s\n", descriptor.ID, formatToolCapabilities(descriptor.Capabilities))
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d tool descriptor(s) of %s failed to load", failed, manifest.Metadata.Name)
+		}
 		return nil
 	case "install", "upgrade", "enable", "disable", "publish":
 		return runPluginLifecycle(ctx, app, args)
@@ -761,6 +989,35 @@ func runPlugins(ctx context.Context, app service.App, args []string) error {
 	}
 }
 
+func formatToolCapabilities(caps pkgplugin.ToolCapabilities) string {
+	var parts []string
+	if caps.SupportsStreaming {
+		parts = append(parts, "streaming")
+	}
+	if caps.SupportsCancel {
+		parts = append(parts, "cancel")
+	}
+	if caps.Concurrency > 0 {
+		parts = append(parts, fmt.Sprintf("concurrency=%d", caps.Concurrency))
+	}
+	if caps.Serial {
+		parts = append(parts, "serial")
+	}
+	if len(caps.Exclusive) > 0 {
+		parts = append(parts, "exclusive="+strings.Join(caps.Exclusive, ","))
+	}
+	if caps.NeedsConfirmation != nil {
+		parts = append(parts, fmt.Sprintf("confirm=%t", *caps.NeedsConfirmation))
+	}
+	if len(caps.Tags) > 0 {
+		parts = append(parts, "tags="+strings.Join(caps.Tags, ","))
+	}
+	if len(parts) == 0 {
+		return "-"
+	}
+	return strings.Join(parts, " ")
+}
+
 func runPluginLifecycle(ctx context.Context, app service.App, args []string) error {
 	subcommand := args[0]
 	switch subcommand {
@@ -1101,6 +1358,17 @@ func runConfig(app service.App, args []string) error {
 			strings.Join(app.Config.EnabledPlugins, ", "),
 			app.Config.ApprovalMode,
 		)
+		if app.Config.Environment != "" {
+			fmt.Printf("environment: %s\n", app.Config.Environment)
+		}
+		if raw, err := config.Load(app.Paths); err == nil && len(raw.Environments) > 0 {
+			names := make([]string, 0, len(raw.Environments))
+			for name := range raw.Environments {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			fmt.Printf("environments: %s\n", strings.Join(names, ", "))
+		}
 		if len(app.Config.PluginSources) == 0 {
 			fmt.Println("plugin_sources: none")
 		} else {
@@ -1119,7 +1387,7 @@ func runConfig(app service.App, args []string) error {
 		}
 		return nil
 	case "paths":
-		fmt.Printf("cwd: %s\nconfig_dir: %s\nconfig_file: %s\nlocal_profiles: %s\nuser_profiles: %s\nlocal_plugins: %s\nuser_plugins: %s\nsessions: %s\n",
+		fmt.Printf("cwd: %s\nconfig_dir: %s\nconfig_file: %s\nlocal_profiles: %s\nuser_profiles: %s\nlocal_plugins: %s\nuser_plugins: %s\nsessions: %s\nmemory: %s\napprovals: %s\nspend: %s\nworkflows: %s\n",
 			app.Paths.CWD,
 			app.Paths.ConfigDir,
 			app.Paths.ConfigFile,
@@ -1128,6 +1396,10 @@ func runConfig(app service.App, args []string) error {
 			app.Paths.LocalPluginsDir,
 			app.Paths.UserPluginsDir,
 			app.Paths.SessionsDir,
+			app.Paths.MemoryDir,
+			app.Paths.ApprovalsDir,
+			app.Paths.SpendFile,
+			app.Paths.WorkflowsDir,
 		)
 		return nil
 	default:
@@ -1135,7 +1407,16 @@ func runConfig(app service.App, args []string) error {
 	}
 }
 
-func runDoctor(ctx context.Context, app service.App) error {
+func runDoctor(ctx context.Context, app service.App, args []string) error {
+	checkProviders := false
+	for _, arg := range args {
+		switch arg {
+		case "--providers":
+			checkProviders = true
+		default:
+			return fmt.Errorf("unknown doctor argument %q", arg)
+		}
+	}
 	profiles, err := app.Profiles.Discover(ctx)
 	if err != nil {
 		return err
@@ -1165,9 +1446,67 @@ func runDoctor(ctx context.Context, app service.App) error {
 	for _, check := range checks {
 		fmt.Printf("%s\t%s\n", check.label, check.value)
 	}
+	// Warn about deprecated models ahead of their sunset, not at run time.
+	for _, p := range profiles {
+		spec := p.Manifest.Spec.Provider
+		primary := config.ResolveModel(app.Config, spec.Default, p.Manifest.Metadata.Name, spec.Model, "")
+		for _, model := range config.ExpandModelChain(app.Config.ModelAliases, app.Config.ModelFallbacks, append([]string{primary}, spec.Fallback...), func(model string) bool { return models.Serves(spec.Default, model) }) {
+			if _, warning := models.Resolve(model, time.Now()); warning != "" {
+				fmt.Printf("model_warning\t%s: %s\n", p.Manifest.Metadata.Name, warning)
+			}
+		}
+	}
+	if checkProviders {
+		manifests := make([]profile.Manifest, 0, len(profiles))
+		for _, p := range profiles {
+			manifests = append(manifests, p.Manifest)
+		}
+		doctorProviders(ctx, app, manifests)
+	}
 	return nil
 }
 
+// doctorProviders runs a preflight check of each provider and model the
+// profiles use, once per pair.
+func doctorProviders(ctx context.Context, app service.App, manifests []profile.Manifest) {
+	checked := map[string]bool{}
+	for _, m := range manifests {
+		spec := m.Spec.Provider
+		model := preflightModel(app, m, "")
+		key := spec.Default + "/" + model
+		if checked[key] {
+			continue
+		}
+		checked[key] = true
+		impl, err := app.ResolveProvider(spec.Default)
+		if err == nil {
+			err = provider.Preflight(ctx, impl, model)
+		}
+		status := "ok"
+		if preflightUnverified(err) {
+			status = "warning: " + err.Error()
+		} else if err != nil {
+			status = err.Error()
+		}
+		fmt.Printf("provider_check\t%s\t%s\n", key, status)
+	}
+}
+
+// preflightModel returns the model a run of manifest would send, with
+// config and built-in aliases resolved, for a preflight check.
+func preflightModel(app service.App, manifest profile.Manifest, cliModel string) string {
+	spec := manifest.Spec.Provider
+	resolved, _ := models.Resolve(config.ResolveModel(app.Config, spec.Default, manifest.Metadata.Name, spec.Model, cliModel), time.Now())
+	return resolved
+}
+
+// preflightUnverified reports whether err is a preflight that could not
+// confirm the model, which is a warning rather than a failure.
+func preflightUnverified(err error) bool {
+	var preflight *provider.PreflightError
+	return errors.As(err, &preflight) && preflight.Kind == provider.PreflightUnverified
+}
+
 func runSessions(ctx context.Context, app service.App, args []string) error {
 	if len(args) == 0 {
 		return errors.New("sessions command requires a subcommand")
@@ -1192,6 +1531,43 @@ func runSessions(ctx context.Context, app service.App, args []string) error {
 			loaded.Metadata.UpdatedAt.Format(time.RFC3339),
 			len(loaded.Entries),
 		)
+		if loaded.Metadata.Title != "" {
+			fmt.Printf("title: %s\n", loaded.Metadata.Title)
+		}
+		if loaded.Metadata.Summary != "" {
+			fmt.Printf("summary: %s\n", loaded.Metadata.Summary)
+		}
+		if loaded.Metadata.Pinned {
+			fmt.Println("pinned: true")
+		}
+		if active, model := loaded.Active(); active != loaded.Metadata.Profile || model != "" {
+			fmt.Printf("handed off to: %s\n", describeHandoffTarget(session.Handoff{ToProfile: active, ToModel: model}))
+		}
+		return nil
+	case "status":
+		if len(args) < 2 {
+			return errors.New("sessions status requires a session id")
+		}
+		status, err := runstatus.Read(runStatusDir(app), args[1])
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("session %s has no active run", args[1])
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("state: %s\nturn: %d\n", status.State, status.Turn)
+		if status.Phase != "" {
+			fmt.Printf("phase: %s\n", status.Phase)
+		}
+		if status.CurrentTool != "" {
+			fmt.Printf("tool: %s\n", status.CurrentTool)
+		}
+		fmt.Printf("bytes_generated: %d\npid: %d\nlast_progress: %s ago\nupdated: %s ago\n",
+			status.BytesGenerated,
+			status.PID,
+			time.Since(status.LastProgressAt).Round(time.Second),
+			time.Since(status.UpdatedAt).Round(time.Second),
+		)
 		return nil
 	case "list":
 		limit := 20
@@ -1230,13 +1606,19 @@ func runSessions(ctx context.Context, app service.App, args []string) error {
 			metas = metas[:limit]
 		}
 		w := newTabWriter()
-		fmt.Fprintln(w, "ID\tPROFILE\tCWD\tUPDATED")
+		fmt.Fprintln(w, "ID\tTITLE\tPROFILE\tCWD\tUPDATED")
 		for _, meta := range metas {
 			cwdDisplay := meta.CWD
 			if len(cwdDisplay) > 40 {
 				cwdDisplay = "..." + cwdDisplay[len(cwdDisplay)-37:]
 			}
-			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", meta.ID, meta.Profile, cwdDisplay, meta.UpdatedAt.Format("2006-01-02 15:04:05"))
+			title := meta.Title
+			if title == "" {
+				title = "-"
+			} else if runes := []rune(title); len(runes) > 40 {
+				title = string(runes[:37]) + "..."
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", meta.ID, title, meta.Profile, cwdDisplay, meta.UpdatedAt.Format("2006-01-02 15:04:05"))
 		}
 		w.Flush()
 		fmt.Printf("\nshowing %d of %d session(s)", len(metas), total)
@@ -1249,22 +1631,441 @@ func runSessions(ctx context.Context, app service.App, args []string) error {
 		if len(args) < 2 {
 			return errors.New("sessions export requires a session id")
 		}
+		format := "text"
+		outPath := ""
+		htmlOpts := transcript.HTMLOptions{}
+		for i := 2; i < len(args); i++ {
+			switch args[i] {
+			case "--theme":
+				if i+1 >= len(args) {
+					return errors.New("--theme requires a value")
+				}
+				htmlOpts.Theme = args[i+1]
+				i++
+			case "--expand":
+				htmlOpts.ExpandThinking = true
+				htmlOpts.ExpandToolResults = true
+			case "--format":
+				if i+1 >= len(args) {
+					return errors.New("--format requires a value")
+				}
+				format = args[i+1]
+				i++
+			case "--out":
+				if i+1 >= len(args) {
+					return errors.New("--out requires a value")
+				}
+				outPath = args[i+1]
+				i++
+			default:
+				return fmt.Errorf("unknown flag %q", args[i])
+			}
+		}
 		loaded, err := app.Sessions.Load(ctx, args[1])
 		if err != nil {
 			return err
 		}
-		for _, entry := range loaded.Entries {
-			if entry.Kind != "message" {
-				continue
+		var out io.Writer = os.Stdout
+		if outPath != "" {
+			file, err := os.Create(outPath)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			out = file
+		}
+		switch format {
+		case "html":
+			return transcript.ExportHTML(out, loaded, htmlOpts)
+		case "state":
+			data, err := pkgruntime.ExportState(transcript.State(loaded))
+			if err != nil {
+				return err
 			}
-			fmt.Printf("[%s] %s: %s\n", entry.CreatedAt.Format("15:04:05"), entry.Role, strings.TrimSpace(entry.Content))
+			_, err = fmt.Fprintln(out, string(data))
+			return err
+		case "text":
+			for _, entry := range loaded.Entries {
+				if entry.Kind != session.EntryMessage && entry.Kind != session.EntryHandoff {
+					continue
+				}
+				fmt.Fprintf(out, "[%s] %s: %s\n", entry.CreatedAt.Format("15:04:05"), entry.Role, strings.TrimSpace(entry.Content))
+			}
+			return nil
+		default:
+			return fmt.Errorf("unknown export format %q (expected text, html, or state)", format)
+		}
+	case "dataset":
+		return runSessionsDataset(ctx, app, args[1:])
+	case "import":
+		return runSessionsImport(ctx, app, args[1:])
+	case "handoff":
+		if len(args) < 3 {
+			return errors.New("sessions handoff requires a session id and a target profile")
+		}
+		h := session.Handoff{ToProfile: args[2]}
+		for i := 3; i < len(args); i++ {
+			switch args[i] {
+			case "--model", "--reason":
+				if i+1 >= len(args) {
+					return fmt.Errorf("%s requires a value", args[i])
+				}
+				if args[i] == "--model" {
+					h.ToModel = args[i+1]
+				} else {
+					h.Reason = args[i+1]
+				}
+				i++
+			default:
+				return fmt.Errorf("unknown flag %q", args[i])
+			}
+		}
+		h, err := app.Handoff(ctx, args[1], h)
+		if err != nil {
+			return err
 		}
+		fmt.Printf("session %s now continues with %s; resume it with: agent resume --session %s <prompt>\n", args[1], describeHandoffTarget(h), args[1])
 		return nil
+	case "search":
+		return runSessionsSearch(ctx, app, args[1:])
+	case "gc":
+		return runSessionsGC(ctx, app, args[1:])
+	case "pin", "unpin":
+		return runSessionsPin(ctx, app, args[1:], args[0] == "pin")
 	default:
 		return fmt.Errorf("unknown sessions subcommand %q", args[0])
 	}
 }
 
+// runSessionsSearch finds sessions by message text. Like sessions list it is
+// scoped to the cwd unless --all or --cwd is given.
+func runSessionsSearch(ctx context.Context, app service.App, args []string) error {
+	query := session.SearchQuery{CWD: app.Paths.CWD}
+	var terms []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--all":
+			query.CWD = ""
+		case "--cwd", "--model", "--since", "--until", "--limit", "--label":
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s requires a value", args[i])
+			}
+			value := args[i+1]
+			i++
+			switch args[i-1] {
+			case "--label":
+				key, val, ok := strings.Cut(value, "=")
+				if !ok || key == "" {
+					return fmt.Errorf("--label expects key=value, got %q", value)
+				}
+				if query.Labels == nil {
+					query.Labels = map[string]string{}
+				}
+				query.Labels[key] = val
+			case "--cwd":
+				abs, err := filepath.Abs(value)
+				if err != nil {
+					return err
+				}
+				query.CWD = abs
+			case "--model":
+				query.Model = value
+			case "--since", "--until":
+				at, err := parseDateArg(value)
+				if err != nil {
+					return fmt.Errorf("%s: %w", args[i-1], err)
+				}
+				if args[i-1] == "--since" {
+					query.Since = at
+				} else {
+					query.Until = at
+				}
+			case "--limit":
+				query.Limit = parseIntArg(value)
+			}
+		default:
+			if strings.HasPrefix(args[i], "--") {
+				return fmt.Errorf("unknown flag %q", args[i])
+			}
+			terms = append(terms, args[i])
+		}
+	}
+	query.Text = strings.Join(terms, " ")
+	if strings.TrimSpace(query.Text) == "" && len(query.Labels) == 0 {
+		return errors.New("sessions search requires a query or --label")
+	}
+	results, err := app.Sessions.Search(ctx, query)
+	if err != nil {
+		return err
+	}
+	printSearchResults(os.Stdout, results)
+	return nil
+}
+
+func printSearchResults(w io.Writer, results []session.SearchResult) {
+	if len(results) == 0 {
+		fmt.Fprintln(w, "no matching sessions")
+		return
+	}
+	for _, result := range results {
+		meta := result.Metadata
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", meta.ID, meta.Profile, meta.CWD, meta.UpdatedAt.Local().Format("2006-01-02 15:04"))
+		for _, hit := range result.Hits {
+			fmt.Fprintf(w, "  %-9s %s\n", hit.Role+":", hit.Snippet)
+		}
+	}
+}
+
+// parseDateArg accepts YYYY-MM-DD (local midnight) or RFC 3339.
+func parseDateArg(value string) (time.Time, error) {
+	if at, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return at, nil
+	}
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q (expected YYYY-MM-DD or RFC 3339)", value)
+	}
+	return at, nil
+}
+
+func runApprovals(app service.App, args []string) error {
+	if len(args) == 0 {
+		return errors.New("approvals requires a subcommand")
+	}
+	switch args[0] {
+	case "list":
+		all := len(args) > 1 && args[1] == "--all"
+		records, err := app.Approvals.List()
+		if err != nil {
+			return err
+		}
+		w := newTabWriter()
+		fmt.Fprintln(w, "ID\tSTATUS\tTOOL\tRISK\tEXPIRES")
+		shown := 0
+		for _, p := range records {
+			if !all && p.Status != internalapproval.StatusPending {
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.ID, p.Status, p.ToolID, p.Risk, p.ExpiresAt.Local().Format("2006-01-02 15:04:05"))
+			shown++
+		}
+		if shown == 0 {
+			fmt.Println("no pending approvals")
+			return nil
+		}
+		return w.Flush()
+	case "approve", "deny":
+		if len(args) < 2 {
+			return fmt.Errorf("approvals %s requires an approval id", args[0])
+		}
+		by := firstNonEmpty(os.Getenv("USER"), "cli")
+		p, err := app.Approvals.Decide(args[1], args[0] == "approve", strings.Join(args[2:], " "), by)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s %s\n", p.ID, p.Status)
+		return nil
+	default:
+		return fmt.Errorf("unknown approvals subcommand %q", args[0])
+	}
+}
+
+// openQueue opens the queue at rawURL, or returns nil when rawURL is empty.
+func openQueue(rawURL string) (queue.Queue, error) {
+	if rawURL == "" {
+		return nil, nil
+	}
+	return queue.Open(rawURL)
+}
+
+func runQueue(ctx context.Context, args []string) error {
+	if len(args) < 3 || args[0] != "push" {
+		return errors.New("usage: queue push <url> <message>")
+	}
+	q, err := queue.Open(args[1])
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+	msg, err := q.Push(ctx, strings.Join(args[2:], " "))
+	if err != nil {
+		return err
+	}
+	fmt.Println(msg.ID)
+	return nil
+}
+
+// runSessionsDataset exports sessions as a fine-tuning dataset. Session IDs
+// may be listed explicitly; otherwise recent sessions for the cwd (or all
+// sessions with --all) are exported.
+func runSessionsDataset(ctx context.Context, app service.App, args []string) error {
+	opts := transcript.DatasetOptions{Format: transcript.FormatOpenAIJSONL}
+	var ids []string
+	outPath := ""
+	filterCWD := true
+	limit := 1000
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--format":
+			if i+1 >= len(args) {
+				return errors.New("--format requires a value")
+			}
+			opts.Format = transcript.DatasetFormat(args[i+1])
+			i++
+		case "--out":
+			if i+1 >= len(args) {
+				return errors.New("--out requires a value")
+			}
+			outPath = args[i+1]
+			i++
+		case "--system":
+			if i+1 >= len(args) {
+				return errors.New("--system requires a value")
+			}
+			opts.System = args[i+1]
+			i++
+		case "--limit":
+			if i+1 >= len(args) {
+				return errors.New("--limit requires a value")
+			}
+			limit = parseIntArg(args[i+1])
+			i++
+		case "--all":
+			filterCWD = false
+		case "--only-successful":
+			opts.OnlySuccessful = true
+		case "--strip-thinking":
+			opts.StripThinking = true
+		case "--anonymize":
+			opts.Anonymize = true
+		default:
+			if strings.HasPrefix(args[i], "--") {
+				return fmt.Errorf("unknown flag %q", args[i])
+			}
+			ids = append(ids, args[i])
+		}
+	}
+	if len(ids) == 0 {
+		cwd := ""
+		if filterCWD {
+			cwd = app.Paths.CWD
+		}
+		metas, err := app.Sessions.List(ctx, cwd, limit)
+		if err != nil {
+			return err
+		}
+		for _, meta := range metas {
+			ids = append(ids, meta.ID)
+		}
+	}
+	sessions := make([]session.Session, 0, len(ids))
+	for _, id := range ids {
+		loaded, err := app.Sessions.Load(ctx, id)
+		if err != nil {
+			return fmt.Errorf("load session %s: %w", id, err)
+		}
+		sessions = append(sessions, loaded)
+	}
+	var out io.Writer = os.Stdout
+	if outPath != "" {
+		file, err := os.Create(outPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	count, err := transcript.WriteDataset(out, sessions, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d of %d session(s) as %s\n", count, len(sessions), opts.Format)
+	return nil
+}
+
+// runSessionsImport stores the conversations in a file exported from
+// another tool as sessions, so they can be resumed here. The first message
+// of each is labeled with its source format and title.
+func runSessionsImport(ctx context.Context, app service.App, args []string) error {
+	var path, cwd string
+	var format transcript.ImportFormat
+	profileRef := app.Config.DefaultProfile
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--format", "--profile", "--cwd":
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s requires a value", args[i])
+			}
+			switch args[i] {
+			case "--format":
+				format = transcript.ImportFormat(args[i+1])
+			case "--profile":
+				profileRef = args[i+1]
+			default:
+				cwd = args[i+1]
+			}
+			i++
+		default:
+			if strings.HasPrefix(args[i], "--") {
+				return fmt.Errorf("unknown flag %q", args[i])
+			}
+			path = args[i]
+		}
+	}
+	if path == "" {
+		return errors.New("sessions import requires a file")
+	}
+	if profileRef == "" {
+		profileRef = "coding"
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if format == "" {
+		if format, err = transcript.DetectFormat(data); err != nil {
+			return fmt.Errorf("%s: %w; name the format with --format", path, err)
+		}
+	}
+	conversations, err := transcript.Import(data, format)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, c := range conversations {
+		meta := session.Metadata{Profile: profileRef, CWD: cwd, CreatedAt: c.CreatedAt}
+		if meta.CWD == "" {
+			meta.CWD = c.CWD
+		}
+		if meta.CWD == "" {
+			meta.CWD = app.Paths.CWD
+		}
+		created, err := app.Sessions.Create(ctx, meta)
+		if err != nil {
+			return err
+		}
+		labels := map[string]string{"imported_from": string(format)}
+		if c.Title != "" {
+			labels["title"] = c.Title
+		}
+		c.Messages[0].Labels = labels
+		at := c.CreatedAt
+		if at.IsZero() {
+			at = time.Now()
+		}
+		for _, entry := range transcript.ToEntries(c.Messages, at) {
+			if err := app.Sessions.Append(ctx, created.Metadata.ID, entry); err != nil {
+				return fmt.Errorf("import into session %s: %w", created.Metadata.ID, err)
+			}
+		}
+		if err := session.Close(ctx, app.Sessions, created.Metadata.ID); err != nil {
+			return fmt.Errorf("import into session %s: %w", created.Metadata.ID, err)
+		}
+		fmt.Printf("%s\t%d messages\t%s\n", created.Metadata.ID, len(c.Messages), c.Title)
+	}
+	fmt.Fprintf(os.Stderr, "imported %d conversation(s) from %s as %s; resume one with: agent resume --session <id> <prompt>\n", len(conversations), path, format)
+	return nil
+}
+
 func statusPath(path string) string {
 	if _, err := os.Stat(path); err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
@@ -1292,10 +2093,19 @@ func printUsage() {
 	fmt.Printf("%s <command>\n\n", prog)
 	fmt.Println("Commands:")
 	fmt.Println("  chat                    Start an interactive session")
+	fmt.Println("  chat --no-preflight     Skip checking the provider's key, model, and base URL at startup")
 	fmt.Println("  serve --profile <ref>   Start as an MCP tool server (stdio transport)")
 	fmt.Println("  serve --addr :9898     Start as an HTTP worker (dynamic profile loading)")
 	fmt.Println("  serve --addr :9898 --profile <ref>  HTTP worker with fixed profile")
 	fmt.Println("  run                     Execute a one-shot run")
+	fmt.Println("  run --steering <url> --follow-ups <url>  Accept mid-run messages from a queue (redis://host:6379?key=...)")
+	fmt.Println("  run --follow-up '<trigger>: <message>'  Send message once the run is done and trigger holds, waiting for it (repeatable):")
+	fmt.Println("                          after 10m, at <cron or RFC 3339 time>, file <path>, or command <shell command exiting 0>")
+	fmt.Println("  run --manifest <file> [--label k=v] [--artifact path]  Write a JSON run manifest at the end (or set AGENT_RUN_MANIFEST)")
+	fmt.Println("  run --label k=v         Label the prompt in the session and manifest (repeatable; find with sessions search --label)")
+	fmt.Println("  run --tool-choice <c>   Make the first turn call a tool: auto, none, required, or a tool ID such as core/read")
+	fmt.Println("  run --prefill <text>    Start the reply with text, such as '```json' to force a format")
+	fmt.Println("  run --attach <file>     Attach an image, PDF, or text file to the prompt (repeatable)")
 	fmt.Println("  resume                  Resume a previous session with a new prompt")
 	fmt.Println("  profiles list                               List discoverable profiles")
 	fmt.Println("  profiles search [query] [--source <name>]  Search registry for profile packages")
@@ -1323,27 +2133,89 @@ func printUsage() {
 	fmt.Println("  sessions list --all     List all sessions across all directories")
 	fmt.Println("  sessions list --limit N Limit to N sessions")
 	fmt.Println("  sessions show <id>      Show one session")
-	fmt.Println("  sessions export <id>    Print session message history")
+	fmt.Println("  sessions status <id>    Show the progress of the session's current or last run")
+	fmt.Println("  sessions export <id> [--format text|html|state] [--out file]  Export session history")
+	fmt.Println("                          [--theme light|dark|auto] [--expand]  HTML theme; unfold thinking and tool results")
+	fmt.Println("  sessions dataset [ids...] [--format openai|messages] [--out file] [--all]")
+	fmt.Println("                          [--only-successful] [--strip-thinking] [--anonymize]  Export a fine-tuning dataset")
+	fmt.Println("  sessions import <file> [--format claude-code|codex|chatgpt|markdown] [--profile name] [--cwd dir]")
+	fmt.Println("                          Store conversations from another tool as sessions to resume here")
+	fmt.Println("  sessions handoff <id> <profile> [--model m] [--reason text]")
+	fmt.Println("                          Continue a session with another profile or model")
+	fmt.Println("  sessions search <text> [--all|--cwd dir] [--model m] [--label k=v] [--since date] [--until date] [--limit N]")
+	fmt.Println("                          Find sessions whose messages contain every word of text")
+	fmt.Println("  sessions gc [--max-age 90d] [--max-size 500MB] [--max-count N] [--compress-after 30d] [--dry-run]")
+	fmt.Println("                          Remove and compress old sessions per sessions.retention in config")
+	fmt.Println("  sessions pin|unpin <id> Protect a session from gc, or lift the protection")
+	fmt.Println("  report [--since date] [--until date] [--all] [--cwd dir] [--by day|month|project|profile|model] [--format table|json|csv]")
+	fmt.Println("                          Total tokens, cost, models, and tool calls recorded in sessions (this month by default)")
+	fmt.Println("  audit verify [file]     Check the hash chain of the tool audit log (audit.enabled in config)")
+	fmt.Println("  skills list             List skills in .agent/skills and ~/.agent/skills")
+	fmt.Println("  skills show <name>      Show a skill's tools, scripts, and resources")
+	fmt.Println("  workflow run <file> [--input name=value]... [--parallel N]  Run a DAG of agent steps")
+	fmt.Println("  workflow resume <run-id> Re-run the failed and unfinished steps of a workflow run")
+	fmt.Println("  workflow status <run-id> Show a workflow run's steps")
+	fmt.Println("  workflow list           List workflow runs")
+	fmt.Println("  queue push <url> <message>  Send a steering or follow-up message to a running agent")
+	fmt.Println("  approvals list [--all]  List pending webhook approvals")
+	fmt.Println("  approvals approve|deny <id> [reason]  Decide a pending approval")
 	fmt.Println("  config show             Show resolved config")
 	fmt.Println("  config paths            Show config-related paths")
 	fmt.Println("  doctor                  Run local diagnostics")
+	fmt.Println("  doctor --providers      Also check each profile's provider key, model, and base URL")
+	fmt.Println("  debug <id> [--step N] [--full]  Step through a session's provider calls and the context each was sent")
+	fmt.Println()
+	fmt.Println("Options:")
+	fmt.Println("  --plain                 Line-oriented output without escape codes or box drawing, for screen readers and")
+	fmt.Println("                          CI logs (also AGENT_PLAIN=1 or TERM=dumb)")
+	fmt.Println("  --env <name>            Merge the config's environments.<name> overlay over the rest (also AGENT_ENV)")
 }
 
 type streamSink struct {
 	Writer *os.File
+	// argsLine is set while the last line written is a partial tool
+	// args line, redrawn in place by the next one.
+	argsLine *bool
 }
 
 func (s streamSink) Publish(_ context.Context, event events.Event) error {
 	switch event.Type {
 	case events.TypeAssistantDelta:
+		// Text after a partial args line starts a line of its own, which
+		// the next args line leaves alone.
+		if s.argsLine != nil && *s.argsLine {
+			*s.argsLine = false
+			if _, err := fmt.Fprintln(s.Writer); err != nil {
+				return err
+			}
+		}
 		_, err := fmt.Fprint(s.Writer, event.Message)
 		return err
+	case events.TypeToolArgsPartial:
+		prefix := "\n"
+		if s.argsLine != nil && *s.argsLine {
+			prefix = "\r\x1b[K"
+		}
+		if s.argsLine != nil {
+			*s.argsLine = true
+		}
+		_, err := fmt.Fprintf(s.Writer, "%s[tool args] %s", prefix, event.Message)
+		return err
 	case events.TypeToolRequested:
+		if s.argsLine != nil {
+			*s.argsLine = false
+		}
 		_, err := fmt.Fprintf(s.Writer, "\n[tool request] %s\n", event.Message)
 		return err
 	case events.TypeToolFinished:
 		_, err := fmt.Fprintf(s.Writer, "[tool finished] %s\n", summarizeToolEvent(event))
 		return err
+	case events.TypeToolLog:
+		_, err := fmt.Fprintf(s.Writer, "[tool log] %s\n", event.Message)
+		return err
+	case events.TypeHostedTool:
+		_, err := fmt.Fprintf(s.Writer, "[hosted tool] %s\n", event.Message)
+		return err
 	case events.TypePolicyDecision:
 		_, err := fmt.Fprintf(s.Writer, "[policy] %s\n", event.Message)
 		return err
@@ -1353,9 +2225,72 @@ func (s streamSink) Publish(_ context.Context, event events.Event) error {
 	case events.TypeError:
 		_, err := fmt.Fprintf(s.Writer, "[error] %s\n", event.Message)
 		return err
+	case events.TypeEmptyResponse:
+		_, err := fmt.Fprintf(s.Writer, "[empty response] %s\n", event.Message)
+		return err
+	case events.TypeStreamResumed:
+		_, err := fmt.Fprintf(s.Writer, "\n[stream resumed] %s\n", event.Message)
+		return err
+	case events.TypeStreamStalled:
+		_, err := fmt.Fprintf(s.Writer, "\n[stream stalled] %s\n", event.Message)
+		return err
+	case events.TypeBudgetWarning, events.TypeBudgetExceeded:
+		_, err := fmt.Fprintf(s.Writer, "\n[budget] %s\n", event.Message)
+		return err
+	case events.TypeDeadlineExceeded:
+		_, err := fmt.Fprintf(s.Writer, "\n[time limit] %s\n", event.Message)
+		return err
+	case events.TypeSessionTitled:
+		_, err := fmt.Fprintf(s.Writer, "\n[session] %s\n", event.Message)
+		return err
+	case events.TypeModelDeprecated, events.TypeModelFallback, events.TypeProviderSwitched:
+		_, err := fmt.Fprintf(s.Writer, "[model] %s\n", event.Message)
+		return err
+	case events.TypeToolsetChanged:
+		_, err := fmt.Fprintf(s.Writer, "[tools] %s\n", event.Message)
+		return err
+	case events.TypeConfigReloaded:
+		_, err := fmt.Fprintf(s.Writer, "[config] %s\n", event.Message)
+		return err
+	case events.TypeGuardrailTriggered:
+		_, err := fmt.Fprintf(s.Writer, "\n[guardrail] %s\n", event.Message)
+		return err
+	case events.TypeSecurityWarning:
+		_, err := fmt.Fprintf(s.Writer, "\n[security] %s\n", event.Message)
+		return err
+	case events.TypeThinkingAdjusted:
+		_, err := fmt.Fprintf(s.Writer, "\n[thinking] %s\n", event.Message)
+		return err
+	case events.TypeSteering:
+		_, err := fmt.Fprintf(s.Writer, "\n[steering] %s\n", event.Message)
+		return err
+	case events.TypeFollowUp:
+		_, err := fmt.Fprintf(s.Writer, "\n[follow-up] %s\n", event.Message)
+		return err
+	case events.TypeFollowUpWaiting:
+		_, err := fmt.Fprintf(s.Writer, "\n[follow-up] %s\n", event.Message)
+		return err
+	case events.TypeWorkflowStep:
+		_, err := fmt.Fprintf(s.Writer, "[workflow] %s\n", event.Message)
+		return err
 	case events.TypeRunStarted:
 		_, err := fmt.Fprintf(s.Writer, "Running at %s\n", event.Time.Format(time.RFC3339))
 		return err
+	case events.TypeTurnFinished:
+		// Only with workspace.watch in the profile.
+		data, _ := event.Data.(map[string]any)
+		if changes, ok := data["filesChanged"].([]session.FileChange); ok {
+			for _, c := range changes {
+				if _, err := fmt.Fprintf(s.Writer, "[%s] %s\n", c.Change, c.Path); err != nil {
+					return err
+				}
+			}
+		}
+		if msg, ok := data["watchError"].(string); ok {
+			_, err := fmt.Fprintf(s.Writer, "[watch] %s\n", msg)
+			return err
+		}
+		return nil
 	default:
 		return nil
 	}
@@ -1486,51 +2421,47 @@ func compactText(text string, max int) string {
 	return text[:max-3] + "..."
 }
 
-// loadSystemInstructions builds the full system prompt from the profile's
-// instructions.system list. Each entry is resolved in this order:
-//
-//  1. Registered prompt ID — if the entry matches a prompt registered by an
-//     enabled plugin (e.g. "email/style-default"), the plugin's prompt file is
-//     loaded. This lets profiles reference plugin-contributed prompts by ID
-//     without hard-coding file paths.
-//
-//  2. File path — the entry is treated as a path relative to the profile
-//     directory (or absolute). The file is read and its content is used.
-//
-//  3. Inline text — if neither lookup succeeds the entry itself is used as
-//     literal prompt text, useful for short one-line instructions.
-//
-// All resolved chunks are joined with a blank line separator.
-func loadSystemInstructions(profilePath string, refs []string, prompts *registry.PromptRegistry) string {
-	baseDir := filepath.Dir(profilePath)
-	chunks := make([]string, 0, len(refs))
-	for _, ref := range refs {
-		// 1. Try as a registered plugin prompt ID.
-		if prompts != nil {
-			if asset, ok := prompts.Get(ref); ok && asset.Path != "" {
-				if data, err := os.ReadFile(asset.Path); err == nil {
-					chunks = append(chunks, strings.TrimSpace(string(data)))
-					continue
-				}
-			}
-		}
-		// 2. Try as a file path relative to the profile directory.
-		candidate := ref
-		if !filepath.IsAbs(candidate) {
-			candidate = filepath.Join(baseDir, candidate)
-		}
-		if data, err := os.ReadFile(candidate); err == nil {
-			chunks = append(chunks, strings.TrimSpace(string(data)))
-			continue
+// buildSystemPrompt composes the system prompt for a run from the profile's
+// instructions and prompt sections. See internal/sysprompt for how each
+// instructions.system entry is resolved.
+func buildSystemPrompt(ctx context.Context, app service.App, input runInput) (string, error) {
+	var notes []memory.Note
+	if app.Memory != nil && usesMemory(input.Tools) {
+		notes, _ = app.Memory.For(input.CWD).List(ctx)
+	}
+	return sysprompt.Build(sysprompt.Options{
+		ProfileName:  input.Manifest.Metadata.Name,
+		ProfilePath:  input.ProfilePath,
+		Instructions: input.Manifest.Spec.Instructions.System,
+		Sections:     input.Manifest.Spec.Instructions.Sections,
+		ContextFiles: input.Manifest.Spec.Instructions.ContextFiles,
+		Prompts:      app.Prompts,
+		CWD:          input.CWD,
+		Tools:        input.Tools,
+		Memory:       notes,
+		Renderer:     app.PromptRenderer,
+	})
+}
+
+// usesMemory reports whether any memory tool is enabled; project memory is
+// only summarized into the prompt for profiles that can read or update it.
+func usesMemory(tools []tool.Tool) bool {
+	for _, t := range tools {
+		switch t.Definition().ID {
+		case "core/memory_read", "core/memory_write":
+			return true
 		}
-		// 3. Use as inline literal text.
-		chunks = append(chunks, ref)
 	}
-	return strings.Join(chunks, "\n\n")
+	return false
 }
 
 type runInput struct {
 	Prompt        string
+	Images        []provider.Image    // attached to Prompt
+	Documents     []provider.Document // attached to Prompt
+	Labels        map[string]string   // stored with Prompt's message
+	ToolChoice    provider.ToolChoice // for the first turn
+	Prefill       string              // text the first reply starts with
 	Manifest      profile.Manifest
 	ProfilePath   string
 	ProviderImpl  provider.Provider
@@ -1542,10 +2473,20 @@ type runInput struct {
 	NoSession     bool
 	CWD           string
 	ModelOverride string
-	TaskID        string // gateway task ID for event forwarding
+	TaskID        string      // gateway task ID for event forwarding
+	Events        events.Sink // also receives a serve run's events
+	Steering      pkgruntime.MessageQueue
+	FollowUps     pkgruntime.MessageQueue
+	Toggles       *tool.Toggles
+	ToolCache     *pkgruntime.ToolCache
+	Reloader      *pkgruntime.Reloader
+	// KeepToolSessions keeps tools' per-session state, such as a
+	// persistent shell, for the next prompt; the caller releases it.
+	KeepToolSessions bool
 }
 
 type chatState struct {
+	Model        string // --model or a handoff target; "" resolves from config and profile
 	Manifest     profile.Manifest
 	ProfilePath  string
 	ProviderImpl provider.Provider
@@ -1556,11 +2497,16 @@ type chatState struct {
 	Transcript   []provider.Message
 	NoSession    bool
 	CWD          string
+	Toggles      *tool.Toggles         // /tools enable and disable; kept across handoffs
+	ToolCache    *pkgruntime.ToolCache // cached tool results, kept across prompts
+	Reloader     *pkgruntime.Reloader  // reloads the profile and config file when edited
+	Attachments  []string              // /attach paths sent with the next message
 }
 
 type sessionView struct {
 	ID      string
-	Profile string
+	Profile string // after any handoffs
+	Model   string // set by a handoff to a specific model
 	CWD     string
 	Entries []session.Entry
 }
@@ -1568,7 +2514,16 @@ type sessionView struct {
 // executeServeRun is like executeRun but sends events to stderr instead of stdout.
 // Used by the MCP serve command where stdout is the JSON-RPC protocol pipe.
 func executeServeRun(ctx context.Context, app service.App, input runInput) (pkgruntime.RunResult, error) {
-	var eventSink events.Sink = streamSink{Writer: os.Stderr}
+	eventSink := newStreamSink(os.Stderr)
+	if input.Events != nil {
+		log := eventSink
+		eventSink = events.SinkFunc(func(ctx context.Context, event events.Event) error {
+			if err := log.Publish(ctx, event); err != nil {
+				return err
+			}
+			return input.Events.Publish(ctx, event)
+		})
+	}
 	// Wrap with gateway forwarding if taskId is available
 	if input.TaskID != "" {
 		eventSink = newGatewayEventSink(eventSink, input.TaskID)
@@ -1576,40 +2531,97 @@ func executeServeRun(ctx context.Context, app service.App, input runInput) (pkgr
 	if app.HostCaps != nil {
 		app.HostCaps.Events = eventSink
 	}
+	systemPrompt, err := buildSystemPrompt(ctx, app, input)
+	if err != nil {
+		return pkgruntime.RunResult{}, err
+	}
+	selector, err := app.BuildToolSelector(input.Manifest, input.ProviderImpl)
+	if err != nil {
+		return pkgruntime.RunResult{}, err
+	}
+	turnLimit, wallClock, err := app.Config.RunLimits()
+	if err != nil {
+		return pkgruntime.RunResult{}, err
+	}
 	runReq := pkgruntime.RunRequest{
-		Prompt:        input.Prompt,
-		SystemPrompt:  loadSystemInstructions(input.ProfilePath, input.Manifest.Spec.Instructions.System, app.Prompts),
-		Profile:       input.Manifest,
-		Provider:      input.ProviderImpl,
-		Tools:         input.Tools,
-		Policy:        app.BuildPolicy(input.Workspace, input.Manifest, input.ProfilePath),
-		Approvals:     app.BuildApprovalResolver(firstNonEmpty(input.ApprovalMode, input.Manifest.Spec.Approval.Mode)),
-		Events:        eventSink,
-		Execution:     pkgruntime.ExecutionContext{CWD: input.CWD, SessionID: input.SessionID, ProfileRef: input.ProfilePath, Workspace: input.Workspace},
-		Transcript:    input.Transcript,
-		ModelOverride: input.ModelOverride,
+		Prompt:          input.Prompt,
+		SystemPrompt:    systemPrompt,
+		Profile:         input.Manifest,
+		Provider:        input.ProviderImpl,
+		Tools:           input.Tools,
+		Policy:          app.BuildPolicy(input.Workspace, input.Manifest, input.ProfilePath),
+		Approvals:       app.BuildApprovalResolver(firstNonEmpty(input.ApprovalMode, input.Manifest.Spec.Approval.Mode)),
+		Events:          eventSink,
+		Execution:       pkgruntime.ExecutionContext{CWD: input.CWD, SessionID: input.SessionID, ProfileRef: input.ProfilePath, Workspace: input.Workspace},
+		Transcript:      input.Transcript,
+		ModelOverride:   input.ModelOverride,
+		ModelAliases:    app.Config.ModelAliases,
+		ModelFallbacks:  app.Config.ModelFallbacks,
+		Ledger:          app.Ledger,
+		Audit:           app.Audit,
+		ToolSelector:    selector,
+		MaxTurnDuration: turnLimit,
+		MaxWallClock:    wallClock,
 	}
 	return app.Runner.Run(ctx, runReq)
 }
 
 func executeRun(ctx context.Context, app service.App, input runInput) (pkgruntime.RunResult, error) {
-	eventSink := streamSink{Writer: os.Stdout}
+	eventSink := newStreamSink(os.Stdout)
+	if !input.NoSession {
+		if interval, ok := heartbeatInterval(); ok {
+			status := runstatus.NewSink(eventSink, runStatusDir(app), interval)
+			defer status.Close()
+			eventSink = status
+		}
+	}
 	// Forward parent event sink to host capabilities so sub-agent progress is visible.
 	if app.HostCaps != nil {
 		app.HostCaps.Events = eventSink
 	}
+	systemPrompt, err := buildSystemPrompt(ctx, app, input)
+	if err != nil {
+		return pkgruntime.RunResult{}, err
+	}
+	selector, err := app.BuildToolSelector(input.Manifest, input.ProviderImpl)
+	if err != nil {
+		return pkgruntime.RunResult{}, err
+	}
+	turnLimit, wallClock, err := app.Config.RunLimits()
+	if err != nil {
+		return pkgruntime.RunResult{}, err
+	}
 	runReq := pkgruntime.RunRequest{
-		Prompt:        input.Prompt,
-		SystemPrompt:  loadSystemInstructions(input.ProfilePath, input.Manifest.Spec.Instructions.System, app.Prompts),
-		Profile:       input.Manifest,
-		Provider:      input.ProviderImpl,
-		Tools:         input.Tools,
-		Policy:        app.BuildPolicy(input.Workspace, input.Manifest, input.ProfilePath),
-		Approvals:     app.BuildApprovalResolver(firstNonEmpty(input.ApprovalMode, input.Manifest.Spec.Approval.Mode)),
-		Events:        eventSink,
-		Execution:     pkgruntime.ExecutionContext{CWD: input.CWD, SessionID: input.SessionID, ProfileRef: input.ProfilePath, Workspace: input.Workspace},
-		Transcript:    input.Transcript,
-		ModelOverride: input.ModelOverride,
+		Prompt:           input.Prompt,
+		Images:           input.Images,
+		Documents:        input.Documents,
+		Labels:           input.Labels,
+		ToolChoice:       input.ToolChoice,
+		Prefill:          input.Prefill,
+		SystemPrompt:     systemPrompt,
+		Profile:          input.Manifest,
+		Provider:         input.ProviderImpl,
+		Tools:            input.Tools,
+		Policy:           app.BuildPolicy(input.Workspace, input.Manifest, input.ProfilePath),
+		Approvals:        app.BuildApprovalResolver(firstNonEmpty(input.ApprovalMode, input.Manifest.Spec.Approval.Mode)),
+		Events:           eventSink,
+		Execution:        pkgruntime.ExecutionContext{CWD: input.CWD, SessionID: input.SessionID, ProfileRef: input.ProfilePath, Workspace: input.Workspace},
+		Transcript:       input.Transcript,
+		ModelOverride:    input.ModelOverride,
+		ModelAliases:     app.Config.ModelAliases,
+		ModelFallbacks:   app.Config.ModelFallbacks,
+		Ledger:           app.Ledger,
+		Audit:            app.Audit,
+		ToolSelector:     selector,
+		Titler:           app.BuildTitler(input.ProviderImpl),
+		Steering:         input.Steering,
+		FollowUps:        input.FollowUps,
+		Toggles:          input.Toggles,
+		ToolCache:        input.ToolCache,
+		Reloader:         input.Reloader,
+		KeepToolSessions: input.KeepToolSessions,
+		MaxTurnDuration:  turnLimit,
+		MaxWallClock:     wallClock,
 	}
 	if !input.NoSession {
 		runReq.Sessions = app.Sessions
@@ -1617,6 +2629,25 @@ func executeRun(ctx context.Context, app service.App, input runInput) (pkgruntim
 	return app.Runner.Run(ctx, runReq)
 }
 
+// heartbeatInterval is how often a run's status file is rewritten while
+// nothing else changes: AGENT_HEARTBEAT as a duration, 10s by default, and
+// "0" to disable the status file.
+func heartbeatInterval() (time.Duration, bool) {
+	raw := strings.TrimSpace(os.Getenv("AGENT_HEARTBEAT"))
+	if raw == "" {
+		return 10 * time.Second, true
+	}
+	interval, err := time.ParseDuration(raw)
+	if err != nil || interval <= 0 {
+		return 0, false
+	}
+	return interval, true
+}
+
+func runStatusDir(app service.App) string {
+	return filepath.Join(app.Paths.SessionsDir, "status")
+}
+
 func initializeChatState(ctx context.Context, app service.App, profileRef, sessionID, approvalMode string, noSession bool) (*chatState, error) {
 	if sessionID != "" {
 		existingSession, err := loadSessionByID(ctx, app, sessionID)
@@ -1647,9 +2678,12 @@ func initializeChatState(ctx context.Context, app service.App, profileRef, sessi
 			Workspace:    workspaceRef,
 			ApprovalMode: approvalMode,
 			SessionID:    existingSession.ID,
-			Transcript:   transcriptFromEntries(existingSession.Entries),
+			Model:        existingSession.Model,
+			Transcript:   transcript.FromEntries(existingSession.Entries),
 			NoSession:    noSession,
 			CWD:          existingSession.CWD,
+			Toggles:      &tool.Toggles{},
+			ToolCache:    &pkgruntime.ToolCache{},
 		}, nil
 	}
 	if profileRef == "" {
@@ -1680,10 +2714,59 @@ func initializeChatState(ctx context.Context, app service.App, profileRef, sessi
 		ApprovalMode: approvalMode,
 		NoSession:    noSession,
 		CWD:          app.Paths.CWD,
+		Toggles:      &tool.Toggles{},
+		ToolCache:    &pkgruntime.ToolCache{},
 	}, nil
 }
 
-func handleChatCommand(state *chatState, line string) (bool, error) {
+// toggleChatTool handles `/tools enable|disable <id|namespace:>`. Enabling a
+// registered tool the profile does not list adds it to the chat.
+func toggleChatTool(app service.App, state *chatState, args []string) error {
+	if len(args) != 2 || (args[0] != "enable" && args[0] != "disable") {
+		fmt.Fprintln(os.Stdout, "usage: /tools enable|disable <id|namespace:>")
+		return nil
+	}
+	action, target := args[0], args[1]
+	key := target
+	if ns, id := tool.SplitQualified(target); id == "" {
+		switch ns {
+		case tool.NamespaceBuiltin, tool.NamespacePlugin, tool.NamespaceMCP:
+		default:
+			fmt.Fprintf(os.Stdout, "unknown namespace %q (expected builtin, plugin, or mcp)\n", ns)
+			return nil
+		}
+	} else {
+		var found tool.Tool
+		for _, t := range state.Tools {
+			if t.Definition().ID == target || tool.QualifiedID(t) == target {
+				found = t
+				break
+			}
+		}
+		if found == nil {
+			// The profile's policy and approvals were set up for its own
+			// tools, so a tool it never granted cannot be switched on.
+			if app.Tools != nil {
+				if _, ok := app.Tools.Get(target); ok {
+					fmt.Fprintf(os.Stdout, "%s is not one of the profile's tools; add it to tools.enabled\n", target)
+					return nil
+				}
+			}
+			fmt.Fprintf(os.Stdout, "no tool %q\n", target)
+			return nil
+		}
+		key = tool.QualifiedID(found)
+	}
+	if action == "enable" {
+		state.Toggles.Enable(key)
+	} else {
+		state.Toggles.Disable(key)
+	}
+	fmt.Fprintf(os.Stdout, "%s %sd from the next turn\n", key, action)
+	return nil
+}
+
+func handleChatCommand(ctx context.Context, app service.App, state *chatState, line string) (bool, error) {
 	parts := strings.Fields(line)
 	if len(parts) == 0 {
 		return false, nil
@@ -1693,7 +2776,12 @@ func handleChatCommand(state *chatState, line string) (bool, error) {
 		fmt.Fprintln(os.Stdout, "/help     Show chat commands")
 		fmt.Fprintln(os.Stdout, "/profile  Show current profile")
 		fmt.Fprintln(os.Stdout, "/session  Show current session")
-		fmt.Fprintln(os.Stdout, "/tools    List enabled tools")
+		fmt.Fprintln(os.Stdout, "/tools    List tools, or switch them for the next turn: /tools enable|disable <id|namespace:>")
+		fmt.Fprintln(os.Stdout, "/attach   Attach an image, PDF, or text file to your next message: /attach <file>, /attach clear")
+		fmt.Fprintln(os.Stdout, "/search   Search past sessions in this directory")
+		fmt.Fprintln(os.Stdout, "/handoff  Continue this conversation with another profile: /handoff <profile> [--model m] [reason]")
+		fmt.Fprintln(os.Stdout, "/pin      List messages, or keep one verbatim through compaction: /pin <n>, /unpin <n>")
+		fmt.Fprintln(os.Stdout, "/context  Show what the next turn sends, in estimated tokens, and how far it is from compaction")
 		fmt.Fprintln(os.Stdout, "/approve  Show approval mode")
 		fmt.Fprintln(os.Stdout, "/quit     Exit chat")
 		return false, nil
@@ -1703,12 +2791,62 @@ func handleChatCommand(state *chatState, line string) (bool, error) {
 	case "/session":
 		fmt.Fprintf(os.Stdout, "session: %s\ncwd: %s\n", state.SessionID, state.CWD)
 		return false, nil
+	case "/attach":
+		return false, attachChatCommand(state, parts[1:])
 	case "/tools":
+		if len(parts) > 1 {
+			return false, toggleChatTool(app, state, parts[1:])
+		}
 		for _, t := range state.Tools {
 			def := t.Definition()
-			fmt.Fprintf(os.Stdout, "%s\t%s\n", def.ID, def.Description)
+			status := "on"
+			if !state.Toggles.Enabled(t) {
+				status = "off"
+			}
+			fmt.Fprintf(os.Stdout, "%s\t%s\t%s\n", tool.QualifiedID(t), status, def.Description)
+		}
+		return false, nil
+	case "/search":
+		if len(parts) < 2 {
+			fmt.Fprintln(os.Stdout, "usage: /search <text>")
+			return false, nil
+		}
+		if app.Sessions == nil {
+			fmt.Fprintln(os.Stdout, "session store is not configured")
+			return false, nil
+		}
+		results, err := app.Sessions.Search(ctx, session.SearchQuery{Text: strings.Join(parts[1:], " "), CWD: state.CWD, Limit: 10})
+		if err != nil {
+			return false, err
+		}
+		printSearchResults(os.Stdout, results)
+		return false, nil
+	case "/handoff":
+		if len(parts) < 2 {
+			fmt.Fprintln(os.Stdout, "usage: /handoff <profile> [--model m] [reason]")
+			return false, nil
+		}
+		h := session.Handoff{ToProfile: parts[1]}
+		var reason []string
+		for i := 2; i < len(parts); i++ {
+			if parts[i] == "--model" && i+1 < len(parts) {
+				h.ToModel = parts[i+1]
+				i++
+				continue
+			}
+			reason = append(reason, parts[i])
 		}
+		h.Reason = strings.Join(reason, " ")
+		if err := handoffChat(ctx, app, state, h); err != nil {
+			fmt.Fprintf(os.Stdout, "handoff failed: %v\n", err)
+			return false, nil
+		}
+		fmt.Fprintf(os.Stdout, "now chatting with profile %s\n", state.Manifest.Metadata.Name)
 		return false, nil
+	case "/pin", "/unpin":
+		return false, pinChatCommand(ctx, app, state, parts[1:], parts[0] == "/pin")
+	case "/context":
+		return false, contextChatCommand(ctx, app, state)
 	case "/approve":
 		mode := state.ApprovalMode
 		if mode == "" {
@@ -1724,22 +2862,119 @@ func handleChatCommand(state *chatState, line string) (bool, error) {
 	}
 }
 
+// handoffChat switches the chat to another profile and model. The history
+// carries over, and a persisted session records the handoff so resuming it
+// continues with the new profile.
+func handoffChat(ctx context.Context, app service.App, state *chatState, h session.Handoff) error {
+	manifest, path, err := app.Profiles.Load(ctx, h.ToProfile)
+	if err != nil {
+		return err
+	}
+	providerImpl, err := app.ResolveProvider(manifest.Spec.Provider.Default)
+	if err != nil {
+		return err
+	}
+	tools, err := app.ResolveTools(manifest.Spec.Tools.Enabled)
+	if err != nil {
+		return err
+	}
+	if state.SessionID != "" && !state.NoSession {
+		h.FromProfile, h.FromModel = state.Manifest.Metadata.Name, state.Model
+		if _, err := app.Handoff(ctx, state.SessionID, h); err != nil {
+			return err
+		}
+	}
+	state.Manifest, state.ProfilePath, state.ProviderImpl, state.Tools, state.Model = manifest, path, providerImpl, tools, h.ToModel
+	state.Reloader = watchChatConfig(ctx, app, state)
+	return nil
+}
+
+// watchChatConfig reloads the chat's profile and the config file when
+// either is edited, so the change applies from the next turn. An edit that
+// fails to load or switches provider is reported and the chat continues
+// with its current configuration.
+func watchChatConfig(ctx context.Context, app service.App, state *chatState) *pkgruntime.Reloader {
+	name, path, providerName, cliModel := state.Manifest.Metadata.Name, state.ProfilePath, state.Manifest.Spec.Provider.Default, state.Model
+	cwd, workspaceRef := state.CWD, state.Workspace
+	return pkgruntime.NewReloader(func() (pkgruntime.Reload, error) {
+		cfg, err := config.LoadEnvironment(app.Paths, app.Config.Environment)
+		if err != nil {
+			return pkgruntime.Reload{}, fmt.Errorf("config: %w", err)
+		}
+		manifest, _, err := app.Profiles.Load(ctx, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			manifest, _, err = app.Profiles.Load(ctx, path)
+		}
+		if err != nil {
+			return pkgruntime.Reload{}, fmt.Errorf("profile %s: %w", name, err)
+		}
+		if manifest.Spec.Provider.Default != providerName {
+			return pkgruntime.Reload{}, fmt.Errorf("profile %s: switching provider from %s to %s needs a new chat", name, providerName, manifest.Spec.Provider.Default)
+		}
+		tools, err := app.ResolveTools(manifest.Spec.Tools.Enabled)
+		if err != nil {
+			return pkgruntime.Reload{}, fmt.Errorf("profile %s: %w", name, err)
+		}
+		app.Config = cfg
+		systemPrompt, err := buildSystemPrompt(ctx, app, runInput{Manifest: manifest, ProfilePath: path, Tools: tools, CWD: cwd, Workspace: workspaceRef})
+		if err != nil {
+			return pkgruntime.Reload{}, err
+		}
+		return pkgruntime.Reload{
+			Profile:      manifest,
+			Model:        config.ResolveModel(cfg, providerName, name, manifest.Spec.Provider.Model, cliModel),
+			SystemPrompt: systemPrompt,
+			Tools:        tools,
+			Policy:       app.BuildPolicy(workspaceRef, manifest, path),
+		}, nil
+	}, path, app.Paths.ConfigFile)
+}
+
+func describeHandoffTarget(h session.Handoff) string {
+	if h.ToModel == "" {
+		return "profile " + h.ToProfile
+	}
+	return fmt.Sprintf("profile %s (model %s)", h.ToProfile, h.ToModel)
+}
+
 func printRunResult(result pkgruntime.RunResult, noSession bool) error {
 	if result.Output != "" {
 		fmt.Fprintf(os.Stdout, "\nFinal Output:\n%s\n", result.Output)
 	}
+	printArtifacts(result.Artifacts)
 	if result.SessionID != "" && !noSession {
 		fmt.Fprintf(os.Stdout, "\nSession: %s\n", result.SessionID)
 	}
 	return nil
 }
 
+// printArtifacts lists the files a run's tools declared, paths relative
+// to the working directory where they are inside it.
+func printArtifacts(artifacts []session.Artifact) {
+	if len(artifacts) == 0 {
+		return
+	}
+	cwd, _ := os.Getwd()
+	fmt.Fprintln(os.Stdout, "\nFiles created/modified:")
+	for _, a := range artifacts {
+		path := a.Path
+		if rel, err := filepath.Rel(cwd, path); err == nil && filepath.IsAbs(path) && !strings.HasPrefix(rel, "..") {
+			path = rel
+		}
+		line := "  " + path
+		if a.Description != "" {
+			line += " (" + a.Description + ")"
+		}
+		fmt.Fprintln(os.Stdout, line)
+	}
+}
+
 func loadSessionByID(ctx context.Context, app service.App, id string) (sessionView, error) {
 	loaded, err := app.Sessions.Load(ctx, id)
 	if err != nil {
 		return sessionView{}, err
 	}
-	return sessionView{ID: loaded.Metadata.ID, Profile: loaded.Metadata.Profile, CWD: loaded.Metadata.CWD, Entries: loaded.Entries}, nil
+	return newSessionView(loaded), nil
 }
 
 func loadMostRecentSession(ctx context.Context, app service.App) (sessionView, error) {
@@ -1747,39 +2982,12 @@ func loadMostRecentSession(ctx context.Context, app service.App) (sessionView, e
 	if err != nil {
 		return sessionView{}, err
 	}
-	return sessionView{ID: loaded.Metadata.ID, Profile: loaded.Metadata.Profile, CWD: loaded.Metadata.CWD, Entries: loaded.Entries}, nil
-}
-
-func transcriptFromEntries(entries []session.Entry) []provider.Message {
-	transcript := make([]provider.Message, 0, len(entries))
-	for _, entry := range entries {
-		if entry.Kind != session.EntryMessage {
-			continue
-		}
-		meta := decodeSessionMetadata(entry.Metadata)
-		switch entry.Role {
-		case "user", "assistant", "tool":
-			transcript = append(transcript, provider.Message{
-				Role:       entry.Role,
-				Content:    entry.Content,
-				ToolCallID: meta.ToolCallID,
-				ToolName:   meta.ToolName,
-				ToolCalls:  meta.ToolCalls,
-			})
-		}
-	}
-	return transcript
+	return newSessionView(loaded), nil
 }
 
-func decodeSessionMetadata(raw string) session.MessageMetadata {
-	if strings.TrimSpace(raw) == "" {
-		return session.MessageMetadata{}
-	}
-	var meta session.MessageMetadata
-	if err := json.Unmarshal([]byte(raw), &meta); err != nil {
-		return session.MessageMetadata{}
-	}
-	return meta
+func newSessionView(loaded session.Session) sessionView {
+	profile, model := loaded.Active()
+	return sessionView{ID: loaded.Metadata.ID, Profile: profile, Model: model, CWD: loaded.Metadata.CWD, Entries: loaded.Entries}
 }
 
 func newTabWriter() *tabwriter.Writer {
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+)
+
+// collectArtifacts describes the declared output files. Patterns are globs
+// relative to cwd; a pattern that matches nothing is reported with an error
+// rather than dropped, so CI notices a missing output.
+func collectArtifacts(cwd string, patterns []string) []pkgruntime.Artifact {
+	var out []pkgruntime.Artifact
+	for _, pattern := range patterns {
+		full := pattern
+		if !filepath.IsAbs(full) {
+			full = filepath.Join(cwd, full)
+		}
+		matches, err := filepath.Glob(full)
+		if err != nil || len(matches) == 0 {
+			out = append(out, pkgruntime.Artifact{Path: pattern, Error: "no matching file"})
+			continue
+		}
+		for _, path := range matches {
+			out = append(out, describeArtifact(cwd, path))
+		}
+	}
+	return out
+}
+
+func describeArtifact(cwd, path string) pkgruntime.Artifact {
+	artifact := pkgruntime.Artifact{Path: path}
+	if rel, err := filepath.Rel(cwd, path); err == nil && filepath.IsLocal(rel) {
+		artifact.Path = rel
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		artifact.Error = err.Error()
+		return artifact
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		artifact.Error = err.Error()
+		return artifact
+	}
+	artifact.Bytes, artifact.SHA256 = n, hex.EncodeToString(h.Sum(nil))
+	return artifact
+}
+
+// writeRunManifest writes the manifest atomically so a consumer polling
+// for the file never reads it half-written.
+func writeRunManifest(path string, m pkgruntime.RunManifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("write run manifest: %w", err)
+		}
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("write run manifest: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	internalapproval "github.com/bitop-dev/agent/internal/approval"
+	"github.com/bitop-dev/agent/pkg/config"
+)
+
+type approvalDecisionRequest struct {
+	Approved bool   `json:"approved"`
+	Reason   string `json:"reason,omitempty"`
+	By       string `json:"by,omitempty"`
+}
+
+// registerApprovalHandlers exposes pending webhook approvals so a dashboard
+// or chat bot can record decisions, with approvals.secret sent as a bearer
+// token. Without a secret anyone who reaches the server could approve tool
+// calls, so the endpoints are not registered.
+func registerApprovalHandlers(mux *http.ServeMux, store internalapproval.FileStore, cfg config.ApprovalsConfig) {
+	secret := cfg.Secret
+	if secret == "" {
+		if cfg.WebhookURL != "" {
+			log.Printf("approvals: approvals.secret is not set; /v1/approvals is disabled, decide with `agent approvals approve|deny`")
+		}
+		return
+	}
+	authorized := func(r *http.Request) bool {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		return subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+	}
+
+	// GET /v1/approvals — list pending approvals
+	mux.HandleFunc("/v1/approvals", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if !authorized(r) {
+			writeHTTPError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		records, err := store.List()
+		if err != nil {
+			writeHTTPError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		pending := []internalapproval.Pending{}
+		for _, p := range records {
+			if p.Status == internalapproval.StatusPending {
+				pending = append(pending, p)
+			}
+		}
+		writeHTTPJSON(w, http.StatusOK, map[string]any{"approvals": pending})
+	})
+
+	// GET  /v1/approvals/<id> — show one approval
+	// POST /v1/approvals/<id> — {"approved": true, "reason": "...", "by": "..."}
+	mux.HandleFunc("/v1/approvals/", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r) {
+			writeHTTPError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, "/v1/approvals/")
+		switch r.Method {
+		case http.MethodGet:
+			p, err := store.Get(id)
+			if err != nil {
+				writeHTTPError(w, http.StatusNotFound, err.Error())
+				return
+			}
+			writeHTTPJSON(w, http.StatusOK, p)
+		case http.MethodPost:
+			var req approvalDecisionRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeHTTPError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+				return
+			}
+			if _, err := store.Get(id); err != nil {
+				writeHTTPError(w, http.StatusNotFound, err.Error())
+				return
+			}
+			p, err := store.Decide(id, req.Approved, req.Reason, firstNonEmpty(req.By, "http"))
+			if err != nil {
+				writeHTTPError(w, http.StatusConflict, err.Error())
+				return
+			}
+			writeHTTPJSON(w, http.StatusOK, p)
+		default:
+			writeHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+}
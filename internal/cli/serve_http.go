@@ -11,7 +11,10 @@ import (
 	"time"
 
 	"github.com/bitop-dev/agent/internal/service"
+	"github.com/bitop-dev/agent/internal/tenant"
+	"github.com/bitop-dev/agent/internal/transcript"
 	pkghost "github.com/bitop-dev/agent/pkg/host"
+	"github.com/bitop-dev/agent/pkg/provider"
 	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
 )
 
@@ -23,19 +26,22 @@ type taskRequest struct {
 	Task     string         `json:"task"`
 	Context  map[string]any `json:"context,omitempty"`
 	MaxTurns int            `json:"maxTurns,omitempty"`
+	// Messages seeds the run with an earlier conversation, oldest first.
+	Messages []provider.Message `json:"messages,omitempty"`
 }
 
 type taskResponse struct {
-	ID           string               `json:"id"`
-	Status       string               `json:"status"`
-	Output       string               `json:"output,omitempty"`
-	Error        string               `json:"error,omitempty"`
-	SessionID    string               `json:"sessionId,omitempty"`
-	Duration     float64              `json:"duration"`
-	Model        string               `json:"model,omitempty"`
-	InputTokens  int                  `json:"inputTokens,omitempty"`
-	OutputTokens int                  `json:"outputTokens,omitempty"`
-	ToolSteps    []pkgruntime.ToolStep `json:"toolSteps,omitempty"`
+	ID              string                `json:"id"`
+	Status          string                `json:"status"`
+	Output          string                `json:"output,omitempty"`
+	Error           string                `json:"error,omitempty"`
+	SessionID       string                `json:"sessionId,omitempty"`
+	Duration        float64               `json:"duration"`
+	Model           string                `json:"model,omitempty"`
+	InputTokens     int                   `json:"inputTokens,omitempty"`
+	OutputTokens    int                   `json:"outputTokens,omitempty"`
+	ReasoningTokens int                   `json:"reasoningTokens,omitempty"`
+	ToolSteps       []pkgruntime.ToolStep `json:"toolSteps,omitempty"`
 }
 
 type agentInfoResponse struct {
@@ -61,6 +67,10 @@ func serveHTTP(ctx context.Context, app service.App, addr, fixedProfile string)
 
 	mux := http.NewServeMux()
 	registerMessageHandlers(mux, bus)
+	registerApprovalHandlers(mux, app.Approvals, app.Config.Approvals)
+	registerUsageHandlers(mux, app.Tenants)
+	registerSessionHandlers(mux, app.Sessions, app.Tenants)
+	registerChatCompletionHandlers(ctx, mux, app, fixedProfile)
 
 	mux.HandleFunc("/v1/health", func(w http.ResponseWriter, r *http.Request) {
 		profiles, _ := app.Profiles.Discover(ctx)
@@ -83,6 +93,11 @@ func serveHTTP(ctx context.Context, app service.App, addr, fixedProfile string)
 			writeHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
 			return
 		}
+		if app.Tenants != nil {
+			if _, ok := authorizeTenant(w, r, app.Tenants); !ok {
+				return
+			}
+		}
 		profiles, err := app.Profiles.Discover(ctx)
 		if err != nil {
 			writeHTTPError(w, http.StatusInternalServerError, err.Error())
@@ -132,6 +147,23 @@ func serveHTTP(ctx context.Context, app service.App, addr, fixedProfile string)
 			return
 		}
 
+		var client tenant.Client
+		if app.Tenants != nil {
+			var ok bool
+			if client, ok = authorizeTenant(w, r, app.Tenants); !ok {
+				return
+			}
+			models, err := taskModels(r.Context(), app, profileRef)
+			if err != nil {
+				writeHTTPError(w, http.StatusBadRequest, fmt.Sprintf("profile %q not found", profileRef))
+				return
+			}
+			if err := app.Tenants.CheckModels(client, models...); err != nil {
+				writeHTTPError(w, tenant.StatusCode(err), err.Error())
+				return
+			}
+		}
+
 		start := time.Now()
 		arguments := map[string]any{"task": req.Task}
 		if len(req.Context) > 0 {
@@ -140,9 +172,19 @@ func serveHTTP(ctx context.Context, app service.App, addr, fixedProfile string)
 		if req.TaskID != "" {
 			arguments["_taskId"] = req.TaskID
 		}
+		if len(req.Messages) > 0 {
+			if err := transcript.Validate(req.Messages); err != nil {
+				writeHTTPError(w, http.StatusBadRequest, "invalid messages: "+err.Error())
+				return
+			}
+			arguments["_history"] = req.Messages
+		}
 
 		sr, err := runTaskForServe(r.Context(), app, profileRef, arguments)
 		duration := time.Since(start).Seconds()
+		if app.Tenants != nil {
+			recordTenantUsage(r.Context(), app.Tenants, client, sr)
+		}
 
 		if err != nil {
 			writeHTTPJSON(w, http.StatusOK, taskResponse{
@@ -153,13 +195,14 @@ func serveHTTP(ctx context.Context, app service.App, addr, fixedProfile string)
 			return
 		}
 		writeHTTPJSON(w, http.StatusOK, taskResponse{
-			Status:       "completed",
-			Output:       sr.Output,
-			Model:        sr.Model,
-			InputTokens:  sr.InputTokens,
-			OutputTokens: sr.OutputTokens,
-			ToolSteps:    sr.ToolSteps,
-			Duration:     duration,
+			Status:          "completed",
+			Output:          sr.Output,
+			Model:           sr.Model,
+			InputTokens:     sr.InputTokens,
+			OutputTokens:    sr.OutputTokens,
+			ReasoningTokens: sr.ReasoningTokens,
+			ToolSteps:       sr.ToolSteps,
+			Duration:        duration,
 		})
 	})
 
@@ -171,6 +214,10 @@ func serveHTTP(ctx context.Context, app service.App, addr, fixedProfile string)
 	log.Printf("  POST /v1/task     — submit a task")
 	log.Printf("  GET  /v1/agents   — list available agents")
 	log.Printf("  GET  /v1/health   — health check")
+	log.Printf("  POST /v1/chat/completions — OpenAI-compatible chat; the model names the profile")
+	if app.Tenants != nil {
+		log.Printf("  GET  /v1/usage    — usage per API client (keys: %s)", app.Config.Serve.KeysFile)
+	}
 
 	// Discover profiles for registration.
 	profiles, _ := app.Profiles.Discover(ctx)
@@ -0,0 +1,341 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitop-dev/agent/internal/service"
+	"github.com/bitop-dev/agent/internal/tenant"
+	"github.com/bitop-dev/agent/pkg/events"
+	"github.com/bitop-dev/agent/pkg/provider"
+)
+
+// ── OpenAI Chat Completions facade ────────────────────────────────────────────
+//
+// Clients that speak the Chat Completions API can use a profile as if it were
+// a model: the request's model names the profile, the agent runs its loop
+// with the profile's tools server-side, and only the assistant's text comes
+// back. Tool definitions sent by the client are ignored.
+
+type chatCompletionRequest struct {
+	Model         string                  `json:"model"`
+	Messages      []chatCompletionMessage `json:"messages"`
+	Stream        bool                    `json:"stream"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options,omitempty"`
+}
+
+type chatCompletionMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"` // a string or an array of content parts
+}
+
+type chatCompletionResponse struct {
+	ID      string                 `json:"id"`
+	Object  string                 `json:"object"`
+	Created int64                  `json:"created"`
+	Model   string                 `json:"model"`
+	Choices []chatCompletionChoice `json:"choices"`
+	Usage   *chatCompletionUsage   `json:"usage,omitempty"`
+}
+
+type chatCompletionChoice struct {
+	Index        int                 `json:"index"`
+	Message      *chatCompletionText `json:"message,omitempty"`
+	Delta        *chatCompletionText `json:"delta,omitempty"`
+	FinishReason *string             `json:"finish_reason"`
+}
+
+type chatCompletionText struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type chatCompletionUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type modelListResponse struct {
+	Object string      `json:"object"`
+	Data   []modelInfo `json:"data"`
+}
+
+type modelInfo struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+func registerChatCompletionHandlers(ctx context.Context, mux *http.ServeMux, app service.App, fixedProfile string) {
+	// GET /v1/models — the profiles a client may name as its model
+	mux.HandleFunc("/v1/models", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeOpenAIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if _, ok := authorizeOpenAIClient(w, r, app.Tenants); !ok {
+			return
+		}
+		names := []string{fixedProfile}
+		if fixedProfile == "" {
+			names = nil
+			profiles, err := app.Profiles.Discover(ctx)
+			if err != nil {
+				writeOpenAIError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			for _, p := range profiles {
+				names = append(names, p.Manifest.Metadata.Name)
+			}
+		}
+		list := modelListResponse{Object: "list", Data: []modelInfo{}}
+		for _, name := range names {
+			list.Data = append(list.Data, modelInfo{ID: name, Object: "model", OwnedBy: "agent"})
+		}
+		writeHTTPJSON(w, http.StatusOK, list)
+	})
+
+	// POST /v1/chat/completions — run the agent on the conversation
+	mux.HandleFunc("/v1/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeOpenAIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		var req chatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeOpenAIError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+			return
+		}
+		profileRef := req.Model
+		if fixedProfile != "" {
+			// A fixed worker answers whatever model the client was configured with.
+			profileRef = fixedProfile
+		}
+		if profileRef == "" {
+			writeOpenAIError(w, http.StatusBadRequest, "model is required: name the profile to run")
+			return
+		}
+		prompt, history, err := chatCompletionConversation(req.Messages)
+		if err != nil {
+			writeOpenAIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		client, ok := authorizeOpenAIClient(w, r, app.Tenants)
+		if !ok {
+			return
+		}
+		if app.Tenants != nil {
+			models, err := taskModels(r.Context(), app, profileRef)
+			if err != nil {
+				writeOpenAIError(w, http.StatusNotFound, fmt.Sprintf("model %q does not exist", profileRef))
+				return
+			}
+			if err := app.Tenants.CheckModels(client, models...); err != nil {
+				writeOpenAIError(w, tenant.StatusCode(err), err.Error())
+				return
+			}
+		}
+
+		id := fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano())
+		created := time.Now().Unix()
+		arguments := map[string]any{"task": prompt, "_history": history}
+		if !req.Stream {
+			sr, err := runTaskForServe(r.Context(), app, profileRef, arguments)
+			if app.Tenants != nil {
+				recordTenantUsage(r.Context(), app.Tenants, client, sr)
+			}
+			if err != nil {
+				writeOpenAIError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			stop := "stop"
+			writeHTTPJSON(w, http.StatusOK, chatCompletionResponse{
+				ID:      id,
+				Object:  "chat.completion",
+				Created: created,
+				Model:   profileRef,
+				Choices: []chatCompletionChoice{{Message: &chatCompletionText{Role: "assistant", Content: sr.Output}, FinishReason: &stop}},
+				Usage:   completionUsage(sr),
+			})
+			return
+		}
+
+		chunks := &chatCompletionStream{w: w, id: id, created: created, model: profileRef}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		chunks.send(chatCompletionChoice{Delta: &chatCompletionText{Role: "assistant"}}, nil)
+		arguments["_events"] = events.SinkFunc(func(_ context.Context, event events.Event) error {
+			if event.Type == events.TypeAssistantDelta && event.Message != "" {
+				chunks.send(chatCompletionChoice{Delta: &chatCompletionText{Content: event.Message}}, nil)
+			}
+			return nil
+		})
+		sr, err := runTaskForServe(r.Context(), app, profileRef, arguments)
+		if app.Tenants != nil {
+			recordTenantUsage(r.Context(), app.Tenants, client, sr)
+		}
+		if err != nil {
+			chunks.write(map[string]any{"error": map[string]string{"message": err.Error(), "type": "server_error"}})
+		} else {
+			stop := "stop"
+			chunks.send(chatCompletionChoice{Delta: &chatCompletionText{}, FinishReason: &stop}, nil)
+			if req.StreamOptions != nil && req.StreamOptions.IncludeUsage {
+				chunks.send(chatCompletionChoice{}, completionUsage(sr))
+			}
+		}
+		chunks.done()
+	})
+}
+
+// chatCompletionConversation turns a Chat Completions conversation into the
+// prompt to run, its last user message, and the history before it. System
+// messages are prepended to the prompt, since the profile owns the system
+// prompt. Tool messages are dropped: tools run inside the agent.
+func chatCompletionConversation(messages []chatCompletionMessage) (string, []provider.Message, error) {
+	if len(messages) == 0 {
+		return "", nil, fmt.Errorf("messages must not be empty")
+	}
+	last := messages[len(messages)-1]
+	if last.Role != "user" {
+		return "", nil, fmt.Errorf("the last message must have role user, got %q", last.Role)
+	}
+	var system []string
+	var history []provider.Message
+	for _, msg := range messages[:len(messages)-1] {
+		text, err := chatMessageText(msg.Content)
+		if err != nil {
+			return "", nil, err
+		}
+		switch msg.Role {
+		case "system", "developer":
+			system = append(system, text)
+		case "user", "assistant":
+			if text != "" {
+				history = append(history, provider.Message{Role: msg.Role, Content: text})
+			}
+		case "tool", "function":
+		default:
+			return "", nil, fmt.Errorf("unsupported message role %q", msg.Role)
+		}
+	}
+	prompt, err := chatMessageText(last.Content)
+	if err != nil {
+		return "", nil, err
+	}
+	if strings.TrimSpace(prompt) == "" {
+		return "", nil, fmt.Errorf("the last user message is empty")
+	}
+	if len(system) > 0 {
+		prompt = strings.Join(system, "\n\n") + "\n\n" + prompt
+	}
+	return prompt, history, nil
+}
+
+// chatMessageText returns the text of a message's content, a string or an
+// array of parts of which only text parts are kept.
+func chatMessageText(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", fmt.Errorf("content must be a string or an array of parts")
+	}
+	var texts []string
+	for _, part := range parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+func completionUsage(sr serveResult) *chatCompletionUsage {
+	return &chatCompletionUsage{PromptTokens: sr.InputTokens, CompletionTokens: sr.OutputTokens, TotalTokens: sr.InputTokens + sr.OutputTokens}
+}
+
+// chatCompletionStream writes chat.completion.chunk server-sent events.
+type chatCompletionStream struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	id      string
+	created int64
+	model   string
+}
+
+func (s *chatCompletionStream) send(choice chatCompletionChoice, usage *chatCompletionUsage) {
+	chunk := chatCompletionResponse{ID: s.id, Object: "chat.completion.chunk", Created: s.created, Model: s.model, Choices: []chatCompletionChoice{choice}, Usage: usage}
+	if usage != nil {
+		chunk.Choices = []chatCompletionChoice{}
+	}
+	s.write(chunk)
+}
+
+func (s *chatCompletionStream) write(v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(s.w, "data: %s\n\n", data)
+	if flusher, ok := s.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (s *chatCompletionStream) done() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprint(s.w, "data: [DONE]\n\n")
+	if flusher, ok := s.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// authorizeOpenAIClient is authorizeTenant with errors in the shape OpenAI
+// clients display.
+func authorizeOpenAIClient(w http.ResponseWriter, r *http.Request, gate *tenant.Gateway) (tenant.Client, bool) {
+	if gate == nil {
+		return tenant.Client{}, true
+	}
+	client, err := gate.Authorize(r.Context(), r)
+	if err != nil {
+		if tenant.StatusCode(err) == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		writeOpenAIError(w, tenant.StatusCode(err), err.Error())
+		return tenant.Client{}, false
+	}
+	return client, true
+}
+
+func writeOpenAIError(w http.ResponseWriter, status int, message string) {
+	kind := "invalid_request_error"
+	switch {
+	case status == http.StatusUnauthorized:
+		kind = "authentication_error"
+	case status == http.StatusTooManyRequests:
+		kind = "rate_limit_error"
+	case status >= 500:
+		kind = "server_error"
+	}
+	writeHTTPJSON(w, status, map[string]any{"error": map[string]string{"message": message, "type": kind}})
+}
@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitop-dev/agent/internal/tenant"
+	"github.com/bitop-dev/agent/pkg/session"
+)
+
+type sessionSearchResult struct {
+	ID        string             `json:"id"`
+	Profile   string             `json:"profile"`
+	CWD       string             `json:"cwd"`
+	CreatedAt time.Time          `json:"createdAt"`
+	UpdatedAt time.Time          `json:"updatedAt"`
+	Hits      []sessionSearchHit `json:"hits"`
+}
+
+type sessionSearchHit struct {
+	Role      string    `json:"role"`
+	Snippet   string    `json:"snippet"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// registerSessionHandlers exposes session search. Sessions are not scoped
+// to API clients, so with a keys file only admin clients may search.
+func registerSessionHandlers(mux *http.ServeMux, store session.Store, gate *tenant.Gateway) {
+	// GET /v1/sessions/search?q=text[&cwd=][&model=][&since=][&until=][&limit=]
+	mux.HandleFunc("/v1/sessions/search", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if store == nil {
+			writeHTTPError(w, http.StatusNotFound, "session store is not configured")
+			return
+		}
+		if gate != nil {
+			client, ok := authorizeTenant(w, r, gate)
+			if !ok {
+				return
+			}
+			if !client.Admin {
+				writeHTTPError(w, http.StatusForbidden, "session search requires an admin API key")
+				return
+			}
+		}
+		params := r.URL.Query()
+		query := session.SearchQuery{Text: params.Get("q"), CWD: params.Get("cwd"), Model: params.Get("model")}
+		for _, label := range params["label"] {
+			key, value, ok := strings.Cut(label, "=")
+			if !ok || key == "" {
+				writeHTTPError(w, http.StatusBadRequest, "label must be key=value")
+				return
+			}
+			if query.Labels == nil {
+				query.Labels = map[string]string{}
+			}
+			query.Labels[key] = value
+		}
+		if query.Text == "" && len(query.Labels) == 0 {
+			writeHTTPError(w, http.StatusBadRequest, "q or label is required")
+			return
+		}
+		for name, dst := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+			if value := params.Get(name); value != "" {
+				at, err := parseDateArg(value)
+				if err != nil {
+					writeHTTPError(w, http.StatusBadRequest, name+": "+err.Error())
+					return
+				}
+				*dst = at
+			}
+		}
+		if value := params.Get("limit"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				writeHTTPError(w, http.StatusBadRequest, "limit must be a non-negative integer")
+				return
+			}
+			query.Limit = n
+		}
+		results, err := store.Search(r.Context(), query)
+		if err != nil {
+			writeHTTPError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out := make([]sessionSearchResult, 0, len(results))
+		for _, result := range results {
+			meta := result.Metadata
+			item := sessionSearchResult{ID: meta.ID, Profile: meta.Profile, CWD: meta.CWD, CreatedAt: meta.CreatedAt, UpdatedAt: meta.UpdatedAt, Hits: []sessionSearchHit{}}
+			for _, hit := range result.Hits {
+				item.Hits = append(item.Hits, sessionSearchHit{Role: hit.Role, Snippet: hit.Snippet, CreatedAt: hit.CreatedAt})
+			}
+			out = append(out, item)
+		}
+		writeHTTPJSON(w, http.StatusOK, map[string]any{"sessions": out})
+	})
+}
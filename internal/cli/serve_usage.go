@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bitop-dev/agent/internal/models"
+	"github.com/bitop-dev/agent/internal/service"
+	"github.com/bitop-dev/agent/internal/tenant"
+	"github.com/bitop-dev/agent/pkg/config"
+)
+
+type usageResponse struct {
+	Month   string         `json:"month"`
+	Clients []tenant.Usage `json:"clients"`
+	Total   tenant.Usage   `json:"total"`
+}
+
+// registerUsageHandlers exposes aggregated consumption per API client.
+// Clients see their own usage; admin clients see everyone's.
+func registerUsageHandlers(mux *http.ServeMux, gate *tenant.Gateway) {
+	// GET /v1/usage?month=YYYY-MM[&client=name]
+	mux.HandleFunc("/v1/usage", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if gate == nil {
+			writeHTTPError(w, http.StatusNotFound, "usage accounting requires serve.keysFile")
+			return
+		}
+		client, ok := authorizeTenant(w, r, gate)
+		if !ok {
+			return
+		}
+		month := r.URL.Query().Get("month")
+		if month == "" {
+			month = tenant.MonthOf(time.Now())
+		} else if _, err := time.Parse("2006-01", month); err != nil {
+			writeHTTPError(w, http.StatusBadRequest, "month must be YYYY-MM")
+			return
+		}
+		only := client.Name
+		if client.Admin {
+			only = r.URL.Query().Get("client")
+		}
+		rows, err := gate.Usage.Month(r.Context(), month)
+		if err != nil {
+			writeHTTPError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		resp := usageResponse{Month: month, Clients: []tenant.Usage{}, Total: tenant.Usage{Client: "*", Month: month}}
+		for _, row := range rows {
+			if only != "" && row.Client != only {
+				continue
+			}
+			resp.Clients = append(resp.Clients, row)
+			resp.Total.Requests += row.Requests
+			resp.Total.InputTokens += row.InputTokens
+			resp.Total.OutputTokens += row.OutputTokens
+			resp.Total.CostUSD += row.CostUSD
+		}
+		writeHTTPJSON(w, http.StatusOK, resp)
+	})
+}
+
+// authorizeTenant identifies the caller, writing the error response and
+// returning false when the request must be rejected.
+func authorizeTenant(w http.ResponseWriter, r *http.Request, gate *tenant.Gateway) (tenant.Client, bool) {
+	client, err := gate.Authorize(r.Context(), r)
+	if err != nil {
+		if tenant.StatusCode(err) == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		writeHTTPError(w, tenant.StatusCode(err), err.Error())
+		return tenant.Client{}, false
+	}
+	return client, true
+}
+
+// taskModels returns every model a task on profileRef may call: the
+// resolved primary model and its fallbacks from the profile and config.
+func taskModels(ctx context.Context, app service.App, profileRef string) ([]string, error) {
+	m, _, err := app.Profiles.Load(ctx, profileRef)
+	if err != nil {
+		return nil, err
+	}
+	primary := config.ResolveModel(app.Config, m.Spec.Provider.Default, m.Metadata.Name, m.Spec.Provider.Model, "")
+	if primary == "" {
+		primary = "gpt-4o" // the runtime's fallback
+	}
+	return config.ExpandModelChain(app.Config.ModelAliases, app.Config.ModelFallbacks, append([]string{primary}, m.Spec.Provider.Fallback...), func(model string) bool {
+		return models.Serves(m.Spec.Provider.Default, model)
+	}), nil
+}
+
+// recordTenantUsage charges a finished task to the client.
+func recordTenantUsage(ctx context.Context, gate *tenant.Gateway, client tenant.Client, sr serveResult) {
+	if err := gate.Record(ctx, client, sr.InputTokens, sr.OutputTokens, sr.CostUSD); err != nil {
+		log.Printf("record usage for %s: %v", client.Name, err)
+	}
+}
@@ -0,0 +1,130 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/bitop-dev/agent/internal/service"
+	"github.com/bitop-dev/agent/pkg/config"
+	"github.com/bitop-dev/agent/pkg/session"
+)
+
+// autoGCInterval is how often sessions.retention.auto applies the policy.
+const autoGCInterval = 24 * time.Hour
+
+// runSessionsGC applies the configured retention policy, with limits given
+// as flags taking precedence.
+func runSessionsGC(ctx context.Context, app service.App, args []string) error {
+	policy, err := app.Config.Sessions.Retention.Policy()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--dry-run":
+			policy.DryRun = true
+		case "--max-age", "--max-size", "--max-count", "--compress-after":
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s requires a value", args[i])
+			}
+			value := args[i+1]
+			switch args[i] {
+			case "--max-age":
+				policy.MaxAge, err = config.ParseAge(value)
+			case "--compress-after":
+				policy.CompressAfter, err = config.ParseAge(value)
+			case "--max-size":
+				policy.MaxBytes, err = config.ParseSize(value)
+			case "--max-count":
+				policy.MaxCount, err = strconv.Atoi(value)
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %w", args[i], err)
+			}
+			i++
+		default:
+			return fmt.Errorf("unknown flag %q", args[i])
+		}
+	}
+	if policy == (session.RetentionPolicy{DryRun: policy.DryRun}) {
+		return errors.New("no retention limits: set sessions.retention in config or pass --max-age, --max-size, --max-count, or --compress-after")
+	}
+	result, err := session.GC(ctx, app.Sessions, policy)
+	if err != nil {
+		return err
+	}
+	removed, compressed := "removed", "compressed"
+	if policy.DryRun {
+		removed, compressed = "would remove", "would compress"
+	}
+	for _, s := range result.Removed {
+		fmt.Printf("%s\t%s\t%s\t%s\n", removed, s.ID, s.UpdatedAt.Local().Format("2006-01-02 15:04"), formatBytes(s.Bytes))
+	}
+	for _, s := range result.Compressed {
+		fmt.Printf("%s\t%s\t%s\t%s\n", compressed, s.ID, s.UpdatedAt.Local().Format("2006-01-02 15:04"), formatBytes(s.Bytes))
+	}
+	fmt.Printf("%s %d session(s) (%s), %s %d, kept %d\n", removed, len(result.Removed), formatBytes(result.FreedBytes), compressed, len(result.Compressed), result.Kept)
+	return nil
+}
+
+// runSessionsPin pins or unpins a session.
+func runSessionsPin(ctx context.Context, app service.App, args []string, pinned bool) error {
+	verb := "pin"
+	if !pinned {
+		verb = "unpin"
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("sessions %s requires a session id", verb)
+	}
+	collector, ok := app.Sessions.(session.Collector)
+	if !ok {
+		return session.ErrNoCollector
+	}
+	if err := collector.Pin(ctx, args[0], pinned); err != nil {
+		return err
+	}
+	fmt.Printf("%sned session %s\n", verb, args[0])
+	return nil
+}
+
+// autoSessionsGC applies the retention policy when sessions.retention.auto
+// is set and it has not run in the last day. Failures are reported but do
+// not stop the command.
+func autoSessionsGC(ctx context.Context, app service.App) {
+	retention := app.Config.Sessions.Retention
+	if !retention.Auto || app.Sessions == nil {
+		return
+	}
+	stamp := filepath.Join(app.Paths.SessionsDir, ".gc")
+	if info, err := os.Stat(stamp); err == nil && time.Since(info.ModTime()) < autoGCInterval {
+		return
+	}
+	policy, err := retention.Policy()
+	if err == nil {
+		_, err = session.GC(ctx, app.Sessions, policy)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: session gc: %v\n", err)
+		return
+	}
+	if err := os.MkdirAll(app.Paths.SessionsDir, 0o755); err == nil {
+		_ = os.WriteFile(stamp, []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0o644)
+	}
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
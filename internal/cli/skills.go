@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bitop-dev/agent/internal/service"
+	"github.com/bitop-dev/agent/pkg/skills"
+)
+
+// runSkills handles `agent skills list|show`.
+func runSkills(app service.App, args []string) error {
+	if len(args) == 0 {
+		return errors.New("skills requires a subcommand (list, show)")
+	}
+	switch args[0] {
+	case "list":
+		if len(app.Skills) == 0 {
+			fmt.Printf("no skills found in %s or %s\n", app.Paths.LocalSkillsDir, app.Paths.UserSkillsDir)
+			return nil
+		}
+		for _, s := range app.Skills {
+			fmt.Printf("%s\t%s\n", s.Name, s.Description)
+		}
+		return nil
+	case "show":
+		if len(args) < 2 {
+			return errors.New("skills show requires a skill name")
+		}
+		selected, err := skills.Select(app.Skills, args[1:2])
+		if err != nil {
+			return err
+		}
+		s := selected[0]
+		fmt.Printf("name: %s\ndescription: %s\ndir: %s\ntools: %s\n", s.Name, s.Description, s.Dir, strings.Join(s.ToolIDs(), ", "))
+		for _, script := range s.Scripts {
+			access := "writes"
+			if script.ReadOnly {
+				access = "read-only"
+			}
+			fmt.Printf("script: %s (%s, %s)\n", script.Name, script.Path, access)
+		}
+		fmt.Printf("resources: %d\n", len(s.Resources))
+		return nil
+	default:
+		return fmt.Errorf("unknown skills subcommand %q", args[0])
+	}
+}
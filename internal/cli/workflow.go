@@ -0,0 +1,172 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	loaderutil "github.com/bitop-dev/agent/internal/loader"
+	"github.com/bitop-dev/agent/internal/service"
+	pkghost "github.com/bitop-dev/agent/pkg/host"
+	"github.com/bitop-dev/agent/pkg/workflow"
+)
+
+// runWorkflow handles `agent workflow run|resume|status|list`.
+func runWorkflow(ctx context.Context, app service.App, args []string) error {
+	if len(args) == 0 {
+		return errors.New("workflow requires a subcommand (run, resume, status, list)")
+	}
+	store := &workflow.FileStore{Dir: app.Paths.WorkflowsDir}
+	switch args[0] {
+	case "run":
+		path := ""
+		inputs := map[string]string{}
+		parallel := 0
+		for i := 1; i < len(args); i++ {
+			switch args[i] {
+			case "--input":
+				if i+1 >= len(args) {
+					return errors.New("--input requires a value")
+				}
+				name, value, ok := strings.Cut(args[i+1], "=")
+				if !ok || name == "" {
+					return fmt.Errorf("--input expects name=value, got %q", args[i+1])
+				}
+				inputs[name] = value
+				i++
+			case "--parallel":
+				if i+1 >= len(args) {
+					return errors.New("--parallel requires a value")
+				}
+				parallel = parseIntArg(args[i+1])
+				i++
+			default:
+				if strings.HasPrefix(args[i], "--") {
+					return fmt.Errorf("unknown flag %q", args[i])
+				}
+				path = args[i]
+			}
+		}
+		if path == "" {
+			return errors.New("workflow run requires a workflow file")
+		}
+		w, err := loaderutil.LoadYAML[workflow.Workflow](path)
+		if err != nil {
+			return fmt.Errorf("load workflow %s: %w", path, err)
+		}
+		if parallel > 0 {
+			w.MaxParallel = parallel
+		}
+		engine, err := workflowEngine(app, store)
+		if err != nil {
+			return err
+		}
+		state, err := engine.Start(ctx, w, inputs)
+		printWorkflowState(state)
+		return err
+	case "resume":
+		if len(args) < 2 {
+			return errors.New("workflow resume requires a run id")
+		}
+		engine, err := workflowEngine(app, store)
+		if err != nil {
+			return err
+		}
+		state, err := engine.Resume(ctx, args[1])
+		printWorkflowState(state)
+		return err
+	case "status":
+		if len(args) < 2 {
+			return errors.New("workflow status requires a run id")
+		}
+		state, err := store.Load(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		printWorkflowState(state)
+		return nil
+	case "list":
+		states, err := store.List(ctx)
+		if err != nil {
+			return err
+		}
+		if len(states) == 0 {
+			fmt.Println("no workflow runs found")
+			return nil
+		}
+		w := newTabWriter()
+		fmt.Fprintln(w, "ID\tWORKFLOW\tSTATUS\tUPDATED")
+		for _, state := range states {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", state.ID, state.Workflow.Name, state.Status, state.UpdatedAt.Local().Format("2006-01-02 15:04:05"))
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown workflow subcommand %q", args[0])
+	}
+}
+
+// workflowEngine runs each step as a sub-agent, so steps get the same
+// profile loading, tool restriction, and deny-all approvals as delegated
+// tasks.
+func workflowEngine(app service.App, store workflow.Store) (workflow.Engine, error) {
+	if app.HostCaps == nil {
+		return workflow.Engine{}, errors.New("workflows require host capabilities")
+	}
+	sink := newStreamSink(os.Stderr)
+	// A copy, so the steps' events do not take over the app's sink.
+	caps := *app.HostCaps
+	caps.Events = sink
+	runner := workflow.StepRunnerFunc(func(ctx context.Context, req workflow.StepRequest) (workflow.StepResult, error) {
+		result, err := caps.SpawnSubRun(ctx, pkghost.SubRunRequest{
+			Task:         req.Prompt,
+			Profile:      req.Step.Profile,
+			Model:        req.Step.Model,
+			MaxTurns:     req.Step.MaxTurns,
+			AllowedTools: req.Step.Tools,
+		})
+		return workflow.StepResult{Output: result.Output, SessionID: result.SessionID}, err
+	})
+	return workflow.Engine{Runner: runner, Store: store, Events: sink}, nil
+}
+
+func printWorkflowState(state workflow.State) {
+	if state.ID == "" {
+		return
+	}
+	fmt.Printf("run: %s\nworkflow: %s\nstatus: %s\n", state.ID, state.Workflow.Name, state.Status)
+	for _, step := range state.Workflow.Steps {
+		st := state.Steps[step.ID]
+		if st == nil {
+			continue
+		}
+		line := fmt.Sprintf("  %-20s %s", step.ID, st.Status)
+		if !st.StartedAt.IsZero() && !st.FinishedAt.IsZero() {
+			line += fmt.Sprintf(" (%s)", st.FinishedAt.Sub(st.StartedAt).Round(time.Second))
+		}
+		if st.Error != "" {
+			line += ": " + st.Error
+		}
+		fmt.Println(line)
+	}
+	if state.Status == workflow.StatusFailed {
+		fmt.Printf("resume with: agent workflow resume %s\n", state.ID)
+	}
+	if state.Status != workflow.StatusDone {
+		return
+	}
+	// Steps nothing depends on are the workflow's results.
+	needed := map[string]bool{}
+	for _, step := range state.Workflow.Steps {
+		for _, dep := range step.DependsOn {
+			needed[dep] = true
+		}
+	}
+	for _, step := range state.Workflow.Steps {
+		if !needed[step.ID] {
+			fmt.Printf("\nFinal Output (%s):\n%s\n", step.ID, state.Steps[step.ID].Output)
+		}
+	}
+}
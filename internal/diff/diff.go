@@ -0,0 +1,178 @@
+// Package diff renders line-based unified diffs of text, as diff -u and
+// git diff print them.
+package diff
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Context is how many unchanged lines surround each change.
+const Context = 3
+
+// maxTrace bounds the work of finding the shortest edit script, in saved
+// diagonals; past it, texts that differ too much are shown as one
+// replacement.
+const maxTrace = 1 << 22
+
+// op is one line of an edit script: kept (' '), deleted ('-'), or
+// inserted ('+'). Lines keep their newline, if any.
+type op struct {
+	kind byte
+	line string
+}
+
+// Unified returns the unified diff turning old into new, with path in its
+// file headers, or "" when they are equal. An empty old is shown as a new
+// file.
+func Unified(path, old, new string) string {
+	if old == new {
+		return ""
+	}
+	ops := edits(splitLines(old), splitLines(new))
+	var b strings.Builder
+	from := "a/" + path
+	if old == "" {
+		from = "/dev/null"
+	}
+	fmt.Fprintf(&b, "--- %s\n+++ b/%s\n", from, path)
+	// aPos and bPos are the lines of old and new before each op.
+	aPos, bPos := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	for i, o := range ops {
+		aPos[i+1], bPos[i+1] = aPos[i], bPos[i]
+		if o.kind != '+' {
+			aPos[i+1]++
+		}
+		if o.kind != '-' {
+			bPos[i+1]++
+		}
+	}
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		start := max(i-Context, 0)
+		end := i
+		for {
+			for end < len(ops) && ops[end].kind != ' ' {
+				end++
+			}
+			run := 0
+			for end+run < len(ops) && ops[end+run].kind == ' ' {
+				run++
+			}
+			if end+run == len(ops) || run > 2*Context {
+				end += min(run, Context)
+				break
+			}
+			end += run
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(aPos[start], aPos[end]-aPos[start]), hunkRange(bPos[start], bPos[end]-bPos[start]))
+		for _, o := range ops[start:end] {
+			b.WriteByte(o.kind)
+			b.WriteString(o.line)
+			if !strings.HasSuffix(o.line, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return b.String()
+}
+
+// hunkRange formats the range of a hunk header: the first line, from 1,
+// and the count when it is not 1. An empty range names the line before it.
+func hunkRange(before, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", before)
+	case 1:
+		return fmt.Sprint(before + 1)
+	}
+	return fmt.Sprintf("%d,%d", before+1, count)
+}
+
+// splitLines splits text after each newline.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// edits returns a shortest edit script turning a into b, found with
+// Myers' algorithm.
+func edits(a, b []string) []op {
+	n, m := len(a), len(b)
+	offset := n + m
+	v := make([]int, 2*offset+2)
+	var trace [][]int
+	for d := 0; d <= n+m; d++ {
+		if (d+1)*len(v) > maxTrace {
+			return replaceAll(a, b)
+		}
+		trace = append(trace, slices.Clone(v))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(a, b, trace, offset)
+			}
+		}
+	}
+	return replaceAll(a, b)
+}
+
+// backtrack walks the saved diagonals back from the end of both texts.
+func backtrack(a, b []string, trace [][]int, offset int) []op {
+	var ops []op
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, op{' ', a[x-1]})
+			x, y = x-1, y-1
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, op{'+', b[y-1]})
+			} else {
+				ops = append(ops, op{'-', a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	slices.Reverse(ops)
+	return ops
+}
+
+// replaceAll deletes every line of a and inserts every line of b.
+func replaceAll(a, b []string) []op {
+	ops := make([]op, 0, len(a)+len(b))
+	for _, line := range a {
+		ops = append(ops, op{'-', line})
+	}
+	for _, line := range b {
+		ops = append(ops, op{'+', line})
+	}
+	return ops
+}
@@ -0,0 +1,57 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedGroupsNearbyChangesIntoHunks(t *testing.T) {
+	var old []string
+	for i := 1; i <= 20; i++ {
+		old = append(old, fmt.Sprintf("line %d", i))
+	}
+	new := append([]string(nil), old...)
+	new[1] = "line two"
+	new[3] = "line four"
+	new = append(new[:15], new[16:]...) // drop line 16
+	got := Unified("notes.txt", strings.Join(old, "\n")+"\n", strings.Join(new, "\n")+"\n")
+	want := `--- a/notes.txt
++++ b/notes.txt
+@@ -1,7 +1,7 @@
+ line 1
+-line 2
++line two
+ line 3
+-line 4
++line four
+ line 5
+ line 6
+ line 7
+@@ -13,7 +13,6 @@
+ line 13
+ line 14
+ line 15
+-line 16
+ line 17
+ line 18
+ line 19
+`
+	if got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestUnifiedNewFilesAndMissingNewlines(t *testing.T) {
+	if got, want := Unified("a.go", "", "package a\n"), "--- /dev/null\n+++ b/a.go\n@@ -0,0 +1 @@\n+package a\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	got := Unified("a.txt", "x\ny", "x\ny\n")
+	want := "--- a/a.txt\n+++ b/a.txt\n@@ -1,2 +1,2 @@\n x\n-y\n\\ No newline at end of file\n+y\n"
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if got := Unified("a.txt", "same\n", "same\n"); got != "" {
+		t.Fatalf("expected no diff for equal texts, got %q", got)
+	}
+}
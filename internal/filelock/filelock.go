@@ -0,0 +1,27 @@
+// Package filelock takes advisory locks on files, so processes sharing a
+// file under ~/.agent take turns writing it.
+package filelock
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Lock takes an exclusive lock on path, creating the file and its
+// directory if needed, waiting while another process holds it. The
+// returned func releases the lock. A file that is replaced by renaming
+// cannot be locked itself, so callers lock a sibling such as path+".lock".
+func Lock(path string) (func() error, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := LockFile(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file.Close, nil
+}
@@ -0,0 +1,217 @@
+// Package guardrails scans text for leaked credentials, personal data,
+// phrases a profile denies, and instructions injected into untrusted
+// content.
+//
+// Scanning is regular expressions plus an entropy check for credentials no
+// pattern knows: a long token mixing upper- and lowercase letters and
+// digits, with the spread of characters of random data, is reported as a
+// secret. Hex strings such as commit hashes and UUIDs are never flagged by
+// entropy alone.
+package guardrails
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/bitop-dev/agent/pkg/profile"
+)
+
+// Kinds of finding.
+const (
+	KindSecret  = "secret"
+	KindPII     = "pii"
+	KindPattern = "pattern"
+	KindDeny    = "deny"
+)
+
+// Finding is one match in scanned text, as the byte range [Start, End).
+type Finding struct {
+	Kind  string `json:"kind"`
+	Rule  string `json:"rule"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// rule finds matches of re. When re has a capture group, the text it
+// captures is a readable prefix kept out of the finding, as the name of a
+// key=value pair. valid, when set, rejects matches the expression cannot.
+type rule struct {
+	kind, name string
+	re         *regexp.Regexp
+	valid      func(string) bool
+}
+
+var secretRules = []rule{
+	{KindSecret, "api-key", regexp.MustCompile(`sk-(?:ant-)?[A-Za-z0-9_\-]{16,}`), nil},
+	{KindSecret, "aws-access-key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`), nil},
+	{KindSecret, "github-token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{40,})\b`), nil},
+	{KindSecret, "slack-token", regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`), nil},
+	{KindSecret, "private-key", regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?(?:-----END [A-Z ]*PRIVATE KEY-----|$)`), nil},
+	{KindSecret, "bearer-token", regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/\-]{16,}=*`), nil},
+	{KindSecret, "assignment", regexp.MustCompile(`(?i)((?:api[_-]?key|access[_-]?token|auth[_-]?token|client[_-]?secret|secret[_-]?key|password|passwd)["']?\s*[:=]\s*["']?)[^\s"',}]{6,}`), nil},
+}
+
+var piiRules = []rule{
+	{KindPII, "email", regexp.MustCompile(`\b[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}\b`), nil},
+	{KindPII, "us-ssn", regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`), nil},
+	{KindPII, "card-number", regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), luhn},
+	{KindPII, "phone", regexp.MustCompile(`(?:\+\d{1,3}[ .\-]?)?\(?\b\d{3}\)?[ .\-]\d{3}[ .\-]\d{4}\b`), nil},
+}
+
+// entropyToken finds candidates for the entropy check.
+var entropyToken = regexp.MustCompile(`[A-Za-z0-9+/_\-]{24,}={0,2}`)
+
+// minEntropy is the least Shannon entropy, in bits per character, of a
+// token reported as a secret. English identifiers stay well below it;
+// random base64 of this length is above it.
+const minEntropy = 4.0
+
+// Scanner finds the findings a GuardrailSpec asks for.
+type Scanner struct {
+	rules   []rule
+	entropy bool
+}
+
+// New returns a scanner for spec. It fails on a pattern that does not
+// compile.
+func New(spec profile.GuardrailSpec) (*Scanner, error) {
+	s := &Scanner{entropy: spec.Secrets}
+	if spec.Secrets {
+		s.rules = append(s.rules, secretRules...)
+	}
+	if spec.PII {
+		s.rules = append(s.rules, piiRules...)
+	}
+	for i, pattern := range spec.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("guardrail pattern %d: %w", i+1, err)
+		}
+		s.rules = append(s.rules, rule{kind: KindPattern, name: pattern, re: re})
+	}
+	for _, phrase := range spec.Deny {
+		if strings.TrimSpace(phrase) == "" {
+			continue
+		}
+		re := regexp.MustCompile(`(?i)` + regexp.QuoteMeta(phrase))
+		s.rules = append(s.rules, rule{kind: KindDeny, name: phrase, re: re})
+	}
+	return s, nil
+}
+
+// Scan returns the findings in text, in order and without overlaps; of
+// two overlapping matches the one starting first, or else the longer, is
+// kept.
+func (s *Scanner) Scan(text string) []Finding {
+	var findings []Finding
+	for _, r := range s.rules {
+		for _, m := range r.re.FindAllStringSubmatchIndex(text, -1) {
+			start, end := m[0], m[1]
+			if len(m) >= 4 && m[2] >= 0 {
+				start = m[3]
+			}
+			if start == end || (r.valid != nil && !r.valid(text[start:end])) {
+				continue
+			}
+			findings = append(findings, Finding{Kind: r.kind, Rule: r.name, Start: start, End: end})
+		}
+	}
+	if s.entropy {
+		for _, m := range entropyToken.FindAllStringIndex(text, -1) {
+			if randomLooking(text[m[0]:m[1]]) {
+				findings = append(findings, Finding{Kind: KindSecret, Rule: "high-entropy", Start: m[0], End: m[1]})
+			}
+		}
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].Start != findings[j].Start {
+			return findings[i].Start < findings[j].Start
+		}
+		return findings[i].End > findings[j].End
+	})
+	kept := findings[:0]
+	for _, f := range findings {
+		if len(kept) > 0 && f.Start < kept[len(kept)-1].End {
+			continue
+		}
+		kept = append(kept, f)
+	}
+	return kept
+}
+
+// Redact returns text with each finding replaced by a marker naming its
+// kind, as "[REDACTED:secret]". The findings must come from Scan on text.
+func Redact(text string, findings []Finding) string {
+	var b strings.Builder
+	last := 0
+	for _, f := range findings {
+		b.WriteString(text[last:f.Start])
+		b.WriteString("[REDACTED:" + f.Kind + "]")
+		last = f.End
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// randomLooking reports whether token mixes cases and digits, is not hex,
+// and has the entropy of random data.
+func randomLooking(token string) bool {
+	var upper, lower, digit, hex bool
+	hex = true
+	for _, r := range token {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		}
+		if !strings.ContainsRune("0123456789abcdefABCDEF-", r) {
+			hex = false
+		}
+	}
+	return upper && lower && digit && !hex && entropy(token) >= minEntropy
+}
+
+// entropy is the Shannon entropy of s in bits per character.
+func entropy(s string) float64 {
+	counts := map[rune]int{}
+	n := 0
+	for _, r := range s {
+		counts[r]++
+		n++
+	}
+	var h float64
+	for _, c := range counts {
+		p := float64(c) / float64(n)
+		h -= p * math.Log2(p)
+	}
+	return h
+}
+
+// luhn reports whether the digits of s pass the Luhn check card numbers
+// carry.
+func luhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
@@ -5,8 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -16,9 +14,11 @@ import (
 	profileloader "github.com/bitop-dev/agent/internal/profile"
 	"github.com/bitop-dev/agent/internal/registry"
 	internalruntime "github.com/bitop-dev/agent/internal/runtime"
+	"github.com/bitop-dev/agent/internal/sysprompt"
 	"github.com/bitop-dev/agent/pkg/approval"
 	"github.com/bitop-dev/agent/pkg/events"
 	pkghost "github.com/bitop-dev/agent/pkg/host"
+	"github.com/bitop-dev/agent/pkg/provider"
 	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
 	"github.com/bitop-dev/agent/pkg/tool"
 	"github.com/bitop-dev/agent/pkg/workspace"
@@ -37,6 +37,15 @@ type RuntimeCapabilities struct {
 	DefaultCWD   string
 	MaxDepth     int
 	currentDepth int
+	// PromptRenderer overrides system prompt layout for sub-agents (optional).
+	PromptRenderer sysprompt.Renderer
+	// Ledger lets sub-agents count toward the daily budget (optional).
+	Ledger pkgruntime.SpendLedger
+	// Audit records the tool calls of sub-agents (optional).
+	Audit pkgruntime.AuditLog
+	// Embedder backs the embedding tool selection strategy (optional).
+	Embedder       provider.Embedder
+	EmbeddingModel string
 }
 
 // subAgentSink forwards sub-agent events to the parent sink with a prefix
@@ -174,7 +183,18 @@ func (c *RuntimeCapabilities) SpawnSubRun(ctx context.Context, req pkghost.SubRu
 	}
 	enabled := manifest.Spec.Tools.Enabled
 	if len(req.AllowedTools) > 0 {
-		enabled = intersect(enabled, req.AllowedTools)
+		custom := make(map[string][]string, len(c.Config.Tools.Presets))
+		for name, preset := range c.Config.Tools.Presets {
+			custom[name] = preset.Tools
+		}
+		allowed, err := tool.ExpandPresetsWith(req.AllowedTools, custom)
+		if err != nil {
+			return pkghost.SubRunResult{}, fmt.Errorf("spawn-sub-agent: %w", err)
+		}
+		enabled = intersect(enabled, allowed)
+	}
+	if enabled, err = c.Config.Tools.Filter(enabled, c.Tools.QualifiedID); err != nil {
+		return pkghost.SubRunResult{}, fmt.Errorf("spawn-sub-agent: %w", err)
 	}
 	toolsForRun, err := resolveTools(c.Tools, enabled)
 	if err != nil {
@@ -183,7 +203,7 @@ func (c *RuntimeCapabilities) SpawnSubRun(ctx context.Context, req pkghost.SubRu
 	workspaceRef, _ := workspace.Resolve(c.DefaultCWD)
 	policyEngine := internalpolicy.Engine{
 		Workspace: workspaceRef,
-		ReadOnly:  internalpolicy.IsReadOnly(manifest.Spec.Workspace.WriteScope, manifest.Spec.Tools.Enabled),
+		ReadOnly:  internalpolicy.IsReadOnly(manifest.Spec.Workspace.WriteScope, manifest.Spec.Tools.Enabled, c.Tools),
 	}
 	// Sub-agents always use deny-all approval to prevent runaway nested approvals.
 	approvalResolver := denyAllResolver{}
@@ -196,16 +216,41 @@ func (c *RuntimeCapabilities) SpawnSubRun(ctx context.Context, req pkghost.SubRu
 		prompt = formatHandoffContext(req.Context) + "\n\n" + req.Task
 	}
 
+	systemPrompt, err := sysprompt.Build(sysprompt.Options{
+		ProfileName:  manifest.Metadata.Name,
+		ProfilePath:  profilePath,
+		Instructions: manifest.Spec.Instructions.System,
+		Sections:     manifest.Spec.Instructions.Sections,
+		ContextFiles: manifest.Spec.Instructions.ContextFiles,
+		Prompts:      c.Prompts,
+		CWD:          c.DefaultCWD,
+		Tools:        toolsForRun,
+		Renderer:     c.PromptRenderer,
+	})
+	if err != nil {
+		return pkghost.SubRunResult{}, fmt.Errorf("spawn-sub-agent: %w", err)
+	}
+
+	selector, err := internalruntime.NewToolSelector(manifest.Spec.Tools.Select, providerImpl, c.Embedder, c.EmbeddingModel)
+	if err != nil {
+		return pkghost.SubRunResult{}, fmt.Errorf("spawn-sub-agent: %w", err)
+	}
+
 	runReq := pkgruntime.RunRequest{
-		Prompt:        prompt,
-		SystemPrompt:  loadSystemPrompt(profilePath, manifest.Spec.Instructions.System, c.Prompts),
-		Profile:       manifest,
-		Provider:      providerImpl,
-		Tools:         toolsForRun,
-		Policy:        policyEngine,
-		Approvals:     approvalResolver,
-		Events:        eventSink,
-		ModelOverride: config.ResolveModel(c.Config, manifest.Spec.Provider.Default, manifest.Metadata.Name, manifest.Spec.Provider.Model, ""),
+		Prompt:         prompt,
+		SystemPrompt:   systemPrompt,
+		Profile:        manifest,
+		Provider:       providerImpl,
+		Tools:          toolsForRun,
+		Policy:         policyEngine,
+		Approvals:      approvalResolver,
+		Events:         eventSink,
+		Ledger:         c.Ledger,
+		Audit:          c.Audit,
+		ToolSelector:   selector,
+		ModelOverride:  config.ResolveModel(c.Config, manifest.Spec.Provider.Default, manifest.Metadata.Name, manifest.Spec.Provider.Model, req.Model),
+		ModelAliases:   c.Config.ModelAliases,
+		ModelFallbacks: c.Config.ModelFallbacks,
 		Execution: pkgruntime.ExecutionContext{
 			CWD:        c.DefaultCWD,
 			ProfileRef: profilePath,
@@ -521,31 +566,3 @@ func resolveTools(reg *registry.ToolRegistry, ids []string) ([]tool.Tool, error)
 	}
 	return out, nil
 }
-
-func loadSystemPrompt(profilePath string, refs []string, prompts *registry.PromptRegistry) string {
-	baseDir := filepath.Dir(profilePath)
-	chunks := make([]string, 0, len(refs))
-	for _, ref := range refs {
-		// 1. Try as a registered plugin prompt ID.
-		if prompts != nil {
-			if asset, ok := prompts.Get(ref); ok && asset.Path != "" {
-				if data, err := os.ReadFile(asset.Path); err == nil {
-					chunks = append(chunks, strings.TrimSpace(string(data)))
-					continue
-				}
-			}
-		}
-		// 2. Try as a file path relative to the profile directory.
-		candidate := ref
-		if !filepath.IsAbs(candidate) {
-			candidate = filepath.Join(baseDir, candidate)
-		}
-		if data, err := os.ReadFile(candidate); err == nil {
-			chunks = append(chunks, strings.TrimSpace(string(data)))
-			continue
-		}
-		// 3. Use as inline literal text.
-		chunks = append(chunks, ref)
-	}
-	return strings.Join(chunks, "\n\n")
-}
@@ -0,0 +1,165 @@
+// Package language guesses which natural language a message is written in.
+//
+// Detection is deliberately small: non-Latin scripts are identified by
+// their Unicode ranges, and Latin-script languages by counting common
+// function words. That is enough to tell which language to answer in
+// without a model call or a large n-gram table, and it declines to guess
+// on short or ambiguous text.
+package language
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Language is a detected language.
+type Language struct {
+	Code string // ISO 639-1, e.g. "es"
+	Name string // English name, e.g. "Spanish"
+}
+
+// minLetters is the least text, in letters, worth guessing from; "ok" or
+// "yes" says nothing about the conversation's language.
+const minLetters = 12
+
+var names = map[string]string{
+	"ar": "Arabic", "de": "German", "el": "Greek", "en": "English", "es": "Spanish",
+	"fa": "Persian", "fr": "French", "he": "Hebrew", "hi": "Hindi", "it": "Italian",
+	"ja": "Japanese", "ko": "Korean", "nl": "Dutch", "pl": "Polish", "pt": "Portuguese",
+	"ru": "Russian", "sv": "Swedish", "th": "Thai", "tr": "Turkish", "uk": "Ukrainian",
+	"zh": "Chinese",
+}
+
+// stopwords are frequent function words that rarely appear in the other
+// listed languages. Code and identifiers mostly miss them, so a message
+// that is mostly a stack trace still detects by its prose.
+var stopwords = map[string][]string{
+	"en": {"the", "and", "is", "are", "of", "to", "in", "that", "it", "with", "for", "this", "what", "how", "can", "you", "please", "why", "not", "be"},
+	"es": {"el", "la", "los", "las", "de", "que", "y", "es", "en", "por", "para", "con", "una", "del", "cómo", "qué", "pero", "está", "puedes", "esto"},
+	"fr": {"le", "la", "les", "de", "des", "et", "est", "une", "pour", "que", "qui", "dans", "pas", "ce", "avec", "sur", "vous", "je", "comment", "pourquoi"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "ein", "eine", "mit", "für", "den", "dem", "ich", "sie", "wie", "warum", "auf", "kannst", "bitte", "auch"},
+	"pt": {"o", "os", "as", "de", "que", "e", "é", "não", "um", "uma", "para", "com", "do", "da", "em", "como", "por", "você", "isso", "está"},
+	"it": {"il", "lo", "gli", "di", "che", "e", "è", "non", "un", "una", "per", "con", "del", "della", "come", "perché", "questo", "sono", "puoi", "anche"},
+	"nl": {"de", "het", "een", "en", "is", "niet", "van", "dat", "met", "voor", "op", "ik", "je", "hoe", "waarom", "kun", "ook", "maar", "dit", "zijn"},
+	"sv": {"och", "är", "att", "det", "som", "en", "ett", "inte", "för", "med", "på", "jag", "du", "hur", "varför", "kan", "den", "av", "till", "om"},
+	"pl": {"i", "w", "nie", "się", "na", "jest", "że", "to", "do", "z", "jak", "co", "czy", "dlaczego", "jestem", "mogę", "ten", "ale", "proszę", "być"},
+	"tr": {"ve", "bir", "bu", "için", "ile", "de", "da", "ne", "nasıl", "neden", "değil", "mi", "mı", "çok", "daha", "olarak", "var", "yok", "ben", "sen"},
+}
+
+// Detect returns the language text is written in, or false when the text
+// is too short or no language clearly dominates.
+func Detect(text string) (Language, bool) {
+	scripts := map[string]int{}
+	letters := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		scripts[script(r)]++
+	}
+	if letters == 0 {
+		return Language{}, false
+	}
+	// Japanese mixes kana with Han; any meaningful amount of kana decides it.
+	if scripts["kana"] > 0 && scripts["kana"]*10 >= scripts["han"]+scripts["kana"] {
+		return lang("ja"), true
+	}
+	for _, s := range []string{"han", "hangul", "cyrillic", "arabic", "hebrew", "greek", "devanagari", "thai"} {
+		if scripts[s]*2 < letters {
+			continue
+		}
+		// Ideographs carry a word each; a few are enough.
+		if s != "han" && s != "hangul" && scripts[s] < minLetters {
+			return Language{}, false
+		}
+		switch s {
+		case "han":
+			return lang("zh"), true
+		case "hangul":
+			return lang("ko"), true
+		case "cyrillic":
+			if strings.ContainsAny(text, "іїєґІЇЄҐ") {
+				return lang("uk"), true
+			}
+			return lang("ru"), true
+		case "arabic":
+			if strings.ContainsAny(text, "پچژگ") {
+				return lang("fa"), true
+			}
+			return lang("ar"), true
+		case "hebrew":
+			return lang("he"), true
+		case "greek":
+			return lang("el"), true
+		case "devanagari":
+			return lang("hi"), true
+		case "thai":
+			return lang("th"), true
+		}
+	}
+	if scripts["latin"] < minLetters {
+		return Language{}, false
+	}
+	return detectLatin(text)
+}
+
+func detectLatin(text string) (Language, bool) {
+	counts := map[string]int{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		for code, words := range stopwords {
+			for _, w := range words {
+				if w == word {
+					counts[code]++
+					break
+				}
+			}
+		}
+	}
+	best, top, runnerUp := "", 0, 0
+	for code, n := range counts {
+		switch {
+		case n > top:
+			best, top, runnerUp = code, n, top
+		case n > runnerUp:
+			runnerUp = n
+		}
+	}
+	// Require a couple of hits and a clear lead; closely related languages
+	// share many function words.
+	if top < 2 || top*2 < runnerUp*3 || top == runnerUp {
+		return Language{}, false
+	}
+	return lang(best), true
+}
+
+func lang(code string) Language {
+	return Language{Code: code, Name: names[code]}
+}
+
+func script(r rune) string {
+	switch {
+	case unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r):
+		return "kana"
+	case unicode.Is(unicode.Han, r):
+		return "han"
+	case unicode.Is(unicode.Hangul, r):
+		return "hangul"
+	case unicode.Is(unicode.Cyrillic, r):
+		return "cyrillic"
+	case unicode.Is(unicode.Arabic, r):
+		return "arabic"
+	case unicode.Is(unicode.Hebrew, r):
+		return "hebrew"
+	case unicode.Is(unicode.Greek, r):
+		return "greek"
+	case unicode.Is(unicode.Devanagari, r):
+		return "devanagari"
+	case unicode.Is(unicode.Thai, r):
+		return "thai"
+	case unicode.Is(unicode.Latin, r):
+		return "latin"
+	default:
+		return "other"
+	}
+}
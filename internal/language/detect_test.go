@@ -0,0 +1,36 @@
+package language
+
+import "testing"
+
+func TestDetect(t *testing.T) {
+	cases := map[string]string{
+		"Can you explain what this function does and why the test is failing?":         "en",
+		"¿Cómo puedo leer el archivo de configuración en este proyecto?":               "es",
+		"Pourquoi est-ce que le test échoue dans la branche principale ?":              "fr",
+		"Kannst du mir bitte erklären, warum der Build nicht funktioniert?":            "de",
+		"Você pode explicar como o cache funciona para mim? Não está claro.":           "pt",
+		"Почему этот тест падает после обновления зависимостей?":                       "ru",
+		"Чому цей тест не проходить після оновлення залежностей?":                      "uk",
+		"このテストが失敗する理由を説明してください":                                                        "ja",
+		"请解释一下为什么这个测试会失败":                                                              "zh",
+		"이 테스트가 실패하는 이유를 설명해 주세요":                                                      "ko",
+		"Leg uit waarom de build niet werkt, ik snap het echt niet en het is dringend": "nl",
+	}
+	for text, want := range cases {
+		got, ok := Detect(text)
+		if !ok || got.Code != want {
+			t.Errorf("Detect(%q) = %q, %v; want %q", text, got.Code, ok, want)
+		}
+		if ok && got.Name == "" {
+			t.Errorf("Detect(%q): missing language name", text)
+		}
+	}
+}
+
+func TestDetectDeclinesShortOrAmbiguousText(t *testing.T) {
+	for _, text := range []string{"ok", "thanks!", "go test ./...", "", "fmt.Println(x) // 42"} {
+		if got, ok := Detect(text); ok {
+			t.Errorf("Detect(%q) = %q; expected no guess", text, got.Code)
+		}
+	}
+}
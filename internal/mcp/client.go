@@ -5,15 +5,27 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"os/exec"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
+	"time"
 )
 
+// ErrServerExited reports that a stdio MCP server process is gone, either
+// because it crashed or because it was closed.
+var ErrServerExited = errors.New("mcp: server process exited")
+
+// shutdownGrace is how long Close waits for the server to exit after each
+// shutdown step before escalating.
+const shutdownGrace = 2 * time.Second
+
 // Client speaks the MCP JSON-RPC protocol over stdio or an HTTP connection.
 type Client struct {
 	cmd        *exec.Cmd
@@ -24,6 +36,10 @@ type Client struct {
 	headers    map[string]string
 	mu         sync.Mutex
 	nextID     atomic.Int64
+
+	readerOnce sync.Once
+	responses  chan response // lines read from stdout; closed at EOF
+	exited     chan struct{} // closed once the server process has been reaped
 }
 
 type request struct {
@@ -64,12 +80,13 @@ type ContentBlock struct {
 }
 
 // StartStdio spawns the given command as an MCP server and performs the
-// initialize handshake. Returns a ready-to-use Client.
+// initialize handshake. Returns a ready-to-use Client. ctx bounds the
+// handshake only: the process lives until Close, not until ctx ends.
 func StartStdio(ctx context.Context, command []string, env []string) (*Client, error) {
 	if len(command) == 0 {
 		return nil, fmt.Errorf("mcp: command is required")
 	}
-	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd := exec.Command(command[0], command[1:]...)
 	if len(env) > 0 {
 		cmd.Env = env
 	}
@@ -88,7 +105,12 @@ func StartStdio(ctx context.Context, command []string, env []string) (*Client, e
 		cmd:    cmd,
 		stdin:  stdin,
 		stdout: bufio.NewReader(stdout),
+		exited: make(chan struct{}),
 	}
+	go func() {
+		_ = cmd.Wait()
+		close(c.exited)
+	}()
 	if err := c.initialize(ctx); err != nil {
 		_ = c.Close()
 		return nil, fmt.Errorf("mcp: initialize: %w", err)
@@ -158,17 +180,58 @@ func (c *Client) CallTool(ctx context.Context, name string, arguments map[string
 	return CallResult{Content: blocks, IsError: result.IsError}, nil
 }
 
-// Close stops the MCP server process.
+// Close shuts the server down. Closing stdin is the stdio transport's
+// shutdown signal; a server that has not exited within shutdownGrace gets
+// SIGTERM, and one that still has not exited is killed.
 func (c *Client) Close() error {
 	if c.stdin != nil {
 		_ = c.stdin.Close()
 	}
-	if c.cmd != nil && c.cmd.Process != nil {
+	if c.cmd == nil || c.cmd.Process == nil {
+		return nil
+	}
+	if c.exited == nil {
 		return c.cmd.Process.Kill()
 	}
+	if c.waitExit(shutdownGrace) {
+		return nil
+	}
+	_ = c.cmd.Process.Signal(syscall.SIGTERM)
+	if c.waitExit(shutdownGrace) {
+		return nil
+	}
+	if err := c.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+	<-c.exited
 	return nil
 }
 
+// Exited reports whether a stdio server process has exited.
+func (c *Client) Exited() bool {
+	return c.waitExit(0)
+}
+
+func (c *Client) waitExit(timeout time.Duration) bool {
+	if c.exited == nil {
+		return false
+	}
+	if timeout <= 0 {
+		select {
+		case <-c.exited:
+			return true
+		default:
+			return false
+		}
+	}
+	select {
+	case <-c.exited:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
 func (c *Client) initialize(ctx context.Context) error {
 	params := map[string]any{
 		"protocolVersion": "2024-11-05",
@@ -198,32 +261,55 @@ func (c *Client) call(ctx context.Context, method string, params any, result any
 	if err != nil {
 		return err
 	}
+	c.readerOnce.Do(func() {
+		c.responses = make(chan response, 16)
+		go c.readLoop()
+	})
+	if c.Exited() {
+		return ErrServerExited
+	}
 	if _, err := fmt.Fprintf(c.stdin, "%s\n", data); err != nil {
-		return fmt.Errorf("mcp write: %w", err)
+		// A broken pipe means the server is gone.
+		return fmt.Errorf("mcp write: %w: %v", ErrServerExited, err)
 	}
+	// Responses to calls abandoned by a cancelled context arrive later and
+	// are skipped by ID.
 	for {
-		line, err := c.stdout.ReadString('\n')
-		if err != nil {
-			return fmt.Errorf("mcp read: %w", err)
-		}
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-		var resp response
-		if err := json.Unmarshal([]byte(line), &resp); err != nil {
-			continue
-		}
-		if resp.ID != id {
-			continue
+		select {
+		case resp, ok := <-c.responses:
+			if !ok {
+				return fmt.Errorf("mcp read: %w", ErrServerExited)
+			}
+			if resp.ID != id {
+				continue
+			}
+			if resp.Error != nil {
+				return fmt.Errorf("mcp error %d: %s", resp.Error.Code, resp.Error.Message)
+			}
+			if result != nil {
+				return json.Unmarshal(resp.Result, result)
+			}
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
 		}
-		if resp.Error != nil {
-			return fmt.Errorf("mcp error %d: %s", resp.Error.Code, resp.Error.Message)
+	}
+}
+
+// readLoop forwards JSON-RPC messages from the server's stdout until EOF.
+func (c *Client) readLoop() {
+	defer close(c.responses)
+	for {
+		line, err := c.stdout.ReadString('\n')
+		if line = strings.TrimSpace(line); line != "" {
+			var resp response
+			if json.Unmarshal([]byte(line), &resp) == nil {
+				c.responses <- resp
+			}
 		}
-		if result != nil {
-			return json.Unmarshal(resp.Result, result)
+		if err != nil {
+			return
 		}
-		return nil
 	}
 }
 
@@ -2,6 +2,7 @@ package mcp
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -13,13 +14,20 @@ import (
 )
 
 // Manager owns MCP client lifecycle. One client per enabled MCP plugin.
+// A stdio server that crashes is restarted the next time it is used.
 type Manager struct {
 	mu      sync.Mutex
-	clients map[string]*Client
+	clients map[string]*managedClient
+}
+
+type managedClient struct {
+	client   *Client
+	manifest plg.Manifest
+	cfg      config.PluginConfig
 }
 
 func NewManager() *Manager {
-	return &Manager{clients: make(map[string]*Client)}
+	return &Manager{clients: make(map[string]*managedClient)}
 }
 
 // Tools discovers and returns tools from the MCP server for the given plugin manifest.
@@ -28,34 +36,82 @@ func (m *Manager) Tools(ctx context.Context, manifest plg.Manifest, cfg config.P
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	name := manifest.Metadata.Name
-	client, ok := m.clients[name]
+	entry, ok := m.clients[name]
 	if !ok {
-		var err error
-		client, err = m.start(ctx, manifest, cfg)
+		client, err := m.start(ctx, manifest, cfg)
 		if err != nil {
 			return nil, fmt.Errorf("mcp plugin %s: %w", name, err)
 		}
-		m.clients[name] = client
+		entry = &managedClient{client: client, manifest: manifest, cfg: cfg}
+		m.clients[name] = entry
+	}
+	infos, err := entry.client.ListTools(ctx)
+	if errors.Is(err, ErrServerExited) {
+		if _, err = m.restartLocked(ctx, name, entry.client); err == nil {
+			infos, err = entry.client.ListTools(ctx)
+		}
 	}
-	infos, err := client.ListTools(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("mcp plugin %s list tools: %w", name, err)
 	}
 	tools := make([]tool.Tool, 0, len(infos))
 	for _, info := range infos {
-		tools = append(tools, NewTool(info, client))
+		tools = append(tools, &Tool{info: info, client: entry.client, manager: m, plugin: name})
 	}
 	return tools, nil
 }
 
-// Close shuts down all managed MCP clients.
+// Close shuts down all managed MCP clients, giving each server the shutdown
+// grace period in parallel.
 func (m *Manager) Close() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	for _, client := range m.clients {
-		_ = client.Close()
+	var wg sync.WaitGroup
+	for _, entry := range m.clients {
+		wg.Add(1)
+		go func(client *Client) {
+			defer wg.Done()
+			_ = client.Close()
+		}(entry.client)
+	}
+	wg.Wait()
+	m.clients = make(map[string]*managedClient)
+}
+
+// client returns the plugin's current client.
+func (m *Manager) client(name string) (*Client, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.clients[name]
+	if !ok {
+		return nil, false
+	}
+	return entry.client, true
+}
+
+// restart replaces a crashed client. When another caller already replaced
+// failed, the current client is returned without starting a new process.
+func (m *Manager) restart(ctx context.Context, name string, failed *Client) (*Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.restartLocked(ctx, name, failed)
+}
+
+func (m *Manager) restartLocked(ctx context.Context, name string, failed *Client) (*Client, error) {
+	entry, ok := m.clients[name]
+	if !ok {
+		return nil, fmt.Errorf("mcp plugin %s is not running", name)
+	}
+	if entry.client != failed {
+		return entry.client, nil
+	}
+	_ = failed.Close()
+	client, err := m.start(ctx, entry.manifest, entry.cfg)
+	if err != nil {
+		return nil, fmt.Errorf("mcp plugin %s restart: %w", name, err)
 	}
-	m.clients = make(map[string]*Client)
+	entry.client = client
+	return client, nil
 }
 
 func (m *Manager) start(ctx context.Context, manifest plg.Manifest, cfg config.PluginConfig) (*Client, error) {
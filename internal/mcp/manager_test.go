@@ -1,6 +1,21 @@
 package mcp
 
-import "testing"
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/config"
+	plg "github.com/bitop-dev/agent/pkg/plugin"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
 
 func TestResolveStringMap(t *testing.T) {
 	tests := []struct {
@@ -59,3 +74,85 @@ func TestMergeStringMaps(t *testing.T) {
 		}
 	}
 }
+
+// TestHelperMCPServer is not a real test: the manager tests re-run the test
+// binary with MCP_TEST_SERVER=1 to get a stdio MCP server process. The
+// "flaky" tool crashes the process on its first call.
+func TestHelperMCPServer(t *testing.T) {
+	if os.Getenv("MCP_TEST_SERVER") != "1" {
+		return
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	encoder := json.NewEncoder(os.Stdout)
+	for scanner.Scan() {
+		var req map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			continue
+		}
+		id, hasID := req["id"]
+		if !hasID {
+			continue
+		}
+		var result any
+		switch req["method"] {
+		case "initialize":
+			result = map[string]any{"protocolVersion": "2024-11-05", "capabilities": map[string]any{}}
+		case "tools/list":
+			result = map[string]any{"tools": []any{map[string]any{"name": "flaky", "inputSchema": map[string]any{"type": "object"}}}}
+		case "tools/call":
+			marker := os.Getenv("MCP_CRASH_MARKER")
+			if _, err := os.Stat(marker); err != nil {
+				_ = os.WriteFile(marker, nil, 0o644)
+				os.Exit(3)
+			}
+			result = map[string]any{"content": []any{map[string]any{"type": "text", "text": fmt.Sprintf("ok from %d", os.Getpid())}}}
+		}
+		_ = encoder.Encode(map[string]any{"jsonrpc": "2.0", "id": id, "result": result})
+	}
+	// stdin closed: the client asked us to shut down.
+	os.Exit(0)
+}
+
+func TestManagerRestartsCrashedServerAndClosesGracefully(t *testing.T) {
+	manifest := plg.Manifest{
+		Metadata: plg.Metadata{Name: "flaky"},
+		Spec: plg.Spec{Runtime: plg.Runtime{
+			Type:    plg.RuntimeMCP,
+			Command: []string{os.Args[0], "-test.run=^TestHelperMCPServer$"},
+			Env:     map[string]string{"MCP_TEST_SERVER": "1", "MCP_CRASH_MARKER": filepath.Join(t.TempDir(), "crashed")},
+		}},
+	}
+	manager := NewManager()
+	tools, err := manager.Tools(context.Background(), manifest, config.PluginConfig{})
+	if err != nil {
+		t.Fatalf("tools: %v", err)
+	}
+	if len(tools) != 1 {
+		t.Fatalf("expected 1 tool, got %d", len(tools))
+	}
+	first, _ := manager.client("flaky")
+
+	result, err := tools[0].Run(context.Background(), tool.Call{ToolID: "flaky"})
+	if err != nil {
+		t.Fatalf("expected the call to be retried after a crash: %v", err)
+	}
+	if !strings.HasPrefix(result.Output, "ok from") || result.Data["plugin_restarted"] != true {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	second, _ := manager.client("flaky")
+	if second == first || !first.Exited() {
+		t.Fatal("expected the crashed server to be replaced")
+	}
+
+	start := time.Now()
+	manager.Close()
+	if !second.Exited() {
+		t.Fatal("expected Close to stop the server")
+	}
+	if elapsed := time.Since(start); elapsed >= shutdownGrace {
+		t.Fatalf("server should exit on stdin close without escalation, took %s", elapsed)
+	}
+	if _, err := second.CallTool(context.Background(), "flaky", nil); !errors.Is(err, ErrServerExited) {
+		t.Fatalf("expected ErrServerExited after Close, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package mcp
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 
@@ -12,6 +13,10 @@ import (
 type Tool struct {
 	info   ToolInfo
 	client *Client
+	// manager and plugin are set for managed servers so a crashed server
+	// can be restarted.
+	manager *Manager
+	plugin  string
 }
 
 func NewTool(info ToolInfo, client *Client) *Tool {
@@ -26,8 +31,27 @@ func (t *Tool) Definition() tool.Definition {
 	}
 }
 
+func (t *Tool) Namespace() string { return tool.NamespaceMCP }
+
+// Run calls the tool. If the server process crashed before or during the
+// call, it is restarted and the call is retried once.
 func (t *Tool) Run(ctx context.Context, call tool.Call) (tool.Result, error) {
-	result, err := t.client.CallTool(ctx, t.info.Name, call.Arguments)
+	client := t.client
+	if t.manager != nil {
+		if current, ok := t.manager.client(t.plugin); ok {
+			client = current
+		}
+	}
+	result, err := client.CallTool(ctx, t.info.Name, call.Arguments)
+	restarted := false
+	if errors.Is(err, ErrServerExited) && t.manager != nil {
+		client, restartErr := t.manager.restart(ctx, t.plugin, client)
+		if restartErr != nil {
+			return tool.Result{}, fmt.Errorf("%w (%v)", err, restartErr)
+		}
+		restarted = true
+		result, err = client.CallTool(ctx, t.info.Name, call.Arguments)
+	}
 	if err != nil {
 		return tool.Result{}, err
 	}
@@ -48,6 +72,9 @@ func (t *Tool) Run(ctx context.Context, call tool.Call) (tool.Result, error) {
 		}
 		data[fmt.Sprintf("content_%d_type", i)] = block.Type
 	}
+	if restarted {
+		data["plugin_restarted"] = true
+	}
 	return tool.Result{
 		ToolID: call.ToolID,
 		Output: strings.Join(parts, "\n"),
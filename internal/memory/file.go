@@ -0,0 +1,182 @@
+package memory
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/memory"
+)
+
+// FileStore keeps a project's notes in a single JSON file. Writes go through
+// a temp file and rename so a crash never leaves a half-written store.
+type FileStore struct {
+	Path string
+	CWD  string // recorded in the file so humans can tell which project it belongs to
+	mu   sync.Mutex
+}
+
+type fileContents struct {
+	CWD   string        `json:"cwd,omitempty"`
+	Notes []memory.Note `json:"notes"`
+}
+
+// ProjectPath returns the memory file for cwd under dir. The cwd is hashed so
+// that any directory maps to a flat, filesystem-safe name.
+func ProjectPath(dir, cwd string) string {
+	sum := sha256.Sum256([]byte(filepath.Clean(cwd)))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+".json")
+}
+
+// ProjectStores opens the FileStore of each project under Dir, one per
+// directory, so that writes to a project's file are serialized.
+type ProjectStores struct {
+	Dir    string
+	mu     sync.Mutex
+	stores map[string]*FileStore
+}
+
+func (p *ProjectStores) For(cwd string) memory.Store {
+	path := ProjectPath(p.Dir, cwd)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if store, ok := p.stores[path]; ok {
+		return store
+	}
+	if p.stores == nil {
+		p.stores = map[string]*FileStore{}
+	}
+	store := &FileStore{Path: path, CWD: filepath.Clean(cwd)}
+	p.stores[path] = store
+	return store
+}
+
+func (s *FileStore) Get(_ context.Context, key string) (memory.Note, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	contents, err := s.load()
+	if err != nil {
+		return memory.Note{}, false, err
+	}
+	for _, note := range contents.Notes {
+		if note.Key == key {
+			return note, true, nil
+		}
+	}
+	return memory.Note{}, false, nil
+}
+
+func (s *FileStore) List(_ context.Context) ([]memory.Note, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	contents, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return contents.Notes, nil
+}
+
+func (s *FileStore) Put(_ context.Context, key, value string) error {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return errors.New("memory key is required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	contents, err := s.load()
+	if err != nil {
+		return err
+	}
+	note := memory.Note{Key: key, Value: value, UpdatedAt: time.Now().UTC()}
+	replaced := false
+	for i := range contents.Notes {
+		if contents.Notes[i].Key == key {
+			contents.Notes[i] = note
+			replaced = true
+		}
+	}
+	if !replaced {
+		contents.Notes = append(contents.Notes, note)
+	}
+	return s.save(contents)
+}
+
+func (s *FileStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	contents, err := s.load()
+	if err != nil {
+		return err
+	}
+	kept := contents.Notes[:0]
+	for _, note := range contents.Notes {
+		if note.Key != key {
+			kept = append(kept, note)
+		}
+	}
+	contents.Notes = kept
+	return s.save(contents)
+}
+
+func (s *FileStore) load() (fileContents, error) {
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fileContents{}, nil
+	}
+	if err != nil {
+		return fileContents{}, err
+	}
+	var contents fileContents
+	if err := json.Unmarshal(data, &contents); err != nil {
+		return fileContents{}, fmt.Errorf("decode memory %s: %w", s.Path, err)
+	}
+	return contents, nil
+}
+
+func (s *FileStore) save(contents fileContents) error {
+	if s.CWD != "" {
+		contents.CWD = s.CWD
+	}
+	sort.Slice(contents.Notes, func(i, j int) bool { return contents.Notes[i].Key < contents.Notes[j].Key })
+	data, err := json.MarshalIndent(contents, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0o755); err != nil {
+		return err
+	}
+	tmp := s.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.Path)
+}
+
+// Summary renders notes as a bullet list for the system prompt, truncated
+// to roughly maxChars so a large memory cannot crowd out the conversation.
+func Summary(notes []memory.Note, maxChars int) string {
+	if len(notes) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("Project memory (from earlier sessions; update with core/memory_write):")
+	for i, note := range notes {
+		line := "\n- " + note.Key + ": " + strings.Join(strings.Fields(note.Value), " ")
+		if maxChars > 0 && b.Len()+len(line) > maxChars {
+			fmt.Fprintf(&b, "\n- … %d more note(s); use core/memory_read to list them", len(notes)-i)
+			break
+		}
+		b.WriteString(line)
+	}
+	return b.String()
+}
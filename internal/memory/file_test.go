@@ -0,0 +1,49 @@
+package memory
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileStorePersistsAcrossInstances(t *testing.T) {
+	ctx := context.Background()
+	path := ProjectPath(t.TempDir(), "/work/project")
+	first := &FileStore{Path: path, CWD: "/work/project"}
+	if err := first.Put(ctx, "tests", "run go test ./..."); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if err := first.Put(ctx, "style", "tabs"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if err := first.Put(ctx, "tests", "run make test"); err != nil {
+		t.Fatalf("overwrite: %v", err)
+	}
+
+	second := &FileStore{Path: path}
+	notes, err := second.List(ctx)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(notes) != 2 || notes[1].Key != "tests" || notes[1].Value != "run make test" {
+		t.Fatalf("unexpected notes: %+v", notes)
+	}
+	if err := second.Delete(ctx, "style"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, ok, _ := first.Get(ctx, "style"); ok {
+		t.Fatal("expected note to be deleted")
+	}
+	summary := Summary(notes, 0)
+	if !strings.Contains(summary, "- tests: run make test") {
+		t.Fatalf("unexpected summary: %s", summary)
+	}
+}
+
+func TestProjectPathIsStablePerDirectory(t *testing.T) {
+	a := ProjectPath("/m", "/work/a/")
+	if a != ProjectPath("/m", "/work/a") || a == ProjectPath("/m", "/work/b") || filepath.Dir(a) != "/m" {
+		t.Fatalf("unexpected project path %s", a)
+	}
+}
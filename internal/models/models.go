@@ -0,0 +1,240 @@
+// Package models is a small built-in catalog of model metadata used for cost
+// estimates, alias resolution, and deprecation notices. Prices are USD per
+// million tokens and are best-effort; unknown models simply report no
+// pricing and resolve to themselves.
+package models
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Pricing is the per-million-token price of a model. CachedInputPerMTok
+// prices input tokens served from the provider's prompt cache, and
+// CacheWritePerMTok those written to it; 0 prices them as other input.
+type Pricing struct {
+	InputPerMTok       float64
+	OutputPerMTok      float64
+	CachedInputPerMTok float64
+	CacheWritePerMTok  float64
+}
+
+// Info describes one catalog entry.
+type Info struct {
+	ID       string
+	Provider string
+	Pricing  Pricing
+	// Thinking is set for models that take a reasoning effort or a
+	// thinking budget.
+	Thinking bool
+	// Sunset is the date the provider retires the model; zero if none is
+	// announced. Replacement is the model to move to.
+	Sunset      time.Time
+	Replacement string
+}
+
+// Deprecated reports whether a retirement date has been announced.
+func (i Info) Deprecated() bool { return !i.Sunset.IsZero() }
+
+var catalog = []Info{
+	{ID: "gpt-4o", Provider: "openai", Pricing: Pricing{2.50, 10.00, 1.25, 0}},
+	{ID: "gpt-4o-mini", Provider: "openai", Pricing: Pricing{0.15, 0.60, 0.075, 0}},
+	{ID: "gpt-4.1", Provider: "openai", Pricing: Pricing{2.00, 8.00, 0.50, 0}},
+	{ID: "gpt-4.1-mini", Provider: "openai", Pricing: Pricing{0.40, 1.60, 0.10, 0}},
+	{ID: "gpt-4.1-nano", Provider: "openai", Pricing: Pricing{0.10, 0.40, 0.025, 0}},
+	{ID: "o3", Provider: "openai", Pricing: Pricing{2.00, 8.00, 0.50, 0}, Thinking: true},
+	{ID: "o4-mini", Provider: "openai", Pricing: Pricing{1.10, 4.40, 0.275, 0}, Thinking: true},
+	{ID: "claude-opus-4", Provider: "anthropic", Pricing: Pricing{15.00, 75.00, 1.50, 18.75}, Thinking: true},
+	{ID: "claude-sonnet-4", Provider: "anthropic", Pricing: Pricing{3.00, 15.00, 0.30, 3.75}, Thinking: true},
+	{ID: "claude-3-7-sonnet", Provider: "anthropic", Pricing: Pricing{3.00, 15.00, 0.30, 3.75}, Thinking: true},
+	{ID: "claude-3-5-sonnet", Provider: "anthropic", Pricing: Pricing{3.00, 15.00, 0.30, 3.75}, Sunset: date(2025, 10, 22), Replacement: "claude-sonnet-4"},
+	{ID: "claude-3-5-haiku", Provider: "anthropic", Pricing: Pricing{0.80, 4.00, 0.08, 1.00}},
+	{ID: "deepseek-chat", Provider: "deepseek", Pricing: Pricing{0.28, 0.42, 0.028, 0}},
+	{ID: "deepseek-reasoner", Provider: "deepseek", Pricing: Pricing{0.28, 0.42, 0.028, 0}},
+	{ID: "llama-3.3-70b-versatile", Provider: "groq", Pricing: Pricing{0.59, 0.79, 0, 0}},
+	{ID: "llama-3.1-8b-instant", Provider: "groq", Pricing: Pricing{0.05, 0.08, 0, 0}},
+	{ID: "meta-llama/llama-4-scout-17b-16e-instruct", Provider: "groq", Pricing: Pricing{0.11, 0.34, 0, 0}},
+	{ID: "meta-llama/llama-4-maverick-17b-128e-instruct", Provider: "groq", Pricing: Pricing{0.20, 0.60, 0, 0}},
+	{ID: "openai/gpt-oss-120b", Provider: "groq", Pricing: Pricing{0.15, 0.60, 0.075, 0}, Thinking: true},
+	{ID: "openai/gpt-oss-20b", Provider: "groq", Pricing: Pricing{0.075, 0.30, 0.0375, 0}, Thinking: true},
+	{ID: "qwen/qwen3-32b", Provider: "groq", Pricing: Pricing{0.29, 0.59, 0, 0}},
+	{ID: "moonshotai/kimi-k2-instruct-0905", Provider: "groq", Pricing: Pricing{1.00, 3.00, 0.50, 0}},
+	{ID: "llama3.1-8b", Provider: "cerebras", Pricing: Pricing{0.10, 0.10, 0, 0}},
+	{ID: "llama-3.3-70b", Provider: "cerebras", Pricing: Pricing{0.85, 1.20, 0, 0}},
+	{ID: "gpt-oss-120b", Provider: "cerebras", Pricing: Pricing{0.35, 0.75, 0, 0}, Thinking: true},
+	{ID: "qwen-3-32b", Provider: "cerebras", Pricing: Pricing{0.40, 0.80, 0, 0}},
+	{ID: "qwen-3-235b-a22b-instruct-2507", Provider: "cerebras", Pricing: Pricing{0.60, 1.20, 0, 0}},
+	{ID: "command-a", Provider: "cohere", Pricing: Pricing{2.50, 10.00, 0, 0}},
+	{ID: "command-a-reasoning", Provider: "cohere", Pricing: Pricing{2.50, 10.00, 0, 0}, Thinking: true},
+	{ID: "command-r-plus", Provider: "cohere", Pricing: Pricing{2.50, 10.00, 0, 0}},
+	{ID: "command-r", Provider: "cohere", Pricing: Pricing{0.15, 0.60, 0, 0}},
+	{ID: "command-r7b", Provider: "cohere", Pricing: Pricing{0.0375, 0.15, 0, 0}},
+}
+
+// aliases maps short names that provider APIs reject to the snapshot they
+// stand for, so profiles can name a model family without a date.
+var aliases = map[string]string{
+	"claude-opus-4":     "claude-opus-4-20250514",
+	"claude-sonnet-4":   "claude-sonnet-4-20250514",
+	"claude-3-7-sonnet": "claude-3-7-sonnet-20250219",
+	"claude-3-5-sonnet": "claude-3-5-sonnet-20241022",
+	"claude-3-5-haiku":  "claude-3-5-haiku-20241022",
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// Resolve returns the model ID to send to the provider for model, and a
+// warning when the model is deprecated. Aliases expand to their snapshot;
+// a model past its sunset with a known replacement resolves to the
+// replacement so existing configs keep working. Provider-qualified names
+// (e.g. anthropic/claude-sonnet-4 on a proxy) are left alone.
+func Resolve(model string, now time.Time) (resolved, warning string) {
+	if strings.Contains(model, "/") {
+		return model, ""
+	}
+	resolved = model
+	if target, ok := aliases[strings.ToLower(strings.TrimSpace(model))]; ok {
+		resolved = target
+	}
+	info, ok := Lookup(resolved)
+	if !ok || !info.Deprecated() {
+		return resolved, ""
+	}
+	sunset := info.Sunset.Format(time.DateOnly)
+	switch {
+	case now.Before(info.Sunset) && info.Replacement != "":
+		return resolved, fmt.Sprintf("model %s is deprecated and will be retired on %s; switch to %s", model, sunset, info.Replacement)
+	case now.Before(info.Sunset):
+		return resolved, fmt.Sprintf("model %s is deprecated and will be retired on %s", model, sunset)
+	case info.Replacement != "":
+		replacement, _ := Resolve(info.Replacement, now)
+		return replacement, fmt.Sprintf("model %s was retired on %s; using %s instead", model, sunset, replacement)
+	default:
+		return resolved, fmt.Sprintf("model %s was retired on %s", model, sunset)
+	}
+}
+
+// Lookup finds a model by exact ID, falling back to the longest catalog ID
+// that prefixes it so dated snapshots (e.g. claude-sonnet-4-20250514) match.
+// A provider-qualified name matches a catalog ID naming the same
+// organization (Groq's openai/gpt-oss-120b), or else is looked up without
+// the qualifier.
+func Lookup(model string) (Info, bool) {
+	return lookup("", model)
+}
+
+// LookupFor is Lookup for a model served by provider: an exact ID matches
+// whichever provider lists it, but the prefix fallback only matches the
+// provider's own entries, so that another provider's shorter ID does not
+// claim a variant it does not list (Cerebras's llama-3.3-70b for a Groq
+// llama-3.3-70b-specdec). A provider the catalog does not know is looked
+// up as by Lookup.
+func LookupFor(provider, model string) (Info, bool) {
+	if !slices.ContainsFunc(catalog, func(info Info) bool { return info.Provider == provider }) {
+		provider = ""
+	}
+	return lookup(provider, model)
+}
+
+// lookup finds model, with the prefix fallback limited to provider's
+// entries unless provider is "".
+func lookup(provider, model string) (Info, bool) {
+	model = strings.ToLower(strings.TrimSpace(model))
+	for _, info := range catalog {
+		if info.ID == model {
+			return info, true
+		}
+	}
+	if i := strings.LastIndex(model, "/"); i >= 0 {
+		model = model[i+1:]
+	}
+	var best Info
+	found := false
+	for _, info := range catalog {
+		if info.ID == model {
+			return info, true
+		}
+		if (provider == "" || info.Provider == provider) && strings.HasPrefix(model, info.ID+"-") && len(info.ID) > len(best.ID) {
+			best, found = info, true
+		}
+	}
+	return best, found
+}
+
+// Serves reports whether a request for model may go to provider: it is
+// false only when the catalog lists model under another of its providers.
+// Provider-qualified names, which gateways serve, and providers the catalog
+// does not know are let through.
+func Serves(provider, model string) bool {
+	if strings.Contains(model, "/") || !slices.ContainsFunc(catalog, func(info Info) bool { return info.Provider == provider }) {
+		return true
+	}
+	info, ok := Lookup(model)
+	return !ok || info.Provider == provider
+}
+
+// SupportsThinking reports whether model, served by provider, takes a
+// thinking level. Models the catalog does not know are assumed to, so a
+// configured level still reaches them.
+func SupportsThinking(provider, model string) bool {
+	info, ok := LookupFor(provider, model)
+	return !ok || info.Thinking
+}
+
+// Cost estimates the USD cost of a call to model, served by provider (""
+// when it is not known). ok is false when the model has no known pricing.
+func Cost(provider, model string, inputTokens, outputTokens int) (usd float64, ok bool) {
+	info, ok := LookupFor(provider, model)
+	if !ok {
+		return 0, false
+	}
+	return float64(inputTokens)/1e6*info.Pricing.InputPerMTok + float64(outputTokens)/1e6*info.Pricing.OutputPerMTok, true
+}
+
+// CacheDiscount is how much less cachedTokens of a call's input cost than
+// Cost charges for them, for models with a cached input price.
+func CacheDiscount(provider, model string, cachedTokens int) float64 {
+	info, ok := LookupFor(provider, model)
+	if !ok || info.Pricing.CachedInputPerMTok == 0 || cachedTokens <= 0 {
+		return 0
+	}
+	return float64(cachedTokens) / 1e6 * (info.Pricing.InputPerMTok - info.Pricing.CachedInputPerMTok)
+}
+
+// CacheWritePremium is how much more cacheWriteTokens of a call's input
+// cost than Cost charges for them, for models with a cache write price.
+func CacheWritePremium(provider, model string, cacheWriteTokens int) float64 {
+	info, ok := LookupFor(provider, model)
+	if !ok || info.Pricing.CacheWritePerMTok == 0 || cacheWriteTokens <= 0 {
+		return 0
+	}
+	return float64(cacheWriteTokens) / 1e6 * (info.Pricing.CacheWritePerMTok - info.Pricing.InputPerMTok)
+}
+
+// CostWithCache is Cost with cacheReadTokens of the input priced at the
+// cached input rate and cacheWriteTokens at the cache write rate, as the
+// runtime prices each turn.
+func CostWithCache(provider, model string, inputTokens, outputTokens, cacheReadTokens, cacheWriteTokens int) (usd float64, ok bool) {
+	usd, ok = Cost(provider, model, inputTokens, outputTokens)
+	if !ok {
+		return 0, false
+	}
+	return usd - CacheDiscount(provider, model, cacheReadTokens) + CacheWritePremium(provider, model, cacheWriteTokens), true
+}
+
+// List returns all catalog entries sorted by provider then ID.
+func List() []Info {
+	out := append([]Info(nil), catalog...)
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Provider != out[j].Provider {
+			return out[i].Provider < out[j].Provider
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
@@ -0,0 +1,127 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveAliasesAndDeprecations(t *testing.T) {
+	before := date(2025, 9, 1)
+	after := date(2026, 1, 1)
+	for _, tc := range []struct {
+		model   string
+		now     time.Time
+		want    string
+		warning string
+	}{
+		{"gpt-4o", after, "gpt-4o", ""},
+		{"claude-sonnet-4", after, "claude-sonnet-4-20250514", ""},
+		{"claude-sonnet-4-5", after, "claude-sonnet-4-5", ""},
+		{"anthropic/claude-3-5-sonnet", after, "anthropic/claude-3-5-sonnet", ""},
+		{"claude-3-5-sonnet", before, "claude-3-5-sonnet-20241022", "will be retired on 2025-10-22; switch to claude-sonnet-4"},
+		{"claude-3-5-sonnet-20240620", after, "claude-sonnet-4-20250514", "was retired on 2025-10-22; using claude-sonnet-4-20250514"},
+	} {
+		got, warning := Resolve(tc.model, tc.now)
+		if got != tc.want {
+			t.Errorf("Resolve(%q) = %q, want %q", tc.model, got, tc.want)
+		}
+		if (tc.warning == "") != (warning == "") || !strings.Contains(warning, tc.warning) {
+			t.Errorf("Resolve(%q) warning = %q, want %q", tc.model, warning, tc.warning)
+		}
+	}
+}
+
+func TestCachedInputIsPricedAtTheCacheRate(t *testing.T) {
+	full, ok := Cost("", "deepseek-chat", 1_000_000, 0)
+	if !ok || full != 0.28 {
+		t.Fatalf("expected $0.28 for 1M input tokens, got %v (%v)", full, ok)
+	}
+	if got := full - CacheDiscount("", "deepseek-chat", 1_000_000); got < 0.0279 || got > 0.0281 {
+		t.Fatalf("expected cached input at $0.028, got %v", got)
+	}
+	if CacheDiscount("", "unknown-model", 1000) != 0 {
+		t.Fatal("expected no discount for an unpriced model")
+	}
+	if got, ok := CostWithCache("", "deepseek-chat", 2_000_000, 0, 1_000_000, 0); !ok || got < 0.3079 || got > 0.3081 {
+		t.Fatalf("expected $0.308 with half the input cached, got %v (%v)", got, ok)
+	}
+	if _, ok := CostWithCache("", "unknown-model", 1000, 100, 500, 0); ok {
+		t.Fatal("expected an unpriced model to stay unpriced")
+	}
+}
+
+func TestCacheWritesArePricedAtTheWriteRate(t *testing.T) {
+	// 1M input tokens of which 1M were written to the cache: $3.75, not $3.
+	if got, ok := CostWithCache("", "claude-sonnet-4", 1_000_000, 0, 0, 1_000_000); !ok || got < 3.749 || got > 3.751 {
+		t.Fatalf("expected $3.75 for input written to the cache, got %v (%v)", got, ok)
+	}
+	if CacheWritePremium("", "gpt-4o", 1_000_000) != 0 {
+		t.Fatal("expected no premium for a model without a cache write price")
+	}
+}
+
+func TestQualifiedIDsPriceEachProvidersCatalog(t *testing.T) {
+	for _, tc := range []struct {
+		model    string
+		provider string
+	}{
+		{"openai/gpt-oss-120b", "groq"},
+		{"gpt-oss-120b", "cerebras"},
+		{"llama-3.3-70b-versatile", "groq"},
+		{"llama-3.3-70b", "cerebras"},
+		{"openrouter/llama-3.3-70b", "cerebras"},
+	} {
+		info, ok := Lookup(tc.model)
+		if !ok || info.Provider != tc.provider {
+			t.Errorf("Lookup(%q) = %+v, %v; want the %s entry", tc.model, info, ok, tc.provider)
+		}
+	}
+	if cost, ok := Cost("", "llama-3.1-8b-instant", 1_000_000, 1_000_000); !ok || cost != 0.13 {
+		t.Fatalf("expected $0.13 for 1M tokens each way, got %v (%v)", cost, ok)
+	}
+}
+
+func TestCohereSnapshotsMatchTheirFamily(t *testing.T) {
+	for model, want := range map[string]float64{
+		"command-a-03-2025":      2.50,
+		"command-r-plus-08-2024": 2.50,
+		"command-r-08-2024":      0.15,
+		"command-r7b-12-2024":    0.0375,
+	} {
+		if info, ok := Lookup(model); !ok || info.Provider != "cohere" || info.Pricing.InputPerMTok != want {
+			t.Errorf("Lookup(%q) = %+v, %v; want cohere at $%v", model, info, ok, want)
+		}
+	}
+}
+
+func TestServesKeepsModelsToTheirProvider(t *testing.T) {
+	for _, tc := range []struct {
+		provider, model string
+		want            bool
+	}{
+		{"openai", "gpt-4o-mini", true},
+		{"openai", "claude-sonnet-4-20250514", false},
+		{"anthropic", "gpt-4o", false},
+		{"openai", "my-finetune", true},
+		{"openai", "anthropic/claude-sonnet-4", true},
+		{"scripted", "claude-sonnet-4", true},
+		{"groq", "llama-3.3-70b", false},
+	} {
+		if got := Serves(tc.provider, tc.model); got != tc.want {
+			t.Errorf("Serves(%q, %q) = %v, want %v", tc.provider, tc.model, got, tc.want)
+		}
+	}
+}
+
+func TestPrefixMatchesStayWithTheirProvider(t *testing.T) {
+	if cost, ok := Cost("groq", "llama-3.3-70b-specdec", 1_000_000, 0); ok {
+		t.Fatalf("expected Groq's llama-3.3-70b-specdec to be unpriced, got $%v", cost)
+	}
+	if cost, ok := Cost("cerebras", "llama-3.3-70b-2025", 1_000_000, 0); !ok || cost != 0.85 {
+		t.Fatalf("expected Cerebras's own variant at its price, got $%v %v", cost, ok)
+	}
+	if _, ok := Cost("", "claude-sonnet-4-20250514", 1_000_000, 0); !ok {
+		t.Fatal("expected a snapshot to be priced when the provider is not known")
+	}
+}
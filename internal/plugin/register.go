@@ -9,14 +9,15 @@ import (
 	"io"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	loaderutil "github.com/bitop-dev/agent/internal/loader"
 	"github.com/bitop-dev/agent/internal/mcp"
 	"github.com/bitop-dev/agent/internal/registry"
+	"github.com/bitop-dev/agent/internal/tools/procenv"
 	"github.com/bitop-dev/agent/pkg/config"
 	pkghost "github.com/bitop-dev/agent/pkg/host"
 	plg "github.com/bitop-dev/agent/pkg/plugin"
@@ -81,19 +82,9 @@ func registerOne(ctx context.Context, item Discovered, regs Registries) error {
 		if regs.Tools == nil {
 			continue
 		}
-		descriptorPath := contribution.Path
-		if descriptorPath == "" {
-			descriptorPath = contribution.Entrypoint
-		}
-		if descriptorPath == "" {
-			return fmt.Errorf("tool contribution %s missing path", contribution.ID)
-		}
-		descriptor, err := loaderutil.LoadYAML[plg.ToolDescriptor](filepath.Join(baseDir, descriptorPath))
+		descriptor, err := loadToolDescriptor(baseDir, contribution)
 		if err != nil {
-			return fmt.Errorf("load tool descriptor %s: %w", contribution.ID, err)
-		}
-		if descriptor.ID == "" {
-			descriptor.ID = contribution.ID
+			return err
 		}
 		if _, exists := regs.Tools.Get(descriptor.ID); exists {
 			continue
@@ -144,6 +135,33 @@ func registerOne(ctx context.Context, item Discovered, regs Registries) error {
 	return nil
 }
 
+// ToolDescriptor loads the descriptor of a tool the plugin at manifestPath
+// contributes.
+func ToolDescriptor(manifestPath string, contribution plg.Contribution) (plg.ToolDescriptor, error) {
+	return loadToolDescriptor(filepath.Dir(manifestPath), contribution)
+}
+
+func loadToolDescriptor(baseDir string, contribution plg.Contribution) (plg.ToolDescriptor, error) {
+	descriptorPath := contribution.Path
+	if descriptorPath == "" {
+		descriptorPath = contribution.Entrypoint
+	}
+	if descriptorPath == "" {
+		return plg.ToolDescriptor{}, fmt.Errorf("tool contribution %s missing path", contribution.ID)
+	}
+	descriptor, err := loaderutil.LoadYAML[plg.ToolDescriptor](filepath.Join(baseDir, descriptorPath))
+	if err != nil {
+		return plg.ToolDescriptor{}, fmt.Errorf("load tool descriptor %s: %w", contribution.ID, err)
+	}
+	if descriptor.ID == "" {
+		descriptor.ID = contribution.ID
+	}
+	if descriptor.Capabilities.Concurrency < 0 {
+		return plg.ToolDescriptor{}, fmt.Errorf("tool descriptor %s: capabilities.concurrency must not be negative", descriptor.ID)
+	}
+	return descriptor, nil
+}
+
 func assetRef(item Discovered, baseDir string, contribution plg.Contribution) registry.AssetReference {
 	path := contribution.Path
 	if path == "" {
@@ -170,7 +188,45 @@ func (t DescriptorTool) Definition() tool.Definition {
 	return tool.Definition{ID: t.Descriptor.ID, Description: t.Descriptor.Description, Schema: t.Descriptor.InputSchema}
 }
 
+// Namespace places MCP-backed plugin tools with the other MCP tools.
+func (t DescriptorTool) Namespace() string {
+	if t.Runtime.Type == plg.RuntimeMCP {
+		return tool.NamespaceMCP
+	}
+	return tool.NamespacePlugin
+}
+
+// Capabilities reports what the descriptor declares.
+func (t DescriptorTool) Capabilities() tool.Capabilities {
+	caps := t.Descriptor.Capabilities
+	return tool.Capabilities{
+		SupportsStreaming: caps.SupportsStreaming,
+		SupportsCancel:    caps.SupportsCancel,
+		Concurrency:       caps.Concurrency,
+		NeedsConfirmation: caps.NeedsConfirmation,
+		Tags:              caps.Tags,
+		Cacheable:         caps.Cacheable,
+		Idempotent:        caps.Idempotent,
+		Serial:            caps.Serial,
+		Exclusive:         caps.Exclusive,
+	}
+}
+
+// toolSlots holds a semaphore per plugin tool that declares a concurrency
+// limit, shared by every copy of its DescriptorTool.
+var toolSlots sync.Map // "plugin/tool" -> chan struct{}
+
 func (t DescriptorTool) Run(ctx context.Context, call tool.Call) (tool.Result, error) {
+	if limit := t.Descriptor.Capabilities.Concurrency; limit > 0 {
+		v, _ := toolSlots.LoadOrStore(t.PluginName+"/"+t.Descriptor.ID, make(chan struct{}, limit))
+		slots := v.(chan struct{})
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+		case <-ctx.Done():
+			return tool.Result{}, ctx.Err()
+		}
+	}
 	switch t.Runtime.Type {
 	case plg.RuntimeHTTP:
 		return t.runHTTP(ctx, call)
@@ -446,15 +502,19 @@ func (t DescriptorTool) runHTTP(ctx context.Context, call tool.Call) (tool.Resul
 		return tool.Result{}, err
 	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return tool.Result{}, fmt.Errorf("plugin %s HTTP tool %s failed: %s: %s", t.PluginName, t.Descriptor.ID, resp.Status, strings.TrimSpace(string(responseBody)))
+		return tool.Result{}, tool.Errorf(tool.CodeForStatus(resp.StatusCode), "plugin %s HTTP tool %s failed: %s: %s", t.PluginName, t.Descriptor.ID, resp.Status, strings.TrimSpace(string(responseBody)))
 	}
 	var decoded struct {
 		Output string         `json:"output"`
 		Data   map[string]any `json:"data"`
 		Error  string         `json:"error"`
+		Code   string         `json:"code"` // optional tool.ErrorCode for Error
 	}
 	if err := json.Unmarshal(responseBody, &decoded); err == nil && (decoded.Output != "" || decoded.Data != nil || decoded.Error != "") {
 		if decoded.Error != "" {
+			if decoded.Code != "" {
+				return tool.Result{}, tool.WrapError(tool.ErrorCode(decoded.Code), errors.New(decoded.Error))
+			}
 			return tool.Result{}, errors.New(decoded.Error)
 		}
 		return tool.Result{ToolID: call.ToolID, Output: decoded.Output, Data: decoded.Data}, nil
@@ -506,9 +566,12 @@ func (t DescriptorTool) runCommandArgv(ctx context.Context, call tool.Call, env
 	args = append(args, t.Runtime.Command[1:]...)
 	args = append(args, expanded...)
 
-	cmd := exec.CommandContext(ctx, t.Runtime.Command[0], args...)
+	cmd, err := procenv.Command(ctx, t.Runtime.Command[0], args...)
+	if err != nil {
+		return tool.Result{}, err
+	}
 	cmd.Env = append(cmd.Environ(), env...)
-	if t.PluginDir != "" {
+	if t.PluginDir != "" && cmd.Dir == "" {
 		cmd.Dir = t.PluginDir
 	}
 
@@ -550,9 +613,12 @@ func (t DescriptorTool) runCommandJSON(ctx context.Context, call tool.Call, env
 	}
 
 	args := t.Runtime.Command[1:]
-	cmd := exec.CommandContext(ctx, t.Runtime.Command[0], args...)
+	cmd, err := procenv.Command(ctx, t.Runtime.Command[0], args...)
+	if err != nil {
+		return tool.Result{}, err
+	}
 	cmd.Env = append(cmd.Environ(), env...)
-	if t.PluginDir != "" {
+	if t.PluginDir != "" && cmd.Dir == "" {
 		cmd.Dir = t.PluginDir
 	}
 	cmd.Stdin = bytes.NewReader(input)
@@ -9,7 +9,9 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/bitop-dev/agent/internal/registry"
 	"github.com/bitop-dev/agent/pkg/config"
@@ -102,7 +104,7 @@ spec:
     plugins: []
 `
 	os.WriteFile(filepath.Join(pluginDir, "plugin.yaml"), []byte(manifest), 0o644)
-	os.WriteFile(filepath.Join(pluginDir, "tools", "tool.yaml"), []byte("id: test/tool\ndescription: test tool\ninputSchema:\n  type: object\n  properties: {}\nexecution:\n  mode: http\n  operation: test\nrisk:\n  level: low\n"), 0o644)
+	os.WriteFile(filepath.Join(pluginDir, "tools", "tool.yaml"), []byte("id: test/tool\ndescription: test tool\ninputSchema:\n  type: object\n  properties: {}\nexecution:\n  mode: http\n  operation: test\nrisk:\n  level: low\ncapabilities:\n  supportsCancel: true\n  concurrency: 2\n  needsConfirmation: true\n  tags: [destructive]\n"), 0o644)
 	os.WriteFile(filepath.Join(pluginDir, "prompts", "prompt.md"), []byte("test prompt"), 0o644)
 	os.WriteFile(filepath.Join(pluginDir, "profiles", "profile.yaml"), []byte("test profile"), 0o644)
 	os.WriteFile(filepath.Join(pluginDir, "policies", "policy.yaml"), []byte("version: 1\nrules: []\n"), 0o644)
@@ -128,9 +130,14 @@ spec:
 	if _, ok := pluginRegistry.Get("test-plugin"); !ok {
 		t.Fatal("expected test-plugin to be registered")
 	}
-	if _, ok := toolRegistry.Get("test/tool"); !ok {
+	registered, ok := toolRegistry.Get("test/tool")
+	if !ok {
 		t.Fatal("expected test/tool to be registered")
 	}
+	caps := tool.CapabilitiesOf(registered)
+	if !caps.SupportsCancel || caps.Concurrency != 2 || caps.NeedsConfirmation == nil || !*caps.NeedsConfirmation || strings.Join(caps.Tags, ",") != "destructive" {
+		t.Fatalf("unexpected capabilities: %+v", caps)
+	}
 	if _, ok := promptRegistry.Get("test/prompt"); !ok {
 		t.Fatal("expected test/prompt to be registered")
 	}
@@ -507,3 +514,41 @@ func TestDescriptorToolRunCommandPlainTextFallback(t *testing.T) {
 		t.Fatalf("expected 'just plain text', got %q", result.Output)
 	}
 }
+
+func TestDescriptorToolConcurrencyLimit(t *testing.T) {
+	var mu sync.Mutex
+	active, peak := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		peak = max(peak, active)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]any{"output": "ok"})
+	}))
+	defer server.Close()
+
+	toolImpl := DescriptorTool{
+		PluginName: "limited",
+		Descriptor: plg.ToolDescriptor{ID: "limited/call", Execution: plg.ToolExecution{Operation: "call"}, Capabilities: plg.ToolCapabilities{Concurrency: 1}},
+		Runtime:    plg.Runtime{Type: plg.RuntimeHTTP},
+		Config:     config.PluginConfig{Enabled: true, Config: map[string]any{"baseURL": server.URL}},
+	}
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := toolImpl.Run(context.Background(), tool.Call{ToolID: "limited/call"}); err != nil {
+				t.Errorf("run: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if peak != 1 {
+		t.Fatalf("expected at most one call in flight, saw %d", peak)
+	}
+}
@@ -17,21 +17,23 @@ type overlayRule struct {
 	ID       string `yaml:"id"`
 	Action   string `yaml:"action"`
 	Tool     string `yaml:"tool,omitempty"`
+	Tag      string `yaml:"tag,omitempty"` // matches tools declaring this capability tag
 	Decision string `yaml:"decision"`
 }
 
 type OverlayDecisions struct {
 	Tools   map[string]policy.DecisionKind
+	Tags    map[string]policy.DecisionKind
 	Shell   *policy.DecisionKind
 	Network *policy.DecisionKind
 }
 
 func LoadToolOverrides(profilePath string, overlays []string) (OverlayDecisions, error) {
 	if len(overlays) == 0 {
-		return OverlayDecisions{Tools: map[string]policy.DecisionKind{}}, nil
+		return OverlayDecisions{Tools: map[string]policy.DecisionKind{}, Tags: map[string]policy.DecisionKind{}}, nil
 	}
 	baseDir := filepath.Dir(profilePath)
-	result := OverlayDecisions{Tools: make(map[string]policy.DecisionKind)}
+	result := OverlayDecisions{Tools: make(map[string]policy.DecisionKind), Tags: make(map[string]policy.DecisionKind)}
 	for _, overlay := range overlays {
 		path := overlay
 		if !filepath.IsAbs(path) {
@@ -56,10 +58,12 @@ func LoadToolOverrides(profilePath string, overlays []string) (OverlayDecisions,
 			}
 			switch rule.Action {
 			case string(policy.ActionTool):
-				if rule.Tool == "" {
-					continue
+				switch {
+				case rule.Tool != "":
+					result.Tools[rule.Tool] = decision
+				case rule.Tag != "":
+					result.Tags[rule.Tag] = decision
 				}
-				result.Tools[rule.Tool] = decision
 			case string(policy.ActionShell):
 				result.Shell = &decision
 			case string(policy.ActionNet):
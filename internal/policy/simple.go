@@ -4,9 +4,11 @@ import (
 	"context"
 	"fmt"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/bitop-dev/agent/pkg/policy"
+	"github.com/bitop-dev/agent/pkg/tool"
 	"github.com/bitop-dev/agent/pkg/workspace"
 )
 
@@ -16,6 +18,7 @@ type Engine struct {
 	AllowNet       bool
 	SensitiveTools map[string]policy.RiskLevel
 	ToolOverrides  map[string]policy.DecisionKind
+	TagOverrides   map[string]policy.DecisionKind // by declared capability tag
 	ShellOverride  *policy.DecisionKind
 	NetOverride    *policy.DecisionKind
 }
@@ -43,18 +46,41 @@ func (e Engine) Check(_ context.Context, req policy.CheckRequest) (policy.Decisi
 		}
 		return policy.Decision{Kind: policy.DecisionAllow, Reason: "network allowed", Risk: req.Risk}, nil
 	case policy.ActionTool:
+		if e.ReadOnly && slices.Contains(req.Tags, tool.TagWrites) {
+			return policy.Decision{Kind: policy.DecisionDeny, Reason: fmt.Sprintf("profile is read-only and %s writes", req.ToolID), Risk: policy.RiskHigh}, nil
+		}
 		if decision, ok := e.ToolOverrides[req.ToolID]; ok {
 			return policy.Decision{Kind: decision, Reason: fmt.Sprintf("tool %s matched policy override", req.ToolID), Risk: req.Risk}, nil
 		}
+		if decision, tag, ok := e.tagOverride(req.Tags); ok {
+			return policy.Decision{Kind: decision, Reason: fmt.Sprintf("tool %s tag %q matched policy override", req.ToolID, tag), Risk: req.Risk}, nil
+		}
 		if risk, ok := e.SensitiveTools[req.ToolID]; ok {
 			return policy.Decision{Kind: policy.DecisionRequireApproval, Reason: fmt.Sprintf("tool %s requires approval", req.ToolID), Risk: risk}, nil
 		}
+		if req.NeedsConfirmation != nil && *req.NeedsConfirmation {
+			return policy.Decision{Kind: policy.DecisionRequireApproval, Reason: fmt.Sprintf("tool %s declares that it needs confirmation", req.ToolID), Risk: req.Risk}, nil
+		}
 		return policy.Decision{Kind: policy.DecisionAllow, Reason: "tool allowed", Risk: req.Risk}, nil
 	default:
 		return policy.Decision{Kind: policy.DecisionAllow, Reason: "default allow", Risk: req.Risk}, nil
 	}
 }
 
+// tagOverride returns the strictest override matching one of tags.
+func (e Engine) tagOverride(tags []string) (policy.DecisionKind, string, bool) {
+	strictness := map[policy.DecisionKind]int{policy.DecisionAllow: 0, policy.DecisionRequireApproval: 1, policy.DecisionDeny: 2}
+	var best policy.DecisionKind
+	var bestTag string
+	for _, tag := range tags {
+		decision, ok := e.TagOverrides[tag]
+		if ok && (bestTag == "" || strictness[decision] > strictness[best]) {
+			best, bestTag = decision, tag
+		}
+	}
+	return best, bestTag, bestTag != ""
+}
+
 func (e Engine) checkWorkspace(path string, allowed policy.DecisionKind, reason string) (policy.Decision, error) {
 	if path == "" {
 		return policy.Decision{Kind: policy.DecisionDeny, Reason: "path is required", Risk: policy.RiskHigh}, nil
@@ -69,12 +95,21 @@ func (e Engine) checkWorkspace(path string, allowed policy.DecisionKind, reason
 	return policy.Decision{Kind: allowed, Reason: reason, Risk: policy.RiskLow}, nil
 }
 
-func IsReadOnly(writeScope string, enabledTools []string) bool {
+// IsReadOnly reports whether a profile may not modify the workspace: its
+// write scope says so, or none of its tools can write. Tools in registry
+// tagged tool.TagWrites count as write tools; registry may be nil.
+func IsReadOnly(writeScope string, enabledTools []string, registry tool.Registry) bool {
 	if strings.EqualFold(writeScope, "read-only") {
 		return true
 	}
 	for _, toolID := range enabledTools {
-		if toolID == "core/write" || toolID == "core/edit" || toolID == "core/bash" {
+		if toolID == "core/write" || toolID == "core/edit" || toolID == "core/bash" || toolID == "git/commit" {
+			return false
+		}
+		if registry == nil {
+			continue
+		}
+		if t, ok := registry.Get(toolID); ok && slices.Contains(tool.CapabilitiesOf(t).Tags, tool.TagWrites) {
 			return false
 		}
 	}
@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -19,6 +20,8 @@ import (
 	loaderutil "github.com/bitop-dev/agent/internal/loader"
 	"github.com/bitop-dev/agent/pkg/config"
 	pf "github.com/bitop-dev/agent/pkg/profile"
+	"github.com/bitop-dev/agent/pkg/skills"
+	"github.com/bitop-dev/agent/pkg/tool"
 )
 
 type Discovered struct {
@@ -28,8 +31,12 @@ type Discovered struct {
 
 type Loader struct {
 	Roots         []string
-	InstallRoot   string               // where to install profiles from registry (e.g. ~/.agent/profiles)
+	InstallRoot   string                // where to install profiles from registry (e.g. ~/.agent/profiles)
 	PluginSources []config.PluginSource // registry sources to search for profiles
+	Skills        []skills.Skill        // discovered skills that spec.skills may name
+	// ToolPresets are the custom presets tools.enabled may name, from
+	// config.
+	ToolPresets map[string]config.ToolPreset
 }
 
 func (l Loader) Discover(context.Context) ([]Discovered, error) {
@@ -69,7 +76,59 @@ func (l Loader) Discover(context.Context) ([]Discovered, error) {
 	return out, nil
 }
 
+// Load finds a profile by path or name, resolves inheritance, and expands
+// tool presets such as @git in tools.enabled. Custom presets also bring
+// their tool settings, for the tools the profile does not configure.
 func (l Loader) Load(ctx context.Context, ref string) (pf.Manifest, string, error) {
+	manifest, path, err := l.load(ctx, ref)
+	if err != nil {
+		return manifest, path, err
+	}
+	custom := make(map[string][]string, len(l.ToolPresets))
+	for name, preset := range l.ToolPresets {
+		custom[name] = preset.Tools
+	}
+	enabled, err := tool.ExpandPresetsWith(manifest.Spec.Tools.Enabled, custom)
+	if err != nil {
+		return pf.Manifest{}, "", fmt.Errorf("profile %s: %w", manifest.Metadata.Name, err)
+	}
+	for _, id := range manifest.Spec.Tools.Enabled {
+		if _, builtin := tool.Preset(id); builtin || !strings.HasPrefix(id, "@") {
+			continue
+		}
+		preset, ok := l.ToolPresets[strings.TrimPrefix(id, "@")]
+		if !ok {
+			continue
+		}
+		for toolID, settings := range preset.Settings {
+			if _, set := manifest.Spec.Tools.Settings[toolID]; set {
+				continue
+			}
+			if manifest.Spec.Tools.Settings == nil {
+				manifest.Spec.Tools.Settings = map[string]pf.ToolSettings{}
+			}
+			manifest.Spec.Tools.Settings[toolID] = settings
+		}
+	}
+	selected, err := skills.Select(l.Skills, manifest.Spec.Skills)
+	if err != nil {
+		return pf.Manifest{}, "", fmt.Errorf("profile %s: %w", manifest.Metadata.Name, err)
+	}
+	for _, s := range selected {
+		for _, id := range s.ToolIDs() {
+			if !slices.Contains(enabled, id) {
+				enabled = append(enabled, id)
+			}
+		}
+	}
+	manifest.Spec.Tools.Enabled = enabled
+	if _, err := manifest.Spec.Tools.AbortGraceDuration(); err != nil {
+		return pf.Manifest{}, "", fmt.Errorf("profile %s: %w", manifest.Metadata.Name, err)
+	}
+	return manifest, path, nil
+}
+
+func (l Loader) load(ctx context.Context, ref string) (pf.Manifest, string, error) {
 	if _, err := os.Stat(ref); err == nil {
 		if info, statErr := os.Stat(ref); statErr == nil && info.IsDir() {
 			for _, candidate := range []string{"profile.yaml", "profile.yml"} {
@@ -154,11 +213,40 @@ func (l Loader) resolveInheritance(ctx context.Context, child pf.Manifest, child
 		mergedTools = append(mergedTools, t)
 	}
 	merged.Spec.Tools.Enabled = mergedTools
+	// Tool settings — child entries replace the parent's for the same tool.
+	if len(child.Spec.Tools.Settings) > 0 {
+		settings := make(map[string]pf.ToolSettings, len(parent.Spec.Tools.Settings)+len(child.Spec.Tools.Settings))
+		for id, ts := range parent.Spec.Tools.Settings {
+			settings[id] = ts
+		}
+		for id, ts := range child.Spec.Tools.Settings {
+			settings[id] = ts
+		}
+		merged.Spec.Tools.Settings = settings
+	}
+	// Tool budget — child wins if it sets a cap.
+	if child.Spec.Tools.Budget.MaxTokens > 0 {
+		merged.Spec.Tools.Budget = child.Spec.Tools.Budget
+	}
+	// Tool selection — child wins if it picks a strategy.
+	if child.Spec.Tools.AbortGrace != "" {
+		merged.Spec.Tools.AbortGrace = child.Spec.Tools.AbortGrace
+	}
+	if child.Spec.Tools.Select.Strategy != "" {
+		merged.Spec.Tools.Select = child.Spec.Tools.Select
+	}
 
 	// Instructions — concatenate (parent first, child after).
 	if len(child.Spec.Instructions.System) > 0 {
 		merged.Spec.Instructions.System = append(parent.Spec.Instructions.System, child.Spec.Instructions.System...)
 	}
+	// Prompt sections — child wins if set.
+	if len(child.Spec.Instructions.Sections) > 0 {
+		merged.Spec.Instructions.Sections = child.Spec.Instructions.Sections
+	}
+	if child.Spec.Instructions.ContextFiles != (pf.ContextFilesSpec{}) {
+		merged.Spec.Instructions.ContextFiles = child.Spec.Instructions.ContextFiles
+	}
 
 	// Approval — child wins if set.
 	if child.Spec.Approval.Mode != "" {
@@ -169,12 +257,32 @@ func (l Loader) resolveInheritance(ctx context.Context, child pf.Manifest, child
 	if child.Spec.Workspace.WriteScope != "" {
 		merged.Spec.Workspace = child.Spec.Workspace
 	}
+	if child.Spec.Workspace.Watch {
+		merged.Spec.Workspace.Watch = true
+	}
 
 	// Session — child wins if set.
 	if child.Spec.Session.Persistence != "" {
 		merged.Spec.Session = child.Spec.Session
 	}
 
+	// Skills — union of parent and child.
+	for _, name := range child.Spec.Skills {
+		if !slices.Contains(merged.Spec.Skills, name) {
+			merged.Spec.Skills = append(merged.Spec.Skills, name)
+		}
+	}
+
+	// Language — child wins if it enables detection.
+	if child.Spec.Language.Enabled() {
+		merged.Spec.Language = child.Spec.Language
+	}
+
+	// Budget — child wins if it sets any cap.
+	if child.Spec.Budget.Enabled() {
+		merged.Spec.Budget = child.Spec.Budget
+	}
+
 	// Policy — child wins if has overlays.
 	if len(child.Spec.Policy.Overlays) > 0 {
 		merged.Spec.Policy = child.Spec.Policy
@@ -4,7 +4,12 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/bitop-dev/agent/pkg/config"
+	pf "github.com/bitop-dev/agent/pkg/profile"
+	"github.com/bitop-dev/agent/pkg/skills"
 )
 
 func TestLoaderLoadAcceptsProfileDirectory(t *testing.T) {
@@ -29,3 +34,56 @@ func TestLoaderLoadAcceptsProfileDirectory(t *testing.T) {
 		t.Fatalf("expected profile.yaml path, got %s", path)
 	}
 }
+
+func TestLoaderEnablesToolsOfNamedSkills(t *testing.T) {
+	dir := t.TempDir()
+	manifest := "apiVersion: agent/v1\nkind: Profile\nmetadata:\n  name: writer\nspec:\n  provider:\n    default: mock\n  tools:\n    enabled: [core/read]\n  skills: [changelog]\n"
+	path := filepath.Join(dir, "profile.yaml")
+	if err := os.WriteFile(path, []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	catalog := []skills.Skill{
+		{Name: "changelog", Scripts: []skills.Script{{Name: "collect"}}},
+		{Name: "pdf", Resources: []string{"forms.md"}},
+	}
+	loaded, _, err := Loader{Skills: catalog}.Load(context.Background(), path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := strings.Join(loaded.Spec.Tools.Enabled, ","); got != "core/read,changelog/load,changelog/collect" {
+		t.Fatalf("unexpected tools: %s", got)
+	}
+	if _, _, err := (Loader{}).Load(context.Background(), path); err == nil || !strings.Contains(err.Error(), `skill "changelog" not found`) {
+		t.Fatalf("expected an unknown skill error, got %v", err)
+	}
+}
+
+func TestLoaderExpandsConfiguredToolPresets(t *testing.T) {
+	dir := t.TempDir()
+	manifest := "apiVersion: agent/v1\nkind: Profile\nmetadata:\n  name: reviewer\nspec:\n  provider:\n    default: mock\n  tools:\n    enabled: [core/read, '@review']\n    settings:\n      core/read:\n        maxBytes: 100\n"
+	path := filepath.Join(dir, "profile.yaml")
+	if err := os.WriteFile(path, []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	presets := map[string]config.ToolPreset{"review": {
+		Tools: []string{"core/read", "core/bash", "@git"},
+		Settings: map[string]pf.ToolSettings{
+			"core/read": {MaxBytes: 65536},
+			"core/bash": {AllowedCommands: []string{"go", "git"}},
+		},
+	}}
+	loaded, _, err := Loader{ToolPresets: presets}.Load(context.Background(), path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := strings.Join(loaded.Spec.Tools.Enabled, ","); got != "core/read,core/bash,git/status,git/diff,git/log,git/branch,git/commit" {
+		t.Fatalf("unexpected tools: %s", got)
+	}
+	settings := loaded.Spec.Tools.Settings
+	if settings["core/read"].MaxBytes != 100 || strings.Join(settings["core/bash"].AllowedCommands, ",") != "go,git" {
+		t.Fatalf("expected the preset's settings under the profile's own, got %+v", settings)
+	}
+	if _, _, err := (Loader{}).Load(context.Background(), path); err == nil || !strings.Contains(err.Error(), `unknown tool preset "@review"`) {
+		t.Fatalf("expected an unknown preset error, got %v", err)
+	}
+}
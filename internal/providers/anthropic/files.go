@@ -0,0 +1,36 @@
+package anthropic
+
+import (
+	"encoding/base64"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+)
+
+// filesBeta is the beta header value file sources in messages require.
+const filesBeta = "files-api-2025-04-14"
+
+// documentBlock is the document content block for doc, referring to an
+// uploaded file when it has a FileID.
+func documentBlock(doc provider.Document) map[string]any {
+	source := map[string]any{"type": "base64", "media_type": doc.MediaType, "data": base64.StdEncoding.EncodeToString(doc.Data)}
+	if doc.FileID != "" {
+		source = map[string]any{"type": "file", "file_id": doc.FileID}
+	}
+	block := map[string]any{"type": "document", "source": source}
+	if doc.Name != "" {
+		block["title"] = doc.Name
+	}
+	return block
+}
+
+// usesFiles reports whether any message refers to an uploaded file.
+func usesFiles(messages []provider.Message) bool {
+	for _, msg := range messages {
+		for _, doc := range msg.Documents {
+			if doc.FileID != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
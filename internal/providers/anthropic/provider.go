@@ -3,15 +3,21 @@
 package anthropic
 
 import (
+	"bufio"
 	"bytes"
+	"cmp"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"regexp"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/bitop-dev/agent/internal/models"
 	"github.com/bitop-dev/agent/pkg/provider"
 	"github.com/bitop-dev/agent/pkg/tool"
 )
@@ -20,10 +26,45 @@ type Provider struct {
 	APIKey     string
 	BaseURL    string // default: https://api.anthropic.com
 	HTTPClient *http.Client
+	// NoCache leaves out the prompt caching breakpoints otherwise set on
+	// the tools, the system prompt, and the last message, so that each
+	// turn can reuse the previous one's prefix. Requests with NoCache set
+	// go without them too.
+	NoCache bool
+	// ThinkingBudget enables extended thinking with up to this many tokens
+	// of thinking per turn; 0 leaves it off. The API requires at least
+	// 1024.
+	ThinkingBudget int
+	// StallTimeout aborts a stream that receives nothing, keepalive pings
+	// included, for this long, failing it with a provider.StallError; 0
+	// waits for the client's timeout.
+	StallTimeout time.Duration
+	// Beta lists the beta features sent in anthropic-beta with every
+	// request, such as "context-1m-2025-08-07".
+	Beta []string
+	// Headers and Query are added to every request, as a gateway in front
+	// of the API may expect.
+	Headers map[string]string
+	Query   map[string]string
 }
 
 func (p Provider) Name() string { return "anthropic" }
 
+// SupportsPrefill reports that a trailing assistant message is continued
+// rather than answered, which the Messages API supports for all models.
+func (p Provider) SupportsPrefill(string) bool { return true }
+
+// SupportsVision reports that every current Claude model accepts images.
+func (p Provider) SupportsVision(string) bool { return true }
+
+// ValidToolCallID reports whether id is a tool_use ID the Messages API
+// accepts: letters, digits, underscores, and hyphens.
+func (p Provider) ValidToolCallID(id string) bool {
+	return toolUseID.MatchString(id)
+}
+
+var toolUseID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
 func (p Provider) Stream(ctx context.Context, req provider.CompletionRequest) (<-chan provider.StreamEvent, error) {
 	if strings.TrimSpace(p.APIKey) == "" {
 		return nil, fmt.Errorf("anthropic provider: API key is required")
@@ -32,6 +73,8 @@ func (p Provider) Stream(ctx context.Context, req provider.CompletionRequest) (<
 	if baseURL == "" {
 		baseURL = "https://api.anthropic.com"
 	}
+	p.Headers = provider.MergeExtras(p.Headers, req.ExtraHeaders)
+	p.Query = provider.MergeExtras(p.Query, req.ExtraQuery)
 	ch := make(chan provider.StreamEvent, 8)
 	go func() {
 		defer close(ch)
@@ -44,22 +87,107 @@ func (p Provider) Stream(ctx context.Context, req provider.CompletionRequest) (<
 	return ch, nil
 }
 
+// defaultThinkingBudget is the thinking budget of ThinkingHigh when a
+// level is asked for without a configured ThinkingBudget.
+const defaultThinkingBudget = 16384
+
+// minThinkingBudget is the least budget_tokens the API accepts.
+const minThinkingBudget = 1024
+
+// thinkingBudget returns the thinking tokens for level: configured, or
+// defaultThinkingBudget, at ThinkingHigh, half of it at ThinkingMedium,
+// and a quarter at ThinkingLow. Without a level it is configured, and 0
+// leaves thinking off.
+func thinkingBudget(configured int, level provider.ThinkingLevel) int {
+	if level == "" {
+		return configured
+	}
+	if configured <= 0 {
+		configured = defaultThinkingBudget
+	}
+	switch level {
+	case provider.ThinkingMedium:
+		configured /= 2
+	case provider.ThinkingLow:
+		configured /= 4
+	}
+	return max(configured, minThinkingBudget)
+}
+
+// setHeaders sets what every request carries: the API key and version,
+// the beta features of p.Beta and beta, and the configured headers and
+// query.
+func (p Provider) setHeaders(req *http.Request, beta ...string) {
+	req.Header.Set("x-api-key", p.APIKey)
+	req.Header.Set("anthropic-version", "2023-06-01")
+	if betas := append(slices.Clone(p.Beta), beta...); len(betas) > 0 {
+		req.Header.Set("anthropic-beta", strings.Join(betas, ","))
+	}
+	provider.ApplyExtras(req, p.Headers, p.Query)
+}
+
+// maxPauses bounds how often one request is continued after the API
+// pauses a long server tool loop.
+const maxPauses = 5
+
+// runMessages sends req and streams the reply. A reply paused in the
+// middle of a server tool loop (stop reason pause_turn) is sent back as
+// it is, so the model carries on, and the continuation streamed after it.
 func (p Provider) runMessages(ctx context.Context, baseURL string, req provider.CompletionRequest, ch chan<- provider.StreamEvent) error {
+	for pauses := 0; ; pauses++ {
+		reply, err := p.send(ctx, baseURL, req, ch)
+		if err != nil || reply.stopReason != "pause_turn" || pauses == maxPauses {
+			return err
+		}
+		paused := provider.Message{Role: "assistant"}
+		for _, block := range reply.content {
+			paused.Raw = append(paused.Raw, provider.RawContent{Provider: "anthropic", Data: block})
+		}
+		req.Messages = append(slices.Clone(req.Messages), paused)
+	}
+}
+
+// reply is how a response ended: its content blocks, as they are sent
+// back, and its stop reason.
+type reply struct {
+	content    []json.RawMessage
+	stopReason string
+}
+
+// send makes one Messages API request for req, streaming its reply to ch.
+func (p Provider) send(ctx context.Context, baseURL string, req provider.CompletionRequest, ch chan<- provider.StreamEvent) (reply, error) {
 	body := map[string]any{
 		"model":      req.Model.Model,
 		"max_tokens": 4096,
 		"messages":   toAnthropicMessages(req.Messages),
+		"stream":     true,
 	}
 	if strings.TrimSpace(req.System) != "" {
 		body["system"] = req.System
 	}
-	if len(req.Tools) > 0 {
-		body["tools"] = toAnthropicTools(req.Tools)
+	forced := false
+	if len(req.Tools) > 0 || len(req.HostedTools) > 0 {
+		body["tools"] = append(toAnthropicTools(req.Tools), toHostedTools(req.HostedTools)...)
+		if choice := toAnthropicToolChoice(req.ToolChoice); choice != nil {
+			body["tool_choice"] = choice
+			forced = choice["type"] == "any" || choice["type"] == "tool"
+		}
+	}
+	// The API refuses extended thinking with a forced tool or a reply
+	// prefix, so those turns go without it, as do models without it.
+	prefilled := len(req.Messages) > 0 && req.Messages[len(req.Messages)-1].Role == "assistant"
+	if budget := thinkingBudget(p.ThinkingBudget, req.Thinking); budget > 0 && !forced && !prefilled && models.SupportsThinking(req.Model.Provider, req.Model.Model) {
+		// max_tokens covers the thinking as well as the answer.
+		body["thinking"] = map[string]any{"type": "enabled", "budget_tokens": budget}
+		body["max_tokens"] = budget + 4096
+	}
+	if !p.NoCache && !req.NoCache {
+		setCacheBreakpoints(body, req.Trailing)
 	}
 
 	data, err := json.Marshal(body)
 	if err != nil {
-		return err
+		return reply{}, err
 	}
 
 	client := p.HTTPClient
@@ -67,45 +195,345 @@ func (p Provider) runMessages(ctx context.Context, baseURL string, req provider.
 		client = &http.Client{Timeout: 120 * time.Second}
 	}
 
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost,
 		strings.TrimRight(baseURL, "/")+"/v1/messages", bytes.NewReader(data))
 	if err != nil {
-		return err
+		return reply{}, err
 	}
-	httpReq.Header.Set("x-api-key", p.APIKey)
-	httpReq.Header.Set("anthropic-version", "2023-06-01")
 	httpReq.Header.Set("content-type", "application/json")
+	httpReq.Header.Set("accept", "text/event-stream")
+	if usesFiles(req.Messages) {
+		p.setHeaders(httpReq, filesBeta)
+	} else {
+		p.setHeaders(httpReq)
+	}
 
 	resp, err := client.Do(httpReq)
 	if err != nil {
-		return err
+		return reply{}, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		respBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("anthropic API: %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+		return reply{}, fmt.Errorf("anthropic API: %w", provider.NewAPIError(resp, respBody))
 	}
+	// Some proxies ignore "stream" and answer with a single JSON message.
+	if !strings.Contains(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return decodeMessage(resp.Body, ch)
+	}
+	stream := provider.WatchStalls(resp.Body, p.StallTimeout, cancel)
+	defer stream.Close()
+	return readStream(stream, ch)
+}
 
-	var result struct {
-		Content []struct {
-			Type  string `json:"type"`
-			Text  string `json:"text"`
-			ID    string `json:"id"`
-			Name  string `json:"name"`
-			Input any    `json:"input"`
-		} `json:"content"`
+// streamEvent is one server-sent event of the streaming Messages API.
+type streamEvent struct {
+	Type    string `json:"type"`
+	Index   int    `json:"index"`
+	Message struct {
+		Usage      usage  `json:"usage"`
 		StopReason string `json:"stop_reason"`
-		Usage      struct {
-			InputTokens  int `json:"input_tokens"`
-			OutputTokens int `json:"output_tokens"`
-		} `json:"usage"`
+	} `json:"message"`
+	ContentBlock struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"content_block"`
+	Delta struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		Thinking    string `json:"thinking"`
+		Signature   string `json:"signature"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *usage `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type usage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+}
+
+// update folds in the usage of a message_delta or message_stop event,
+// which is cumulative for the message.
+func (u *usage) update(next usage) {
+	if next.InputTokens > 0 {
+		u.InputTokens = next.InputTokens
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return fmt.Errorf("anthropic decode: %w", err)
+	if next.CacheReadInputTokens > 0 || next.CacheCreationInputTokens > 0 {
+		u.CacheReadInputTokens = next.CacheReadInputTokens
+		u.CacheCreationInputTokens = next.CacheCreationInputTokens
 	}
+	u.OutputTokens = max(u.OutputTokens, next.OutputTokens)
+}
+
+// usageEvent reports u with thinkingChars of extended-thinking text. The
+// API folds thinking into output_tokens without a separate count, so the
+// reasoning share is estimated at four characters per token. input_tokens
+// counts only the tokens after the last cache breakpoint; InputTokens is
+// the whole prompt, as other providers report it.
+func usageEvent(typ provider.StreamEventType, u usage, thinkingChars int) provider.StreamEvent {
+	return provider.StreamEvent{
+		Type:             typ,
+		InputTokens:      u.InputTokens + u.CacheReadInputTokens + u.CacheCreationInputTokens,
+		OutputTokens:     u.OutputTokens,
+		ReasoningTokens:  min(thinkingChars/4, u.OutputTokens),
+		CacheReadTokens:  u.CacheReadInputTokens,
+		CacheWriteTokens: u.CacheCreationInputTokens,
+	}
+}
+
+// knownBlock reports whether the runtime models a content block type;
+// other blocks are passed through as provider.RawContent. That includes
+// thinking and redacted_thinking blocks, which the API requires back
+// unchanged, signature and all, on the turns that follow them while a tool
+// use is answered.
+func knownBlock(typ string) bool {
+	switch typ {
+	case "text", "tool_use":
+		return true
+	}
+	return false
+}
+
+// rawContent wraps an unmodeled content block for the runtime, naming the
+// server tool a server_tool_use or server tool result block belongs to.
+func rawContent(typ string, block json.RawMessage) provider.StreamEvent {
+	raw := provider.RawContent{Provider: "anthropic", Type: typ, Data: block}
+	switch {
+	case typ == "server_tool_use":
+		var use struct {
+			Name string `json:"name"`
+		}
+		_ = json.Unmarshal(block, &use)
+		raw.HostedTool = use.Name
+	case strings.HasSuffix(typ, "_tool_result"):
+		raw.HostedTool = strings.TrimSuffix(typ, "_tool_result")
+	}
+	return provider.StreamEvent{Type: provider.StreamEventRaw, Raw: raw}
+}
 
-	for _, block := range result.Content {
+// dataPrefix starts the data lines of a server-sent event stream.
+var dataPrefix = []byte("data:")
+
+// readStream emits text deltas as they arrive, tool calls when their block
+// closes, and running usage from message_start and message_delta. Blocks of
+// other types are emitted whole as raw content when they close. Thinking
+// is not shown but counts toward the reasoning estimate; its deltas and
+// signature are gathered into the thinking block. It returns the blocks of
+// the reply and why it stopped once message_stop ends the message, or the
+// stream ends without one.
+func readStream(r io.Reader, ch chan<- provider.StreamEvent) (reply, error) {
+	type toolBlock struct {
+		id, name string
+		input    strings.Builder
+	}
+	type unknownBlock struct {
+		typ      string
+		block    map[string]any
+		input    strings.Builder
+		thinking strings.Builder
+	}
+	blocks := map[int]*toolBlock{}
+	unknown := map[int]*unknownBlock{}
+	texts := map[int]*strings.Builder{}
+	var out reply
+	var total usage
+	thinkingChars := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+read:
+	for scanner.Scan() {
+		// Decode in place from the scanner's buffer; only the deltas are
+		// kept, so a long reply costs no more per event than a short one.
+		payload, ok := bytes.CutPrefix(scanner.Bytes(), dataPrefix)
+		if !ok {
+			continue
+		}
+		payload = bytes.TrimSpace(payload)
+		var event streamEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			continue
+		}
+		switch event.Type {
+		case "message_start":
+			total = event.Message.Usage
+			ch <- usageEvent(provider.StreamEventUsage, total, thinkingChars)
+		case "content_block_start":
+			if event.ContentBlock.Type == "text" {
+				texts[event.Index] = &strings.Builder{}
+			} else if event.ContentBlock.Type == "tool_use" {
+				blocks[event.Index] = &toolBlock{id: event.ContentBlock.ID, name: event.ContentBlock.Name}
+			} else if !knownBlock(event.ContentBlock.Type) {
+				var start struct {
+					ContentBlock map[string]any `json:"content_block"`
+				}
+				if err := json.Unmarshal(payload, &start); err == nil {
+					unknown[event.Index] = &unknownBlock{typ: event.ContentBlock.Type, block: start.ContentBlock}
+				}
+			}
+		case "content_block_delta":
+			switch event.Delta.Type {
+			case "text_delta":
+				if text := texts[event.Index]; text != nil {
+					text.WriteString(event.Delta.Text)
+				}
+				if event.Delta.Text != "" {
+					ch <- provider.StreamEvent{Type: provider.StreamEventText, Text: event.Delta.Text}
+				}
+			case "thinking_delta":
+				thinkingChars += len(event.Delta.Thinking)
+				if block := unknown[event.Index]; block != nil {
+					block.thinking.WriteString(event.Delta.Thinking)
+				}
+			case "signature_delta":
+				if block := unknown[event.Index]; block != nil {
+					block.block["signature"] = event.Delta.Signature
+				}
+			case "input_json_delta":
+				if block := blocks[event.Index]; block != nil {
+					block.input.WriteString(event.Delta.PartialJSON)
+					if event.Delta.PartialJSON != "" {
+						ch <- provider.StreamEvent{Type: provider.StreamEventToolCallDelta, Text: event.Delta.PartialJSON, ToolCall: tool.Call{ID: block.id, ToolID: block.name}}
+					}
+				} else if block := unknown[event.Index]; block != nil {
+					block.input.WriteString(event.Delta.PartialJSON)
+				}
+			}
+		case "content_block_stop":
+			if raw := unknown[event.Index]; raw != nil {
+				delete(unknown, event.Index)
+				// Server-side tool calls stream their input like tool_use.
+				if input := strings.TrimSpace(raw.input.String()); input != "" {
+					raw.block["input"] = json.RawMessage(input)
+				}
+				if raw.typ == "thinking" {
+					raw.block["thinking"] = raw.thinking.String()
+				}
+				data, err := json.Marshal(raw.block)
+				if err != nil {
+					return reply{}, fmt.Errorf("encode %s block: %w", raw.typ, err)
+				}
+				out.content = append(out.content, data)
+				ch <- rawContent(raw.typ, data)
+				continue
+			}
+			if text := texts[event.Index]; text != nil {
+				delete(texts, event.Index)
+				data, _ := json.Marshal(map[string]any{"type": "text", "text": text.String()})
+				out.content = append(out.content, data)
+				continue
+			}
+			block := blocks[event.Index]
+			if block == nil {
+				continue
+			}
+			delete(blocks, event.Index)
+			args := make(map[string]any)
+			if raw := strings.TrimSpace(block.input.String()); raw != "" {
+				if err := json.Unmarshal([]byte(raw), &args); err != nil {
+					return reply{}, fmt.Errorf("parse tool call %s input: %w", block.name, err)
+				}
+			}
+			data, _ := json.Marshal(map[string]any{"type": "tool_use", "id": block.id, "name": block.name, "input": args})
+			out.content = append(out.content, data)
+			ch <- provider.StreamEvent{Type: provider.StreamEventToolCall, ToolCall: tool.Call{ID: block.id, ToolID: block.name, Arguments: args}}
+		case "message_delta":
+			out.stopReason = cmp.Or(event.Delta.StopReason, out.stopReason)
+			if event.Usage != nil {
+				total.update(*event.Usage)
+				ch <- usageEvent(provider.StreamEventUsage, total, thinkingChars)
+			}
+		case "message_stop":
+			// Gateways relaying the API may report the stop reason and
+			// final usage here instead of in message_delta. The message
+			// is complete either way, so a connection left open after it
+			// does not hold the reply.
+			out.stopReason = cmp.Or(event.Message.StopReason, out.stopReason)
+			if event.Usage != nil {
+				total.update(*event.Usage)
+			}
+			break read
+		case "error":
+			if event.Error != nil {
+				return reply{}, fmt.Errorf("anthropic stream: %w", &provider.APIError{Status: streamErrorStatus(event.Error.Type), Code: event.Error.Type, Message: event.Error.Message, Body: string(payload)})
+			}
+			return reply{}, fmt.Errorf("anthropic stream: error event")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return reply{}, fmt.Errorf("reading stream: %w", err)
+	}
+	if total.InputTokens > 0 || total.OutputTokens > 0 {
+		ch <- usageEvent(provider.StreamEventDone, total, thinkingChars)
+	}
+	return out, nil
+}
+
+// streamErrorStatus is the HTTP status the API answers an error of typ
+// with, for an error that arrives mid-stream after a 200, so it is
+// classified and retried like one that arrived as the response.
+func streamErrorStatus(typ string) int {
+	switch typ {
+	case "overloaded_error":
+		return 529
+	case "rate_limit_error":
+		return http.StatusTooManyRequests
+	case "invalid_request_error":
+		return http.StatusBadRequest
+	case "authentication_error":
+		return http.StatusUnauthorized
+	case "permission_error":
+		return http.StatusForbidden
+	case "not_found_error":
+		return http.StatusNotFound
+	case "request_too_large":
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusInternalServerError
+}
+
+// decodeMessage handles a non-streaming Messages API response.
+func decodeMessage(r io.Reader, ch chan<- provider.StreamEvent) (reply, error) {
+	var result struct {
+		Content    []json.RawMessage `json:"content"`
+		StopReason string            `json:"stop_reason"`
+		Usage      usage             `json:"usage"`
+	}
+	if err := json.NewDecoder(r).Decode(&result); err != nil {
+		return reply{}, fmt.Errorf("anthropic decode: %w", err)
+	}
+
+	thinkingChars := 0
+	for _, raw := range result.Content {
+		var block struct {
+			Type     string `json:"type"`
+			Text     string `json:"text"`
+			Thinking string `json:"thinking"`
+			ID       string `json:"id"`
+			Name     string `json:"name"`
+			Input    any    `json:"input"`
+		}
+		if err := json.Unmarshal(raw, &block); err != nil {
+			return reply{}, fmt.Errorf("anthropic decode: %w", err)
+		}
+		if block.Type == "thinking" {
+			thinkingChars += len(block.Thinking)
+		}
+		if !knownBlock(block.Type) {
+			ch <- rawContent(block.Type, raw)
+			continue
+		}
 		switch block.Type {
 		case "text":
 			if strings.TrimSpace(block.Text) != "" {
@@ -129,24 +557,46 @@ func (p Provider) runMessages(ctx context.Context, baseURL string, req provider.
 
 	// Report usage.
 	if result.Usage.InputTokens > 0 || result.Usage.OutputTokens > 0 {
-		ch <- provider.StreamEvent{
-			Type:         provider.StreamEventDone,
-			InputTokens:  result.Usage.InputTokens,
-			OutputTokens: result.Usage.OutputTokens,
-		}
+		ch <- usageEvent(provider.StreamEventDone, result.Usage, thinkingChars)
 	}
 
-	return nil
+	return reply{content: result.Content, stopReason: result.StopReason}, nil
 }
 
 func toAnthropicMessages(messages []provider.Message) []map[string]any {
 	var out []map[string]any
-	for _, msg := range messages {
+	for i, msg := range messages {
+		// The API rejects a final assistant pre-fill that ends in whitespace.
+		if i == len(messages)-1 && msg.Role == "assistant" && len(msg.ToolCalls) == 0 {
+			msg.Content = strings.TrimRight(msg.Content, " \t\r\n")
+		}
 		switch msg.Role {
 		case "user":
-			out = append(out, map[string]any{"role": "user", "content": msg.Content})
+			if len(msg.Images) == 0 && len(msg.Documents) == 0 {
+				out = append(out, map[string]any{"role": "user", "content": msg.Content})
+				continue
+			}
+			content := []any{}
+			for _, doc := range msg.Documents {
+				content = append(content, documentBlock(doc))
+			}
+			for _, img := range msg.Images {
+				content = append(content, imageBlock(img))
+			}
+			if msg.Content != "" {
+				content = append(content, map[string]any{"type": "text", "text": msg.Content})
+			}
+			out = append(out, map[string]any{"role": "user", "content": content})
 		case "assistant":
-			content := []map[string]any{}
+			content := []any{}
+			// Unmodeled blocks (thinking, server tool calls and results) came
+			// first in the original response, ahead of the text that cites
+			// them.
+			for _, raw := range msg.Raw {
+				if raw.Provider == "anthropic" {
+					content = append(content, raw.Data)
+				}
+			}
 			if msg.Content != "" {
 				content = append(content, map[string]any{"type": "text", "text": msg.Content})
 			}
@@ -157,12 +607,20 @@ func toAnthropicMessages(messages []provider.Message) []map[string]any {
 			}
 			out = append(out, map[string]any{"role": "assistant", "content": content})
 		case "tool":
+			var result any = msg.Content
+			if len(msg.Images) > 0 {
+				blocks := []any{map[string]any{"type": "text", "text": msg.Content}}
+				for _, img := range msg.Images {
+					blocks = append(blocks, imageBlock(img))
+				}
+				result = blocks
+			}
 			out = append(out, map[string]any{
 				"role": "user",
 				"content": []map[string]any{{
 					"type":        "tool_result",
 					"tool_use_id": msg.ToolCallID,
-					"content":     msg.Content,
+					"content":     result,
 				}},
 			})
 		}
@@ -170,6 +628,50 @@ func toAnthropicMessages(messages []provider.Message) []map[string]any {
 	return out
 }
 
+func imageBlock(img provider.Image) map[string]any {
+	return map[string]any{
+		"type":   "image",
+		"source": map[string]any{"type": "base64", "media_type": img.MediaType, "data": base64.StdEncoding.EncodeToString(img.Data)},
+	}
+}
+
+// setCacheBreakpoints marks the end of the tools, the system prompt, and
+// the conversation so far, ahead of its trailing request-only messages, as
+// cacheable. The next turn sends the same prefix, and the API serves it
+// from the cache instead of processing it again. Prompts below the model's
+// minimum cacheable length are unaffected.
+func setCacheBreakpoints(body map[string]any, trailing int) {
+	ephemeral := map[string]any{"type": "ephemeral"}
+	if tools, _ := body["tools"].([]map[string]any); len(tools) > 0 {
+		tools[len(tools)-1]["cache_control"] = ephemeral
+	}
+	if system, ok := body["system"].(string); ok {
+		body["system"] = []map[string]any{{"type": "text", "text": system, "cache_control": ephemeral}}
+	}
+	messages, _ := body["messages"].([]map[string]any)
+	if len(messages) <= trailing {
+		return
+	}
+	last := messages[len(messages)-1-trailing]
+	switch content := last["content"].(type) {
+	case string:
+		if content != "" {
+			last["content"] = []map[string]any{{"type": "text", "text": content, "cache_control": ephemeral}}
+		}
+	case []map[string]any:
+		if len(content) > 0 {
+			content[len(content)-1]["cache_control"] = ephemeral
+		}
+	case []any:
+		// Raw blocks are passed through verbatim and cannot be marked.
+		if len(content) > 0 {
+			if block, ok := content[len(content)-1].(map[string]any); ok {
+				block["cache_control"] = ephemeral
+			}
+		}
+	}
+}
+
 func toAnthropicTools(defs []tool.Definition) []map[string]any {
 	var out []map[string]any
 	for _, def := range defs {
@@ -186,6 +688,61 @@ func toAnthropicTools(defs []tool.Definition) []map[string]any {
 	return out
 }
 
+// hostedToolVersions are the server tool versions the short names map to.
+var hostedToolVersions = map[string]string{
+	"web_search":     "web_search_20250305",
+	"web_fetch":      "web_fetch_20250910",
+	"code_execution": "code_execution_20250825",
+}
+
+// toHostedTools maps hosted tools to server tool definitions. A short name
+// takes the version in hostedToolVersions; a versioned type such as
+// web_search_20250305 is sent as is, named without its date.
+func toHostedTools(tools []provider.HostedTool) []map[string]any {
+	var out []map[string]any
+	for _, t := range tools {
+		typ, name := t.Type, t.Type
+		if v, ok := hostedToolVersions[typ]; ok {
+			typ = v
+		} else if i := strings.LastIndexByte(typ, '_'); i > 0 && isDigits(typ[i+1:]) {
+			name = typ[:i]
+		}
+		def := map[string]any{}
+		for k, v := range t.Options {
+			def[k] = v
+		}
+		def["type"], def["name"] = typ, name
+		out = append(out, def)
+	}
+	return out
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// toAnthropicToolChoice maps a tool choice to tool_choice, or nil to leave
+// the API's default (auto).
+func toAnthropicToolChoice(choice provider.ToolChoice) map[string]any {
+	if id, ok := choice.Tool(); ok {
+		return map[string]any{"type": "tool", "name": sanitizeName(id)}
+	}
+	switch choice {
+	case provider.ToolChoiceAuto:
+		return map[string]any{"type": "auto"}
+	case provider.ToolChoiceNone:
+		return map[string]any{"type": "none"}
+	case provider.ToolChoiceRequired:
+		return map[string]any{"type": "any"}
+	}
+	return nil
+}
+
 func sanitizeName(id string) string {
 	var b strings.Builder
 	for _, r := range id {
@@ -0,0 +1,849 @@
+package anthropic
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+func TestProviderStreamsTextToolCallsAndUsage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("decode body: %v", err)
+		}
+		if body["stream"] != true {
+			t.Fatalf("expected a streaming request, got %#v", body["stream"])
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range []string{
+			`{"type":"message_start","message":{"usage":{"input_tokens":120,"output_tokens":1}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Reading "}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"it."}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"read"}}`,
+			`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"path\":"}}`,
+			`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"a.go\"}"}}`,
+			`{"type":"content_block_stop","index":1}`,
+			`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":42}}`,
+			`{"type":"message_stop"}`,
+		} {
+			fmt.Fprintf(w, "event: x\ndata: %s\n\n", event)
+		}
+	}))
+	defer server.Close()
+
+	p := Provider{APIKey: "test", BaseURL: server.URL, HTTPClient: server.Client()}
+	stream, err := p.Stream(context.Background(), provider.CompletionRequest{
+		Model:    provider.ModelRef{Model: "claude-sonnet-4-5"},
+		Messages: []provider.Message{{Role: "user", Content: "read a.go"}},
+	})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	var text string
+	var usage []provider.StreamEvent
+	var done provider.StreamEvent
+	var call provider.StreamEvent
+	for event := range stream {
+		if event.Err != nil {
+			t.Fatalf("event error: %v", event.Err)
+		}
+		switch event.Type {
+		case provider.StreamEventText:
+			text += event.Text
+		case provider.StreamEventToolCall:
+			call = event
+		case provider.StreamEventUsage:
+			usage = append(usage, event)
+		case provider.StreamEventDone:
+			if event.InputTokens > 0 {
+				done = event
+			}
+		}
+	}
+	if text != "Reading it." {
+		t.Fatalf("unexpected text: %q", text)
+	}
+	if call.ToolCall.ID != "toolu_1" || call.ToolCall.ToolID != "read" || call.ToolCall.Arguments["path"] != "a.go" {
+		t.Fatalf("unexpected tool call: %+v", call.ToolCall)
+	}
+	if len(usage) != 2 || usage[0].InputTokens != 120 || usage[1].OutputTokens != 42 || usage[1].InputTokens != 120 {
+		t.Fatalf("unexpected running usage: %+v", usage)
+	}
+	if done.InputTokens != 120 || done.OutputTokens != 42 {
+		t.Fatalf("unexpected final usage: %+v", done)
+	}
+}
+
+func TestProviderEstimatesReasoningFromThinking(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range []string{
+			`{"type":"message_start","message":{"usage":{"input_tokens":10,"output_tokens":1}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"thinking"}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"` + strings.Repeat("abcd", 50) + `"}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"content_block_start","index":1,"content_block":{"type":"text","text":""}}`,
+			`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"Done."}}`,
+			`{"type":"content_block_stop","index":1}`,
+			`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":60}}`,
+		} {
+			fmt.Fprintf(w, "data: %s\n\n", event)
+		}
+	}))
+	defer server.Close()
+
+	p := Provider{APIKey: "test", BaseURL: server.URL, HTTPClient: server.Client()}
+	stream, err := p.Stream(context.Background(), provider.CompletionRequest{
+		Model:    provider.ModelRef{Model: "claude-sonnet-4-5"},
+		Messages: []provider.Message{{Role: "user", Content: "think first"}},
+	})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	var text string
+	var done provider.StreamEvent
+	for event := range stream {
+		if event.Err != nil {
+			t.Fatalf("event error: %v", event.Err)
+		}
+		switch event.Type {
+		case provider.StreamEventText:
+			text += event.Text
+		case provider.StreamEventDone:
+			if event.InputTokens > 0 {
+				done = event
+			}
+		}
+	}
+	if text != "Done." {
+		t.Fatalf("thinking leaked into text: %q", text)
+	}
+	if done.OutputTokens != 60 || done.ReasoningTokens != 50 {
+		t.Fatalf("unexpected final usage: %+v", done)
+	}
+}
+
+func TestProviderPreservesUnknownContentBlocks(t *testing.T) {
+	var sent []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Messages []map[string]any `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("decode body: %v", err)
+		}
+		sent = body.Messages
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range []string{
+			`{"type":"content_block_start","index":0,"content_block":{"type":"server_tool_use","id":"srvtoolu_1","name":"web_search","input":{}}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"{\"query\":\"go 1.27\"}"}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"content_block_start","index":1,"content_block":{"type":"web_search_tool_result","tool_use_id":"srvtoolu_1","content":[{"type":"web_search_result","url":"https://go.dev"}]}}`,
+			`{"type":"content_block_stop","index":1}`,
+			`{"type":"content_block_start","index":2,"content_block":{"type":"text","text":""}}`,
+			`{"type":"content_block_delta","index":2,"delta":{"type":"text_delta","text":"Released."}}`,
+			`{"type":"content_block_stop","index":2}`,
+		} {
+			fmt.Fprintf(w, "event: x\ndata: %s\n\n", event)
+		}
+	}))
+	defer server.Close()
+
+	p := Provider{APIKey: "test", BaseURL: server.URL, HTTPClient: server.Client()}
+	stream, err := p.Stream(context.Background(), provider.CompletionRequest{Messages: []provider.Message{{Role: "user", Content: "news?"}}})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	reply := provider.Message{Role: "assistant"}
+	for event := range stream {
+		switch event.Type {
+		case provider.StreamEventRaw:
+			reply.Raw = append(reply.Raw, event.Raw)
+		case provider.StreamEventText:
+			reply.Content += event.Text
+		}
+	}
+	if len(reply.Raw) != 2 || reply.Raw[0].Type != "server_tool_use" || reply.Raw[1].Type != "web_search_tool_result" {
+		t.Fatalf("unexpected raw blocks: %+v", reply.Raw)
+	}
+	if !strings.Contains(string(reply.Raw[0].Data), `"input":{"query":"go 1.27"}`) {
+		t.Fatalf("expected the streamed input in the raw block, got %s", reply.Raw[0].Data)
+	}
+	if reply.Raw[0].HostedTool != "web_search" || reply.Raw[1].HostedTool != "web_search" {
+		t.Fatalf("expected both blocks attributed to web_search, got %+v", reply.Raw)
+	}
+
+	// The blocks go back verbatim, ahead of the text, on the next turn.
+	stream, err = p.Stream(context.Background(), provider.CompletionRequest{Messages: []provider.Message{{Role: "user", Content: "news?"}, reply, {Role: "user", Content: "thanks"}}})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	for range stream {
+	}
+	content, _ := sent[1]["content"].([]any)
+	if len(content) != 3 {
+		t.Fatalf("expected raw blocks and text, got %#v", sent[1]["content"])
+	}
+	first, _ := content[0].(map[string]any)
+	last, _ := content[2].(map[string]any)
+	if first["type"] != "server_tool_use" || last["type"] != "text" {
+		t.Fatalf("unexpected block order: %#v", content)
+	}
+}
+
+func TestStalledStreamsAreAborted(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "event: x\ndata: %s\n\n", `{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`)
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	p := Provider{APIKey: "test", BaseURL: server.URL, HTTPClient: server.Client(), StallTimeout: 50 * time.Millisecond}
+	stream, err := p.Stream(context.Background(), provider.CompletionRequest{Messages: []provider.Message{{Role: "user", Content: "hi"}}})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	var streamErr error
+	for event := range stream {
+		if event.Err != nil {
+			streamErr = event.Err
+		}
+	}
+	if !errors.Is(streamErr, provider.ErrStreamStalled) {
+		t.Fatalf("expected a stall error, got %v", streamErr)
+	}
+}
+
+func TestThinkingBlocksRoundTripWithTheirSignatures(t *testing.T) {
+	var bodies []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("decode body: %v", err)
+		}
+		bodies = append(bodies, body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range []string{
+			`{"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":"","signature":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"Read the "}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"file first."}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"signature_delta","signature":"sig=="}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"content_block_start","index":1,"content_block":{"type":"redacted_thinking","data":"opaque"}}`,
+			`{"type":"content_block_stop","index":1}`,
+			`{"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_1","name":"read","input":{}}}`,
+			`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"{\"path\":\"a.go\"}"}}`,
+			`{"type":"content_block_stop","index":2}`,
+		} {
+			fmt.Fprintf(w, "data: %s\n\n", event)
+		}
+	}))
+	defer server.Close()
+
+	p := Provider{APIKey: "test", BaseURL: server.URL, HTTPClient: server.Client(), ThinkingBudget: 2048}
+	req := provider.CompletionRequest{Model: provider.ModelRef{Model: "claude-sonnet-4-5"}, Messages: []provider.Message{{Role: "user", Content: "fix a.go"}}}
+	stream, err := p.Stream(context.Background(), req)
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	reply := provider.Message{Role: "assistant"}
+	for event := range stream {
+		switch event.Type {
+		case provider.StreamEventRaw:
+			reply.Raw = append(reply.Raw, event.Raw)
+		case provider.StreamEventText:
+			reply.Content += event.Text
+		case provider.StreamEventToolCall:
+			reply.ToolCalls = append(reply.ToolCalls, event.ToolCall)
+		}
+	}
+	if reply.Content != "" || len(reply.ToolCalls) != 1 {
+		t.Fatalf("expected only the tool call outside the raw blocks, got %+v", reply)
+	}
+	thinking, _ := bodies[0]["thinking"].(map[string]any)
+	if thinking["type"] != "enabled" || thinking["budget_tokens"] != float64(2048) || bodies[0]["max_tokens"] != float64(2048+4096) {
+		t.Fatalf("expected extended thinking requested, got %v and max_tokens %v", bodies[0]["thinking"], bodies[0]["max_tokens"])
+	}
+
+	// The thinking goes back first and verbatim while the tool use is answered.
+	req.Messages = append(req.Messages, reply, provider.Message{Role: "tool", ToolCallID: "toolu_1", Content: "package a"})
+	stream, err = p.Stream(context.Background(), req)
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	for range stream {
+	}
+	messages, _ := bodies[1]["messages"].([]any)
+	assistant, _ := messages[1].(map[string]any)
+	got, err := json.Marshal(assistant["content"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"signature":"sig==","thinking":"Read the file first.","type":"thinking"},{"data":"opaque","type":"redacted_thinking"},{"id":"toolu_1","input":{"path":"a.go"},"name":"read","type":"tool_use"}]`
+	if string(got) != want {
+		t.Fatalf("assistant content:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestImagesAreSentAsBase64Blocks(t *testing.T) {
+	out, err := json.Marshal(toAnthropicMessages([]provider.Message{{Role: "user", Content: "what is this?", Images: []provider.Image{{MediaType: "image/jpeg", Data: []byte("jpg")}}}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"content":[{"source":{"data":"anBn","media_type":"image/jpeg","type":"base64"},"type":"image"},{"text":"what is this?","type":"text"}],"role":"user"}]`; string(out) != want {
+		t.Fatalf("messages:\n got %s\nwant %s", out, want)
+	}
+}
+
+func TestToolResultImagesAreSentInTheToolResult(t *testing.T) {
+	out, err := json.Marshal(toAnthropicMessages([]provider.Message{{Role: "tool", Content: "image a.png", ToolCallID: "c1", Images: []provider.Image{{MediaType: "image/png", Data: []byte("png")}}}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"content":[{"content":[{"text":"image a.png","type":"text"},{"source":{"data":"cG5n","media_type":"image/png","type":"base64"},"type":"image"}],"tool_use_id":"c1","type":"tool_result"}],"role":"user"}]`; string(out) != want {
+		t.Fatalf("messages:\n got %s\nwant %s", out, want)
+	}
+}
+
+func TestToolChoiceIsSentAsToolChoice(t *testing.T) {
+	for choice, want := range map[provider.ToolChoice]string{
+		"":                          `null`,
+		provider.ToolChoiceAuto:     `{"type":"auto"}`,
+		provider.ToolChoiceNone:     `{"type":"none"}`,
+		provider.ToolChoiceRequired: `{"type":"any"}`,
+		"email/draft":               `{"name":"email_draft","type":"tool"}`,
+	} {
+		out, err := json.Marshal(toAnthropicToolChoice(choice))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != want {
+			t.Fatalf("tool choice %q:\n got %s\nwant %s", choice, out, want)
+		}
+	}
+}
+
+func TestThinkingIsLeftOutOfTurnsThatCannotTakeIt(t *testing.T) {
+	var bodies []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("decode body: %v", err)
+		}
+		bodies = append(bodies, body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"type\":\"message_stop\"}\n\n")
+	}))
+	defer server.Close()
+
+	p := Provider{APIKey: "test", BaseURL: server.URL, HTTPClient: server.Client(), ThinkingBudget: 2048}
+	user := provider.Message{Role: "user", Content: "draft the reply"}
+	tools := []tool.Definition{{ID: "email/draft", Description: "Draft an email."}}
+	for _, req := range []provider.CompletionRequest{
+		{Messages: []provider.Message{user}, Tools: tools, ToolChoice: provider.ToolChoiceAuto},
+		{Messages: []provider.Message{user}, Tools: tools, ToolChoice: provider.ToolChoiceRequired},
+		{Messages: []provider.Message{user}, Tools: tools, ToolChoice: "email/draft"},
+		{Messages: []provider.Message{user, {Role: "assistant", Content: "{"}}},
+		{Messages: []provider.Message{user}, Model: provider.ModelRef{Model: "claude-3-5-haiku-20241022"}},
+	} {
+		req.Model.Model = cmp.Or(req.Model.Model, "claude-sonnet-4-5")
+		stream, err := p.Stream(context.Background(), req)
+		if err != nil {
+			t.Fatalf("stream: %v", err)
+		}
+		for range stream {
+		}
+	}
+	for i, want := range []bool{true, false, false, false, false} {
+		if _, thinking := bodies[i]["thinking"]; thinking != want {
+			t.Errorf("request %d: thinking sent = %v, want %v (tool_choice %v)", i, thinking, want, bodies[i]["tool_choice"])
+		}
+	}
+}
+
+func TestHostedToolsAreSentAsServerTools(t *testing.T) {
+	tools := append(toAnthropicTools([]tool.Definition{{ID: "core/read"}}), toHostedTools([]provider.HostedTool{
+		{Type: "web_search", Options: map[string]any{"max_uses": 3}},
+		{Type: "code_execution_20250522"},
+	})...)
+	out, err := json.Marshal(tools[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"max_uses":3,"name":"web_search","type":"web_search_20250305"},{"name":"code_execution","type":"code_execution_20250522"}]`
+	if string(out) != want {
+		t.Fatalf("hosted tools:\n got %s\nwant %s", out, want)
+	}
+	if tools[0]["name"] != "core_read" {
+		t.Fatalf("expected the local tool first, got %#v", tools[0])
+	}
+}
+
+func TestPausedTurnsAreContinued(t *testing.T) {
+	var sent [][]map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Messages []map[string]any `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("decode body: %v", err)
+		}
+		sent = append(sent, body.Messages)
+		w.Header().Set("Content-Type", "text/event-stream")
+		events := []string{
+			`{"type":"message_start","message":{"usage":{"input_tokens":10,"output_tokens":1}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Searching. "}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"content_block_start","index":1,"content_block":{"type":"server_tool_use","id":"srvtoolu_1","name":"web_search","input":{}}}`,
+			`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"query\":\"go 1.27\"}"}}`,
+			`{"type":"content_block_stop","index":1}`,
+			`{"type":"message_delta","delta":{"stop_reason":"pause_turn"},"usage":{"output_tokens":5}}`,
+		}
+		if len(sent) > 1 {
+			events = []string{
+				`{"type":"message_start","message":{"usage":{"input_tokens":20,"output_tokens":1}}}`,
+				`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+				`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Released."}}`,
+				`{"type":"content_block_stop","index":0}`,
+				`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":3}}`,
+			}
+		}
+		for _, event := range events {
+			fmt.Fprintf(w, "event: x\ndata: %s\n\n", event)
+		}
+	}))
+	defer server.Close()
+
+	p := Provider{APIKey: "test", BaseURL: server.URL, HTTPClient: server.Client()}
+	stream, err := p.Stream(context.Background(), provider.CompletionRequest{Messages: []provider.Message{{Role: "user", Content: "news?"}}})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	var text string
+	output := 0
+	for event := range stream {
+		if event.Err != nil {
+			t.Fatal(event.Err)
+		}
+		switch event.Type {
+		case provider.StreamEventText:
+			text += event.Text
+		case provider.StreamEventDone:
+			output += event.OutputTokens
+		}
+	}
+	if len(sent) != 2 || text != "Searching. Released." || output != 8 {
+		t.Fatalf("expected the paused turn continued, got %d requests, %q, %d output tokens", len(sent), text, output)
+	}
+	// The paused reply goes back as it was, as the last message.
+	last := sent[1][len(sent[1])-1]
+	content, _ := last["content"].([]any)
+	if last["role"] != "assistant" || len(content) != 2 {
+		t.Fatalf("expected the paused reply sent back, got %#v", last)
+	}
+	first, _ := content[0].(map[string]any)
+	use, _ := content[1].(map[string]any)
+	if first["text"] != "Searching. " || use["type"] != "server_tool_use" || use["input"].(map[string]any)["query"] != "go 1.27" {
+		t.Fatalf("unexpected paused blocks: %#v", content)
+	}
+}
+
+func TestDocumentsAreSentAsDocumentBlocks(t *testing.T) {
+	var beta, messages string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		beta = r.Header.Get("anthropic-beta")
+		var body struct {
+			Messages json.RawMessage `json:"messages"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		messages = string(body.Messages)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"content":[{"type":"text","text":"a report"}],"usage":{"input_tokens":10,"output_tokens":2}}`)
+	}))
+	defer server.Close()
+
+	p := Provider{APIKey: "test-key", BaseURL: server.URL, HTTPClient: server.Client(), NoCache: true}
+	stream, err := p.Stream(context.Background(), provider.CompletionRequest{
+		Model: provider.ModelRef{Model: "claude-sonnet-4-5"},
+		Messages: []provider.Message{{Role: "user", Content: "summarize", Documents: []provider.Document{
+			{Name: "inline.pdf", MediaType: "application/pdf", Data: []byte("pdf")},
+			{MediaType: "application/pdf", FileID: "file_1"},
+		}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for event := range stream {
+		if event.Err != nil {
+			t.Fatalf("event error: %v", event.Err)
+		}
+	}
+	if want := `[{"content":[{"source":{"data":"cGRm","media_type":"application/pdf","type":"base64"},"title":"inline.pdf","type":"document"},{"source":{"file_id":"file_1","type":"file"},"type":"document"},{"text":"summarize","type":"text"}],"role":"user"}]`; messages != want {
+		t.Fatalf("messages:\n got %s\nwant %s", messages, want)
+	}
+	if beta != filesBeta {
+		t.Fatalf("expected the files beta header with a file source, got %q", beta)
+	}
+}
+
+func TestBetaFeaturesAndHeadersAreSent(t *testing.T) {
+	var got *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"content":[{"type":"text","text":"ok"}],"usage":{"input_tokens":1,"output_tokens":1}}`)
+	}))
+	defer server.Close()
+
+	p := Provider{
+		APIKey: "test-key", BaseURL: server.URL, HTTPClient: server.Client(), NoCache: true,
+		Beta:    []string{"context-1m-2025-08-07"},
+		Headers: map[string]string{"X-Gateway-Route": "claude"},
+		Query:   map[string]string{"region": "eu"},
+	}
+	stream, err := p.Stream(context.Background(), provider.CompletionRequest{
+		Model: provider.ModelRef{Model: "claude-sonnet-4-5"},
+		Messages: []provider.Message{{Role: "user", Content: "hi", Documents: []provider.Document{
+			{MediaType: "application/pdf", FileID: "file_1"},
+		}}},
+		ExtraHeaders: map[string]string{"X-Trace": "t1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for event := range stream {
+		if event.Err != nil {
+			t.Fatalf("event error: %v", event.Err)
+		}
+	}
+	if beta := got.Header.Get("anthropic-beta"); beta != "context-1m-2025-08-07,"+filesBeta {
+		t.Errorf("unexpected beta header %q", beta)
+	}
+	if got.Header.Get("X-Gateway-Route") != "claude" || got.Header.Get("X-Trace") != "t1" || got.URL.Query().Get("region") != "eu" {
+		t.Errorf("missing extras: headers %v, query %q", got.Header, got.URL.RawQuery)
+	}
+}
+
+func TestOverloadedResponsesAreTypedAPIErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3")
+		w.WriteHeader(529)
+		fmt.Fprint(w, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`)
+	}))
+	defer server.Close()
+
+	p := Provider{APIKey: "test-key", BaseURL: server.URL, HTTPClient: server.Client()}
+	stream, err := p.Stream(context.Background(), provider.CompletionRequest{
+		Model:    provider.ModelRef{Model: "claude-sonnet-4-5"},
+		Messages: []provider.Message{{Role: "user", Content: "hi"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var apiErr *provider.APIError
+	for event := range stream {
+		if event.Err != nil {
+			errors.As(event.Err, &apiErr)
+		}
+	}
+	if apiErr == nil || apiErr.Code != "overloaded_error" || apiErr.RetryAfter != 3*time.Second || apiErr.Kind() != provider.ErrorOverloaded {
+		t.Fatalf("unexpected error %+v", apiErr)
+	}
+}
+
+func TestStreamErrorEventsAreTypedAPIErrors(t *testing.T) {
+	for typ, want := range map[string]provider.ErrorKind{"overloaded_error": provider.ErrorOverloaded, "rate_limit_error": provider.ErrorRateLimited} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":%q,\"message\":\"try later\"}}\n\n", typ)
+		}))
+		p := Provider{APIKey: "test-key", BaseURL: server.URL, HTTPClient: server.Client()}
+		stream, err := p.Stream(context.Background(), provider.CompletionRequest{
+			Model:    provider.ModelRef{Model: "claude-sonnet-4-5"},
+			Messages: []provider.Message{{Role: "user", Content: "hi"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var apiErr *provider.APIError
+		for event := range stream {
+			if event.Err != nil {
+				errors.As(event.Err, &apiErr)
+			}
+		}
+		server.Close()
+		if apiErr == nil || apiErr.Code != typ || apiErr.Message != "try later" || apiErr.Kind() != want {
+			t.Fatalf("%s: unexpected error %+v", typ, apiErr)
+		}
+	}
+}
+
+func TestPromptCacheBreakpointsAndUsage(t *testing.T) {
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("decode body: %v", err)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range []string{
+			`{"type":"message_start","message":{"usage":{"input_tokens":20,"cache_read_input_tokens":900,"cache_creation_input_tokens":80,"output_tokens":1}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"ok"}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":5}}`,
+		} {
+			fmt.Fprintf(w, "data: %s\n\n", event)
+		}
+	}))
+	defer server.Close()
+
+	p := Provider{APIKey: "test", BaseURL: server.URL, HTTPClient: server.Client()}
+	stream, err := p.Stream(context.Background(), provider.CompletionRequest{
+		Model:    provider.ModelRef{Model: "claude-sonnet-4-5"},
+		System:   "You are a test agent.",
+		Messages: []provider.Message{{Role: "user", Content: "hi"}},
+		Tools:    []tool.Definition{{ID: "core/read", Description: "read"}, {ID: "core/write", Description: "write"}},
+	})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	var done provider.StreamEvent
+	for event := range stream {
+		if event.Err != nil {
+			t.Fatalf("event error: %v", event.Err)
+		}
+		if event.Type == provider.StreamEventDone && event.InputTokens > 0 {
+			done = event
+		}
+	}
+	if done.InputTokens != 1000 || done.CacheReadTokens != 900 || done.CacheWriteTokens != 80 || done.OutputTokens != 5 {
+		t.Fatalf("unexpected final usage: %+v", done)
+	}
+
+	marked := func(v any) bool {
+		block, _ := v.(map[string]any)
+		return block["cache_control"] != nil
+	}
+	tools := body["tools"].([]any)
+	if marked(tools[0]) || !marked(tools[1]) {
+		t.Fatalf("expected only the last tool marked: %v", tools)
+	}
+	if system := body["system"].([]any); !marked(system[0]) {
+		t.Fatalf("expected the system prompt marked: %v", system)
+	}
+	content := body["messages"].([]any)[0].(map[string]any)["content"].([]any)
+	if !marked(content[len(content)-1]) {
+		t.Fatalf("expected the last message marked: %v", content)
+	}
+
+	// Request-only messages at the end stay out of the cached prefix.
+	stream, err = p.Stream(context.Background(), provider.CompletionRequest{
+		Model:    provider.ModelRef{Model: "claude-sonnet-4-5"},
+		Messages: []provider.Message{{Role: "user", Content: "hi"}, {Role: "user", Content: "Reply in Spanish."}},
+		Trailing: 1,
+	})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	for range stream {
+	}
+	messages := body["messages"].([]any)
+	if _, plain := messages[1].(map[string]any)["content"].(string); !plain || !marked(messages[0].(map[string]any)["content"].([]any)[0]) {
+		t.Fatalf("expected the breakpoint before the trailing message: %v", messages)
+	}
+
+	// A one-off request asks not to be cached.
+	stream, err = p.Stream(context.Background(), provider.CompletionRequest{
+		Model:    provider.ModelRef{Model: "claude-sonnet-4-5"},
+		System:   "Title this conversation.",
+		Messages: []provider.Message{{Role: "user", Content: "hi"}},
+		NoCache:  true,
+	})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	for range stream {
+	}
+	if _, plain := body["system"].(string); !plain {
+		t.Fatalf("expected no breakpoints on a NoCache request: %v", body["system"])
+	}
+}
+
+func TestProviderValidateClassifiesFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Header.Get("x-api-key") != "good":
+			http.Error(w, `{"type":"error","error":{"type":"authentication_error"}}`, http.StatusUnauthorized)
+		case r.URL.Path != "/v1/models/claude-sonnet-4-5":
+			http.Error(w, `{"type":"error","error":{"type":"not_found_error"}}`, http.StatusNotFound)
+		default:
+			fmt.Fprint(w, `{"id":"claude-sonnet-4-5","type":"model"}`)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	if err := (Provider{APIKey: "good", BaseURL: server.URL}).Validate(ctx, "claude-sonnet-4-5"); err != nil {
+		t.Fatalf("expected a valid configuration, got %v", err)
+	}
+	for _, tc := range []struct {
+		provider Provider
+		model    string
+		kind     provider.PreflightKind
+	}{
+		{Provider{BaseURL: server.URL}, "claude-sonnet-4-5", provider.PreflightAuth},
+		{Provider{APIKey: "bad", BaseURL: server.URL}, "claude-sonnet-4-5", provider.PreflightAuth},
+		{Provider{APIKey: "good", BaseURL: server.URL}, "claude-nope", provider.PreflightModelNotFound},
+		{Provider{APIKey: "good", BaseURL: "http://127.0.0.1:1"}, "claude-sonnet-4-5", provider.PreflightNetwork},
+	} {
+		var preflight *provider.PreflightError
+		if err := provider.Preflight(ctx, tc.provider, tc.model); !errors.As(err, &preflight) || preflight.Kind != tc.kind {
+			t.Fatalf("%s with key %q: expected a %s failure, got %v", tc.model, tc.provider.APIKey, tc.kind, err)
+		}
+	}
+}
+
+// longStream is a reply of deltas text chunks followed by calls tool calls
+// whose input arrives in 20 pieces each.
+func longStream(deltas, calls int) []byte {
+	var b strings.Builder
+	write := func(event string) { fmt.Fprintf(&b, "event: x\ndata: %s\n\n", event) }
+	write(`{"type":"message_start","message":{"usage":{"input_tokens":1200,"output_tokens":1}}}`)
+	write(`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`)
+	for i := 0; i < deltas; i++ {
+		write(`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"a few more words of the answer "}}`)
+	}
+	write(`{"type":"content_block_stop","index":0}`)
+	for i := 1; i <= calls; i++ {
+		write(fmt.Sprintf(`{"type":"content_block_start","index":%d,"content_block":{"type":"tool_use","id":"toolu_%d","name":"core_read"}}`, i, i))
+		write(fmt.Sprintf(`{"type":"content_block_delta","index":%d,"delta":{"type":"input_json_delta","partial_json":"{\"path\": \""}}`, i))
+		for j := 0; j < 18; j++ {
+			write(fmt.Sprintf(`{"type":"content_block_delta","index":%d,"delta":{"type":"input_json_delta","partial_json":"dir/"}}`, i))
+		}
+		write(fmt.Sprintf(`{"type":"content_block_delta","index":%d,"delta":{"type":"input_json_delta","partial_json":"main.go\"}"}}`, i))
+		write(fmt.Sprintf(`{"type":"content_block_stop","index":%d}`, i))
+	}
+	write(`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":4000}}`)
+	return []byte(b.String())
+}
+
+func BenchmarkReadStreamLongReply(b *testing.B) {
+	body := longStream(4000, 50)
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	for b.Loop() {
+		ch := make(chan provider.StreamEvent, 64)
+		go func() {
+			for range ch {
+			}
+		}()
+		if _, err := readStream(bytes.NewReader(body), ch); err != nil {
+			b.Fatal(err)
+		}
+		close(ch)
+	}
+}
+
+func TestMessageStopEndsTheReply(t *testing.T) {
+	// A gateway reports the stop reason and final usage on message_stop
+	// and leaves the connection open after it.
+	r, w := io.Pipe()
+	defer w.Close()
+	go func() {
+		for _, event := range []string{
+			`{"type":"message_start","message":{"usage":{"input_tokens":12,"output_tokens":1}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"ok"}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"message_stop","message":{"stop_reason":"max_tokens"},"usage":{"output_tokens":9}}`,
+		} {
+			fmt.Fprintf(w, "data: %s\n\n", event)
+		}
+	}()
+	ch := make(chan provider.StreamEvent, 16)
+	type result struct {
+		reply reply
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		out, err := readStream(r, ch)
+		done <- result{out, err}
+	}()
+	var got result
+	select {
+	case got = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected message_stop to end the reply")
+	}
+	close(ch)
+	if got.err != nil || got.reply.stopReason != "max_tokens" {
+		t.Fatalf("expected the stop reason from message_stop, got %q (%v)", got.reply.stopReason, got.err)
+	}
+	var final provider.StreamEvent
+	for event := range ch {
+		if event.Type == provider.StreamEventDone {
+			final = event
+		}
+	}
+	if final.InputTokens != 12 || final.OutputTokens != 9 {
+		t.Fatalf("expected the final usage from message_stop, got %+v", final)
+	}
+}
+
+func TestValidToolCallIDRejectsForeignFormats(t *testing.T) {
+	p := Provider{}
+	for id, want := range map[string]bool{
+		"toolu_01A09q90qw90lq917835lq9": true,
+		"call_abc-123":                  true,
+		"call_abc|fc_123":               false,
+		"functions.read:0":              false,
+		"":                              false,
+	} {
+		if got := p.ValidToolCallID(id); got != want {
+			t.Errorf("ValidToolCallID(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
+
+func TestThinkingBudgetScalesWithTheLevel(t *testing.T) {
+	for _, tc := range []struct {
+		configured int
+		level      provider.ThinkingLevel
+		want       int
+	}{
+		{0, "", 0},
+		{8192, "", 8192},
+		{8192, provider.ThinkingHigh, 8192},
+		{8192, provider.ThinkingMedium, 4096},
+		{2048, provider.ThinkingLow, minThinkingBudget},
+		{0, provider.ThinkingMedium, defaultThinkingBudget / 2},
+	} {
+		if got := thinkingBudget(tc.configured, tc.level); got != tc.want {
+			t.Errorf("thinkingBudget(%d, %q) = %d, want %d", tc.configured, tc.level, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package anthropic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+)
+
+// Validate checks the key, base URL, and model by retrieving the model
+// from the Models API, which costs no tokens.
+func (p Provider) Validate(ctx context.Context, model string) error {
+	fail := func(kind provider.PreflightKind, err error) error {
+		return &provider.PreflightError{Provider: p.Name(), Model: model, Kind: kind, Err: err}
+	}
+	if strings.TrimSpace(p.APIKey) == "" {
+		return fail(provider.PreflightAuth, errors.New("API key is required"))
+	}
+	baseURL := p.BaseURL
+	if baseURL == "" {
+		baseURL = "https://api.anthropic.com"
+	}
+	client := p.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		strings.TrimRight(baseURL, "/")+"/v1/models/"+url.PathEscape(model), nil)
+	if err != nil {
+		return fail(provider.PreflightNetwork, err)
+	}
+	p.setHeaders(httpReq)
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return fail(provider.PreflightNetwork, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fail(provider.PreflightStatus(resp.StatusCode), fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body))))
+	}
+	return nil
+}
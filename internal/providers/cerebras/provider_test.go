@@ -0,0 +1,52 @@
+package cerebras
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+)
+
+func TestTimeInfoIsReportedWithTheUsage(t *testing.T) {
+	var sent map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&sent)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintln(w, `data: {"choices":[{"delta":{"content":"quick"}}]}`)
+		fmt.Fprintln(w, `data: {"choices":[{"delta":{},"finish_reason":"stop"}],"usage":{"prompt_tokens":1000,"completion_tokens":300,"total_tokens":1300},"time_info":{"queue_time":0.0005,"prompt_time":0.01,"completion_time":0.15,"total_time":0.17}}`)
+		fmt.Fprintln(w, `data: [DONE]`)
+	}))
+	defer server.Close()
+
+	p := Provider{APIKey: "test-key", BaseURL: server.URL, ServiceTier: "priority", HTTPClient: server.Client()}
+	stream, err := p.Stream(context.Background(), provider.CompletionRequest{
+		Model:    provider.ModelRef{Model: "llama3.1-8b"},
+		Messages: []provider.Message{{Role: "user", Content: "hi"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var done provider.StreamEvent
+	for event := range stream {
+		if event.Err != nil {
+			t.Fatalf("event error: %v", event.Err)
+		}
+		if event.Type == provider.StreamEventDone {
+			done = event
+		}
+	}
+	if done.InputTokens != 1000 || done.OutputTokens != 300 {
+		t.Fatalf("unexpected usage %+v", done)
+	}
+	if done.Timing == nil || done.Timing.Prompt != 10*time.Millisecond || done.Timing.OutputThroughput(300) != 2000 {
+		t.Fatalf("unexpected timing %+v", done.Timing)
+	}
+	if sent["service_tier"] != "priority" {
+		t.Fatalf("expected the service tier sent, got %v", sent["service_tier"])
+	}
+}
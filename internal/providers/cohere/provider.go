@@ -0,0 +1,563 @@
+// Package cohere implements the Cohere v2 Chat API provider for the
+// Command R and Command A models. It is native rather than routed through
+// Cohere's OpenAI compatible endpoint, which drops the tool plans and
+// citations the v2 API streams: tool plans are shown as reasoning, and both
+// are kept as raw content so they are sent back with the conversation.
+package cohere
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bitop-dev/agent/internal/models"
+	"github.com/bitop-dev/agent/pkg/provider"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// DefaultBaseURL is the Cohere API endpoint.
+const DefaultBaseURL = "https://api.cohere.com"
+
+// Raw content types of the blocks Cohere streams that the runtime does not
+// model.
+const (
+	rawToolPlan = "tool_plan"
+	rawCitation = "citation"
+)
+
+type Provider struct {
+	APIKey     string
+	BaseURL    string // default: DefaultBaseURL
+	HTTPClient *http.Client
+	// StallTimeout aborts a stream that receives nothing for this long,
+	// failing it with a provider.StallError; 0 waits for the client's
+	// timeout.
+	StallTimeout time.Duration
+	// Headers and Query are added to every request.
+	Headers map[string]string
+	Query   map[string]string
+}
+
+func (p Provider) Name() string { return "cohere" }
+
+// SupportsVision reports whether model accepts images, which only the
+// Command A Vision models do.
+func (p Provider) SupportsVision(model string) bool {
+	return strings.Contains(strings.ToLower(model), "vision")
+}
+
+func (p Provider) Stream(ctx context.Context, req provider.CompletionRequest) (<-chan provider.StreamEvent, error) {
+	if strings.TrimSpace(p.APIKey) == "" {
+		return nil, fmt.Errorf("cohere provider: API key is required")
+	}
+	body, err := toChatRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("cohere provider: %w", err)
+	}
+	p.Headers = provider.MergeExtras(p.Headers, req.ExtraHeaders)
+	p.Query = provider.MergeExtras(p.Query, req.ExtraQuery)
+	ch := make(chan provider.StreamEvent, 8)
+	go func() {
+		defer close(ch)
+		if err := p.streamChat(ctx, body, buildToolNameMap(req.Tools), ch); err != nil {
+			ch <- provider.StreamEvent{Err: err}
+		}
+	}()
+	return ch, nil
+}
+
+func (p Provider) streamChat(ctx context.Context, body chatRequest, nameMap map[string]string, ch chan<- provider.StreamEvent) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	httpClient := p.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 120 * time.Second}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL()+"/v2/chat", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+p.APIKey)
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+	provider.ApplyExtras(httpReq, p.Headers, p.Query)
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		rawBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("cohere provider request failed: %w", provider.NewAPIError(resp, rawBody))
+	}
+	stream := provider.WatchStalls(resp.Body, p.StallTimeout, cancel)
+	defer stream.Close()
+	return readStream(stream, nameMap, ch)
+}
+
+// Validate checks the key and model by retrieving the model from the
+// Models API, which costs no tokens.
+func (p Provider) Validate(ctx context.Context, model string) error {
+	fail := func(kind provider.PreflightKind, err error) error {
+		return &provider.PreflightError{Provider: p.Name(), Model: model, Kind: kind, Err: err}
+	}
+	if strings.TrimSpace(p.APIKey) == "" {
+		return fail(provider.PreflightAuth, errors.New("API key is required"))
+	}
+	client := p.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL()+"/v1/models/"+url.PathEscape(model), nil)
+	if err != nil {
+		return fail(provider.PreflightNetwork, err)
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+p.APIKey)
+	provider.ApplyExtras(httpReq, p.Headers, p.Query)
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return fail(provider.PreflightNetwork, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fail(provider.PreflightStatus(resp.StatusCode), fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body))))
+	}
+	return nil
+}
+
+func (p Provider) baseURL() string {
+	if p.BaseURL == "" {
+		return DefaultBaseURL
+	}
+	return strings.TrimRight(p.BaseURL, "/")
+}
+
+// dataPrefix starts the data lines of a server-sent event stream.
+var dataPrefix = []byte("data:")
+
+// readStream emits text and the tool plan as they arrive, the plan as
+// reasoning, then once the message ends the plan and citations as raw
+// content, the tool calls, and the usage.
+func readStream(r io.Reader, nameMap map[string]string, ch chan<- provider.StreamEvent) error {
+	type toolCallAccum struct {
+		id        string
+		name      string
+		arguments strings.Builder
+	}
+	var calls []*toolCallAccum
+	var plan strings.Builder
+	var citations []json.RawMessage
+	var done *streamDelta
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		payload, ok := bytes.CutPrefix(scanner.Bytes(), dataPrefix)
+		if !ok {
+			continue
+		}
+		var event streamEvent
+		if err := json.Unmarshal(bytes.TrimSpace(payload), &event); err != nil {
+			continue
+		}
+		message := event.Delta.Message
+		switch event.Type {
+		case "content-delta":
+			var content struct {
+				Text     string `json:"text"`
+				Thinking string `json:"thinking"`
+			}
+			_ = json.Unmarshal(message.Content, &content)
+			if content.Thinking != "" {
+				ch <- provider.StreamEvent{Type: provider.StreamEventReasoning, Text: content.Thinking}
+			}
+			if content.Text != "" {
+				ch <- provider.StreamEvent{Type: provider.StreamEventText, Text: content.Text}
+			}
+		case "tool-plan-delta":
+			if message.ToolPlan != "" {
+				plan.WriteString(message.ToolPlan)
+				ch <- provider.StreamEvent{Type: provider.StreamEventReasoning, Text: message.ToolPlan}
+			}
+		case "tool-call-start", "tool-call-delta":
+			var call toolCall
+			_ = json.Unmarshal(message.ToolCalls, &call)
+			for len(calls) <= event.Index {
+				calls = append(calls, &toolCallAccum{})
+			}
+			accum := calls[event.Index]
+			if call.ID != "" {
+				accum.id = call.ID
+			}
+			if call.Function.Name != "" {
+				accum.name = call.Function.Name
+			}
+			accum.arguments.WriteString(call.Function.Arguments)
+			if call.Function.Arguments != "" {
+				ch <- provider.StreamEvent{Type: provider.StreamEventToolCallDelta, Text: call.Function.Arguments, ToolCall: tool.Call{ID: accum.id, ToolID: restoreToolID(accum.name, nameMap)}}
+			}
+		case "citation-start":
+			if len(message.Citations) > 0 {
+				citations = append(citations, message.Citations)
+			}
+		case "message-end":
+			delta := event.Delta
+			done = &delta
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading stream: %w", err)
+	}
+	if done == nil {
+		return errors.New("cohere provider: stream ended without message-end")
+	}
+	switch done.FinishReason {
+	case "ERROR", "TIMEOUT":
+		return fmt.Errorf("cohere provider: generation failed (finish_reason %s)", done.FinishReason)
+	}
+	if plan.Len() > 0 {
+		data, _ := json.Marshal(plan.String())
+		ch <- provider.StreamEvent{Type: provider.StreamEventRaw, Raw: provider.RawContent{Provider: "cohere", Type: rawToolPlan, Data: data}}
+	}
+	for _, citation := range citations {
+		ch <- provider.StreamEvent{Type: provider.StreamEventRaw, Raw: provider.RawContent{Provider: "cohere", Type: rawCitation, Data: citation}}
+	}
+	for _, accum := range calls {
+		args, err := parseArguments(accum.arguments.String())
+		if err != nil {
+			return fmt.Errorf("parse tool call %s arguments: %w", accum.name, err)
+		}
+		ch <- provider.StreamEvent{Type: provider.StreamEventToolCall, ToolCall: tool.Call{ID: accum.id, ToolID: restoreToolID(accum.name, nameMap), Arguments: args}}
+	}
+	final := provider.StreamEvent{Type: provider.StreamEventDone}
+	if u := done.Usage; u != nil {
+		// tokens counts what the model saw; billed_units leaves out the
+		// tokens Cohere adds itself.
+		final.InputTokens = int(cmp.Or(u.Tokens.InputTokens, u.BilledUnits.InputTokens))
+		final.OutputTokens = int(cmp.Or(u.Tokens.OutputTokens, u.BilledUnits.OutputTokens))
+	}
+	ch <- final
+	return nil
+}
+
+// thinkingBudgets are the token_budget of each thinking level; high
+// leaves the budget to the model.
+var thinkingBudgets = map[provider.ThinkingLevel]int{
+	provider.ThinkingMedium: 8192,
+	provider.ThinkingLow:    2048,
+}
+
+// toChatRequest maps a completion request to a streaming v2 chat request.
+// Text documents attached to user messages are sent as documents, which
+// the model cites; other documents are rejected.
+func toChatRequest(req provider.CompletionRequest) (chatRequest, error) {
+	body := chatRequest{Model: req.Model.Model, Stream: true}
+	if strings.TrimSpace(req.System) != "" {
+		body.Messages = append(body.Messages, chatMessage{Role: "system", Content: req.System})
+	}
+	messages, documents, err := toChatMessages(req.Messages)
+	if err != nil {
+		return chatRequest{}, err
+	}
+	body.Messages, body.Documents = append(body.Messages, messages...), documents
+	if len(req.Tools) > 0 {
+		defs := req.Tools
+		if id, ok := req.ToolChoice.Tool(); ok {
+			// Cohere cannot force one tool, so it is offered alone.
+			defs = nil
+			for _, def := range req.Tools {
+				if def.ID == id {
+					defs = append(defs, def)
+				}
+			}
+			body.ToolChoice = "REQUIRED"
+		}
+		for _, def := range defs {
+			body.Tools = append(body.Tools, chatTool{Type: "function", Function: chatFunction{
+				Name:        sanitizeToolName(def.ID),
+				Description: def.Description,
+				Parameters:  schemaOrObject(def.Schema),
+			}})
+		}
+		switch req.ToolChoice {
+		case provider.ToolChoiceRequired:
+			body.ToolChoice = "REQUIRED"
+		case provider.ToolChoiceNone:
+			body.ToolChoice = "NONE"
+		}
+	}
+	// Only reasoning models accept thinking; others reject the request.
+	if req.Thinking != "" && models.SupportsThinking(req.Model.Provider, req.Model.Model) {
+		body.Thinking = &chatThinking{Type: "enabled", TokenBudget: thinkingBudgets[req.Thinking]}
+	}
+	return body, nil
+}
+
+// toolImagesText introduces the user message that carries the images of
+// the tool results before it.
+const toolImagesText = "Images from the tool results above:"
+
+func toChatMessages(messages []provider.Message) ([]chatMessage, []chatDocument, error) {
+	var out []chatMessage
+	var documents []chatDocument
+	// Tool results carry text only, so their images follow them in a user
+	// message.
+	var toolImages []provider.Image
+	flushToolImages := func() {
+		if len(toolImages) == 0 {
+			return
+		}
+		parts := []contentPart{{Type: "text", Text: toolImagesText}}
+		for _, img := range toolImages {
+			parts = append(parts, imagePart(img))
+		}
+		out = append(out, chatMessage{Role: "user", Content: parts})
+		toolImages = nil
+	}
+	for _, msg := range messages {
+		if msg.Role == "tool" {
+			toolImages = append(toolImages, msg.Images...)
+			// A result sent as a document can be cited.
+			out = append(out, chatMessage{Role: "tool", ToolCallID: msg.ToolCallID, Content: []contentPart{{Type: "document", Document: &toolDocument{Data: msg.Content}}}})
+			continue
+		}
+		flushToolImages()
+		switch msg.Role {
+		case "assistant":
+			chatMsg := chatMessage{Role: "assistant"}
+			if msg.Content != "" {
+				chatMsg.Content = msg.Content
+			}
+			for _, raw := range msg.Raw {
+				if raw.Provider != "cohere" {
+					continue
+				}
+				switch raw.Type {
+				case rawToolPlan:
+					_ = json.Unmarshal(raw.Data, &chatMsg.ToolPlan)
+				case rawCitation:
+					chatMsg.Citations = append(chatMsg.Citations, raw.Data)
+				}
+			}
+			for _, call := range msg.ToolCalls {
+				chatMsg.ToolCalls = append(chatMsg.ToolCalls, toolCall{ID: call.ID, Type: "function", Function: toolCallFunction{
+					Name:      sanitizeToolName(call.ToolID),
+					Arguments: mustJSON(call.Arguments),
+				}})
+			}
+			// A tool plan only goes with the calls it planned.
+			if len(chatMsg.ToolCalls) == 0 {
+				chatMsg.ToolPlan = ""
+			}
+			out = append(out, chatMsg)
+		default:
+			for _, doc := range msg.Documents {
+				if !textDocument(doc.MediaType) || doc.FileID != "" {
+					return nil, nil, fmt.Errorf("document %q: only text documents are supported, not %s", doc.Name, doc.MediaType)
+				}
+				data := map[string]any{"text": string(doc.Data)}
+				if doc.Name != "" {
+					data["title"] = doc.Name
+				}
+				documents = append(documents, chatDocument{ID: fmt.Sprintf("doc_%d", len(documents)), Data: data})
+			}
+			chatMsg := chatMessage{Role: msg.Role, Content: msg.Content}
+			if len(msg.Images) > 0 {
+				parts := []contentPart{}
+				if msg.Content != "" {
+					parts = append(parts, contentPart{Type: "text", Text: msg.Content})
+				}
+				for _, img := range msg.Images {
+					parts = append(parts, imagePart(img))
+				}
+				chatMsg.Content = parts
+			}
+			out = append(out, chatMsg)
+		}
+	}
+	flushToolImages()
+	return out, documents, nil
+}
+
+// textDocument reports whether a document of mediaType can be sent as
+// text.
+func textDocument(mediaType string) bool {
+	switch mediaType {
+	case "application/json", "application/xml", "application/x-yaml":
+		return true
+	}
+	return strings.HasPrefix(mediaType, "text/")
+}
+
+func imagePart(img provider.Image) contentPart {
+	return contentPart{Type: "image_url", ImageURL: &imageURL{URL: "data:" + img.MediaType + ";base64," + base64.StdEncoding.EncodeToString(img.Data)}}
+}
+
+// sanitizeToolName replaces the characters Cohere rejects in function
+// names, such as the "/" of "core/read", with underscores.
+func sanitizeToolName(id string) string {
+	var b strings.Builder
+	for _, r := range id {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+// buildToolNameMap maps sanitized names back to the tool IDs they stand
+// for.
+func buildToolNameMap(defs []tool.Definition) map[string]string {
+	m := make(map[string]string, len(defs))
+	for _, def := range defs {
+		m[sanitizeToolName(def.ID)] = def.ID
+	}
+	return m
+}
+
+func restoreToolID(sanitized string, nameMap map[string]string) string {
+	if original, ok := nameMap[sanitized]; ok {
+		return original
+	}
+	return sanitized
+}
+
+func parseArguments(input string) (map[string]any, error) {
+	if strings.TrimSpace(input) == "" {
+		return map[string]any{}, nil
+	}
+	var args map[string]any
+	if err := json.Unmarshal([]byte(input), &args); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+func schemaOrObject(schema map[string]any) map[string]any {
+	if len(schema) == 0 {
+		return map[string]any{"type": "object", "properties": map[string]any{}}
+	}
+	return schema
+}
+
+func mustJSON(value any) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+type chatRequest struct {
+	Model      string         `json:"model"`
+	Messages   []chatMessage  `json:"messages"`
+	Tools      []chatTool     `json:"tools,omitempty"`
+	ToolChoice string         `json:"tool_choice,omitempty"` // REQUIRED or NONE
+	Documents  []chatDocument `json:"documents,omitempty"`
+	Thinking   *chatThinking  `json:"thinking,omitempty"`
+	Stream     bool           `json:"stream"`
+}
+
+type chatMessage struct {
+	Role       string            `json:"role"`
+	Content    any               `json:"content,omitempty"` // a string or []contentPart
+	ToolPlan   string            `json:"tool_plan,omitempty"`
+	ToolCalls  []toolCall        `json:"tool_calls,omitempty"`
+	ToolCallID string            `json:"tool_call_id,omitempty"`
+	Citations  []json.RawMessage `json:"citations,omitempty"`
+}
+
+type contentPart struct {
+	Type     string        `json:"type"`
+	Text     string        `json:"text,omitempty"`
+	ImageURL *imageURL     `json:"image_url,omitempty"`
+	Document *toolDocument `json:"document,omitempty"`
+}
+
+type imageURL struct {
+	URL string `json:"url"`
+}
+
+// toolDocument is a tool result; Data is its output.
+type toolDocument struct {
+	Data string `json:"data"`
+}
+
+// chatDocument is a document the model grounds its answer in and cites.
+type chatDocument struct {
+	ID   string         `json:"id"`
+	Data map[string]any `json:"data"`
+}
+
+type chatThinking struct {
+	Type        string `json:"type"`
+	TokenBudget int    `json:"token_budget,omitempty"`
+}
+
+type toolCall struct {
+	ID       string           `json:"id,omitempty"`
+	Type     string           `json:"type,omitempty"`
+	Function toolCallFunction `json:"function"`
+}
+
+type toolCallFunction struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+}
+
+type chatTool struct {
+	Type     string       `json:"type"`
+	Function chatFunction `json:"function"`
+}
+
+type chatFunction struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters"`
+}
+
+// streamEvent is one event of a v2 chat stream. The message fields are
+// objects in deltas but lists in message-start, so they are decoded by
+// event type.
+type streamEvent struct {
+	Type  string      `json:"type"`
+	Index int         `json:"index"`
+	Delta streamDelta `json:"delta"`
+}
+
+type streamDelta struct {
+	Message struct {
+		Content   json.RawMessage `json:"content"`
+		ToolPlan  string          `json:"tool_plan"`
+		ToolCalls json.RawMessage `json:"tool_calls"`
+		Citations json.RawMessage `json:"citations"`
+	} `json:"message"`
+	FinishReason string       `json:"finish_reason"`
+	Usage        *streamUsage `json:"usage"`
+}
+
+type streamUsage struct {
+	BilledUnits tokenCounts `json:"billed_units"`
+	Tokens      tokenCounts `json:"tokens"`
+}
+
+type tokenCounts struct {
+	InputTokens  float64 `json:"input_tokens"`
+	OutputTokens float64 `json:"output_tokens"`
+}
@@ -0,0 +1,138 @@
+package cohere
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+func TestStreamReadsToolPlansCitationsAndToolCalls(t *testing.T) {
+	var sent map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/chat" || r.Header.Get("Authorization") != "Bearer test-key" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&sent)
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, line := range []string{
+			`{"type":"message-start","id":"m1","delta":{"message":{"role":"assistant","content":[],"tool_plan":"","tool_calls":[],"citations":[]}}}`,
+			`{"type":"tool-plan-delta","delta":{"message":{"tool_plan":"I will read "}}}`,
+			`{"type":"tool-plan-delta","delta":{"message":{"tool_plan":"the file."}}}`,
+			`{"type":"tool-call-start","index":0,"delta":{"message":{"tool_calls":{"id":"core_read_1","type":"function","function":{"name":"core_read","arguments":""}}}}}`,
+			`{"type":"tool-call-delta","index":0,"delta":{"message":{"tool_calls":{"function":{"arguments":"{\"path\":"}}}}}`,
+			`{"type":"tool-call-delta","index":0,"delta":{"message":{"tool_calls":{"function":{"arguments":"\"go.mod\"}"}}}}}`,
+			`{"type":"tool-call-end","index":0}`,
+			`{"type":"content-start","index":0,"delta":{"message":{"content":{"type":"text","text":""}}}}`,
+			`{"type":"content-delta","index":0,"delta":{"message":{"content":{"text":"Go 1.26"}}}}`,
+			`{"type":"citation-start","index":0,"delta":{"message":{"citations":{"start":0,"end":7,"text":"Go 1.26","sources":[{"type":"document","id":"doc_0"}]}}}}`,
+			`{"type":"message-end","delta":{"finish_reason":"TOOL_CALL","usage":{"billed_units":{"input_tokens":90,"output_tokens":20},"tokens":{"input_tokens":120,"output_tokens":25}}}}`,
+		} {
+			fmt.Fprintf(w, "event: x\ndata: %s\n\n", line)
+		}
+	}))
+	defer server.Close()
+
+	p := Provider{APIKey: "test-key", BaseURL: server.URL, HTTPClient: server.Client()}
+	stream, err := p.Stream(context.Background(), provider.CompletionRequest{
+		Model:  provider.ModelRef{Model: "command-a-03-2025"},
+		System: "be brief",
+		Messages: []provider.Message{
+			{Role: "user", Content: "which Go?", Documents: []provider.Document{{Name: "notes.txt", MediaType: "text/plain", Data: []byte("uses Go 1.26")}}},
+			{Role: "assistant", ToolCalls: []tool.Call{{ID: "c0", ToolID: "core/glob", Arguments: map[string]any{"pattern": "*"}}}, Raw: []provider.RawContent{{Provider: "cohere", Type: rawToolPlan, Data: json.RawMessage(`"list files"`)}}},
+			{Role: "tool", ToolCallID: "c0", Content: "go.mod"},
+		},
+		Tools:      []tool.Definition{{ID: "core/read"}, {ID: "core/glob"}},
+		ToolChoice: provider.ToolChoiceRequired,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reasoning, text string
+	var raws []provider.RawContent
+	var calls []tool.Call
+	var done provider.StreamEvent
+	for event := range stream {
+		if event.Err != nil {
+			t.Fatalf("event error: %v", event.Err)
+		}
+		switch event.Type {
+		case provider.StreamEventReasoning:
+			reasoning += event.Text
+		case provider.StreamEventText:
+			text += event.Text
+		case provider.StreamEventRaw:
+			raws = append(raws, event.Raw)
+		case provider.StreamEventToolCall:
+			calls = append(calls, event.ToolCall)
+		case provider.StreamEventDone:
+			done = event
+		}
+	}
+	if reasoning != "I will read the file." || text != "Go 1.26" {
+		t.Fatalf("unexpected reasoning %q or text %q", reasoning, text)
+	}
+	if len(raws) != 2 || raws[0].Type != rawToolPlan || string(raws[0].Data) != `"I will read the file."` || raws[1].Type != rawCitation {
+		t.Fatalf("expected the tool plan and citation as raw content, got %+v", raws)
+	}
+	if len(calls) != 1 || calls[0].ToolID != "core/read" || calls[0].ID != "core_read_1" || calls[0].Arguments["path"] != "go.mod" {
+		t.Fatalf("unexpected tool calls %+v", calls)
+	}
+	if done.InputTokens != 120 || done.OutputTokens != 25 {
+		t.Fatalf("unexpected usage %+v", done)
+	}
+
+	if sent["tool_choice"] != "REQUIRED" || sent["stream"] != true {
+		t.Fatalf("unexpected request %v", sent)
+	}
+	docs, _ := sent["documents"].([]any)
+	if len(docs) != 1 || docs[0].(map[string]any)["data"].(map[string]any)["text"] != "uses Go 1.26" {
+		t.Fatalf("expected the text document sent as a document, got %v", sent["documents"])
+	}
+	messages, _ := sent["messages"].([]any)
+	if len(messages) != 4 || messages[0].(map[string]any)["role"] != "system" {
+		t.Fatalf("unexpected messages %v", messages)
+	}
+	assistant := messages[2].(map[string]any)
+	call := assistant["tool_calls"].([]any)[0].(map[string]any)
+	if assistant["tool_plan"] != "list files" || call["function"].(map[string]any)["name"] != "core_glob" {
+		t.Fatalf("expected the tool plan and sanitized name sent back, got %v", assistant)
+	}
+	result := messages[3].(map[string]any)
+	if result["tool_call_id"] != "c0" || result["content"].([]any)[0].(map[string]any)["type"] != "document" {
+		t.Fatalf("expected the tool result sent as a document, got %v", result)
+	}
+}
+
+func TestForcedToolIsOfferedAlone(t *testing.T) {
+	body, err := toChatRequest(provider.CompletionRequest{
+		Model:      provider.ModelRef{Provider: "cohere", Model: "command-a-reasoning-08-2025"},
+		Tools:      []tool.Definition{{ID: "core/read"}, {ID: "core/glob"}},
+		ToolChoice: provider.ToolChoice("core/glob"),
+		Thinking:   provider.ThinkingLow,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Tools) != 1 || body.Tools[0].Function.Name != "core_glob" || body.ToolChoice != "REQUIRED" {
+		t.Fatalf("unexpected tools %+v, choice %q", body.Tools, body.ToolChoice)
+	}
+	if body.Thinking == nil || body.Thinking.TokenBudget != 2048 {
+		t.Fatalf("unexpected thinking %+v", body.Thinking)
+	}
+	// Models without reasoning are not sent a thinking budget.
+	body, err = toChatRequest(provider.CompletionRequest{Model: provider.ModelRef{Provider: "cohere", Model: "command-a-03-2025"}, Thinking: provider.ThinkingLow})
+	if err != nil || body.Thinking != nil {
+		t.Fatalf("expected no thinking for command-a, got %+v (%v)", body.Thinking, err)
+	}
+	_, err = toChatRequest(provider.CompletionRequest{Messages: []provider.Message{{Role: "user", Documents: []provider.Document{{Name: "a.pdf", MediaType: "application/pdf"}}}}})
+	if err == nil {
+		t.Fatal("expected an error for a PDF document")
+	}
+}
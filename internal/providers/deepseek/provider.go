@@ -0,0 +1,90 @@
+// Package deepseek is the DeepSeek provider. DeepSeek serves OpenAI
+// compatible chat completions; this provider points them at the DeepSeek
+// API, streams deepseek-reasoner's reasoning_content as reasoning events,
+// and leaves tools out of requests to the reasoner, which does not support
+// function calling.
+package deepseek
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bitop-dev/agent/internal/providers/openai"
+	"github.com/bitop-dev/agent/pkg/provider"
+)
+
+// DefaultBaseURL is the DeepSeek API endpoint.
+const DefaultBaseURL = "https://api.deepseek.com"
+
+type Provider struct {
+	APIKey     string
+	BaseURL    string // default: DefaultBaseURL
+	HTTPClient *http.Client
+	// StallTimeout aborts a stream that receives nothing for this long;
+	// see openai.Provider.
+	StallTimeout time.Duration
+	// Headers and Query are added to every request.
+	Headers map[string]string
+	Query   map[string]string
+}
+
+func (p Provider) Name() string { return "deepseek" }
+
+// ValidToolCallID reports whether id is a call ID the OpenAI compatible
+// API accepts.
+func (p Provider) ValidToolCallID(id string) bool {
+	return openai.Provider{}.ValidToolCallID(id)
+}
+
+func (p Provider) Stream(ctx context.Context, req provider.CompletionRequest) (<-chan provider.StreamEvent, error) {
+	if strings.TrimSpace(p.APIKey) == "" {
+		return nil, fmt.Errorf("deepseek provider: API key is required")
+	}
+	baseURL := p.BaseURL
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	if !SupportsTools(req.Model.Model) {
+		req.Tools = nil
+	}
+	inner := openai.Provider{BaseURL: baseURL, APIKey: p.APIKey, APIMode: "chat", HTTPClient: p.HTTPClient, StallTimeout: p.StallTimeout, Headers: p.Headers, Query: p.Query}
+	events, err := inner.Stream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan provider.StreamEvent, 8)
+	go func() {
+		defer close(out)
+		for event := range events {
+			if event.Err != nil {
+				event.Err = fmt.Errorf("deepseek: %w", event.Err)
+			}
+			out <- event
+		}
+	}()
+	return out, nil
+}
+
+// Validate checks the key and model against the DeepSeek model list.
+func (p Provider) Validate(ctx context.Context, model string) error {
+	baseURL := p.BaseURL
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	err := openai.Provider{BaseURL: baseURL, APIKey: p.APIKey, HTTPClient: p.HTTPClient, Headers: p.Headers, Query: p.Query}.Validate(ctx, model)
+	var preflight *provider.PreflightError
+	if errors.As(err, &preflight) {
+		preflight.Provider = p.Name()
+	}
+	return err
+}
+
+// SupportsTools reports whether model accepts tool definitions; the R1
+// reasoner does not.
+func SupportsTools(model string) bool {
+	return !strings.Contains(strings.ToLower(model), "reasoner")
+}
@@ -0,0 +1,85 @@
+package deepseek
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+func TestReasonerStreamsReasoningWithoutTools(t *testing.T) {
+	var sent map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat/completions" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		_ = json.NewDecoder(r.Body).Decode(&sent)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintln(w, `data: {"choices":[{"delta":{"reasoning_content":"Two plus "}}]}`)
+		fmt.Fprintln(w, `data: {"choices":[{"delta":{"reasoning_content":"two is four."}}]}`)
+		fmt.Fprintln(w, `data: {"choices":[{"delta":{"content":"4"}}]}`)
+		fmt.Fprintln(w, `data: {"choices":[],"usage":{"prompt_tokens":100,"completion_tokens":20,"total_tokens":120,"prompt_cache_hit_tokens":64,"prompt_cache_miss_tokens":36,"completion_tokens_details":{"reasoning_tokens":18}}}`)
+		fmt.Fprintln(w, `data: [DONE]`)
+	}))
+	defer server.Close()
+
+	p := Provider{APIKey: "test-key", BaseURL: server.URL, HTTPClient: server.Client()}
+	stream, err := p.Stream(context.Background(), provider.CompletionRequest{
+		Model:    provider.ModelRef{Model: "deepseek-reasoner"},
+		Messages: []provider.Message{{Role: "user", Content: "2+2?"}},
+		Tools:    []tool.Definition{{ID: "core/read", Description: "read"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reasoning, text strings.Builder
+	var done provider.StreamEvent
+	for event := range stream {
+		switch {
+		case event.Err != nil:
+			t.Fatalf("event error: %v", event.Err)
+		case event.Type == provider.StreamEventReasoning:
+			reasoning.WriteString(event.Text)
+		case event.Type == provider.StreamEventText:
+			text.WriteString(event.Text)
+		case event.Type == provider.StreamEventDone:
+			done = event
+		}
+	}
+	if reasoning.String() != "Two plus two is four." || text.String() != "4" {
+		t.Fatalf("expected reasoning and answer apart, got %q and %q", reasoning.String(), text.String())
+	}
+	if done.CacheReadTokens != 64 || done.ReasoningTokens != 18 || done.InputTokens != 100 {
+		t.Fatalf("unexpected usage: %+v", done)
+	}
+	if _, ok := sent["tools"]; ok {
+		t.Fatalf("expected no tools sent to the reasoner, got %v", sent["tools"])
+	}
+}
+
+func TestErrorsNameTheProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"Insufficient Balance"}}`, http.StatusPaymentRequired)
+	}))
+	defer server.Close()
+	p := Provider{APIKey: "test-key", BaseURL: server.URL, HTTPClient: server.Client()}
+	stream, err := p.Stream(context.Background(), provider.CompletionRequest{Model: provider.ModelRef{Model: "deepseek-chat"}, Messages: []provider.Message{{Role: "user", Content: "hi"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for event := range stream {
+		if event.Err != nil {
+			if !strings.HasPrefix(event.Err.Error(), "deepseek: ") || !strings.Contains(event.Err.Error(), "Insufficient Balance") {
+				t.Fatalf("unexpected error: %v", event.Err)
+			}
+			return
+		}
+	}
+	t.Fatal("expected an error")
+}
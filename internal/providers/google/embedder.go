@@ -0,0 +1,89 @@
+// Package google implements the Gemini API embeddings endpoint.
+package google
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+)
+
+type Embedder struct {
+	APIKey     string
+	BaseURL    string // default: https://generativelanguage.googleapis.com/v1beta
+	HTTPClient *http.Client
+	// Headers and Query are added to every request.
+	Headers map[string]string
+	Query   map[string]string
+}
+
+func (e Embedder) Embed(ctx context.Context, req provider.EmbeddingRequest) ([][]float32, error) {
+	if strings.TrimSpace(e.APIKey) == "" {
+		return nil, fmt.Errorf("google embedder: API key is required")
+	}
+	baseURL := e.BaseURL
+	if baseURL == "" {
+		baseURL = "https://generativelanguage.googleapis.com/v1beta"
+	}
+	model := req.Model
+	if model == "" {
+		model = "text-embedding-004"
+	}
+	model = strings.TrimPrefix(model, "models/")
+	requests := make([]map[string]any, 0, len(req.Inputs))
+	for _, input := range req.Inputs {
+		requests = append(requests, map[string]any{
+			"model":   "models/" + model,
+			"content": map[string]any{"parts": []map[string]any{{"text": input}}},
+		})
+	}
+	data, err := json.Marshal(map[string]any{"requests": requests})
+	if err != nil {
+		return nil, err
+	}
+	endpoint := fmt.Sprintf("%s/models/%s:batchEmbedContents?key=%s", strings.TrimRight(baseURL, "/"), url.PathEscape(model), url.QueryEscape(e.APIKey))
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	provider.ApplyExtras(httpReq, e.Headers, e.Query)
+	client := e.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 60 * time.Second}
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("google embedder: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("google embedder request failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var parsed struct {
+		Embeddings []struct {
+			Values []float32 `json:"values"`
+		} `json:"embeddings"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, fmt.Errorf("google embedder: decode response: %w", err)
+	}
+	out := make([][]float32, len(req.Inputs))
+	for i := range parsed.Embeddings {
+		if i < len(out) {
+			out[i] = parsed.Embeddings[i].Values
+		}
+	}
+	return out, nil
+}
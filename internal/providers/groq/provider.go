@@ -0,0 +1,82 @@
+// Package groq is the Groq provider. Groq serves OpenAI compatible chat
+// completions; this provider points them at the Groq API, reads the usage
+// Groq reports in x_groq with its queue, prompt, and completion times, and
+// sends the configured service tier.
+package groq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bitop-dev/agent/internal/providers/openai"
+	"github.com/bitop-dev/agent/pkg/provider"
+)
+
+// DefaultBaseURL is the Groq API endpoint.
+const DefaultBaseURL = "https://api.groq.com/openai/v1"
+
+type Provider struct {
+	APIKey  string
+	BaseURL string // default: DefaultBaseURL
+	// ServiceTier is "on_demand", "flex", or "auto"; empty leaves the
+	// account's default.
+	ServiceTier string
+	HTTPClient  *http.Client
+	// StallTimeout aborts a stream that receives nothing for this long;
+	// see openai.Provider.
+	StallTimeout time.Duration
+	// Headers and Query are added to every request.
+	Headers map[string]string
+	Query   map[string]string
+}
+
+func (p Provider) Name() string { return "groq" }
+
+// ValidToolCallID reports whether id is a call ID the OpenAI compatible
+// API accepts.
+func (p Provider) ValidToolCallID(id string) bool {
+	return openai.Provider{}.ValidToolCallID(id)
+}
+
+func (p Provider) Stream(ctx context.Context, req provider.CompletionRequest) (<-chan provider.StreamEvent, error) {
+	if strings.TrimSpace(p.APIKey) == "" {
+		return nil, fmt.Errorf("groq provider: API key is required")
+	}
+	events, err := p.inner().Stream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan provider.StreamEvent, 8)
+	go func() {
+		defer close(out)
+		for event := range events {
+			if event.Err != nil {
+				event.Err = fmt.Errorf("groq: %w", event.Err)
+			}
+			out <- event
+		}
+	}()
+	return out, nil
+}
+
+// Validate checks the key and model against the Groq model list.
+func (p Provider) Validate(ctx context.Context, model string) error {
+	err := p.inner().Validate(ctx, model)
+	var preflight *provider.PreflightError
+	if errors.As(err, &preflight) {
+		preflight.Provider = p.Name()
+	}
+	return err
+}
+
+func (p Provider) inner() openai.Provider {
+	baseURL := p.BaseURL
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	return openai.Provider{BaseURL: baseURL, APIKey: p.APIKey, APIMode: "chat", ServiceTier: p.ServiceTier, HTTPClient: p.HTTPClient, StallTimeout: p.StallTimeout, Headers: p.Headers, Query: p.Query}
+}
@@ -0,0 +1,105 @@
+package groq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+)
+
+func TestUsageAndTimingsComeFromXGroq(t *testing.T) {
+	var sent map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat/completions" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		_ = json.NewDecoder(r.Body).Decode(&sent)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintln(w, `data: {"choices":[{"delta":{"content":"fast"}}]}`)
+		fmt.Fprintln(w, `data: {"choices":[{"delta":{},"finish_reason":"stop"}],"x_groq":{"id":"req_1","usage":{"queue_time":0.25,"prompt_tokens":400,"prompt_time":0.002,"completion_tokens":50,"completion_time":0.1,"total_tokens":450}}}`)
+		fmt.Fprintln(w, `data: [DONE]`)
+	}))
+	defer server.Close()
+
+	p := Provider{APIKey: "test-key", BaseURL: server.URL, ServiceTier: "flex", HTTPClient: server.Client()}
+	stream, err := p.Stream(context.Background(), provider.CompletionRequest{
+		Model:    provider.ModelRef{Model: "llama-3.1-8b-instant"},
+		Messages: []provider.Message{{Role: "user", Content: "hi"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dones []provider.StreamEvent
+	for event := range stream {
+		if event.Err != nil {
+			t.Fatalf("event error: %v", event.Err)
+		}
+		if event.Type == provider.StreamEventDone {
+			dones = append(dones, event)
+		}
+	}
+	if len(dones) != 1 || dones[0].InputTokens != 400 || dones[0].OutputTokens != 50 {
+		t.Fatalf("expected one done event with the x_groq usage, got %+v", dones)
+	}
+	timing := dones[0].Timing
+	if timing == nil || timing.Queue != 250*time.Millisecond || timing.PromptThroughput(400) != 200000 || timing.OutputThroughput(50) != 500 {
+		t.Fatalf("unexpected timing %+v", timing)
+	}
+	if sent["service_tier"] != "flex" {
+		t.Fatalf("expected the service tier sent, got %v", sent["service_tier"])
+	}
+}
+
+func TestStandardUsageIsNotCountedTwice(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintln(w, `data: {"choices":[{"delta":{"content":"ok"},"finish_reason":"stop"}],"x_groq":{"usage":{"queue_time":0.01,"prompt_tokens":10,"completion_tokens":2,"total_tokens":12}}}`)
+		fmt.Fprintln(w, `data: {"choices":[],"usage":{"prompt_tokens":10,"completion_tokens":2,"total_tokens":12}}`)
+		fmt.Fprintln(w, `data: [DONE]`)
+	}))
+	defer server.Close()
+	p := Provider{APIKey: "test-key", BaseURL: server.URL, HTTPClient: server.Client()}
+	stream, err := p.Stream(context.Background(), provider.CompletionRequest{Model: provider.ModelRef{Model: "llama-3.1-8b-instant"}, Messages: []provider.Message{{Role: "user", Content: "hi"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := 0
+	for event := range stream {
+		if event.Type == provider.StreamEventDone {
+			input += event.InputTokens
+			if event.Timing == nil || event.Timing.Queue != 10*time.Millisecond {
+				t.Fatalf("expected the x_groq timing on the final usage, got %+v", event.Timing)
+			}
+		}
+	}
+	if input != 10 {
+		t.Fatalf("expected the input counted once, got %d", input)
+	}
+}
+
+func TestErrorsNameTheProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"Rate limit reached"}}`, http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	p := Provider{APIKey: "test-key", BaseURL: server.URL, HTTPClient: server.Client()}
+	stream, err := p.Stream(context.Background(), provider.CompletionRequest{Model: provider.ModelRef{Model: "llama-3.1-8b-instant"}, Messages: []provider.Message{{Role: "user", Content: "hi"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for event := range stream {
+		if event.Err != nil {
+			if !strings.HasPrefix(event.Err.Error(), "groq: ") {
+				t.Fatalf("unexpected error: %v", event.Err)
+			}
+			return
+		}
+	}
+	t.Fatal("expected an error")
+}
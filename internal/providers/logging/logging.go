@@ -0,0 +1,340 @@
+// Package logging writes the full traffic of provider requests to a file,
+// for debugging provider-specific streaming bugs without patching provider
+// code. Unlike a replay recording, the log is meant to be read and shared:
+// API keys, long file contents, and secrets matching configured patterns
+// are redacted before anything is written.
+//
+// A log is JSON Lines: one Entry per completion request, written when its
+// stream ends.
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// Redacted replaces every secret in a log.
+const Redacted = "[REDACTED]"
+
+// DefaultPatterns match common credential formats: OpenAI and Anthropic
+// style keys, bearer tokens, AWS access keys, and key=value or "key": value
+// pairs whose name suggests a secret. Their first capture group, if any, is
+// kept, so the name of a key=value pair stays readable.
+var DefaultPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`sk-[A-Za-z0-9_\-]{16,}`),
+	regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/\-]{16,}=*`),
+	regexp.MustCompile(`AKIA[0-9A-Z]{16}`),
+	regexp.MustCompile(`(?i)((?:api[_-]?key|access[_-]?token|auth[_-]?token|secret|password)["']?\s*[:=]\s*["']?)[^\s"',}]+`),
+}
+
+// Entry is one logged completion request and the stream it produced.
+type Entry struct {
+	Seq        int       `json:"seq"`
+	At         time.Time `json:"at"`
+	Provider   string    `json:"provider"`
+	Model      string    `json:"model"`
+	DurationMS int64     `json:"durationMs"`
+	Request    Request   `json:"request"`
+	Events     []Event   `json:"events"`
+	Error      string    `json:"error,omitempty"` // Stream itself failed
+}
+
+// Request is the logged form of a provider.CompletionRequest.
+type Request struct {
+	System   string             `json:"system,omitempty"`
+	Messages []provider.Message `json:"messages"`
+	Tools    []tool.Definition  `json:"tools,omitempty"`
+}
+
+// Event is the logged form of a provider.StreamEvent.
+type Event struct {
+	Type             provider.StreamEventType `json:"type"`
+	Text             string                   `json:"text,omitempty"`
+	ToolCall         *tool.Call               `json:"toolCall,omitempty"`
+	Error            string                   `json:"error,omitempty"`
+	InputTokens      int                      `json:"inputTokens,omitempty"`
+	OutputTokens     int                      `json:"outputTokens,omitempty"`
+	ReasoningTokens  int                      `json:"reasoningTokens,omitempty"`
+	CacheReadTokens  int                      `json:"cacheReadTokens,omitempty"`
+	CacheWriteTokens int                      `json:"cacheWriteTokens,omitempty"`
+	Raw              *provider.RawContent     `json:"raw,omitempty"`
+}
+
+// Logger appends redacted entries to a log file. One Logger is shared by
+// every provider it wraps.
+type Logger struct {
+	Path string
+	// MaxBytes caps any single string in an entry (message content, tool
+	// output, tool arguments); longer ones are replaced by a note of their
+	// size. Zero keeps strings whole.
+	MaxBytes int
+	// Patterns are redacted wherever they match, after the literal Secrets.
+	// A pattern's first capture group, if any, is kept.
+	Patterns []*regexp.Regexp
+	// Secrets are literal values, typically the configured API keys, that
+	// are redacted wherever they appear.
+	Secrets []string
+	// Sample is the fraction of requests logged, from 0 to 1. Requests that
+	// fail are logged regardless, since they are usually what is being
+	// debugged.
+	Sample float64
+
+	mu  sync.Mutex
+	seq int
+}
+
+// NewLogger logs every request to path, appending to an existing log, with
+// the default redaction patterns.
+func NewLogger(path string) (*Logger, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+	return &Logger{Path: path, Patterns: append([]*regexp.Regexp(nil), DefaultPatterns...), Sample: 1}, nil
+}
+
+// Wrap returns a provider that forwards to inner and logs its traffic.
+func (l *Logger) Wrap(inner provider.Provider) *LoggingProvider {
+	return &LoggingProvider{Inner: inner, Logger: l}
+}
+
+func (l *Logger) sampled() bool {
+	return l.Sample >= 1 || (l.Sample > 0 && rand.Float64() < l.Sample)
+}
+
+func (l *Logger) append(entry Entry) error {
+	l.redactEntry(&entry)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	entry.Seq = l.seq
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(l.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Redact returns s with secrets replaced and, past MaxBytes, its content
+// replaced by a note of its size.
+func (l *Logger) Redact(s string) string {
+	if l.MaxBytes > 0 && len(s) > l.MaxBytes {
+		return fmt.Sprintf("[%d bytes omitted]", len(s))
+	}
+	for _, secret := range l.Secrets {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, Redacted)
+		}
+	}
+	for _, pattern := range l.Patterns {
+		s = pattern.ReplaceAllString(s, "${1}"+Redacted)
+	}
+	return s
+}
+
+func (l *Logger) redactEntry(entry *Entry) {
+	req := &entry.Request
+	req.System = l.Redact(req.System)
+	messages := make([]provider.Message, len(req.Messages))
+	for i, msg := range req.Messages {
+		msg.Content = l.Redact(msg.Content)
+		if msg.ToolCalls != nil {
+			calls := make([]tool.Call, len(msg.ToolCalls))
+			for j, call := range msg.ToolCalls {
+				call.Arguments = l.redactArguments(call.Arguments)
+				calls[j] = call
+			}
+			msg.ToolCalls = calls
+		}
+		if msg.Raw != nil {
+			raw := make([]provider.RawContent, len(msg.Raw))
+			for j, block := range msg.Raw {
+				raw[j] = l.redactRaw(block)
+			}
+			msg.Raw = raw
+		}
+		messages[i] = msg
+	}
+	req.Messages = messages
+	for i := range entry.Events {
+		event := &entry.Events[i]
+		event.Text = l.Redact(event.Text)
+		event.Error = l.Redact(event.Error)
+		if event.ToolCall != nil {
+			call := *event.ToolCall
+			call.Arguments = l.redactArguments(call.Arguments)
+			event.ToolCall = &call
+		}
+		if event.Raw != nil {
+			raw := l.redactRaw(*event.Raw)
+			event.Raw = &raw
+		}
+	}
+	entry.Error = l.Redact(entry.Error)
+}
+
+func (l *Logger) redactArguments(args map[string]any) map[string]any {
+	if args == nil {
+		return nil
+	}
+	out := make(map[string]any, len(args))
+	for key, value := range args {
+		out[key] = l.redactValue(value)
+	}
+	return out
+}
+
+func (l *Logger) redactValue(value any) any {
+	switch v := value.(type) {
+	case string:
+		return l.Redact(v)
+	case map[string]any:
+		return l.redactArguments(v)
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = l.redactValue(item)
+		}
+		return out
+	default:
+		return value
+	}
+}
+
+// redactRaw redacts a raw content block through its decoded form, so the
+// result is still valid JSON.
+func (l *Logger) redactRaw(block provider.RawContent) provider.RawContent {
+	var data any
+	if err := json.Unmarshal(block.Data, &data); err != nil {
+		block.Data, _ = json.Marshal(l.Redact(string(block.Data)))
+		return block
+	}
+	block.Data, _ = json.Marshal(l.redactValue(data))
+	return block
+}
+
+// LoggingProvider forwards requests to Inner and logs each request and the
+// events it streamed.
+type LoggingProvider struct {
+	Inner  provider.Provider
+	Logger *Logger
+}
+
+func (p *LoggingProvider) Name() string { return p.Inner.Name() }
+
+// SupportsPrefill defers to the wrapped provider.
+func (p *LoggingProvider) SupportsPrefill(model string) bool {
+	prefiller, ok := p.Inner.(provider.Prefiller)
+	return ok && prefiller.SupportsPrefill(model)
+}
+
+// SupportsVision defers to the wrapped provider.
+func (p *LoggingProvider) SupportsVision(model string) bool {
+	vision, ok := p.Inner.(provider.VisionSupporter)
+	return ok && vision.SupportsVision(model)
+}
+
+// ValidToolCallID defers to the wrapped provider, which accepts any
+// non-empty ID when it does not check them.
+func (p *LoggingProvider) ValidToolCallID(id string) bool {
+	if checker, ok := p.Inner.(provider.ToolCallIDChecker); ok {
+		return checker.ValidToolCallID(id)
+	}
+	return id != ""
+}
+
+// Validate defers to the wrapped provider.
+func (p *LoggingProvider) Validate(ctx context.Context, model string) error {
+	return provider.Preflight(ctx, p.Inner, model)
+}
+
+func (p *LoggingProvider) Stream(ctx context.Context, req provider.CompletionRequest) (<-chan provider.StreamEvent, error) {
+	sampled := p.Logger.sampled()
+	start := time.Now()
+	entry := Entry{
+		At:       start,
+		Provider: p.Inner.Name(),
+		Model:    req.Model.Model,
+		Request:  Request{System: req.System, Messages: req.Messages, Tools: req.Tools},
+	}
+	inner, err := p.Inner.Stream(ctx, req)
+	if err != nil {
+		entry.Error = err.Error()
+		entry.DurationMS = time.Since(start).Milliseconds()
+		_ = p.Logger.append(entry)
+		return nil, err
+	}
+	out := make(chan provider.StreamEvent)
+	go func() {
+		defer close(out)
+		failed := false
+		finish := func() {
+			if !sampled && !failed {
+				return
+			}
+			entry.DurationMS = time.Since(start).Milliseconds()
+			// A log that cannot be written must not fail the run.
+			_ = p.Logger.append(entry)
+		}
+		for event := range inner {
+			// Events are kept even when not sampled, in case the stream
+			// fails later.
+			failed = failed || event.Err != nil
+			entry.Events = append(entry.Events, encodeEvent(event))
+			select {
+			case out <- event:
+			case <-ctx.Done():
+				// Drain so the inner provider can finish; a cancelled
+				// stream is logged like a failed one.
+				for range inner {
+				}
+				failed = true
+				finish()
+				return
+			}
+		}
+		finish()
+	}()
+	return out, nil
+}
+
+func encodeEvent(event provider.StreamEvent) Event {
+	out := Event{Type: event.Type, Text: event.Text, InputTokens: event.InputTokens, OutputTokens: event.OutputTokens, ReasoningTokens: event.ReasoningTokens, CacheReadTokens: event.CacheReadTokens, CacheWriteTokens: event.CacheWriteTokens}
+	if event.Type == provider.StreamEventToolCall || event.Type == provider.StreamEventToolCallDelta {
+		call := event.ToolCall
+		out.ToolCall = &call
+	}
+	if event.Type == provider.StreamEventRaw {
+		raw := event.Raw
+		out.Raw = &raw
+	}
+	if event.Err != nil {
+		out.Error = event.Err.Error()
+	}
+	return out
+}
@@ -0,0 +1,122 @@
+package logging
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/bitop-dev/agent/internal/providers/mock"
+	"github.com/bitop-dev/agent/pkg/provider"
+)
+
+func drain(t *testing.T, p provider.Provider, req provider.CompletionRequest) {
+	t.Helper()
+	ch, err := p.Stream(context.Background(), req)
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	for range ch {
+	}
+}
+
+func readEntries(t *testing.T, path string) []Entry {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("decode %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestLogRedactsSecretsAndLongContent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "providers.jsonl")
+	logger, err := NewLogger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.MaxBytes = 64
+	logger.Secrets = []string{"configured-key-123"}
+	logger.Patterns = append(logger.Patterns, regexp.MustCompile(`internal-[0-9]+`))
+	drain(t, logger.Wrap(mock.Provider{}), provider.CompletionRequest{
+		Model:  provider.ModelRef{Provider: "mock", Model: "m"},
+		System: "key configured-key-123, host internal-42",
+		Messages: []provider.Message{
+			{Role: "tool", Content: strings.Repeat("x", 100)},
+			{Role: "user", Content: "use api_key=hunter2hunter2 and sk-abcdefghijklmnopqrstuv"},
+		},
+	})
+
+	entries := readEntries(t, path)
+	if len(entries) != 1 {
+		t.Fatalf("expected one entry, got %d", len(entries))
+	}
+	entry := entries[0]
+	if entry.Provider != "mock" || entry.Model != "m" || len(entry.Events) == 0 {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+	if want := "key [REDACTED], host [REDACTED]"; entry.Request.System != want {
+		t.Fatalf("system = %q, want %q", entry.Request.System, want)
+	}
+	if want := "[100 bytes omitted]"; entry.Request.Messages[0].Content != want {
+		t.Fatalf("long content = %q, want %q", entry.Request.Messages[0].Content, want)
+	}
+	if want := "use api_key=[REDACTED] and [REDACTED]"; entry.Request.Messages[1].Content != want {
+		t.Fatalf("user content = %q, want %q", entry.Request.Messages[1].Content, want)
+	}
+}
+
+func TestSampleSkipsRequestsUnlessTheyFail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "providers.jsonl")
+	logger, err := NewLogger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Sample = 0
+	drain(t, logger.Wrap(mock.Provider{}), provider.CompletionRequest{
+		Model:    provider.ModelRef{Provider: "mock", Model: "m"},
+		Messages: []provider.Message{{Role: "user", Content: "hello"}},
+	})
+	if entries := readEntries(t, path); len(entries) != 0 {
+		t.Fatalf("expected an unsampled request to be skipped, got %+v", entries)
+	}
+
+	drain(t, logger.Wrap(failingProvider{}), provider.CompletionRequest{
+		Model:    provider.ModelRef{Provider: "broken", Model: "m"},
+		Messages: []provider.Message{{Role: "user", Content: "hello"}},
+	})
+	entries := readEntries(t, path)
+	if len(entries) != 1 || entries[0].Provider != "broken" {
+		t.Fatalf("expected the failed request to be logged, got %+v", entries)
+	}
+	if last := entries[0].Events[len(entries[0].Events)-1]; last.Error != "connection reset" {
+		t.Fatalf("expected the stream error in the log, got %+v", last)
+	}
+}
+
+type failingProvider struct{}
+
+func (failingProvider) Name() string { return "broken" }
+
+func (failingProvider) Stream(context.Context, provider.CompletionRequest) (<-chan provider.StreamEvent, error) {
+	ch := make(chan provider.StreamEvent, 2)
+	ch <- provider.StreamEvent{Type: provider.StreamEventText, Text: "partial"}
+	ch <- provider.StreamEvent{Err: errors.New("connection reset")}
+	close(ch)
+	return ch, nil
+}
@@ -0,0 +1,51 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+)
+
+type embeddingsRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type embeddingsResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+// Embed calls the /embeddings endpoint. Results are returned in input order.
+func (p Provider) Embed(ctx context.Context, req provider.EmbeddingRequest) ([][]float32, error) {
+	if strings.TrimSpace(p.BaseURL) == "" {
+		return nil, fmt.Errorf("openai provider base URL is required")
+	}
+	if strings.TrimSpace(p.APIKey) == "" {
+		return nil, fmt.Errorf("openai provider API key is required")
+	}
+	model := req.Model
+	if model == "" {
+		model = "text-embedding-3-small"
+	}
+	raw, err := p.postJSON(ctx, "/embeddings", embeddingsRequest{Model: model, Input: req.Inputs})
+	if err != nil {
+		return nil, err
+	}
+	var resp embeddingsResponse
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return nil, fmt.Errorf("decode openai embeddings response: %w", err)
+	}
+	out := make([][]float32, len(req.Inputs))
+	for _, item := range resp.Data {
+		if item.Index >= 0 && item.Index < len(out) {
+			out[item.Index] = item.Embedding
+		}
+	}
+	return out, nil
+}
@@ -3,14 +3,18 @@ package openai
 import (
 	"bufio"
 	"bytes"
+	"cmp"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/bitop-dev/agent/internal/models"
 	"github.com/bitop-dev/agent/pkg/provider"
 	"github.com/bitop-dev/agent/pkg/tool"
 )
@@ -25,12 +29,55 @@ type Provider struct {
 	APIKey     string
 	APIMode    string
 	HTTPClient *http.Client
+	// PromptCacheKey is sent as prompt_cache_key in responses mode; empty
+	// derives one from the instructions and tools.
+	PromptCacheKey string
+	// ServerState continues conversations with previous_response_id in
+	// responses mode, sending only the items added since the last response
+	// instead of the full history.
+	ServerState bool
+	// ServiceTier is sent as service_tier, the processing tier of the
+	// request, such as "flex" or "priority"; empty leaves the account's
+	// default.
+	ServiceTier string
+	// StallTimeout aborts a chat stream that receives nothing for this
+	// long, failing it with a provider.StallError; 0 waits for the
+	// client's timeout. Responses mode is not streamed and is not watched.
+	StallTimeout time.Duration
+	// Organization and Project are sent as OpenAI-Organization and
+	// OpenAI-Project, choosing the account requests are billed to.
+	Organization string
+	Project      string
+	// Headers and Query are added to every request, as gateways such as
+	// OpenRouter (HTTP-Referer, X-Title) or an enterprise proxy expect.
+	Headers map[string]string
+	Query   map[string]string
 }
 
 func (p Provider) Name() string {
 	return "openai"
 }
 
+// SupportsVision reports whether model accepts images: GPT-4o and later
+// chat models and o1, o3, and o4-mini do; GPT-3.5, the original GPT-4, and
+// o1-mini and o3-mini do not.
+func (p Provider) SupportsVision(model string) bool {
+	model = strings.ToLower(model)
+	for _, prefix := range []string{"gpt-3.5", "gpt-4-0", "gpt-4-32k", "o1-mini", "o3-mini"} {
+		if strings.HasPrefix(model, prefix) {
+			return false
+		}
+	}
+	return model != "gpt-4"
+}
+
+// ValidToolCallID reports whether id is a call ID the API accepts: at most
+// 64 characters, without the "|" of the compound call and item IDs some
+// clients store for the Responses API.
+func (p Provider) ValidToolCallID(id string) bool {
+	return id != "" && len(id) <= 64 && !strings.Contains(id, "|")
+}
+
 func (p Provider) Stream(ctx context.Context, req provider.CompletionRequest) (<-chan provider.StreamEvent, error) {
 	if strings.TrimSpace(p.BaseURL) == "" {
 		return nil, fmt.Errorf("openai provider base URL is required")
@@ -38,6 +85,8 @@ func (p Provider) Stream(ctx context.Context, req provider.CompletionRequest) (<
 	if strings.TrimSpace(p.APIKey) == "" {
 		return nil, fmt.Errorf("openai provider API key is required")
 	}
+	p.Headers = provider.MergeExtras(p.Headers, req.ExtraHeaders)
+	p.Query = provider.MergeExtras(p.Query, req.ExtraQuery)
 	mode := normalizeMode(p.APIMode)
 	ch := make(chan provider.StreamEvent, 8)
 	go func() {
@@ -59,10 +108,13 @@ func (p Provider) Stream(ctx context.Context, req provider.CompletionRequest) (<
 
 func (p Provider) runChat(ctx context.Context, req provider.CompletionRequest, ch chan<- provider.StreamEvent) error {
 	nameMap := buildToolNameMap(req.Tools)
+	// Hosted tools are built-in tools of the Responses API; Chat
+	// Completions has no equivalent for most, so they are only sent in
+	// responses mode.
 	tools := toChatTools(req.Tools)
-	toolChoice := ""
+	var toolChoice any
 	if len(tools) > 0 {
-		toolChoice = "auto"
+		toolChoice = toChatToolChoice(req.ToolChoice)
 	}
 	// Request usage reporting in the response.
 	body := chatRequest{
@@ -72,6 +124,8 @@ func (p Provider) runChat(ctx context.Context, req provider.CompletionRequest, c
 		ToolChoice:    toolChoice,
 		Stream:        true,
 		StreamOptions: &streamOptions{IncludeUsage: true},
+		ServiceTier:   p.ServiceTier,
+		Effort:        thinkingLevel(req),
 	}
 	if strings.TrimSpace(req.System) != "" {
 		body.Messages = append([]chatMessage{{Role: "system", Content: req.System}}, body.Messages...)
@@ -79,6 +133,19 @@ func (p Provider) runChat(ctx context.Context, req provider.CompletionRequest, c
 	return p.streamChat(ctx, body, nameMap, ch)
 }
 
+// thinkingLevel returns the reasoning effort to ask of req's model: none
+// for a model the catalog lists without reasoning, which refuses one.
+func thinkingLevel(req provider.CompletionRequest) string {
+	if !models.SupportsThinking(req.Model.Provider, req.Model.Model) {
+		return ""
+	}
+	return string(req.Thinking)
+}
+
+// dataPrefix starts the data lines of a server-sent event stream, and
+// doneMarker is the data that ends a Chat Completions stream.
+var dataPrefix, doneMarker = []byte("data:"), []byte("[DONE]")
+
 // streamChat returns (inputTokens, outputTokens, error).
 func (p Provider) streamChat(ctx context.Context, body chatRequest, nameMap map[string]string, ch chan<- provider.StreamEvent) error {
 	data, err := json.Marshal(body)
@@ -90,13 +157,15 @@ func (p Provider) streamChat(ctx context.Context, body chatRequest, nameMap map[
 		httpClient = &http.Client{Timeout: 120 * time.Second}
 	}
 	url := strings.TrimRight(p.BaseURL, "/") + "/chat/completions"
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
 	if err != nil {
 		return err
 	}
-	httpReq.Header.Set("Authorization", "Bearer "+p.APIKey)
 	httpReq.Header.Set("Content-Type", "application/json")
 	httpReq.Header.Set("Accept", "text/event-stream")
+	p.setHeaders(httpReq)
 	resp, err := httpClient.Do(httpReq)
 	if err != nil {
 		return err
@@ -104,24 +173,17 @@ func (p Provider) streamChat(ctx context.Context, body chatRequest, nameMap map[
 	defer resp.Body.Close()
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		rawBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("openai provider request failed: %s: %s", resp.Status, strings.TrimSpace(string(rawBody)))
-	}
-	// Collect accumulated tool call state keyed by index.
-	type toolCallAccum struct {
-		id        string
-		name      string
-		arguments strings.Builder
+		return fmt.Errorf("openai provider request failed: %w", provider.NewAPIError(resp, rawBody))
 	}
-	toolCalls := make(map[int]*toolCallAccum)
 	// Peek at the content type to decide SSE vs plain JSON fallback.
 	contentType := resp.Header.Get("Content-Type")
 	isSSE := strings.Contains(contentType, "text/event-stream")
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("reading response body: %w", err)
-	}
 	if !isSSE {
 		// Proxy returned a plain JSON response; fall back to non-streaming parse.
+		bodyBytes, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("reading response body: %w", err)
+		}
 		var fallback chatResponse
 		if err := json.Unmarshal(bodyBytes, &fallback); err != nil {
 			return fmt.Errorf("parse fallback chat response: %w", err)
@@ -130,6 +192,9 @@ func (p Provider) streamChat(ctx context.Context, body chatRequest, nameMap map[
 			return fmt.Errorf("openai chat response returned no choices")
 		}
 		message := fallback.Choices[0].Message
+		if message.ReasoningContent != "" {
+			ch <- provider.StreamEvent{Type: provider.StreamEventReasoning, Text: message.ReasoningContent}
+		}
 		for _, call := range message.ToolCalls {
 			args, parseErr := parseArguments(call.Function.Arguments)
 			if parseErr != nil {
@@ -143,40 +208,99 @@ func (p Provider) streamChat(ctx context.Context, body chatRequest, nameMap map[
 		// Report usage from non-streaming response.
 		if fallback.Usage.TotalTokens > 0 {
 			ch <- provider.StreamEvent{
-				Type:         provider.StreamEventDone,
-				InputTokens:  fallback.Usage.PromptTokens,
-				OutputTokens: fallback.Usage.CompletionTokens,
+				Type:            provider.StreamEventDone,
+				InputTokens:     fallback.Usage.PromptTokens,
+				OutputTokens:    fallback.Usage.CompletionTokens,
+				ReasoningTokens: fallback.Usage.CompletionTokensDetails.ReasoningTokens,
+				CacheReadTokens: fallback.Usage.cachedTokens(),
+				Timing:          fallback.Usage.timing(),
 			}
 		}
 		return nil
 	}
-	scanner := bufio.NewScanner(strings.NewReader(string(bodyBytes)))
-	scanner.Buffer(make([]byte, 64*1024), 64*1024)
+	stream := provider.WatchStalls(resp.Body, p.StallTimeout, cancel)
+	defer stream.Close()
+	return readChatStream(stream, nameMap, ch)
+}
+
+// readChatStream emits the events of a Chat Completions stream as they
+// arrive, so text, usage, and tool call arguments reach the caller live,
+// and whole tool calls once the stream ends. Lines are decoded in place
+// from the scanner's buffer; only deltas and tool call arguments are kept.
+func readChatStream(r io.Reader, nameMap map[string]string, ch chan<- provider.StreamEvent) error {
+	// Collect accumulated tool call state keyed by index.
+	type toolCallAccum struct {
+		id        string
+		name      string
+		arguments strings.Builder
+	}
+	toolCalls := make(map[int]*toolCallAccum)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var running *provider.StreamEvent // last running usage not yet followed by a final count
+	// Groq reports usage with timings in x_groq, ahead of any standard
+	// usage chunk; it is the final count if none follows.
+	var timing *provider.Timing
+	var groqUsage *chatUsage
+	final := false
+	produced, filtered := false, false
 	for scanner.Scan() {
-		line := scanner.Text()
-		if !strings.HasPrefix(line, "data:") {
+		payload, ok := bytes.CutPrefix(scanner.Bytes(), dataPrefix)
+		if !ok {
 			continue
 		}
-		payload := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
-		if payload == "[DONE]" {
+		payload = bytes.TrimSpace(payload)
+		if bytes.Equal(payload, doneMarker) {
 			break
 		}
 		var chunk chatStreamChunk
-		if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+		if err := json.Unmarshal(payload, &chunk); err != nil {
 			continue
 		}
-		// Extract usage from final chunk (stream_options.include_usage)
+		if chunk.XGroq != nil && chunk.XGroq.Usage != nil {
+			groqUsage = chunk.XGroq.Usage
+			timing = cmp.Or(groqUsage.timing(), timing)
+		}
+		if chunk.TimeInfo != nil {
+			timing = cmp.Or(chunk.TimeInfo.timing(), timing)
+		}
 		if chunk.Usage != nil {
+			timing = cmp.Or(chunk.Usage.timing(), timing)
+		}
+		// Servers that report usage on every chunk (e.g. vLLM's continuous
+		// usage stats) attach it next to the delta; those are running counts.
+		if chunk.Usage != nil && len(chunk.Choices) > 0 {
+			running = &provider.StreamEvent{
+				Type:            provider.StreamEventUsage,
+				InputTokens:     chunk.Usage.PromptTokens,
+				OutputTokens:    chunk.Usage.CompletionTokens,
+				ReasoningTokens: chunk.Usage.CompletionTokensDetails.ReasoningTokens,
+				CacheReadTokens: chunk.Usage.cachedTokens(),
+			}
+			ch <- *running
+		} else if chunk.Usage != nil {
+			// Final usage-only chunk (stream_options.include_usage).
+			running, final = nil, true
 			ch <- provider.StreamEvent{
-				Type:         provider.StreamEventDone,
-				InputTokens:  chunk.Usage.PromptTokens,
-				OutputTokens: chunk.Usage.CompletionTokens,
+				Type:            provider.StreamEventDone,
+				InputTokens:     chunk.Usage.PromptTokens,
+				OutputTokens:    chunk.Usage.CompletionTokens,
+				ReasoningTokens: chunk.Usage.CompletionTokensDetails.ReasoningTokens,
+				CacheReadTokens: chunk.Usage.cachedTokens(),
+				Timing:          timing,
 			}
 		}
 		if len(chunk.Choices) == 0 {
 			continue
 		}
+		if chunk.Choices[0].FinishReason == "content_filter" {
+			filtered = true
+		}
 		delta := chunk.Choices[0].Delta
+		produced = produced || delta.Content != "" || len(delta.ToolCalls) > 0
+		if delta.ReasoningContent != "" {
+			ch <- provider.StreamEvent{Type: provider.StreamEventReasoning, Text: delta.ReasoningContent}
+		}
 		if delta.Content != "" {
 			ch <- provider.StreamEvent{Type: provider.StreamEventText, Text: delta.Content}
 		}
@@ -193,11 +317,34 @@ func (p Provider) streamChat(ctx context.Context, body chatRequest, nameMap map[
 				accum.name = tc.Function.Name
 			}
 			accum.arguments.WriteString(tc.Function.Arguments)
+			if tc.Function.Arguments != "" {
+				ch <- provider.StreamEvent{Type: provider.StreamEventToolCallDelta, Text: tc.Function.Arguments, ToolCall: tool.Call{ID: accum.id, ToolID: restoreToolID(accum.name, nameMap)}}
+			}
 		}
 	}
 	if scanErr := scanner.Err(); scanErr != nil {
 		return fmt.Errorf("reading stream: %w", scanErr)
 	}
+	// A reply the content filter withheld entirely is an error, so a
+	// fallback model can be tried; a partly filtered reply is kept.
+	if filtered && !produced {
+		return errors.New("openai provider: response blocked by the content filter (finish_reason content_filter)")
+	}
+	switch {
+	case running != nil:
+		done := *running
+		done.Type, done.Timing = provider.StreamEventDone, timing
+		ch <- done
+	case !final && groqUsage != nil:
+		ch <- provider.StreamEvent{
+			Type:            provider.StreamEventDone,
+			InputTokens:     groqUsage.PromptTokens,
+			OutputTokens:    groqUsage.CompletionTokens,
+			ReasoningTokens: groqUsage.CompletionTokensDetails.ReasoningTokens,
+			CacheReadTokens: groqUsage.cachedTokens(),
+			Timing:          timing,
+		}
+	}
 	for i := 0; i < len(toolCalls); i++ {
 		accum, ok := toolCalls[i]
 		if !ok {
@@ -214,14 +361,32 @@ func (p Provider) streamChat(ctx context.Context, body chatRequest, nameMap map[
 
 func (p Provider) runResponses(ctx context.Context, req provider.CompletionRequest, ch chan<- provider.StreamEvent) error {
 	nameMap := buildToolNameMap(req.Tools)
+	input := toResponsesInput(req.Messages)
 	body := responsesRequest{
-		Model:        req.Model.Model,
-		Instructions: req.System,
-		Input:        toResponsesInput(req.Messages),
-		Tools:        toResponsesTools(req.Tools),
-		ToolChoice:   "auto",
+		Model:          req.Model.Model,
+		Instructions:   req.System,
+		Input:          input,
+		Tools:          toResponsesTools(req.Tools, req.HostedTools),
+		ToolChoice:     toResponsesToolChoice(req.ToolChoice),
+		PromptCacheKey: p.promptCacheKey(req),
+		ServiceTier:    p.ServiceTier,
+	}
+	if effort := thinkingLevel(req); effort != "" {
+		body.Reasoning = &responsesReasoning{Effort: effort}
+	}
+	var hashes []string
+	if p.ServerState {
+		hashes = inputHashes(p.chainSeed(req.Model.Model), input)
+		if id, n := chains.find(hashes); id != "" {
+			body.PreviousResponseID, body.Input = id, input[n:]
+		}
 	}
 	responseBody, err := p.postJSON(ctx, "/responses", body)
+	if body.PreviousResponseID != "" && isMissingPreviousResponse(err) {
+		// The stored response expired or was deleted; send the full history.
+		body.PreviousResponseID, body.Input = "", input
+		responseBody, err = p.postJSON(ctx, "/responses", body)
+	}
 	if err != nil {
 		return err
 	}
@@ -230,6 +395,8 @@ func (p Provider) runResponses(ctx context.Context, req provider.CompletionReque
 		return err
 	}
 	var textParts []string
+	var calls []tool.Call
+	var raws []provider.RawContent
 	for _, item := range resp.Output {
 		switch item.Type {
 		case "function_call":
@@ -237,32 +404,69 @@ func (p Provider) runResponses(ctx context.Context, req provider.CompletionReque
 			if err != nil {
 				return fmt.Errorf("parse tool call %s arguments: %w", item.Name, err)
 			}
-			ch <- provider.StreamEvent{Type: provider.StreamEventToolCall, ToolCall: tool.Call{ID: item.CallID, ToolID: restoreToolID(item.Name, nameMap), Arguments: args}}
+			call := tool.Call{ID: item.CallID, ToolID: restoreToolID(item.Name, nameMap), Arguments: args}
+			calls = append(calls, call)
+			ch <- provider.StreamEvent{Type: provider.StreamEventToolCall, ToolCall: call}
 		case "message":
 			for _, content := range item.Content {
 				if strings.TrimSpace(content.Text) != "" {
 					textParts = append(textParts, content.Text)
 				}
 			}
+		default:
+			// Reasoning, web search calls, and other items the runtime does
+			// not model go back to the API unchanged on the next turn.
+			raw := provider.RawContent{Provider: "openai", Type: item.Type, Data: item.raw}
+			if strings.HasSuffix(item.Type, "_call") {
+				// web_search_call, file_search_call, code_interpreter_call...
+				raw.HostedTool = strings.TrimSuffix(item.Type, "_call")
+			}
+			raws = append(raws, raw)
+			ch <- provider.StreamEvent{Type: provider.StreamEventRaw, Raw: raw}
 		}
 	}
 	if strings.TrimSpace(resp.OutputText) != "" {
 		textParts = append(textParts, resp.OutputText)
 	}
-	if joined := strings.TrimSpace(strings.Join(textParts, "\n")); joined != "" {
+	joined := strings.TrimSpace(strings.Join(textParts, "\n"))
+	if joined != "" {
 		ch <- provider.StreamEvent{Type: provider.StreamEventText, Text: joined}
 	}
+	if p.ServerState && resp.ID != "" {
+		seed := p.chainSeed(req.Model.Model)
+		if len(hashes) > 0 {
+			seed = hashes[len(hashes)-1]
+		}
+		if covered := inputHashes(seed, outputItems(joined, calls, raws)); len(covered) > 0 {
+			chains.remember(covered[len(covered)-1], resp.ID)
+		}
+	}
 	// Emit usage from responses API.
 	if resp.Usage != nil {
 		ch <- provider.StreamEvent{
-			Type:         provider.StreamEventDone,
-			InputTokens:  resp.Usage.InputTokens,
-			OutputTokens: resp.Usage.OutputTokens,
+			Type:            provider.StreamEventDone,
+			InputTokens:     resp.Usage.InputTokens,
+			OutputTokens:    resp.Usage.OutputTokens,
+			ReasoningTokens: resp.Usage.OutputTokensDetails.ReasoningTokens,
+			CacheReadTokens: resp.Usage.InputTokensDetails.CachedTokens,
 		}
 	}
 	return nil
 }
 
+// setHeaders sets what every request carries: the API key, the
+// organization and project, and the configured headers and query.
+func (p Provider) setHeaders(req *http.Request) {
+	req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	if p.Organization != "" {
+		req.Header.Set("OpenAI-Organization", p.Organization)
+	}
+	if p.Project != "" {
+		req.Header.Set("OpenAI-Project", p.Project)
+	}
+	provider.ApplyExtras(req, p.Headers, p.Query)
+}
+
 func (p Provider) postJSON(ctx context.Context, endpoint string, body any) ([]byte, error) {
 	data, err := json.Marshal(body)
 	if err != nil {
@@ -277,8 +481,8 @@ func (p Provider) postJSON(ctx context.Context, endpoint string, body any) ([]by
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Authorization", "Bearer "+p.APIKey)
 	req.Header.Set("Content-Type", "application/json")
+	p.setHeaders(req)
 	resp, err := httpClient.Do(req)
 	if err != nil {
 		return nil, err
@@ -289,7 +493,7 @@ func (p Provider) postJSON(ctx context.Context, endpoint string, body any) ([]by
 		return nil, err
 	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("openai provider request failed: %s: %s", resp.Status, strings.TrimSpace(string(responseBody)))
+		return nil, fmt.Errorf("openai provider request failed: %w", provider.NewAPIError(resp, responseBody))
 	}
 	return responseBody, nil
 }
@@ -321,6 +525,46 @@ func sanitizeToolName(id string) string {
 	return b.String()
 }
 
+// chatToolChoice forces a call to one function in the Chat Completions API.
+type chatToolChoice struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name string `json:"name"`
+	} `json:"function"`
+}
+
+// toChatToolChoice maps a tool choice to tool_choice, which is "auto" when
+// the choice is left to the model.
+func toChatToolChoice(choice provider.ToolChoice) any {
+	if id, ok := choice.Tool(); ok {
+		forced := chatToolChoice{Type: "function"}
+		forced.Function.Name = sanitizeToolName(id)
+		return forced
+	}
+	if choice == "" {
+		return string(provider.ToolChoiceAuto)
+	}
+	return string(choice)
+}
+
+// responsesToolChoice forces a call to one function in the Responses API.
+type responsesToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+// toResponsesToolChoice maps a tool choice to tool_choice, which is "auto"
+// when the choice is left to the model.
+func toResponsesToolChoice(choice provider.ToolChoice) any {
+	if id, ok := choice.Tool(); ok {
+		return responsesToolChoice{Type: "function", Name: sanitizeToolName(id)}
+	}
+	if choice == "" {
+		return string(provider.ToolChoiceAuto)
+	}
+	return string(choice)
+}
+
 // buildToolNameMap returns a mapping of sanitized-name → original-tool-ID built
 // from the supplied tool definitions. Used to reverse-map tool call names that
 // come back from the API to the original IDs the runtime understands.
@@ -354,8 +598,43 @@ func parseArguments(input string) (map[string]any, error) {
 
 func toChatMessages(req provider.CompletionRequest) []chatMessage {
 	messages := make([]chatMessage, 0, len(req.Messages))
+	// Tool messages carry text only, so images from tool results follow
+	// them in a user message.
+	var toolImages []provider.Image
+	flushToolImages := func() {
+		if len(toolImages) == 0 {
+			return
+		}
+		parts := []chatContentPart{{Type: "text", Text: toolImagesText}}
+		for _, img := range toolImages {
+			parts = append(parts, chatContentPart{Type: "image_url", ImageURL: &chatImageURL{URL: dataURL(img)}})
+		}
+		messages = append(messages, chatMessage{Role: "user", Content: parts})
+		toolImages = nil
+	}
 	for _, message := range req.Messages {
-		chatMsg := chatMessage{Role: mapRole(message.Role), Content: message.Content}
+		if message.Role == "tool" {
+			toolImages = append(toolImages, message.Images...)
+		} else {
+			flushToolImages()
+		}
+		chatMsg := chatMessage{Role: mapRole(message.Role)}
+		if message.Content != "" {
+			chatMsg.Content = message.Content
+		}
+		if message.Role != "tool" && (len(message.Images) > 0 || len(message.Documents) > 0) {
+			parts := []chatContentPart{}
+			for _, doc := range message.Documents {
+				parts = append(parts, chatContentPart{Type: "file", File: &chatFile{Filename: fileName(doc), FileData: fileData(doc), FileID: doc.FileID}})
+			}
+			if message.Content != "" {
+				parts = append(parts, chatContentPart{Type: "text", Text: message.Content})
+			}
+			for _, img := range message.Images {
+				parts = append(parts, chatContentPart{Type: "image_url", ImageURL: &chatImageURL{URL: dataURL(img)}})
+			}
+			chatMsg.Content = parts
+		}
 		if len(message.ToolCalls) > 0 {
 			chatMsg.Role = "assistant"
 			chatMsg.ToolCalls = make([]chatToolCall, 0, len(message.ToolCalls))
@@ -376,18 +655,44 @@ func toChatMessages(req provider.CompletionRequest) []chatMessage {
 		}
 		messages = append(messages, chatMsg)
 	}
+	flushToolImages()
 	return messages
 }
 
-func toResponsesInput(messages []provider.Message) []responsesInputItem {
-	items := make([]responsesInputItem, 0, len(messages))
+// toolImagesText introduces the user message that carries the images of
+// the tool results before it.
+const toolImagesText = "Images from the tool results above:"
+
+func toResponsesInput(messages []provider.Message) []any {
+	items := make([]any, 0, len(messages))
+	var toolImages []provider.Image
+	flushToolImages := func() {
+		if len(toolImages) == 0 {
+			return
+		}
+		content := []responsesInputContent{{Type: "input_text", Text: toolImagesText}}
+		for _, img := range toolImages {
+			content = append(content, responsesInputContent{Type: "input_image", ImageURL: dataURL(img)})
+		}
+		items = append(items, responsesInputItem{Role: "user", Content: content})
+		toolImages = nil
+	}
 	for _, message := range messages {
+		if message.Role != "tool" {
+			flushToolImages()
+		}
+		for _, raw := range message.Raw {
+			if raw.Provider == "openai" {
+				items = append(items, raw.Data)
+			}
+		}
 		if message.Role == "tool" {
 			items = append(items, responsesInputItem{
 				Type:   "function_call_output",
 				CallID: message.ToolCallID,
 				Output: message.Content,
 			})
+			toolImages = append(toolImages, message.Images...)
 			continue
 		}
 		// Assistant messages with tool calls → function_call items
@@ -412,17 +717,51 @@ func toResponsesInput(messages []provider.Message) []responsesInputItem {
 			}
 			continue
 		}
+		content := []responsesInputContent{}
+		for _, doc := range message.Documents {
+			content = append(content, responsesInputContent{Type: "input_file", Filename: fileName(doc), FileData: fileData(doc), FileID: doc.FileID})
+		}
+		if message.Content != "" || (len(message.Images) == 0 && len(message.Documents) == 0) {
+			content = append(content, responsesInputContent{Type: "input_text", Text: message.Content})
+		}
+		for _, img := range message.Images {
+			content = append(content, responsesInputContent{Type: "input_image", ImageURL: dataURL(img)})
+		}
 		items = append(items, responsesInputItem{
-			Role: mapRole(message.Role),
-			Content: []responsesInputContent{{
-				Type: "input_text",
-				Text: message.Content,
-			}},
+			Role:    mapRole(message.Role),
+			Content: content,
 		})
 	}
+	flushToolImages()
 	return items
 }
 
+// dataURL encodes an image for the image_url fields of both APIs.
+func dataURL(img provider.Image) string {
+	return "data:" + img.MediaType + ";base64," + base64.StdEncoding.EncodeToString(img.Data)
+}
+
+// fileData encodes a document for the file_data fields of both APIs, or
+// returns "" for an uploaded file sent by ID.
+func fileData(doc provider.Document) string {
+	if doc.FileID != "" {
+		return ""
+	}
+	return "data:" + doc.MediaType + ";base64," + base64.StdEncoding.EncodeToString(doc.Data)
+}
+
+// fileName returns the filename sent with a document's data; the APIs
+// require one.
+func fileName(doc provider.Document) string {
+	switch {
+	case doc.FileID != "":
+		return ""
+	case doc.Name != "":
+		return doc.Name
+	}
+	return "document.pdf"
+}
+
 func toChatTools(defs []tool.Definition) []chatTool {
 	tools := make([]chatTool, 0, len(defs))
 	for _, def := range defs {
@@ -438,8 +777,10 @@ func toChatTools(defs []tool.Definition) []chatTool {
 	return tools
 }
 
-func toResponsesTools(defs []tool.Definition) []responsesTool {
-	tools := make([]responsesTool, 0, len(defs))
+// toResponsesTools maps function tools and hosted tools (web_search,
+// file_search, code_interpreter, ...) to Responses tools.
+func toResponsesTools(defs []tool.Definition, hosted []provider.HostedTool) []any {
+	tools := make([]any, 0, len(defs)+len(hosted))
 	for _, def := range defs {
 		tools = append(tools, responsesTool{
 			Type:        "function",
@@ -448,9 +789,22 @@ func toResponsesTools(defs []tool.Definition) []responsesTool {
 			Parameters:  schemaOrObject(def.Schema),
 		})
 	}
+	for _, t := range hosted {
+		tools = append(tools, hostedTool(t))
+	}
 	return tools
 }
 
+// hostedTool is a built-in tool definition: its options with its type.
+func hostedTool(t provider.HostedTool) map[string]any {
+	def := map[string]any{}
+	for k, v := range t.Options {
+		def[k] = v
+	}
+	def["type"] = t.Type
+	return def
+}
+
 func schemaOrObject(schema map[string]any) map[string]any {
 	if len(schema) == 0 {
 		return map[string]any{"type": "object", "properties": map[string]any{}}
@@ -477,9 +831,11 @@ type chatRequest struct {
 	Model         string         `json:"model"`
 	Messages      []chatMessage  `json:"messages"`
 	Tools         []chatTool     `json:"tools,omitempty"`
-	ToolChoice    string         `json:"tool_choice,omitempty"`
+	ToolChoice    any            `json:"tool_choice,omitempty"` // a mode or a chatToolChoice
 	Stream        bool           `json:"stream,omitempty"`
 	StreamOptions *streamOptions `json:"stream_options,omitempty"`
+	ServiceTier   string         `json:"service_tier,omitempty"`
+	Effort        string         `json:"reasoning_effort,omitempty"` // a provider.ThinkingLevel
 }
 
 type streamOptions struct {
@@ -489,8 +845,11 @@ type streamOptions struct {
 type chatStreamChunk struct {
 	Choices []struct {
 		Delta struct {
-			Content   string `json:"content"`
-			ToolCalls []struct {
+			Content string `json:"content"`
+			// ReasoningContent is the reasoning DeepSeek and other
+			// compatible servers stream ahead of the answer.
+			ReasoningContent string `json:"reasoning_content"`
+			ToolCalls        []struct {
 				Index    int    `json:"index"`
 				ID       string `json:"id"`
 				Function struct {
@@ -499,21 +858,78 @@ type chatStreamChunk struct {
 				} `json:"function"`
 			} `json:"tool_calls"`
 		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
-	Usage *struct {
-		PromptTokens     int `json:"prompt_tokens"`
-		CompletionTokens int `json:"completion_tokens"`
-		TotalTokens      int `json:"total_tokens"`
-	} `json:"usage,omitempty"`
+	Usage *chatUsage `json:"usage,omitempty"`
+	// XGroq carries Groq's usage, with timings, on the last choice chunk.
+	XGroq *struct {
+		Usage *chatUsage `json:"usage"`
+	} `json:"x_groq,omitempty"`
+	// TimeInfo is Cerebras's timing, sent with the usage.
+	TimeInfo *chatTimeInfo `json:"time_info,omitempty"`
+}
+
+// chatTimeInfo holds server timings in seconds, as Groq reports them in
+// its usage and Cerebras in time_info.
+type chatTimeInfo struct {
+	QueueTime      float64 `json:"queue_time"`
+	PromptTime     float64 `json:"prompt_time"`
+	CompletionTime float64 `json:"completion_time"`
+}
+
+// timing converts the timings, or returns nil when none were reported.
+func (t chatTimeInfo) timing() *provider.Timing {
+	if t == (chatTimeInfo{}) {
+		return nil
+	}
+	seconds := func(s float64) time.Duration { return time.Duration(s * float64(time.Second)) }
+	return &provider.Timing{Queue: seconds(t.QueueTime), Prompt: seconds(t.PromptTime), Completion: seconds(t.CompletionTime)}
+}
+
+type chatUsage struct {
+	chatTimeInfo
+	PromptTokens            int `json:"prompt_tokens"`
+	CompletionTokens        int `json:"completion_tokens"`
+	TotalTokens             int `json:"total_tokens"`
+	CompletionTokensDetails struct {
+		ReasoningTokens int `json:"reasoning_tokens"`
+	} `json:"completion_tokens_details"`
+	PromptTokensDetails struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+	// PromptCacheHitTokens is DeepSeek's count of cached prompt tokens.
+	PromptCacheHitTokens int `json:"prompt_cache_hit_tokens"`
+}
+
+// cachedTokens returns the prompt tokens served from the cache.
+func (u chatUsage) cachedTokens() int {
+	return max(u.PromptTokensDetails.CachedTokens, u.PromptCacheHitTokens)
 }
 
 type chatMessage struct {
 	Role       string         `json:"role"`
-	Content    string         `json:"content,omitempty"`
+	Content    any            `json:"content,omitempty"` // a string, or []chatContentPart with images
 	ToolCalls  []chatToolCall `json:"tool_calls,omitempty"`
 	ToolCallID string         `json:"tool_call_id,omitempty"`
 }
 
+type chatContentPart struct {
+	Type     string        `json:"type"`
+	Text     string        `json:"text,omitempty"`
+	ImageURL *chatImageURL `json:"image_url,omitempty"`
+	File     *chatFile     `json:"file,omitempty"`
+}
+
+type chatImageURL struct {
+	URL string `json:"url"`
+}
+
+type chatFile struct {
+	Filename string `json:"filename,omitempty"`
+	FileData string `json:"file_data,omitempty"`
+	FileID   string `json:"file_id,omitempty"`
+}
+
 type chatToolCall struct {
 	ID       string               `json:"id,omitempty"`
 	Type     string               `json:"type"`
@@ -539,8 +955,9 @@ type chatFunction struct {
 type chatResponse struct {
 	Choices []struct {
 		Message struct {
-			Content   string `json:"content"`
-			ToolCalls []struct {
+			Content          string `json:"content"`
+			ReasoningContent string `json:"reasoning_content"`
+			ToolCalls        []struct {
 				ID       string `json:"id"`
 				Function struct {
 					Name      string `json:"name"`
@@ -549,19 +966,27 @@ type chatResponse struct {
 			} `json:"tool_calls"`
 		} `json:"message"`
 	} `json:"choices"`
-	Usage struct {
-		PromptTokens     int `json:"prompt_tokens"`
-		CompletionTokens int `json:"completion_tokens"`
-		TotalTokens      int `json:"total_tokens"`
-	} `json:"usage"`
+	Usage chatUsage `json:"usage"`
 }
 
 type responsesRequest struct {
-	Model        string               `json:"model"`
-	Instructions string               `json:"instructions,omitempty"`
-	Input        []responsesInputItem `json:"input"`
-	Tools        []responsesTool      `json:"tools,omitempty"`
-	ToolChoice   string               `json:"tool_choice,omitempty"`
+	Model        string `json:"model"`
+	Instructions string `json:"instructions,omitempty"`
+	Input        []any  `json:"input"`                 // responsesInputItem or a raw output item
+	Tools        []any  `json:"tools,omitempty"`       // responsesTool or a hosted tool
+	ToolChoice   any    `json:"tool_choice,omitempty"` // a mode or a responsesToolChoice
+
+	PromptCacheKey     string `json:"prompt_cache_key,omitempty"`
+	PreviousResponseID string `json:"previous_response_id,omitempty"`
+	ServiceTier        string `json:"service_tier,omitempty"`
+
+	Reasoning *responsesReasoning `json:"reasoning,omitempty"`
+}
+
+// responsesReasoning asks a reasoning model for an effort, a
+// provider.ThinkingLevel.
+type responsesReasoning struct {
+	Effort string `json:"effort"`
 }
 
 type responsesInputItem struct {
@@ -575,8 +1000,12 @@ type responsesInputItem struct {
 }
 
 type responsesInputContent struct {
-	Type string `json:"type"`
-	Text string `json:"text"`
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"` // input_image
+	Filename string `json:"filename,omitempty"`  // input_file
+	FileData string `json:"file_data,omitempty"` // input_file
+	FileID   string `json:"file_id,omitempty"`   // input_file
 }
 
 type responsesTool struct {
@@ -587,12 +1016,19 @@ type responsesTool struct {
 }
 
 type responsesResponse struct {
+	ID         string                `json:"id"`
 	OutputText string                `json:"output_text"`
 	Output     []responsesOutputItem `json:"output"`
 	Usage      *struct {
-		InputTokens  int `json:"input_tokens"`
-		OutputTokens int `json:"output_tokens"`
-		TotalTokens  int `json:"total_tokens"`
+		InputTokens         int `json:"input_tokens"`
+		OutputTokens        int `json:"output_tokens"`
+		TotalTokens         int `json:"total_tokens"`
+		OutputTokensDetails struct {
+			ReasoningTokens int `json:"reasoning_tokens"`
+		} `json:"output_tokens_details"`
+		InputTokensDetails struct {
+			CachedTokens int `json:"cached_tokens"`
+		} `json:"input_tokens_details"`
 	} `json:"usage,omitempty"`
 }
 
@@ -602,6 +1038,17 @@ type responsesOutputItem struct {
 	Name      string                   `json:"name"`
 	Arguments string                   `json:"arguments"`
 	Content   []responsesOutputContent `json:"content"`
+
+	raw json.RawMessage // the item as received
+}
+
+func (i *responsesOutputItem) UnmarshalJSON(data []byte) error {
+	type plain responsesOutputItem
+	if err := json.Unmarshal(data, (*plain)(i)); err != nil {
+		return err
+	}
+	i.raw = append(json.RawMessage(nil), data...)
+	return nil
 }
 
 type responsesOutputContent struct {
@@ -1,13 +1,17 @@
 package openai
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/bitop-dev/agent/pkg/provider"
 	"github.com/bitop-dev/agent/pkg/tool"
@@ -182,6 +186,162 @@ func TestProviderChatModeSSEStreaming(t *testing.T) {
 	}
 }
 
+func TestRequestsCarryOrganizationHeadersAndQuery(t *testing.T) {
+	var got *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintln(w, `data: {"choices":[{"delta":{"content":"ok"}}]}`)
+		fmt.Fprintln(w, `data: [DONE]`)
+	}))
+	defer server.Close()
+
+	p := Provider{
+		BaseURL: server.URL, APIKey: "test-key", APIMode: apiModeChat, HTTPClient: server.Client(),
+		Organization: "org-1", Project: "proj-1",
+		Headers: map[string]string{"X-Title": "agent", "HTTP-Referer": "https://example.com"},
+		Query:   map[string]string{"api-version": "2024-10-21"},
+	}
+	stream, err := p.Stream(context.Background(), provider.CompletionRequest{
+		Model:        provider.ModelRef{Model: "gpt-4.1"},
+		Messages:     []provider.Message{{Role: "user", Content: "hello"}},
+		ExtraHeaders: map[string]string{"X-Title": "nightly", "X-Trace": "t1"},
+		ExtraQuery:   map[string]string{"tenant": "acme"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for event := range stream {
+		if event.Err != nil {
+			t.Fatalf("event error: %v", event.Err)
+		}
+	}
+	for name, want := range map[string]string{
+		"Authorization":       "Bearer test-key",
+		"OpenAI-Organization": "org-1",
+		"OpenAI-Project":      "proj-1",
+		"HTTP-Referer":        "https://example.com",
+		"X-Title":             "nightly",
+		"X-Trace":             "t1",
+	} {
+		if value := got.Header.Get(name); value != want {
+			t.Errorf("header %s: got %q, want %q", name, value, want)
+		}
+	}
+	if query := got.URL.RawQuery; query != "api-version=2024-10-21&tenant=acme" {
+		t.Errorf("unexpected query %q", query)
+	}
+	if len(p.Headers) != 2 {
+		t.Errorf("request headers leaked into the provider: %v", p.Headers)
+	}
+}
+
+func TestThinkingIsSentOnlyToReasoningModels(t *testing.T) {
+	var bodies []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("decode body: %v", err)
+		}
+		bodies = append(bodies, body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintln(w, `data: [DONE]`)
+	}))
+	defer server.Close()
+
+	for _, mode := range []string{apiModeChat, apiModeResponses} {
+		p := Provider{BaseURL: server.URL, APIKey: "test-key", APIMode: mode, HTTPClient: server.Client()}
+		for _, model := range []string{"o4-mini", "gpt-4o", "gpt-5"} {
+			bodies = nil
+			stream, err := p.Stream(context.Background(), provider.CompletionRequest{
+				Model:    provider.ModelRef{Model: model},
+				Messages: []provider.Message{{Role: "user", Content: "hello"}},
+				Thinking: provider.ThinkingLow,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range stream {
+			}
+			_, effort := bodies[0]["reasoning_effort"]
+			_, reasoning := bodies[0]["reasoning"]
+			if sent, want := effort || reasoning, model != "gpt-4o"; sent != want {
+				t.Errorf("%s mode, %s: thinking sent = %v, want %v", mode, model, sent, want)
+			}
+		}
+	}
+}
+
+func TestErrorResponsesAreTypedAPIErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("retry-after-ms", "1500")
+		w.Header().Set("Retry-After", "2")
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"error":{"message":"Rate limit reached","type":"requests","code":"rate_limit_exceeded"}}`)
+	}))
+	defer server.Close()
+
+	p := Provider{BaseURL: server.URL, APIKey: "test-key", APIMode: apiModeChat, HTTPClient: server.Client()}
+	stream, err := p.Stream(context.Background(), provider.CompletionRequest{
+		Model:    provider.ModelRef{Model: "gpt-4.1"},
+		Messages: []provider.Message{{Role: "user", Content: "hello"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var apiErr *provider.APIError
+	for event := range stream {
+		if event.Err != nil && !errors.As(event.Err, &apiErr) {
+			t.Fatalf("expected an APIError, got %v", event.Err)
+		}
+	}
+	if apiErr == nil || apiErr.Status != 429 || apiErr.Code != "rate_limit_exceeded" || apiErr.Message != "Rate limit reached" || apiErr.RetryAfter != 1500*time.Millisecond || apiErr.Kind() != provider.ErrorRateLimited {
+		t.Fatalf("unexpected error %+v", apiErr)
+	}
+	if !strings.HasPrefix(apiErr.Error(), "429 Too Many Requests: {") {
+		t.Fatalf("unexpected message %q", apiErr.Error())
+	}
+}
+
+func TestProviderChatModeStreamsRunningUsage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintln(w, `data: {"choices":[{"delta":{"content":"a"}}],"usage":{"prompt_tokens":50,"completion_tokens":1,"total_tokens":51}}`)
+		fmt.Fprintln(w, `data: {"choices":[{"delta":{"content":"b"}}],"usage":{"prompt_tokens":50,"completion_tokens":2,"total_tokens":52}}`)
+		fmt.Fprintln(w, `data: [DONE]`)
+	}))
+	defer server.Close()
+
+	p := Provider{BaseURL: server.URL, APIKey: "test-key", APIMode: apiModeChat, HTTPClient: server.Client()}
+	stream, err := p.Stream(context.Background(), provider.CompletionRequest{
+		Model:    provider.ModelRef{Model: "served-model"},
+		Messages: []provider.Message{{Role: "user", Content: "hello"}},
+	})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	var running []int
+	var final provider.StreamEvent
+	for event := range stream {
+		if event.Err != nil {
+			t.Fatalf("event error: %v", event.Err)
+		}
+		switch event.Type {
+		case provider.StreamEventUsage:
+			running = append(running, event.OutputTokens)
+		case provider.StreamEventDone:
+			final = event
+		}
+	}
+	if len(running) != 2 || running[1] != 2 {
+		t.Fatalf("unexpected running usage: %v", running)
+	}
+	// Without a usage-only chunk the last running count is the final one.
+	if final.InputTokens != 50 || final.OutputTokens != 2 {
+		t.Fatalf("unexpected final usage: %+v", final)
+	}
+}
+
 func TestProviderResponsesModeText(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/responses" {
@@ -214,3 +374,330 @@ func TestProviderResponsesModeText(t *testing.T) {
 		t.Fatalf("unexpected text: %q", gotText)
 	}
 }
+
+func TestProviderReportsReasoningAndCachedTokens(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/responses":
+			fmt.Fprint(w, `{"output_text":"42","usage":{"input_tokens":30,"output_tokens":500,"total_tokens":530,"input_tokens_details":{"cached_tokens":24},"output_tokens_details":{"reasoning_tokens":480}}}`)
+		case "/chat/completions":
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintln(w, `data: {"choices":[{"delta":{"content":"42"}}]}`)
+			fmt.Fprintln(w, `data: {"choices":[],"usage":{"prompt_tokens":30,"completion_tokens":200,"total_tokens":230,"prompt_tokens_details":{"cached_tokens":24},"completion_tokens_details":{"reasoning_tokens":190}}}`)
+			fmt.Fprintln(w, `data: [DONE]`)
+		}
+	}))
+	defer server.Close()
+
+	for mode, want := range map[string]int{apiModeResponses: 480, apiModeChat: 190} {
+		p := Provider{BaseURL: server.URL, APIKey: "test-key", APIMode: mode, HTTPClient: server.Client()}
+		stream, err := p.Stream(context.Background(), provider.CompletionRequest{
+			Model:    provider.ModelRef{Model: "o4-mini"},
+			Messages: []provider.Message{{Role: "user", Content: "think"}},
+		})
+		if err != nil {
+			t.Fatalf("%s: stream: %v", mode, err)
+		}
+		var done provider.StreamEvent
+		for event := range stream {
+			if event.Err != nil {
+				t.Fatalf("%s: event error: %v", mode, event.Err)
+			}
+			if event.Type == provider.StreamEventDone {
+				done = event
+			}
+		}
+		if done.ReasoningTokens != want || done.CacheReadTokens != 24 {
+			t.Fatalf("%s: expected %d reasoning and 24 cached tokens, got %+v", mode, want, done)
+		}
+	}
+}
+
+func TestImagesAreSentAsDataURLs(t *testing.T) {
+	msg := provider.Message{Role: "user", Content: "what is this?", Images: []provider.Image{{MediaType: "image/png", Data: []byte("png")}}}
+	chat, err := json.Marshal(toChatMessages(provider.CompletionRequest{Messages: []provider.Message{msg}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"role":"user","content":[{"type":"text","text":"what is this?"},{"type":"image_url","image_url":{"url":"data:image/png;base64,cG5n"}}]}]`; string(chat) != want {
+		t.Fatalf("chat messages:\n got %s\nwant %s", chat, want)
+	}
+	responses, err := json.Marshal(toResponsesInput([]provider.Message{msg}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"role":"user","content":[{"type":"input_text","text":"what is this?"},{"type":"input_image","image_url":"data:image/png;base64,cG5n"}]}]`; string(responses) != want {
+		t.Fatalf("responses input:\n got %s\nwant %s", responses, want)
+	}
+}
+
+func TestToolResultImagesFollowTheToolOutputs(t *testing.T) {
+	messages := []provider.Message{
+		{Role: "assistant", ToolCalls: []tool.Call{{ID: "c1", ToolID: "core/read"}, {ID: "c2", ToolID: "core/read"}}},
+		{Role: "tool", Content: "image a.png", ToolCallID: "c1", Images: []provider.Image{{MediaType: "image/png", Data: []byte("png")}}},
+		{Role: "tool", Content: "text", ToolCallID: "c2"},
+	}
+	chat, err := json.Marshal(toChatMessages(provider.CompletionRequest{Messages: messages})[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"role":"tool","content":"image a.png","tool_call_id":"c1"},{"role":"tool","content":"text","tool_call_id":"c2"},{"role":"user","content":[{"type":"text","text":"Images from the tool results above:"},{"type":"image_url","image_url":{"url":"data:image/png;base64,cG5n"}}]}]`; string(chat) != want {
+		t.Fatalf("chat messages:\n got %s\nwant %s", chat, want)
+	}
+	responses, err := json.Marshal(toResponsesInput(messages)[2:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"type":"function_call_output","call_id":"c1","output":"image a.png"},{"type":"function_call_output","call_id":"c2","output":"text"},{"role":"user","content":[{"type":"input_text","text":"Images from the tool results above:"},{"type":"input_image","image_url":"data:image/png;base64,cG5n"}]}]`; string(responses) != want {
+		t.Fatalf("responses input:\n got %s\nwant %s", responses, want)
+	}
+}
+
+func TestToolChoiceIsSentAsToolChoice(t *testing.T) {
+	for choice, want := range map[provider.ToolChoice][2]string{
+		"":                          {`"auto"`, `"auto"`},
+		provider.ToolChoiceNone:     {`"none"`, `"none"`},
+		provider.ToolChoiceRequired: {`"required"`, `"required"`},
+		"email/draft":               {`{"type":"function","function":{"name":"email_draft"}}`, `{"type":"function","name":"email_draft"}`},
+	} {
+		chat, err := json.Marshal(toChatToolChoice(choice))
+		if err != nil {
+			t.Fatal(err)
+		}
+		responses, err := json.Marshal(toResponsesToolChoice(choice))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(chat) != want[0] || string(responses) != want[1] {
+			t.Fatalf("tool choice %q: got %s and %s, want %s and %s", choice, chat, responses, want[0], want[1])
+		}
+	}
+}
+
+func TestHostedToolsAreSentAsBuiltInTools(t *testing.T) {
+	defs := []tool.Definition{{ID: "core/read"}}
+	hosted := []provider.HostedTool{{Type: "web_search", Options: map[string]any{"search_context_size": "low"}}, {Type: "code_interpreter", Options: map[string]any{"container": map[string]any{"type": "auto"}}}}
+
+	responses, err := json.Marshal(toResponsesTools(defs, hosted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"type":"function","name":"core_read","parameters":{"properties":{},"type":"object"}},{"search_context_size":"low","type":"web_search"},{"container":{"type":"auto"},"type":"code_interpreter"}]`
+	if string(responses) != want {
+		t.Fatalf("responses tools:\n got %s\nwant %s", responses, want)
+	}
+
+	// Chat Completions has no built-in tools, so they are left out there.
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("decode body: %v", err)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintln(w, `data: [DONE]`)
+	}))
+	defer server.Close()
+	p := Provider{BaseURL: server.URL, APIKey: "test-key", APIMode: apiModeChat, HTTPClient: server.Client()}
+	stream, err := p.Stream(context.Background(), provider.CompletionRequest{
+		Model:       provider.ModelRef{Model: "gpt-4o"},
+		Messages:    []provider.Message{{Role: "user", Content: "hello"}},
+		Tools:       defs,
+		HostedTools: hosted,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range stream {
+	}
+	if tools, _ := body["tools"].([]any); len(tools) != 1 || body["web_search_options"] != nil {
+		t.Fatalf("expected only the function tool in chat mode, got %v", body)
+	}
+}
+
+func TestDocumentsAreSentAsFiles(t *testing.T) {
+	msg := provider.Message{Role: "user", Content: "summarize", Documents: []provider.Document{
+		{Name: "report.pdf", MediaType: "application/pdf", Data: []byte("pdf")},
+		{MediaType: "application/pdf", FileID: "file-1"},
+	}}
+	chat, err := json.Marshal(toChatMessages(provider.CompletionRequest{Messages: []provider.Message{msg}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"role":"user","content":[{"type":"file","file":{"filename":"report.pdf","file_data":"data:application/pdf;base64,cGRm"}},{"type":"file","file":{"file_id":"file-1"}},{"type":"text","text":"summarize"}]}]`; string(chat) != want {
+		t.Fatalf("chat messages:\n got %s\nwant %s", chat, want)
+	}
+	responses, err := json.Marshal(toResponsesInput([]provider.Message{msg}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"role":"user","content":[{"type":"input_file","filename":"report.pdf","file_data":"data:application/pdf;base64,cGRm"},{"type":"input_file","file_id":"file-1"},{"type":"input_text","text":"summarize"}]}]`; string(responses) != want {
+		t.Fatalf("responses input:\n got %s\nwant %s", responses, want)
+	}
+}
+
+func TestResponsesContinueFromPreviousResponse(t *testing.T) {
+	var bodies []map[string]any
+	forget := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		if forget && body["previous_response_id"] != nil {
+			http.Error(w, `{"error":{"code":"previous_response_not_found"}}`, http.StatusBadRequest)
+			return
+		}
+		if len(bodies) == 1 {
+			fmt.Fprint(w, `{"id":"resp_1","output":[{"type":"function_call","call_id":"call_1","name":"core_read","arguments":"{\"path\":\"a.txt\"}"}]}`)
+			return
+		}
+		fmt.Fprintf(w, `{"id":"resp_%d","output_text":"done"}`, len(bodies))
+	}))
+	defer server.Close()
+
+	p := Provider{BaseURL: server.URL, APIKey: "test-key", APIMode: apiModeResponses, HTTPClient: server.Client(), ServerState: true}
+	tools := []tool.Definition{{ID: "core/read", Description: "read"}}
+	stream := func(messages []provider.Message) []provider.StreamEvent {
+		t.Helper()
+		ch, err := p.Stream(context.Background(), provider.CompletionRequest{Model: provider.ModelRef{Model: "gpt-4.1"}, System: "be brief", Messages: messages, Tools: tools})
+		if err != nil {
+			t.Fatalf("stream: %v", err)
+		}
+		var out []provider.StreamEvent
+		for event := range ch {
+			if event.Err != nil {
+				t.Fatalf("event error: %v", event.Err)
+			}
+			out = append(out, event)
+		}
+		return out
+	}
+
+	history := []provider.Message{{Role: "user", Content: "read a.txt"}}
+	first := stream(history)
+	history = append(history,
+		provider.Message{Role: "assistant", ToolCalls: []tool.Call{first[0].ToolCall}},
+		provider.Message{Role: "tool", Content: "contents", ToolCallID: "call_1", ToolName: "core/read"},
+	)
+	stream(history)
+	if got := bodies[1]["previous_response_id"]; got != "resp_1" {
+		t.Fatalf("expected previous_response_id resp_1, got %v", got)
+	}
+	if input := bodies[1]["input"].([]any); len(input) != 1 || input[0].(map[string]any)["type"] != "function_call_output" {
+		t.Fatalf("expected only the tool output to be sent, got %v", input)
+	}
+	if bodies[0]["prompt_cache_key"] == "" || bodies[0]["prompt_cache_key"] != bodies[1]["prompt_cache_key"] {
+		t.Fatalf("expected a stable prompt_cache_key, got %v and %v", bodies[0]["prompt_cache_key"], bodies[1]["prompt_cache_key"])
+	}
+
+	// An edited history no longer matches, so it is sent in full.
+	edited := append([]provider.Message{{Role: "user", Content: "read b.txt"}}, history[1:]...)
+	stream(edited)
+	if bodies[2]["previous_response_id"] != nil || len(bodies[2]["input"].([]any)) != 3 {
+		t.Fatalf("expected the full edited history, got %v", bodies[2])
+	}
+
+	// A response the server no longer holds is retried with the full history.
+	forget = true
+	history = append(history, provider.Message{Role: "assistant", Content: "done"}, provider.Message{Role: "user", Content: "again"})
+	if events := stream(history); len(events) == 0 {
+		t.Fatal("expected a response after the retry")
+	}
+	if len(bodies) != 5 || bodies[3]["previous_response_id"] != "resp_2" {
+		t.Fatalf("expected a continuation of resp_2 then a retry, got %d requests", len(bodies))
+	}
+	last := bodies[4]
+	if last["previous_response_id"] != nil || len(last["input"].([]any)) != 5 {
+		t.Fatalf("expected a full resend after the missing response, got %v", last)
+	}
+}
+
+func TestProviderValidateChecksTheModelList(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer good" {
+			http.Error(w, `{"error":{"code":"invalid_api_key"}}`, http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/v1/models" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"object":"list","data":[{"id":"gpt-4o"},{"id":"gpt-4o-mini"}]}`)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	good := Provider{BaseURL: server.URL + "/v1", APIKey: "good"}
+	if err := good.Validate(ctx, "gpt-4o-mini"); err != nil {
+		t.Fatalf("expected a listed model to pass, got %v", err)
+	}
+	for _, tc := range []struct {
+		provider Provider
+		model    string
+		kind     provider.PreflightKind
+	}{
+		{Provider{BaseURL: server.URL + "/v1", APIKey: "bad"}, "gpt-4o", provider.PreflightAuth},
+		// A compatible server may list only some models, or none.
+		{good, "gpt-5-imaginary", provider.PreflightUnverified},
+		{Provider{BaseURL: server.URL + "/wrong", APIKey: "good"}, "gpt-4o", provider.PreflightUnverified},
+	} {
+		var preflight *provider.PreflightError
+		if err := provider.Preflight(ctx, tc.provider, tc.model); !errors.As(err, &preflight) || preflight.Kind != tc.kind {
+			t.Fatalf("%s at %s: expected a %s failure, got %v", tc.model, tc.provider.BaseURL, tc.kind, err)
+		}
+	}
+
+	// OpenAI's own listing is complete, so an unlisted model fails.
+	target, _ := url.Parse(server.URL)
+	official := Provider{BaseURL: "https://api.openai.com/v1", APIKey: "good", HTTPClient: &http.Client{Transport: rewriteHost{target}}}
+	var preflight *provider.PreflightError
+	if err := provider.Preflight(ctx, official, "gpt-5-imaginary"); !errors.As(err, &preflight) || preflight.Kind != provider.PreflightModelNotFound {
+		t.Fatalf("expected an unlisted model to fail at api.openai.com, got %v", err)
+	}
+}
+
+// rewriteHost sends every request to target instead.
+type rewriteHost struct{ target *url.URL }
+
+func (r rewriteHost) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = r.target.Scheme, r.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// longChatStream is a reply of deltas text chunks followed by calls tool
+// calls whose arguments arrive in 20 pieces each.
+func longChatStream(deltas, calls int) []byte {
+	var b strings.Builder
+	write := func(chunk string) { fmt.Fprintf(&b, "data: %s\n\n", chunk) }
+	for i := 0; i < deltas; i++ {
+		write(`{"choices":[{"delta":{"content":"a few more words of the answer "}}]}`)
+	}
+	for i := 0; i < calls; i++ {
+		write(fmt.Sprintf(`{"choices":[{"delta":{"tool_calls":[{"index":%d,"id":"call_%d","function":{"name":"core_read","arguments":"{\"path\": \""}}]}}]}`, i, i))
+		for j := 0; j < 18; j++ {
+			write(fmt.Sprintf(`{"choices":[{"delta":{"tool_calls":[{"index":%d,"function":{"arguments":"dir/"}}]}}]}`, i))
+		}
+		write(fmt.Sprintf(`{"choices":[{"delta":{"tool_calls":[{"index":%d,"function":{"arguments":"main.go\"}"}}]}}]}`, i))
+	}
+	write(`{"choices":[],"usage":{"prompt_tokens":1200,"completion_tokens":4000,"total_tokens":5200}}`)
+	b.WriteString("data: [DONE]\n\n")
+	return []byte(b.String())
+}
+
+func BenchmarkReadChatStreamLongReply(b *testing.B) {
+	body := longChatStream(4000, 50)
+	nameMap := buildToolNameMap([]tool.Definition{{ID: "core/read"}})
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	for b.Loop() {
+		ch := make(chan provider.StreamEvent, 64)
+		go func() {
+			for range ch {
+			}
+		}()
+		if err := readChatStream(bytes.NewReader(body), nameMap, ch); err != nil {
+			b.Fatal(err)
+		}
+		close(ch)
+	}
+}
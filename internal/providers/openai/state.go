@@ -0,0 +1,112 @@
+package openai
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// maxChainedResponses bounds how many response IDs are remembered for
+// previous_response_id.
+const maxChainedResponses = 256
+
+// responseChain remembers responses the server keeps state for, keyed by
+// a hash of the input items they cover (the request input followed by the
+// response output as the runtime sends it back). A later request whose
+// input starts with those items continues from the response and sends only
+// the items after them.
+type responseChain struct {
+	mu    sync.Mutex
+	ids   map[string]string
+	order []string
+}
+
+// chains is shared by every Provider value; the hashes include the base URL
+// and model, so conversations on different endpoints never match.
+var chains = &responseChain{ids: map[string]string{}}
+
+// find returns the response covering the longest prefix of the input whose
+// running hashes are given, and the number of items it covers. It never
+// covers the whole input: a continuation has at least one new item.
+func (c *responseChain) find(hashes []string) (string, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for n := len(hashes) - 1; n > 0; n-- {
+		if id, ok := c.ids[hashes[n-1]]; ok {
+			return id, n
+		}
+	}
+	return "", 0
+}
+
+func (c *responseChain) remember(hash, id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.ids[hash]; !ok {
+		c.order = append(c.order, hash)
+	}
+	c.ids[hash] = id
+	for len(c.order) > maxChainedResponses {
+		delete(c.ids, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// inputHashes returns the running hash after each item, continuing from
+// seed.
+func inputHashes(seed string, items []any) []string {
+	hashes := make([]string, 0, len(items))
+	prev := seed
+	for _, item := range items {
+		h := sha256.New()
+		h.Write([]byte(prev))
+		data, _ := json.Marshal(item)
+		h.Write(data)
+		prev = hex.EncodeToString(h.Sum(nil))
+		hashes = append(hashes, prev)
+	}
+	return hashes
+}
+
+// chainSeed starts the running hash for a conversation with model on the
+// provider's endpoint and key.
+func (p Provider) chainSeed(model string) string {
+	sum := sha256.Sum256([]byte(p.BaseURL + "\x00" + p.APIKey + "\x00" + model))
+	return hex.EncodeToString(sum[:])
+}
+
+// outputItems returns the input items the runtime will send back for a
+// response: the assistant message it builds from the streamed events, as
+// toResponsesInput encodes it.
+func outputItems(text string, calls []tool.Call, raw []provider.RawContent) []any {
+	message := provider.Message{Role: "assistant", Content: text, ToolCalls: calls, Raw: raw}
+	if message.Content == "" && len(message.ToolCalls) == 0 && len(message.Raw) == 0 {
+		return nil
+	}
+	return toResponsesInput([]provider.Message{message})
+}
+
+// promptCacheKey returns the configured key, or one derived from the
+// instructions and tools so requests sharing a prefix share a cache.
+func (p Provider) promptCacheKey(req provider.CompletionRequest) string {
+	if key := strings.TrimSpace(p.PromptCacheKey); key != "" {
+		return key
+	}
+	h := sha256.New()
+	h.Write([]byte(req.System))
+	for _, def := range req.Tools {
+		h.Write([]byte("\x00" + def.ID))
+	}
+	return "agent-" + hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// isMissingPreviousResponse reports whether the server no longer has the
+// response a request continued from.
+func isMissingPreviousResponse(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "previous_response")
+}
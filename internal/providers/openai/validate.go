@@ -0,0 +1,91 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+)
+
+// Validate checks the key, base URL, and model by listing the endpoint's
+// models, which costs no tokens and which OpenAI-compatible servers
+// commonly support. A server that lists no models passes for any model;
+// on a compatible server, a missing listing or an unlisted model is
+// PreflightUnverified rather than a failure.
+func (p Provider) Validate(ctx context.Context, model string) error {
+	fail := func(kind provider.PreflightKind, err error) error {
+		return &provider.PreflightError{Provider: p.Name(), Model: model, Kind: kind, Err: err}
+	}
+	if strings.TrimSpace(p.BaseURL) == "" {
+		return fail(provider.PreflightNetwork, errors.New("base URL is required"))
+	}
+	if strings.TrimSpace(p.APIKey) == "" {
+		return fail(provider.PreflightAuth, errors.New("API key is required"))
+	}
+	httpClient := p.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(p.BaseURL, "/")+"/models", nil)
+	if err != nil {
+		return fail(provider.PreflightNetwork, err)
+	}
+	p.setHeaders(httpReq)
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return fail(provider.PreflightNetwork, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		kind := provider.PreflightStatus(resp.StatusCode)
+		if kind == provider.PreflightModelNotFound {
+			// The listing itself is missing: the base URL is wrong, or a
+			// compatible server does not list its models.
+			kind = provider.PreflightNetwork
+			if p.compatible() {
+				kind = provider.PreflightUnverified
+			}
+		}
+		return fail(kind, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body))))
+	}
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return fail(provider.PreflightOther, fmt.Errorf("decode model list: %w", err))
+	}
+	if len(list.Data) == 0 {
+		return nil
+	}
+	for _, m := range list.Data {
+		if m.ID == model {
+			return nil
+		}
+	}
+	if p.compatible() {
+		return fail(provider.PreflightUnverified, fmt.Errorf("not among the %d models the server lists", len(list.Data)))
+	}
+	return fail(provider.PreflightModelNotFound, fmt.Errorf("not among the %d models listed", len(list.Data)))
+}
+
+// listingHosts are the APIs, OpenAI's and those of the providers built on
+// this one, whose model listing is complete.
+var listingHosts = []string{"api.openai.com", "api.groq.com", "api.cerebras.ai", "api.deepseek.com"}
+
+// compatible reports whether BaseURL is some other compatible server,
+// whose model listing may be missing or incomplete.
+func (p Provider) compatible() bool {
+	u, err := url.Parse(p.BaseURL)
+	return err != nil || !slices.Contains(listingHosts, u.Hostname())
+}
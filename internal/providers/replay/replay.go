@@ -0,0 +1,346 @@
+// Package replay records provider conversations to a file and plays them
+// back, for reproducible bug reports, offline demos, and deterministic
+// tests of agent behavior without calling a model API.
+//
+// A recording is JSON Lines: one Interaction per completion request, in
+// the order the requests were made. Requests are matched on playback by a
+// hash of everything sent to the model.
+package replay
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// Interaction is one recorded completion request and the stream it produced.
+type Interaction struct {
+	Seq      int         `json:"seq"`
+	Hash     string      `json:"hash"`
+	Provider string      `json:"provider"`
+	Model    string      `json:"model"`
+	Prompt   string      `json:"prompt,omitempty"` // last message, for reading the file
+	Events   []Event     `json:"events"`
+	Error    string      `json:"error,omitempty"` // Stream itself failed
+	At       time.Time   `json:"at"`
+	Request  *requestKey `json:"request,omitempty"`
+}
+
+// Event is a serialized provider.StreamEvent.
+type Event struct {
+	Type             provider.StreamEventType `json:"type"`
+	Text             string                   `json:"text,omitempty"`
+	ToolCall         *Call                    `json:"toolCall,omitempty"`
+	Error            string                   `json:"error,omitempty"`
+	InputTokens      int                      `json:"inputTokens,omitempty"`
+	OutputTokens     int                      `json:"outputTokens,omitempty"`
+	ReasoningTokens  int                      `json:"reasoningTokens,omitempty"`
+	CacheReadTokens  int                      `json:"cacheReadTokens,omitempty"`
+	CacheWriteTokens int                      `json:"cacheWriteTokens,omitempty"`
+	Raw              *provider.RawContent     `json:"raw,omitempty"`
+}
+
+// Call is a serialized tool.Call.
+type Call struct {
+	ID        string         `json:"id"`
+	Tool      string         `json:"tool"`
+	Arguments map[string]any `json:"arguments,omitempty"`
+}
+
+// requestKey is what a request hash covers.
+type requestKey struct {
+	Provider string             `json:"provider"`
+	Model    string             `json:"model"`
+	System   string             `json:"system,omitempty"`
+	Messages []provider.Message `json:"messages"`
+	Tools    []tool.Definition  `json:"tools,omitempty"`
+}
+
+// Hash identifies a completion request sent to providerName.
+func Hash(providerName string, req provider.CompletionRequest) string {
+	return hashKey(newKey(providerName, req))
+}
+
+func newKey(providerName string, req provider.CompletionRequest) requestKey {
+	return requestKey{Provider: providerName, Model: req.Model.Model, System: req.System, Messages: req.Messages, Tools: req.Tools}
+}
+
+func hashKey(key requestKey) string {
+	data, _ := json.Marshal(key)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:12])
+}
+
+// Recorder appends interactions to a recording file. One Recorder is
+// shared by every provider it wraps, so a run that spans several providers
+// produces a single file.
+type Recorder struct {
+	Path string
+	// Full stores the whole request in each interaction so a mismatch on
+	// playback can be diagnosed; without it only the last message is kept.
+	Full bool
+
+	mu  sync.Mutex
+	seq int
+}
+
+// NewRecorder records to path, replacing any earlier recording there.
+func NewRecorder(path string) (*Recorder, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		return nil, err
+	}
+	return &Recorder{Path: path}, nil
+}
+
+// Wrap returns a provider that forwards to inner and records its traffic.
+func (r *Recorder) Wrap(inner provider.Provider) *RecordingProvider {
+	return &RecordingProvider{Inner: inner, Recorder: r}
+}
+
+func (r *Recorder) append(interaction Interaction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	interaction.Seq = r.seq
+	data, err := json.Marshal(interaction)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(r.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// RecordingProvider forwards requests to Inner and records each request
+// and the events it streamed.
+type RecordingProvider struct {
+	Inner    provider.Provider
+	Recorder *Recorder
+}
+
+func (p *RecordingProvider) Name() string { return p.Inner.Name() }
+
+// SupportsPrefill defers to the wrapped provider.
+func (p *RecordingProvider) SupportsPrefill(model string) bool {
+	prefiller, ok := p.Inner.(provider.Prefiller)
+	return ok && prefiller.SupportsPrefill(model)
+}
+
+// SupportsVision defers to the wrapped provider.
+func (p *RecordingProvider) SupportsVision(model string) bool {
+	vision, ok := p.Inner.(provider.VisionSupporter)
+	return ok && vision.SupportsVision(model)
+}
+
+// ValidToolCallID defers to the wrapped provider, which accepts any
+// non-empty ID when it does not check them.
+func (p *RecordingProvider) ValidToolCallID(id string) bool {
+	if checker, ok := p.Inner.(provider.ToolCallIDChecker); ok {
+		return checker.ValidToolCallID(id)
+	}
+	return id != ""
+}
+
+// Validate defers to the wrapped provider.
+func (p *RecordingProvider) Validate(ctx context.Context, model string) error {
+	return provider.Preflight(ctx, p.Inner, model)
+}
+
+func (p *RecordingProvider) Stream(ctx context.Context, req provider.CompletionRequest) (<-chan provider.StreamEvent, error) {
+	key := newKey(p.Inner.Name(), req)
+	interaction := Interaction{Hash: hashKey(key), Provider: key.Provider, Model: key.Model, Prompt: lastContent(req.Messages), At: time.Now()}
+	if p.Recorder.Full {
+		interaction.Request = &key
+	}
+	inner, err := p.Inner.Stream(ctx, req)
+	if err != nil {
+		interaction.Error = err.Error()
+		return nil, errors.Join(err, p.Recorder.append(interaction))
+	}
+	out := make(chan provider.StreamEvent)
+	go func() {
+		defer close(out)
+		for event := range inner {
+			interaction.Events = append(interaction.Events, encodeEvent(event))
+			select {
+			case out <- event:
+			case <-ctx.Done():
+				// Drain so the inner provider can finish; the partial
+				// stream is still worth recording.
+				for range inner {
+				}
+				_ = p.Recorder.append(interaction)
+				return
+			}
+		}
+		if err := p.Recorder.append(interaction); err != nil {
+			out <- provider.StreamEvent{Err: fmt.Errorf("record provider response: %w", err)}
+		}
+	}()
+	return out, nil
+}
+
+// Recording is a loaded recording file. Each interaction is served once.
+type Recording struct {
+	// Strict fails a request whose hash matches no unplayed interaction.
+	// Otherwise the provider's next unplayed interaction in recorded order
+	// is served, which tolerates tool output that differs between runs
+	// (timestamps, temp paths).
+	Strict bool
+
+	mu           sync.Mutex
+	interactions []Interaction
+	played       []bool
+}
+
+// Load reads a recording written by a Recorder.
+func Load(path string) (*Recording, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	r := &Recording{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var interaction Interaction
+		if err := json.Unmarshal(scanner.Bytes(), &interaction); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		r.interactions = append(r.interactions, interaction)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	r.played = make([]bool, len(r.interactions))
+	return r, nil
+}
+
+// Providers returns the names of the providers the recording was made
+// with, in order of first use.
+func (r *Recording) Providers() []string {
+	var names []string
+	for _, interaction := range r.interactions {
+		if !slices.Contains(names, interaction.Provider) {
+			names = append(names, interaction.Provider)
+		}
+	}
+	return names
+}
+
+// Provider returns a provider named name that serves the recording.
+func (r *Recording) Provider(name string) *ReplayProvider {
+	return &ReplayProvider{name: name, recording: r}
+}
+
+func (r *Recording) next(providerName, hash string) (Interaction, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fallback := -1
+	for i, interaction := range r.interactions {
+		if r.played[i] || interaction.Provider != providerName {
+			continue
+		}
+		if interaction.Hash == hash {
+			r.played[i] = true
+			return interaction, nil
+		}
+		if fallback < 0 {
+			fallback = i
+		}
+	}
+	if r.Strict || fallback < 0 {
+		return Interaction{}, fmt.Errorf("replay: no recorded %s response for request %s", providerName, hash)
+	}
+	r.played[fallback] = true
+	return r.interactions[fallback], nil
+}
+
+// ReplayProvider serves recorded interactions instead of calling a model.
+type ReplayProvider struct {
+	name      string
+	recording *Recording
+}
+
+func (p *ReplayProvider) Name() string { return p.name }
+
+func (p *ReplayProvider) Stream(_ context.Context, req provider.CompletionRequest) (<-chan provider.StreamEvent, error) {
+	interaction, err := p.recording.next(p.name, Hash(p.name, req))
+	if err != nil {
+		return nil, err
+	}
+	if interaction.Error != "" {
+		return nil, errors.New(interaction.Error)
+	}
+	out := make(chan provider.StreamEvent, len(interaction.Events))
+	for _, event := range interaction.Events {
+		out <- decodeEvent(event)
+	}
+	close(out)
+	return out, nil
+}
+
+func encodeEvent(event provider.StreamEvent) Event {
+	out := Event{Type: event.Type, Text: event.Text, InputTokens: event.InputTokens, OutputTokens: event.OutputTokens, ReasoningTokens: event.ReasoningTokens, CacheReadTokens: event.CacheReadTokens, CacheWriteTokens: event.CacheWriteTokens}
+	if event.Type == provider.StreamEventToolCall || event.Type == provider.StreamEventToolCallDelta {
+		out.ToolCall = &Call{ID: event.ToolCall.ID, Tool: event.ToolCall.ToolID, Arguments: event.ToolCall.Arguments}
+	}
+	if event.Type == provider.StreamEventRaw {
+		raw := event.Raw
+		out.Raw = &raw
+	}
+	if event.Err != nil {
+		out.Error = event.Err.Error()
+	}
+	return out
+}
+
+func decodeEvent(event Event) provider.StreamEvent {
+	out := provider.StreamEvent{Type: event.Type, Text: event.Text, InputTokens: event.InputTokens, OutputTokens: event.OutputTokens, ReasoningTokens: event.ReasoningTokens, CacheReadTokens: event.CacheReadTokens, CacheWriteTokens: event.CacheWriteTokens}
+	if event.ToolCall != nil {
+		out.ToolCall = tool.Call{ID: event.ToolCall.ID, ToolID: event.ToolCall.Tool, Arguments: event.ToolCall.Arguments}
+	}
+	if event.Raw != nil {
+		out.Raw = *event.Raw
+	}
+	if event.Error != "" {
+		out.Err = errors.New(event.Error)
+	}
+	return out
+}
+
+func lastContent(messages []provider.Message) string {
+	if len(messages) == 0 {
+		return ""
+	}
+	content := messages[len(messages)-1].Content
+	if len(content) > 200 {
+		content = content[:200] + "…"
+	}
+	return content
+}
@@ -0,0 +1,84 @@
+package replay
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bitop-dev/agent/internal/providers/mock"
+	"github.com/bitop-dev/agent/pkg/provider"
+)
+
+func collect(t *testing.T, p provider.Provider, req provider.CompletionRequest) []provider.StreamEvent {
+	t.Helper()
+	ch, err := p.Stream(context.Background(), req)
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	var out []provider.StreamEvent
+	for event := range ch {
+		out = append(out, event)
+	}
+	return out
+}
+
+func request(prompt string) provider.CompletionRequest {
+	return provider.CompletionRequest{
+		Model:    provider.ModelRef{Provider: "mock", Model: "m"},
+		System:   "be brief",
+		Messages: []provider.Message{{Role: "user", Content: prompt}},
+	}
+}
+
+func TestRecordThenReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.jsonl")
+	recorder, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recording := recorder.Wrap(mock.Provider{})
+	hello := collect(t, recording, request("hello"))
+	readCall := collect(t, recording, request("read notes.txt"))
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := loaded.Providers(); !reflect.DeepEqual(got, []string{"mock"}) {
+		t.Fatalf("unexpected providers: %v", got)
+	}
+	loaded.Strict = true
+	player := loaded.Provider("mock")
+	// Requests are matched by hash, not by order.
+	if got := collect(t, player, request("read notes.txt")); !reflect.DeepEqual(got, readCall) {
+		t.Fatalf("replayed tool call differs:\n got %+v\nwant %+v", got, readCall)
+	}
+	if got := collect(t, player, request("hello")); !reflect.DeepEqual(got, hello) {
+		t.Fatalf("replayed text differs:\n got %+v\nwant %+v", got, hello)
+	}
+	if _, err := player.Stream(context.Background(), request("hello")); err == nil {
+		t.Fatal("expected an exhausted recording to fail in strict mode")
+	}
+}
+
+func TestReplayFallsBackToRecordedOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.jsonl")
+	recorder, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := collect(t, recorder.Wrap(mock.Provider{}), request("hello"))
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	player := loaded.Provider("mock")
+	if got := collect(t, player, request("something else")); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected the next recorded response, got %+v", got)
+	}
+	if _, err := loaded.Provider("openai").Stream(context.Background(), request("hello")); err == nil {
+		t.Fatal("expected no response for a provider absent from the recording")
+	}
+}
@@ -0,0 +1,81 @@
+// Package voyage implements the Voyage AI embeddings API.
+package voyage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+)
+
+type Embedder struct {
+	APIKey     string
+	BaseURL    string // default: https://api.voyageai.com/v1
+	HTTPClient *http.Client
+	// Headers and Query are added to every request.
+	Headers map[string]string
+	Query   map[string]string
+}
+
+func (e Embedder) Embed(ctx context.Context, req provider.EmbeddingRequest) ([][]float32, error) {
+	if strings.TrimSpace(e.APIKey) == "" {
+		return nil, fmt.Errorf("voyage embedder: API key is required")
+	}
+	baseURL := e.BaseURL
+	if baseURL == "" {
+		baseURL = "https://api.voyageai.com/v1"
+	}
+	model := req.Model
+	if model == "" {
+		model = "voyage-code-3"
+	}
+	data, err := json.Marshal(map[string]any{"model": model, "input": req.Inputs})
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(baseURL, "/")+"/embeddings", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+e.APIKey)
+	httpReq.Header.Set("Content-Type", "application/json")
+	provider.ApplyExtras(httpReq, e.Headers, e.Query)
+	client := e.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 60 * time.Second}
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("voyage embedder: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("voyage embedder request failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var parsed struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, fmt.Errorf("voyage embedder: decode response: %w", err)
+	}
+	out := make([][]float32, len(req.Inputs))
+	for _, item := range parsed.Data {
+		if item.Index >= 0 && item.Index < len(out) {
+			out[item.Index] = item.Embedding
+		}
+	}
+	return out, nil
+}
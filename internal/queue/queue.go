@@ -0,0 +1,95 @@
+// Package queue provides message queues that feed steering and follow-up
+// messages into a running agent. The in-memory queue serves embedders that
+// steer a run from the same process; the Redis queue lets an operator
+// dashboard or another service steer a headless agent on a different host.
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+)
+
+// Queue is a MessageQueue that also accepts new messages.
+type Queue interface {
+	pkgruntime.MessageQueue
+	Push(ctx context.Context, content string) (pkgruntime.QueuedMessage, error)
+	Close() error
+}
+
+// Open returns the queue described by rawURL. Supported forms:
+//
+//	memory://
+//	redis://[:password@]host:port[/db]?key=agent:steering
+func Open(rawURL string) (Queue, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("queue url: %w", err)
+	}
+	switch u.Scheme {
+	case "memory":
+		return NewMemory(), nil
+	case "redis", "rediss":
+		return OpenRedis(u)
+	default:
+		return nil, fmt.Errorf("unsupported queue scheme %q (expected memory or redis)", u.Scheme)
+	}
+}
+
+// Memory is an in-process queue. Received messages stay in flight until they
+// are acknowledged; unacknowledged messages are returned again on the next
+// Receive, ahead of newer ones.
+type Memory struct {
+	mu       sync.Mutex
+	pending  []pkgruntime.QueuedMessage
+	inflight []pkgruntime.QueuedMessage
+}
+
+func NewMemory() *Memory { return &Memory{} }
+
+func (q *Memory) Push(_ context.Context, content string) (pkgruntime.QueuedMessage, error) {
+	msg := pkgruntime.QueuedMessage{ID: newID(), Content: content, CreatedAt: time.Now().UTC()}
+	q.mu.Lock()
+	q.pending = append(q.pending, msg)
+	q.mu.Unlock()
+	return msg, nil
+}
+
+func (q *Memory) Receive(context.Context) ([]pkgruntime.QueuedMessage, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.inflight = append(q.inflight, q.pending...)
+	q.pending = nil
+	return append([]pkgruntime.QueuedMessage(nil), q.inflight...), nil
+}
+
+func (q *Memory) Ack(_ context.Context, ids ...string) error {
+	done := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		done[id] = true
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	kept := q.inflight[:0]
+	for _, msg := range q.inflight {
+		if !done[msg.ID] {
+			kept = append(kept, msg)
+		}
+	}
+	q.inflight = kept
+	return nil
+}
+
+func (q *Memory) Close() error { return nil }
+
+func newID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(b[:])
+}
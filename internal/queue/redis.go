@@ -0,0 +1,275 @@
+package queue
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+)
+
+// maxReceive bounds how many messages a single Receive moves in flight.
+const maxReceive = 100
+
+// Redis is a queue backed by a Redis list. Producers RPUSH JSON-encoded
+// messages onto Key; the consumer atomically LMOVEs them onto Key+":processing"
+// and LREMs them there on Ack. Messages left in the processing list by a
+// consumer that crashed are moved back to the head of the queue the first
+// time a new consumer receives, so delivery is at-least-once and ordered.
+//
+// Each key is intended for a single consuming run. Requires Redis 6.2+.
+type Redis struct {
+	Addr     string
+	Password string
+	DB       int
+	Key      string
+	TLS      bool
+
+	mu        sync.Mutex
+	conn      net.Conn
+	reader    *bufio.Reader
+	recovered bool
+	raw       map[string]string // in-flight message ID → stored payload
+}
+
+// OpenRedis builds a Redis queue from a redis:// or rediss:// URL. The list
+// key defaults to "agent:steering".
+func OpenRedis(u *url.URL) (*Redis, error) {
+	q := &Redis{Addr: u.Host, Key: u.Query().Get("key"), TLS: u.Scheme == "rediss", raw: map[string]string{}}
+	if q.Addr == "" {
+		q.Addr = "localhost:6379"
+	}
+	if _, _, err := net.SplitHostPort(q.Addr); err != nil {
+		q.Addr = net.JoinHostPort(q.Addr, "6379")
+	}
+	if q.Key == "" {
+		q.Key = "agent:steering"
+	}
+	if u.User != nil {
+		q.Password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		n, err := strconv.Atoi(db)
+		if err != nil {
+			return nil, fmt.Errorf("redis db %q: %w", db, err)
+		}
+		q.DB = n
+	}
+	return q, nil
+}
+
+func (q *Redis) processingKey() string { return q.Key + ":processing" }
+
+func (q *Redis) Push(ctx context.Context, content string) (pkgruntime.QueuedMessage, error) {
+	msg := pkgruntime.QueuedMessage{ID: newID(), Content: content, CreatedAt: time.Now().UTC()}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return msg, err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, err := q.do(ctx, "RPUSH", q.Key, string(payload)); err != nil {
+		return msg, err
+	}
+	return msg, nil
+}
+
+func (q *Redis) Receive(ctx context.Context) ([]pkgruntime.QueuedMessage, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.recovered {
+		// Requeue anything a previous consumer took but never acknowledged,
+		// preserving order: the newest in-flight item goes back first.
+		for {
+			reply, err := q.do(ctx, "LMOVE", q.processingKey(), q.Key, "RIGHT", "LEFT")
+			if err != nil {
+				return nil, err
+			}
+			if reply == nil {
+				break
+			}
+		}
+		q.recovered = true
+	}
+	var out []pkgruntime.QueuedMessage
+	for len(out) < maxReceive {
+		reply, err := q.do(ctx, "LMOVE", q.Key, q.processingKey(), "LEFT", "RIGHT")
+		if err != nil {
+			return out, err
+		}
+		payload, ok := reply.(string)
+		if !ok {
+			break
+		}
+		var msg pkgruntime.QueuedMessage
+		if err := json.Unmarshal([]byte(payload), &msg); err != nil || msg.ID == "" {
+			// Accept plain-text pushes from tools like redis-cli.
+			msg = pkgruntime.QueuedMessage{ID: newID(), Content: payload, CreatedAt: time.Now().UTC()}
+		}
+		q.raw[msg.ID] = payload
+		out = append(out, msg)
+	}
+	return out, nil
+}
+
+func (q *Redis) Ack(ctx context.Context, ids ...string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, id := range ids {
+		payload, ok := q.raw[id]
+		if !ok {
+			continue
+		}
+		if _, err := q.do(ctx, "LREM", q.processingKey(), "1", payload); err != nil {
+			return err
+		}
+		delete(q.raw, id)
+	}
+	return nil
+}
+
+func (q *Redis) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.conn == nil {
+		return nil
+	}
+	err := q.conn.Close()
+	q.conn, q.reader = nil, nil
+	return err
+}
+
+// do sends one command, reconnecting once if the connection was dropped.
+// Callers must hold q.mu.
+func (q *Redis) do(ctx context.Context, args ...string) (any, error) {
+	for attempt := 0; ; attempt++ {
+		if q.conn == nil {
+			if err := q.dial(ctx); err != nil {
+				return nil, err
+			}
+		}
+		reply, err := q.roundTrip(ctx, args)
+		var redisErr redisError
+		if err == nil || errors.As(err, &redisErr) || attempt > 0 {
+			return reply, err
+		}
+		q.dropConn()
+	}
+}
+
+func (q *Redis) dropConn() {
+	if q.conn != nil {
+		q.conn.Close()
+	}
+	q.conn, q.reader = nil, nil
+}
+
+func (q *Redis) dial(ctx context.Context) error {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	var conn net.Conn
+	var err error
+	if q.TLS {
+		host, _, _ := net.SplitHostPort(q.Addr)
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", q.Addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", q.Addr)
+	}
+	if err != nil {
+		return fmt.Errorf("redis dial %s: %w", q.Addr, err)
+	}
+	q.conn, q.reader = conn, bufio.NewReader(conn)
+	if q.Password != "" {
+		if _, err := q.roundTrip(ctx, []string{"AUTH", q.Password}); err != nil {
+			q.dropConn()
+			return fmt.Errorf("redis auth: %w", err)
+		}
+	}
+	if q.DB != 0 {
+		if _, err := q.roundTrip(ctx, []string{"SELECT", strconv.Itoa(q.DB)}); err != nil {
+			q.dropConn()
+			return fmt.Errorf("redis select: %w", err)
+		}
+	}
+	return nil
+}
+
+func (q *Redis) roundTrip(ctx context.Context, args []string) (any, error) {
+	deadline := time.Now().Add(10 * time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = q.conn.SetDeadline(deadline)
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(q.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return readReply(q.reader)
+}
+
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// readReply parses one RESP2 reply. Nil bulk strings and arrays become nil.
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
@@ -0,0 +1,142 @@
+package queue
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRedis implements the handful of list commands the queue uses.
+type fakeRedis struct {
+	mu    sync.Mutex
+	lists map[string][]string
+}
+
+func startFakeRedis(t *testing.T) (*fakeRedis, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	srv := &fakeRedis{lists: map[string][]string{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(conn)
+		}
+	}()
+	return srv, ln.Addr().String()
+}
+
+func (s *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		reply, err := readReply(r)
+		if err != nil {
+			return
+		}
+		items, _ := reply.([]any)
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i], _ = item.(string)
+		}
+		fmt.Fprint(conn, s.exec(args))
+	}
+}
+
+func (s *fakeRedis) exec(args []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "RPUSH":
+		s.lists[args[1]] = append(s.lists[args[1]], args[2:]...)
+		return ":" + strconv.Itoa(len(s.lists[args[1]])) + "\r\n"
+	case "LMOVE":
+		src := s.lists[args[1]]
+		if len(src) == 0 {
+			return "$-1\r\n"
+		}
+		var value string
+		if args[3] == "LEFT" {
+			value, s.lists[args[1]] = src[0], src[1:]
+		} else {
+			value, s.lists[args[1]] = src[len(src)-1], src[:len(src)-1]
+		}
+		if args[4] == "LEFT" {
+			s.lists[args[2]] = append([]string{value}, s.lists[args[2]]...)
+		} else {
+			s.lists[args[2]] = append(s.lists[args[2]], value)
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+	case "LREM":
+		list := s.lists[args[1]]
+		for i, v := range list {
+			if v == args[3] {
+				s.lists[args[1]] = append(list[:i:i], list[i+1:]...)
+				return ":1\r\n"
+			}
+		}
+		return ":0\r\n"
+	default:
+		return "-ERR unknown command\r\n"
+	}
+}
+
+func TestRedisQueueRedeliversUnacknowledged(t *testing.T) {
+	srv, addr := startFakeRedis(t)
+	u, _ := url.Parse("redis://" + addr + "?key=steer")
+	ctx := context.Background()
+
+	producer, err := OpenRedis(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer producer.Close()
+	for _, text := range []string{"one", "two"} {
+		if _, err := producer.Push(ctx, text); err != nil {
+			t.Fatalf("push: %v", err)
+		}
+	}
+
+	// A consumer that receives but crashes before acknowledging.
+	crashed, _ := OpenRedis(u)
+	got, err := crashed.Receive(ctx)
+	if err != nil || len(got) != 2 {
+		t.Fatalf("receive = %v, %v", got, err)
+	}
+	crashed.Close()
+	producer.Push(ctx, "three")
+
+	consumer, _ := OpenRedis(u)
+	defer consumer.Close()
+	got, err = consumer.Receive(ctx)
+	if err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	var contents []string
+	for _, msg := range got {
+		contents = append(contents, msg.Content)
+	}
+	if strings.Join(contents, ",") != "one,two,three" {
+		t.Fatalf("unexpected order after redelivery: %v", contents)
+	}
+	if err := consumer.Ack(ctx, got[0].ID, got[1].ID, got[2].ID); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if n := len(srv.lists["steer"]) + len(srv.lists["steer:processing"]); n != 0 {
+		t.Fatalf("%d messages left after ack", n)
+	}
+}
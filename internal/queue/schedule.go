@@ -0,0 +1,343 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+)
+
+// defaultPoll is how often Schedule checks files and commands.
+const defaultPoll = 10 * time.Second
+
+// Trigger decides when a scheduled message comes due: at a time, once a
+// file exists, or once a shell command exits 0. Exactly one is set.
+type Trigger struct {
+	At      time.Time
+	File    string
+	Command string
+	spec    string // as parsed, for Waiting
+}
+
+// ParseTrigger parses one of
+//
+//	after <duration>   after 10m
+//	at <cron>          at 0 9 * * 1-5  (the next matching minute)
+//	at <time>          at 2026-10-16T17:00:00Z
+//	file <path>        file dist/report.xml
+//	command <command>  command gh run watch --exit-status
+func ParseTrigger(spec string, now time.Time) (Trigger, error) {
+	kind, arg, _ := strings.Cut(strings.TrimSpace(spec), " ")
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return Trigger{}, fmt.Errorf("follow-up trigger %q: expected after, at, file, or command and an argument", spec)
+	}
+	trigger := Trigger{spec: kind + " " + arg}
+	switch kind {
+	case "after":
+		d, err := time.ParseDuration(arg)
+		if err != nil {
+			return Trigger{}, fmt.Errorf("follow-up trigger %q: %w", spec, err)
+		}
+		trigger.At = now.Add(d)
+	case "at":
+		if at, err := time.Parse(time.RFC3339, arg); err == nil {
+			trigger.At = at
+			break
+		}
+		cron, err := parseCron(arg)
+		if err != nil {
+			return Trigger{}, fmt.Errorf("follow-up trigger %q: %w", spec, err)
+		}
+		if trigger.At = cron.next(now); trigger.At.IsZero() {
+			return Trigger{}, fmt.Errorf("follow-up trigger %q: no matching time within a year", spec)
+		}
+	case "file":
+		trigger.File = arg
+	case "command":
+		trigger.Command = arg
+	default:
+		return Trigger{}, fmt.Errorf("follow-up trigger %q: expected after, at, file, or command", spec)
+	}
+	return trigger, nil
+}
+
+func (t Trigger) String() string {
+	if !t.At.IsZero() {
+		return fmt.Sprintf("%s (at %s)", t.spec, t.At.Local().Format("2006-01-02 15:04"))
+	}
+	return t.spec
+}
+
+// Schedule is a follow-up queue of messages that come due by Trigger,
+// ahead of those of Queue, which are due at once. A run with nothing else
+// to do waits for them.
+type Schedule struct {
+	Queue pkgruntime.MessageQueue // optional
+	Dir   string                  // where files and commands are resolved
+	Poll  time.Duration           // how often files and commands are checked; 0 is 10s
+
+	mu      sync.Mutex
+	pending []*scheduled
+}
+
+type scheduled struct {
+	msg     pkgruntime.QueuedMessage
+	trigger Trigger
+	due     bool
+	checked time.Time // when Command last ran
+}
+
+// Add schedules content to come due by trigger.
+func (s *Schedule) Add(content string, trigger Trigger) pkgruntime.QueuedMessage {
+	msg := pkgruntime.QueuedMessage{ID: newID(), Content: content, CreatedAt: time.Now().UTC()}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(s.pending, &scheduled{msg: msg, trigger: trigger})
+	return msg
+}
+
+// AddSpec schedules a follow-up written "<trigger>: <message>", such as
+// "command gh run watch: summarize the CI run". The
+// trigger ends at the first ": ".
+func (s *Schedule) AddSpec(spec string) (pkgruntime.QueuedMessage, error) {
+	when, content, ok := strings.Cut(spec, ": ")
+	if !ok || strings.TrimSpace(content) == "" {
+		return pkgruntime.QueuedMessage{}, fmt.Errorf("follow-up %q: expected <trigger>: <message>", spec)
+	}
+	trigger, err := ParseTrigger(when, time.Now())
+	if err != nil {
+		return pkgruntime.QueuedMessage{}, err
+	}
+	return s.Add(strings.TrimSpace(content), trigger), nil
+}
+
+// Receive returns the messages that are due, checking times and files
+// but not commands, which only Wait runs, followed by those of Queue.
+func (s *Schedule) Receive(ctx context.Context) ([]pkgruntime.QueuedMessage, error) {
+	s.mu.Lock()
+	var due []pkgruntime.QueuedMessage
+	for _, item := range s.pending {
+		if !item.due && item.trigger.Command == "" {
+			item.due = s.ready(ctx, item)
+		}
+		if item.due {
+			due = append(due, item.msg)
+		}
+	}
+	s.mu.Unlock()
+	if s.Queue == nil {
+		return due, nil
+	}
+	queued, err := s.Queue.Receive(ctx)
+	return append(due, queued...), err
+}
+
+func (s *Schedule) Ack(ctx context.Context, ids ...string) error {
+	s.mu.Lock()
+	acked := map[string]bool{}
+	kept := s.pending[:0]
+	for _, item := range s.pending {
+		if item.due && slices.Contains(ids, item.msg.ID) {
+			acked[item.msg.ID] = true
+			continue
+		}
+		kept = append(kept, item)
+	}
+	s.pending = kept
+	s.mu.Unlock()
+	var rest []string
+	for _, id := range ids {
+		if !acked[id] {
+			rest = append(rest, id)
+		}
+	}
+	if s.Queue == nil || len(rest) == 0 {
+		return nil
+	}
+	return s.Queue.Ack(ctx, rest...)
+}
+
+// Waiting describes the triggers of the messages not yet due.
+func (s *Schedule) Waiting() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var waiting []string
+	for _, item := range s.pending {
+		if !item.due {
+			waiting = append(waiting, item.trigger.String())
+		}
+	}
+	return waiting
+}
+
+// Wait blocks until a scheduled message is due, running commands at most
+// once per Poll, or until ctx ends. It returns at once when nothing waits.
+func (s *Schedule) Wait(ctx context.Context) error {
+	poll := s.Poll
+	if poll <= 0 {
+		poll = defaultPoll
+	}
+	for {
+		s.mu.Lock()
+		items := append([]*scheduled(nil), s.pending...)
+		for _, item := range items {
+			if item.due {
+				s.mu.Unlock()
+				return nil
+			}
+		}
+		s.mu.Unlock()
+		next, waiting := time.Now().Add(poll), false
+		for _, item := range items {
+			// Commands run unlocked; they may take a while.
+			if item.trigger.Command != "" && time.Since(item.checked) < poll {
+				waiting = true
+				next = earlier(next, item.checked.Add(poll))
+				continue
+			}
+			if s.ready(ctx, item) {
+				s.mu.Lock()
+				item.due = true
+				s.mu.Unlock()
+				return nil
+			}
+			waiting = true
+			if !item.trigger.At.IsZero() {
+				next = earlier(next, item.trigger.At)
+			}
+		}
+		if !waiting {
+			return nil
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func earlier(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+// ready reports whether the trigger of item holds now.
+func (s *Schedule) ready(ctx context.Context, item *scheduled) bool {
+	t := item.trigger
+	switch {
+	case !t.At.IsZero():
+		return !time.Now().Before(t.At)
+	case t.File != "":
+		path := t.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(s.Dir, path)
+		}
+		_, err := os.Stat(path)
+		return err == nil
+	case t.Command != "":
+		item.checked = time.Now()
+		cmd := exec.CommandContext(ctx, "/bin/sh", "-c", t.Command)
+		cmd.Dir = s.Dir
+		return cmd.Run() == nil
+	}
+	return false
+}
+
+// cronSchedule is a five-field cron expression: minute, hour, day of
+// month, month, and day of week, each a set of allowed values.
+type cronSchedule struct {
+	minute, hour, dom, month, dow map[int]bool
+	domAny, dowAny                bool
+}
+
+func parseCron(expr string) (cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return cronSchedule{}, errors.New("expected a time or a cron expression of five fields")
+	}
+	var c cronSchedule
+	var err error
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	sets := [5]*map[int]bool{&c.minute, &c.hour, &c.dom, &c.month, &c.dow}
+	for i, field := range fields {
+		if *sets[i], err = parseCronField(field, bounds[i][0], bounds[i][1]); err != nil {
+			return cronSchedule{}, fmt.Errorf("cron field %q: %w", field, err)
+		}
+	}
+	if c.dow[7] {
+		c.dow[0] = true // Sunday is 0 or 7
+	}
+	c.domAny, c.dowAny = fields[2] == "*", fields[4] == "*"
+	return c, nil
+}
+
+// parseCronField parses a list of *, n, a-b, each optionally /step.
+func parseCronField(field string, lo, hi int) (map[int]bool, error) {
+	set := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+				return nil, fmt.Errorf("bad step %q", stepText)
+			}
+		}
+		from, to := lo, hi
+		if rng != "*" {
+			first, last, isRange := strings.Cut(rng, "-")
+			var err error
+			if from, err = strconv.Atoi(first); err != nil {
+				return nil, fmt.Errorf("bad value %q", first)
+			}
+			to = from
+			if isRange {
+				if to, err = strconv.Atoi(last); err != nil {
+					return nil, fmt.Errorf("bad value %q", last)
+				}
+			} else if hasStep {
+				to = hi
+			}
+		}
+		if from < lo || to > hi || from > to {
+			return nil, fmt.Errorf("out of range %d-%d", lo, hi)
+		}
+		for v := from; v <= to; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+// next returns the first matching minute after now, or the zero time if
+// none comes within a year. As in cron, when both days are restricted a
+// day matching either one matches.
+func (c cronSchedule) next(now time.Time) time.Time {
+	t := now.Truncate(time.Minute).Add(time.Minute)
+	for end := t.AddDate(1, 0, 0); t.Before(end); t = t.Add(time.Minute) {
+		dom, dow := c.dom[t.Day()], c.dow[int(t.Weekday())]
+		day := dom && dow
+		if !c.domAny && !c.dowAny {
+			day = dom || dow
+		}
+		if day && c.month[int(t.Month())] && c.hour[t.Hour()] && c.minute[t.Minute()] {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,84 @@
+package queue
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseTrigger(t *testing.T) {
+	now := time.Date(2026, 10, 16, 14, 30, 20, 0, time.UTC) // a Friday
+	for spec, want := range map[string]time.Time{
+		"after 90m":               now.Add(90 * time.Minute),
+		"at 0 9 * * 1-5":          time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC),
+		"at */15 * * * *":         time.Date(2026, 10, 16, 14, 45, 0, 0, time.UTC),
+		"at 0 0 1 * 0":            time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC), // day 1 or Sunday
+		"at 2026-12-01T08:00:00Z": time.Date(2026, 12, 1, 8, 0, 0, 0, time.UTC),
+	} {
+		trigger, err := ParseTrigger(spec, now)
+		if err != nil || !trigger.At.Equal(want) {
+			t.Errorf("%s: got %v, %v; want %v", spec, trigger.At, err, want)
+		}
+	}
+	for _, spec := range []string{"after", "at 61 * * * *", "at * * *", "when x", "after soon"} {
+		if _, err := ParseTrigger(spec, now); err == nil {
+			t.Errorf("%s: expected an error", spec)
+		}
+	}
+}
+
+func TestScheduleWaitsForFilesAndCommands(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	inner := NewMemory()
+	s := &Schedule{Queue: inner, Dir: dir, Poll: 10 * time.Millisecond}
+	report, err := s.AddSpec("file out/report.xml: summarize the report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddSpec("command test -f done: say done"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddSpec("no colon here"); err == nil {
+		t.Fatal("expected an error for a spec without a message")
+	}
+	queued, _ := inner.Push(ctx, "queued at once")
+	if got, _ := s.Receive(ctx); len(got) != 1 || got[0].ID != queued.ID {
+		t.Fatalf("expected only the inner queue's message due, got %+v", got)
+	}
+	if err := s.Ack(ctx, queued.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		_ = os.MkdirAll(filepath.Join(dir, "out"), 0o755)
+		_ = os.WriteFile(filepath.Join(dir, "out", "report.xml"), nil, 0o644)
+	}()
+	if err := s.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := s.Receive(ctx)
+	if len(got) != 1 || got[0].ID != report.ID {
+		t.Fatalf("expected the file's follow-up due, got %+v", got)
+	}
+	_ = s.Ack(ctx, report.ID)
+	if waiting := s.Waiting(); len(waiting) != 1 || waiting[0] != "command test -f done" {
+		t.Fatalf("expected the command still waiting, got %v", waiting)
+	}
+
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := s.Wait(short); err == nil {
+		t.Fatal("expected the wait cut short while the command fails")
+	}
+	_ = os.WriteFile(filepath.Join(dir, "done"), nil, 0o644)
+	if err := s.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Receive(ctx); len(got) != 1 || got[0].Content != "say done" {
+		t.Fatalf("expected the command's follow-up due, got %+v", got)
+	}
+}
@@ -26,11 +26,38 @@ func (r *ToolRegistry) Register(t tool.Tool) error {
 	return nil
 }
 
+// Get finds a tool by ID or by qualified ID ("mcp:search"), which also
+// requires the tool to be in that namespace.
 func (r *ToolRegistry) Get(id string) (tool.Tool, bool) {
+	ns, id := tool.SplitQualified(id)
 	t, ok := r.tools[id]
+	if ok && ns != "" && tool.NamespaceOf(t) != ns {
+		return nil, false
+	}
 	return t, ok
 }
 
+// QualifiedID returns id prefixed with its tool's namespace, or id itself
+// when no tool has that ID.
+func (r *ToolRegistry) QualifiedID(id string) string {
+	if t, ok := r.tools[id]; ok {
+		return tool.QualifiedID(t)
+	}
+	return id
+}
+
+// Namespace lists the definitions of the tools in namespace ns, sorted by ID.
+func (r *ToolRegistry) Namespace(ns string) []tool.Definition {
+	var defs []tool.Definition
+	for _, t := range r.tools {
+		if tool.NamespaceOf(t) == ns {
+			defs = append(defs, t.Definition())
+		}
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].ID < defs[j].ID })
+	return defs
+}
+
 func (r *ToolRegistry) List() []tool.Definition {
 	defs := make([]tool.Definition, 0, len(r.tools))
 	for _, t := range r.tools {
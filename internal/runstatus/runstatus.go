@@ -0,0 +1,229 @@
+// Package runstatus writes a sidecar status file for a session's active
+// run, so external monitors can tell a run that is still generating or
+// running a tool from one that has hung, without subscribing to the
+// in-process event bus.
+//
+// The file is rewritten on every phase change and at a fixed heartbeat
+// interval in between, and removed when the run returns. UpdatedAt
+// advancing means the process is alive; LastProgressAt advancing means the
+// model or a tool is making progress. A file left behind whose UpdatedAt
+// has stopped advancing belongs to a process that died mid-run.
+package runstatus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/events"
+)
+
+// States of a run.
+const (
+	StateRunning  = "running"
+	StateFinished = "finished"
+)
+
+// Phases of a running turn.
+const (
+	PhaseModel = "model" // waiting for or streaming the model's reply
+	PhaseTool  = "tool"
+)
+
+// Status is the content of a session's status file.
+type Status struct {
+	SessionID      string    `json:"sessionId"`
+	PID            int       `json:"pid"`
+	State          string    `json:"state"`
+	Phase          string    `json:"phase,omitempty"`
+	Turn           int       `json:"turn"`
+	CurrentTool    string    `json:"currentTool,omitempty"`
+	BytesGenerated int       `json:"bytesGenerated"` // assistant text streamed this turn
+	StartedAt      time.Time `json:"startedAt"`
+	LastProgressAt time.Time `json:"lastProgressAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// Path returns the status file of sessionID under dir.
+func Path(dir, sessionID string) string {
+	return filepath.Join(dir, sessionID+".status.json")
+}
+
+// Read loads the status file of sessionID.
+func Read(dir, sessionID string) (Status, error) {
+	data, err := os.ReadFile(Path(dir, sessionID))
+	if err != nil {
+		return Status{}, err
+	}
+	var status Status
+	if err := json.Unmarshal(data, &status); err != nil {
+		return Status{}, err
+	}
+	return status, nil
+}
+
+// Sink wraps an event sink and maintains the status file of the run whose
+// events pass through it. Sub-agent events forwarded through the same sink
+// do not change the status.
+type Sink struct {
+	inner    events.Sink
+	dir      string
+	interval time.Duration
+
+	mu      sync.Mutex
+	status  Status
+	stop    chan struct{}
+	done    chan struct{}
+	writeMu sync.Mutex // keeps an older snapshot from replacing a newer one
+}
+
+// NewSink returns a sink writing status files to dir every interval while
+// a run is active. Call Close when the run returns.
+func NewSink(inner events.Sink, dir string, interval time.Duration) *Sink {
+	return &Sink{inner: inner, dir: dir, interval: interval}
+}
+
+func (s *Sink) Publish(ctx context.Context, event events.Event) error {
+	s.observe(event)
+	return s.inner.Publish(ctx, event)
+}
+
+// Close stops the heartbeat and removes the status file, whether or not
+// the run reported finishing.
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	stop, done, id := s.stop, s.done, s.status.SessionID
+	s.stop = nil
+	s.status.SessionID = "" // so that no later event writes the file again
+	s.mu.Unlock()
+	if stop == nil {
+		return nil
+	}
+	close(stop)
+	<-done
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if err := os.Remove(Path(s.dir, id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *Sink) observe(event events.Event) {
+	s.mu.Lock()
+	now := event.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+	switch event.Type {
+	case events.TypeRunStarted:
+		id := sessionID(event)
+		if s.status.SessionID != "" || id == "" {
+			s.mu.Unlock()
+			return
+		}
+		s.status = Status{SessionID: id, PID: os.Getpid(), State: StateRunning, Phase: PhaseModel, StartedAt: now, LastProgressAt: now}
+		s.stop, s.done = make(chan struct{}), make(chan struct{})
+		go s.heartbeat(s.stop, s.done)
+	case events.TypeRunFinished:
+		if sessionID(event) != s.status.SessionID {
+			s.mu.Unlock()
+			return
+		}
+		s.status.State, s.status.Phase, s.status.CurrentTool = StateFinished, "", ""
+	case events.TypeTurnStarted:
+		s.status.Turn++
+		s.status.Phase, s.status.CurrentTool, s.status.BytesGenerated = PhaseModel, "", 0
+	case events.TypeToolStarted:
+		s.status.Phase, s.status.CurrentTool = PhaseTool, event.Message
+	case events.TypeToolFinished:
+		if strings.HasPrefix(event.Message, "[sub:") {
+			// A sub-agent's tool; the parent's tool is still running.
+			s.mu.Unlock()
+			return
+		}
+		s.status.Phase, s.status.CurrentTool = PhaseModel, ""
+	case events.TypeAssistantDelta:
+		// Deltas are frequent; record them and let the heartbeat persist.
+		s.status.BytesGenerated += len(event.Message)
+		s.status.LastProgressAt = now
+		s.mu.Unlock()
+		return
+	default:
+		s.mu.Unlock()
+		return
+	}
+	active := s.status.SessionID != ""
+	s.status.LastProgressAt = now
+	s.mu.Unlock()
+	if active {
+		_ = s.write()
+	}
+}
+
+func (s *Sink) heartbeat(stop, done chan struct{}) {
+	defer close(done)
+	_ = s.write()
+	if s.interval <= 0 {
+		<-stop
+		return
+	}
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			_ = s.write()
+		}
+	}
+}
+
+// write replaces the status file atomically so readers never see a
+// partial record.
+func (s *Sink) write() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.mu.Lock()
+	status := s.status
+	s.mu.Unlock()
+	if status.SessionID == "" {
+		return nil
+	}
+	status.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return err
+	}
+	path := Path(s.dir, status.SessionID)
+	tmp, err := os.CreateTemp(s.dir, filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func sessionID(event events.Event) string {
+	data, _ := event.Data.(map[string]any)
+	id, _ := data["session_id"].(string)
+	return id
+}
@@ -0,0 +1,73 @@
+package runstatus
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/events"
+)
+
+func TestSinkTracksRunProgress(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	sink := NewSink(events.NopSink{}, dir, 10*time.Millisecond)
+	publish := func(typ events.Type, message string, data any) {
+		t.Helper()
+		if err := sink.Publish(ctx, events.Event{Type: typ, Time: time.Now(), Message: message, Data: data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	publish(events.TypeRunStarted, "run started", map[string]any{"session_id": "s1"})
+	publish(events.TypeTurnStarted, "turn 1 started", nil)
+	publish(events.TypeAssistantDelta, "Let me look.", nil)
+	publish(events.TypeToolStarted, "core/bash", nil)
+	publish(events.TypeToolFinished, "[sub:reviewer] → ok", nil)
+
+	status, err := Read(dir, "s1")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if status.State != StateRunning || status.Phase != PhaseTool || status.CurrentTool != "core/bash" || status.Turn != 1 {
+		t.Fatalf("unexpected status during a tool call: %+v", status)
+	}
+
+	// The heartbeat rewrites the file while nothing else happens.
+	before := status.UpdatedAt
+	time.Sleep(50 * time.Millisecond)
+	if status, _ = Read(dir, "s1"); !status.UpdatedAt.After(before) {
+		t.Fatalf("expected a heartbeat after %s, got %s", before, status.UpdatedAt)
+	}
+	if status.BytesGenerated != len("Let me look.") {
+		t.Fatalf("expected the heartbeat to record streamed bytes, got %d", status.BytesGenerated)
+	}
+
+	// A run that returns without finishing leaves no status behind.
+	if err := sink.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, err := Read(dir, "s1"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected the status file removed, got %v", err)
+	}
+}
+
+func TestSinkMarksFinishedRun(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	sink := NewSink(events.NopSink{}, dir, time.Second)
+	_ = sink.Publish(ctx, events.Event{Type: events.TypeRunStarted, Data: map[string]any{"session_id": "s2"}})
+	_ = sink.Publish(ctx, events.Event{Type: events.TypeRunFinished, Data: map[string]any{"session_id": "s2"}})
+	status, err := Read(dir, "s2")
+	if err != nil || status.State != StateFinished || status.Phase != "" {
+		t.Fatalf("expected a finished run, got %+v (%v)", status, err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, err := Read(dir, "s2"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected the status file removed, got %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+	"github.com/bitop-dev/agent/pkg/session"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// abortedNote ends the result of a tool cut short by an abort.
+const abortedNote = "[aborted: the run was canceled while this tool ran; the output above may be incomplete]"
+
+// runAbortable runs a tool call and returns its result. When ctx is
+// canceled before the call returns, it waits up to grace for the tool to
+// wind down and then stops waiting; a grace of 0 waits as long as the tool
+// takes. The tool runs with a context that is canceled with ctx.
+func runAbortable(ctx context.Context, grace time.Duration, run func() (tool.Result, error)) (tool.Result, error) {
+	type outcome struct {
+		result tool.Result
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := run()
+		done <- outcome{result, err}
+	}()
+	select {
+	case o := <-done:
+		return o.result, o.err
+	case <-ctx.Done():
+	}
+	if grace <= 0 {
+		o := <-done
+		return o.result, o.err
+	}
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case o := <-done:
+		return o.result, o.err
+	case <-timer.C:
+		return tool.Result{}, fmt.Errorf("tool did not return within the %s abort grace period", grace)
+	}
+}
+
+// abortedResult records what a tool returned when the run was aborted while
+// it ran: its output so far, flagged aborted, rather than an error in its
+// place.
+func abortedResult(call tool.Call, result tool.Result, err error) tool.Result {
+	result.ToolID = call.ToolID
+	var out strings.Builder
+	if output := strings.TrimRight(result.Output, "\n"); output != "" {
+		out.WriteString(output + "\n")
+	}
+	out.WriteString(abortedNote)
+	result.Output = out.String()
+	if result.Data == nil {
+		result.Data = map[string]any{}
+	}
+	result.Data["aborted"] = true
+	if err != nil {
+		result.Data["error"] = err.Error()
+	}
+	return result
+}
+
+// isAborted reports whether result is one made by abortedResult.
+func isAborted(result tool.Result) bool {
+	aborted, _ := result.Data["aborted"].(bool)
+	return aborted
+}
+
+// saveAborted records a turn cut short by an abort or a time limit, so a
+// resumed session shows what actually ran: the assistant's reply and tool
+// calls so far and their results, the last of them flagged aborted when
+// lastAborted is set. It returns the transcript with them appended.
+func saveAborted(ctx context.Context, req pkgruntime.RunRequest, sessionID string, transcript []provider.Message, assistant provider.Message, toolMessages []provider.Message, lastAborted bool) []provider.Message {
+	transcript = append(append(transcript, assistant), toolMessages...)
+	if req.Sessions == nil {
+		return transcript
+	}
+	ctx = context.WithoutCancel(ctx)
+	now := time.Now()
+	_ = req.Sessions.Append(ctx, sessionID, session.Entry{
+		Kind:      session.EntryMessage,
+		Role:      "assistant",
+		Content:   assistant.Content,
+		Metadata:  encodeSessionMetadata(session.MessageMetadata{ToolCalls: assistant.ToolCalls, Raw: assistant.Raw}),
+		CreatedAt: now,
+	})
+	for i, message := range toolMessages {
+		_ = req.Sessions.Append(ctx, sessionID, session.Entry{
+			Kind:      session.EntryMessage,
+			Role:      "tool",
+			Content:   message.Content,
+			Metadata:  encodeSessionMetadata(session.MessageMetadata{ToolCallID: message.ToolCallID, ToolName: message.ToolName, Images: message.Images, Aborted: lastAborted && i == len(toolMessages)-1}),
+			CreatedAt: now,
+		})
+	}
+	return transcript
+}
@@ -0,0 +1,207 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/events"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// argumentCheck validates tool calls against their schemas when a profile
+// sets tools.argumentRetries. A call that does not fit is not run; the
+// violations go back to the model as an invalid_args result and the
+// turn is repeated without counting against the turn limit, up to the
+// configured number of times per turn. After that, calls reach the tool
+// unchecked as before.
+type argumentCheck struct {
+	limit    int
+	used     int // retries spent since the last turn that counted
+	rejected int // calls rejected this turn
+	total    int // calls rejected over the run
+}
+
+func newArgumentCheck(limit int) *argumentCheck {
+	return &argumentCheck{limit: limit}
+}
+
+// check returns call with near-miss arguments coerced to the schema, or a
+// result rejecting it when a retry is still available.
+func (a *argumentCheck) check(ctx context.Context, sink events.Sink, impl tool.Tool, call tool.Call) (tool.Call, *tool.Result) {
+	if a.limit <= 0 || impl == nil {
+		return call, nil
+	}
+	def := impl.Definition()
+	args, problems := validateAndCoerce(def.Schema, call.Arguments)
+	call.Arguments = args
+	if len(problems) == 0 || a.used >= a.limit {
+		return call, nil
+	}
+	a.rejected++
+	a.total++
+	_ = sink.Publish(ctx, events.Event{Type: events.TypeToolRequested, Time: time.Now(), Message: call.ToolID})
+	schema, _ := json.Marshal(def.Schema)
+	result := tool.Result{
+		ToolID: call.ToolID,
+		Output: fmt.Sprintf("tool error [%s]: the call to %s was not run because its arguments do not match the schema: %s. Expected schema: %s. Call the tool again with corrected arguments.",
+			tool.ErrInvalidArgs, call.ToolID, strings.Join(problems, "; "), compactRuntimeText(string(schema), 1200)),
+		Data: map[string]any{"error": "invalid arguments: " + strings.Join(problems, "; "), "errorCode": string(tool.ErrInvalidArgs), "invalidArguments": problems},
+	}
+	_ = sink.Publish(ctx, events.Event{Type: events.TypeArgumentsRejected, Time: time.Now(), Message: fmt.Sprintf("%s arguments rejected (retry %d/%d): %s", call.ToolID, a.used+1, a.limit, strings.Join(problems, "; ")), Data: map[string]any{"tool_id": call.ToolID, "problems": problems, "retry": a.used + 1, "limit": a.limit}})
+	_ = sink.Publish(ctx, events.Event{Type: events.TypeToolFinished, Time: time.Now(), Message: result.Output, Data: result})
+	return call, &result
+}
+
+// endTurn reports whether the turn just finished is repeated rather than
+// counted, and resets the per-turn count.
+func (a *argumentCheck) endTurn() bool {
+	retry := a.rejected > 0
+	a.rejected = 0
+	if retry {
+		a.used++
+	} else {
+		a.used = 0
+	}
+	return retry
+}
+
+// validateAndCoerce checks args against a JSON Schema object and converts
+// near misses that smaller models often produce: numbers and booleans sent
+// as strings, scalars sent where a string is expected, and arrays or objects
+// sent as JSON text. It reports missing required arguments, arguments of the
+// wrong type, values outside an enum, and unknown arguments when the schema
+// forbids additional properties.
+func validateAndCoerce(schema, args map[string]any) (map[string]any, []string) {
+	properties, _ := schema["properties"].(map[string]any)
+	required := schemaRequired(schema)
+	if len(properties) == 0 && len(required) == 0 {
+		return args, nil
+	}
+	out := make(map[string]any, len(args))
+	for name, value := range args {
+		out[name] = value
+	}
+	var problems []string
+	var missing []string
+	for name := range required {
+		if _, ok := out[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		problems = append(problems, fmt.Sprintf("missing required %q", name))
+	}
+	names := make([]string, 0, len(out))
+	for name := range out {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	closed := schema["additionalProperties"] == false
+	for _, name := range names {
+		prop, known := properties[name]
+		if !known {
+			if closed {
+				problems = append(problems, fmt.Sprintf("unknown argument %q", name))
+			}
+			continue
+		}
+		want := schemaType(prop)
+		if want == "" {
+			continue
+		}
+		value := out[name]
+		if !matchesType(value, want) {
+			coerced, ok := coerceValue(value, want)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%q should be %s, got %s", name, want, jsonType(value)))
+				continue
+			}
+			value = coerced
+			out[name] = value
+		}
+		if allowed := schemaEnum(prop); len(allowed) > 0 && !inEnum(value, allowed) {
+			problems = append(problems, fmt.Sprintf("%q must be one of %s", name, enumList(allowed)))
+		}
+	}
+	return out, problems
+}
+
+func coerceValue(value any, want string) (any, bool) {
+	switch want {
+	case "integer", "number":
+		s, ok := value.(string)
+		if !ok {
+			return nil, false
+		}
+		n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil || (want == "integer" && n != float64(int64(n))) {
+			return nil, false
+		}
+		return n, true
+	case "boolean":
+		s, ok := value.(string)
+		if !ok {
+			return nil, false
+		}
+		b, err := strconv.ParseBool(strings.TrimSpace(s))
+		return b, err == nil
+	case "string":
+		switch v := value.(type) {
+		case float64, bool, int, int64:
+			return fmt.Sprint(v), true
+		}
+		return nil, false
+	case "array", "object":
+		s, ok := value.(string)
+		if !ok {
+			return nil, false
+		}
+		var decoded any
+		if err := json.Unmarshal([]byte(s), &decoded); err != nil || jsonType(decoded) != want {
+			return nil, false
+		}
+		return decoded, true
+	}
+	return nil, false
+}
+
+// schemaEnum returns a property's enum, which Go-defined schemas often
+// declare as []string.
+func schemaEnum(prop any) []any {
+	m, _ := prop.(map[string]any)
+	switch list := m["enum"].(type) {
+	case []any:
+		return list
+	case []string:
+		out := make([]any, len(list))
+		for i, option := range list {
+			out[i] = option
+		}
+		return out
+	}
+	return nil
+}
+
+func inEnum(value any, allowed []any) bool {
+	for _, option := range allowed {
+		if fmt.Sprint(option) == fmt.Sprint(value) && jsonType(option) == jsonType(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func enumList(allowed []any) string {
+	parts := make([]string, len(allowed))
+	for i, option := range allowed {
+		data, _ := json.Marshal(option)
+		parts[i] = string(data)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,35 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+	"github.com/bitop-dev/agent/pkg/session"
+)
+
+// recordArtifacts stores the artifacts tools declared in a turn in the
+// session.
+func recordArtifacts(ctx context.Context, req pkgruntime.RunRequest, sessionID string, turn int, artifacts []session.Artifact) {
+	if req.Sessions == nil || len(artifacts) == 0 {
+		return
+	}
+	data, err := json.Marshal(session.TurnArtifacts{Turn: turn, Artifacts: artifacts})
+	if err != nil {
+		return
+	}
+	paths := make([]string, len(artifacts))
+	for i, a := range artifacts {
+		paths[i] = a.Path
+	}
+	_ = req.Sessions.Append(ctx, sessionID, session.Entry{
+		Kind:      session.EntryEvent,
+		EventType: session.EventArtifacts,
+		Content:   fmt.Sprintf("turn %d: %s", turn, strings.Join(paths, ", ")),
+		Metadata:  string(data),
+		CreatedAt: time.Now(),
+	})
+}
@@ -0,0 +1,71 @@
+package runtime
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// newAuditRecord starts the audit record of a call, as run without asking
+// anyone until executeTool learns otherwise.
+func newAuditRecord(ctx context.Context, call tool.Call) pkgruntime.AuditRecord {
+	sessionID, _ := tool.SessionFrom(ctx)
+	return pkgruntime.AuditRecord{
+		Time:       time.Now().UTC(),
+		SessionID:  sessionID,
+		ToolID:     call.ToolID,
+		ToolCallID: call.ID,
+		ArgsHash:   argsHash(call.Arguments),
+		Decision:   pkgruntime.AuditAuto,
+	}
+}
+
+// finishAuditRecord adds what the call's result tells about how it went.
+func finishAuditRecord(record *pkgruntime.AuditRecord, result tool.Result) {
+	if isAborted(result) {
+		record.Status = "aborted"
+	}
+	if n, ok := result.Data["bytes_written"].(int); ok {
+		record.BytesWritten = n
+	}
+}
+
+// auditRejected records a call that argument validation kept from running.
+func auditRejected(ctx context.Context, req pkgruntime.RunRequest, call tool.Call, result tool.Result) error {
+	if req.Audit == nil {
+		return nil
+	}
+	record := newAuditRecord(ctx, call)
+	record.Decision = pkgruntime.AuditDenied
+	record.Reason, _ = result.Data["error"].(string)
+	if err := req.Audit.Record(context.WithoutCancel(ctx), record); err != nil {
+		return fmt.Errorf("audit %s: %w", call.ToolID, err)
+	}
+	return nil
+}
+
+// argsHash is the SHA-256 of the arguments' JSON, whose object keys
+// encoding/json sorts, so equal arguments hash alike.
+func argsHash(args map[string]any) string {
+	data, _ := json.Marshal(args)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// exitCode returns the exit status of a command a tool ran, if err is one.
+func exitCode(err error) *int {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return nil
+	}
+	code := exitErr.ExitCode()
+	return &code
+}
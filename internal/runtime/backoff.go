@@ -0,0 +1,81 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/events"
+	"github.com/bitop-dev/agent/pkg/provider"
+)
+
+// Backoff before retrying a failed provider request, doubling with each
+// attempt, by kind of failure. A rate limit or an overloaded provider
+// needs longer to clear than a server error.
+const (
+	baseRetryDelay       = 500 * time.Millisecond
+	overloadedRetryDelay = 2 * time.Second
+	rateLimitRetryDelay  = 5 * time.Second
+	// maxRetryAfter is the longest Retry-After waited for; a provider
+	// asking for more is treated as refusing the request.
+	maxRetryAfter = time.Minute
+)
+
+// retryDelay returns how long to wait before retrying a provider request
+// that failed with err on attempt, counted from 0: the Retry-After the
+// provider sent, or a backoff for the kind of failure. It returns false
+// when retrying cannot help: the key was refused, the quota is spent, the
+// request itself was rejected, or the provider asked to wait longer than
+// maxRetryAfter. A stalled stream is retried at once.
+func retryDelay(err error, attempt int) (time.Duration, bool) {
+	if errors.Is(err, provider.ErrStreamStalled) {
+		return 0, true
+	}
+	base := baseRetryDelay
+	var apiErr *provider.APIError
+	switch {
+	case errors.As(err, &apiErr):
+		switch apiErr.Kind() {
+		case provider.ErrorAuth, provider.ErrorQuota, provider.ErrorRequest:
+			return 0, false
+		case provider.ErrorRateLimited:
+			base = rateLimitRetryDelay
+		case provider.ErrorOverloaded:
+			base = overloadedRetryDelay
+		}
+		if apiErr.RetryAfter > maxRetryAfter {
+			return 0, false
+		}
+		if apiErr.RetryAfter > 0 {
+			return apiErr.RetryAfter, true
+		}
+	case fallbackReason(err) != "":
+		return 0, false
+	}
+	jitter := time.Duration(rand.Intn(200)) * time.Millisecond
+	return base<<attempt + jitter, true
+}
+
+// wait sleeps for d, returning false if ctx ends first.
+func wait(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// publishRetry reports that a failed provider request is retried after
+// delay.
+func publishRetry(ctx context.Context, sink events.Sink, model string, attempt, attempts int, delay time.Duration, err error) {
+	data := map[string]any{"model": model, "attempt": attempt + 1, "delay_ms": delay.Milliseconds()}
+	if kind := provider.ErrorKindOf(err); kind != "" {
+		data["kind"] = string(kind)
+	}
+	_ = sink.Publish(ctx, events.Event{Type: events.TypeError, Time: time.Now(), Message: fmt.Sprintf("model %s attempt %d/%d: %s; retrying in %s", model, attempt+1, attempts, err, delay.Round(time.Millisecond)), Data: data})
+}
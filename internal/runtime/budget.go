@@ -0,0 +1,191 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bitop-dev/agent/internal/models"
+	"github.com/bitop-dev/agent/pkg/approval"
+	"github.com/bitop-dev/agent/pkg/events"
+	"github.com/bitop-dev/agent/pkg/profile"
+	"github.com/bitop-dev/agent/pkg/provider"
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+)
+
+// Budget scopes reported in budget events.
+const (
+	budgetTurn    = "turn"
+	budgetSession = "session"
+	budgetDaily   = "daily"
+)
+
+// budgetGuard tracks spend against a profile's BudgetSpec. A nil guard
+// accepts everything.
+type budgetGuard struct {
+	spec    profile.BudgetSpec
+	ledger  pkgruntime.SpendLedger
+	session float64
+	tools   map[string]float64
+	warned  map[string]bool
+	waived  map[string]bool // scopes a human approved continuing past
+}
+
+func newBudgetGuard(req pkgruntime.RunRequest) *budgetGuard {
+	if !req.Profile.Spec.Budget.Enabled() {
+		return nil
+	}
+	return &budgetGuard{
+		spec:   req.Profile.Spec.Budget,
+		ledger: req.Ledger,
+		tools:  map[string]float64{},
+		warned: map[string]bool{},
+		waived: map[string]bool{},
+	}
+}
+
+// recordTurn prices one turn's usage, adds it to the session and daily
+// totals, and returns its cost. Cached input is priced at the cache rate,
+// and input written to the cache at the cache write rate. Unpriced models
+// cost nothing.
+func (g *budgetGuard) recordTurn(ctx context.Context, providerName, model string, inputTokens, outputTokens, cacheReadTokens, cacheWriteTokens int) float64 {
+	cost, _ := models.CostWithCache(providerName, model, inputTokens, outputTokens, cacheReadTokens, cacheWriteTokens)
+	if g == nil {
+		return cost
+	}
+	g.session += cost
+	if g.ledger != nil {
+		_ = g.ledger.Record(ctx, time.Now(), cost)
+	}
+	return cost
+}
+
+// recordTool adds spend reported by a tool via a costUSD result field.
+func (g *budgetGuard) recordTool(ctx context.Context, toolID string, data map[string]any) {
+	if g == nil {
+		return
+	}
+	cost, ok := data["costUSD"].(float64)
+	if !ok || cost <= 0 {
+		return
+	}
+	g.tools[toolID] += cost
+	if g.ledger != nil {
+		_ = g.ledger.Record(ctx, time.Now(), cost)
+	}
+}
+
+// check compares spend with every cap, publishing warnings once per scope.
+// When a cap is exceeded it either stops (the default) or asks the approval
+// resolver whether to continue; an approved scope is not checked again.
+func (g *budgetGuard) check(ctx context.Context, req pkgruntime.RunRequest, sink events.Sink, turnCost float64) (stop bool, err error) {
+	if g == nil {
+		return false, nil
+	}
+	type usage struct {
+		scope        string
+		spent, limit float64
+	}
+	scopes := []usage{
+		{budgetTurn, turnCost, g.spec.MaxTurnUSD},
+		{budgetSession, g.session, g.spec.MaxSessionUSD},
+	}
+	if g.spec.MaxDailyUSD > 0 && g.ledger != nil {
+		daily, err := g.ledger.Spent(ctx, time.Now().Add(-24*time.Hour))
+		if err == nil {
+			scopes = append(scopes, usage{budgetDaily, daily, g.spec.MaxDailyUSD})
+		}
+	}
+	for toolID, limit := range g.spec.MaxToolUSD {
+		scopes = append(scopes, usage{"tool:" + toolID, g.tools[toolID], limit})
+	}
+	warnAt := g.spec.WarnAt
+	if warnAt <= 0 || warnAt >= 1 {
+		warnAt = 0.8
+	}
+	for _, u := range scopes {
+		if u.limit <= 0 || g.waived[u.scope] {
+			continue
+		}
+		data := map[string]any{"scope": u.scope, "spent_usd": u.spent, "limit_usd": u.limit}
+		if u.spent <= u.limit {
+			if u.spent >= warnAt*u.limit && !g.warned[u.scope] {
+				g.warned[u.scope] = true
+				if err := sink.Publish(ctx, events.Event{Type: events.TypeBudgetWarning, Time: time.Now(), Message: fmt.Sprintf("%s budget at $%.4f of $%.2f", u.scope, u.spent, u.limit), Data: data}); err != nil {
+					return false, err
+				}
+			}
+			continue
+		}
+		message := fmt.Sprintf("%s budget exceeded: $%.4f of $%.2f", u.scope, u.spent, u.limit)
+		if err := sink.Publish(ctx, events.Event{Type: events.TypeBudgetExceeded, Time: time.Now(), Message: message, Data: data}); err != nil {
+			return false, err
+		}
+		if g.spec.OnExceeded != "approve" || req.Approvals == nil {
+			return true, nil
+		}
+		decision, err := req.Approvals.Resolve(ctx, approval.Request{Action: "budget", ToolID: "budget/" + u.scope, Reason: message + "; continue?", Risk: "high"})
+		if err != nil {
+			return false, err
+		}
+		if err := sink.Publish(ctx, events.Event{Type: events.TypeApprovalResult, Time: time.Now(), Message: decision.Reason, Data: decision}); err != nil {
+			return false, err
+		}
+		if !decision.Approved {
+			return true, nil
+		}
+		g.waived[u.scope] = true
+	}
+	return false, nil
+}
+
+// pressure returns the largest share of a session or daily cap spent so
+// far, 0 without either.
+func (g *budgetGuard) pressure(ctx context.Context) float64 {
+	if g == nil {
+		return 0
+	}
+	var share float64
+	if g.spec.MaxSessionUSD > 0 {
+		share = g.session / g.spec.MaxSessionUSD
+	}
+	if g.spec.MaxDailyUSD > 0 && g.ledger != nil {
+		if daily, err := g.ledger.Spent(ctx, time.Now().Add(-24*time.Hour)); err == nil {
+			share = max(share, daily/g.spec.MaxDailyUSD)
+		}
+	}
+	return share
+}
+
+// usageEventData describes usage for a TypeUsageUpdate or TypeTurnFinished
+// event, priced when the model is in the catalog. Reasoning tokens are part
+// of outputTokens; their share of the cost is reported separately so the
+// spend on hidden reasoning is visible.
+func usageEventData(providerName, model string, turn, inputTokens, outputTokens, reasoningTokens int) map[string]any {
+	data := map[string]any{"model": model, "turn": turn, "inputTokens": inputTokens, "outputTokens": outputTokens}
+	if reasoningTokens > 0 {
+		data["reasoningTokens"] = reasoningTokens
+	}
+	if cost, priced := models.Cost(providerName, model, inputTokens, outputTokens); priced {
+		data["costUSD"] = cost
+		if reasoningTokens > 0 {
+			data["reasoningCostUSD"], _ = models.Cost(providerName, model, 0, reasoningTokens)
+		}
+	}
+	return data
+}
+
+// addTimingData adds to a turn's event data the server timing its provider
+// reported, if any: time queued and token throughput.
+func addTimingData(data map[string]any, timing *provider.Timing, inputTokens, outputTokens int) {
+	if timing == nil {
+		return
+	}
+	data["queueMs"] = timing.Queue.Milliseconds()
+	if tps := timing.PromptThroughput(inputTokens); tps > 0 {
+		data["promptTokensPerSecond"] = tps
+	}
+	if tps := timing.OutputThroughput(outputTokens); tps > 0 {
+		data["outputTokensPerSecond"] = tps
+	}
+}
@@ -0,0 +1,81 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/events"
+	"github.com/bitop-dev/agent/pkg/policy"
+	"github.com/bitop-dev/agent/pkg/provider"
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// callContext returns the tool.CallContext of call, made when the call
+// runs so it reflects the run's current working directory, and a func
+// that stops its Log once the call has returned.
+func callContext(ctx context.Context, req pkgruntime.RunRequest, sink events.Sink, transcript []provider.Message, call tool.Call) (tool.CallContext, func()) {
+	sessionID, _ := tool.SessionFrom(ctx)
+	cc := tool.CallContext{CallID: call.ID, CWD: req.Execution.CWD, SessionID: sessionID}
+	if sessionID != "" {
+		cc.ScratchDir = filepath.Join(os.TempDir(), "agent-scratch", sessionID)
+	}
+	snapshot := append([]provider.Message(nil), transcript...)
+	cc.Transcript = func() []tool.Message {
+		messages := make([]tool.Message, len(snapshot))
+		for i, msg := range snapshot {
+			messages[i] = tool.Message{Role: msg.Role, Content: msg.Content, ToolCalls: append([]tool.Call(nil), msg.ToolCalls...), ToolCallID: msg.ToolCallID}
+		}
+		return messages
+	}
+	if req.Policy != nil {
+		cc.CheckRead = func(ctx context.Context, path string) error {
+			decision, err := req.Policy.Check(ctx, policy.CheckRequest{Action: policy.ActionRead, ToolID: call.ToolID, Path: cc.Path(path), Risk: policy.RiskLow})
+			if err != nil {
+				return err
+			}
+			if decision.Kind != policy.DecisionAllow {
+				return fmt.Errorf("policy does not allow reading %s: %s", path, decision.Reason)
+			}
+			return nil
+		}
+	}
+	var mu sync.Mutex
+	stopped := false
+	cc.Log = func(message string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if stopped || message == "" {
+			return
+		}
+		_ = sink.Publish(ctx, events.Event{Type: events.TypeToolLog, Time: time.Now(), Message: message, Data: map[string]any{"tool_id": call.ToolID, "tool_call_id": call.ID}})
+	}
+	stop := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = true
+	}
+	return cc, stop
+}
+
+// dryRun returns the diff a tool.DryRunner says call would make, for an
+// approval request, with the call context the call will run with. It is
+// "" for other tools and when the dry run fails, which the call itself
+// will report.
+func dryRun(ctx context.Context, req pkgruntime.RunRequest, sink events.Sink, transcript []provider.Message, impl tool.Tool, call tool.Call) string {
+	runner, ok := tool.As[tool.DryRunner](impl)
+	if !ok {
+		return ""
+	}
+	cc, stop := callContext(ctx, req, sink, transcript, call)
+	defer stop()
+	diff, err := runner.DryRun(tool.WithCallContext(ctx, cc), call)
+	if err != nil {
+		return ""
+	}
+	return diff
+}
@@ -0,0 +1,89 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/events"
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+	"github.com/bitop-dev/agent/pkg/session"
+)
+
+// Causes of a turn context ended by a run limit rather than by the caller.
+var (
+	errTurnTimeout = errors.New("turn time limit reached")
+	errWallClock   = errors.New("run time limit reached")
+)
+
+// runLimits enforces RunRequest.MaxTurnDuration and MaxWallClock. Each turn
+// runs under a context that ends at the nearer of the two, with a cause
+// telling them apart from a cancellation by the caller.
+type runLimits struct {
+	turn, wallClock time.Duration
+	started         time.Time
+}
+
+func newRunLimits(req pkgruntime.RunRequest) runLimits {
+	return runLimits{turn: req.MaxTurnDuration, wallClock: req.MaxWallClock, started: time.Now()}
+}
+
+// turnContext returns the context a turn starting now runs under.
+func (l runLimits) turnContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if l.turn <= 0 && l.wallClock <= 0 {
+		return ctx, func() {}
+	}
+	turnEnd := time.Now().Add(l.turn)
+	if l.wallClock > 0 && (l.turn <= 0 || l.started.Add(l.wallClock).Before(turnEnd)) {
+		return context.WithDeadlineCause(ctx, l.started.Add(l.wallClock), errWallClock)
+	}
+	return context.WithDeadlineCause(ctx, turnEnd, errTurnTimeout)
+}
+
+// overrun reports whether the run is out of wall-clock time before a turn.
+func (l runLimits) overrun() bool {
+	return l.wallClock > 0 && time.Since(l.started) >= l.wallClock
+}
+
+// exceeded returns the limit that ended turnCtx, or nil while it runs or
+// when the caller's ctx ended it.
+func (l runLimits) exceeded(ctx, turnCtx context.Context) error {
+	if ctx.Err() != nil || turnCtx.Err() == nil {
+		return nil
+	}
+	if cause := context.Cause(turnCtx); errors.Is(cause, errTurnTimeout) || errors.Is(cause, errWallClock) {
+		return cause
+	}
+	return nil
+}
+
+// stop publishes and records that cause, one of the limit errors, stopped
+// the run at turn, which started at turnStarted, and returns the run's
+// stop reason.
+func (l runLimits) stop(ctx context.Context, req pkgruntime.RunRequest, sink events.Sink, sessionID string, turn int, turnStarted time.Time, cause error) string {
+	marker := session.Deadline{Limit: "run", LimitMs: l.wallClock.Milliseconds(), Turn: turn, ElapsedMs: time.Since(l.started).Milliseconds()}
+	reason := pkgruntime.StopWallClock
+	if errors.Is(cause, errTurnTimeout) {
+		marker = session.Deadline{Limit: "turn", LimitMs: l.turn.Milliseconds(), Turn: turn, ElapsedMs: time.Since(turnStarted).Milliseconds()}
+		reason = pkgruntime.StopTurnTimeout
+	}
+	message := fmt.Sprintf("stopped at turn %d: run time limit of %s reached", turn, l.wallClock)
+	if marker.Limit == "turn" {
+		message = fmt.Sprintf("stopped at turn %d: turn time limit of %s reached", turn, l.turn)
+	}
+	_ = sink.Publish(ctx, events.Event{Type: events.TypeDeadlineExceeded, Time: time.Now(), Message: message, Data: marker})
+	if req.Sessions != nil {
+		if data, err := json.Marshal(marker); err == nil {
+			_ = req.Sessions.Append(ctx, sessionID, session.Entry{
+				Kind:      session.EntryEvent,
+				EventType: session.EventDeadlineExceeded,
+				Content:   message,
+				Metadata:  string(data),
+				CreatedAt: time.Now(),
+			})
+		}
+	}
+	return reason
+}
@@ -0,0 +1,69 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/events"
+	"github.com/bitop-dev/agent/pkg/provider"
+)
+
+// Reasons a turn moves on to the next model in the fallback chain.
+const (
+	fallbackModelNotFound = "model not found"
+	fallbackContentFilter = "content filter"
+	fallbackQuota         = "quota exhausted"
+	fallbackBadRequest    = "request rejected"
+	fallbackRetries       = "retries exhausted"
+)
+
+// fallbackReason classifies an error that retrying the same model will not
+// fix but another model might: the model does not exist, its content filter
+// refused the request, or the account's quota for it is spent. It returns
+// "" for errors worth retrying, such as timeouts, rate limits, and server
+// errors.
+func fallbackReason(err error) string {
+	if err == nil {
+		return ""
+	}
+	switch provider.ErrorKindOf(err) {
+	case provider.ErrorQuota:
+		return fallbackQuota
+	case provider.ErrorAuth, provider.ErrorRateLimited, provider.ErrorOverloaded, provider.ErrorServer:
+		// Another model of the same provider fares no better, and a
+		// transient error is the retries' to handle.
+		return ""
+	}
+	msg := strings.ToLower(err.Error())
+	contains := func(needles ...string) bool {
+		for _, needle := range needles {
+			if strings.Contains(msg, needle) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case contains("invalid model", "model not found", "model_not_found", "does not exist", "not_found_error", "unknown model"):
+		return fallbackModelNotFound
+	case contains("content_filter", "content filter", "content_policy", "content management policy", "responsibleaipolicyviolation", "safety system"):
+		return fallbackContentFilter
+	case contains("insufficient_quota", "exceeded your current quota", "quota exceeded", "credit balance is too low"):
+		return fallbackQuota
+	case contains("400 bad request"):
+		return fallbackBadRequest
+	}
+	return ""
+}
+
+// publishFallback reports that the turn moves from one model to the next.
+func publishFallback(ctx context.Context, sink events.Sink, from, to, reason string, err error) {
+	_ = sink.Publish(ctx, events.Event{
+		Type:    events.TypeModelFallback,
+		Time:    time.Now(),
+		Message: fmt.Sprintf("model %s failed (%s), falling back to %s", from, reason, to),
+		Data:    map[string]any{"from": from, "to": to, "reason": reason, "error": err.Error()},
+	})
+}
@@ -0,0 +1,46 @@
+package runtime
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/events"
+	"github.com/bitop-dev/agent/pkg/provider"
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+)
+
+// awaitFollowUps waits, once the model is done and nothing is queued, for
+// the scheduled follow-ups of req.FollowUps to come due, and injects the
+// first messages then queued. It gives up with none injected when nothing
+// waits, ctx ends, or the run's wall clock runs out.
+func awaitFollowUps(ctx context.Context, req pkgruntime.RunRequest, sink events.Sink, sessionID string, limits runLimits, transcript []provider.Message) ([]provider.Message, int) {
+	scheduled, ok := req.FollowUps.(pkgruntime.ScheduledQueue)
+	if !ok {
+		return transcript, 0
+	}
+	waitCtx := ctx
+	if limits.wallClock > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithDeadline(ctx, limits.started.Add(limits.wallClock))
+		defer cancel()
+	}
+	for {
+		waiting := scheduled.Waiting()
+		if len(waiting) == 0 {
+			return transcript, 0
+		}
+		_ = sink.Publish(ctx, events.Event{Type: events.TypeFollowUpWaiting, Time: time.Now(), Message: "waiting for " + strings.Join(waiting, "; "), Data: map[string]any{"waiting": waiting}})
+		if err := scheduled.Wait(waitCtx); err != nil {
+			return transcript, 0
+		}
+		var injected int
+		transcript, injected = injectQueued(ctx, req, sink, sessionID, req.Steering, events.TypeSteering, transcript)
+		if injected == 0 {
+			transcript, injected = injectQueued(ctx, req, sink, sessionID, req.FollowUps, events.TypeFollowUp, transcript)
+		}
+		if injected > 0 {
+			return transcript, injected
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bitop-dev/agent/internal/guardrails"
+	"github.com/bitop-dev/agent/pkg/approval"
+	"github.com/bitop-dev/agent/pkg/events"
+	"github.com/bitop-dev/agent/pkg/profile"
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+)
+
+// Guardrail actions; see profile.GuardrailSpec.
+const (
+	guardrailRedact  = "redact"
+	guardrailBlock   = "block"
+	guardrailApprove = "approve"
+)
+
+// guardrail applies a profile's GuardrailSpec. A nil guardrail passes
+// everything.
+type guardrail struct {
+	spec    profile.GuardrailSpec
+	scanner *guardrails.Scanner
+}
+
+func newGuardrail(req pkgruntime.RunRequest) (*guardrail, error) {
+	spec := req.Profile.Spec.Guardrails
+	if !spec.Enabled() {
+		return nil, nil
+	}
+	switch spec.Action {
+	case "", guardrailRedact, guardrailBlock, guardrailApprove:
+	default:
+		return nil, fmt.Errorf("guardrails: unknown action %q (want redact, block, or approve)", spec.Action)
+	}
+	switch spec.Scope {
+	case "", "all", "assistant", "tools":
+	default:
+		return nil, fmt.Errorf("guardrails: unknown scope %q (want all, assistant, or tools)", spec.Scope)
+	}
+	scanner, err := guardrails.New(spec)
+	if err != nil {
+		return nil, fmt.Errorf("guardrails: %w", err)
+	}
+	return &guardrail{spec: spec, scanner: scanner}, nil
+}
+
+// checkAssistant scans an assistant reply.
+func (g *guardrail) checkAssistant(ctx context.Context, req pkgruntime.RunRequest, sink events.Sink, text string) (string, bool, error) {
+	if g == nil || g.spec.Scope == "tools" {
+		return text, false, nil
+	}
+	return g.check(ctx, req, sink, "assistant", text)
+}
+
+// checkTool scans the output of a tool.
+func (g *guardrail) checkTool(ctx context.Context, req pkgruntime.RunRequest, sink events.Sink, toolID, text string) (string, bool, error) {
+	if g == nil || g.spec.Scope == "assistant" {
+		return text, false, nil
+	}
+	return g.check(ctx, req, sink, "tool:"+toolID, text)
+}
+
+// holdsAssistant reports whether assistant replies are in scope, and so
+// are held back from the sink until checked.
+func (g *guardrail) holdsAssistant() bool {
+	return g != nil && g.spec.Scope != "tools"
+}
+
+// holdsTools reports whether tool output is in scope, and so is held back
+// from the sink until checked.
+func (g *guardrail) holdsTools() bool {
+	return g != nil && g.spec.Scope != "assistant"
+}
+
+// assistantSink returns sink without the assistant deltas the guardrail
+// checks; the caller publishes the reply it keeps.
+func (g *guardrail) assistantSink(sink events.Sink) events.Sink {
+	if !g.holdsAssistant() {
+		return sink
+	}
+	return heldSink{sink, []events.Type{events.TypeAssistantDelta}}
+}
+
+// toolSink returns sink without the streamed output and result of a tool
+// call the guardrail checks; the caller publishes the result it keeps.
+func (g *guardrail) toolSink(sink events.Sink) events.Sink {
+	if !g.holdsTools() {
+		return sink
+	}
+	return heldSink{sink, []events.Type{events.TypeToolOutput, events.TypeToolFinished}}
+}
+
+// heldSink drops events of the held types and passes the rest to Sink.
+type heldSink struct {
+	events.Sink
+	held []events.Type
+}
+
+func (s heldSink) Publish(ctx context.Context, event events.Event) error {
+	if slices.Contains(s.held, event.Type) {
+		return nil
+	}
+	return s.Sink.Publish(ctx, event)
+}
+
+// check scans text from source and applies the action to a match. It
+// returns the text to keep, which is the notice of a block when blocked is
+// set.
+func (g *guardrail) check(ctx context.Context, req pkgruntime.RunRequest, sink events.Sink, source, text string) (kept string, blocked bool, err error) {
+	findings := g.scanner.Scan(text)
+	if len(findings) == 0 {
+		return text, false, nil
+	}
+	action := g.spec.Action
+	if action == "" {
+		action = guardrailRedact
+	}
+	rules := make([]string, 0, len(findings))
+	summary := make([]map[string]any, len(findings))
+	for i, f := range findings {
+		summary[i] = map[string]any{"kind": f.Kind, "rule": f.Rule}
+		if name := f.Kind + ":" + f.Rule; !slices.Contains(rules, name) {
+			rules = append(rules, name)
+		}
+	}
+	message := fmt.Sprintf("%s: %d match(es) (%s)", source, len(findings), strings.Join(rules, ", "))
+	data := map[string]any{"source": source, "action": action, "findings": summary}
+	if err := sink.Publish(ctx, events.Event{Type: events.TypeGuardrailTriggered, Time: time.Now(), Message: message + "; " + action, Data: data}); err != nil {
+		return "", false, err
+	}
+	switch action {
+	case guardrailRedact:
+		return guardrails.Redact(text, findings), false, nil
+	case guardrailApprove:
+		if req.Approvals != nil {
+			decision, err := req.Approvals.Resolve(ctx, approval.Request{Action: "guardrail", ToolID: "guardrail/" + source, Reason: message + "; let it through?", Risk: "high"})
+			if err != nil {
+				return "", false, err
+			}
+			if err := sink.Publish(ctx, events.Event{Type: events.TypeApprovalResult, Time: time.Now(), Message: decision.Reason, Data: decision}); err != nil {
+				return "", false, err
+			}
+			if decision.Approved {
+				return text, false, nil
+			}
+		}
+	}
+	return fmt.Sprintf("[withheld by guardrail: %s]", message), true, nil
+}
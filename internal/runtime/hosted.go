@@ -0,0 +1,103 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/events"
+	"github.com/bitop-dev/agent/pkg/policy"
+	"github.com/bitop-dev/agent/pkg/profile"
+	"github.com/bitop-dev/agent/pkg/provider"
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+)
+
+// hostedTools converts a profile's hosted tools for a completion request.
+func hostedTools(tools []profile.HostedTool) []provider.HostedTool {
+	var out []provider.HostedTool
+	for _, t := range tools {
+		if t.Type != "" {
+			out = append(out, provider.HostedTool{Type: t.Type, Options: t.Options})
+		}
+	}
+	return out
+}
+
+// offeredHostedTools returns the profile's hosted tools that policy
+// allows, checked as "hosted/<type>", web ones as network access. The
+// provider runs them without asking, so one that policy denies or wants
+// approved is left out, and the decision published.
+func offeredHostedTools(ctx context.Context, req pkgruntime.RunRequest, sink events.Sink) ([]provider.HostedTool, error) {
+	tools := hostedTools(req.Profile.Spec.Provider.HostedTools)
+	if req.Policy == nil {
+		return tools, nil
+	}
+	var out []provider.HostedTool
+	for _, t := range tools {
+		check := policy.CheckRequest{Action: policy.ActionTool, ToolID: "hosted/" + t.Type, Risk: policy.RiskMedium, Arguments: t.Options}
+		if strings.HasPrefix(t.Type, "web_") {
+			check.Action = policy.ActionNet
+		}
+		decision, err := req.Policy.Check(ctx, check)
+		if err != nil {
+			return nil, err
+		}
+		if decision.Kind == policy.DecisionAllow {
+			out = append(out, t)
+			continue
+		}
+		message := fmt.Sprintf("hosted tool %s not offered: %s", t.Type, decision.Reason)
+		if err := sink.Publish(ctx, events.Event{Type: events.TypePolicyDecision, Time: time.Now(), Message: message, Data: decision}); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// publishHostedTool reports a raw block that is a hosted tool's call or
+// result. The block itself stays on the assistant message like any other.
+func publishHostedTool(ctx context.Context, sink events.Sink, raw provider.RawContent) error {
+	if raw.HostedTool == "" {
+		return nil
+	}
+	message := raw.HostedTool + " " + hostedToolAction(raw.Type)
+	if query := hostedToolQuery(raw.Data); query != "" {
+		message += ": " + query
+	}
+	return sink.Publish(ctx, events.Event{Type: events.TypeHostedTool, Time: time.Now(), Message: message, Data: map[string]any{
+		"tool":     raw.HostedTool,
+		"type":     raw.Type,
+		"provider": raw.Provider,
+		"block":    raw.Data,
+	}})
+}
+
+// hostedToolAction tells a result block from a call by its type.
+func hostedToolAction(typ string) string {
+	if strings.HasSuffix(typ, "_result") {
+		return "result"
+	}
+	return "call"
+}
+
+// hostedToolQuery digs the search query out of a call block, where the
+// providers put it: Anthropic in input.query, OpenAI in action.query.
+func hostedToolQuery(data json.RawMessage) string {
+	var block struct {
+		Input struct {
+			Query string `json:"query"`
+		} `json:"input"`
+		Action struct {
+			Query string `json:"query"`
+		} `json:"action"`
+	}
+	if json.Unmarshal(data, &block) != nil {
+		return ""
+	}
+	if block.Input.Query != "" {
+		return block.Input.Query
+	}
+	return block.Action.Query
+}
@@ -0,0 +1,140 @@
+package runtime
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bitop-dev/agent/internal/guardrails"
+	"github.com/bitop-dev/agent/pkg/events"
+	"github.com/bitop-dev/agent/pkg/profile"
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// injectionGuard applies a profile's InjectionSpec. A nil injectionGuard
+// trusts every tool.
+type injectionGuard struct {
+	spec profile.InjectionSpec
+	root string
+}
+
+func newInjectionGuard(req pkgruntime.RunRequest) (*injectionGuard, error) {
+	spec := req.Profile.Spec.Injection
+	if !spec.Enabled() {
+		return nil, nil
+	}
+	for pattern, mode := range spec.Tools {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("injection: tool pattern %q: %w", pattern, err)
+		}
+		if err := checkInjectionMode(mode); err != nil {
+			return nil, fmt.Errorf("injection: tool %s: %w", pattern, err)
+		}
+	}
+	if err := checkInjectionMode(spec.OutsideWorkspace); err != nil {
+		return nil, fmt.Errorf("injection: outsideWorkspace: %w", err)
+	}
+	return &injectionGuard{spec: spec, root: cmp.Or(req.Execution.Workspace.Root, req.Execution.CWD)}, nil
+}
+
+func checkInjectionMode(mode string) error {
+	switch mode {
+	case "", profile.InjectionStrip, profile.InjectionWrap, profile.InjectionOff:
+		return nil
+	}
+	return fmt.Errorf("unknown mode %q (want strip, wrap, or off)", mode)
+}
+
+// systemPrompt returns system with the note on untrusted content added,
+// unless it already has it.
+func (g *injectionGuard) systemPrompt(system string) string {
+	if g == nil || strings.Contains(system, guardrails.UntrustedNote) {
+		return system
+	}
+	if system == "" {
+		return guardrails.UntrustedNote
+	}
+	return system + "\n\n" + guardrails.UntrustedNote
+}
+
+// mode returns the mode for result of call, "off" when it is trusted. An
+// exact tool ID wins over a pattern, and of several patterns the
+// alphabetically first that matches applies.
+func (g *injectionGuard) mode(call tool.Call, result tool.Result) string {
+	mode, ok := g.spec.Tools[call.ToolID]
+	if !ok {
+		patterns := make([]string, 0, len(g.spec.Tools))
+		for pattern := range g.spec.Tools {
+			patterns = append(patterns, pattern)
+		}
+		slices.Sort(patterns)
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, call.ToolID); matched {
+				mode, ok = g.spec.Tools[pattern], true
+				break
+			}
+		}
+	}
+	if !ok && g.spec.OutsideWorkspace != "" && call.ToolID == "core/read" && g.outside(result) {
+		mode, ok = g.spec.OutsideWorkspace, true
+	}
+	switch {
+	case !ok:
+		return profile.InjectionOff
+	case mode == "":
+		return profile.InjectionStrip
+	}
+	return mode
+}
+
+// outside reports whether a core/read result is of a file outside the
+// run's workspace, or its working directory when it has no workspace.
+func (g *injectionGuard) outside(result tool.Result) bool {
+	file, _ := result.Data["path"].(string)
+	if file == "" || g.root == "" {
+		return false
+	}
+	rel, err := filepath.Rel(g.root, file)
+	return err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// checkTool returns the output of an untrusted tool result wrapped as
+// untrusted content, with likely injections stripped in "strip" mode and
+// reported either way. A delimiter in the output is always removed so it
+// cannot close the wrapping early.
+func (g *injectionGuard) checkTool(ctx context.Context, sink events.Sink, call tool.Call, result tool.Result) (string, error) {
+	if g == nil {
+		return result.Output, nil
+	}
+	mode := g.mode(call, result)
+	if mode == profile.InjectionOff {
+		return result.Output, nil
+	}
+	findings := guardrails.ScanInjection(result.Output)
+	removed := findings
+	if mode == profile.InjectionWrap {
+		removed = slices.DeleteFunc(slices.Clone(findings), func(f guardrails.Finding) bool { return f.Rule != "delimiter" })
+	}
+	if len(findings) > 0 {
+		rules := make([]string, 0, len(findings))
+		summary := make([]map[string]any, len(findings))
+		for i, f := range findings {
+			summary[i] = map[string]any{"kind": f.Kind, "rule": f.Rule}
+			if !slices.Contains(rules, f.Rule) {
+				rules = append(rules, f.Rule)
+			}
+		}
+		message := fmt.Sprintf("tool:%s: %d possible prompt injection(s) (%s); %s", call.ToolID, len(findings), strings.Join(rules, ", "), mode)
+		data := map[string]any{"source": "tool:" + call.ToolID, "callID": call.ID, "mode": mode, "findings": summary}
+		if err := sink.Publish(ctx, events.Event{Type: events.TypeSecurityWarning, Time: time.Now(), Message: message, Data: data}); err != nil {
+			return "", err
+		}
+	}
+	return guardrails.Wrap(call.ToolID, guardrails.Redact(result.Output, removed)), nil
+}
@@ -0,0 +1,49 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bitop-dev/agent/internal/language"
+	"github.com/bitop-dev/agent/pkg/events"
+	"github.com/bitop-dev/agent/pkg/provider"
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+)
+
+// languageHint tracks the language the user writes in. Messages too short
+// to tell keep the last detected language.
+type languageHint struct {
+	match   bool
+	query   string
+	current language.Language
+}
+
+func newLanguageHint(req pkgruntime.RunRequest) *languageHint {
+	if !req.Profile.Spec.Language.Enabled() {
+		return nil
+	}
+	return &languageHint{match: req.Profile.Spec.Language.Match}
+}
+
+// note detects the language of the latest user message, publishes a
+// TypeLanguage event when it changes, and returns the reply-language note
+// for the turn when the profile asks for one. The note is sent after the
+// conversation rather than in the system prompt, so a change of language
+// leaves the cached prefix intact.
+func (h *languageHint) note(ctx context.Context, sink events.Sink, transcript []provider.Message) *provider.Message {
+	if h == nil {
+		return nil
+	}
+	if query := latestUserMessage(transcript); query != h.query {
+		h.query = query
+		if detected, ok := language.Detect(query); ok && detected != h.current {
+			h.current = detected
+			_ = sink.Publish(ctx, events.Event{Type: events.TypeLanguage, Time: time.Now(), Message: "user language: " + detected.Name, Data: map[string]any{"language": detected.Code, "name": detected.Name}})
+		}
+	}
+	if !h.match || h.current.Code == "" {
+		return nil
+	}
+	return &provider.Message{Role: "user", Content: fmt.Sprintf("The user is writing in %s. Reply in %s unless they ask for another language; keep code, identifiers, and tool arguments unchanged.", h.current.Name, h.current.Name)}
+}
@@ -0,0 +1,104 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bitop-dev/agent/internal/tools/core"
+	"github.com/bitop-dev/agent/pkg/events"
+	"github.com/bitop-dev/agent/pkg/provider"
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+	"github.com/bitop-dev/agent/pkg/session"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// planNote introduces the plan restated in the system prompt.
+const planNote = "Current plan (update it with core/plan as tasks progress):\n"
+
+// planTracker keeps the task list the model records with core/plan.
+type planTracker struct {
+	items    []session.PlanItem
+	inject   bool
+	appended string // what systemPrompt last added to the prompt
+}
+
+// newPlanTracker starts from the latest core/plan call in the transcript,
+// or else the plan the request carries over.
+func newPlanTracker(req pkgruntime.RunRequest) *planTracker {
+	p := &planTracker{items: req.Plan, inject: req.Profile.Spec.Tools.InjectPlan}
+	if items, ok := latestPlan(req.Transcript); ok {
+		p.items = items
+	}
+	return p
+}
+
+// latestPlan returns the items of the last core/plan call in transcript
+// that was well formed.
+func latestPlan(transcript []provider.Message) ([]session.PlanItem, bool) {
+	for i := len(transcript) - 1; i >= 0; i-- {
+		calls := transcript[i].ToolCalls
+		for j := len(calls) - 1; j >= 0; j-- {
+			if calls[j].ToolID != core.PlanToolID {
+				continue
+			}
+			if items, err := core.PlanItems(calls[j].Arguments); err == nil {
+				return items, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// record takes the plan from the result of a core/plan call, publishes a
+// TypePlanUpdated event, and stores it in the session. Other calls, and
+// core/plan calls that failed or were aborted, are ignored.
+func (p *planTracker) record(ctx context.Context, req pkgruntime.RunRequest, sink events.Sink, sessionID string, turn int, call tool.Call, result tool.Result) error {
+	items, ok := result.Data["plan"].([]session.PlanItem)
+	if call.ToolID != core.PlanToolID || !ok || isAborted(result) {
+		return nil
+	}
+	p.items = items
+	plan := session.Plan{Turn: turn, Items: items}
+	done := 0
+	for _, item := range items {
+		if item.Status == session.PlanCompleted {
+			done++
+		}
+	}
+	message := fmt.Sprintf("plan: %d of %d tasks completed", done, len(items))
+	if err := sink.Publish(ctx, events.Event{Type: events.TypePlanUpdated, Time: time.Now(), Message: message, Data: plan}); err != nil {
+		return err
+	}
+	if req.Sessions != nil {
+		data, err := json.Marshal(plan)
+		if err != nil {
+			return nil
+		}
+		_ = req.Sessions.Append(ctx, sessionID, session.Entry{
+			Kind:      session.EntryEvent,
+			EventType: session.EventPlanUpdated,
+			Content:   message,
+			Metadata:  string(data),
+			CreatedAt: time.Now(),
+		})
+	}
+	return nil
+}
+
+// systemPrompt returns system with the current plan at the end when the
+// profile injects it, replacing the plan it added on an earlier turn.
+func (p *planTracker) systemPrompt(system string) string {
+	system = strings.TrimSuffix(system, p.appended)
+	p.appended = ""
+	if !p.inject || len(p.items) == 0 {
+		return system
+	}
+	p.appended = planNote + core.FormatPlan(p.items)
+	if system != "" {
+		p.appended = "\n\n" + p.appended
+	}
+	return system + p.appended
+}
@@ -0,0 +1,78 @@
+package runtime
+
+import (
+	"context"
+	"strings"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+)
+
+// replyPrefill starts a run's first reply with the prompt's prefill text.
+// A provider that supports assistant pre-fill is sent the text as a
+// trailing assistant message to continue; any other is asked to continue
+// it, and the stream is filtered so that a model repeating the text
+// anyway does not produce it twice.
+type replyPrefill struct {
+	text   string
+	native bool
+	shown  bool // the text has been added to the run's output
+	// repeat is what the stream may repeat of text before continuing it:
+	// all of it when emulated; natively, its trailing whitespace, which
+	// providers such as Anthropic trim from a pre-fill, so a model
+	// continuing "```json" writes the newline of "```json\n" again.
+	repeat string
+	// pending is stream text that may still turn out to repeat text;
+	// decided is set once it is known.
+	pending string
+	decided bool
+}
+
+func newReplyPrefill(ctx context.Context, req pkgruntime.RunRequest, model string) *replyPrefill {
+	p := &replyPrefill{text: pkgruntime.PromptPrefill(ctx, req)}
+	if prefiller, ok := req.Provider.(provider.Prefiller); ok {
+		p.native = prefiller.SupportsPrefill(model)
+	}
+	p.repeat = p.text
+	if p.native {
+		p.repeat = p.text[len(strings.TrimRight(p.text, " \t\r\n")):]
+	}
+	return p
+}
+
+// messages returns the messages of a turn primed with the text.
+func (p *replyPrefill) messages(messages []provider.Message) []provider.Message {
+	p.pending, p.decided = "", p.repeat == ""
+	primed := append([]provider.Message{}, messages...)
+	if p.native {
+		return append(primed, provider.Message{Role: "assistant", Content: p.text})
+	}
+	return append(primed, provider.Message{Role: "user", Content: "Your reply has already begun with the text between the markers below. Continue it from exactly where it ends, without repeating any of it.\n<<<\n" + p.text + "\n>>>"})
+}
+
+// filter returns the part of streamed text to keep. It holds text back
+// while it could still be the model repeating the prefill.
+func (p *replyPrefill) filter(text string) string {
+	if p.decided {
+		return text
+	}
+	p.pending += text
+	switch {
+	case strings.HasPrefix(p.pending, p.repeat):
+		p.decided = true
+		return strings.TrimPrefix(p.pending, p.repeat)
+	case strings.HasPrefix(p.repeat, p.pending):
+		return ""
+	}
+	p.decided = true
+	return p.pending
+}
+
+// flush returns the text filter still holds when the stream ends.
+func (p *replyPrefill) flush() string {
+	if p.decided {
+		return ""
+	}
+	p.decided = true
+	return p.pending
+}
@@ -0,0 +1,85 @@
+package runtime
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"sort"
+
+	"github.com/bitop-dev/agent/internal/models"
+	"github.com/bitop-dev/agent/pkg/provider"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// canonicalTools returns defs sorted by ID. Providers cache the request
+// prefix (tools, system prompt, then messages), so tool definitions are
+// sent in the same order every turn however selection and toggles
+// assembled them.
+func canonicalTools(defs []tool.Definition) []tool.Definition {
+	out := append([]tool.Definition(nil), defs...)
+	sort.SliceStable(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// prefixTracker notes which parts of the cacheable prefix changed since the
+// previous turn, so a turn with a low cache hit ratio can be explained.
+type prefixTracker struct {
+	started bool
+	system  [sha256.Size]byte
+	tools   [sha256.Size]byte
+	summary [sha256.Size]byte
+}
+
+// update records this turn's prefix and returns the parts that differ from
+// the last turn's: "system prompt", "tools", or "summary" (the compaction
+// summary at the head of the transcript).
+func (p *prefixTracker) update(system string, tools []tool.Definition, summary []provider.Message) []string {
+	toolData, _ := json.Marshal(tools)
+	summaryData, _ := json.Marshal(summary)
+	next := prefixTracker{
+		started: true,
+		system:  sha256.Sum256([]byte(system)),
+		tools:   sha256.Sum256(toolData),
+		summary: sha256.Sum256(summaryData),
+	}
+	var changed []string
+	if p.started {
+		if next.system != p.system {
+			changed = append(changed, "system prompt")
+		}
+		if next.tools != p.tools {
+			changed = append(changed, "tools")
+		}
+		if next.summary != p.summary {
+			changed = append(changed, "summary")
+		}
+	}
+	*p = next
+	return changed
+}
+
+// addCacheData adds the turn's prompt cache usage to a usage event's data.
+// The hit ratio is the share of input tokens read from the cache; it stays
+// near zero across turns when the prefix keeps changing. Cached input is
+// priced at the model's cache rate, and input written to the cache at its
+// cache write rate.
+func addCacheData(data map[string]any, providerName, model string, inputTokens, cacheReadTokens, cacheWriteTokens int, prefixChanged []string) map[string]any {
+	if cacheReadTokens > 0 {
+		data["cacheReadTokens"] = cacheReadTokens
+		if cost, ok := data["costUSD"].(float64); ok {
+			data["costUSD"] = cost - models.CacheDiscount(providerName, model, cacheReadTokens)
+		}
+	}
+	if cacheWriteTokens > 0 {
+		data["cacheWriteTokens"] = cacheWriteTokens
+		if cost, ok := data["costUSD"].(float64); ok {
+			data["costUSD"] = cost + models.CacheWritePremium(providerName, model, cacheWriteTokens)
+		}
+	}
+	if inputTokens > 0 {
+		data["cacheHitRatio"] = float64(cacheReadTokens) / float64(inputTokens)
+	}
+	if len(prefixChanged) > 0 {
+		data["prefixChanged"] = prefixChanged
+	}
+	return data
+}
@@ -0,0 +1,70 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bitop-dev/agent/internal/models"
+	"github.com/bitop-dev/agent/pkg/events"
+	"github.com/bitop-dev/agent/pkg/provider"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// progressInterval is how many streamed deltas pass between progress
+// events; the first delta of a turn always reports.
+const progressInterval = 16
+
+// progressMeter estimates a turn's context and output tokens while it
+// streams, for a live meter that does not wait for the provider's usage.
+// Output is counted at four characters per token, like the transcript
+// estimate; counts the provider reports mid-stream replace the estimates
+// once they are larger.
+type progressMeter struct {
+	provider      string
+	model         string
+	turn          int
+	contextTokens int
+	chars         int
+	deltas        int
+	reported      struct{ input, output int }
+}
+
+// newProgressMeter starts a meter for a turn sending system, messages, and
+// tools to providerName's model.
+func newProgressMeter(providerName, model string, turn int, system string, messages []provider.Message, tools []tool.Definition) *progressMeter {
+	return &progressMeter{
+		provider:      providerName,
+		model:         model,
+		turn:          turn,
+		contextTokens: len(system)/4 + estimateTranscriptTokens(messages) + definitionTokens(tools),
+	}
+}
+
+// usage records running counts from a StreamEventUsage.
+func (m *progressMeter) usage(event provider.StreamEvent) {
+	m.reported.input = max(m.reported.input, event.InputTokens)
+	m.reported.output = max(m.reported.output, event.OutputTokens)
+}
+
+// delta counts a streamed chunk of text or reasoning and publishes a
+// TypeProgress event on the first and every progressInterval-th delta.
+func (m *progressMeter) delta(ctx context.Context, sink events.Sink, text string) error {
+	m.chars += len(text)
+	m.deltas++
+	if (m.deltas-1)%progressInterval != 0 {
+		return nil
+	}
+	contextTokens := max(m.contextTokens, m.reported.input)
+	outputTokens := max(m.chars/4, m.reported.output)
+	data := map[string]any{"model": m.model, "turn": m.turn, "contextTokens": contextTokens, "estimatedOutputTokens": outputTokens, "deltas": m.deltas}
+	if cost, priced := models.Cost(m.provider, m.model, contextTokens, outputTokens); priced {
+		data["estimatedCostUSD"] = cost
+	}
+	return sink.Publish(ctx, events.Event{
+		Type:    events.TypeProgress,
+		Time:    time.Now(),
+		Message: fmt.Sprintf("~%d context / ~%d out", contextTokens, outputTokens),
+		Data:    data,
+	})
+}
@@ -0,0 +1,170 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bitop-dev/agent/internal/models"
+	"github.com/bitop-dev/agent/pkg/config"
+	"github.com/bitop-dev/agent/pkg/events"
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// loopConfig is what the loop derives from the request's profile, tools,
+// and model; it is rebuilt when the configuration is reloaded.
+type loopConfig struct {
+	toolsByID  map[string]tool.Tool
+	toolDefs   []tool.Definition
+	defsBudget *toolBudget
+	selection  *toolSelection
+	models     []string // primary model first, then fallbacks
+	compaction bool
+}
+
+func configureLoop(ctx context.Context, req pkgruntime.RunRequest, sink events.Sink) (loopConfig, error) {
+	loop := loopConfig{
+		toolsByID:  make(map[string]tool.Tool, len(req.Tools)),
+		toolDefs:   make([]tool.Definition, 0, len(req.Tools)),
+		compaction: req.Profile.Spec.Session.Compaction == "auto",
+	}
+	for _, t := range req.Tools {
+		def := t.Definition()
+		loop.toolsByID[def.ID] = t
+		loop.toolDefs = append(loop.toolDefs, def)
+	}
+	defsBudget, budgetNote, err := newToolBudget(req.Profile.Spec.Tools.Budget, loop.toolDefs)
+	if err != nil {
+		return loopConfig{}, err
+	}
+	if budgetNote != "" {
+		_ = sink.Publish(ctx, events.Event{Type: events.TypeToolBudget, Time: time.Now(), Message: budgetNote})
+	}
+	if meta := defsBudget.searchTool(); meta != nil {
+		loop.toolsByID[searchToolsID] = meta
+	}
+	loop.defsBudget = defsBudget
+	loop.selection = newToolSelection(req)
+
+	// Build model chain: primary + fallbacks.
+	// ModelOverride (from config/CLI/env) takes priority over profile's model.
+	primaryModel := req.Profile.Spec.Provider.Model
+	if req.ModelOverride != "" {
+		primaryModel = req.ModelOverride
+	}
+	if primaryModel == "" {
+		primaryModel = "gpt-4o" // ultimate fallback
+	}
+	chain := config.ExpandModelChain(req.ModelAliases, req.ModelFallbacks, append([]string{primaryModel}, req.Profile.Spec.Provider.Fallback...), func(model string) bool {
+		return models.Serves(req.Provider.Name(), model)
+	})
+	loop.models = resolveModels(ctx, sink, chain)
+	return loop, nil
+}
+
+type reloaded struct {
+	req     pkgruntime.RunRequest
+	loop    loopConfig
+	helpers runHelpers
+}
+
+// runHelpers are the helpers of a run that read the profile; they are
+// rebuilt when the configuration is reloaded.
+type runHelpers struct {
+	guard    *guardrail
+	inject   *injectionGuard
+	thinking *thinkingGovernor
+	plan     *planTracker
+	budget   *budgetGuard
+}
+
+// reloadHelpers builds the helpers for next, carrying over what prev has
+// tracked so far: spend, the plan, and a lowered thinking level.
+func reloadHelpers(next pkgruntime.RunRequest, prev runHelpers) (runHelpers, error) {
+	var h runHelpers
+	var err error
+	if h.guard, err = newGuardrail(next); err != nil {
+		return runHelpers{}, err
+	}
+	if h.inject, err = newInjectionGuard(next); err != nil {
+		return runHelpers{}, err
+	}
+	if h.thinking, err = newThinkingGovernor(next); err != nil {
+		return runHelpers{}, err
+	}
+	if h.thinking != nil && prev.thinking != nil {
+		h.thinking.crossed = prev.thinking.crossed
+		if prev.thinking.lowered && thinkingRank(prev.thinking.level) >= thinkingRank(h.thinking.min) {
+			h.thinking.lowered, h.thinking.level = true, prev.thinking.level
+		}
+	}
+	h.plan = &planTracker{items: prev.plan.items, inject: next.Profile.Spec.Tools.InjectPlan}
+	if h.budget = newBudgetGuard(next); h.budget != nil && prev.budget != nil {
+		h.budget.session, h.budget.tools, h.budget.warned, h.budget.waived = prev.budget.session, prev.budget.tools, prev.budget.warned, prev.budget.waived
+	}
+	return h, nil
+}
+
+// reloadConfig returns req with the configuration req.Reloader has
+// pending, if any, and helpers rebuilt from it. An invalid configuration is
+// reported and skipped, so the run continues with the settings it had.
+func reloadConfig(ctx context.Context, req pkgruntime.RunRequest, sink events.Sink, helpers runHelpers) (reloaded, bool) {
+	reload, ok, err := req.Reloader.Check()
+	if err != nil {
+		_ = sink.Publish(ctx, events.Event{Type: events.TypeError, Time: time.Now(), Message: fmt.Sprintf("config reload failed, keeping the previous settings: %v", err)})
+		return reloaded{}, false
+	}
+	if !ok {
+		return reloaded{}, false
+	}
+	next := reload.Apply(req)
+	loop, err := configureLoop(ctx, next, sink)
+	if err == nil {
+		helpers, err = reloadHelpers(next, helpers)
+	}
+	if err != nil {
+		_ = sink.Publish(ctx, events.Event{Type: events.TypeError, Time: time.Now(), Message: fmt.Sprintf("config reload failed, keeping the previous settings: %v", err)})
+		return reloaded{}, false
+	}
+	var changes []string
+	if next.Provider != nil && next.Provider.Name() != req.Provider.Name() {
+		changes = append(changes, "provider "+next.Provider.Name())
+	}
+	if model := resolveModel(next); model != resolveModel(req) {
+		changes = append(changes, "model "+model)
+	}
+	if next.Profile.Spec.Session.Compaction != req.Profile.Spec.Session.Compaction {
+		changes = append(changes, fmt.Sprintf("compaction %q", next.Profile.Spec.Session.Compaction))
+	}
+	if !sameTools(next.Tools, req.Tools) {
+		changes = append(changes, fmt.Sprintf("tools (%d)", len(next.Tools)))
+	}
+	if next.SystemPrompt != req.SystemPrompt {
+		changes = append(changes, "system prompt")
+	}
+	message := "configuration reloaded, nothing the run uses changed"
+	if len(changes) > 0 {
+		message = "configuration reloaded: " + strings.Join(changes, ", ")
+	}
+	_ = sink.Publish(ctx, events.Event{Type: events.TypeConfigReloaded, Time: time.Now(), Message: message, Data: map[string]any{
+		"profile":    next.Profile.Metadata.Name,
+		"model":      loop.models[0],
+		"tools":      len(next.Tools),
+		"compaction": next.Profile.Spec.Session.Compaction,
+	}})
+	return reloaded{req: next, loop: loop, helpers: helpers}, true
+}
+
+func sameTools(a, b []tool.Tool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Definition().ID != b[i].Definition().ID {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,230 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/events"
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// retryGuidanceAfter is the number of consecutive failures of one tool
+// before its error result is enriched. A single failure is usually fixed by
+// the plain error message; repeated ones are where models start guessing.
+const retryGuidanceAfter = 2
+
+// retryTracker counts consecutive failures per tool and remembers the last
+// arguments each tool accepted.
+type retryTracker struct {
+	advisor  pkgruntime.RetryAdvisor
+	failures map[string]int
+	lastGood map[string]map[string]any
+}
+
+// transientRetries is how many times a call failing with tool.ErrTransient
+// is repeated, with a linearly growing delay, before the error reaches the
+// model.
+const (
+	transientRetries    = 2
+	transientRetryDelay = 200 * time.Millisecond
+)
+
+// runWithTransientRetry runs call, repeating it while it fails transiently
+// if the tool is idempotent or cacheable: a transient failure of any other
+// tool may have come after its effect, such as a POST the backend applied.
+// ctx governs the wait between attempts; runCtx is passed to the tool.
+func runWithTransientRetry(ctx, runCtx context.Context, sink events.Sink, impl tool.Tool, call tool.Call) (tool.Result, error) {
+	result, err := impl.Run(runCtx, call)
+	if caps := tool.CapabilitiesOf(impl); !caps.Idempotent && !caps.Cacheable {
+		return result, err
+	}
+	for attempt := 1; attempt <= transientRetries && tool.CodeOf(err) == tool.ErrTransient; attempt++ {
+		_ = sink.Publish(ctx, events.Event{Type: events.TypeToolRetry, Time: time.Now(), Message: fmt.Sprintf("%s failed transiently, retrying (%d/%d): %v", call.ToolID, attempt, transientRetries, err), Data: map[string]any{"tool_id": call.ToolID, "attempt": attempt, "error": err.Error()}})
+		select {
+		case <-ctx.Done():
+			return result, err
+		case <-time.After(time.Duration(attempt) * transientRetryDelay):
+		}
+		result, err = impl.Run(runCtx, call)
+	}
+	return result, err
+}
+
+func newRetryTracker(req pkgruntime.RunRequest) *retryTracker {
+	advisor := req.RetryAdvisor
+	if advisor == nil {
+		advisor = SchemaRetryAdvisor{}
+	}
+	return &retryTracker{advisor: advisor, failures: map[string]int{}, lastGood: map[string]map[string]any{}}
+}
+
+// observe records the outcome of a call and, once a tool has failed
+// repeatedly, appends the advisor's guidance to the result the model sees.
+func (t *retryTracker) observe(ctx context.Context, sink events.Sink, impl tool.Tool, call tool.Call, result tool.Result) tool.Result {
+	errMsg, failed := result.Data["error"].(string)
+	if !failed {
+		delete(t.failures, call.ToolID)
+		t.lastGood[call.ToolID] = call.Arguments
+		return result
+	}
+	t.failures[call.ToolID]++
+	attempts := t.failures[call.ToolID]
+	if attempts < retryGuidanceAfter || impl == nil {
+		return result
+	}
+	advice := strings.TrimSpace(t.advisor.Advise(ctx, pkgruntime.ToolFailure{
+		Call:       call,
+		Definition: impl.Definition(),
+		Error:      errMsg,
+		Code:       tool.ErrorCode(stringArg(result.Data, "errorCode")),
+		Attempts:   attempts,
+		LastGood:   t.lastGood[call.ToolID],
+	}))
+	if advice == "" {
+		return result
+	}
+	result.Output += "\n\n" + advice
+	result.Data["retryGuidance"] = true
+	_ = sink.Publish(ctx, events.Event{Type: events.TypeRetryGuidance, Time: time.Now(), Message: fmt.Sprintf("%s failed %d times; added retry guidance", call.ToolID, attempts), Data: map[string]any{"tool_id": call.ToolID, "attempts": attempts}})
+	return result
+}
+
+// SchemaRetryAdvisor derives retry guidance from the tool definition: the
+// expected arguments, what was wrong with the failing call, the last
+// arguments that worked, and the tool's description.
+type SchemaRetryAdvisor struct{}
+
+func (SchemaRetryAdvisor) Advise(_ context.Context, f pkgruntime.ToolFailure) string {
+	properties, _ := f.Definition.Schema["properties"].(map[string]any)
+	required := schemaRequired(f.Definition.Schema)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Retry guidance: %s has failed %d times in a row. Check the call before retrying.\n", f.Call.ToolID, f.Attempts)
+	if f.Code != "" && f.Code != tool.ErrUnknown {
+		fmt.Fprintf(&b, "- Error kind: %s\n", f.Code)
+	}
+	if len(properties) > 0 {
+		names := make([]string, 0, len(properties))
+		for name := range properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		parts := make([]string, 0, len(names))
+		for _, name := range names {
+			part := name
+			if typ := schemaType(properties[name]); typ != "" {
+				part += " (" + typ + ")"
+			}
+			if required[name] {
+				part += " required"
+			}
+			parts = append(parts, part)
+		}
+		fmt.Fprintf(&b, "- Expected arguments: %s\n", strings.Join(parts, ", "))
+	}
+	if problems := argumentProblems(f.Call.Arguments, properties, required); len(problems) > 0 {
+		fmt.Fprintf(&b, "- Problems with this call: %s\n", strings.Join(problems, "; "))
+	}
+	if f.LastGood != nil {
+		if data, err := json.Marshal(f.LastGood); err == nil {
+			fmt.Fprintf(&b, "- Last arguments that worked: %s\n", compactRuntimeText(string(data), 400))
+		}
+	}
+	if desc := strings.TrimSpace(f.Definition.Description); desc != "" {
+		fmt.Fprintf(&b, "- Tool description: %s\n", compactRuntimeText(desc, 400))
+	}
+	b.WriteString("If the same approach keeps failing, try a different tool or explain the blocker instead of repeating the call.")
+	return b.String()
+}
+
+// argumentProblems reports missing required, unknown, and mistyped arguments.
+func argumentProblems(args, properties map[string]any, required map[string]bool) []string {
+	var problems []string
+	var missing []string
+	for name := range required {
+		if _, ok := args[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		problems = append(problems, fmt.Sprintf("missing required %q", name))
+	}
+	if len(properties) == 0 {
+		return problems
+	}
+	names := make([]string, 0, len(args))
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		prop, known := properties[name]
+		if !known {
+			problems = append(problems, fmt.Sprintf("unknown argument %q", name))
+			continue
+		}
+		if want := schemaType(prop); want != "" && !matchesType(args[name], want) {
+			problems = append(problems, fmt.Sprintf("%q should be %s, got %s", name, want, jsonType(args[name])))
+		}
+	}
+	return problems
+}
+
+func schemaRequired(schema map[string]any) map[string]bool {
+	required := map[string]bool{}
+	switch list := schema["required"].(type) {
+	case []string:
+		for _, name := range list {
+			required[name] = true
+		}
+	case []any:
+		for _, name := range list {
+			if s, ok := name.(string); ok {
+				required[s] = true
+			}
+		}
+	}
+	return required
+}
+
+func schemaType(prop any) string {
+	m, _ := prop.(map[string]any)
+	typ, _ := m["type"].(string)
+	return typ
+}
+
+func matchesType(value any, want string) bool {
+	got := jsonType(value)
+	return got == want || (want == "number" && got == "integer")
+}
+
+// jsonType names a decoded JSON value's type as JSON Schema would.
+func jsonType(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case int, int64:
+		return "integer"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
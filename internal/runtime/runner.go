@@ -1,20 +1,28 @@
 package runtime
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
-	"math"
-	"math/rand"
-
+	"github.com/bitop-dev/agent/internal/models"
+	internaltranscript "github.com/bitop-dev/agent/internal/transcript"
 	"github.com/bitop-dev/agent/pkg/approval"
 	"github.com/bitop-dev/agent/pkg/events"
 	"github.com/bitop-dev/agent/pkg/policy"
+	"github.com/bitop-dev/agent/pkg/profile"
 	"github.com/bitop-dev/agent/pkg/provider"
 	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
 	"github.com/bitop-dev/agent/pkg/session"
@@ -46,6 +54,16 @@ func (Runner) Run(ctx context.Context, req pkgruntime.RunRequest) (pkgruntime.Ru
 		return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, req.Transcript...)}, errors.New("prompt is required")
 	}
 
+	// A history passed to a new session was built outside the runtime (by
+	// an importer, a database, another system); check it before a
+	// provider rejects it mid-run.
+	seeded := createSession && len(req.Transcript) > 0
+	if seeded {
+		if err := internaltranscript.Validate(req.Transcript); err != nil {
+			return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, req.Transcript...)}, fmt.Errorf("invalid seed transcript: %w", err)
+		}
+	}
+
 	if req.Sessions != nil && createSession {
 		_, err := req.Sessions.Create(ctx, session.Metadata{
 			ID:        sessionID,
@@ -57,83 +75,225 @@ func (Runner) Run(ctx context.Context, req pkgruntime.RunRequest) (pkgruntime.Ru
 		if err != nil {
 			return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, req.Transcript...)}, err
 		}
+		// Backfill the seeded history so resuming this session replays it.
+		if seeded {
+			for _, entry := range internaltranscript.ToEntries(req.Transcript, now) {
+				if err := req.Sessions.Append(ctx, sessionID, entry); err != nil {
+					return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, req.Transcript...)}, fmt.Errorf("persist seed transcript: %w", err)
+				}
+			}
+		}
+	}
+	if closer, ok := req.Sessions.(session.Closer); ok {
+		defer func() {
+			if err := closer.Close(context.WithoutCancel(ctx), sessionID); err != nil {
+				_ = sink.Publish(ctx, events.Event{Type: events.TypeError, Time: time.Now(), Message: "close session: " + err.Error(), Data: map[string]any{"session_id": sessionID}})
+			}
+		}()
+	}
+	if !req.KeepToolSessions {
+		defer tool.CloseSession(req.Tools, sessionID)
 	}
+	labels := pkgruntime.PromptLabels(ctx, req)
 	if req.Sessions != nil {
-		_ = req.Sessions.Append(ctx, sessionID, session.Entry{Kind: session.EntryMessage, Role: "user", Content: req.Prompt, CreatedAt: now})
+		entry := session.Entry{Kind: session.EntryMessage, Role: "user", Content: req.Prompt, CreatedAt: now}
+		entry.Metadata = encodeSessionMetadata(session.MessageMetadata{Images: req.Images, Documents: req.Documents, Labels: labels})
+		_ = req.Sessions.Append(ctx, sessionID, entry)
 	}
 
 	transcript := append([]provider.Message{}, req.Transcript...)
-	transcript = append(transcript, provider.Message{Role: "user", Content: req.Prompt})
-	compactionEnabled := req.Profile.Spec.Session.Compaction == "auto"
+	transcript = append(transcript, provider.Message{Role: "user", Content: req.Prompt, Images: req.Images, Documents: req.Documents, Labels: labels})
 	// Rough token estimate: 1 token ≈ 4 chars. Reserve 16k for the response,
 	// keep the most recent ~20k tokens verbatim. Trigger compaction when the
 	// estimated total exceeds 80k tokens (320k chars), matching pi-mono's approach.
-	const reserveTokens = 16384
-	const keepRecentTokens = 20000
-	const contextTokenThreshold = 80000
-	toolsByID := make(map[string]tool.Tool, len(req.Tools))
-	toolDefs := make([]tool.Definition, 0, len(req.Tools))
-	for _, t := range req.Tools {
-		def := t.Definition()
-		toolsByID[def.ID] = t
-		toolDefs = append(toolDefs, def)
+	const reserveTokens = pkgruntime.CompactionReserve
+	const keepRecentTokens = pkgruntime.CompactionKeepRecent
+	const contextTokenThreshold = pkgruntime.CompactionThreshold
+	loop, err := configureLoop(ctx, req, sink)
+	if err != nil {
+		return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
+	}
+	toolsByID, toolDefs, defsBudget, selection, modelChain, compactionEnabled := loop.toolsByID, loop.toolDefs, loop.defsBudget, loop.selection, loop.models, loop.compaction
+	langHint := newLanguageHint(req)
+	basePrompt := req.SystemPrompt // without the notes added each turn
+	toolChoice := pkgruntime.PromptToolChoice(ctx, req)
+	prefill := newReplyPrefill(ctx, req, modelChain[0])
+	if err := checkToolChoice(toolChoice, toolsByID, req.Toggles); err != nil {
+		return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
+	}
+	guard, err := newGuardrail(req)
+	if err != nil {
+		return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
+	}
+	guardStopped := false
+	inject, err := newInjectionGuard(req)
+	if err != nil {
+		return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
+	}
+	thinking, err := newThinkingGovernor(req)
+	if err != nil {
+		return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
 	}
 
 	var output strings.Builder
 	var toolHistory []tool.Result
-	var totalInputTokens, totalOutputTokens int
+	var totalInputTokens, totalOutputTokens, totalReasoningTokens, totalCacheReadTokens, totalCacheWriteTokens int
 	var usedModel string
+	var filesChanged []string
+	var artifacts []session.Artifact // declared by tools, one per path
+	stopReason, turns := pkgruntime.StopMaxTurns, 0
 	const maxTurns = 8
 	const maxRetries = 3
-	const baseRetryDelayMs = 500
 	const maxExplorationToolCalls = 6
 
-	// Build model chain: primary + fallbacks.
-	// ModelOverride (from config/CLI/env) takes priority over profile's model.
-	primaryModel := req.Profile.Spec.Provider.Model
-	if req.ModelOverride != "" {
-		primaryModel = req.ModelOverride
+	// Some proxies and models intermittently return an empty stream. When
+	// enabled, the turn is retried once with a nudge instead of ending the run.
+	emptyRetried := false
+	streamRetries := 0
+	var nudge *provider.Message
+	budget := newBudgetGuard(req)
+	// contexts records each call for `agent debug`; the first prefix
+	// messages of the transcript are a compaction summary, not history.
+	var contexts turnContexts
+	prefix := 0
+	retries := newRetryTracker(req)
+	argChecks := newArgumentCheck(req.Profile.Spec.Tools.ArgumentRetries)
+	var prefixes prefixTracker
+	cache := newToolCache(req)
+	var totalCost float64
+	watcher := newWorkspaceWatcher(req)
+	plan := newPlanTracker(req)
+	limits := newRunLimits(req)
+	deadlineStopped := false
+	stopTurn := func() {}
+	defer func() { stopTurn() }()
+	// A daily budget already spent by earlier sessions stops the run before
+	// the first call.
+	budgetStopped, err := budget.check(ctx, req, sink, 0)
+	if err != nil {
+		return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
 	}
-	if primaryModel == "" {
-		primaryModel = "gpt-4o" // ultimate fallback
+	if budgetStopped {
+		stopReason = pkgruntime.StopBudget
+	}
+	hosted, err := offeredHostedTools(ctx, req, sink)
+	if err != nil {
+		return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
 	}
-	models := []string{primaryModel}
-	models = append(models, req.Profile.Spec.Provider.Fallback...)
 
-	for turn := 0; turn < maxTurns; turn++ {
+	for turn := 0; turn < maxTurns && !budgetStopped; turn++ {
+		if limits.overrun() {
+			stopReason = limits.stop(ctx, req, sink, sessionID, turn+1, time.Now(), errWallClock)
+			deadlineStopped = true
+			break
+		}
 		if err := sink.Publish(ctx, events.Event{Type: events.TypeTurnStarted, Time: time.Now(), Message: fmt.Sprintf("turn %d started", turn+1)}); err != nil {
 			return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
 		}
+		// Configuration edited since the last turn applies from this one.
+		// req.SystemPrompt carries last turn's notes; reload from the
+		// prompt without them.
+		req.SystemPrompt = basePrompt
+		if next, ok := reloadConfig(ctx, req, sink, runHelpers{guard, inject, thinking, plan, budget}); ok {
+			if switched, ok := switchProvider(ctx, req, next.req, sink, sessionID, transcript); ok {
+				transcript = switched
+			} else {
+				// The run stays on its provider and model chain; the rest
+				// of the configuration still applies.
+				next.req.Provider, next.req.ModelOverride, next.req.Profile.Spec.Provider = req.Provider, req.ModelOverride, req.Profile.Spec.Provider
+				next.loop.models, next.helpers.thinking = modelChain, thinking
+			}
+			toolsByID, toolDefs, defsBudget, selection, modelChain, compactionEnabled = next.loop.toolsByID, next.loop.toolDefs, next.loop.defsBudget, next.loop.selection, next.loop.models, next.loop.compaction
+			guard, inject, thinking, plan, budget = next.helpers.guard, next.helpers.inject, next.helpers.thinking, next.helpers.plan, next.helpers.budget
+			req, basePrompt = next.req, next.req.SystemPrompt
+			if hosted, err = offeredHostedTools(ctx, req, sink); err != nil {
+				return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
+			}
+		}
+		if turn > 0 {
+			transcript, _ = injectQueued(ctx, req, sink, sessionID, req.Steering, events.TypeSteering, transcript)
+		}
+		watchErr := watcher.begin(ctx)
 		var stream <-chan provider.StreamEvent
 		var err error
+		turnStarted := time.Now()
+		stopTurn()
+		var turnCtx context.Context
+		turnCtx, stopTurn = limits.turnContext(ctx)
+		var turnInputTokens, turnOutputTokens, turnReasoningTokens, turnCacheReadTokens, turnCacheWriteTokens int
+		var turnTiming *provider.Timing
+		// The model's share of the turn: from the request that streamed
+		// to the end of the stream, less the tool calls run on the way.
+		var streamStarted time.Time
+		var toolTime time.Duration
+
+		// Every later call this turn, including resumes and forced final
+		// answers, reads the system prompt from req.
+		req.SystemPrompt = plan.systemPrompt(inject.systemPrompt(basePrompt))
+		req.Profile.Spec.Provider.Thinking = thinking.current(req.Profile.Spec.Provider.Thinking)
+		selected, selectCost := selection.filter(ctx, req, sink, budget, transcript, toggled(ctx, req, sink, toolsByID, defsBudget.definitions(toolDefs)))
+		totalCost += selectCost
+		turnTools := canonicalTools(selected)
+		// The prompt's tool choice applies to its first turn only, so that
+		// a forced tool's result can be answered.
+		var turnChoice provider.ToolChoice
+		if turn == 0 {
+			turnChoice = toolChoice
+			turnTools = withForcedTool(turnTools, turnChoice, toolsByID, req.Toggles)
+		}
+		prefixChanged := prefixes.update(req.SystemPrompt, turnTools, transcript[:min(prefix, len(transcript))])
+		messages := transcript
+		if nudge != nil {
+			messages = append(append([]provider.Message{}, transcript...), *nudge)
+			nudge = nil
+		}
+		if note := langHint.note(ctx, sink, transcript); note != nil {
+			messages = append(append([]provider.Message{}, messages...), *note)
+		}
+		// The prompt's prefill starts its first reply; sent is messages
+		// primed with it.
+		primed := turn == 0 && prefill.text != ""
+		sent := messages
+		if primed {
+			sent = prefill.messages(messages)
+		}
 
 		// Try each model in the chain with retries.
-		for _, model := range models {
+	chain:
+		for i, model := range modelChain {
 			for attempt := 0; attempt < maxRetries; attempt++ {
-				stream, err = req.Provider.Stream(ctx, provider.CompletionRequest{
-					Model:    provider.ModelRef{Provider: req.Provider.Name(), Model: model},
-					System:   req.SystemPrompt,
-					Messages: transcript,
-					Tools:    toolDefs,
+				streamStarted = time.Now()
+				stream, err = req.Provider.Stream(turnCtx, provider.CompletionRequest{
+					Model:        provider.ModelRef{Provider: req.Provider.Name(), Model: model},
+					System:       req.SystemPrompt,
+					Messages:     sent,
+					Trailing:     len(sent) - len(transcript),
+					Tools:        turnTools,
+					ToolChoice:   turnChoice,
+					HostedTools:  hosted,
+					Thinking:     provider.ThinkingLevel(req.Profile.Spec.Provider.Thinking),
+					ExtraHeaders: req.Profile.Spec.Provider.Headers,
+					ExtraQuery:   req.Profile.Spec.Provider.Query,
 				})
 				if err == nil {
 					break
 				}
-				// Permanent model errors — skip retries, go straight to fallback.
-				errMsg := err.Error()
-				if strings.Contains(errMsg, "Invalid model") || strings.Contains(errMsg, "model not found") ||
-					strings.Contains(errMsg, "does not exist") || strings.Contains(errMsg, "400 Bad Request") {
-					_ = sink.Publish(ctx, events.Event{Type: events.TypeError, Time: time.Now(), Message: fmt.Sprintf("model %s not available, trying fallback", model)})
+				// A refused key fails every model; permanent model errors
+				// skip retries and go straight to fallback.
+				if provider.ErrorKindOf(err) == provider.ErrorAuth {
+					break chain
+				}
+				delay, retry := retryDelay(err, attempt)
+				if !retry {
 					break
 				}
 				if attempt < maxRetries-1 {
-					jitter := time.Duration(rand.Intn(200)) * time.Millisecond
-					delay := time.Duration(math.Pow(2, float64(attempt)))*time.Duration(baseRetryDelayMs)*time.Millisecond + jitter
-					_ = sink.Publish(ctx, events.Event{Type: events.TypeError, Time: time.Now(), Message: fmt.Sprintf("model %s attempt %d/%d: %s", model, attempt+1, maxRetries, err)})
-					select {
-					case <-ctx.Done():
+					publishRetry(ctx, sink, model, attempt, maxRetries, delay, err)
+					if !wait(turnCtx, delay) {
+						if limits.exceeded(ctx, turnCtx) != nil {
+							break chain
+						}
 						return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, ctx.Err()
-					case <-time.After(delay):
 					}
 				}
 			}
@@ -141,67 +301,302 @@ func (Runner) Run(ctx context.Context, req pkgruntime.RunRequest) (pkgruntime.Ru
 				usedModel = model
 				break // success with this model
 			}
-			_ = sink.Publish(ctx, events.Event{Type: events.TypeError, Time: time.Now(), Message: fmt.Sprintf("model %s failed, trying next fallback", model)})
+			if i+1 < len(modelChain) {
+				reason := fallbackReason(err)
+				if reason == "" {
+					reason = fallbackRetries
+				}
+				publishFallback(ctx, sink, model, modelChain[i+1], reason, err)
+			}
 		}
 		if err != nil {
+			if cause := limits.exceeded(ctx, turnCtx); cause != nil {
+				stopReason = limits.stop(ctx, req, sink, sessionID, turn+1, turnStarted, cause)
+				deadlineStopped = true
+				break
+			}
 			return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
 		}
+		contexts.record(ctx, req, sessionID, turn+1, usedModel, transcript, sent, prefix, turnTools)
+		progress := newProgressMeter(req.Provider.Name(), usedModel, turn+1, req.SystemPrompt, sent, turnTools)
 
 		toolExecuted := false
 		var streamErr error
 		var assistantText strings.Builder
 		var assistantToolCalls []tool.Call
+		var assistantRaw []provider.RawContent
+		var argStream toolArgsStream
 		var toolMessages []provider.Message
-		for event := range stream {
-			if event.Err != nil {
-				streamErr = event.Err
-				break // don't return — let the fallback loop handle it
-			}
-			switch event.Type {
-			case provider.StreamEventText:
-				output.WriteString(event.Text)
-				assistantText.WriteString(event.Text)
-				if err := sink.Publish(ctx, events.Event{Type: events.TypeAssistantDelta, Time: time.Now(), Message: event.Text}); err != nil {
+		var turnArtifacts []session.Artifact
+		streamResumes := 0
+		toolAborted := false
+		outputStart := output.Len()
+		if primed {
+			assistantText.WriteString(prefill.text)
+			if !prefill.shown {
+				prefill.shown = true
+				output.WriteString(prefill.text)
+				if err := guard.assistantSink(sink).Publish(ctx, events.Event{Type: events.TypeAssistantDelta, Time: time.Now(), Message: prefill.text}); err != nil {
 					return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
 				}
-			case provider.StreamEventToolCall:
-				toolExecuted = true
-				assistantToolCalls = append(assistantToolCalls, event.ToolCall)
-				result, err := executeTool(ctx, req, sink, toolsByID, event.ToolCall)
-				if err != nil {
-					return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
+			}
+		}
+		primedLen, replyStart := assistantText.Len(), output.Len()
+	consume:
+		for {
+			for event := range stream {
+				if event.Err != nil {
+					streamErr = event.Err
+					if errors.Is(streamErr, provider.ErrStreamStalled) {
+						_ = sink.Publish(ctx, events.Event{Type: events.TypeStreamStalled, Time: time.Now(), Message: fmt.Sprintf("model %s: %v", usedModel, streamErr), Data: map[string]any{"model": usedModel, "turn": turn + 1}})
+					}
+					// Checkpoint: if the connection dropped mid-text, ask the
+					// provider to continue from the partial text instead of
+					// regenerating it or failing the turn.
+					if limits.exceeded(ctx, turnCtx) != nil {
+						break consume
+					}
+					if resumed, ok := resumeStream(turnCtx, req, sink, usedModel, messages, turnTools, hosted, assistantText.String(), len(assistantToolCalls), streamResumes, streamErr); ok {
+						stream = resumed
+						streamErr = nil
+						streamResumes++
+						continue consume
+					}
+					break // don't return — let the fallback loop handle it
 				}
-				toolHistory = append(toolHistory, result)
-				toolMessages = append(toolMessages, provider.Message{Role: "tool", Content: result.Output, ToolCallID: event.ToolCall.ID, ToolName: event.ToolCall.ToolID})
-			case provider.StreamEventDone:
-				totalInputTokens += event.InputTokens
-				totalOutputTokens += event.OutputTokens
+				switch event.Type {
+				case provider.StreamEventText:
+					if primed {
+						if event.Text = prefill.filter(event.Text); event.Text == "" {
+							continue
+						}
+					}
+					output.WriteString(event.Text)
+					assistantText.WriteString(event.Text)
+					if err := guard.assistantSink(sink).Publish(ctx, events.Event{Type: events.TypeAssistantDelta, Time: time.Now(), Message: event.Text}); err != nil {
+						return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
+					}
+					if err := progress.delta(ctx, sink, event.Text); err != nil {
+						return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
+					}
+				case provider.StreamEventToolCallDelta:
+					if err := argStream.delta(ctx, sink, event); err != nil {
+						return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
+					}
+				case provider.StreamEventToolCall:
+					toolExecuted = true
+					assistantToolCalls = append(assistantToolCalls, event.ToolCall)
+					call, rejected := argChecks.check(ctx, sink, toolsByID[event.ToolCall.ToolID], event.ToolCall)
+					if rejected != nil {
+						if err := auditRejected(tool.WithSession(turnCtx, sessionID), req, call, *rejected); err != nil {
+							return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
+						}
+						toolMessages = append(toolMessages, provider.Message{Role: "tool", Content: rejected.Output, ToolCallID: call.ID, ToolName: call.ToolID})
+						continue
+					}
+					event.ToolCall = call
+					toolStarted := time.Now()
+					result, err := executeTool(tool.WithSession(tool.WithModel(turnCtx, toolModel(req.Provider, usedModel)), sessionID), req, guard.toolSink(sink), toolsByID, cache, transcript, call)
+					if err != nil {
+						return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
+					}
+					toolTime += time.Since(toolStarted)
+					result = retries.observe(ctx, sink, toolsByID[event.ToolCall.ToolID], event.ToolCall, result)
+					if result.Output, err = inject.checkTool(ctx, sink, event.ToolCall, result); err != nil {
+						return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
+					}
+					if result.Output, _, err = guard.checkTool(ctx, req, sink, event.ToolCall.ToolID, result.Output); err != nil {
+						return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
+					}
+					if guard.holdsTools() {
+						if err := sink.Publish(ctx, events.Event{Type: events.TypeToolFinished, Time: time.Now(), Message: result.Output, Data: result}); err != nil {
+							return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
+						}
+					}
+					if err := plan.record(ctx, req, sink, sessionID, turn+1, event.ToolCall, result); err != nil {
+						return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
+					}
+					toolHistory = append(toolHistory, result)
+					if path := changedPath(event.ToolCall, result); path != "" && !slices.Contains(filesChanged, path) {
+						filesChanged = append(filesChanged, path)
+					}
+					for _, a := range result.DeclaredArtifacts() {
+						turnArtifacts = session.AddArtifacts(turnArtifacts, session.Artifact{Artifact: a, ToolID: event.ToolCall.ToolID, Turn: turn + 1})
+					}
+					budget.recordTool(ctx, event.ToolCall.ToolID, result.Data)
+					toolMessages = append(toolMessages, provider.Message{Role: "tool", Content: result.Output, ToolCallID: event.ToolCall.ID, ToolName: event.ToolCall.ToolID, Images: resultImages(result.Images)})
+					if isAborted(result) && limits.exceeded(ctx, turnCtx) != nil {
+						toolAborted = true
+						break consume
+					}
+					if isAborted(result) {
+						assistant := provider.Message{Role: "assistant", Content: assistantText.String(), ToolCalls: assistantToolCalls, Raw: assistantRaw}
+						transcript = saveAborted(ctx, req, sessionID, transcript, assistant, toolMessages, true)
+						recordArtifacts(ctx, req, sessionID, turn+1, turnArtifacts)
+						return pkgruntime.RunResult{SessionID: sessionID, Output: output.String(), Transcript: append([]provider.Message{}, transcript...), FilesChanged: filesChanged, Artifacts: session.AddArtifacts(artifacts, turnArtifacts...)}, ctx.Err()
+					}
+				case provider.StreamEventRaw:
+					assistantRaw = append(assistantRaw, event.Raw)
+					if err := publishHostedTool(ctx, sink, event.Raw); err != nil {
+						return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
+					}
+				case provider.StreamEventReasoning:
+					if err := sink.Publish(ctx, events.Event{Type: events.TypeReasoningDelta, Time: time.Now(), Message: event.Text}); err != nil {
+						return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
+					}
+					if err := progress.delta(ctx, sink, event.Text); err != nil {
+						return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
+					}
+				case provider.StreamEventUsage:
+					// Running counts for a live ticker; totals come from Done.
+					progress.usage(event)
+					data := usageEventData(req.Provider.Name(), usedModel, turn+1, event.InputTokens, event.OutputTokens, event.ReasoningTokens)
+					if err := sink.Publish(ctx, events.Event{Type: events.TypeUsageUpdate, Time: time.Now(), Message: fmt.Sprintf("%d in / %d out", event.InputTokens, event.OutputTokens), Data: data}); err != nil {
+						return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
+					}
+				case provider.StreamEventDone:
+					totalInputTokens += event.InputTokens
+					totalOutputTokens += event.OutputTokens
+					turnInputTokens += event.InputTokens
+					turnOutputTokens += event.OutputTokens
+					totalReasoningTokens += event.ReasoningTokens
+					turnReasoningTokens += event.ReasoningTokens
+					totalCacheReadTokens += event.CacheReadTokens
+					turnCacheReadTokens += event.CacheReadTokens
+					totalCacheWriteTokens += event.CacheWriteTokens
+					turnCacheWriteTokens += event.CacheWriteTokens
+					turnTiming = cmp.Or(event.Timing, turnTiming)
+				}
+			}
+			break
+		}
+		if rest := prefill.flush(); primed && rest != "" {
+			output.WriteString(rest)
+			assistantText.WriteString(rest)
+			_ = guard.assistantSink(sink).Publish(ctx, events.Event{Type: events.TypeAssistantDelta, Time: time.Now(), Message: rest})
+		}
+		// A turn cut short by a time limit keeps what it got to and ends
+		// the run.
+		if cause := limits.exceeded(ctx, turnCtx); cause != nil {
+			assistant := provider.Message{Role: "assistant", Content: assistantText.String(), ToolCalls: assistantToolCalls, Raw: assistantRaw}
+			if assistant.Content != "" || len(assistant.ToolCalls) > 0 || len(assistant.Raw) > 0 {
+				transcript = saveAborted(ctx, req, sessionID, transcript, assistant, toolMessages, toolAborted)
+			}
+			recordArtifacts(ctx, req, sessionID, turn+1, turnArtifacts)
+			artifacts = session.AddArtifacts(artifacts, turnArtifacts...)
+			turns++
+			stopReason = limits.stop(ctx, req, sink, sessionID, turn+1, turnStarted, cause)
+			deadlineStopped = true
+			break
+		}
+
+		// A stream that stalled, or whose request was rate limited or
+		// failed on the provider's side, before producing anything is
+		// retried like a failed request, after the wait its error calls for.
+		// So is a stream that stalled mid-reply and could not be resumed,
+		// from the start of the turn: its partial text is dropped. Tool
+		// calls have run by then, so a turn that made some is not retried.
+		stalled := errors.Is(streamErr, provider.ErrStreamStalled)
+		produced := assistantText.Len() > primedLen || len(assistantRaw) > 0
+		if streamErr != nil && (stalled || !produced) && len(assistantToolCalls) == 0 && streamRetries < maxRetries-1 {
+			if delay, retry := retryDelay(streamErr, streamRetries); retry && (stalled || provider.ErrorKindOf(streamErr) != "") {
+				if delay > 0 || produced {
+					publishRetry(ctx, sink, usedModel, streamRetries, maxRetries, delay, streamErr)
+					if !wait(turnCtx, delay) {
+						if cause := limits.exceeded(ctx, turnCtx); cause != nil {
+							stopReason = limits.stop(ctx, req, sink, sessionID, turn+1, turnStarted, cause)
+							deadlineStopped = true
+							break
+						}
+						return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, ctx.Err()
+					}
+				}
+				if produced {
+					kept := output.String()[:replyStart]
+					output.Reset()
+					output.WriteString(kept)
+				}
+				streamRetries++
+				turn--
+				continue
 			}
 		}
 		// If stream errored with a model-level error, try the next model in the fallback chain.
 		if streamErr != nil {
-			errMsg := streamErr.Error()
-			isModelError := strings.Contains(errMsg, "Invalid model") || strings.Contains(errMsg, "400 Bad Request") ||
-				strings.Contains(errMsg, "model not found") || strings.Contains(errMsg, "does not exist")
-			if isModelError && len(models) > 1 {
-				_ = sink.Publish(ctx, events.Event{Type: events.TypeError, Time: time.Now(), Message: fmt.Sprintf("model error via stream, trying fallback: %s", errMsg)})
-				// Pop the first model off and retry this turn.
-				models = models[1:]
+			// The turn's tools ran whether or not it is retried.
+			recordArtifacts(ctx, req, sessionID, turn+1, turnArtifacts)
+			artifacts = session.AddArtifacts(artifacts, turnArtifacts...)
+			next := slices.Index(modelChain, usedModel) + 1
+			if reason := fallbackReason(streamErr); reason != "" && next > 0 && next < len(modelChain) {
+				publishFallback(ctx, sink, usedModel, modelChain[next], reason, streamErr)
+				// Drop the failed model and the ones before it, and retry
+				// this turn.
+				modelChain = modelChain[next:]
 				turn--
 				continue
 			}
-			return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, streamErr
+			return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...), FilesChanged: filesChanged, Artifacts: artifacts}, streamErr
 		}
+		streamRetries = 0
 
-		assistantMessage := provider.Message{Role: "assistant", Content: assistantText.String(), ToolCalls: assistantToolCalls}
-		if assistantMessage.Content != "" || len(assistantMessage.ToolCalls) > 0 {
+		if assistantText.Len() == primedLen && len(assistantToolCalls) == 0 && len(assistantRaw) == 0 {
+			retry := req.Profile.Spec.Provider.RetryEmpty && !emptyRetried
+			if err := sink.Publish(ctx, events.Event{Type: events.TypeEmptyResponse, Time: time.Now(), Message: fmt.Sprintf("model %s returned an empty response", usedModel), Data: map[string]any{"model": usedModel, "turn": turn + 1, "retrying": retry}}); err != nil {
+				return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
+			}
+			if retry {
+				emptyRetried = true
+				nudge = &provider.Message{Role: "user", Content: "Your previous response was empty. Please continue: answer the request or call a tool."}
+				turn--
+				continue
+			}
+		}
+
+		// The guardrail decides what the session, the transcript, and the
+		// run's output keep of the reply, and the sink, which it was held
+		// back from, gets what is kept.
+		if assistantText.Len() > 0 {
+			kept, blocked, err := guard.checkAssistant(ctx, req, sink, assistantText.String())
+			if err != nil {
+				return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
+			}
+			if kept != assistantText.String() {
+				assistantText.Reset()
+				assistantText.WriteString(kept)
+				previous := output.String()[:outputStart]
+				output.Reset()
+				output.WriteString(previous + kept)
+			}
+			guardStopped = blocked
+			if kept := output.String()[outputStart:]; guard.holdsAssistant() && kept != "" {
+				if err := sink.Publish(ctx, events.Event{Type: events.TypeAssistantDelta, Time: time.Now(), Message: kept}); err != nil {
+					return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
+				}
+			}
+		}
+		assistantMessage := provider.Message{Role: "assistant", Content: assistantText.String(), ToolCalls: assistantToolCalls, Raw: assistantRaw}
+		if assistantMessage.Content != "" || len(assistantMessage.ToolCalls) > 0 || len(assistantMessage.Raw) > 0 {
 			transcript = append(transcript, assistantMessage)
 			if req.Sessions != nil {
 				_ = req.Sessions.Append(ctx, sessionID, session.Entry{
-					Kind:      session.EntryMessage,
-					Role:      "assistant",
-					Content:   assistantMessage.Content,
-					Metadata:  encodeSessionMetadata(session.MessageMetadata{ToolCalls: assistantMessage.ToolCalls}),
+					Kind:    session.EntryMessage,
+					Role:    "assistant",
+					Content: assistantMessage.Content,
+					Metadata: encodeSessionMetadata(session.MessageMetadata{
+						ToolCalls: assistantMessage.ToolCalls,
+						Raw:       assistantMessage.Raw,
+						Usage: &session.TurnUsage{
+							Provider:         req.Provider.Name(),
+							Model:            usedModel,
+							InputTokens:      turnInputTokens,
+							OutputTokens:     turnOutputTokens,
+							ReasoningTokens:  turnReasoningTokens,
+							CacheReadTokens:  turnCacheReadTokens,
+							CacheWriteTokens: turnCacheWriteTokens,
+							DurationMs:       time.Since(turnStarted).Milliseconds(),
+							ArgumentRetries:  argChecks.rejected,
+						},
+					}),
 					CreatedAt: time.Now(),
 				})
 			}
@@ -212,19 +607,60 @@ func (Runner) Run(ctx context.Context, req pkgruntime.RunRequest) (pkgruntime.Ru
 					Kind:      session.EntryMessage,
 					Role:      "tool",
 					Content:   message.Content,
-					Metadata:  encodeSessionMetadata(session.MessageMetadata{ToolCallID: message.ToolCallID, ToolName: message.ToolName}),
+					Metadata:  encodeSessionMetadata(session.MessageMetadata{ToolCallID: message.ToolCallID, ToolName: message.ToolName, Images: message.Images}),
 					CreatedAt: time.Now(),
 				})
 			}
 		}
 		transcript = append(transcript, toolMessages...)
-		if err := sink.Publish(ctx, events.Event{Type: events.TypeTurnFinished, Time: time.Now(), Message: fmt.Sprintf("turn %d finished", turn+1)}); err != nil {
+		turns++
+		turnData := addCacheData(usageEventData(req.Provider.Name(), usedModel, turn+1, turnInputTokens, turnOutputTokens, turnReasoningTokens), req.Provider.Name(), usedModel, turnInputTokens, turnCacheReadTokens, turnCacheWriteTokens, prefixChanged)
+		addTimingData(turnData, turnTiming, turnInputTokens, turnOutputTokens)
+		if watchErr == nil {
+			var changes []session.FileChange
+			changes, watchErr = watcher.end(ctx)
+			if len(changes) > 0 {
+				turnData["filesChanged"] = changes
+				recordFileChanges(ctx, req, sessionID, turn+1, changes)
+			}
+		}
+		if watchErr != nil {
+			turnData["watchError"] = watchErr.Error()
+		}
+		if len(turnArtifacts) > 0 {
+			turnData["artifacts"] = turnArtifacts
+			recordArtifacts(ctx, req, sessionID, turn+1, turnArtifacts)
+			artifacts = session.AddArtifacts(artifacts, turnArtifacts...)
+		}
+		if err := sink.Publish(ctx, events.Event{Type: events.TypeTurnFinished, Time: time.Now(), Message: fmt.Sprintf("turn %d finished", turn+1), Data: turnData}); err != nil {
+			return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
+		}
+		if argChecks.endTurn() {
+			// A turn spent correcting tool arguments does not count
+			// against the turn limit.
+			turn--
+		}
+		turnCost := budget.recordTurn(ctx, req.Provider.Name(), usedModel, turnInputTokens, turnOutputTokens, turnCacheReadTokens, turnCacheWriteTokens)
+		totalCost += turnCost
+		if budgetStopped, err = budget.check(ctx, req, sink, turnCost); err != nil {
 			return pkgruntime.RunResult{SessionID: sessionID, Transcript: append([]provider.Message{}, transcript...)}, err
 		}
+		if budgetStopped {
+			stopReason = pkgruntime.StopBudget
+			break
+		}
+		req.Profile.Spec.Provider.Thinking = thinking.observe(ctx, sink, turn+1, req.Profile.Spec.Provider.Thinking, budget.pressure(ctx), time.Since(streamStarted)-toolTime)
+		if guardStopped {
+			stopReason = pkgruntime.StopGuardrail
+			break
+		}
 		// Compact when estimated context tokens exceed threshold — mirrors pi-mono's approach.
 		if compactionEnabled && estimateTranscriptTokens(transcript) > contextTokenThreshold-reserveTokens {
-			if compacted, compactionSummary, err := compactTranscript(ctx, req, transcript, keepRecentTokens); err == nil {
+			if compacted, compactionSummary, pinned, err := compactTranscript(ctx, req, transcript, keepRecentTokens); err == nil {
 				transcript = compacted
+				if compactionSummary != "" {
+					prefix = pinned + 1
+				}
 				// Persist the compaction entry to the session so it survives resume.
 				if req.Sessions != nil && compactionSummary != "" {
 					_ = req.Sessions.Append(ctx, sessionID, session.Entry{
@@ -237,16 +673,45 @@ func (Runner) Run(ctx context.Context, req pkgruntime.RunRequest) (pkgruntime.Ru
 			}
 		}
 		if len(toolHistory) >= maxExplorationToolCalls && strings.TrimSpace(output.String()) == "" {
+			stopReason = pkgruntime.StopExplorationLimit
 			break
 		}
 		if !toolExecuted {
-			break
+			// The model is done; an operator may still redirect it or queue
+			// the next task before the run ends.
+			var injected int
+			transcript, injected = injectQueued(ctx, req, sink, sessionID, req.Steering, events.TypeSteering, transcript)
+			if injected == 0 {
+				transcript, injected = injectQueued(ctx, req, sink, sessionID, req.FollowUps, events.TypeFollowUp, transcript)
+			}
+			if injected == 0 {
+				transcript, injected = awaitFollowUps(ctx, req, sink, sessionID, limits, transcript)
+			}
+			if injected == 0 && limits.overrun() {
+				stopReason = limits.stop(ctx, req, sink, sessionID, turn+1, time.Now(), errWallClock)
+				deadlineStopped = true
+				break
+			}
+			if injected == 0 {
+				stopReason = pkgruntime.StopCompleted
+				break
+			}
+			if output.Len() > 0 {
+				output.WriteString("\n\n")
+			}
 		}
 	}
 
 	finalOutput := strings.TrimSpace(output.String())
-	if finalOutput == "" || needsFinalAnswer(transcript) {
-		answer, updatedTranscript, err := forceFinalAnswer(ctx, req, transcript, toolHistory, sink)
+	// Forcing a final answer costs another call, so skip it once over budget.
+	if !budgetStopped && !guardStopped && !deadlineStopped && (finalOutput == "" || needsFinalAnswer(transcript)) {
+		answer, updatedTranscript, err := forceFinalAnswer(ctx, req, transcript, toolHistory, guard.assistantSink(sink))
+		if err == nil && guard.holdsAssistant() && answer != "" {
+			if answer, _, err = guard.checkAssistant(ctx, req, sink, answer); err == nil {
+				updatedTranscript[len(updatedTranscript)-1].Content = answer
+				err = sink.Publish(ctx, events.Event{Type: events.TypeAssistantDelta, Time: time.Now(), Message: answer})
+			}
+		}
 		if err == nil && strings.TrimSpace(answer) != "" {
 			finalOutput = strings.TrimSpace(answer)
 			transcript = updatedTranscript
@@ -271,6 +736,9 @@ func (Runner) Run(ctx context.Context, req pkgruntime.RunRequest) (pkgruntime.Ru
 			}
 		}
 	}
+	if createSession && req.Sessions != nil && req.Titler != nil && ctx.Err() == nil {
+		totalCost += titleSession(ctx, req, sink, budget, sessionID, transcript)
+	}
 	if req.Sessions != nil {
 		_ = sink.Publish(ctx, events.Event{Type: events.TypeSessionSaved, Time: time.Now(), Message: "session saved", Data: map[string]any{"session_id": sessionID}})
 	}
@@ -292,16 +760,182 @@ func (Runner) Run(ctx context.Context, req pkgruntime.RunRequest) (pkgruntime.Ru
 	}
 
 	return pkgruntime.RunResult{
-		SessionID:    sessionID,
-		Output:       finalOutput,
-		Transcript:   append([]provider.Message{}, transcript...),
-		Model:        usedModel,
-		InputTokens:  totalInputTokens,
-		OutputTokens: totalOutputTokens,
-		ToolSteps:    toolSteps,
+		SessionID:        sessionID,
+		Output:           finalOutput,
+		Transcript:       append([]provider.Message{}, transcript...),
+		Model:            usedModel,
+		InputTokens:      totalInputTokens,
+		OutputTokens:     totalOutputTokens,
+		ReasoningTokens:  totalReasoningTokens,
+		CacheReadTokens:  totalCacheReadTokens,
+		CacheWriteTokens: totalCacheWriteTokens,
+		ToolSteps:        toolSteps,
+		CostUSD:          totalCost,
+		StopReason:       stopReason,
+		Turns:            turns,
+		FilesChanged:     filesChanged,
+		Artifacts:        artifacts,
+		ArgumentRetries:  argChecks.total,
+		ToolCacheHits:    cache.hitCount(),
+		Plan:             plan.items,
 	}, nil
 }
 
+// changedPath returns the file a successful write or edit call changed.
+func changedPath(call tool.Call, result tool.Result) string {
+	if call.ToolID != "core/write" && call.ToolID != "core/edit" {
+		return ""
+	}
+	if _, failed := result.Data["errorCode"]; failed {
+		return ""
+	}
+	return stringArg(call.Arguments, "path")
+}
+
+// toolUpdates returns an UpdateFunc publishing a call's streamed output as
+// TypeToolOutput events, and a function that stops it, so a tool still
+// running after an abort cannot publish once the call has returned.
+func toolUpdates(ctx context.Context, sink events.Sink, call tool.Call) (tool.UpdateFunc, func()) {
+	var mu sync.Mutex
+	stopped := false
+	update := func(output string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if stopped || output == "" {
+			return
+		}
+		_ = sink.Publish(ctx, events.Event{Type: events.TypeToolOutput, Time: time.Now(), Message: output, Data: map[string]any{"tool_id": call.ToolID, "tool_call_id": call.ID}})
+	}
+	stop := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = true
+	}
+	return update, stop
+}
+
+// execOptions converts profile tool settings into subprocess options, resolving
+// a relative cwd against the run's working directory.
+func execOptions(settings profile.ToolSettings, cwd string) tool.ExecOptions {
+	opts := tool.ExecOptions{Nice: settings.Nice, IONice: settings.IONice, Umask: settings.Umask, AllowedCommands: settings.AllowedCommands, Persistent: settings.Persistent}
+	if settings.CWD != "" {
+		opts.Dir = settings.CWD
+		if !filepath.IsAbs(opts.Dir) && cwd != "" {
+			opts.Dir = filepath.Join(cwd, opts.Dir)
+		}
+	}
+	keys := make([]string, 0, len(settings.Env))
+	for k := range settings.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		opts.Env = append(opts.Env, k+"="+settings.Env[k])
+	}
+	return opts
+}
+
+// capOutput truncates a tool's output to maxBytes at a character boundary,
+// noting how much was cut.
+func capOutput(output string, maxBytes int) string {
+	if len(output) <= maxBytes {
+		return output
+	}
+	n := maxBytes
+	for n > 0 && !utf8.RuneStart(output[n]) {
+		n--
+	}
+	return output[:n] + fmt.Sprintf("\n… [%d bytes truncated]", len(output)-n)
+}
+
+// maxStreamResumes bounds how often one turn's stream is resumed.
+const maxStreamResumes = 2
+
+// resumeStream re-issues a request whose stream died with a transient error
+// after producing text, passing that text as an assistant pre-fill so the
+// provider continues where it stopped. It only applies to providers that
+// implement provider.Prefiller and to turns that have not emitted tool calls.
+func resumeStream(ctx context.Context, req pkgruntime.RunRequest, sink events.Sink, model string, messages []provider.Message, toolDefs []tool.Definition, hosted []provider.HostedTool, partial string, toolCalls, resumes int, streamErr error) (<-chan provider.StreamEvent, bool) {
+	if partial == "" || toolCalls > 0 || resumes >= maxStreamResumes || !isTransientStreamError(streamErr) {
+		return nil, false
+	}
+	prefiller, ok := req.Provider.(provider.Prefiller)
+	if !ok || !prefiller.SupportsPrefill(model) {
+		return nil, false
+	}
+	prefill := append(append([]provider.Message{}, messages...), provider.Message{Role: "assistant", Content: partial})
+	stream, err := req.Provider.Stream(ctx, provider.CompletionRequest{
+		Model:        provider.ModelRef{Provider: req.Provider.Name(), Model: model},
+		System:       req.SystemPrompt,
+		Messages:     prefill,
+		Tools:        toolDefs,
+		HostedTools:  hosted,
+		Thinking:     provider.ThinkingLevel(req.Profile.Spec.Provider.Thinking),
+		ExtraHeaders: req.Profile.Spec.Provider.Headers,
+		ExtraQuery:   req.Profile.Spec.Provider.Query,
+	})
+	if err != nil {
+		return nil, false
+	}
+	_ = sink.Publish(ctx, events.Event{Type: events.TypeStreamResumed, Time: time.Now(), Message: fmt.Sprintf("stream interrupted (%v); resuming from %d chars", streamErr, len(partial)), Data: map[string]any{"model": model, "resume": resumes + 1, "prefill_chars": len(partial)}})
+	return stream, true
+}
+
+// isTransientStreamError reports whether err looks like a dropped connection
+// rather than a model or request error.
+func isTransientStreamError(err error) bool {
+	if errors.Is(err, provider.ErrStreamStalled) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, marker := range []string{"unexpected eof", "connection reset", "broken pipe", "stream error", "internal_error", "http2: server sent goaway"} {
+		if strings.Contains(msg, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// injectQueued appends pending queue messages to the transcript as user
+// turns. Each message is persisted to the session before it is acknowledged,
+// so a crash between the two redelivers it rather than losing it. Queue
+// errors are reported as events and never fail the run.
+func injectQueued(ctx context.Context, req pkgruntime.RunRequest, sink events.Sink, sessionID string, queue pkgruntime.MessageQueue, eventType events.Type, transcript []provider.Message) ([]provider.Message, int) {
+	if queue == nil {
+		return transcript, 0
+	}
+	pending, err := queue.Receive(ctx)
+	if err != nil {
+		_ = sink.Publish(ctx, events.Event{Type: events.TypeError, Time: time.Now(), Message: fmt.Sprintf("receive %s: %v", eventType, err)})
+		return transcript, 0
+	}
+	ids := make([]string, 0, len(pending))
+	injected := 0
+	for _, msg := range pending {
+		if strings.TrimSpace(msg.Content) == "" {
+			ids = append(ids, msg.ID)
+			continue
+		}
+		transcript = append(transcript, provider.Message{Role: "user", Content: msg.Content})
+		if req.Sessions != nil {
+			_ = req.Sessions.Append(ctx, sessionID, session.Entry{Kind: session.EntryMessage, Role: "user", Content: msg.Content, CreatedAt: time.Now()})
+		}
+		_ = sink.Publish(ctx, events.Event{Type: eventType, Time: time.Now(), Message: msg.Content, Data: map[string]any{"id": msg.ID}})
+		ids = append(ids, msg.ID)
+		injected++
+	}
+	if len(ids) > 0 {
+		if err := queue.Ack(ctx, ids...); err != nil {
+			_ = sink.Publish(ctx, events.Event{Type: events.TypeError, Time: time.Now(), Message: fmt.Sprintf("ack %s: %v", eventType, err)})
+		}
+	}
+	return transcript, injected
+}
+
 func needsFinalAnswer(transcript []provider.Message) bool {
 	if len(transcript) == 0 {
 		return true
@@ -320,18 +954,15 @@ func forceFinalAnswer(ctx context.Context, req pkgruntime.RunRequest, transcript
 	followUp := provider.Message{Role: "user", Content: "You have enough information now. Do not call tools. Answer the original user question directly, briefly, and confidently."}
 	messages := append(append([]provider.Message{}, transcript...), followUp)
 	// Use the resolved model (same as the main loop)
-	resolvedModel := req.ModelOverride
-	if resolvedModel == "" {
-		resolvedModel = req.Profile.Spec.Provider.Model
-	}
-	if resolvedModel == "" {
-		resolvedModel = "gpt-4o"
-	}
+	resolvedModel := resolveModel(req)
 	stream, err := req.Provider.Stream(ctx, provider.CompletionRequest{
-		Model:    provider.ModelRef{Provider: req.Provider.Name(), Model: resolvedModel},
-		System:   req.SystemPrompt,
-		Messages: messages,
-		Tools:    nil,
+		Model:        provider.ModelRef{Provider: req.Provider.Name(), Model: resolvedModel},
+		System:       req.SystemPrompt,
+		Messages:     messages,
+		Tools:        nil,
+		Thinking:     provider.ThinkingLevel(req.Profile.Spec.Provider.Thinking),
+		ExtraHeaders: req.Profile.Spec.Provider.Headers,
+		ExtraQuery:   req.Profile.Spec.Provider.Query,
 	})
 	if err != nil {
 		return "", transcript, err
@@ -368,7 +999,9 @@ func forceFinalAnswerFromEvidence(ctx context.Context, req pkgruntime.RunRequest
 		Messages: []provider.Message{
 			{Role: "user", Content: prompt},
 		},
-		Tools: nil,
+		Tools:        nil,
+		ExtraHeaders: req.Profile.Spec.Provider.Headers,
+		ExtraQuery:   req.Profile.Spec.Provider.Query,
 	})
 	if err != nil {
 		return "", transcript, err
@@ -442,13 +1075,30 @@ func summarizeEvidenceResult(result tool.Result) string {
 // resolveModel returns the effective model for a run request,
 // using ModelOverride if set, falling back to profile, then hardcoded default.
 func resolveModel(req pkgruntime.RunRequest) string {
+	model := "gpt-4o"
 	if req.ModelOverride != "" {
-		return req.ModelOverride
+		model = req.ModelOverride
+	} else if req.Profile.Spec.Provider.Model != "" {
+		model = req.Profile.Spec.Provider.Model
 	}
-	if req.Profile.Spec.Provider.Model != "" {
-		return req.Profile.Spec.Provider.Model
+	resolved, _ := models.Resolve(model, time.Now())
+	return resolved
+}
+
+// resolveModels expands aliases in the model chain and warns once per run
+// about deprecated models, before the provider starts rejecting them.
+func resolveModels(ctx context.Context, sink events.Sink, chain []string) []string {
+	out := make([]string, 0, len(chain))
+	for _, model := range chain {
+		resolved, warning := models.Resolve(model, time.Now())
+		if warning != "" {
+			_ = sink.Publish(ctx, events.Event{Type: events.TypeModelDeprecated, Time: time.Now(), Message: warning, Data: map[string]any{"model": model, "resolved": resolved}})
+		}
+		if !slices.Contains(out, resolved) {
+			out = append(out, resolved)
+		}
 	}
-	return "gpt-4o"
+	return out
 }
 
 func compactRuntimeText(text string, max int) string {
@@ -456,10 +1106,14 @@ func compactRuntimeText(text string, max int) string {
 	if len(text) <= max {
 		return text
 	}
+	cut, ellipsis := max-3, "..."
 	if max < 4 {
-		return text[:max]
+		cut, ellipsis = max, ""
+	}
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
 	}
-	return text[:max-3] + "..."
+	return text[:cut] + ellipsis
 }
 
 func heuristicFinalAnswer(prompt string, toolHistory []tool.Result) string {
@@ -505,7 +1159,7 @@ func heuristicFinalAnswer(prompt string, toolHistory []tool.Result) string {
 }
 
 func encodeSessionMetadata(meta session.MessageMetadata) string {
-	if meta.ToolCallID == "" && meta.ToolName == "" && len(meta.ToolCalls) == 0 {
+	if meta.ToolCallID == "" && meta.ToolName == "" && len(meta.ToolCalls) == 0 && meta.Usage == nil && len(meta.Images) == 0 && len(meta.Documents) == 0 && len(meta.Labels) == 0 {
 		return ""
 	}
 	data, err := json.Marshal(meta)
@@ -515,17 +1169,58 @@ func encodeSessionMetadata(meta session.MessageMetadata) string {
 	return string(data)
 }
 
-func executeTool(ctx context.Context, req pkgruntime.RunRequest, sink events.Sink, tools map[string]tool.Tool, call tool.Call) (tool.Result, error) {
+// executeTool runs a call the model made, recording it in req.Audit.
+func executeTool(ctx context.Context, req pkgruntime.RunRequest, sink events.Sink, tools map[string]tool.Tool, cache *toolCache, transcript []provider.Message, call tool.Call) (tool.Result, error) {
+	audit := newAuditRecord(ctx, call)
+	result, err := runToolCall(ctx, req, sink, tools, cache, transcript, call, &audit)
+	if req.Audit == nil {
+		return result, err
+	}
+	finishAuditRecord(&audit, result)
+	if auditErr := req.Audit.Record(context.WithoutCancel(ctx), audit); auditErr != nil {
+		return result, errors.Join(err, fmt.Errorf("audit %s: %w", call.ToolID, auditErr))
+	}
+	return result, err
+}
+
+// maxApprovalRewrites bounds how often approvers may rewrite one call
+// before approving it as is.
+const maxApprovalRewrites = 3
+
+// runToolCall checks, approves, and runs a call for executeTool, noting
+// in audit what was decided and how the run went. A call that passes its
+// checks is answered from cache when it can be.
+func runToolCall(ctx context.Context, req pkgruntime.RunRequest, sink events.Sink, tools map[string]tool.Tool, cache *toolCache, transcript []provider.Message, call tool.Call, audit *pkgruntime.AuditRecord) (tool.Result, error) {
 	if err := sink.Publish(ctx, events.Event{Type: events.TypeToolRequested, Time: time.Now(), Message: call.ToolID}); err != nil {
 		return tool.Result{}, err
 	}
 	toolImpl, ok := tools[call.ToolID]
 	if !ok {
+		audit.Decision, audit.Reason = pkgruntime.AuditDenied, "tool not enabled"
 		return tool.Result{}, fmt.Errorf("tool %q is not enabled", call.ToolID)
 	}
-	action, path, risk := classifyToolCall(call)
-	if req.Policy != nil {
-		decision, err := req.Policy.Check(ctx, policy.CheckRequest{Action: action, ToolID: call.ToolID, Path: path, Risk: risk})
+	if !req.Toggles.Enabled(toolImpl) {
+		// Switched off after the model was offered it: tell the model
+		// instead of ending the run.
+		audit.Decision, audit.Reason = pkgruntime.AuditDenied, "tool disabled"
+		code := tool.ErrPermissionDenied
+		result := tool.Result{
+			ToolID: call.ToolID,
+			Output: fmt.Sprintf("tool error [%s]: %s has been disabled for this conversation", code, call.ToolID),
+			Data:   map[string]any{"error": "tool disabled", "errorCode": string(code)},
+		}
+		if err := sink.Publish(ctx, events.Event{Type: events.TypeToolFinished, Time: time.Now(), Message: result.Output, Data: result}); err != nil {
+			return tool.Result{}, err
+		}
+		return result, nil
+	}
+	rewritten := false
+	action, path, risk := classifyToolCall(call, req.Execution.CWD)
+	caps := tool.CapabilitiesOf(toolImpl)
+	// A call an approver rewrites is decided again from the start, so the
+	// rewrite is held to the policy and asked about when it needs approval.
+	for round := 0; req.Policy != nil; round++ {
+		decision, err := req.Policy.Check(ctx, policy.CheckRequest{Action: action, ToolID: call.ToolID, Path: path, Risk: risk, Arguments: call.Arguments, Tags: caps.Tags, NeedsConfirmation: caps.NeedsConfirmation})
 		if err != nil {
 			return tool.Result{}, err
 		}
@@ -533,48 +1228,143 @@ func executeTool(ctx context.Context, req pkgruntime.RunRequest, sink events.Sin
 			return tool.Result{}, err
 		}
 		if decision.Kind == policy.DecisionDeny {
+			audit.Decision, audit.Reason = pkgruntime.AuditDenied, decision.Reason
+			if round > 0 {
+				return tool.Result{}, fmt.Errorf("policy denied rewritten %s: %s", call.ToolID, decision.Reason)
+			}
 			return tool.Result{}, fmt.Errorf("policy denied %s: %s", call.ToolID, decision.Reason)
 		}
-		if decision.Kind == policy.DecisionRequireApproval {
-			if req.Approvals == nil {
-				return tool.Result{}, fmt.Errorf("approval required for %s but no resolver configured", call.ToolID)
-			}
-			if err := sink.Publish(ctx, events.Event{Type: events.TypeApprovalRequest, Time: time.Now(), Message: decision.Reason, Data: call}); err != nil {
-				return tool.Result{}, err
-			}
-			approvalDecision, err := req.Approvals.Resolve(ctx, approval.Request{Action: string(action), ToolID: call.ToolID, Reason: decision.Reason, Risk: string(decision.Risk)})
-			if err != nil {
-				return tool.Result{}, err
-			}
-			if err := sink.Publish(ctx, events.Event{Type: events.TypeApprovalResult, Time: time.Now(), Message: approvalDecision.Reason, Data: approvalDecision}); err != nil {
-				return tool.Result{}, err
-			}
-			if !approvalDecision.Approved {
-				return tool.Result{}, fmt.Errorf("approval denied for %s", call.ToolID)
-			}
+		if decision.Arguments != nil {
+			call.Arguments = decision.Arguments
+			rewritten = true
+		}
+		if decision.Kind != policy.DecisionRequireApproval {
+			break
 		}
+		if req.Approvals == nil {
+			return tool.Result{}, fmt.Errorf("approval required for %s but no resolver configured", call.ToolID)
+		}
+		if err := sink.Publish(ctx, events.Event{Type: events.TypeApprovalRequest, Time: time.Now(), Message: decision.Reason, Data: call}); err != nil {
+			return tool.Result{}, err
+		}
+		approvalDecision, err := req.Approvals.Resolve(ctx, approval.Request{
+			Action:     string(action),
+			ToolID:     call.ToolID,
+			Reason:     decision.Reason,
+			Risk:       string(decision.Risk),
+			ToolCallID: call.ID,
+			Definition: toolImpl.Definition(),
+			Arguments:  call.Arguments,
+			Transcript: append([]provider.Message(nil), transcript...),
+			Diff:       dryRun(ctx, req, sink, transcript, toolImpl, call),
+		})
+		if err != nil {
+			return tool.Result{}, err
+		}
+		if err := sink.Publish(ctx, events.Event{Type: events.TypeApprovalResult, Time: time.Now(), Message: approvalDecision.Reason, Data: approvalDecision}); err != nil {
+			return tool.Result{}, err
+		}
+		if !approvalDecision.Approved {
+			audit.Decision, audit.Reason = pkgruntime.AuditDenied, cmp.Or(approvalDecision.Reason, "approval denied")
+			return tool.Result{}, fmt.Errorf("approval denied for %s", call.ToolID)
+		}
+		audit.Decision, audit.Reason = pkgruntime.AuditAllowed, approvalDecision.Reason
+		if approvalDecision.Arguments == nil || argsHash(approvalDecision.Arguments) == argsHash(call.Arguments) {
+			break
+		}
+		if round == maxApprovalRewrites {
+			audit.Decision, audit.Reason = pkgruntime.AuditDenied, "approval kept rewriting the call"
+			return tool.Result{}, fmt.Errorf("approval for %s rewrote the call %d times without approving it as is", call.ToolID, round+1)
+		}
+		call.Arguments = approvalDecision.Arguments
+		rewritten = true
+		action, path, risk = classifyToolCall(call, req.Execution.CWD)
+	}
+	if result, hit := cache.lookup(toolImpl, call); hit {
+		audit.ArgsHash, audit.Status = argsHash(call.Arguments), "cached"
+		if err := sink.Publish(ctx, events.Event{Type: events.TypeToolFinished, Time: time.Now(), Message: result.Output, Data: result}); err != nil {
+			return tool.Result{}, err
+		}
+		return result, nil
 	}
 	if err := sink.Publish(ctx, events.Event{Type: events.TypeToolStarted, Time: time.Now(), Message: call.ToolID}); err != nil {
 		return tool.Result{}, err
 	}
-	result, err := toolImpl.Run(ctx, call)
+	runCtx := ctx
+	if settings, ok := req.Profile.Spec.Tools.Settings[call.ToolID]; ok {
+		runCtx = tool.WithExecOptions(ctx, execOptions(settings, req.Execution.CWD))
+	}
+	grace, _ := req.Profile.Spec.Tools.AbortGraceDuration()
+	if grace > 0 {
+		runCtx = tool.WithAbortGrace(runCtx, grace)
+	}
+	updates, stopUpdates := toolUpdates(ctx, sink, call)
+	defer stopUpdates()
+	runCtx = tool.WithUpdates(runCtx, updates)
+	cc, stopLog := callContext(ctx, req, sink, transcript, call)
+	defer stopLog()
+	runCtx = tool.WithCallContext(runCtx, cc)
+	audit.ArgsHash = argsHash(call.Arguments)
+	started := time.Now()
+	result, err := runAbortable(ctx, grace, func() (tool.Result, error) {
+		release, err := acquireTool(runCtx, cmp.Or(req.Execution.Workspace.Root, req.Execution.CWD), toolImpl, req.Profile.Spec.Tools.Settings[call.ToolID])
+		if err != nil {
+			return tool.Result{}, err
+		}
+		defer release()
+		return runWithTransientRetry(ctx, runCtx, sink, toolImpl, call)
+	})
+	audit.DurationMS = time.Since(started).Milliseconds()
+	audit.Status = "ok"
+	if ctx.Err() != nil {
+		// Aborted mid-call: keep what the tool returned, and still report
+		// it although the run's context is done.
+		result, err = abortedResult(call, result, err), nil
+		ctx = context.WithoutCancel(ctx)
+	}
 	if err != nil {
+		audit.Status, audit.ExitCode = "error", exitCode(err)
+		code := tool.CodeOf(err)
 		result = tool.Result{
 			ToolID: call.ToolID,
-			Output: fmt.Sprintf("tool error: %v", err),
-			Data:   map[string]any{"error": err.Error()},
+			Output: fmt.Sprintf("tool error [%s]: %v", code, err),
+			Data:   map[string]any{"error": err.Error(), "errorCode": string(code)},
 		}
-		if publishErr := sink.Publish(ctx, events.Event{Type: events.TypeError, Time: time.Now(), Message: err.Error(), Data: map[string]any{"tool_id": call.ToolID}}); publishErr != nil {
+		if publishErr := sink.Publish(ctx, events.Event{Type: events.TypeError, Time: time.Now(), Message: err.Error(), Data: map[string]any{"tool_id": call.ToolID, "code": string(code)}}); publishErr != nil {
 			return tool.Result{}, publishErr
 		}
 	}
+	if settings := req.Profile.Spec.Tools.Settings[call.ToolID]; err == nil && settings.MaxBytes > 0 {
+		result.Output = capOutput(result.Output, settings.MaxBytes)
+	}
+	if rewritten {
+		if result.Data == nil {
+			result.Data = map[string]any{}
+		}
+		result.Data["arguments"] = call.Arguments
+		result.Data["arguments_rewritten"] = true
+	}
+	if !isAborted(result) {
+		cache.store(toolImpl, call, result)
+	}
 	if err := sink.Publish(ctx, events.Event{Type: events.TypeToolFinished, Time: time.Now(), Message: result.Output, Data: result}); err != nil {
 		return tool.Result{}, err
 	}
 	return result, nil
 }
 
-func classifyToolCall(call tool.Call) (policy.Action, string, policy.RiskLevel) {
+// classifyToolCall returns the policy action, path, and risk of call. A
+// relative path is resolved against cwd, as the file tools do, rather than
+// the process's working directory.
+func classifyToolCall(call tool.Call, cwd string) (policy.Action, string, policy.RiskLevel) {
+	action, path, risk := classifyToolAction(call)
+	if path != "" {
+		path = tool.CallContext{CWD: cwd}.Path(path)
+	}
+	return action, path, risk
+}
+
+func classifyToolAction(call tool.Call) (policy.Action, string, policy.RiskLevel) {
 	switch call.ToolID {
 	case "core/read":
 		path := stringArg(call.Arguments, "path")
@@ -596,6 +1386,13 @@ func classifyToolCall(call tool.Call) (policy.Action, string, policy.RiskLevel)
 		return policy.ActionEdit, path, policy.RiskMedium
 	case "core/bash":
 		return policy.ActionShell, "", policy.RiskHigh
+	case searchToolsID, "git/status", "git/diff", "git/log":
+		return policy.ActionTool, "", policy.RiskLow
+	case "git/branch":
+		if action := stringArg(call.Arguments, "action"); action == "" || action == "list" {
+			return policy.ActionTool, "", policy.RiskLow
+		}
+		return policy.ActionTool, "", policy.RiskMedium
 	default:
 		return policy.ActionTool, "", policy.RiskMedium
 	}
@@ -615,10 +1412,7 @@ func stringArg(args map[string]any, key string) string {
 func estimateTranscriptTokens(transcript []provider.Message) int {
 	total := 0
 	for _, msg := range transcript {
-		total += len(msg.Content) / 4
-		for _, tc := range msg.ToolCalls {
-			total += (len(tc.ToolID) + len(fmt.Sprint(tc.Arguments))) / 4
-		}
+		total += pkgruntime.EstimateMessageTokens(msg)
 	}
 	return total
 }
@@ -628,11 +1422,14 @@ func estimateTranscriptTokens(transcript []provider.Message) int {
 // pi-mono's approach: structured summary format, serialised conversation text,
 // turn-boundary-aware cut point.
 //
-// Returns (compactedTranscript, summaryText, error). All errors are non-fatal —
-// the original transcript is returned unchanged on failure.
-func compactTranscript(ctx context.Context, req pkgruntime.RunRequest, transcript []provider.Message, keepRecentTokens int) ([]provider.Message, string, error) {
+// Pinned messages (pkgruntime.LabelPinned) before the cut point are not
+// summarised: they are kept verbatim, in order, ahead of the summary.
+//
+// Returns (compactedTranscript, summaryText, pinnedKept, error). All errors
+// are non-fatal — the original transcript is returned unchanged on failure.
+func compactTranscript(ctx context.Context, req pkgruntime.RunRequest, transcript []provider.Message, keepRecentTokens int) ([]provider.Message, string, int, error) {
 	if len(transcript) < 8 {
-		return transcript, "", nil
+		return transcript, "", 0, nil
 	}
 
 	// Walk backwards from the end to find the cut point:
@@ -640,11 +1437,14 @@ func compactTranscript(ctx context.Context, req pkgruntime.RunRequest, transcrip
 	// always cutting at a turn boundary (never inside a tool call pair).
 	cutIdx := findCompactionCutPoint(transcript, keepRecentTokens)
 	if cutIdx <= 1 {
-		return transcript, "", nil // nothing meaningful to compact
+		return transcript, "", 0, nil // nothing meaningful to compact
 	}
 
-	toSummarise := transcript[:cutIdx]
+	pinned, toSummarise := splitPinned(transcript[:cutIdx])
 	toKeep := transcript[cutIdx:]
+	if len(toSummarise) == 0 {
+		return transcript, "", 0, nil // everything before the cut is pinned
+	}
 
 	// Serialise the messages to be summarised using labeled text so the LLM
 	// does not treat them as a live conversation (pi-mono's approach).
@@ -684,12 +1484,15 @@ Conversation to summarize:
 ` + serialised
 
 	stream, err := req.Provider.Stream(ctx, provider.CompletionRequest{
-		Model:    provider.ModelRef{Provider: req.Provider.Name(), Model: resolveModel(req)},
-		Messages: []provider.Message{{Role: "user", Content: prompt}},
-		Tools:    nil,
+		Model:        provider.ModelRef{Provider: req.Provider.Name(), Model: resolveModel(req)},
+		Messages:     []provider.Message{{Role: "user", Content: prompt}},
+		Tools:        nil,
+		ExtraHeaders: req.Profile.Spec.Provider.Headers,
+		ExtraQuery:   req.Profile.Spec.Provider.Query,
+		NoCache:      true,
 	})
 	if err != nil {
-		return transcript, "", nil // non-fatal
+		return transcript, "", 0, nil // non-fatal
 	}
 	var summaryBuf strings.Builder
 	for event := range stream {
@@ -699,17 +1502,18 @@ Conversation to summarize:
 	}
 	summaryText := strings.TrimSpace(summaryBuf.String())
 	if summaryText == "" {
-		return transcript, "", nil
+		return transcript, "", 0, nil
 	}
 
 	// Replace summarised messages with a single assistant summary message.
-	compacted := make([]provider.Message, 0, 1+len(toKeep))
+	compacted := make([]provider.Message, 0, len(pinned)+1+len(toKeep))
+	compacted = append(compacted, pinned...)
 	compacted = append(compacted, provider.Message{
 		Role:    "assistant",
-		Content: "[Context compacted — summary of earlier conversation]\n\n" + summaryText,
+		Content: pkgruntime.CompactionPrefix + summaryText,
 	})
 	compacted = append(compacted, toKeep...)
-	return compacted, summaryText, nil
+	return compacted, summaryText, len(pinned), nil
 }
 
 // findCompactionCutPoint walks backwards through the transcript, accumulating
@@ -733,6 +1537,44 @@ func findCompactionCutPoint(transcript []provider.Message, keepRecentTokens int)
 	return 1 // keep everything except the very first message
 }
 
+// splitPinned separates the pinned messages from the rest. A pinned tool
+// call keeps its results and a pinned tool result keeps the call, with the
+// call's other results, so the pinned messages remain a valid history.
+func splitPinned(messages []provider.Message) (pinned, rest []provider.Message) {
+	keep := make([]bool, len(messages))
+	callers := map[string]int{} // tool call ID -> index of the assistant message making it
+	found := false
+	for i, msg := range messages {
+		for _, call := range msg.ToolCalls {
+			callers[call.ID] = i
+		}
+		if pkgruntime.IsPinned(msg) {
+			keep[i], found = true, true
+		}
+	}
+	if !found {
+		return nil, messages
+	}
+	for _, msg := range messages {
+		if j, ok := callers[msg.ToolCallID]; ok && msg.Role == "tool" && pkgruntime.IsPinned(msg) {
+			keep[j] = true
+		}
+	}
+	for i, msg := range messages {
+		if j, ok := callers[msg.ToolCallID]; ok && msg.Role == "tool" && keep[j] {
+			keep[i] = true
+		}
+	}
+	for i, msg := range messages {
+		if keep[i] {
+			pinned = append(pinned, msg)
+		} else {
+			rest = append(rest, msg)
+		}
+	}
+	return pinned, rest
+}
+
 // serializeForCompaction converts a message slice to labeled text that prevents
 // the LLM from treating it as a live conversation to continue.
 // Mirrors pi-mono's serializeConversation() approach.
@@ -0,0 +1,63 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/bitop-dev/agent/pkg/profile"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// toolSlots holds the semaphores enforcing tool concurrency across the runs
+// in the process that share a workspace: one per Exclusive group, and one
+// per tool with a parallel-call limit. Runs in different workspaces do not
+// wait on each other.
+var toolSlots sync.Map // "root\x00group:name" or "root\x00tool:id#limit" -> chan struct{}
+
+// acquireTool waits until a call of impl may run under its declared
+// concurrency and settings' overrides in the workspace at root, and returns
+// the func releasing it. Groups are taken in name order, then the tool's own
+// slot, so two calls never wait on each other.
+func acquireTool(ctx context.Context, root string, impl tool.Tool, settings profile.ToolSettings) (func(), error) {
+	caps := tool.CapabilitiesOf(impl)
+	limit := caps.Concurrency
+	if caps.Serial || settings.Serial {
+		limit = 1
+	}
+	if settings.MaxParallel > 0 {
+		limit = settings.MaxParallel
+	}
+	groups := slices.Concat(caps.Exclusive, settings.Exclusive)
+	slices.Sort(groups)
+	type semaphore struct {
+		key  string
+		size int
+	}
+	var wanted []semaphore
+	for _, group := range slices.Compact(groups) {
+		wanted = append(wanted, semaphore{"group:" + group, 1})
+	}
+	if limit > 0 {
+		wanted = append(wanted, semaphore{fmt.Sprintf("tool:%s#%d", impl.Definition().ID, limit), limit})
+	}
+	var held []chan struct{}
+	release := func() {
+		for _, slots := range held {
+			<-slots
+		}
+	}
+	for _, sem := range wanted {
+		v, _ := toolSlots.LoadOrStore(root+"\x00"+sem.key, make(chan struct{}, sem.size))
+		slots := v.(chan struct{})
+		select {
+		case slots <- struct{}{}:
+			held = append(held, slots)
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
@@ -0,0 +1,53 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/events"
+	"github.com/bitop-dev/agent/pkg/provider"
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+	"github.com/bitop-dev/agent/pkg/session"
+)
+
+// switchProvider carries transcript over when a reload moves the run from
+// req's provider to next's, and marks the switch in the events and the
+// session. A history the new provider cannot be sent is reported and the
+// run stays on its provider.
+func switchProvider(ctx context.Context, req, next pkgruntime.RunRequest, sink events.Sink, sessionID string, transcript []provider.Message) ([]provider.Message, bool) {
+	if next.Provider == nil || next.Provider.Name() == req.Provider.Name() {
+		return transcript, true
+	}
+	normalized, changes, err := pkgruntime.NormalizeHistory(transcript, next.Provider)
+	if err != nil {
+		_ = sink.Publish(ctx, events.Event{Type: events.TypeError, Time: time.Now(), Message: fmt.Sprintf("cannot continue on %s, staying on %s: %v", next.Provider.Name(), req.Provider.Name(), err)})
+		return transcript, false
+	}
+	marker := session.ProviderSwitch{
+		From:            req.Provider.Name(),
+		To:              next.Provider.Name(),
+		Model:           resolveModel(next),
+		RewrittenIDs:    changes.RewrittenIDs,
+		DroppedBlocks:   changes.DroppedBlocks,
+		DroppedMessages: changes.DroppedMessages,
+	}
+	message := fmt.Sprintf("continuing on %s/%s after %s", marker.To, marker.Model, marker.From)
+	if changes != (pkgruntime.HistoryChanges{}) {
+		message += fmt.Sprintf(" (%d tool call IDs rewritten, %d provider blocks dropped)", changes.RewrittenIDs, changes.DroppedBlocks)
+	}
+	_ = sink.Publish(ctx, events.Event{Type: events.TypeProviderSwitched, Time: time.Now(), Message: message, Data: marker})
+	if req.Sessions != nil {
+		if data, err := json.Marshal(marker); err == nil {
+			_ = req.Sessions.Append(ctx, sessionID, session.Entry{
+				Kind:      session.EntryEvent,
+				EventType: session.EventProviderSwitched,
+				Content:   message,
+				Metadata:  string(data),
+				CreatedAt: time.Now(),
+			})
+		}
+	}
+	return normalized, true
+}
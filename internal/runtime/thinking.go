@@ -0,0 +1,123 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/events"
+	"github.com/bitop-dev/agent/pkg/provider"
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+)
+
+// Reasons a thinking level is lowered.
+const (
+	thinkingBudget  = "budget"
+	thinkingLatency = "latency"
+)
+
+// thinkingGovernor applies a profile's ThinkingDowngrade. A nil governor
+// keeps the profile's level.
+type thinkingGovernor struct {
+	atBudget   float64
+	maxLatency time.Duration
+	min        provider.ThinkingLevel
+	lowered    bool
+	level      provider.ThinkingLevel
+	crossed    int // budget thresholds passed: AtBudget, then halfway to the cap
+}
+
+func newThinkingGovernor(req pkgruntime.RunRequest) (*thinkingGovernor, error) {
+	spec := req.Profile.Spec.Provider
+	if err := checkThinkingLevel(spec.Thinking); err != nil {
+		return nil, fmt.Errorf("provider.thinking: %w", err)
+	}
+	downgrade := spec.ThinkingDowngrade
+	if !downgrade.Enabled() {
+		return nil, nil
+	}
+	if err := checkThinkingLevel(downgrade.Min); err != nil {
+		return nil, fmt.Errorf("provider.thinkingDowngrade.min: %w", err)
+	}
+	g := &thinkingGovernor{atBudget: downgrade.AtBudget, min: provider.ThinkingLevel(downgrade.Min)}
+	if g.min == "" {
+		g.min = provider.ThinkingLow
+	}
+	if downgrade.MaxTurnLatency != "" {
+		d, err := time.ParseDuration(downgrade.MaxTurnLatency)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("provider.thinkingDowngrade.maxTurnLatency: invalid duration %q", downgrade.MaxTurnLatency)
+		}
+		g.maxLatency = d
+	}
+	return g, nil
+}
+
+func checkThinkingLevel(level string) error {
+	switch provider.ThinkingLevel(level) {
+	case "", provider.ThinkingHigh, provider.ThinkingMedium, provider.ThinkingLow:
+		return nil
+	}
+	return fmt.Errorf("unknown level %q (want high, medium, or low)", level)
+}
+
+// current returns the level for the next call: configured until the
+// governor has lowered it.
+func (g *thinkingGovernor) current(configured string) string {
+	if g == nil || !g.lowered {
+		return configured
+	}
+	return string(g.level)
+}
+
+// observe lowers the level from current after a turn whose model call
+// took latency and that left pressure of a cap spent, publishing a TypeThinkingAdjusted
+// event, and returns the level for the next call.
+func (g *thinkingGovernor) observe(ctx context.Context, sink events.Sink, turn int, current string, pressure float64, latency time.Duration) string {
+	if g == nil {
+		return current
+	}
+	var reason string
+	// Spend that jumps past both thresholds in one turn lowers the level
+	// on this turn and the next.
+	thresholds := [...]float64{g.atBudget, (1 + g.atBudget) / 2}
+	if g.atBudget > 0 && g.crossed < len(thresholds) && pressure >= thresholds[g.crossed] {
+		g.crossed++
+		reason = thinkingBudget
+	}
+	if reason == "" && g.maxLatency > 0 && latency > g.maxLatency {
+		reason = thinkingLatency
+	}
+	if reason == "" {
+		return current
+	}
+	from := provider.ThinkingLevel(current)
+	to, ok := from.Lower()
+	if !ok || thinkingRank(to) < thinkingRank(g.min) {
+		return current
+	}
+	g.level, g.lowered = to, true
+	var message string
+	switch reason {
+	case thinkingBudget:
+		message = fmt.Sprintf("thinking lowered to %s at %.0f%% of budget", to, pressure*100)
+	default:
+		message = fmt.Sprintf("thinking lowered to %s after a %s model response", to, latency.Round(time.Millisecond))
+	}
+	_ = sink.Publish(ctx, events.Event{Type: events.TypeThinkingAdjusted, Time: time.Now(), Message: message, Data: map[string]any{
+		"from": string(from), "to": string(to), "reason": reason, "turn": turn, "budgetShare": pressure, "latencyMs": latency.Milliseconds(),
+	}})
+	return string(to)
+}
+
+// thinkingRank orders levels from ThinkingLow, with the provider's default
+// as ThinkingHigh.
+func thinkingRank(level provider.ThinkingLevel) int {
+	switch level {
+	case provider.ThinkingLow:
+		return 0
+	case provider.ThinkingMedium:
+		return 1
+	}
+	return 2
+}
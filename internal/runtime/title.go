@@ -0,0 +1,113 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/events"
+	"github.com/bitop-dev/agent/pkg/provider"
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+	"github.com/bitop-dev/agent/pkg/session"
+)
+
+// Longest title and summary kept, in bytes, whatever the model writes.
+const (
+	maxTitleLen   = 80
+	maxSummaryLen = 200
+)
+
+// ModelTitler asks a model, ideally a small one, for a session's title and
+// summary from the conversation's first exchange.
+type ModelTitler struct {
+	Provider provider.Provider
+	Model    string // "" uses the run's model
+}
+
+func (t ModelTitler) Title(ctx context.Context, req pkgruntime.TitleRequest) (session.Title, error) {
+	var prompt strings.Builder
+	prompt.WriteString(`Write a title of at most six words and a one-sentence summary for the conversation below, for a list of past sessions. Reply with only a JSON object: {"title": "...", "summary": "..."}` + "\n\nConversation:\n")
+	for _, msg := range req.Transcript {
+		if (msg.Role == "user" || msg.Role == "assistant") && strings.TrimSpace(msg.Content) != "" {
+			fmt.Fprintf(&prompt, "%s: %s\n", msg.Role, compactRuntimeText(msg.Content, 1000))
+		}
+	}
+	model := t.Model
+	if model == "" {
+		model = req.Model
+	}
+	stream, err := t.Provider.Stream(ctx, provider.CompletionRequest{
+		Model:        provider.ModelRef{Provider: t.Provider.Name(), Model: model},
+		Messages:     []provider.Message{{Role: "user", Content: prompt.String()}},
+		ExtraHeaders: req.ExtraHeaders,
+		ExtraQuery:   req.ExtraQuery,
+		NoCache:      true,
+	})
+	if err != nil {
+		return session.Title{}, err
+	}
+	var reply strings.Builder
+	for event := range stream {
+		if event.Err != nil {
+			return session.Title{}, event.Err
+		}
+		switch event.Type {
+		case provider.StreamEventText:
+			reply.WriteString(event.Text)
+		case provider.StreamEventDone:
+			if req.Usage != nil {
+				req.Usage(t.Provider.Name(), model, event.InputTokens, event.OutputTokens)
+			}
+		}
+	}
+	text := reply.String()
+	first, last := strings.Index(text, "{"), strings.LastIndex(text, "}")
+	if first < 0 || last < first {
+		return session.Title{}, fmt.Errorf("expected a JSON title, got %q", compactRuntimeText(text, 120))
+	}
+	var title session.Title
+	if err := json.Unmarshal([]byte(text[first:last+1]), &title); err != nil {
+		return session.Title{}, fmt.Errorf("parse title: %w", err)
+	}
+	title.Model = model
+	return title, nil
+}
+
+// titleSession names a session after its first exchange with req.Titler,
+// and returns what the titler spent asking a model, recorded with budget.
+// A failing titler leaves the session untitled and the run unaffected.
+func titleSession(ctx context.Context, req pkgruntime.RunRequest, sink events.Sink, budget *budgetGuard, sessionID string, transcript []provider.Message) (cost float64) {
+	title, err := req.Titler.Title(ctx, pkgruntime.TitleRequest{
+		Transcript:   transcript,
+		Model:        resolveModel(req),
+		ExtraHeaders: req.Profile.Spec.Provider.Headers,
+		ExtraQuery:   req.Profile.Spec.Provider.Query,
+		Usage: func(providerName, model string, inputTokens, outputTokens int) {
+			cost += budget.recordTurn(ctx, providerName, model, inputTokens, outputTokens, 0, 0)
+		},
+	})
+	if err == nil {
+		title.Title = compactRuntimeText(strings.Trim(strings.TrimSpace(title.Title), `"'.`), maxTitleLen)
+		title.Summary = compactRuntimeText(title.Summary, maxSummaryLen)
+		if title.Title == "" {
+			err = errors.New("the title is empty")
+		}
+	}
+	if err != nil {
+		_ = sink.Publish(ctx, events.Event{Type: events.TypeError, Time: time.Now(), Message: fmt.Sprintf("session title failed: %v", err)})
+		return cost
+	}
+	data, err := json.Marshal(title)
+	if err != nil {
+		return cost
+	}
+	if err := req.Sessions.Append(ctx, sessionID, session.Entry{Kind: session.EntryTitle, Content: title.Title, Metadata: string(data), CreatedAt: time.Now()}); err != nil {
+		_ = sink.Publish(ctx, events.Event{Type: events.TypeError, Time: time.Now(), Message: fmt.Sprintf("save session title: %v", err)})
+		return cost
+	}
+	_ = sink.Publish(ctx, events.Event{Type: events.TypeSessionTitled, Time: time.Now(), Message: title.Title, Data: title})
+	return cost
+}
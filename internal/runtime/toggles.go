@@ -0,0 +1,38 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/events"
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// toggled drops the definitions of tools switched off in req.Toggles and
+// announces a toolset that changed since the last turn that looked.
+func toggled(ctx context.Context, req pkgruntime.RunRequest, sink events.Sink, tools map[string]tool.Tool, defs []tool.Definition) []tool.Definition {
+	if req.Toggles == nil {
+		return defs
+	}
+	kept := make([]tool.Definition, 0, len(defs))
+	var offered []string
+	for _, def := range defs {
+		if t, ok := tools[def.ID]; ok && !req.Toggles.Enabled(t) {
+			continue
+		}
+		kept = append(kept, def)
+		offered = append(offered, def.ID)
+	}
+	if req.Toggles.Changed() {
+		disabled := req.Toggles.Disabled()
+		message := fmt.Sprintf("toolset changed: %d tools offered", len(kept))
+		if len(disabled) > 0 {
+			message += ", disabled " + strings.Join(disabled, ", ")
+		}
+		_ = sink.Publish(ctx, events.Event{Type: events.TypeToolsetChanged, Time: time.Now(), Message: message, Data: map[string]any{"tools": offered, "disabled": disabled}})
+	}
+	return kept
+}
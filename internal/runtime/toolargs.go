@@ -0,0 +1,236 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bitop-dev/agent/pkg/events"
+	"github.com/bitop-dev/agent/pkg/provider"
+)
+
+// toolArgsInterval is how often the arguments of a streaming tool call
+// are published.
+const toolArgsInterval = 100 * time.Millisecond
+
+// partialValueMax is the most bytes of a string value a TypeToolArgsPartial
+// event carries in its args; the text arrived since the previous event is
+// in its chunk whole.
+const partialValueMax = 256
+
+// toolArgsStream gathers the argument chunks of the tool calls a turn
+// streams and publishes what they parse to so far as TypeToolArgsPartial
+// events, at most once per toolArgsInterval for each call.
+type toolArgsStream struct {
+	calls map[string]*streamingCall
+}
+
+type streamingCall struct {
+	text      strings.Builder
+	published time.Time
+	sent      int // bytes of text in the events published so far
+}
+
+// delta adds the chunk of a StreamEventToolCallDelta to its call.
+func (s *toolArgsStream) delta(ctx context.Context, sink events.Sink, event provider.StreamEvent) error {
+	if s.calls == nil {
+		s.calls = map[string]*streamingCall{}
+	}
+	call := s.calls[event.ToolCall.ID]
+	if call == nil {
+		call = &streamingCall{}
+		s.calls[event.ToolCall.ID] = call
+	}
+	call.text.WriteString(event.Text)
+	now := time.Now()
+	if now.Sub(call.published) < toolArgsInterval {
+		return nil
+	}
+	call.published = now
+	args, streaming := parsePartialArgs(call.text.String())
+	text := call.text.String()
+	data := map[string]any{"tool_id": event.ToolCall.ToolID, "tool_call_id": event.ToolCall.ID, "args": clipValue(args), "chunk": text[call.sent:], "bytes": len(text)}
+	call.sent = len(text)
+	if streaming != "" {
+		data["streaming"] = streaming
+	}
+	return sink.Publish(ctx, events.Event{Type: events.TypeToolArgsPartial, Time: now, Message: describePartialArgs(event.ToolCall.ToolID, args, streaming, call.text.Len()), Data: data})
+}
+
+// describePartialArgs summarizes partial arguments, as in
+// "core/write path=main.go (content streaming, 4.2KB so far)".
+func describePartialArgs(toolID string, args map[string]any, streaming string, total int) string {
+	keys := make([]string, 0, len(args))
+	for key := range args {
+		if key != streaming {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	parts := []string{toolID}
+	for _, key := range keys {
+		value := fmt.Sprint(args[key])
+		if s, ok := args[key].(string); ok {
+			value = s
+		}
+		value = clipText(value, 40)
+		parts = append(parts, key+"="+strings.ReplaceAll(value, "\n", " "))
+	}
+	if streaming != "" {
+		size := total
+		if s, ok := args[streaming].(string); ok {
+			size = len(s)
+		}
+		parts = append(parts, fmt.Sprintf("(%s streaming, %s so far)", streaming, formatBytes(size)))
+	}
+	return strings.Join(parts, " ")
+}
+
+// clipValue returns v with its strings, in maps and slices too, clipped
+// to partialValueMax bytes.
+func clipValue(v any) any {
+	switch v := v.(type) {
+	case string:
+		return clipText(v, partialValueMax)
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for key, value := range v {
+			out[key] = clipValue(value)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, value := range v {
+			out[i] = clipValue(value)
+		}
+		return out
+	}
+	return v
+}
+
+// clipText cuts s to at most n bytes at a character boundary, marking the
+// cut with "…".
+func clipText(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "…"
+}
+
+func formatBytes(n int) string {
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%dB", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.1fKB", float64(n)/1024)
+	}
+	return fmt.Sprintf("%.1fMB", float64(n)/(1024*1024))
+}
+
+// parsePartialArgs parses the start of a JSON object as far as it goes:
+// an open string value is cut where the text ends, and a key or value
+// not yet begun is left out. streaming is the top-level key whose value
+// is still incomplete, if any.
+func parsePartialArgs(text string) (args map[string]any, streaming string) {
+	var stack []byte // open containers, '{' or '['
+	// cuts are where the text can end, with the closers the containers
+	// open there need.
+	type cut struct {
+		at      int
+		closers string
+	}
+	var cuts []cut
+	closers := func() string {
+		b := make([]byte, 0, len(stack))
+		for i := len(stack) - 1; i >= 0; i-- {
+			b = append(b, stack[i]+2) // '{'+2 is '}', '['+2 is ']'
+		}
+		return string(b)
+	}
+	inString, escaped, isKey := false, false, false
+	expectKey := false // the innermost open object expects a key
+	keyStart := -1
+	key, valueDone := "", false // the last top-level key, and whether its value ended
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"' && isKey:
+				inString, expectKey = false, false
+				if len(stack) == 1 {
+					_ = json.Unmarshal([]byte(text[keyStart:i+1]), &key)
+					valueDone = false
+				}
+			case c == '"':
+				inString = false
+				cuts = append(cuts, cut{i + 1, closers()})
+				valueDone = valueDone || len(stack) == 1
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString, keyStart = true, i
+			isKey = expectKey && len(stack) > 0 && stack[len(stack)-1] == '{'
+		case '{', '[':
+			stack = append(stack, c)
+			expectKey = c == '{'
+			cuts = append(cuts, cut{i + 1, closers()})
+		case '}', ']':
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			expectKey = false
+			cuts = append(cuts, cut{i + 1, closers()})
+			valueDone = valueDone || len(stack) == 1
+		case ',':
+			cuts = append(cuts, cut{i, closers()})
+			expectKey = len(stack) > 0 && stack[len(stack)-1] == '{'
+			if len(stack) == 1 {
+				key = ""
+			}
+		}
+	}
+	candidates := []string{text + closers()}
+	if inString && !isKey {
+		candidates = append([]string{trimEscape(text, escaped) + `"` + closers()}, candidates...)
+	}
+	for i := len(cuts) - 1; i >= 0; i-- {
+		candidates = append(candidates, text[:cuts[i].at]+cuts[i].closers)
+	}
+	args = map[string]any{}
+	for _, candidate := range candidates {
+		var parsed map[string]any
+		if json.Unmarshal([]byte(candidate), &parsed) == nil && parsed != nil {
+			args = parsed
+			break
+		}
+	}
+	if key != "" && len(stack) > 0 && !valueDone {
+		streaming = key
+	}
+	return args, streaming
+}
+
+// trimEscape drops an escape sequence cut off at the end of the text of
+// an open string: a lone backslash, when escaped, or a short \u escape.
+func trimEscape(text string, escaped bool) string {
+	if escaped {
+		return text[:len(text)-1]
+	}
+	if i := strings.LastIndex(text, `\u`); i >= 0 && len(text)-i < 6 && (i == 0 || text[i-1] != '\\') {
+		return text[:i]
+	}
+	return text
+}
@@ -0,0 +1,226 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bitop-dev/agent/pkg/profile"
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// searchToolsID is the meta-tool the search strategy exposes in place of
+// the tools that do not fit the budget.
+const searchToolsID = "search_tools"
+
+// toolBudget decides which tool definitions, and in what detail, are sent
+// with each turn. A nil budget sends every definition unchanged.
+type toolBudget struct {
+	pinned map[string]bool
+	full   []tool.Definition // every enabled tool, as registered
+	sent   []tool.Definition // trimmed definitions when trimming suffices
+	search bool              // lazy mode: pinned and loaded tools only
+	loaded map[string]bool   // tools search_tools has surfaced
+}
+
+// newToolBudget applies the profile's budget to defs. The returned note
+// describes what was done, for a TypeToolBudget event, and is empty when
+// the definitions fit.
+func newToolBudget(spec profile.ToolBudget, defs []tool.Definition) (*toolBudget, string, error) {
+	switch spec.Strategy {
+	case "", profile.ToolBudgetAuto, profile.ToolBudgetTrim, profile.ToolBudgetSearch:
+	default:
+		return nil, "", fmt.Errorf("unknown tool budget strategy %q (expected auto, trim, or search)", spec.Strategy)
+	}
+	total := definitionTokens(defs)
+	if spec.MaxTokens <= 0 || total <= spec.MaxTokens {
+		return nil, "", nil
+	}
+	b := &toolBudget{pinned: map[string]bool{}, full: defs, loaded: map[string]bool{}}
+	for _, id := range spec.Pinned {
+		b.pinned[id] = true
+	}
+	if spec.Strategy != profile.ToolBudgetSearch {
+		for level := 1; level <= 3; level++ {
+			b.sent = make([]tool.Definition, len(defs))
+			for i, def := range defs {
+				if b.pinned[def.ID] {
+					b.sent[i] = def
+				} else {
+					b.sent[i] = trimDefinition(def, level)
+				}
+			}
+			trimmed := definitionTokens(b.sent)
+			if trimmed <= spec.MaxTokens || (level == 3 && spec.Strategy == profile.ToolBudgetTrim) {
+				return b, fmt.Sprintf("tool definitions trimmed from ~%d to ~%d tokens (budget %d)", total, trimmed, spec.MaxTokens), nil
+			}
+		}
+	}
+	b.search, b.sent = true, nil
+	return b, fmt.Sprintf("tool definitions need ~%d tokens (budget %d); sending %d pinned tools and %s", total, spec.MaxTokens, len(b.pinned), searchToolsID), nil
+}
+
+// definitions returns what to send this turn.
+func (b *toolBudget) definitions(all []tool.Definition) []tool.Definition {
+	if b == nil {
+		return all
+	}
+	if !b.search {
+		return b.sent
+	}
+	defs := []tool.Definition{b.searchTool().Definition()}
+	for _, def := range b.full {
+		if b.pinned[def.ID] || b.loaded[def.ID] {
+			defs = append(defs, def)
+		}
+	}
+	return defs
+}
+
+// searchTool returns the meta-tool, or nil outside search mode.
+func (b *toolBudget) searchTool() tool.Tool {
+	if b == nil || !b.search {
+		return nil
+	}
+	return searchTools{budget: b}
+}
+
+// searchTools finds tools by keyword and loads the matches for later turns.
+type searchTools struct {
+	budget *toolBudget
+}
+
+func (t searchTools) Definition() tool.Definition {
+	return tool.Definition{
+		ID:          searchToolsID,
+		Description: fmt.Sprintf("Search the %d available tools by keyword. Matching tools are loaded and can be called from the next step on. Only a few tools are loaded up front; search before concluding a capability is missing.", len(t.budget.full)),
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"query": map[string]any{"type": "string", "description": "Keywords describing the capability, e.g. \"create github issue\""},
+				"limit": map[string]any{"type": "integer", "description": "Maximum tools to load (default 5)"},
+			},
+			"required": []string{"query"},
+		},
+	}
+}
+
+func (t searchTools) Run(_ context.Context, call tool.Call) (tool.Result, error) {
+	query, _ := call.Arguments["query"].(string)
+	terms := strings.Fields(strings.ToLower(query))
+	if len(terms) == 0 {
+		return tool.Result{}, tool.Errorf(tool.ErrInvalidArgs, "argument %q must be a non-empty string", "query")
+	}
+	limit := 5
+	if n, ok := call.Arguments["limit"].(float64); ok && n > 0 {
+		limit = int(n)
+	}
+	type match struct {
+		def   tool.Definition
+		score int
+	}
+	var matches []match
+	for _, def := range t.budget.full {
+		text := strings.ToLower(def.ID + " " + def.Description)
+		score := 0
+		for _, term := range terms {
+			if strings.Contains(text, term) {
+				score++
+			}
+		}
+		if score > 0 {
+			matches = append(matches, match{def, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	if len(matches) == 0 {
+		return tool.Result{ToolID: call.ToolID, Output: "no tools matched; try other keywords", Data: map[string]any{"tools": []string{}}}, nil
+	}
+	var out strings.Builder
+	ids := make([]string, 0, len(matches))
+	for _, m := range matches {
+		t.budget.loaded[m.def.ID] = true
+		ids = append(ids, m.def.ID)
+		fmt.Fprintf(&out, "%s: %s\n", m.def.ID, firstSentence(m.def.Description))
+	}
+	out.WriteString("These tools are now available.")
+	return tool.Result{ToolID: call.ToolID, Output: out.String(), Data: map[string]any{"tools": ids}}, nil
+}
+
+// trimDefinition shortens a definition. Level 1 keeps the first sentence
+// of each description, level 2 also strips schema annotations down to
+// types, enums, and required lists, and level 3 drops the tool description.
+func trimDefinition(def tool.Definition, level int) tool.Definition {
+	out := tool.Definition{ID: def.ID, Description: firstSentence(def.Description), Schema: def.Schema}
+	if def.Schema != nil {
+		out.Schema = trimSchema(def.Schema, level >= 2).(map[string]any)
+	}
+	if level >= 3 {
+		out.Description = ""
+	}
+	return out
+}
+
+func trimSchema(node any, strip bool) any {
+	switch v := node.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for key, value := range v {
+			switch key {
+			case "properties":
+				// Keys here are argument names, not annotations.
+				props, ok := value.(map[string]any)
+				if !ok {
+					out[key] = value
+					continue
+				}
+				trimmed := make(map[string]any, len(props))
+				for name, prop := range props {
+					trimmed[name] = trimSchema(prop, strip)
+				}
+				out[key] = trimmed
+			case "description":
+				if s, ok := value.(string); ok && !strip {
+					out[key] = firstSentence(s)
+				}
+			case "examples", "title", "default", "$comment":
+				if !strip {
+					out[key] = value
+				}
+			default:
+				out[key] = trimSchema(value, strip)
+			}
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = trimSchema(item, strip)
+		}
+		return out
+	default:
+		return node
+	}
+}
+
+func firstSentence(s string) string {
+	end := len(s)
+	if i := strings.Index(s, ". "); i >= 0 {
+		end = i + 1
+	}
+	if i := strings.IndexByte(s, '\n'); i >= 0 && i < end {
+		end = i
+	}
+	return strings.TrimSpace(s[:end])
+}
+
+// definitionTokens estimates the prompt tokens defs take, using the same
+// 4-characters-per-token heuristic as the transcript estimate.
+func definitionTokens(defs []tool.Definition) int {
+	return pkgruntime.EstimateToolTokens(defs)
+}
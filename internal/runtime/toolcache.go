@@ -0,0 +1,83 @@
+package runtime
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"maps"
+
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// toolCache reuses the results of cacheable tools when a profile sets
+// tools.cache. Models often read the same file or repeat a search a few
+// turns later; a repeated call that policy still allows returns the earlier
+// result at once, marked with "cached" in its data. Any other tool call may
+// have changed the workspace, so it empties the cache. Results live in the
+// request's ToolCache, shared by a conversation's runs, or else for the run.
+// A nil cache caches nothing.
+type toolCache struct {
+	results *pkgruntime.ToolCache
+	hits    int
+}
+
+func newToolCache(req pkgruntime.RunRequest) *toolCache {
+	if !req.Profile.Spec.Tools.Cache {
+		return nil
+	}
+	return &toolCache{results: cmp.Or(req.ToolCache, &pkgruntime.ToolCache{})}
+}
+
+// toolCacheKey identifies a call by tool and arguments. Arguments are
+// hashed through JSON, which orders object keys.
+func toolCacheKey(call tool.Call) string {
+	args, _ := json.Marshal(call.Arguments)
+	sum := sha256.Sum256(args)
+	return call.ToolID + ":" + hex.EncodeToString(sum[:])
+}
+
+// lookup returns the cached result of an identical earlier call. The
+// caller has checked the call as if it were to run.
+func (c *toolCache) lookup(impl tool.Tool, call tool.Call) (tool.Result, bool) {
+	if c == nil || impl == nil || !tool.CapabilitiesOf(impl).Cacheable {
+		return tool.Result{}, false
+	}
+	cached, ok := c.results.Get(toolCacheKey(call))
+	if !ok {
+		return tool.Result{}, false
+	}
+	c.hits++
+	result := cached
+	result.Data = maps.Clone(cached.Data)
+	if result.Data == nil {
+		result.Data = map[string]any{}
+	}
+	result.Data["cached"] = true
+	return result, true
+}
+
+// hitCount returns the number of calls answered from the cache.
+func (c *toolCache) hitCount() int {
+	if c == nil {
+		return 0
+	}
+	return c.hits
+}
+
+// store keeps a cacheable tool's successful result, or empties the cache
+// after any other tool.
+func (c *toolCache) store(impl tool.Tool, call tool.Call, result tool.Result) {
+	if c == nil {
+		return
+	}
+	if impl == nil || !tool.CapabilitiesOf(impl).Cacheable {
+		c.results.Clear()
+		return
+	}
+	if _, failed := result.Data["error"]; failed {
+		return
+	}
+	c.results.Put(toolCacheKey(call), result)
+}
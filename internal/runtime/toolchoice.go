@@ -0,0 +1,46 @@
+package runtime
+
+import (
+	"fmt"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// checkToolChoice returns an error when choice forces a tool the run does
+// not have or toggles has switched off.
+func checkToolChoice(choice provider.ToolChoice, toolsByID map[string]tool.Tool, toggles *tool.Toggles) error {
+	id, ok := choice.Tool()
+	if !ok {
+		return nil
+	}
+	forced, ok := toolsByID[id]
+	if !ok {
+		return fmt.Errorf("tool choice %q: the profile has no such tool", id)
+	}
+	if !toggles.Enabled(forced) {
+		return fmt.Errorf("tool choice %q: the tool is disabled", id)
+	}
+	return nil
+}
+
+// withForcedTool returns defs with the definition of the tool choice
+// forces added when tool selection left it out, since a provider can only
+// be made to call a tool it is sent. A tool toggles has switched off is
+// not added back.
+func withForcedTool(defs []tool.Definition, choice provider.ToolChoice, toolsByID map[string]tool.Tool, toggles *tool.Toggles) []tool.Definition {
+	id, ok := choice.Tool()
+	if !ok {
+		return defs
+	}
+	for _, def := range defs {
+		if def.ID == id {
+			return defs
+		}
+	}
+	forced, ok := toolsByID[id]
+	if !ok || !toggles.Enabled(forced) {
+		return defs
+	}
+	return canonicalTools(append(defs, forced.Definition()))
+}
@@ -0,0 +1,278 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/events"
+	"github.com/bitop-dev/agent/pkg/profile"
+	"github.com/bitop-dev/agent/pkg/provider"
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+const (
+	defaultSelectTopK     = 8
+	defaultSelectMinTools = 20
+)
+
+// NewToolSelector builds the selector for a profile's tools.select spec.
+// It returns nil when selection is disabled. The embedding strategy needs
+// an embedder; the model strategy asks prov.
+func NewToolSelector(spec profile.ToolSelect, prov provider.Provider, embedder provider.Embedder, embeddingModel string) (pkgruntime.ToolSelector, error) {
+	switch spec.Strategy {
+	case "":
+		return nil, nil
+	case profile.ToolSelectEmbedding:
+		if embedder == nil {
+			return nil, errors.New("tool selection strategy embedding requires embeddings to be configured")
+		}
+		return &EmbeddingToolSelector{Embedder: embedder, Model: embeddingModel}, nil
+	case profile.ToolSelectModel:
+		if prov == nil {
+			return nil, errors.New("tool selection strategy model requires a provider")
+		}
+		return ModelToolSelector{Provider: prov, Model: spec.Model}, nil
+	default:
+		return nil, fmt.Errorf("unknown tool selection strategy %q (expected embedding or model)", spec.Strategy)
+	}
+}
+
+// toolSelection applies a ToolSelector before each turn. The selection is
+// made once per user message, so tool results and follow-up turns reuse it.
+type toolSelection struct {
+	selector pkgruntime.ToolSelector
+	topK     int
+	minTools int
+	pinned   map[string]bool
+	query    string
+	chosen   map[string]bool // nil sends every tool
+}
+
+func newToolSelection(req pkgruntime.RunRequest) *toolSelection {
+	if req.ToolSelector == nil {
+		return nil
+	}
+	spec := req.Profile.Spec.Tools.Select
+	s := &toolSelection{selector: req.ToolSelector, topK: spec.TopK, minTools: spec.MinTools, pinned: map[string]bool{searchToolsID: true}}
+	if s.topK <= 0 {
+		s.topK = defaultSelectTopK
+	}
+	if s.minTools <= 0 {
+		s.minTools = defaultSelectMinTools
+	}
+	for _, id := range spec.Pinned {
+		s.pinned[id] = true
+	}
+	return s
+}
+
+// filter returns the definitions to send this turn: pinned tools, tools
+// already called in the conversation, and the selector's picks for the
+// latest user message. A failing selector sends every tool. cost is what
+// the selector spent asking a model, recorded with budget.
+func (s *toolSelection) filter(ctx context.Context, req pkgruntime.RunRequest, sink events.Sink, budget *budgetGuard, transcript []provider.Message, defs []tool.Definition) (_ []tool.Definition, cost float64) {
+	if s == nil || len(defs) <= s.minTools {
+		return defs, 0
+	}
+	if query := latestUserMessage(transcript); query != s.query {
+		s.query = query
+		ids, err := s.selector.Select(ctx, pkgruntime.ToolSelection{
+			Query:        query,
+			Tools:        defs,
+			Limit:        s.topK,
+			Model:        resolveModel(req),
+			ExtraHeaders: req.Profile.Spec.Provider.Headers,
+			ExtraQuery:   req.Profile.Spec.Provider.Query,
+			Usage: func(providerName, model string, inputTokens, outputTokens int) {
+				cost += budget.recordTurn(ctx, providerName, model, inputTokens, outputTokens, 0, 0)
+			},
+		})
+		if err != nil {
+			s.chosen = nil
+			_ = sink.Publish(ctx, events.Event{Type: events.TypeError, Time: time.Now(), Message: fmt.Sprintf("tool selection failed, sending all %d tools: %v", len(defs), err)})
+		} else {
+			if len(ids) > s.topK {
+				ids = ids[:s.topK]
+			}
+			s.chosen = make(map[string]bool, len(ids))
+			for _, id := range ids {
+				s.chosen[id] = true
+			}
+			_ = sink.Publish(ctx, events.Event{Type: events.TypeToolSelection, Time: time.Now(), Message: fmt.Sprintf("selected %d of %d tools: %s", len(ids), len(defs), strings.Join(ids, ", ")), Data: map[string]any{"tools": ids, "available": len(defs)}})
+		}
+	}
+	if s.chosen == nil {
+		return defs, cost
+	}
+	called := map[string]bool{}
+	for _, msg := range transcript {
+		for _, call := range msg.ToolCalls {
+			called[call.ToolID] = true
+		}
+	}
+	out := make([]tool.Definition, 0, len(s.chosen)+len(s.pinned))
+	for _, def := range defs {
+		if s.pinned[def.ID] || s.chosen[def.ID] || called[def.ID] {
+			out = append(out, def)
+		}
+	}
+	return out, cost
+}
+
+// latestUserMessage returns the most recent message the user typed,
+// including steering and follow-up messages.
+func latestUserMessage(transcript []provider.Message) string {
+	for i := len(transcript) - 1; i >= 0; i-- {
+		if msg := transcript[i]; msg.Role == "user" && msg.ToolCallID == "" && strings.TrimSpace(msg.Content) != "" {
+			return msg.Content
+		}
+	}
+	return ""
+}
+
+// EmbeddingToolSelector ranks tools by cosine similarity between the
+// request and each tool's ID and description. Tool vectors are cached, so
+// after the first request only the query is embedded.
+type EmbeddingToolSelector struct {
+	Embedder provider.Embedder
+	Model    string
+
+	mu      sync.Mutex
+	vectors map[string][]float32 // keyed by the embedded tool text
+}
+
+func (s *EmbeddingToolSelector) Select(ctx context.Context, req pkgruntime.ToolSelection) ([]string, error) {
+	texts := make([]string, len(req.Tools))
+	for i, def := range req.Tools {
+		texts[i] = def.ID + ": " + def.Description
+	}
+	s.mu.Lock()
+	if s.vectors == nil {
+		s.vectors = map[string][]float32{}
+	}
+	inputs := []string{req.Query}
+	for _, text := range texts {
+		if _, ok := s.vectors[text]; !ok {
+			inputs = append(inputs, text)
+		}
+	}
+	s.mu.Unlock()
+	vectors, err := s.Embedder.Embed(ctx, provider.EmbeddingRequest{Model: s.Model, Inputs: inputs})
+	if err != nil {
+		return nil, fmt.Errorf("embed tools: %w", err)
+	}
+	if len(vectors) != len(inputs) {
+		return nil, fmt.Errorf("embed tools: expected %d vectors, got %d", len(inputs), len(vectors))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, text := range inputs[1:] {
+		s.vectors[text] = vectors[i+1]
+	}
+	type scored struct {
+		id    string
+		score float64
+	}
+	ranked := make([]scored, 0, len(texts))
+	for i, text := range texts {
+		ranked = append(ranked, scored{req.Tools[i].ID, cosine(vectors[0], s.vectors[text])})
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].score > ranked[j].score })
+	ids := make([]string, 0, req.Limit)
+	for _, r := range ranked {
+		if len(ids) == req.Limit {
+			break
+		}
+		ids = append(ids, r.id)
+	}
+	return ids, nil
+}
+
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+// ModelToolSelector asks a model to pick the relevant tools from a compact
+// list of IDs and first sentences. Point Model at a small, cheap model;
+// when empty the run's model is used.
+type ModelToolSelector struct {
+	Provider provider.Provider
+	Model    string
+}
+
+func (s ModelToolSelector) Select(ctx context.Context, req pkgruntime.ToolSelection) ([]string, error) {
+	var prompt strings.Builder
+	fmt.Fprintf(&prompt, "Pick the tools an assistant is most likely to need for the request below. Reply with only a JSON array of at most %d tool IDs, most relevant first, or [] if no tool is needed.\n\nTools:\n", req.Limit)
+	for _, def := range req.Tools {
+		fmt.Fprintf(&prompt, "- %s: %s\n", def.ID, firstSentence(def.Description))
+	}
+	fmt.Fprintf(&prompt, "\nRequest:\n%s", req.Query)
+	model := s.Model
+	if model == "" {
+		model = req.Model
+	}
+	stream, err := s.Provider.Stream(ctx, provider.CompletionRequest{
+		Model:        provider.ModelRef{Provider: s.Provider.Name(), Model: model},
+		Messages:     []provider.Message{{Role: "user", Content: prompt.String()}},
+		ExtraHeaders: req.ExtraHeaders,
+		ExtraQuery:   req.ExtraQuery,
+		NoCache:      true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var reply strings.Builder
+	for event := range stream {
+		if event.Err != nil {
+			return nil, event.Err
+		}
+		switch event.Type {
+		case provider.StreamEventText:
+			reply.WriteString(event.Text)
+		case provider.StreamEventDone:
+			if req.Usage != nil {
+				req.Usage(s.Provider.Name(), model, event.InputTokens, event.OutputTokens)
+			}
+		}
+	}
+	text := reply.String()
+	first, last := strings.Index(text, "["), strings.LastIndex(text, "]")
+	if first < 0 || last < first {
+		return nil, fmt.Errorf("expected a JSON array of tool IDs, got %q", compactRuntimeText(text, 120))
+	}
+	var ids []string
+	if err := json.Unmarshal([]byte(text[first:last+1]), &ids); err != nil {
+		return nil, fmt.Errorf("parse selected tools: %w", err)
+	}
+	known := make(map[string]bool, len(req.Tools))
+	for _, def := range req.Tools {
+		known[def.ID] = true
+	}
+	out := ids[:0]
+	for _, id := range ids {
+		if known[id] {
+			out = append(out, id)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,58 @@
+package runtime
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+	"github.com/bitop-dev/agent/pkg/session"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// turnContexts persists what each provider call was sent, so `agent debug`
+// can replay a run step by step. The system prompt is written only when it
+// changes within the run.
+type turnContexts struct {
+	lastHash string
+}
+
+// record stores the context of a call that sent messages, of which the
+// first prefix messages of transcript were synthesized by the runtime and
+// anything past transcript (a retry nudge) was never stored.
+func (c *turnContexts) record(ctx context.Context, req pkgruntime.RunRequest, sessionID string, turn int, model string, transcript, messages []provider.Message, prefix int, tools []tool.Definition) {
+	if req.Sessions == nil {
+		return
+	}
+	sum := sha256.Sum256([]byte(req.SystemPrompt))
+	tc := session.TurnContext{
+		Turn:       turn,
+		Model:      model,
+		SystemHash: hex.EncodeToString(sum[:8]),
+		Prefix:     transcript[:prefix],
+		History:    len(transcript) - prefix,
+		Suffix:     messages[len(transcript):],
+	}
+	if tc.SystemHash != c.lastHash {
+		tc.System = req.SystemPrompt
+		c.lastHash = tc.SystemHash
+	}
+	for _, def := range tools {
+		tc.Tools = append(tc.Tools, def.ID)
+	}
+	data, err := json.Marshal(tc)
+	if err != nil {
+		return
+	}
+	_ = req.Sessions.Append(ctx, sessionID, session.Entry{
+		Kind:      session.EntryEvent,
+		EventType: session.EventTurnContext,
+		Content:   fmt.Sprintf("turn %d: %s, %d messages, %d tools", turn, model, len(messages), len(tools)),
+		Metadata:  string(data),
+		CreatedAt: time.Now(),
+	})
+}
@@ -0,0 +1,25 @@
+package runtime
+
+import (
+	"github.com/bitop-dev/agent/pkg/provider"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// toolModel describes model to the tools it calls, so they only return
+// images to models that can view them.
+func toolModel(p provider.Provider, model string) tool.Model {
+	vision, ok := p.(provider.VisionSupporter)
+	return tool.Model{Name: model, Vision: ok && vision.SupportsVision(model)}
+}
+
+// resultImages returns the images of a tool result for its tool message.
+func resultImages(images []tool.Image) []provider.Image {
+	if len(images) == 0 {
+		return nil
+	}
+	out := make([]provider.Image, len(images))
+	for i, img := range images {
+		out[i] = provider.Image{MediaType: img.MediaType, Data: img.Data}
+	}
+	return out
+}
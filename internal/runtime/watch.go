@@ -0,0 +1,176 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitop-dev/agent/internal/tools/core"
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+	"github.com/bitop-dev/agent/pkg/session"
+)
+
+// maxWatchedFiles bounds a workspace snapshot. A larger workspace is not
+// watched.
+const maxWatchedFiles = 20000
+
+var errTooManyFiles = errors.New("too many files")
+
+// fileStamp is what a snapshot keeps of a file. A change to either field is
+// a modification, as with make and git's stat check.
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// workspaceWatcher finds the files each turn changed by comparing
+// snapshots of the workspace taken before and after it, so the record does
+// not depend on what tools report. Files ignored by grep and glob are not
+// watched.
+type workspaceWatcher struct {
+	root     string
+	previous map[string]fileStamp // the snapshot ending the previous turn
+	disabled bool
+}
+
+// newWorkspaceWatcher returns a watcher when the profile sets
+// workspace.watch, or nil.
+func newWorkspaceWatcher(req pkgruntime.RunRequest) *workspaceWatcher {
+	if !req.Profile.Spec.Workspace.Watch {
+		return nil
+	}
+	root := req.Execution.Workspace.Root
+	if root == "" {
+		root = req.Execution.CWD
+	}
+	if root == "" {
+		return nil
+	}
+	return &workspaceWatcher{root: root}
+}
+
+// begin snapshots the workspace before a turn, unless the previous turn's
+// closing snapshot already describes it.
+func (w *workspaceWatcher) begin(ctx context.Context) error {
+	if w == nil || w.disabled || w.previous != nil {
+		return nil
+	}
+	stamps, err := w.snapshot(ctx)
+	if err != nil {
+		return w.stop(err)
+	}
+	w.previous = stamps
+	return nil
+}
+
+// end snapshots the workspace after a turn and returns the files that
+// changed during it, sorted by path.
+func (w *workspaceWatcher) end(ctx context.Context) ([]session.FileChange, error) {
+	if w == nil || w.disabled || w.previous == nil {
+		return nil, nil
+	}
+	after, err := w.snapshot(ctx)
+	if err != nil {
+		return nil, w.stop(err)
+	}
+	var changes []session.FileChange
+	for path, stamp := range after {
+		before, ok := w.previous[path]
+		switch {
+		case !ok:
+			changes = append(changes, session.FileChange{Path: path, Change: "added"})
+		case before != stamp:
+			changes = append(changes, session.FileChange{Path: path, Change: "modified"})
+		}
+	}
+	for path := range w.previous {
+		if _, ok := after[path]; !ok {
+			changes = append(changes, session.FileChange{Path: path, Change: "deleted"})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	w.previous = after
+	return changes, nil
+}
+
+// stop turns the watcher off for the rest of the run when the workspace is
+// too large, and otherwise skips the turn.
+func (w *workspaceWatcher) stop(err error) error {
+	w.previous = nil
+	if !errors.Is(err, errTooManyFiles) {
+		return err
+	}
+	w.disabled = true
+	return fmt.Errorf("workspace watch stopped: more than %d files under %s", maxWatchedFiles, w.root)
+}
+
+// snapshot stamps every watched file by its slash-separated path relative
+// to the root.
+func (w *workspaceWatcher) snapshot(ctx context.Context) (map[string]fileStamp, error) {
+	ignored := core.IgnoreFunc(w.root)
+	stamps := map[string]fileStamp{}
+	err := filepath.WalkDir(w.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if path != w.root && ignored(path, d.IsDir()) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if len(stamps) == maxWatchedFiles {
+			return errTooManyFiles
+		}
+		rel, _ := filepath.Rel(w.root, path)
+		stamps[filepath.ToSlash(rel)] = fileStamp{size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stamps, nil
+}
+
+// recordFileChanges stores a turn's changed files in the session.
+func recordFileChanges(ctx context.Context, req pkgruntime.RunRequest, sessionID string, turn int, changes []session.FileChange) {
+	if req.Sessions == nil || len(changes) == 0 {
+		return
+	}
+	data, err := json.Marshal(session.TurnFiles{Turn: turn, Files: changes})
+	if err != nil {
+		return
+	}
+	_ = req.Sessions.Append(ctx, sessionID, session.Entry{
+		Kind:      session.EntryEvent,
+		EventType: session.EventFilesChanged,
+		Content:   fmt.Sprintf("turn %d: %s", turn, describeFileChanges(changes)),
+		Metadata:  string(data),
+		CreatedAt: time.Now(),
+	})
+}
+
+// describeFileChanges summarizes changes as "a.go (modified), b.go (added)".
+func describeFileChanges(changes []session.FileChange) string {
+	parts := make([]string, len(changes))
+	for i, c := range changes {
+		parts[i] = c.Path + " (" + c.Change + ")"
+	}
+	return strings.Join(parts, ", ")
+}
@@ -1,32 +1,55 @@
 package service
 
 import (
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	internalapproval "github.com/bitop-dev/agent/internal/approval"
+	"github.com/bitop-dev/agent/internal/audit"
+	"github.com/bitop-dev/agent/internal/budget"
 	internalhost "github.com/bitop-dev/agent/internal/host"
 	internalmcp "github.com/bitop-dev/agent/internal/mcp"
+	internalmemory "github.com/bitop-dev/agent/internal/memory"
 	"github.com/bitop-dev/agent/internal/plugin"
 	internalpolicy "github.com/bitop-dev/agent/internal/policy"
 	profileloader "github.com/bitop-dev/agent/internal/profile"
 	"github.com/bitop-dev/agent/internal/providers/anthropic"
+	"github.com/bitop-dev/agent/internal/providers/cerebras"
+	"github.com/bitop-dev/agent/internal/providers/cohere"
+	"github.com/bitop-dev/agent/internal/providers/deepseek"
+	"github.com/bitop-dev/agent/internal/providers/google"
+	"github.com/bitop-dev/agent/internal/providers/groq"
+	"github.com/bitop-dev/agent/internal/providers/logging"
 	"github.com/bitop-dev/agent/internal/providers/mock"
 	"github.com/bitop-dev/agent/internal/providers/openai"
+	"github.com/bitop-dev/agent/internal/providers/replay"
+	"github.com/bitop-dev/agent/internal/providers/voyage"
 	"github.com/bitop-dev/agent/internal/registry"
 	internalruntime "github.com/bitop-dev/agent/internal/runtime"
+	"github.com/bitop-dev/agent/internal/store/remote"
 	store "github.com/bitop-dev/agent/internal/store/sqlite"
+	"github.com/bitop-dev/agent/internal/sysprompt"
+	"github.com/bitop-dev/agent/internal/tenant"
 	coretools "github.com/bitop-dev/agent/internal/tools/core"
+	skilltools "github.com/bitop-dev/agent/internal/tools/skill"
 	"github.com/bitop-dev/agent/pkg/approval"
 	"github.com/bitop-dev/agent/pkg/config"
+	"github.com/bitop-dev/agent/pkg/memory"
 	"github.com/bitop-dev/agent/pkg/policy"
 	"github.com/bitop-dev/agent/pkg/profile"
 	"github.com/bitop-dev/agent/pkg/provider"
 	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
 	"github.com/bitop-dev/agent/pkg/session"
+	"github.com/bitop-dev/agent/pkg/skills"
 	"github.com/bitop-dev/agent/pkg/tool"
 	"github.com/bitop-dev/agent/pkg/workspace"
 )
@@ -46,47 +69,169 @@ type App struct {
 	HostCaps         *internalhost.RuntimeCapabilities
 	Runner           pkgruntime.Runner
 	Sessions         session.Store
+	Memory           memory.Stores              // each run's project notes, by working directory
+	Approvals        internalapproval.FileStore // pending out-of-band approvals
+	Ledger           pkgruntime.SpendLedger     // spend history for daily budgets
+	Audit            pkgruntime.AuditLog        // nil unless audit.enabled is set
+	Tenants          *tenant.Gateway            // API-key clients of the HTTP worker; nil when serve.keysFile is unset
+	Embedder         provider.Embedder          // nil when embeddings are not configured
+	EmbeddingModel   string
+	Skills           []skills.Skill // discovered skill directories
+	// PromptRenderer lets embedders own the system prompt layout. When nil,
+	// sections are joined with a blank line.
+	PromptRenderer sysprompt.Renderer
 }
 
-func Bootstrap(cwd string) (App, error) {
+// Bootstrap loads the configuration for cwd, with the named environment
+// overlay merged in (see config.LoadEnvironment), and builds the App.
+func Bootstrap(cwd, environment string) (App, error) {
 	paths, err := config.DefaultPaths(cwd)
 	if err != nil {
 		return App{}, err
 	}
-	cfg, err := config.Load(paths)
+	cfg, err := config.LoadEnvironment(paths, environment)
 	if err != nil {
 		return App{}, err
 	}
+	memoryStores := &internalmemory.ProjectStores{Dir: paths.MemoryDir}
 	toolRegistry := registry.NewToolRegistry()
-	for _, t := range []tool.Tool{coretools.ReadTool{}, coretools.WriteTool{}, coretools.EditTool{}, coretools.BashTool{}, coretools.GlobTool{}, coretools.GrepTool{}} {
+	for _, t := range append([]tool.Tool{
+		coretools.ReadTool{}, coretools.WriteTool{}, coretools.EditTool{}, coretools.BashTool{}, coretools.GlobTool{}, coretools.GrepTool{},
+		coretools.MemoryReadTool{Stores: memoryStores, CWD: paths.CWD},
+		coretools.MemoryWriteTool{Stores: memoryStores, CWD: paths.CWD},
+		coretools.PlanTool{},
+	}, coretools.GitTools()...) {
 		if err := toolRegistry.Register(t); err != nil {
 			return App{}, err
 		}
 	}
+	// Semantic search is only offered when an embedding provider is configured.
+	embedder, embeddingModel := newEmbedder(cfg)
+	if embedder != nil {
+		if err := toolRegistry.Register(&coretools.SemanticSearchTool{
+			Embedder:  embedder,
+			Model:     embeddingModel,
+			Root:      paths.CWD,
+			IndexPath: indexPath(paths, embeddingModel),
+		}); err != nil {
+			return App{}, err
+		}
+	}
+	// Skills register their load, read, and script tools under the skill's
+	// name; profiles opt in with spec.skills.
+	discoveredSkills, err := skills.Discover(paths.LocalSkillsDir, paths.UserSkillsDir)
+	if err != nil {
+		return App{}, err
+	}
+	for _, s := range discoveredSkills {
+		for _, t := range skilltools.Tools(s) {
+			if err := toolRegistry.Register(t); err != nil {
+				return App{}, fmt.Errorf("skill %s: %w", s.Name, err)
+			}
+		}
+	}
 	providerRegistry := registry.NewProviderRegistry()
 	if err := providerRegistry.Register(mock.Provider{}); err != nil {
 		return App{}, err
 	}
+	stalls := make(map[string]time.Duration)
+	for _, name := range []string{"openai", "anthropic", "deepseek", "groq", "cerebras", "cohere"} {
+		if stalls[name], err = cfg.StallTimeout(name); err != nil {
+			return App{}, err
+		}
+	}
 	if err := providerRegistry.Register(openai.Provider{
-		BaseURL: cfg.Providers["openai"].BaseURL,
-		APIKey:  cfg.Providers["openai"].APIKey,
-		APIMode: cfg.Providers["openai"].APIMode,
+		BaseURL:        cfg.Providers["openai"].BaseURL,
+		APIKey:         cfg.Providers["openai"].APIKey,
+		APIMode:        cfg.Providers["openai"].APIMode,
+		PromptCacheKey: cfg.Providers["openai"].PromptCacheKey,
+		ServerState:    cfg.Providers["openai"].ServerState,
+		ServiceTier:    cfg.Providers["openai"].ServiceTier,
+		StallTimeout:   stalls["openai"],
+		Organization:   cfg.Providers["openai"].Organization,
+		Project:        cfg.Providers["openai"].Project,
+		Headers:        cfg.Providers["openai"].Headers,
+		Query:          cfg.Providers["openai"].Query,
 	}); err != nil {
 		return App{}, err
 	}
 	// Register Anthropic provider if configured.
 	if anthropicCfg := cfg.Providers["anthropic"]; anthropicCfg.APIKey != "" {
 		if err := providerRegistry.Register(anthropic.Provider{
-			APIKey:  anthropicCfg.APIKey,
-			BaseURL: anthropicCfg.BaseURL,
+			APIKey:         anthropicCfg.APIKey,
+			BaseURL:        anthropicCfg.BaseURL,
+			ThinkingBudget: anthropicCfg.ThinkingBudget,
+			NoCache:        anthropicCfg.NoCache,
+			StallTimeout:   stalls["anthropic"],
+			Beta:           anthropicCfg.Beta,
+			Headers:        anthropicCfg.Headers,
+			Query:          anthropicCfg.Query,
 		}); err != nil {
 			return App{}, err
 		}
 	} else if apiKey := os.Getenv("ANTHROPIC_API_KEY"); apiKey != "" {
-		if err := providerRegistry.Register(anthropic.Provider{APIKey: apiKey}); err != nil {
+		if err := providerRegistry.Register(anthropic.Provider{APIKey: apiKey, ThinkingBudget: anthropicCfg.ThinkingBudget, NoCache: anthropicCfg.NoCache, StallTimeout: stalls["anthropic"], Beta: anthropicCfg.Beta, Headers: anthropicCfg.Headers, Query: anthropicCfg.Query}); err != nil {
+			return App{}, err
+		}
+	}
+	// Register DeepSeek provider if configured.
+	if deepseekCfg := cfg.Providers["deepseek"]; deepseekCfg.APIKey != "" {
+		if err := providerRegistry.Register(deepseek.Provider{
+			APIKey:       deepseekCfg.APIKey,
+			BaseURL:      deepseekCfg.BaseURL,
+			StallTimeout: stalls["deepseek"],
+			Headers:      deepseekCfg.Headers,
+			Query:        deepseekCfg.Query,
+		}); err != nil {
+			return App{}, err
+		}
+	} else if apiKey := os.Getenv("DEEPSEEK_API_KEY"); apiKey != "" {
+		if err := providerRegistry.Register(deepseek.Provider{APIKey: apiKey, StallTimeout: stalls["deepseek"], Headers: cfg.Providers["deepseek"].Headers, Query: cfg.Providers["deepseek"].Query}); err != nil {
+			return App{}, err
+		}
+	}
+	// Register Groq and Cerebras providers if configured.
+	if groqCfg := cfg.Providers["groq"]; cmp.Or(groqCfg.APIKey, os.Getenv("GROQ_API_KEY")) != "" {
+		if err := providerRegistry.Register(groq.Provider{
+			APIKey:       cmp.Or(groqCfg.APIKey, os.Getenv("GROQ_API_KEY")),
+			BaseURL:      groqCfg.BaseURL,
+			ServiceTier:  groqCfg.ServiceTier,
+			StallTimeout: stalls["groq"],
+			Headers:      groqCfg.Headers,
+			Query:        groqCfg.Query,
+		}); err != nil {
+			return App{}, err
+		}
+	}
+	if cerebrasCfg := cfg.Providers["cerebras"]; cmp.Or(cerebrasCfg.APIKey, os.Getenv("CEREBRAS_API_KEY")) != "" {
+		if err := providerRegistry.Register(cerebras.Provider{
+			APIKey:       cmp.Or(cerebrasCfg.APIKey, os.Getenv("CEREBRAS_API_KEY")),
+			BaseURL:      cerebrasCfg.BaseURL,
+			ServiceTier:  cerebrasCfg.ServiceTier,
+			StallTimeout: stalls["cerebras"],
+			Headers:      cerebrasCfg.Headers,
+			Query:        cerebrasCfg.Query,
+		}); err != nil {
+			return App{}, err
+		}
+	}
+	if cohereCfg := cfg.Providers["cohere"]; cmp.Or(cohereCfg.APIKey, os.Getenv("COHERE_API_KEY")) != "" {
+		if err := providerRegistry.Register(cohere.Provider{
+			APIKey:       cmp.Or(cohereCfg.APIKey, os.Getenv("COHERE_API_KEY")),
+			BaseURL:      cohereCfg.BaseURL,
+			StallTimeout: stalls["cohere"],
+			Headers:      cohereCfg.Headers,
+			Query:        cohereCfg.Query,
+		}); err != nil {
 			return App{}, err
 		}
 	}
+	if err := setupReplay(providerRegistry); err != nil {
+		return App{}, err
+	}
+	if err := setupProviderLog(providerRegistry, cfg); err != nil {
+		return App{}, err
+	}
 	pluginRegistry := registry.NewPluginRegistry()
 	promptRegistry := registry.NewPromptRegistry()
 	profileTemplateRegistry := registry.NewProfileTemplateRegistry()
@@ -98,16 +243,24 @@ func Bootstrap(cwd string) (App, error) {
 		},
 		InstallRoot:   paths.UserProfilesDir,
 		PluginSources: cfg.PluginSources,
+		Skills:        discoveredSkills,
+		ToolPresets:   cfg.Tools.Presets,
 	}
+	ledger := &budget.FileLedger{Path: paths.SpendFile}
+	auditLog := newAuditLog(paths, cfg.Audit)
 	hostCaps := &internalhost.RuntimeCapabilities{
-		Profiles:   profileLoaderInst,
-		Tools:      toolRegistry,
-		Providers:  providerRegistry,
-		Prompts:    promptRegistry,
-		Config:     cfg,
-		GatewayURL: os.Getenv("GATEWAY_URL"),
-		DefaultCWD: paths.CWD,
-		MaxDepth:   2,
+		Ledger:         ledger,
+		Audit:          auditLog,
+		Profiles:       profileLoaderInst,
+		Tools:          toolRegistry,
+		Providers:      providerRegistry,
+		Prompts:        promptRegistry,
+		Config:         cfg,
+		GatewayURL:     os.Getenv("GATEWAY_URL"),
+		DefaultCWD:     paths.CWD,
+		MaxDepth:       2,
+		Embedder:       embedder,
+		EmbeddingModel: embeddingModel,
 	}
 	pluginLoader := plugin.Loader{Roots: []string{
 		paths.LocalPluginsDir,
@@ -131,6 +284,10 @@ func Bootstrap(cwd string) (App, error) {
 	}); err != nil {
 		return App{}, err
 	}
+	sessions, err := newSessionStore(paths, cfg.Sessions)
+	if err != nil {
+		return App{}, err
+	}
 	app := App{
 		Paths:            paths,
 		Config:           cfg,
@@ -145,11 +302,216 @@ func Bootstrap(cwd string) (App, error) {
 		MCPManager:       mcpManager,
 		HostCaps:         hostCaps,
 		Runner:           internalruntime.Runner{},
-		Sessions:         store.Store{Path: filepath.Join(paths.SessionsDir, "sessions.db")},
+		Sessions:         sessions,
+		Memory:           memoryStores,
+		Approvals:        internalapproval.FileStore{Dir: paths.ApprovalsDir},
+		Ledger:           ledger,
+		Audit:            auditLog,
+		Embedder:         embedder,
+		EmbeddingModel:   embeddingModel,
+		Skills:           discoveredSkills,
+	}
+	if keysFile := cfg.Serve.KeysFile; keysFile != "" {
+		if !filepath.IsAbs(keysFile) {
+			keysFile = filepath.Join(paths.ConfigDir, keysFile)
+		}
+		app.Tenants = &tenant.Gateway{
+			Keys:  &tenant.FileKeyStore{Path: keysFile},
+			Usage: &tenant.FileUsage{Path: paths.UsageFile},
+		}
 	}
 	return app, nil
 }
 
+// setupReplay wraps the registered providers to record their traffic to
+// the file named by AGENT_RECORD, or replaces them with the recording named
+// by AGENT_REPLAY so a run can be reproduced without calling a model API.
+// AGENT_REPLAY_STRICT=1 fails requests that do not match the recording.
+func setupReplay(providers *registry.ProviderRegistry) error {
+	recordPath, replayPath := os.Getenv("AGENT_RECORD"), os.Getenv("AGENT_REPLAY")
+	switch {
+	case recordPath != "" && replayPath != "":
+		return fmt.Errorf("AGENT_RECORD and AGENT_REPLAY cannot both be set")
+	case recordPath != "":
+		recorder, err := replay.NewRecorder(recordPath)
+		if err != nil {
+			return fmt.Errorf("AGENT_RECORD: %w", err)
+		}
+		for _, name := range providers.List() {
+			inner, _ := providers.Get(name)
+			if err := providers.Register(recorder.Wrap(inner)); err != nil {
+				return err
+			}
+		}
+	case replayPath != "":
+		recording, err := replay.Load(replayPath)
+		if err != nil {
+			return fmt.Errorf("AGENT_REPLAY: %w", err)
+		}
+		recording.Strict = os.Getenv("AGENT_REPLAY_STRICT") == "1"
+		for _, name := range recording.Providers() {
+			if err := providers.Register(recording.Provider(name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// providerKeyEnv lists the environment variables providers and embedders
+// read their API keys from, redacted from the provider log.
+var providerKeyEnv = []string{
+	"OPENAI_API_KEY", "ANTHROPIC_API_KEY", "DEEPSEEK_API_KEY", "GROQ_API_KEY", "CEREBRAS_API_KEY",
+	"COHERE_API_KEY", "VOYAGE_API_KEY", "GEMINI_API_KEY", "GOOGLE_API_KEY",
+}
+
+// setupProviderLog wraps the registered providers to log every request and
+// response, redacted, to the file named by AGENT_PROVIDER_LOG.
+// AGENT_PROVIDER_LOG_SAMPLE logs only that fraction of requests (failed ones
+// are always logged), AGENT_PROVIDER_LOG_MAX_BYTES replaces longer strings
+// such as file contents with their size, and AGENT_PROVIDER_LOG_REDACT adds
+// a regular expression to redact besides API keys and common token formats.
+func setupProviderLog(providers *registry.ProviderRegistry, cfg config.Config) error {
+	path := os.Getenv("AGENT_PROVIDER_LOG")
+	if path == "" {
+		return nil
+	}
+	logger, err := logging.NewLogger(path)
+	if err != nil {
+		return fmt.Errorf("AGENT_PROVIDER_LOG: %w", err)
+	}
+	if value := os.Getenv("AGENT_PROVIDER_LOG_SAMPLE"); value != "" {
+		sample, err := strconv.ParseFloat(value, 64)
+		if err != nil || sample < 0 || sample > 1 {
+			return fmt.Errorf("AGENT_PROVIDER_LOG_SAMPLE must be a number from 0 to 1, got %q", value)
+		}
+		logger.Sample = sample
+	}
+	if value := os.Getenv("AGENT_PROVIDER_LOG_MAX_BYTES"); value != "" {
+		maxBytes, err := strconv.Atoi(value)
+		if err != nil || maxBytes < 0 {
+			return fmt.Errorf("AGENT_PROVIDER_LOG_MAX_BYTES must be a byte count, got %q", value)
+		}
+		logger.MaxBytes = maxBytes
+	}
+	if value := os.Getenv("AGENT_PROVIDER_LOG_REDACT"); value != "" {
+		pattern, err := regexp.Compile(value)
+		if err != nil {
+			return fmt.Errorf("AGENT_PROVIDER_LOG_REDACT: %w", err)
+		}
+		logger.Patterns = append(logger.Patterns, pattern)
+	}
+	for _, providerCfg := range cfg.Providers {
+		logger.Secrets = append(logger.Secrets, providerCfg.APIKey)
+	}
+	for _, name := range providerKeyEnv {
+		logger.Secrets = append(logger.Secrets, os.Getenv(name))
+	}
+	for _, name := range providers.List() {
+		inner, _ := providers.Get(name)
+		if err := providers.Register(logger.Wrap(inner)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newAuditLog returns the tool audit log when cfg enables it.
+func newAuditLog(paths config.Paths, cfg config.AuditConfig) pkgruntime.AuditLog {
+	if !cfg.Enabled {
+		return nil
+	}
+	path := cmp.Or(cfg.Path, paths.AuditFile)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(paths.ConfigDir, path)
+	}
+	return &audit.FileLog{Path: path}
+}
+
+// newSessionStore returns the session database, mirrored to the bucket of
+// cfg.Remote when it names one.
+func newSessionStore(paths config.Paths, cfg config.SessionsConfig) (session.Store, error) {
+	local := store.Store{Path: filepath.Join(paths.SessionsDir, "sessions.db"), Compress: cfg.Compress}
+	if cfg.Remote.URL == "" {
+		return local, nil
+	}
+	bucket, prefix, err := remote.ParseURL(cfg.Remote.URL)
+	if err != nil {
+		return nil, fmt.Errorf("sessions.remote.url: %w", err)
+	}
+	if cfg.Remote.Endpoint != "" {
+		bucket.Endpoint = cfg.Remote.Endpoint
+	}
+	if cfg.Remote.Region != "" {
+		bucket.Region = cfg.Remote.Region
+	}
+	bucket.AccessKey, bucket.SecretKey = cfg.Remote.AccessKey, cfg.Remote.SecretKey
+	if bucket.AccessKey == "" {
+		bucket.AccessKey, bucket.SecretKey = os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+		bucket.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	interval := time.Minute
+	if cfg.Remote.SyncInterval != "" {
+		interval, err = time.ParseDuration(cfg.Remote.SyncInterval)
+		if err != nil || interval < 0 {
+			return nil, fmt.Errorf("sessions.remote.syncInterval: invalid duration %q", cfg.Remote.SyncInterval)
+		}
+	}
+	return &remote.Store{Local: local, Bucket: bucket, Prefix: prefix, Interval: interval, Warnings: os.Stderr}, nil
+}
+
+// newEmbedder builds the embedder selected by cfg.Embeddings and returns it
+// with the resolved model name. It returns nil when embeddings are not
+// configured or the provider has no credentials.
+func newEmbedder(cfg config.Config) (provider.Embedder, string) {
+	embCfg := cfg.Embeddings
+	provCfg := cfg.Providers[embCfg.Provider]
+	switch embCfg.Provider {
+	case "openai":
+		if provCfg.APIKey == "" {
+			return nil, ""
+		}
+		return openai.Provider{
+			BaseURL:      provCfg.BaseURL,
+			APIKey:       provCfg.APIKey,
+			Organization: provCfg.Organization,
+			Project:      provCfg.Project,
+			Headers:      provCfg.Headers,
+			Query:        provCfg.Query,
+		}, firstNonEmpty(embCfg.Model, "text-embedding-3-small")
+	case "voyage":
+		apiKey := firstNonEmpty(provCfg.APIKey, os.Getenv("VOYAGE_API_KEY"))
+		if apiKey == "" {
+			return nil, ""
+		}
+		return voyage.Embedder{APIKey: apiKey, BaseURL: provCfg.BaseURL, Headers: provCfg.Headers, Query: provCfg.Query}, firstNonEmpty(embCfg.Model, "voyage-code-3")
+	case "google":
+		apiKey := firstNonEmpty(provCfg.APIKey, os.Getenv("GEMINI_API_KEY"), os.Getenv("GOOGLE_API_KEY"))
+		if apiKey == "" {
+			return nil, ""
+		}
+		return google.Embedder{APIKey: apiKey, BaseURL: provCfg.BaseURL, Headers: provCfg.Headers, Query: provCfg.Query}, firstNonEmpty(embCfg.Model, "text-embedding-004")
+	default:
+		return nil, ""
+	}
+}
+
+// indexPath returns the retrieval index file for the workspace and model,
+// under ~/.agent/index.
+func indexPath(paths config.Paths, model string) string {
+	sum := sha256.Sum256([]byte(paths.CWD + "\x00" + model))
+	return filepath.Join(paths.ConfigDir, "index", hex.EncodeToString(sum[:8])+".gob")
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
 // autoPopulatePluginConfigs fills missing plugin config values from:
 // 1. Property.EnvVar — explicit env var name from plugin.yaml
 // 2. Convention: AGENT_PLUGIN_<PLUGINNAME>_<KEY> (uppercase, hyphens→underscores)
@@ -205,6 +567,11 @@ func (a App) ResolveProvider(name string) (provider.Provider, error) {
 }
 
 func (a App) ResolveTools(enabled []string) ([]tool.Tool, error) {
+	// tools.include and tools.exclude in config apply to every profile.
+	enabled, err := a.Config.Tools.Filter(enabled, a.Tools.QualifiedID)
+	if err != nil {
+		return nil, err
+	}
 	tools := make([]tool.Tool, 0, len(enabled))
 	var missing []string
 	for _, id := range enabled {
@@ -263,12 +630,15 @@ func (a App) installMissingPlugins(missingTools []string) bool {
 		if err != nil {
 			continue
 		}
-		// Enable the plugin only if it has no required config that isn't set.
+		// Enable the plugin only if it has no required config that isn't set,
+		// counting the environment's overlay, as Bootstrap would load it.
+		// The record is saved to the file as written.
 		cfg, err := config.Load(a.Paths)
-		if err == nil {
+		effective, effErr := config.LoadEnvironment(a.Paths, a.Config.Environment)
+		if err == nil && effErr == nil {
 			cfg.SetPluginInstallRecord(name, result.Version, result.Source)
 			// Check if the plugin can be enabled without config.
-			pluginCfg := cfg.Plugins[name]
+			pluginCfg := effective.Plugins[name]
 			if err := plugin.ValidateConfig(result.Manifest, pluginCfg); err == nil {
 				cfg.SetPluginEnabled(name, true)
 			} else {
@@ -285,7 +655,7 @@ func (a App) installMissingPlugins(missingTools []string) bool {
 	}
 
 	// Re-discover and register all plugins (including newly installed ones).
-	cfg, _ := config.Load(a.Paths)
+	cfg, _ := config.LoadEnvironment(a.Paths, a.Config.Environment)
 	pluginLoader := plugin.Loader{
 		Roots: []string{a.Paths.LocalPluginsDir, a.Paths.UserPluginsDir},
 		Enable: func(name string) bool { return cfg.IsPluginEnabled(name) },
@@ -309,18 +679,34 @@ func (a App) installMissingPlugins(missingTools []string) bool {
 func (a App) BuildPolicy(workspaceRef workspace.Workspace, manifest profile.Manifest, profilePath string) policy.Engine {
 	overrides, err := internalpolicy.LoadToolOverrides(profilePath, manifest.Spec.Policy.Overlays)
 	if err != nil {
-		overrides = internalpolicy.OverlayDecisions{Tools: map[string]policy.DecisionKind{}}
+		overrides = internalpolicy.OverlayDecisions{Tools: map[string]policy.DecisionKind{}, Tags: map[string]policy.DecisionKind{}}
 	}
 	return internalpolicy.Engine{
 		Workspace:      workspaceRef,
-		ReadOnly:       internalpolicy.IsReadOnly(manifest.Spec.Workspace.WriteScope, manifest.Spec.Tools.Enabled),
+		ReadOnly:       internalpolicy.IsReadOnly(manifest.Spec.Workspace.WriteScope, manifest.Spec.Tools.Enabled, a.Tools),
 		SensitiveTools: a.sensitiveToolsFor(manifest.Spec.Tools.Enabled),
 		ToolOverrides:  overrides.Tools,
+		TagOverrides:   overrides.Tags,
 		ShellOverride:  overrides.Shell,
 		NetOverride:    overrides.Network,
 	}
 }
 
+// BuildTitler returns the titler for new sessions, or nil when
+// sessions.titles is off.
+func (a App) BuildTitler(prov provider.Provider) pkgruntime.Titler {
+	if !a.Config.Sessions.Titles.Enabled || prov == nil {
+		return nil
+	}
+	return internalruntime.ModelTitler{Provider: prov, Model: a.Config.Sessions.Titles.Model}
+}
+
+// BuildToolSelector returns the per-turn tool selector for the profile's
+// tools.select spec, or nil when selection is disabled.
+func (a App) BuildToolSelector(manifest profile.Manifest, prov provider.Provider) (pkgruntime.ToolSelector, error) {
+	return internalruntime.NewToolSelector(manifest.Spec.Tools.Select, prov, a.Embedder, a.EmbeddingModel)
+}
+
 func (a App) BuildApprovalResolver(mode string) approval.Resolver {
 	resolved := approval.Mode(mode)
 	if resolved == "" {
@@ -329,7 +715,33 @@ func (a App) BuildApprovalResolver(mode string) approval.Resolver {
 	if resolved == "" {
 		resolved = approval.ModeOnRequest
 	}
-	return internalapproval.CLIResolver{Mode: resolved, Reader: os.Stdin, Writer: os.Stdout}
+	if resolved == approval.ModeWebhook {
+		return a.webhookResolver()
+	}
+	return internalapproval.CLIResolver{Mode: resolved, Reader: os.Stdin, Writer: os.Stdout, Color: colorTerminal(os.Stdout)}
+}
+
+// colorTerminal reports whether f is a terminal to color output on, unless
+// NO_COLOR is set.
+func colorTerminal(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (a App) webhookResolver() approval.Resolver {
+	cfg := a.Config.Approvals
+	timeout, _ := time.ParseDuration(cfg.Timeout)
+	return internalapproval.WebhookResolver{
+		URL:         cfg.WebhookURL,
+		Format:      cfg.Format,
+		Secret:      cfg.Secret,
+		CallbackURL: cfg.CallbackURL,
+		Timeout:     timeout,
+		Store:       a.Approvals,
+	}
 }
 
 func (a App) sensitiveToolsFor(enabled []string) map[string]policy.RiskLevel {
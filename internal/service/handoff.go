@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/session"
+)
+
+// Handoff moves a session's conversation to another profile and model, for
+// example to escalate from a small model to a larger one when it is stuck.
+// It appends an EntryHandoff marker; the history stays in place, and later
+// runs and resumes of the session use the new profile. An empty
+// FromProfile is filled from the session.
+func (a App) Handoff(ctx context.Context, sessionID string, h session.Handoff) (session.Handoff, error) {
+	if a.Sessions == nil {
+		return h, errors.New("session store is not configured")
+	}
+	if h.ToProfile == "" {
+		return h, errors.New("handoff requires a target profile")
+	}
+	loaded, err := a.Sessions.Load(ctx, sessionID)
+	if err != nil {
+		return h, err
+	}
+	if _, _, err := a.Profiles.Load(ctx, h.ToProfile); err != nil {
+		return h, fmt.Errorf("handoff target %s: %w", h.ToProfile, err)
+	}
+	if h.FromProfile == "" {
+		h.FromProfile, h.FromModel = loaded.Active()
+	}
+	data, err := json.Marshal(h)
+	if err != nil {
+		return h, err
+	}
+	content := fmt.Sprintf("handoff from %s to %s", describeAgent(h.FromProfile, h.FromModel), describeAgent(h.ToProfile, h.ToModel))
+	if h.Reason != "" {
+		content += ": " + h.Reason
+	}
+	if err := a.Sessions.Append(ctx, sessionID, session.Entry{Kind: session.EntryHandoff, Role: "system", Content: content, Metadata: string(data), CreatedAt: time.Now()}); err != nil {
+		return h, err
+	}
+	return h, session.Close(ctx, a.Sessions, sessionID)
+}
+
+func describeAgent(profile, model string) string {
+	if model == "" {
+		return profile
+	}
+	return profile + " (" + model + ")"
+}
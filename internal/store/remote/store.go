@@ -0,0 +1,448 @@
+// Package remote mirrors sessions to object storage, so agents running in
+// ephemeral containers keep their transcripts after the container is gone.
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/session"
+)
+
+// Bucket stores objects by key. Get of a missing key returns an error
+// wrapping fs.ErrNotExist.
+type Bucket interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// Objects of a mirrored session, under <prefix>/<id>/.
+const (
+	metadataObject = "metadata.json"
+	sessionObject  = "session.json"
+)
+
+// Store is a session.Store that keeps sessions in Local and mirrors them
+// to Bucket: a session is written through when its run closes it, and at
+// most once per Interval, in the background, while a long run appends to
+// it. Sessions missing from Local are loaded from the bucket and copied
+// back, and List, Count, and MostRecent include them, from a listing kept
+// for listTTL; while the bucket cannot be listed they cover Local only.
+// Search only covers Local.
+type Store struct {
+	Local    session.Store
+	Bucket   Bucket
+	Prefix   string        // key prefix of the mirrored sessions
+	Interval time.Duration // 0 writes sessions through only on Close
+	// Warnings receives what the store worked around, such as a bucket
+	// that could not be listed; nil discards it.
+	Warnings io.Writer
+
+	mu       sync.Mutex
+	dirty    map[string]bool
+	synced   map[string]time.Time
+	uploads  map[string]chan struct{} // closed when the upload of a session in flight ends
+	listed   []session.Metadata       // the bucket's sessions, as last listed
+	listedAt time.Time
+}
+
+// listTTL is how long a listing of the bucket is reused. Sessions this
+// store writes through are kept current in it meanwhile.
+const listTTL = time.Minute
+
+func (s *Store) Create(ctx context.Context, meta session.Metadata) (session.Session, error) {
+	sess, err := s.Local.Create(ctx, meta)
+	if err == nil {
+		s.markDirty(sess.Metadata.ID)
+	}
+	return sess, err
+}
+
+// Load returns the local session, or else the mirrored one, which is
+// copied to Local so the session can be continued.
+func (s *Store) Load(ctx context.Context, id string) (session.Session, error) {
+	sess, err := s.Local.Load(ctx, id)
+	if err == nil {
+		return sess, nil
+	}
+	data, getErr := s.Bucket.Get(ctx, s.key(id, sessionObject))
+	if errors.Is(getErr, fs.ErrNotExist) {
+		return session.Session{}, err
+	}
+	if getErr != nil {
+		return session.Session{}, fmt.Errorf("load session %s from remote: %w", id, getErr)
+	}
+	if err := json.Unmarshal(data, &sess); err != nil {
+		return session.Session{}, fmt.Errorf("remote session %s: %w", id, err)
+	}
+	if err := s.restore(ctx, sess); err != nil {
+		return session.Session{}, fmt.Errorf("restore session %s: %w", id, err)
+	}
+	return s.Local.Load(ctx, id)
+}
+
+// restore copies a mirrored session to Local, whole when Local is a
+// session.Importer. Otherwise it is created and appended to entry by
+// entry, which stamps it as updated now.
+func (s *Store) restore(ctx context.Context, sess session.Session) error {
+	if importer, ok := s.Local.(session.Importer); ok {
+		if err := importer.Import(ctx, sess); err != nil {
+			return err
+		}
+		s.markSynced(sess.Metadata.ID)
+		return nil
+	}
+	if _, err := s.Local.Create(ctx, sess.Metadata); err != nil {
+		return err
+	}
+	for _, entry := range sess.Entries {
+		if err := s.Local.Append(ctx, sess.Metadata.ID, entry); err != nil {
+			return err
+		}
+	}
+	if collector, ok := s.Local.(session.Collector); ok && sess.Metadata.Pinned {
+		if err := collector.Pin(ctx, sess.Metadata.ID, true); err != nil {
+			return err
+		}
+	}
+	s.markSynced(sess.Metadata.ID)
+	return nil
+}
+
+// Append appends to Local, then, when Interval has passed since the
+// session was last written through, writes it through in the background.
+// A failed write is retried on the next one and reported by Close.
+func (s *Store) Append(ctx context.Context, id string, entry session.Entry) error {
+	if err := s.Local.Append(ctx, id, entry); err != nil {
+		return err
+	}
+	s.markDirty(id)
+	if s.Interval > 0 && s.due(id) {
+		s.syncLater(ctx, id)
+	}
+	return nil
+}
+
+// syncLater writes session id through from a snapshot taken now, leaving
+// the upload to a goroutine so a slow bucket does not hold up the run. It
+// does nothing while an upload of the session is in flight; the session
+// stays dirty for the next one.
+func (s *Store) syncLater(ctx context.Context, id string) {
+	release, ok := s.claim(id, false)
+	if !ok {
+		return
+	}
+	sess, err := s.snapshot(ctx, id)
+	if err != nil {
+		release()
+		return
+	}
+	go func() {
+		defer release()
+		if err := s.upload(context.WithoutCancel(ctx), sess); err != nil {
+			s.markDirty(id)
+		}
+	}()
+}
+
+// Close closes the session in Local, when Local holds back writes too, and
+// writes it through if it changed since it last was, after any upload of
+// it in flight.
+func (s *Store) Close(ctx context.Context, id string) error {
+	if closer, ok := s.Local.(session.Closer); ok {
+		if err := closer.Close(ctx, id); err != nil {
+			return err
+		}
+	}
+	release, _ := s.claim(id, true)
+	defer release()
+	s.mu.Lock()
+	dirty := s.dirty[id]
+	s.mu.Unlock()
+	if !dirty {
+		return nil
+	}
+	return s.sync(ctx, id)
+}
+
+// Sync writes the local session to the bucket, after any upload of it in
+// flight.
+func (s *Store) Sync(ctx context.Context, id string) error {
+	release, _ := s.claim(id, true)
+	defer release()
+	return s.sync(ctx, id)
+}
+
+func (s *Store) sync(ctx context.Context, id string) error {
+	sess, err := s.snapshot(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.upload(ctx, sess); err != nil {
+		s.markDirty(id)
+		return err
+	}
+	return nil
+}
+
+// snapshot loads session id to be written through and marks it clean and
+// synced; entries appended from here on mark it dirty again.
+func (s *Store) snapshot(ctx context.Context, id string) (session.Session, error) {
+	s.mu.Lock()
+	delete(s.dirty, id)
+	s.mu.Unlock()
+	sess, err := s.Local.Load(ctx, id)
+	if err != nil {
+		s.markDirty(id)
+		return session.Session{}, err
+	}
+	s.markSynced(id)
+	return sess, nil
+}
+
+// upload puts sess in the bucket and updates the cached listing.
+func (s *Store) upload(ctx context.Context, sess session.Session) error {
+	id := sess.Metadata.ID
+	meta, err := json.Marshal(sess.Metadata)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(sess)
+	if err != nil {
+		return err
+	}
+	// The transcript goes first so listed metadata always has a session.
+	if err := s.Bucket.Put(ctx, s.key(id, sessionObject), data); err != nil {
+		return fmt.Errorf("sync session %s: %w", id, err)
+	}
+	if err := s.Bucket.Put(ctx, s.key(id, metadataObject), meta); err != nil {
+		return fmt.Errorf("sync session %s: %w", id, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listedAt.IsZero() {
+		return nil
+	}
+	listed := slices.DeleteFunc(slices.Clone(s.listed), func(m session.Metadata) bool { return m.ID == id })
+	s.listed = append(listed, sess.Metadata)
+	return nil
+}
+
+// List merges the local sessions with the mirrored ones missing from
+// Local, newest first. A mirror is never newer than its local session, so
+// local ones win ties.
+func (s *Store) List(ctx context.Context, cwd string, limit int) ([]session.Metadata, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	metas, err := s.Local.List(ctx, cwd, limit)
+	if err != nil {
+		return nil, err
+	}
+	local := map[string]bool{}
+	for _, meta := range metas {
+		local[meta.ID] = true
+	}
+	for _, meta := range s.mirrored(ctx, cwd) {
+		if !local[meta.ID] {
+			metas = append(metas, meta)
+		}
+	}
+	sort.SliceStable(metas, func(i, j int) bool { return metas[i].UpdatedAt.After(metas[j].UpdatedAt) })
+	if len(metas) > limit {
+		metas = metas[:limit]
+	}
+	return metas, nil
+}
+
+func (s *Store) Count(ctx context.Context, cwd string) (int, error) {
+	n, err := s.Local.Count(ctx, cwd)
+	if err != nil {
+		return 0, err
+	}
+	metas, err := s.Local.List(ctx, cwd, max(n, 1))
+	if err != nil {
+		return 0, err
+	}
+	local := map[string]bool{}
+	for _, meta := range metas {
+		local[meta.ID] = true
+	}
+	for _, meta := range s.mirrored(ctx, cwd) {
+		if !local[meta.ID] {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *Store) MostRecent(ctx context.Context, cwd string) (session.Session, error) {
+	metas, err := s.List(ctx, cwd, 1)
+	if err != nil {
+		return session.Session{}, err
+	}
+	if len(metas) == 0 {
+		return s.Local.MostRecent(ctx, cwd)
+	}
+	return s.Load(ctx, metas[0].ID)
+}
+
+func (s *Store) Search(ctx context.Context, query session.SearchQuery) ([]session.SearchResult, error) {
+	return s.Local.Search(ctx, query)
+}
+
+// GC collects local sessions only; their mirrors are kept, to be expired
+// by the bucket's own lifecycle rules.
+func (s *Store) GC(ctx context.Context, policy session.RetentionPolicy) (session.GCResult, error) {
+	collector, ok := s.Local.(session.Collector)
+	if !ok {
+		return session.GCResult{}, session.ErrNoCollector
+	}
+	return collector.GC(ctx, policy)
+}
+
+// Pin pins the local session and writes the change through.
+func (s *Store) Pin(ctx context.Context, id string, pinned bool) error {
+	collector, ok := s.Local.(session.Collector)
+	if !ok {
+		return session.ErrNoCollector
+	}
+	if err := collector.Pin(ctx, id, pinned); err != nil {
+		return err
+	}
+	return s.Sync(ctx, id)
+}
+
+// mirrored returns the metadata of the sessions in the bucket, of those
+// in cwd unless it is "", listing the bucket when the last listing is
+// older than listTTL. When it cannot be listed, it warns and returns none,
+// so callers fall back to Local.
+func (s *Store) mirrored(ctx context.Context, cwd string) []session.Metadata {
+	s.mu.Lock()
+	listed, fresh := s.listed, !s.listedAt.IsZero() && time.Since(s.listedAt) < listTTL
+	s.mu.Unlock()
+	if !fresh {
+		var err error
+		if listed, err = s.list(ctx); err != nil {
+			if s.Warnings != nil {
+				fmt.Fprintf(s.Warnings, "warning: %v; listing local sessions only\n", err)
+			}
+			return nil
+		}
+		s.mu.Lock()
+		s.listed, s.listedAt = listed, time.Now()
+		s.mu.Unlock()
+	}
+	var metas []session.Metadata
+	for _, meta := range listed {
+		if cwd == "" || meta.CWD == cwd {
+			metas = append(metas, meta)
+		}
+	}
+	return metas
+}
+
+// list reads the metadata of every session in the bucket.
+func (s *Store) list(ctx context.Context) ([]session.Metadata, error) {
+	keys, err := s.Bucket.List(ctx, s.key("", ""))
+	if err != nil {
+		return nil, fmt.Errorf("list remote sessions: %w", err)
+	}
+	var metas []session.Metadata
+	for _, key := range keys {
+		if !strings.HasSuffix(key, "/"+metadataObject) {
+			continue
+		}
+		data, err := s.Bucket.Get(ctx, key)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("list remote sessions: %w", err)
+		}
+		var meta session.Metadata
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return nil, fmt.Errorf("remote session metadata %s: %w", key, err)
+		}
+		metas = append(metas, meta)
+	}
+	return metas, nil
+}
+
+// key returns the key of a session's object, or of the prefix holding
+// every session when id is "".
+func (s *Store) key(id, object string) string {
+	key := strings.Trim(s.Prefix, "/")
+	if key != "" {
+		key += "/"
+	}
+	if id == "" {
+		return key
+	}
+	return key + id + "/" + object
+}
+
+func (s *Store) markDirty(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dirty == nil {
+		s.dirty = map[string]bool{}
+	}
+	s.dirty[id] = true
+}
+
+func (s *Store) markSynced(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.synced == nil {
+		s.synced = map[string]time.Time{}
+	}
+	s.synced[id] = time.Now()
+}
+
+// claim claims the next upload of session id, waiting out one in flight
+// unless wait is false, in which case it reports false instead. The
+// returned func releases the claim.
+func (s *Store) claim(id string, wait bool) (func(), bool) {
+	for {
+		s.mu.Lock()
+		done, busy := s.uploads[id]
+		if !busy {
+			done = make(chan struct{})
+			if s.uploads == nil {
+				s.uploads = map[string]chan struct{}{}
+			}
+			s.uploads[id] = done
+			s.mu.Unlock()
+			return func() {
+				s.mu.Lock()
+				delete(s.uploads, id)
+				s.mu.Unlock()
+				close(done)
+			}, true
+		}
+		s.mu.Unlock()
+		if !wait {
+			return nil, false
+		}
+		<-done
+	}
+}
+
+// due reports whether Interval has passed since id was last written
+// through; a session not yet written through is due at once.
+func (s *Store) due(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Since(s.synced[id]) >= s.Interval
+}
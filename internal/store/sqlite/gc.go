@@ -0,0 +1,224 @@
+package sqlite
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/session"
+)
+
+// A compressed session keeps its row in sessions, so it lists as before,
+// and moves its entries into archives as one gzipped JSON Lines blob.
+// Loading it decodes the blob; appending to it moves the entries back.
+// Compressed sessions are not matched by Search.
+
+// archivedEntry is the JSON form of a session.Entry in an archive.
+type archivedEntry struct {
+	Kind      session.EntryKind `json:"kind"`
+	Role      string            `json:"role,omitempty"`
+	Content   string            `json:"content,omitempty"`
+	EventType string            `json:"eventType,omitempty"`
+	Metadata  string            `json:"metadata,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+// GC removes and compresses sessions as policy selects, then compacts the
+// database file so removed sessions free disk space.
+func (s Store) GC(ctx context.Context, policy session.RetentionPolicy) (session.GCResult, error) {
+	db, err := s.open(ctx)
+	if err != nil {
+		return session.GCResult{}, err
+	}
+	defer db.Close()
+	stored, err := s.storedSessions(ctx, db)
+	if err != nil {
+		return session.GCResult{}, err
+	}
+	remove, compress := policy.Select(stored, time.Now())
+	result := session.GCResult{Removed: remove, Compressed: compress, Kept: len(stored) - len(remove)}
+	for _, r := range remove {
+		result.FreedBytes += r.Bytes
+	}
+	if policy.DryRun || (len(remove) == 0 && len(compress) == 0) {
+		return result, nil
+	}
+	for _, r := range remove {
+		if err := s.removeSession(ctx, db, r.ID); err != nil {
+			return result, fmt.Errorf("remove session %s: %w", r.ID, err)
+		}
+	}
+	for _, c := range compress {
+		if err := s.archiveSession(ctx, db, c.ID); err != nil {
+			return result, fmt.Errorf("compress session %s: %w", c.ID, err)
+		}
+	}
+	if _, err := db.ExecContext(ctx, `VACUUM`); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// Pin protects a session from GC, or with pinned false lifts the
+// protection.
+func (s Store) Pin(ctx context.Context, id string, pinned bool) error {
+	db, err := s.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	res, err := db.ExecContext(ctx, `UPDATE sessions SET pinned = ? WHERE id = ?`, pinned, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("session %s not found", id)
+	}
+	return nil
+}
+
+func (s Store) storedSessions(ctx context.Context, db *sql.DB) ([]session.Stored, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT s.id, s.profile, s.cwd, s.created_at, s.updated_at, s.pinned,
+			COALESCE((SELECT SUM(LENGTH(e.content) + LENGTH(e.metadata)) FROM entries e WHERE e.session_id = s.id), 0),
+			(SELECT LENGTH(a.data) FROM archives a WHERE a.session_id = s.id)
+		FROM sessions s
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []session.Stored
+	for rows.Next() {
+		var st session.Stored
+		var archived sql.NullInt64
+		if err := rows.Scan(&st.ID, &st.Profile, &st.CWD, &st.CreatedAt, &st.UpdatedAt, &st.Pinned, &st.Bytes, &archived); err != nil {
+			return nil, err
+		}
+		if archived.Valid {
+			st.Compressed = true
+			st.Bytes += archived.Int64
+		}
+		st.Bytes += s.logSize(st.ID)
+		out = append(out, st)
+	}
+	return out, rows.Err()
+}
+
+func (s Store) removeSession(ctx context.Context, db *sql.DB, id string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range []string{
+		`DELETE FROM entries WHERE session_id = ?`,
+		`DELETE FROM archives WHERE session_id = ?`,
+		`DELETE FROM sessions WHERE id = ?`,
+	} {
+		if _, err := tx.ExecContext(ctx, stmt, id); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return s.removeLog(id)
+}
+
+// archiveSession moves a session's entries, logged ones included, into a
+// compressed archive. They are read in the transaction that deletes them,
+// so an entry appended meanwhile is not deleted unarchived.
+func (s Store) archiveSession(ctx context.Context, db *sql.DB, id string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	entries, err := s.loadEntries(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(zw)
+	for _, e := range entries {
+		if err := enc.Encode(archivedEntry{Kind: e.Kind, Role: e.Role, Content: e.Content, EventType: e.EventType, Metadata: e.Metadata, CreatedAt: e.CreatedAt.UTC()}); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT OR REPLACE INTO archives (session_id, data) VALUES (?, ?)`, id, buf.Bytes()); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM entries WHERE session_id = ?`, id); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return s.removeLog(id)
+}
+
+// loadArchive returns the entries of a compressed session, or none when
+// the session is not compressed.
+func loadArchive(ctx context.Context, db queryer, id string) ([]session.Entry, error) {
+	var data []byte
+	err := db.QueryRowContext(ctx, `SELECT data FROM archives WHERE session_id = ?`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("session %s archive: %w", id, err)
+	}
+	dec := json.NewDecoder(zr)
+	var entries []session.Entry
+	for {
+		var e archivedEntry
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("session %s archive: %w", id, err)
+		}
+		entries = append(entries, session.Entry{Kind: e.Kind, Role: e.Role, Content: e.Content, EventType: e.EventType, Metadata: e.Metadata, CreatedAt: e.CreatedAt})
+	}
+	return entries, nil
+}
+
+// restoreArchive moves a compressed session's entries back into entries,
+// before it is appended to.
+func restoreArchive(ctx context.Context, db *sql.DB, id string) error {
+	entries, err := loadArchive(ctx, db, id)
+	if err != nil || entries == nil {
+		return err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, e := range entries {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO entries (session_id, kind, role, content, event_type, metadata, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, id, e.Kind, e.Role, e.Content, e.EventType, e.Metadata, e.CreatedAt.UTC()); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM archives WHERE session_id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,157 @@
+package sqlite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bitop-dev/agent/internal/store/zstdlog"
+	"github.com/bitop-dev/agent/pkg/session"
+)
+
+// A session appended to by a Store with Compress set keeps its row in
+// sessions, so it lists as before, and writes its entries to a zstd log of
+// its own in logs/ beside the database instead of to entries; see package
+// zstdlog. Entries stored before it are loaded first. Logged entries are
+// not matched by Search.
+
+// logPath is where the log of session id is kept.
+func (s Store) logPath(id string) string {
+	return filepath.Join(filepath.Dir(s.Path), "logs", id+".zst")
+}
+
+// logChunk is how many entries a session's log batches into one record.
+const logChunk = 16
+
+// sessionLog is the open log of one session. The writer is opened on the
+// first append and kept, with the lock on the file, until the session is
+// closed.
+type sessionLog struct {
+	mu sync.Mutex
+	w  *zstdlog.Writer
+}
+
+// logs holds the session logs open in this process, by path, so appends
+// share one writer instead of each reopening and rescanning the file.
+var logs sync.Map // path -> *sessionLog
+
+func openLog(path string) *sessionLog {
+	v, _ := logs.LoadOrStore(path, &sessionLog{})
+	return v.(*sessionLog)
+}
+
+// appendLog appends entry to the log of session id. Entries are written
+// logChunk at a time, each record synced, and the rest when the session is
+// closed or loaded.
+func (s Store) appendLog(id string, entry session.Entry) error {
+	l := openLog(s.logPath(id))
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.w == nil {
+		w, recovery, err := zstdlog.Open(s.logPath(id), zstdlog.Options{Chunk: logChunk, SyncEvery: 1})
+		if err != nil {
+			return err
+		}
+		if recovery.Truncated() {
+			if err := w.Append(recoveryEntry(recovery)); err != nil {
+				w.Close()
+				return err
+			}
+		}
+		l.w = w
+	}
+	return l.w.Append(entry)
+}
+
+// closeLog writes out and closes the log of session id, if this process
+// has it open, releasing its lock.
+func (s Store) closeLog(id string) error {
+	v, ok := logs.LoadAndDelete(s.logPath(id))
+	if !ok {
+		return nil
+	}
+	l := v.(*sessionLog)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.w == nil {
+		return nil
+	}
+	err := l.w.Close()
+	l.w = nil
+	return err
+}
+
+// Close writes out the log of session id and releases it; the runtime
+// calls it when a run on the session ends.
+func (s Store) Close(_ context.Context, id string) error {
+	return s.closeLog(id)
+}
+
+// loadLog returns the logged entries of session id, none when it has no
+// log, after writing out entries this process still holds. A damaged final
+// record is skipped and reported by an EventLogRecovered entry at the end;
+// the file is left alone, and the next append cuts the record off and
+// keeps the note.
+func (s Store) loadLog(id string) ([]session.Entry, error) {
+	path := s.logPath(id)
+	if v, ok := logs.Load(path); ok {
+		l := v.(*sessionLog)
+		l.mu.Lock()
+		var err error
+		if l.w != nil {
+			err = l.w.Flush()
+		}
+		l.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+	entries, recovery, err := zstdlog.Read(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil || !recovery.Truncated() {
+		return entries, err
+	}
+	return append(entries, recoveryEntry(recovery)), nil
+}
+
+// importLog writes entries to a new log of session id and closes it.
+func (s Store) importLog(id string, entries []session.Entry) error {
+	for _, entry := range entries {
+		if err := s.appendLog(id, entry); err != nil {
+			return err
+		}
+	}
+	return s.closeLog(id)
+}
+
+// removeLog deletes the log of session id, if it has one.
+func (s Store) removeLog(id string) error {
+	if err := s.closeLog(id); err != nil {
+		return err
+	}
+	if err := os.Remove(s.logPath(id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// logSize is the size in bytes of the log of session id, 0 without one.
+func (s Store) logSize(id string) int64 {
+	info, err := os.Stat(s.logPath(id))
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func recoveryEntry(recovery zstdlog.Recovery) session.Entry {
+	data, _ := json.Marshal(session.LogRecovery{Offset: recovery.Offset, DroppedBytes: recovery.DroppedBytes})
+	return session.Entry{Kind: session.EntryEvent, EventType: session.EventLogRecovered, Metadata: string(data), CreatedAt: time.Now()}
+}
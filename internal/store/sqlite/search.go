@@ -0,0 +1,168 @@
+package sqlite
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bitop-dev/agent/pkg/session"
+)
+
+// maxSearchHits caps the messages reported per matching session.
+const maxSearchHits = 5
+
+// Search scans message entries for the query terms. Every term must occur
+// somewhere in a session for it to match, not necessarily in one message.
+// Labels must all be on one message of the session; without terms, the
+// messages carrying them are the hits.
+func (s Store) Search(ctx context.Context, q session.SearchQuery) ([]session.SearchResult, error) {
+	var terms []string
+	for _, term := range strings.Fields(strings.ToLower(q.Text)) {
+		if !slices.Contains(terms, term) {
+			terms = append(terms, term)
+		}
+	}
+	if len(terms) == 0 && len(q.Labels) == 0 {
+		return nil, nil
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+	db, err := s.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	query := `
+		SELECT s.id, s.profile, s.cwd, s.created_at, s.updated_at, e.role, e.content, e.created_at
+		FROM entries e JOIN sessions s ON s.id = e.session_id
+		WHERE e.kind = 'message' AND e.role IN ('user', 'assistant', 'tool')`
+	var args []any
+	if len(terms) > 0 {
+		likes := make([]string, len(terms))
+		for i, term := range terms {
+			likes[i] = `e.content LIKE ? ESCAPE '\'`
+			args = append(args, "%"+escapeLike(term)+"%")
+		}
+		query += ` AND (` + strings.Join(likes, " OR ") + `)`
+	}
+	if len(q.Labels) > 0 {
+		// Without terms the labeled messages themselves are selected;
+		// with terms, any message of the session may carry the labels.
+		alias := "e"
+		if len(terms) > 0 {
+			alias = "l"
+			query += ` AND EXISTS (SELECT 1 FROM entries l WHERE l.session_id = s.id AND l.kind = 'message'`
+		}
+		for _, key := range slices.Sorted(maps.Keys(q.Labels)) {
+			query += ` AND CASE WHEN json_valid(` + alias + `.metadata) THEN json_extract(` + alias + `.metadata, ?) END = ?`
+			args = append(args, `$.labels."`+strings.ReplaceAll(key, `"`, `\"`)+`"`, q.Labels[key])
+		}
+		if len(terms) > 0 {
+			query += `)`
+		}
+	}
+	if q.CWD != "" {
+		query += ` AND s.cwd = ?`
+		args = append(args, q.CWD)
+	}
+	if q.Model != "" {
+		// Assistant turns record their model in the message metadata.
+		query += ` AND EXISTS (SELECT 1 FROM entries m WHERE m.session_id = s.id AND m.metadata LIKE ? ESCAPE '\')`
+		args = append(args, `%"model":"`+escapeLike(q.Model)+"%")
+	}
+	query += ` ORDER BY s.updated_at DESC, s.id, e.created_at ASC, e.id ASC`
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []session.SearchResult
+	var covered map[string]bool
+	flush := func() {
+		n := len(results)
+		if n > 0 && len(covered) < len(terms) {
+			results = results[:n-1]
+		}
+	}
+	for rows.Next() {
+		var meta session.Metadata
+		var role, content string
+		var at time.Time
+		if err := rows.Scan(&meta.ID, &meta.Profile, &meta.CWD, &meta.CreatedAt, &meta.UpdatedAt, &role, &content, &at); err != nil {
+			return nil, err
+		}
+		if (!q.Since.IsZero() && at.Before(q.Since)) || (!q.Until.IsZero() && !at.Before(q.Until)) {
+			continue
+		}
+		if len(results) == 0 || results[len(results)-1].Metadata.ID != meta.ID {
+			flush()
+			if len(results) == limit {
+				break
+			}
+			results = append(results, session.SearchResult{Metadata: meta})
+			covered = map[string]bool{}
+		}
+		current := &results[len(results)-1]
+		if len(terms) == 0 {
+			if len(current.Hits) < maxSearchHits {
+				current.Hits = append(current.Hits, session.SearchHit{Role: role, Snippet: snippet(content, 0), CreatedAt: at})
+			}
+			continue
+		}
+		lower := strings.ToLower(content)
+		first := -1
+		for _, term := range terms {
+			if i := strings.Index(lower, term); i >= 0 {
+				covered[term] = true
+				if first < 0 || i < first {
+					first = i
+				}
+			}
+		}
+		if first < 0 {
+			continue // matched LIKE's ASCII-only case folding but not ours
+		}
+		if len(current.Hits) < maxSearchHits {
+			if len(lower) != len(content) {
+				first = 0 // case folding changed byte offsets
+			}
+			current.Hits = append(current.Hits, session.SearchHit{Role: role, Snippet: snippet(content, first), CreatedAt: at})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return results, nil
+}
+
+// snippet returns one line of content around byte offset at.
+func snippet(content string, at int) string {
+	const before, after = 60, 140
+	start, end := max(at-before, 0), min(at+after, len(content))
+	for start > 0 && !utf8.RuneStart(content[start]) {
+		start--
+	}
+	for end < len(content) && !utf8.RuneStart(content[end]) {
+		end++
+	}
+	out := strings.Join(strings.Fields(content[start:end]), " ")
+	if start > 0 {
+		out = "…" + out
+	}
+	if end < len(content) {
+		out += "…"
+	}
+	return out
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
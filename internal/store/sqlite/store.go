@@ -3,6 +3,7 @@ package sqlite
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -16,8 +17,14 @@ import (
 
 type Store struct {
 	Path string
+	// Compress appends entries to a zstd-compressed log per session
+	// rather than to the database; see appendLog.
+	Compress bool
 }
 
+// metadataColumns are the sessions columns scanned into a session.Metadata.
+const metadataColumns = `id, profile, cwd, created_at, updated_at, pinned, title, summary`
+
 func (s Store) Create(ctx context.Context, meta session.Metadata) (session.Session, error) {
 	db, err := s.open(ctx)
 	if err != nil {
@@ -34,26 +41,87 @@ func (s Store) Create(ctx context.Context, meta session.Metadata) (session.Sessi
 		meta.UpdatedAt = meta.CreatedAt
 	}
 	_, err = db.ExecContext(ctx, `
-		INSERT OR REPLACE INTO sessions (id, profile, cwd, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?)
-	`, meta.ID, meta.Profile, meta.CWD, meta.CreatedAt.UTC(), meta.UpdatedAt.UTC())
+		INSERT OR REPLACE INTO sessions (`+metadataColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, meta.ID, meta.Profile, meta.CWD, meta.CreatedAt.UTC(), meta.UpdatedAt.UTC(), meta.Pinned, meta.Title, meta.Summary)
 	if err != nil {
 		return session.Session{}, err
 	}
 	return session.Session{Metadata: meta}, nil
 }
 
+// Import stores sess whole, replacing a session with its ID, and keeps
+// its metadata as given where Create and Append would touch updated_at.
+// The rows are written in one transaction; with Compress, the log is
+// removed again when the import fails.
+func (s Store) Import(ctx context.Context, sess session.Session) error {
+	meta := sess.Metadata
+	if meta.ID == "" {
+		return fmt.Errorf("import session: id is required")
+	}
+	db, err := s.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range []string{
+		`DELETE FROM entries WHERE session_id = ?`,
+		`DELETE FROM archives WHERE session_id = ?`,
+	} {
+		if _, err := tx.ExecContext(ctx, stmt, meta.ID); err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT OR REPLACE INTO sessions (`+metadataColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, meta.ID, meta.Profile, meta.CWD, meta.CreatedAt.UTC(), meta.UpdatedAt.UTC(), meta.Pinned, meta.Title, meta.Summary)
+	if err != nil {
+		return err
+	}
+	if s.Compress {
+		if err := s.removeLog(meta.ID); err != nil {
+			return err
+		}
+		if err := s.importLog(meta.ID, sess.Entries); err != nil {
+			_ = s.removeLog(meta.ID)
+			return err
+		}
+	} else {
+		for _, e := range sess.Entries {
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO entries (session_id, kind, role, content, event_type, metadata, created_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?)
+			`, meta.ID, e.Kind, e.Role, e.Content, e.EventType, e.Metadata, e.CreatedAt.UTC()); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		if s.Compress {
+			_ = s.removeLog(meta.ID)
+		}
+		return err
+	}
+	return nil
+}
+
 func (s Store) Load(ctx context.Context, id string) (session.Session, error) {
 	db, err := s.open(ctx)
 	if err != nil {
 		return session.Session{}, err
 	}
 	defer db.Close()
-	meta, err := loadMetadata(ctx, db, `SELECT id, profile, cwd, created_at, updated_at FROM sessions WHERE id = ?`, id)
+	meta, err := loadMetadata(ctx, db, `SELECT `+metadataColumns+` FROM sessions WHERE id = ?`, id)
 	if err != nil {
 		return session.Session{}, err
 	}
-	entries, err := loadEntries(ctx, db, id)
+	entries, err := s.loadEntries(ctx, db, id)
 	if err != nil {
 		return session.Session{}, err
 	}
@@ -69,13 +137,30 @@ func (s Store) Append(ctx context.Context, id string, entry session.Entry) error
 	if entry.CreatedAt.IsZero() {
 		entry.CreatedAt = time.Now()
 	}
-	_, err = db.ExecContext(ctx, `
-		INSERT INTO entries (session_id, kind, role, content, event_type, metadata, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`, id, entry.Kind, entry.Role, entry.Content, entry.EventType, entry.Metadata, entry.CreatedAt.UTC())
+	if err := restoreArchive(ctx, db, id); err != nil {
+		return err
+	}
+	if s.Compress {
+		err = s.appendLog(id, entry)
+	} else {
+		_, err = db.ExecContext(ctx, `
+			INSERT INTO entries (session_id, kind, role, content, event_type, metadata, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, id, entry.Kind, entry.Role, entry.Content, entry.EventType, entry.Metadata, entry.CreatedAt.UTC())
+	}
 	if err != nil {
 		return err
 	}
+	if entry.Kind == session.EntryTitle {
+		var title session.Title
+		if err := json.Unmarshal([]byte(entry.Metadata), &title); err != nil {
+			return fmt.Errorf("title entry: %w", err)
+		}
+		_, err = db.ExecContext(ctx, `UPDATE sessions SET title = ?, summary = ? WHERE id = ?`, title.Title, title.Summary, id)
+		if err != nil {
+			return err
+		}
+	}
 	_, err = db.ExecContext(ctx, `UPDATE sessions SET updated_at = ? WHERE id = ?`, time.Now().UTC(), id)
 	return err
 }
@@ -89,7 +174,7 @@ func (s Store) List(ctx context.Context, cwd string, limit int) ([]session.Metad
 	if limit <= 0 {
 		limit = 20
 	}
-	query := `SELECT id, profile, cwd, created_at, updated_at FROM sessions`
+	query := `SELECT ` + metadataColumns + ` FROM sessions`
 	args := []any{}
 	if cwd != "" {
 		query += ` WHERE cwd = ?`
@@ -105,7 +190,7 @@ func (s Store) List(ctx context.Context, cwd string, limit int) ([]session.Metad
 	var metas []session.Metadata
 	for rows.Next() {
 		var meta session.Metadata
-		if err := rows.Scan(&meta.ID, &meta.Profile, &meta.CWD, &meta.CreatedAt, &meta.UpdatedAt); err != nil {
+		if err := rows.Scan(&meta.ID, &meta.Profile, &meta.CWD, &meta.CreatedAt, &meta.UpdatedAt, &meta.Pinned, &meta.Title, &meta.Summary); err != nil {
 			return nil, err
 		}
 		metas = append(metas, meta)
@@ -139,7 +224,7 @@ func (s Store) MostRecent(ctx context.Context, cwd string) (session.Session, err
 		return session.Session{}, err
 	}
 	defer db.Close()
-	query := `SELECT id, profile, cwd, created_at, updated_at FROM sessions`
+	query := `SELECT ` + metadataColumns + ` FROM sessions`
 	args := []any{}
 	if cwd != "" {
 		query += ` WHERE cwd = ?`
@@ -150,7 +235,7 @@ func (s Store) MostRecent(ctx context.Context, cwd string) (session.Session, err
 	if err != nil {
 		return session.Session{}, err
 	}
-	entries, err := loadEntries(ctx, db, meta.ID)
+	entries, err := s.loadEntries(ctx, db, meta.ID)
 	if err != nil {
 		return session.Session{}, err
 	}
@@ -201,27 +286,47 @@ func ensureSchema(ctx context.Context, db *sql.DB) error {
 		);
 		CREATE INDEX IF NOT EXISTS idx_entries_session_id_created_at
 		ON entries(session_id, created_at);
+		CREATE TABLE IF NOT EXISTS archives (
+			session_id TEXT PRIMARY KEY,
+			data BLOB NOT NULL,
+			FOREIGN KEY(session_id) REFERENCES sessions(id)
+		);
 	`)
 	if err != nil {
 		return err
 	}
-	_, err = db.ExecContext(ctx, `ALTER TABLE entries ADD COLUMN metadata TEXT NOT NULL DEFAULT ''`)
-	if err != nil && !strings.Contains(strings.ToLower(err.Error()), "duplicate column name") {
-		return err
+	for _, column := range []string{
+		`ALTER TABLE entries ADD COLUMN metadata TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE sessions ADD COLUMN pinned INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE sessions ADD COLUMN title TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE sessions ADD COLUMN summary TEXT NOT NULL DEFAULT ''`,
+	} {
+		_, err = db.ExecContext(ctx, column)
+		if err != nil && !strings.Contains(strings.ToLower(err.Error()), "duplicate column name") {
+			return err
+		}
 	}
 	return nil
 }
 
 func loadMetadata(ctx context.Context, db *sql.DB, query string, args ...any) (session.Metadata, error) {
 	var meta session.Metadata
-	err := db.QueryRowContext(ctx, query, args...).Scan(&meta.ID, &meta.Profile, &meta.CWD, &meta.CreatedAt, &meta.UpdatedAt)
+	err := db.QueryRowContext(ctx, query, args...).Scan(&meta.ID, &meta.Profile, &meta.CWD, &meta.CreatedAt, &meta.UpdatedAt, &meta.Pinned, &meta.Title, &meta.Summary)
 	if err != nil {
 		return session.Metadata{}, err
 	}
 	return meta, nil
 }
 
-func loadEntries(ctx context.Context, db *sql.DB, sessionID string) ([]session.Entry, error) {
+// queryer reads from a *sql.DB, or within a *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// loadEntries returns the entries of a session: those in entries, or else
+// in its archive, then those in its log.
+func (s Store) loadEntries(ctx context.Context, db queryer, sessionID string) ([]session.Entry, error) {
 	rows, err := db.QueryContext(ctx, `
 		SELECT kind, role, content, event_type, metadata, created_at
 		FROM entries
@@ -243,5 +348,14 @@ func loadEntries(ctx context.Context, db *sql.DB, sessionID string) ([]session.E
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
-	return entries, nil
+	if len(entries) == 0 {
+		if entries, err = loadArchive(ctx, db, sessionID); err != nil {
+			return nil, err
+		}
+	}
+	logged, err := s.loadLog(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return append(entries, logged...), nil
 }
@@ -0,0 +1,294 @@
+// Package zstdlog stores session entries in an append-only file of
+// zstd-compressed records.
+//
+// Each record is a chunk of entries as JSON Lines, compressed as one zstd
+// frame, after an 8-byte header holding the frame's length and CRC-32,
+// both little-endian. A record is written whole or not at all as far as a
+// reader is concerned: a crash mid-write leaves a final record that is
+// short or fails its checksum, which Read reports and skips instead of
+// failing, and Open cuts off before appending. A damaged record before the
+// last is an error.
+//
+// A Writer holds an exclusive lock on its file until it is closed, so only
+// one writer, in any process, appends to a log at a time. Read takes no
+// lock and never changes the file.
+package zstdlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/bitop-dev/agent/internal/filelock"
+	"github.com/bitop-dev/agent/pkg/session"
+)
+
+// headerSize is the length of a record header: the frame's length, then
+// its CRC-32 (IEEE).
+const headerSize = 8
+
+// maxRecord bounds the length a header may claim, so a damaged header is
+// not taken for a huge record still being written.
+const maxRecord = 1 << 30
+
+// DefaultChunk is how many entries Writer buffers before writing a record
+// when Options.Chunk is 0.
+const DefaultChunk = 64
+
+// Options tune a Writer.
+type Options struct {
+	// Chunk is how many appended entries are compressed into one record;
+	// fewer are written by Flush and Close.
+	Chunk int
+	// SyncEvery is how many records are written between fsyncs, each a
+	// sync point a crash cannot lose; 0 syncs only on Sync and Close.
+	SyncEvery int
+}
+
+// entry is the JSON form of a session.Entry in a record.
+type entry struct {
+	Kind      session.EntryKind `json:"kind"`
+	Role      string            `json:"role,omitempty"`
+	Content   string            `json:"content,omitempty"`
+	EventType string            `json:"eventType,omitempty"`
+	Metadata  string            `json:"metadata,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+// Writer appends entries to a log file.
+type Writer struct {
+	file     *os.File
+	enc      *zstd.Encoder
+	opts     Options
+	pending  []session.Entry
+	unsynced int
+}
+
+// Open opens the log at path for appending, creating it and its directory
+// if needed, and waits for the lock on it. A damaged final record left by a
+// crash is cut off first, and reported as the Recovery.
+func Open(path string, opts Options) (*Writer, Recovery, error) {
+	if opts.Chunk <= 0 {
+		opts.Chunk = DefaultChunk
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, Recovery{}, err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, Recovery{}, err
+	}
+	if err := filelock.LockFile(file); err != nil {
+		file.Close()
+		return nil, Recovery{}, fmt.Errorf("%s: %w", path, err)
+	}
+	_, recovery, err := decode(file, false)
+	if err == nil && recovery.Truncated() {
+		err = file.Truncate(recovery.Offset)
+	}
+	if err == nil {
+		_, err = file.Seek(recovery.Offset, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, Recovery{}, fmt.Errorf("%s: %w", path, err)
+	}
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderCRC(false))
+	if err != nil {
+		file.Close()
+		return nil, Recovery{}, err
+	}
+	return &Writer{file: file, enc: enc, opts: opts}, recovery, nil
+}
+
+// Append adds entries, writing a record each time Chunk are pending.
+func (w *Writer) Append(entries ...session.Entry) error {
+	for _, e := range entries {
+		w.pending = append(w.pending, e)
+		if len(w.pending) >= w.opts.Chunk {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Flush writes the pending entries as one record.
+func (w *Writer) Flush() error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	var lines bytes.Buffer
+	enc := json.NewEncoder(&lines)
+	for _, e := range w.pending {
+		if err := enc.Encode(entry{Kind: e.Kind, Role: e.Role, Content: e.Content, EventType: e.EventType, Metadata: e.Metadata, CreatedAt: e.CreatedAt.UTC()}); err != nil {
+			return err
+		}
+	}
+	frame := w.enc.EncodeAll(lines.Bytes(), nil)
+	record := make([]byte, headerSize, headerSize+len(frame))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(frame))
+	if _, err := w.file.Write(append(record, frame...)); err != nil {
+		return err
+	}
+	w.pending = w.pending[:0]
+	w.unsynced++
+	if w.opts.SyncEvery > 0 && w.unsynced >= w.opts.SyncEvery {
+		return w.sync()
+	}
+	return nil
+}
+
+// Sync flushes the pending entries and commits the file to disk.
+func (w *Writer) Sync() error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return w.sync()
+}
+
+func (w *Writer) sync() error {
+	w.unsynced = 0
+	return w.file.Sync()
+}
+
+// Close syncs and closes the log.
+func (w *Writer) Close() error {
+	err := w.Sync()
+	w.enc.Close()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Recovery describes the damaged tail of a log: the bytes from Offset on,
+// which are not a whole record.
+type Recovery struct {
+	Offset       int64 // the end of the last whole record
+	DroppedBytes int64
+}
+
+// Truncated reports whether the log had a damaged tail.
+func (r Recovery) Truncated() bool {
+	return r.DroppedBytes > 0
+}
+
+// Read returns the entries of the log at path, and what was skipped of a
+// damaged final record.
+func Read(path string) ([]session.Entry, Recovery, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, Recovery{}, err
+	}
+	defer file.Close()
+	entries, recovery, err := decode(file, true)
+	if err != nil {
+		return nil, Recovery{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return entries, recovery, nil
+}
+
+// errTorn marks a record cut short; errChecksum one whose frame does not
+// match its checksum, which is a torn write only in the final record.
+var (
+	errTorn     = errors.New("torn record")
+	errChecksum = errors.New("checksum mismatch")
+)
+
+// decode reads every record of r from its start, and with entries set
+// decompresses them; without, only record checksums are checked.
+func decode(r io.ReadSeeker, entries bool) ([]session.Entry, Recovery, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, Recovery{}, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, Recovery{}, err
+	}
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, Recovery{}, err
+	}
+	defer dec.Close()
+	var decoded []session.Entry
+	var offset int64
+	for offset < size {
+		frame, err := readRecord(r, size-offset)
+		if errors.Is(err, errTorn) {
+			return decoded, Recovery{Offset: offset, DroppedBytes: size - offset}, nil
+		}
+		if err != nil && !errors.Is(err, errChecksum) {
+			return nil, Recovery{}, err
+		}
+		next := offset + headerSize + int64(len(frame))
+		var chunk []session.Entry
+		if err == nil && entries {
+			chunk, err = decodeChunk(dec, frame)
+		}
+		if err != nil && next == size {
+			return decoded, Recovery{Offset: offset, DroppedBytes: size - offset}, nil
+		}
+		if err != nil {
+			return nil, Recovery{}, fmt.Errorf("record at %d: %w", offset, err)
+		}
+		decoded = append(decoded, chunk...)
+		offset = next
+	}
+	return decoded, Recovery{Offset: offset}, nil
+}
+
+// readRecord reads the frame of the next record, of at most remaining
+// bytes with its header. A record that does not fit is errTorn; one that
+// fails its checksum is returned with errChecksum.
+func readRecord(r io.Reader, remaining int64) ([]byte, error) {
+	if remaining < headerSize {
+		return nil, errTorn
+	}
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	length := int64(binary.LittleEndian.Uint32(header[0:4]))
+	if length > maxRecord || length > remaining-headerSize {
+		return nil, errTorn
+	}
+	frame := make([]byte, length)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(frame) != binary.LittleEndian.Uint32(header[4:8]) {
+		return frame, errChecksum
+	}
+	return frame, nil
+}
+
+// decodeChunk decompresses a frame into its entries.
+func decodeChunk(dec *zstd.Decoder, frame []byte) ([]session.Entry, error) {
+	lines, err := dec.DecodeAll(frame, nil)
+	if err != nil {
+		return nil, err
+	}
+	jd := json.NewDecoder(bytes.NewReader(lines))
+	var entries []session.Entry
+	for {
+		var e entry
+		if err := jd.Decode(&e); err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+		entries = append(entries, session.Entry{Kind: e.Kind, Role: e.Role, Content: e.Content, EventType: e.EventType, Metadata: e.Metadata, CreatedAt: e.CreatedAt})
+	}
+}
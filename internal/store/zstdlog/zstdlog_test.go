@@ -0,0 +1,87 @@
+package zstdlog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitop-dev/agent/pkg/session"
+)
+
+func writeLog(t *testing.T, path string, opts Options, n int) {
+	t.Helper()
+	w, _, err := Open(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range n {
+		if err := w.Append(session.Entry{Kind: session.EntryMessage, Role: "user", Content: fmt.Sprintf("message %d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEntriesRoundTripAcrossChunksAndReopens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "s.zst")
+	writeLog(t, path, Options{Chunk: 3, SyncEvery: 2}, 7)
+	writeLog(t, path, Options{}, 2)
+	entries, recovery, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recovery.Truncated() || len(entries) != 9 {
+		t.Fatalf("expected 9 entries and no recovery, got %d and %+v", len(entries), recovery)
+	}
+	if entries[6].Content != "message 6" || entries[7].Content != "message 0" {
+		t.Fatalf("expected entries in order, got %q then %q", entries[6].Content, entries[7].Content)
+	}
+}
+
+func TestTornFinalRecordIsSkippedAndCutOff(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "s.zst")
+	writeLog(t, path, Options{Chunk: 1}, 3)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-5); err != nil {
+		t.Fatal(err)
+	}
+	entries, recovery, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || !recovery.Truncated() {
+		t.Fatalf("expected 2 entries and a recovery, got %d and %+v", len(entries), recovery)
+	}
+
+	// Appending cuts the torn record off first.
+	writeLog(t, path, Options{}, 1)
+	entries, recovery, err = Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || recovery.Truncated() || entries[2].Content != "message 0" {
+		t.Fatalf("expected the new entry after the 2 whole ones, got %+v and %+v", entries, recovery)
+	}
+}
+
+func TestDamageBeforeTheFinalRecordIsAnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "s.zst")
+	writeLog(t, path, Options{Chunk: 1}, 2)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[headerSize+2] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Read(path); err == nil {
+		t.Fatal("expected a damaged first record to fail the read")
+	}
+}
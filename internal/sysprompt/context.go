@@ -0,0 +1,201 @@
+package sysprompt
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bitop-dev/agent/pkg/workspace"
+)
+
+// ContextFileNames are the context files read from each directory, in
+// this order; later ones take precedence within a directory.
+var ContextFileNames = []string{"AGENTS.md", "CLAUDE.md", ".agent.md"}
+
+// defaultContextBytes caps the context files put in the prompt when the
+// profile sets no limit.
+const defaultContextBytes = 32 * 1024
+
+// maxImportDepth bounds chains of @imports.
+const maxImportDepth = 5
+
+// ContextFile is a context file as put in the prompt.
+type ContextFile struct {
+	Path    string
+	Content string // with @imports expanded, cut to fit the limit
+	Bytes   int    // size of the expanded content before any cut
+	Imports []string
+}
+
+// Truncated reports whether the file was cut or left out to fit the limit.
+func (f ContextFile) Truncated() bool { return len(f.Content) < f.Bytes }
+
+// LoadContextFiles reads the context files from the repository root
+// containing cwd, or cwd alone outside a repository, down to cwd, root
+// first: files nearer cwd take precedence. A line holding only
+// "@path" is replaced by that file, relative to the importing one. The
+// files come with the repository, so their imports must stay inside it;
+// only files under the user's ~/.agent may import from anywhere.
+// Together the files are cut to limit bytes (0 uses 32KB), nearest
+// first, so the most specific instructions survive.
+func LoadContextFiles(cwd string, limit int) []ContextFile {
+	if cwd == "" {
+		return nil
+	}
+	if limit <= 0 {
+		limit = defaultContextBytes
+	}
+	var files []ContextFile
+	seen := map[string]bool{}
+	dirs := contextDirs(cwd)
+	root, err := filepath.EvalSymlinks(dirs[0])
+	if err != nil {
+		return nil
+	}
+	if userDir := userAgentDir(); userDir != "" && (workspace.Workspace{Root: userDir}).Contains(root) {
+		root = ""
+	}
+	for _, dir := range dirs {
+		for _, name := range ContextFileNames {
+			path := filepath.Join(dir, name)
+			real, err := filepath.EvalSymlinks(path)
+			if err != nil || seen[real] {
+				continue
+			}
+			data, err := os.ReadFile(path)
+			if err != nil || len(bytes.TrimSpace(data)) == 0 {
+				continue
+			}
+			seen[real] = true
+			file := ContextFile{Path: path}
+			file.Content = strings.TrimSpace(expandImports(string(data), filepath.Dir(real), root, map[string]bool{real: true}, 0, &file.Imports))
+			file.Bytes = len(file.Content)
+			// CLAUDE.md is often a copy of AGENTS.md.
+			if len(files) > 0 && files[len(files)-1].Content == file.Content {
+				continue
+			}
+			files = append(files, file)
+		}
+	}
+	remaining := limit
+	for i := len(files) - 1; i >= 0; i-- {
+		files[i].Content = cut(files[i].Content, remaining)
+		remaining -= len(files[i].Content)
+	}
+	return files
+}
+
+// contextDirs lists the directories from the repository root containing
+// cwd down to cwd, or just cwd when it is not in a repository.
+func contextDirs(cwd string) []string {
+	dirs := []string{cwd}
+	for dir := cwd; ; {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return []string{cwd}
+		}
+		dir = parent
+		dirs = append([]string{dir}, dirs...)
+	}
+	return dirs
+}
+
+// userAgentDir returns the user's ~/.agent directory with symlinks
+// resolved, or "" when there is none.
+func userAgentDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	dir, err := filepath.EvalSymlinks(filepath.Join(home, ".agent"))
+	if err != nil {
+		return ""
+	}
+	return dir
+}
+
+// expandImports replaces each "@path" line outside code fences with the
+// file it names. Imports that cannot be read, that would loop, or that
+// resolve outside root (unless root is "") are left as they are.
+func expandImports(text, dir, root string, chain map[string]bool, depth int, imports *[]string) string {
+	lines := strings.Split(text, "\n")
+	fenced := false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			fenced = !fenced
+		}
+		ref, ok := strings.CutPrefix(trimmed, "@")
+		if fenced || !ok || ref == "" || strings.ContainsAny(ref, " \t") || depth >= maxImportDepth {
+			continue
+		}
+		path := ref
+		if rest, ok := strings.CutPrefix(ref, "~/"); ok {
+			if home, err := os.UserHomeDir(); err == nil {
+				path = filepath.Join(home, rest)
+			}
+		} else if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		real, err := filepath.EvalSymlinks(path)
+		if err != nil || chain[real] || (root != "" && !(workspace.Workspace{Root: root}).Contains(real)) {
+			continue
+		}
+		data, err := os.ReadFile(real)
+		if err != nil {
+			continue
+		}
+		*imports = append(*imports, path)
+		chain[real] = true
+		lines[i] = strings.TrimSpace(expandImports(string(data), filepath.Dir(real), root, chain, depth+1, imports))
+		delete(chain, real)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// cut shortens content to at most limit bytes, at a line break when there
+// is one, leaving room for a notice of what was cut.
+func cut(content string, limit int) string {
+	if len(content) <= limit {
+		return content
+	}
+	limit -= 120 // the notice
+	if limit <= 0 {
+		return ""
+	}
+	content = content[:limit]
+	if i := strings.LastIndexByte(content, '\n'); i > limit/2 {
+		return content[:i]
+	}
+	for !utf8.ValidString(content) {
+		content = content[:len(content)-1]
+	}
+	return content
+}
+
+// contextSection renders the context files, noting those cut to fit.
+func contextSection(files []ContextFile) string {
+	if len(files) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("Project context files follow, from the repository root down to the working directory. Where they disagree, the later, more specific file takes precedence.")
+	for _, f := range files {
+		fmt.Fprintf(&b, "\n\n# %s\n", f.Path)
+		switch {
+		case f.Content == "":
+			fmt.Fprintf(&b, "[omitted: %d bytes over the context file limit]", f.Bytes)
+		case f.Truncated():
+			fmt.Fprintf(&b, "%s\n[truncated: %d of %d bytes shown to fit the context file limit]", f.Content, len(f.Content), f.Bytes)
+		default:
+			b.WriteString(f.Content)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,105 @@
+package sysprompt
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bitop-dev/agent/pkg/profile"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadContextFilesWalksToTheRepositoryRoot(t *testing.T) {
+	outside := t.TempDir()
+	writeFile(t, filepath.Join(outside, "AGENTS.md"), "outside the repository")
+	root := filepath.Join(outside, "repo")
+	if err := os.MkdirAll(filepath.Join(root, ".git"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(root, "AGENTS.md"), "Use Go 1.26.\n@docs/style.md\n```\n@not/an/import\n```")
+	writeFile(t, filepath.Join(root, "CLAUDE.md"), "Use Go 1.26.\n@docs/style.md\n```\n@not/an/import\n```")
+	writeFile(t, filepath.Join(root, "docs", "style.md"), "Tabs, not spaces.\n@style.md")
+	cwd := filepath.Join(root, "pkg", "store")
+	writeFile(t, filepath.Join(cwd, ".agent.md"), "Never change the schema.")
+
+	files := LoadContextFiles(cwd, 0)
+	if len(files) != 2 || files[0].Path != filepath.Join(root, "AGENTS.md") || files[1].Path != filepath.Join(cwd, ".agent.md") {
+		t.Fatalf("expected the root AGENTS.md, once, then the nearest file, got %+v", files)
+	}
+	want := "Use Go 1.26.\nTabs, not spaces.\n@style.md\n```\n@not/an/import\n```"
+	if files[0].Content != want || len(files[0].Imports) != 1 {
+		t.Fatalf("expected the import expanded without looping, got %q", files[0].Content)
+	}
+
+	got, err := Build(Options{Instructions: []string{"be terse"}, CWD: cwd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(got, "be terse\n\nProject context files follow") || !strings.HasSuffix(got, "Never change the schema.") {
+		t.Fatalf("unexpected prompt:\n%s", got)
+	}
+}
+
+func TestLoadContextFilesCutsTheFarthestFirst(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, ".git"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(root, "AGENTS.md"), strings.Repeat("general guidance\n", 40))
+	cwd := filepath.Join(root, "web")
+	writeFile(t, filepath.Join(cwd, "AGENTS.md"), strings.Repeat("frontend rules\n", 20))
+
+	files := LoadContextFiles(cwd, 600)
+	if files[1].Truncated() || !files[0].Truncated() || len(files[0].Content)+len(files[1].Content) > 600 {
+		t.Fatalf("expected only the root file cut, got %+v", files)
+	}
+	section := contextSection(files)
+	if !strings.Contains(section, "[truncated: ") {
+		t.Fatalf("expected a truncation notice, got:\n%s", section)
+	}
+	if files := LoadContextFiles(cwd, 300); !strings.Contains(contextSection(files), "[omitted: 679 bytes over the context file limit]") {
+		t.Fatalf("expected the root file omitted, got %+v", files)
+	}
+
+	got, err := Build(Options{CWD: cwd, ContextFiles: profile.ContextFilesSpec{Disabled: true}})
+	if err != nil || got != "" {
+		t.Fatalf("expected no context files when disabled, got %q, %v", got, err)
+	}
+}
+
+func TestContextFileImportsStayInTheRepository(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	writeFile(t, filepath.Join(home, ".ssh", "config"), "Host secret")
+	outside := t.TempDir()
+	writeFile(t, filepath.Join(outside, "secret.md"), "outside secret")
+	root := filepath.Join(outside, "repo")
+	if err := os.MkdirAll(filepath.Join(root, ".git"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(root, "docs", "style.md"), "Tabs, not spaces.")
+	writeFile(t, filepath.Join(root, "AGENTS.md"), "@docs/style.md\n@../secret.md\n@"+filepath.Join(outside, "secret.md")+"\n@~/.ssh/config")
+
+	files := LoadContextFiles(root, 0)
+	want := "Tabs, not spaces.\n@../secret.md\n@" + filepath.Join(outside, "secret.md") + "\n@~/.ssh/config"
+	if len(files) != 1 || files[0].Content != want {
+		t.Fatalf("expected only the in-repository import expanded, got %+v", files)
+	}
+
+	// The user's own files under ~/.agent may import from anywhere.
+	own := filepath.Join(home, ".agent", "notes")
+	writeFile(t, filepath.Join(own, "AGENTS.md"), "@~/.ssh/config")
+	if files := LoadContextFiles(own, 0); len(files) != 1 || files[0].Content != "Host secret" {
+		t.Fatalf("expected the home import expanded from ~/.agent, got %+v", files)
+	}
+}
@@ -0,0 +1,290 @@
+// Package sysprompt composes the system prompt sent to the provider.
+//
+// A profile's instructions.system list is always available as the
+// "instructions" section, followed by the project's context files (see
+// LoadContextFiles). Profiles can add built-in sections (date, cwd, os,
+// tools, memory), reorder or disable them, and define custom sections
+// rendered with Go templates. Embedders that want full control over layout can supply a
+// Renderer instead of relying on the default blank-line join.
+package sysprompt
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+	"time"
+
+	internalmemory "github.com/bitop-dev/agent/internal/memory"
+	"github.com/bitop-dev/agent/internal/registry"
+	skilltools "github.com/bitop-dev/agent/internal/tools/skill"
+	"github.com/bitop-dev/agent/pkg/memory"
+	"github.com/bitop-dev/agent/pkg/profile"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// Built-in section names.
+const (
+	SectionInstructions = "instructions"
+	SectionContext      = "context"
+	SectionDate         = "date"
+	SectionCWD          = "cwd"
+	SectionOS           = "os"
+	SectionTools        = "tools"
+	SectionMemory       = "memory"
+	SectionSkills       = "skills"
+)
+
+// memorySummaryChars caps the project memory section.
+const memorySummaryChars = 4000
+
+// Options controls how the system prompt is composed.
+type Options struct {
+	ProfileName  string
+	ProfilePath  string
+	Instructions []string // instructions.system entries (prompt IDs, files, or inline text)
+	Sections     []profile.PromptSection
+	ContextFiles profile.ContextFilesSpec
+	Prompts      *registry.PromptRegistry
+	CWD          string
+	Tools        []tool.Tool
+	Memory       []memory.Note // project notes summarized into the memory section
+	Renderer     Renderer      // optional; defaults to joining sections with a blank line
+	Now          time.Time     // optional; defaults to time.Now()
+}
+
+// Section is one rendered block of the system prompt.
+type Section struct {
+	Name    string
+	Content string
+}
+
+// Data is the value passed to custom section templates and to Renderer.
+type Data struct {
+	Profile   string
+	Date      string
+	Time      string
+	CWD       string
+	OS        string
+	Arch      string
+	GitBranch string
+	Tools     []string
+	Env       map[string]string
+}
+
+// Renderer lays out the rendered sections into the final prompt text.
+type Renderer func(data Data, sections []Section) string
+
+// Build resolves the instructions, renders every enabled section in order,
+// and hands the result to the renderer. When no sections are configured the
+// prompt consists of the instructions followed by the project's context
+// files, the index of enabled skills, and project memory, if any.
+func Build(opts Options) (string, error) {
+	data := newData(opts)
+	specs := opts.Sections
+	if len(specs) == 0 {
+		specs = []profile.PromptSection{{Name: SectionInstructions}, {Name: SectionContext}, {Name: SectionSkills}, {Name: SectionMemory}}
+	}
+	sections := make([]Section, 0, len(specs))
+	for _, spec := range specs {
+		if spec.Disabled {
+			continue
+		}
+		content, err := renderSection(spec, opts, data)
+		if err != nil {
+			return "", err
+		}
+		content = strings.TrimSpace(content)
+		if content == "" {
+			continue
+		}
+		sections = append(sections, Section{Name: spec.Name, Content: content})
+	}
+	render := opts.Renderer
+	if render == nil {
+		render = JoinSections
+	}
+	return render(data, sections), nil
+}
+
+// JoinSections is the default Renderer: section contents separated by a blank line.
+func JoinSections(_ Data, sections []Section) string {
+	chunks := make([]string, 0, len(sections))
+	for _, s := range sections {
+		chunks = append(chunks, s.Content)
+	}
+	return strings.Join(chunks, "\n\n")
+}
+
+func renderSection(spec profile.PromptSection, opts Options, data Data) (string, error) {
+	if spec.Template != "" {
+		tmpl, err := template.New(spec.Name).Option("missingkey=zero").Parse(spec.Template)
+		if err != nil {
+			return "", fmt.Errorf("system prompt section %q: %w", spec.Name, err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return "", fmt.Errorf("system prompt section %q: %w", spec.Name, err)
+		}
+		return buf.String(), nil
+	}
+	switch spec.Name {
+	case SectionInstructions:
+		return LoadInstructions(opts.ProfilePath, opts.Instructions, opts.Prompts), nil
+	case SectionContext:
+		if opts.ContextFiles.Disabled {
+			return "", nil
+		}
+		return contextSection(LoadContextFiles(opts.CWD, opts.ContextFiles.MaxBytes)), nil
+	case SectionDate:
+		return "Current date: " + data.Date, nil
+	case SectionCWD:
+		if data.CWD == "" {
+			return "", nil
+		}
+		return "Current working directory: " + data.CWD, nil
+	case SectionOS:
+		return fmt.Sprintf("Operating system: %s/%s", data.OS, data.Arch), nil
+	case SectionTools:
+		return toolsSection(opts.Tools), nil
+	case SectionMemory:
+		return internalmemory.Summary(opts.Memory, memorySummaryChars), nil
+	case SectionSkills:
+		return skillsSection(opts.Tools), nil
+	default:
+		return "", fmt.Errorf("system prompt section %q: unknown section without template", spec.Name)
+	}
+}
+
+// LoadInstructions resolves the profile's instructions.system list. Each
+// entry is resolved in this order:
+//
+//  1. Registered prompt ID — if the entry matches a prompt registered by an
+//     enabled plugin (e.g. "email/style-default"), the plugin's prompt file is
+//     loaded. This lets profiles reference plugin-contributed prompts by ID
+//     without hard-coding file paths.
+//
+//  2. File path — the entry is treated as a path relative to the profile
+//     directory (or absolute). The file is read and its content is used.
+//
+//  3. Inline text — if neither lookup succeeds the entry itself is used as
+//     literal prompt text, useful for short one-line instructions.
+//
+// All resolved chunks are joined with a blank line separator.
+func LoadInstructions(profilePath string, refs []string, prompts *registry.PromptRegistry) string {
+	baseDir := filepath.Dir(profilePath)
+	chunks := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		// 1. Try as a registered plugin prompt ID.
+		if prompts != nil {
+			if asset, ok := prompts.Get(ref); ok && asset.Path != "" {
+				if data, err := os.ReadFile(asset.Path); err == nil {
+					chunks = append(chunks, strings.TrimSpace(string(data)))
+					continue
+				}
+			}
+		}
+		// 2. Try as a file path relative to the profile directory.
+		candidate := ref
+		if !filepath.IsAbs(candidate) {
+			candidate = filepath.Join(baseDir, candidate)
+		}
+		if data, err := os.ReadFile(candidate); err == nil {
+			chunks = append(chunks, strings.TrimSpace(string(data)))
+			continue
+		}
+		// 3. Use as inline literal text.
+		chunks = append(chunks, ref)
+	}
+	return strings.Join(chunks, "\n\n")
+}
+
+func toolsSection(tools []tool.Tool) string {
+	if len(tools) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("Available tools:")
+	for _, t := range tools {
+		def := t.Definition()
+		b.WriteString("\n- ")
+		b.WriteString(def.ID)
+		if def.Description != "" {
+			b.WriteString(": ")
+			b.WriteString(def.Description)
+		}
+	}
+	return b.String()
+}
+
+// skillsSection indexes the enabled skills by name and description; their
+// full instructions stay out of the prompt until the model loads one.
+func skillsSection(tools []tool.Tool) string {
+	var b strings.Builder
+	for _, t := range tools {
+		load, ok := t.(skilltools.LoadTool)
+		if !ok {
+			continue
+		}
+		if b.Len() == 0 {
+			b.WriteString("Skills hold instructions, scripts, and reference files for specific tasks. Before starting a task a skill covers, call its load tool to read the full instructions.")
+		}
+		fmt.Fprintf(&b, "\n- %s: %s (load with %s)", load.Skill.Name, load.Skill.Description, load.Definition().ID)
+	}
+	return b.String()
+}
+
+func newData(opts Options) Data {
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			env[k] = v
+		}
+	}
+	toolIDs := make([]string, 0, len(opts.Tools))
+	for _, t := range opts.Tools {
+		toolIDs = append(toolIDs, t.Definition().ID)
+	}
+	return Data{
+		Profile:   opts.ProfileName,
+		Date:      now.Format("2006-01-02"),
+		Time:      now.Format("15:04 MST"),
+		CWD:       opts.CWD,
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		GitBranch: gitBranch(opts.CWD),
+		Tools:     toolIDs,
+		Env:       env,
+	}
+}
+
+// gitBranch reads .git/HEAD from dir or its nearest ancestor. It avoids
+// shelling out to git so prompt assembly stays cheap and side-effect free.
+func gitBranch(dir string) string {
+	if dir == "" {
+		return ""
+	}
+	for current := dir; ; current = filepath.Dir(current) {
+		data, err := os.ReadFile(filepath.Join(current, ".git", "HEAD"))
+		if err == nil {
+			head := strings.TrimSpace(string(data))
+			if ref, ok := strings.CutPrefix(head, "ref: refs/heads/"); ok {
+				return ref
+			}
+			if len(head) > 12 {
+				return head[:12]
+			}
+			return head
+		}
+		if filepath.Dir(current) == current {
+			return ""
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package sysprompt
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/profile"
+)
+
+func TestBuildDefaultsToInstructions(t *testing.T) {
+	got, err := Build(Options{Instructions: []string{"be terse", "cite files"}})
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if got != "be terse\n\ncite files" {
+		t.Fatalf("unexpected prompt: %q", got)
+	}
+}
+
+func TestBuildOrdersSectionsAndRendersTemplates(t *testing.T) {
+	t.Setenv("AGENT_TEST_TEAM", "platform")
+	got, err := Build(Options{
+		ProfileName:  "coder",
+		Instructions: []string{"be terse"},
+		Now:          time.Date(2026, 3, 4, 10, 0, 0, 0, time.UTC),
+		Sections: []profile.PromptSection{
+			{Name: "date"},
+			{Name: "team", Template: "Team: {{.Env.AGENT_TEST_TEAM}} ({{.Profile}})"},
+			{Name: "cwd", Disabled: true},
+			{Name: "instructions"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	want := "Current date: 2026-03-04\n\nTeam: platform (coder)\n\nbe terse"
+	if got != want {
+		t.Fatalf("unexpected prompt:\n%s", got)
+	}
+}
+
+func TestBuildCustomRenderer(t *testing.T) {
+	got, err := Build(Options{
+		Instructions: []string{"be terse"},
+		Renderer: func(_ Data, sections []Section) string {
+			var b strings.Builder
+			for _, s := range sections {
+				b.WriteString("<" + s.Name + ">" + s.Content + "</" + s.Name + ">")
+			}
+			return b.String()
+		},
+	})
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if got != "<instructions>be terse</instructions>" {
+		t.Fatalf("unexpected prompt: %q", got)
+	}
+}
+
+func TestBuildRejectsUnknownSection(t *testing.T) {
+	if _, err := Build(Options{Sections: []profile.PromptSection{{Name: "bogus"}}}); err == nil {
+		t.Fatal("expected error for unknown section without template")
+	}
+}
@@ -0,0 +1,119 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnauthorized    = errors.New("missing or unknown API key")
+	ErrModelNotAllowed = errors.New("model not allowed for this API key")
+	ErrRateLimited     = errors.New("rate limit exceeded")
+	ErrQuotaExceeded   = errors.New("monthly quota exceeded")
+)
+
+// Gateway authenticates requests and enforces per-client limits.
+type Gateway struct {
+	Keys  KeyStore
+	Usage *FileUsage
+	Now   func() time.Time // defaults to time.Now
+
+	mu     sync.Mutex
+	recent map[string][]time.Time // request times in the last minute, per client
+}
+
+// Authorize identifies the caller from its bearer token and checks its rate
+// limit and monthly quotas. A successful call counts toward the rate limit.
+func (g *Gateway) Authorize(ctx context.Context, r *http.Request) (Client, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return Client{}, ErrUnauthorized
+	}
+	client, found, err := g.Keys.Lookup(ctx, token)
+	if err != nil {
+		return Client{}, err
+	}
+	if !found {
+		return Client{}, ErrUnauthorized
+	}
+	now := g.now()
+	if client.MonthlyTokens > 0 || client.MonthlyUSD > 0 {
+		used, err := g.Usage.Get(ctx, client.Name, MonthOf(now))
+		if err != nil {
+			return Client{}, err
+		}
+		if client.MonthlyTokens > 0 && used.Tokens() >= client.MonthlyTokens {
+			return Client{}, fmt.Errorf("%w: %d of %d tokens used", ErrQuotaExceeded, used.Tokens(), client.MonthlyTokens)
+		}
+		if client.MonthlyUSD > 0 && used.CostUSD >= client.MonthlyUSD {
+			return Client{}, fmt.Errorf("%w: $%.2f of $%.2f spent", ErrQuotaExceeded, used.CostUSD, client.MonthlyUSD)
+		}
+	}
+	if client.RateLimit > 0 && !g.allow(client, now) {
+		return Client{}, fmt.Errorf("%w: %d requests per minute", ErrRateLimited, client.RateLimit)
+	}
+	return client, nil
+}
+
+// CheckModels rejects the request unless every model it may use is allowed.
+func (g *Gateway) CheckModels(client Client, models ...string) error {
+	for _, model := range models {
+		if !client.AllowsModel(model) {
+			return fmt.Errorf("%w: %s", ErrModelNotAllowed, model)
+		}
+	}
+	return nil
+}
+
+// Record adds a finished request's consumption to the client's usage.
+func (g *Gateway) Record(ctx context.Context, client Client, inputTokens, outputTokens int, costUSD float64) error {
+	return g.Usage.Record(ctx, client.Name, g.now(), inputTokens, outputTokens, costUSD)
+}
+
+// StatusCode maps gateway errors to HTTP status codes.
+func StatusCode(err error) int {
+	switch {
+	case errors.Is(err, ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrModelNotAllowed):
+		return http.StatusForbidden
+	case errors.Is(err, ErrRateLimited), errors.Is(err, ErrQuotaExceeded):
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// allow applies a one-minute sliding window.
+func (g *Gateway) allow(client Client, now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.recent == nil {
+		g.recent = map[string][]time.Time{}
+	}
+	cutoff := now.Add(-time.Minute)
+	kept := g.recent[client.Name][:0]
+	for _, t := range g.recent[client.Name] {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	if len(kept) >= client.RateLimit {
+		g.recent[client.Name] = kept
+		return false
+	}
+	g.recent[client.Name] = append(kept, now)
+	return true
+}
+
+func (g *Gateway) now() time.Time {
+	if g.Now != nil {
+		return g.Now()
+	}
+	return time.Now()
+}
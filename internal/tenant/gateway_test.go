@@ -0,0 +1,103 @@
+package tenant
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGatewayEnforcesKeysLimitsAndQuotas(t *testing.T) {
+	dir := t.TempDir()
+	sum := sha256.Sum256([]byte("bob-secret"))
+	keys := filepath.Join(dir, "keys.yaml")
+	if err := os.WriteFile(keys, []byte(`clients:
+  - name: alice
+    key: alice-secret
+    models: ["gpt-4o*"]
+    rateLimit: 2
+  - name: bob
+    key: sha256:`+hex.EncodeToString(sum[:])+`
+    monthlyTokens: 100
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	gate := &Gateway{
+		Keys:  &FileKeyStore{Path: keys},
+		Usage: &FileUsage{Path: filepath.Join(dir, "usage.json")},
+		Now:   func() time.Time { return now },
+	}
+	ctx := context.Background()
+	request := func(token string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/v1/task", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		return r
+	}
+
+	if _, err := gate.Authorize(ctx, request("")); StatusCode(err) != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a key, got %v", err)
+	}
+	if _, err := gate.Authorize(ctx, request("wrong")); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected unknown key to be rejected, got %v", err)
+	}
+
+	alice, err := gate.Authorize(ctx, request("alice-secret"))
+	if err != nil || alice.Name != "alice" {
+		t.Fatalf("authorize alice: %+v %v", alice, err)
+	}
+	if err := gate.CheckModels(alice, "gpt-4o-mini"); err != nil {
+		t.Fatalf("gpt-4o-mini should be allowed: %v", err)
+	}
+	if err := gate.CheckModels(alice, "gpt-4o", "claude-sonnet-4-5"); StatusCode(err) != http.StatusForbidden {
+		t.Fatalf("expected fallback outside the allowlist to be forbidden, got %v", err)
+	}
+	if _, err := gate.Authorize(ctx, request("alice-secret")); err != nil {
+		t.Fatalf("second request within limit: %v", err)
+	}
+	if _, err := gate.Authorize(ctx, request("alice-secret")); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected third request in a minute to be rate limited, got %v", err)
+	}
+	now = now.Add(61 * time.Second)
+	if _, err := gate.Authorize(ctx, request("alice-secret")); err != nil {
+		t.Fatalf("rate limit should reset after a minute: %v", err)
+	}
+
+	bob, err := gate.Authorize(ctx, request("bob-secret"))
+	if err != nil || bob.Name != "bob" {
+		t.Fatalf("authorize bob by digest: %+v %v", bob, err)
+	}
+	if err := gate.Record(ctx, bob, 80, 30, 0.25); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gate.Authorize(ctx, request("bob-secret")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected monthly token quota to be enforced, got %v", err)
+	}
+	now = time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := gate.Authorize(ctx, request("bob-secret")); err != nil {
+		t.Fatalf("quota should reset in a new month: %v", err)
+	}
+
+	if err := gate.Record(ctx, alice, 10, 5, 0.01); err != nil {
+		t.Fatal(err)
+	}
+	october, err := gate.Usage.Month(ctx, "2026-10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(october) != 1 || october[0].Client != "bob" || october[0].Tokens() != 110 || october[0].Requests != 1 {
+		t.Fatalf("unexpected October usage: %+v", october)
+	}
+	november, _ := gate.Usage.Month(ctx, "2026-11")
+	if len(november) != 1 || november[0].Client != "alice" || november[0].CostUSD != 0.01 {
+		t.Fatalf("unexpected November usage: %+v", november)
+	}
+}
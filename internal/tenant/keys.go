@@ -0,0 +1,131 @@
+// Package tenant lets one serve worker act as a team gateway: callers are
+// identified by API key, and each named client has its own model allowlist,
+// rate limit, and monthly token and cost quotas.
+package tenant
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Client is one named API consumer.
+type Client struct {
+	Name string `yaml:"name" json:"name"`
+	// Key is the bearer token, or "sha256:<hex>" to store only its digest.
+	Key           string   `yaml:"key" json:"-"`
+	Models        []string `yaml:"models,omitempty" json:"models,omitempty"`               // allowed models as glob patterns; empty allows all
+	RateLimit     int      `yaml:"rateLimit,omitempty" json:"rateLimit,omitempty"`         // requests per minute; 0 is unlimited
+	MonthlyTokens int      `yaml:"monthlyTokens,omitempty" json:"monthlyTokens,omitempty"` // input+output tokens per calendar month (UTC)
+	MonthlyUSD    float64  `yaml:"monthlyUSD,omitempty" json:"monthlyUSD,omitempty"`
+	Admin         bool     `yaml:"admin,omitempty" json:"admin,omitempty"` // may read every client's usage
+}
+
+// AllowsModel reports whether the client may use model.
+func (c Client) AllowsModel(model string) bool {
+	if len(c.Models) == 0 {
+		return true
+	}
+	for _, pattern := range c.Models {
+		if ok, _ := path.Match(pattern, model); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Client) matches(token string) bool {
+	if digest, ok := strings.CutPrefix(c.Key, "sha256:"); ok {
+		sum := sha256.Sum256([]byte(token))
+		return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(strings.ToLower(digest))) == 1
+	}
+	return c.Key != "" && subtle.ConstantTimeCompare([]byte(token), []byte(c.Key)) == 1
+}
+
+// KeyStore maps bearer tokens to clients.
+type KeyStore interface {
+	Lookup(ctx context.Context, token string) (Client, bool, error)
+}
+
+// KeyStoreFunc adapts a callback, such as a lookup against an external
+// identity service, to KeyStore.
+type KeyStoreFunc func(ctx context.Context, token string) (Client, bool, error)
+
+func (f KeyStoreFunc) Lookup(ctx context.Context, token string) (Client, bool, error) {
+	return f(ctx, token)
+}
+
+// FileKeyStore reads clients from a YAML file with a top-level "clients"
+// list. The file is re-read when its modification time changes, so keys can
+// be rotated without restarting the worker.
+type FileKeyStore struct {
+	Path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	clients []Client
+}
+
+func (s *FileKeyStore) Lookup(_ context.Context, token string) (Client, bool, error) {
+	clients, err := s.load()
+	if err != nil {
+		return Client{}, false, err
+	}
+	for _, c := range clients {
+		if c.matches(token) {
+			return c, true, nil
+		}
+	}
+	return Client{}, false, nil
+}
+
+// Clients returns the configured clients.
+func (s *FileKeyStore) Clients() ([]Client, error) {
+	return s.load()
+}
+
+func (s *FileKeyStore) load() ([]Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info, err := os.Stat(s.Path)
+	if err != nil {
+		return nil, fmt.Errorf("read keys file: %w", err)
+	}
+	if s.clients != nil && info.ModTime().Equal(s.modTime) {
+		return s.clients, nil
+	}
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return nil, fmt.Errorf("read keys file: %w", err)
+	}
+	var doc struct {
+		Clients []Client `yaml:"clients"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse keys file %s: %w", s.Path, err)
+	}
+	seen := map[string]bool{}
+	for _, c := range doc.Clients {
+		if c.Name == "" || c.Key == "" {
+			return nil, fmt.Errorf("keys file %s: every client needs a name and a key", s.Path)
+		}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("keys file %s: duplicate client %q", s.Path, c.Name)
+		}
+		seen[c.Name] = true
+	}
+	if doc.Clients == nil {
+		doc.Clients = []Client{}
+	}
+	s.clients, s.modTime = doc.Clients, info.ModTime()
+	return s.clients, nil
+}
@@ -0,0 +1,129 @@
+package tenant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bitop-dev/agent/internal/filelock"
+)
+
+// Usage is one client's consumption in one calendar month (UTC).
+type Usage struct {
+	Client       string  `json:"client"`
+	Month        string  `json:"month"` // YYYY-MM
+	Requests     int     `json:"requests"`
+	InputTokens  int     `json:"inputTokens"`
+	OutputTokens int     `json:"outputTokens"`
+	CostUSD      float64 `json:"costUSD"`
+}
+
+// Tokens is the input plus output token count.
+func (u Usage) Tokens() int { return u.InputTokens + u.OutputTokens }
+
+// MonthOf formats t as the month key used by Usage.
+func MonthOf(t time.Time) string { return t.UTC().Format("2006-01") }
+
+// FileUsage aggregates usage per client and month in a small JSON file.
+// Writers lock a sibling ".lock" file, so gateways sharing the file do not
+// lose each other's requests.
+type FileUsage struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// Record adds one request's consumption to the client's monthly total.
+func (u *FileUsage) Record(_ context.Context, client string, at time.Time, inputTokens, outputTokens int, costUSD float64) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	unlock, err := filelock.Lock(u.Path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	rows, err := u.load()
+	if err != nil {
+		return err
+	}
+	month := MonthOf(at)
+	i := sort.Search(len(rows), func(i int) bool { return !rowLess(rows[i], client, month) })
+	if i == len(rows) || rows[i].Client != client || rows[i].Month != month {
+		rows = append(rows, Usage{})
+		copy(rows[i+1:], rows[i:])
+		rows[i] = Usage{Client: client, Month: month}
+	}
+	rows[i].Requests++
+	rows[i].InputTokens += inputTokens
+	rows[i].OutputTokens += outputTokens
+	rows[i].CostUSD += costUSD
+	data, err := json.Marshal(map[string]any{"usage": rows})
+	if err != nil {
+		return err
+	}
+	tmp := u.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, u.Path)
+}
+
+// Month returns every client's usage for month (YYYY-MM), by client name.
+func (u *FileUsage) Month(_ context.Context, month string) ([]Usage, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	rows, err := u.load()
+	if err != nil {
+		return nil, err
+	}
+	var out []Usage
+	for _, row := range rows {
+		if row.Month == month {
+			out = append(out, row)
+		}
+	}
+	return out, nil
+}
+
+// Get returns one client's usage for month; the zero value when none.
+func (u *FileUsage) Get(ctx context.Context, client, month string) (Usage, error) {
+	rows, err := u.Month(ctx, month)
+	if err != nil {
+		return Usage{}, err
+	}
+	for _, row := range rows {
+		if row.Client == client {
+			return row, nil
+		}
+	}
+	return Usage{Client: client, Month: month}, nil
+}
+
+func rowLess(row Usage, client, month string) bool {
+	if row.Client != client {
+		return row.Client < client
+	}
+	return row.Month < month
+}
+
+func (u *FileUsage) load() ([]Usage, error) {
+	data, err := os.ReadFile(u.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Usage []Usage `json:"usage"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc.Usage, nil
+}
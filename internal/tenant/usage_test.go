@@ -0,0 +1,34 @@
+package tenant
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUsageSharedByGatewaysKeepsEveryRequest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	at := time.Date(2026, 3, 14, 12, 0, 0, 0, time.UTC)
+	var wg sync.WaitGroup
+	for range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// A store apiece, as separate gateway processes would have.
+			usage := &FileUsage{Path: path}
+			if err := usage.Record(context.Background(), "acme", at, 10, 5, 0.01); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	got, err := (&FileUsage{Path: path}).Get(context.Background(), "acme", MonthOf(at))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Requests != 100 || got.Tokens() != 1500 {
+		t.Fatalf("expected 100 requests and 1500 tokens, got %+v", got)
+	}
+}
@@ -1,24 +1,121 @@
 package core
 
 import (
+	"bytes"
 	"context"
-	"os/exec"
+	"errors"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
 
+	"github.com/bitop-dev/agent/internal/tools/procenv"
 	"github.com/bitop-dev/agent/pkg/tool"
 )
 
 type BashTool struct{}
 
 func (BashTool) Definition() tool.Definition {
-	return tool.Definition{ID: "core/bash", Description: "Run a shell command subject to policy and approval"}
+	return tool.Definition{
+		ID:          "core/bash",
+		Description: "Run a shell command subject to policy and approval",
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"command": map[string]any{"type": "string"},
+				"reset":   map[string]any{"type": "boolean", "description": "Kill this session's persistent shell, and any command still running in it, before running command; command may then be omitted"},
+			},
+		},
+	}
+}
+
+func (BashTool) Capabilities() tool.Capabilities {
+	return tool.Capabilities{SupportsStreaming: true, SupportsCancel: true, Exclusive: []string{tool.ExclusiveWorkspace}}
+}
+
+// CloseSession kills the session's persistent shell, if it has one.
+func (BashTool) CloseSession(sessionID string) {
+	shells.reset(sessionID)
 }
 
 func (BashTool) Run(ctx context.Context, call tool.Call) (tool.Result, error) {
+	opts, _ := tool.ExecOptionsFrom(ctx)
+	sessionID, hasSession := tool.SessionFrom(ctx)
+	if reset, _ := call.Arguments["reset"].(bool); reset {
+		if hasSession {
+			shells.reset(sessionID)
+		}
+		if _, ok := call.Arguments["command"]; !ok {
+			return tool.Result{ToolID: call.ToolID, Output: "shell reset"}, nil
+		}
+	}
 	command, err := argString(call.Arguments, "command")
 	if err != nil {
 		return tool.Result{}, err
 	}
-	cmd := exec.CommandContext(ctx, "/bin/sh", "-lc", command)
-	output, err := cmd.CombinedOutput()
-	return tool.Result{ToolID: call.ToolID, Output: string(output)}, err
+	if len(opts.AllowedCommands) > 0 {
+		if err := checkAllowedCommands(command, opts.AllowedCommands); err != nil {
+			return tool.Result{}, err
+		}
+	}
+	if opts.Persistent && hasSession {
+		output, err := shells.run(ctx, sessionID, command)
+		return tool.Result{ToolID: call.ToolID, Output: output}, err
+	}
+	cmd, err := procenv.Command(ctx, "/bin/sh", "-lc", command)
+	if err != nil {
+		return tool.Result{}, err
+	}
+	if grace, ok := tool.AbortGraceFrom(ctx); ok && grace > 0 {
+		// On abort, interrupt the command and kill it only if it has not
+		// exited within the grace period, so it can clean up.
+		cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+		cmd.WaitDelay = grace
+	}
+	output := &streamingBuffer{update: tool.UpdatesFrom(ctx)}
+	cmd.Stdout, cmd.Stderr = output, output
+	err = cmd.Run()
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = tool.WrapError(tool.ErrTimeout, err)
+	}
+	return tool.Result{ToolID: call.ToolID, Output: output.String()}, err
+}
+
+// streamingBuffer collects a command's output and passes each write on as
+// an update.
+type streamingBuffer struct {
+	bytes.Buffer
+	update tool.UpdateFunc
+}
+
+func (b *streamingBuffer) Write(p []byte) (int, error) {
+	b.update(string(p))
+	return b.Buffer.Write(p)
+}
+
+// shellSeparators split a command line into the simple commands it runs,
+// once redirections such as 2>&1, which also contain "&", are removed.
+var (
+	shellSeparators  = regexp.MustCompile(`&&|\|\||[;&|()\n]`)
+	shellRedirection = regexp.MustCompile(`[0-9]*[<>]&[0-9-]*|&>>?`)
+)
+
+// checkAllowedCommands rejects a command line that runs a program not in
+// allowed. Programs must be named as listed, so "./go" is not "go", and
+// command substitution is rejected since its programs cannot be checked.
+func checkAllowedCommands(command string, allowed []string) error {
+	if strings.Contains(command, "`") || strings.Contains(command, "$(") {
+		return tool.Errorf(tool.ErrPermissionDenied, "command substitution is not allowed when core/bash may only run %s", strings.Join(allowed, ", "))
+	}
+	for _, part := range shellSeparators.Split(shellRedirection.ReplaceAllString(command, " "), -1) {
+		fields := strings.Fields(part)
+		// Skip environment assignments such as CI=1.
+		for len(fields) > 0 && strings.Index(fields[0], "=") > 0 {
+			fields = fields[1:]
+		}
+		if len(fields) > 0 && !slices.Contains(allowed, fields[0]) {
+			return tool.Errorf(tool.ErrPermissionDenied, "%q is not an allowed command (core/bash may only run %s)", fields[0], strings.Join(allowed, ", "))
+		}
+	}
+	return nil
 }
@@ -2,10 +2,11 @@ package core
 
 import (
 	"context"
-	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
+	"github.com/bitop-dev/agent/internal/diff"
 	"github.com/bitop-dev/agent/pkg/tool"
 )
 
@@ -15,30 +16,54 @@ func (EditTool) Definition() tool.Definition {
 	return tool.Definition{ID: "core/edit", Description: "Edit a file inside the local workspace"}
 }
 
-func (EditTool) Run(_ context.Context, call tool.Call) (tool.Result, error) {
-	path, err := argString(call.Arguments, "path")
+func (EditTool) Capabilities() tool.Capabilities {
+	return tool.Capabilities{Exclusive: []string{tool.ExclusiveWorkspace}}
+}
+
+func (EditTool) Run(ctx context.Context, call tool.Call) (tool.Result, error) {
+	path, _, updated, err := edited(ctx, call)
 	if err != nil {
 		return tool.Result{}, err
 	}
+	if err := os.WriteFile(path, []byte(updated), 0o644); err != nil {
+		return tool.Result{}, err
+	}
+	return tool.Result{ToolID: call.ToolID, Output: "edited file", Data: map[string]any{"path": path, "bytes_written": len(updated)}, Artifacts: []tool.Artifact{{Path: path, Type: "file", Description: "modified"}}}, nil
+}
+
+// DryRun returns the diff the edit would make.
+func (EditTool) DryRun(ctx context.Context, call tool.Call) (string, error) {
+	_, content, updated, err := edited(ctx, call)
+	if err != nil {
+		return "", err
+	}
+	name, _ := argString(call.Arguments, "path")
+	return diff.Unified(filepath.Clean(name), content, updated), nil
+}
+
+// edited returns the file an edit call changes, with its content before
+// and after the edit.
+func edited(ctx context.Context, call tool.Call) (path, content, updated string, err error) {
+	path, err = argString(call.Arguments, "path")
+	if err != nil {
+		return "", "", "", err
+	}
+	path = workspacePath(ctx, path)
 	oldText, err := argString(call.Arguments, "old")
 	if err != nil {
-		return tool.Result{}, err
+		return "", "", "", err
 	}
 	newText, err := argString(call.Arguments, "new")
 	if err != nil {
-		return tool.Result{}, err
+		return "", "", "", err
 	}
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return tool.Result{}, err
+		return "", "", "", err
 	}
-	content := string(data)
+	content = string(data)
 	if !strings.Contains(content, oldText) {
-		return tool.Result{}, fmt.Errorf("old text not found in %s", path)
-	}
-	updated := strings.Replace(content, oldText, newText, 1)
-	if err := os.WriteFile(path, []byte(updated), 0o644); err != nil {
-		return tool.Result{}, err
+		return "", "", "", tool.Errorf(tool.ErrInvalidArgs, "old text not found in %s", path)
 	}
-	return tool.Result{ToolID: call.ToolID, Output: "edited file", Data: map[string]any{"path": path}}, nil
+	return path, content, strings.Replace(content, oldText, newText, 1), nil
 }
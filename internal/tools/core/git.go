@@ -0,0 +1,432 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bitop-dev/agent/internal/tools/procenv"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// The git tools run the git binary directly, without a shell, so each call
+// is one well-defined operation that policies and approvers can reason
+// about. Arguments that could be read as options are rejected.
+
+// maxGitOutput caps diff and log output returned to the model.
+const maxGitOutput = 64 * 1024
+
+// GitTools returns the tools of the @git preset.
+func GitTools() []tool.Tool {
+	return []tool.Tool{GitStatusTool{}, GitDiffTool{}, GitLogTool{}, GitBranchTool{}, GitCommitTool{}}
+}
+
+type GitStatusTool struct{}
+
+func (GitStatusTool) Definition() tool.Definition {
+	return tool.Definition{
+		ID:          "git/status",
+		Description: "Show the current branch, upstream tracking, and changed files (staged, unstaged, untracked)",
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"path": map[string]any{"type": "string", "description": "Limit to this path"},
+			},
+		},
+	}
+}
+
+func (GitStatusTool) Capabilities() tool.Capabilities {
+	return tool.Capabilities{Tags: []string{"git"}}
+}
+
+func (GitStatusTool) Run(ctx context.Context, call tool.Call) (tool.Result, error) {
+	args := []string{"status", "--porcelain=v1", "--branch"}
+	if path, err := gitPathArg(call.Arguments); err != nil {
+		return tool.Result{}, err
+	} else if path != "" {
+		args = append(args, "--", path)
+	}
+	out, err := runGit(ctx, args...)
+	if err != nil {
+		return tool.Result{}, err
+	}
+	branch := ""
+	var files []map[string]any
+	for _, line := range strings.Split(strings.TrimRight(out, "\n"), "\n") {
+		if rest, ok := strings.CutPrefix(line, "## "); ok {
+			branch = rest
+			continue
+		}
+		if len(line) < 4 {
+			continue
+		}
+		files = append(files, map[string]any{"index": strings.TrimSpace(line[:1]), "worktree": strings.TrimSpace(line[1:2]), "path": line[3:]})
+	}
+	output := "branch: " + branch + "\n"
+	if len(files) == 0 {
+		output += "working tree clean"
+	} else {
+		output += strings.Join(strings.Split(strings.TrimRight(out, "\n"), "\n")[1:], "\n")
+	}
+	return tool.Result{ToolID: call.ToolID, Output: output, Data: map[string]any{"branch": branch, "files": files}}, nil
+}
+
+type GitDiffTool struct{}
+
+func (GitDiffTool) Definition() tool.Definition {
+	return tool.Definition{
+		ID:          "git/diff",
+		Description: "Show changes as a unified diff: unstaged by default, staged with staged=true, or against a ref",
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"staged": map[string]any{"type": "boolean", "description": "Diff the index against HEAD"},
+				"ref":    map[string]any{"type": "string", "description": "Compare the working tree (or index when staged) with this commit or branch"},
+				"path":   map[string]any{"type": "string", "description": "Limit to this path"},
+				"stat":   map[string]any{"type": "boolean", "description": "Summarize changed files instead of the full diff"},
+			},
+		},
+	}
+}
+
+func (GitDiffTool) Capabilities() tool.Capabilities {
+	return tool.Capabilities{Tags: []string{"git"}}
+}
+
+func (GitDiffTool) Run(ctx context.Context, call tool.Call) (tool.Result, error) {
+	args := []string{"diff", "--no-color", "--no-ext-diff", "--no-textconv"}
+	staged, _ := call.Arguments["staged"].(bool)
+	if staged {
+		args = append(args, "--cached")
+	}
+	if stat, _ := call.Arguments["stat"].(bool); stat {
+		args = append(args, "--stat")
+	}
+	if ref, err := gitRefArg(call.Arguments, "ref"); err != nil {
+		return tool.Result{}, err
+	} else if ref != "" {
+		args = append(args, ref)
+	}
+	path, err := gitPathArg(call.Arguments)
+	if err != nil {
+		return tool.Result{}, err
+	}
+	args = append(args, "--")
+	if path != "" {
+		args = append(args, path)
+	}
+	out, err := runGit(ctx, args...)
+	if err != nil {
+		return tool.Result{}, err
+	}
+	out, truncated := truncateGitOutput(out)
+	if out == "" {
+		out = "no changes"
+	}
+	return tool.Result{ToolID: call.ToolID, Output: out, Data: map[string]any{"staged": staged, "truncated": truncated}}, nil
+}
+
+type GitLogTool struct{}
+
+func (GitLogTool) Definition() tool.Definition {
+	return tool.Definition{
+		ID:          "git/log",
+		Description: "List recent commits with hash, author, date, and subject",
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"ref":   map[string]any{"type": "string", "description": "Start from this commit or branch (default HEAD)"},
+				"path":  map[string]any{"type": "string", "description": "Only commits touching this path"},
+				"limit": map[string]any{"type": "integer", "description": "Maximum commits (default 20)"},
+			},
+		},
+	}
+}
+
+func (GitLogTool) Capabilities() tool.Capabilities {
+	return tool.Capabilities{Tags: []string{"git"}}
+}
+
+func (GitLogTool) Run(ctx context.Context, call tool.Call) (tool.Result, error) {
+	limit := 20
+	if n, ok := call.Arguments["limit"].(float64); ok && n > 0 {
+		limit = int(n)
+	}
+	args := []string{"log", "--max-count=" + strconv.Itoa(limit), "--format=%H%x1f%an%x1f%aI%x1f%s"}
+	if ref, err := gitRefArg(call.Arguments, "ref"); err != nil {
+		return tool.Result{}, err
+	} else if ref != "" {
+		args = append(args, ref)
+	}
+	path, err := gitPathArg(call.Arguments)
+	if err != nil {
+		return tool.Result{}, err
+	}
+	args = append(args, "--")
+	if path != "" {
+		args = append(args, path)
+	}
+	out, err := runGit(ctx, args...)
+	if err != nil {
+		return tool.Result{}, err
+	}
+	var commits []map[string]any
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Split(line, "\x1f")
+		if len(fields) != 4 {
+			continue
+		}
+		commits = append(commits, map[string]any{"hash": fields[0], "author": fields[1], "date": fields[2], "subject": fields[3]})
+		lines = append(lines, fmt.Sprintf("%s %s %s: %s", fields[0][:min(len(fields[0]), 12)], fields[2], fields[1], fields[3]))
+	}
+	output := strings.Join(lines, "\n")
+	if output == "" {
+		output = "no commits"
+	}
+	return tool.Result{ToolID: call.ToolID, Output: output, Data: map[string]any{"commits": commits}}, nil
+}
+
+type GitBranchTool struct{}
+
+func (GitBranchTool) Definition() tool.Definition {
+	return tool.Definition{
+		ID:          "git/branch",
+		Description: "List local branches, create a branch, or switch to one",
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"action": map[string]any{"type": "string", "enum": []string{"list", "create", "switch"}, "description": "Default list"},
+				"name":   map[string]any{"type": "string", "description": "Branch to create or switch to"},
+				"from":   map[string]any{"type": "string", "description": "Start point for create (default HEAD)"},
+			},
+		},
+	}
+}
+
+func (GitBranchTool) Capabilities() tool.Capabilities {
+	// Creating and switching branches change the repository, and switching
+	// rewrites the working tree.
+	return tool.Capabilities{Tags: []string{"git", tool.TagWrites}, Exclusive: []string{tool.ExclusiveWorkspace}}
+}
+
+func (GitBranchTool) Run(ctx context.Context, call tool.Call) (tool.Result, error) {
+	action, _ := call.Arguments["action"].(string)
+	switch action {
+	case "", "list":
+		out, err := runGit(ctx, "branch", "--list", "--format=%(HEAD)%(refname:short)")
+		if err != nil {
+			return tool.Result{}, err
+		}
+		var branches []string
+		current := ""
+		for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+			name := strings.TrimSpace(strings.TrimPrefix(line, "*"))
+			if name == "" {
+				continue
+			}
+			if strings.HasPrefix(line, "*") {
+				current = name
+			}
+			branches = append(branches, name)
+		}
+		return tool.Result{ToolID: call.ToolID, Output: strings.TrimRight(out, "\n"), Data: map[string]any{"current": current, "branches": branches}}, nil
+	case "create", "switch":
+		name, err := gitRefArg(call.Arguments, "name")
+		if err != nil {
+			return tool.Result{}, err
+		}
+		if name == "" {
+			return tool.Result{}, tool.Errorf(tool.ErrInvalidArgs, "action %s requires a branch name", action)
+		}
+		args := []string{"switch", name}
+		if action == "create" {
+			args = []string{"branch", name}
+			if from, err := gitRefArg(call.Arguments, "from"); err != nil {
+				return tool.Result{}, err
+			} else if from != "" {
+				args = append(args, from)
+			}
+		}
+		if _, err := runGit(ctx, args...); err != nil {
+			return tool.Result{}, err
+		}
+		verb := map[string]string{"create": "created", "switch": "switched to"}[action]
+		return tool.Result{ToolID: call.ToolID, Output: fmt.Sprintf("%s branch %s", verb, name), Data: map[string]any{"action": action, "branch": name}}, nil
+	default:
+		return tool.Result{}, tool.Errorf(tool.ErrInvalidArgs, "unknown action %q (expected list, create, or switch)", action)
+	}
+}
+
+// GitCommitTool records a commit. It declares that it needs confirmation,
+// so it goes through approval unless a policy rule allows it, and the
+// approval request carries the message and files to be committed.
+type GitCommitTool struct{}
+
+func (GitCommitTool) Definition() tool.Definition {
+	return tool.Definition{
+		ID:          "git/commit",
+		Description: "Commit changes with a message. Stages the listed files first; with no files, commits what is already staged. Requires confirmation.",
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"message": map[string]any{"type": "string", "description": "Commit message"},
+				"files":   map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "Paths to stage before committing"},
+				"all":     map[string]any{"type": "boolean", "description": "Stage every modified tracked file (git commit -a)"},
+			},
+			"required": []string{"message"},
+		},
+	}
+}
+
+func (GitCommitTool) Capabilities() tool.Capabilities {
+	confirm := true
+	return tool.Capabilities{NeedsConfirmation: &confirm, Tags: []string{"git", tool.TagWrites}, Exclusive: []string{tool.ExclusiveWorkspace}}
+}
+
+func (GitCommitTool) Run(ctx context.Context, call tool.Call) (tool.Result, error) {
+	message, err := argString(call.Arguments, "message")
+	if err != nil {
+		return tool.Result{}, err
+	}
+	var files []string
+	if raw, ok := call.Arguments["files"].([]any); ok {
+		for _, item := range raw {
+			path, ok := item.(string)
+			if !ok || path == "" {
+				return tool.Result{}, tool.Errorf(tool.ErrInvalidArgs, "argument %q must be a list of paths", "files")
+			}
+			files = append(files, path)
+		}
+	}
+	if len(files) > 0 {
+		if _, err := runGit(ctx, append([]string{"add", "--"}, files...)...); err != nil {
+			return tool.Result{}, err
+		}
+	}
+	args := []string{"commit", "--message", message}
+	if all, _ := call.Arguments["all"].(bool); all {
+		args = append(args, "--all")
+	}
+	if _, err := runGit(ctx, args...); err != nil {
+		return tool.Result{}, err
+	}
+	hash, err := runGit(ctx, "rev-parse", "HEAD")
+	if err != nil {
+		return tool.Result{}, err
+	}
+	hash = strings.TrimSpace(hash)
+	changed, _ := runGit(ctx, "show", "--no-ext-diff", "--no-textconv", "--name-only", "--format=", "HEAD")
+	committed := strings.Fields(changed)
+	return tool.Result{
+		ToolID: call.ToolID,
+		Output: fmt.Sprintf("committed %s (%d files)", hash[:min(len(hash), 12)], len(committed)),
+		Data:   map[string]any{"hash": hash, "files": committed},
+	}, nil
+}
+
+// gitOverrides switch off the settings through which a repository's own
+// config would run programs during a read: fsmonitor hooks and pagers.
+// External diff drivers and textconv filters are disabled per command.
+var gitOverrides = []string{"-c", "core.quotepath=off", "-c", "core.fsmonitor=false", "-c", "core.pager=cat"}
+
+// runGit runs git with args and returns stdout. A failure carries git's
+// stderr so the model sees why. A repository whose local config would
+// still run a program is refused, since a cloned or model-edited
+// .git/config is not trusted.
+func runGit(ctx context.Context, args ...string) (string, error) {
+	if key, err := gitConfigHazard(ctx); err != nil {
+		return "", err
+	} else if key != "" {
+		return "", tool.Errorf(tool.ErrPermissionDenied, "the repository config sets %s, which runs a program; review .git/config before using the git tools", key)
+	}
+	return execGit(ctx, args...)
+}
+
+// gitConfigHazard returns the first repository-local config key that names
+// a program git could run despite gitOverrides. Outside a repository it
+// returns "".
+func gitConfigHazard(ctx context.Context) (string, error) {
+	out, err := execGit(ctx, "config", "--show-scope", "--includes", "--name-only", "--list")
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", err
+		}
+		return "", nil
+	}
+	for _, line := range strings.Split(out, "\n") {
+		scope, key, ok := strings.Cut(line, "\t")
+		if !ok || (scope != "local" && scope != "worktree") {
+			continue
+		}
+		key = strings.ToLower(key)
+		section, _, _ := strings.Cut(key, ".")
+		name := key[strings.LastIndex(key, ".")+1:]
+		switch {
+		case section == "filter" && (name == "clean" || name == "smudge" || name == "process"),
+			section == "gpg" && name == "program",
+			section == "credential" && name == "helper",
+			key == "core.hookspath", key == "core.sshcommand", key == "core.editor", key == "core.askpass":
+			return key, nil
+		}
+	}
+	return "", nil
+}
+
+// execGit runs git with gitOverrides and without the system config.
+func execGit(ctx context.Context, args ...string) (string, error) {
+	cmd, err := procenv.Command(ctx, "git", append(slices.Clone(gitOverrides), args...)...)
+	if err != nil {
+		return "", err
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, "GIT_CONFIG_NOSYSTEM=1")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", tool.WrapError(tool.ErrTimeout, err)
+		}
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("git %s: %s", args[0], msg)
+	}
+	return stdout.String(), nil
+}
+
+// gitRefArg returns an optional ref-like argument, rejecting values git
+// would parse as an option.
+func gitRefArg(args map[string]any, key string) (string, error) {
+	v, _ := args[key].(string)
+	if strings.HasPrefix(v, "-") || strings.ContainsAny(v, " \t\n") {
+		return "", tool.Errorf(tool.ErrInvalidArgs, "argument %q is not a valid ref: %q", key, v)
+	}
+	return v, nil
+}
+
+// gitPathArg returns the optional path argument. Paths always follow "--",
+// so only an empty-after-trim path needs rejecting.
+func gitPathArg(args map[string]any) (string, error) {
+	v, _ := args["path"].(string)
+	if v != "" && strings.TrimSpace(v) == "" {
+		return "", tool.Errorf(tool.ErrInvalidArgs, "argument %q must not be blank", "path")
+	}
+	return v, nil
+}
+
+func truncateGitOutput(out string) (string, bool) {
+	if len(out) <= maxGitOutput {
+		return out, false
+	}
+	return out[:maxGitOutput] + "\n[diff truncated]", true
+}
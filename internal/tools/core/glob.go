@@ -2,7 +2,6 @@ package core
 
 import (
 	"context"
-	"fmt"
 	"io/fs"
 	"path/filepath"
 	"sort"
@@ -30,7 +29,11 @@ func (GlobTool) Definition() tool.Definition {
 	}
 }
 
-func (GlobTool) Run(_ context.Context, call tool.Call) (tool.Result, error) {
+func (GlobTool) Capabilities() tool.Capabilities {
+	return tool.Capabilities{Cacheable: true}
+}
+
+func (GlobTool) Run(ctx context.Context, call tool.Call) (tool.Result, error) {
 	pattern, err := argString(call.Arguments, "pattern")
 	if err != nil {
 		return tool.Result{}, err
@@ -39,6 +42,7 @@ func (GlobTool) Run(_ context.Context, call tool.Call) (tool.Result, error) {
 	if r, ok := call.Arguments["root"].(string); ok && r != "" {
 		root = r
 	}
+	root = workspacePath(ctx, root)
 	maxDepth := 4
 	if v, ok := call.Arguments["maxDepth"].(float64); ok && v > 0 {
 		maxDepth = int(v)
@@ -75,7 +79,7 @@ func (GlobTool) Run(_ context.Context, call tool.Call) (tool.Result, error) {
 		}
 		matched, err := matchGlobPattern(pattern, rel, d.IsDir())
 		if err != nil {
-			return fmt.Errorf("invalid glob pattern: %w", err)
+			return tool.Errorf(tool.ErrInvalidArgs, "invalid glob pattern: %w", err)
 		}
 		if broadPattern && rel == "." {
 			matched = false
@@ -2,17 +2,30 @@ package core
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
 	"regexp"
+	"regexp/syntax"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/bitop-dev/agent/pkg/tool"
 )
 
+// sniffLen is how much of a file is checked for NUL bytes to tell binary
+// files from text, as git does.
+const sniffLen = 8000
+
+// maxGrepLine is the longest line grep reads; longer lines end the search
+// of their file.
+const maxGrepLine = 1 << 20
+
 type GrepTool struct{}
 
 func (GrepTool) Definition() tool.Definition {
@@ -23,6 +36,7 @@ func (GrepTool) Definition() tool.Definition {
 			"type": "object",
 			"properties": map[string]any{
 				"pattern":     map[string]any{"type": "string"},
+				"literal":     map[string]any{"type": "boolean", "description": "Match pattern as a fixed string rather than a regular expression"},
 				"path":        map[string]any{"type": "string"},
 				"filePattern": map[string]any{"type": "string"},
 				"maxResults":  map[string]any{"type": "integer"},
@@ -32,19 +46,31 @@ func (GrepTool) Definition() tool.Definition {
 	}
 }
 
-func (GrepTool) Run(_ context.Context, call tool.Call) (tool.Result, error) {
+func (GrepTool) Capabilities() tool.Capabilities {
+	return tool.Capabilities{Cacheable: true}
+}
+
+type grepMatch struct {
+	File string
+	Line int
+	Text string
+}
+
+func (GrepTool) Run(ctx context.Context, call tool.Call) (tool.Result, error) {
 	pattern, err := argString(call.Arguments, "pattern")
 	if err != nil {
 		return tool.Result{}, err
 	}
-	re, err := regexp.Compile(pattern)
+	literal, _ := call.Arguments["literal"].(bool)
+	match, err := compileGrepPattern(pattern, literal)
 	if err != nil {
-		return tool.Result{}, fmt.Errorf("invalid regex pattern: %w", err)
+		return tool.Result{}, tool.Errorf(tool.ErrInvalidArgs, "invalid regex pattern: %w", err)
 	}
 	searchPath := "."
 	if p, ok := call.Arguments["path"].(string); ok && p != "" {
 		searchPath = p
 	}
+	searchPath = workspacePath(ctx, searchPath)
 	filePattern := "*"
 	if fp, ok := call.Arguments["filePattern"].(string); ok && fp != "" {
 		filePattern = fp
@@ -54,17 +80,42 @@ func (GrepTool) Run(_ context.Context, call tool.Call) (tool.Result, error) {
 	if mr, ok := call.Arguments["maxResults"].(float64); ok && mr > 0 {
 		maxResults = int(mr)
 	}
-	type match struct {
-		File string `json:"file"`
-		Line int    `json:"line"`
-		Text string `json:"text"`
+
+	// The walk hands files to a pool of workers and stops once they have
+	// found maxResults matches. Results are kept per file and joined in
+	// walk order, so the output is the same as a sequential search.
+	type job struct {
+		index int
+		path  string
 	}
-	var matches []match
-	var outputLines []string
-	err = filepath.WalkDir(searchPath, func(path string, d fs.DirEntry, walkErr error) error {
+	jobs := make(chan job)
+	var (
+		mu      sync.Mutex
+		perFile [][]grepMatch
+		found   atomic.Int64
+		wg      sync.WaitGroup
+	)
+	for range runtime.GOMAXPROCS(0) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				rel, _ := filepath.Rel(searchPath, j.path)
+				matches := grepFile(j.path, rel, match, maxResults)
+				found.Add(int64(len(matches)))
+				mu.Lock()
+				perFile[j.index] = matches
+				mu.Unlock()
+			}
+		}()
+	}
+	walkErr := filepath.WalkDir(searchPath, func(path string, d fs.DirEntry, walkErr error) error {
 		if walkErr != nil {
 			return nil
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if path != searchPath && matcher.shouldIgnore(path, d.IsDir()) {
 			if d.IsDir() {
 				return fs.SkipDir
@@ -74,7 +125,7 @@ func (GrepTool) Run(_ context.Context, call tool.Call) (tool.Result, error) {
 		if d.IsDir() {
 			return nil
 		}
-		if len(matches) >= maxResults {
+		if found.Load() >= int64(maxResults) {
 			return fs.SkipAll
 		}
 		if filePattern != "*" {
@@ -83,37 +134,112 @@ func (GrepTool) Run(_ context.Context, call tool.Call) (tool.Result, error) {
 				return nil
 			}
 		}
-		f, err := os.Open(path)
-		if err != nil {
-			return nil
-		}
-		defer f.Close()
-		rel, _ := filepath.Rel(searchPath, path)
-		scanner := bufio.NewScanner(f)
-		lineNum := 0
-		for scanner.Scan() {
-			lineNum++
-			if len(matches) >= maxResults {
+		mu.Lock()
+		index := len(perFile)
+		perFile = append(perFile, nil)
+		mu.Unlock()
+		jobs <- job{index: index, path: path}
+		return nil
+	})
+	close(jobs)
+	wg.Wait()
+	if walkErr != nil {
+		return tool.Result{}, walkErr
+	}
+
+	var data []any
+	var outputLines []string
+	for _, matches := range perFile {
+		for _, m := range matches {
+			if len(data) >= maxResults {
 				break
 			}
-			text := scanner.Text()
-			if re.MatchString(text) {
-				matches = append(matches, match{File: rel, Line: lineNum, Text: strings.TrimSpace(text)})
-				outputLines = append(outputLines, fmt.Sprintf("%s:%d: %s", rel, lineNum, strings.TrimSpace(text)))
-			}
+			data = append(data, map[string]any{"file": m.File, "line": m.Line, "text": m.Text})
+			outputLines = append(outputLines, fmt.Sprintf("%s:%d: %s", m.File, m.Line, m.Text))
 		}
-		return nil
-	})
-	if err != nil {
-		return tool.Result{}, err
 	}
-	data := make([]any, len(matches))
-	for i, m := range matches {
-		data[i] = map[string]any{"file": m.File, "line": m.Line, "text": m.Text}
+	if data == nil {
+		data = []any{}
 	}
 	return tool.Result{
 		ToolID: call.ToolID,
 		Output: strings.Join(outputLines, "\n"),
-		Data:   map[string]any{"matches": data, "count": len(matches)},
+		Data:   map[string]any{"matches": data, "count": len(data)},
 	}, nil
 }
+
+// grepFile returns up to limit matching lines of the file at path, which is
+// reported as rel. Binary files have no matches.
+func grepFile(path, rel string, match func([]byte) bool, limit int) []grepMatch {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	reader := bufio.NewReaderSize(f, 64*1024)
+	head, _ := reader.Peek(sniffLen)
+	if bytes.IndexByte(head, 0) >= 0 {
+		return nil
+	}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxGrepLine)
+	var matches []grepMatch
+	lineNum := 0
+	for scanner.Scan() && len(matches) < limit {
+		lineNum++
+		if line := scanner.Bytes(); match(line) {
+			matches = append(matches, grepMatch{File: rel, Line: lineNum, Text: strings.TrimSpace(string(line))})
+		}
+	}
+	return matches
+}
+
+// compileGrepPattern returns a line matcher for pattern. Fixed strings, and
+// regular expressions that are only a literal or an alternation of literals
+// ("foo", "foo|bar"), are matched with bytes.Contains instead of the regexp
+// engine.
+func compileGrepPattern(pattern string, literal bool) (func([]byte) bool, error) {
+	if literal {
+		return containsAny([][]byte{[]byte(pattern)}), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if literals, ok := regexLiterals(pattern); ok {
+		return containsAny(literals), nil
+	}
+	return re.Match, nil
+}
+
+func containsAny(literals [][]byte) func([]byte) bool {
+	return func(line []byte) bool {
+		for _, lit := range literals {
+			if bytes.Contains(line, lit) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// regexLiterals returns the strings a pattern matches when it is a plain
+// literal or an alternation of plain literals.
+func regexLiterals(pattern string) ([][]byte, bool) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, false
+	}
+	subs := []*syntax.Regexp{re}
+	if re.Op == syntax.OpAlternate {
+		subs = re.Sub
+	}
+	literals := make([][]byte, 0, len(subs))
+	for _, sub := range subs {
+		if sub.Op != syntax.OpLiteral || sub.Flags&syntax.FoldCase != 0 {
+			return nil, false
+		}
+		literals = append(literals, []byte(string(sub.Rune)))
+	}
+	return literals, true
+}
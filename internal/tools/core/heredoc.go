@@ -0,0 +1,95 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/bitop-dev/agent/internal/diff"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// heredocWrites match a line writing a heredoc to a file with cat, as
+// cat > path <<'EOF' or cat <<EOF >> path. The submatches name the
+// redirection, the path, whether tabs are stripped ("-"), and the
+// delimiter.
+var heredocWrites = []*regexp.Regexp{
+	regexp.MustCompile(`^\s*cat\s*(?P<redirect>>>?)\s*(?P<path>[^\s<>;&|]+)\s*<<(?P<strip>-?)\s*['"]?(?P<delim>\w+)['"]?\s*$`),
+	regexp.MustCompile(`^\s*cat\s*<<(?P<strip>-?)\s*['"]?(?P<delim>\w+)['"]?\s*(?P<redirect>>>?)\s*(?P<path>[^\s<>;&|]+)\s*$`),
+}
+
+// DryRun returns the diff of the files a command writes with cat
+// heredocs, each applied in order; other commands show no diff. The
+// heredocs are shown as written, before the shell expands variables in
+// those with an unquoted delimiter. A file the run's policy does not let
+// it read fails the dry run rather than showing its content.
+func (BashTool) DryRun(ctx context.Context, call tool.Call) (string, error) {
+	command, _ := call.Arguments["command"].(string)
+	var names []string
+	before, after := map[string]string{}, map[string]string{}
+	lines := strings.Split(command, "\n")
+	for i := 0; i < len(lines); i++ {
+		m, ok := matchHeredoc(lines[i])
+		if !ok {
+			continue
+		}
+		var body strings.Builder
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+			if m["strip"] == "-" {
+				line = strings.TrimLeft(line, "\t")
+			}
+			if line == m["delim"] {
+				break
+			}
+			body.WriteString(line + "\n")
+		}
+		name := strings.Trim(m["path"], `'"`)
+		if !slices.Contains(names, name) {
+			if cc, _ := tool.CallContextFrom(ctx); cc.CheckRead != nil {
+				if err := cc.CheckRead(ctx, name); err != nil {
+					return "", err
+				}
+			}
+			data, err := os.ReadFile(workspacePath(ctx, name))
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return "", err
+			}
+			names = append(names, name)
+			before[name], after[name] = string(data), string(data)
+		}
+		if m["redirect"] == ">" {
+			after[name] = ""
+		}
+		after[name] += body.String()
+	}
+	var out strings.Builder
+	for _, name := range names {
+		out.WriteString(diff.Unified(filepath.Clean(name), before[name], after[name]))
+	}
+	return out.String(), nil
+}
+
+// matchHeredoc returns the named submatches of a line starting a heredoc
+// write.
+func matchHeredoc(line string) (map[string]string, bool) {
+	for _, re := range heredocWrites {
+		match := re.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		m := map[string]string{}
+		for i, name := range re.SubexpNames() {
+			if name != "" {
+				m[name] = match[i]
+			}
+		}
+		return m, true
+	}
+	return nil, false
+}
@@ -7,9 +7,16 @@ import (
 	"strings"
 )
 
+// ignoreMatcher skips the default ignore set and the patterns of the
+// .gitignore files at the root and in the directories below it. Each nested
+// .gitignore applies to paths under its own directory.
 type ignoreMatcher struct {
 	root     string
 	patterns []string
+	// nested caches the patterns of each directory's .gitignore, nil when
+	// it has none. It is filled during a walk, which visits directories one
+	// at a time.
+	nested map[string][]string
 }
 
 func loadIgnoreMatcher(root string) ignoreMatcher {
@@ -25,32 +32,73 @@ func loadIgnoreMatcher(root string) ignoreMatcher {
 			"vendor",
 			"vendor/*",
 		},
+		nested: map[string][]string{},
 	}
-	gitignorePath := filepath.Join(root, ".gitignore")
-	file, err := os.Open(gitignorePath)
+	matcher.patterns = append(matcher.patterns, readGitignore(root)...)
+	return matcher
+}
+
+// readGitignore returns the patterns of dir's .gitignore. Negations are not
+// supported and are dropped.
+func readGitignore(dir string) []string {
+	file, err := os.Open(filepath.Join(dir, ".gitignore"))
 	if err != nil {
-		return matcher
+		return nil
 	}
 	defer file.Close()
+	var patterns []string
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
 			continue
 		}
-		matcher.patterns = append(matcher.patterns, line)
+		patterns = append(patterns, line)
 	}
-	return matcher
+	return patterns
 }
 
 func (m ignoreMatcher) shouldIgnore(path string, isDir bool) bool {
+	if matchIgnorePatterns(m.patterns, m.root, path, isDir) {
+		return true
+	}
+	if m.nested == nil {
+		return false
+	}
 	rel, err := filepath.Rel(m.root, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	// Check the .gitignore of every directory between the root and path.
+	dir := m.root
+	parts := strings.Split(filepath.ToSlash(filepath.Dir(rel)), "/")
+	for _, part := range parts {
+		if part == "." {
+			break
+		}
+		dir = filepath.Join(dir, part)
+		patterns, ok := m.nested[dir]
+		if !ok {
+			patterns = readGitignore(dir)
+			m.nested[dir] = patterns
+		}
+		if matchIgnorePatterns(patterns, dir, path, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchIgnorePatterns reports whether path matches one of patterns, taken
+// relative to dir.
+func matchIgnorePatterns(patterns []string, dir, path string, isDir bool) bool {
+	rel, err := filepath.Rel(dir, path)
 	if err != nil {
 		return false
 	}
 	rel = filepath.ToSlash(rel)
 	base := filepath.Base(path)
-	for _, pattern := range m.patterns {
+	for _, pattern := range patterns {
 		pattern = filepath.ToSlash(strings.TrimSpace(pattern))
 		if pattern == "" {
 			continue
@@ -71,3 +119,9 @@ func (m ignoreMatcher) shouldIgnore(path string, isDir bool) bool {
 	}
 	return false
 }
+
+// IgnoreFunc returns the ignore rules grep and glob apply under root, for
+// walks of the workspace outside this package.
+func IgnoreFunc(root string) func(path string, isDir bool) bool {
+	return loadIgnoreMatcher(root).shouldIgnore
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bitop-dev/agent/pkg/memory"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// MemoryReadTool reads project notes persisted across sessions.
+type MemoryReadTool struct {
+	Stores memory.Stores
+	CWD    string // the project of calls made outside a run
+}
+
+func (MemoryReadTool) Definition() tool.Definition {
+	return tool.Definition{
+		ID:          "core/memory_read",
+		Description: "Read project notes remembered across sessions. Omit key to list all notes.",
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"key": map[string]any{"type": "string"},
+			},
+		},
+	}
+}
+
+func (t MemoryReadTool) Run(ctx context.Context, call tool.Call) (tool.Result, error) {
+	store, err := projectMemory(ctx, t.Stores, t.CWD)
+	if err != nil {
+		return tool.Result{}, err
+	}
+	if key, _ := call.Arguments["key"].(string); key != "" {
+		note, ok, err := store.Get(ctx, key)
+		if err != nil {
+			return tool.Result{}, err
+		}
+		if !ok {
+			return tool.Result{ToolID: call.ToolID, Output: fmt.Sprintf("no note for %q", key), Data: map[string]any{"found": false}}, nil
+		}
+		return tool.Result{ToolID: call.ToolID, Output: note.Value, Data: map[string]any{"found": true, "key": note.Key}}, nil
+	}
+	notes, err := store.List(ctx)
+	if err != nil {
+		return tool.Result{}, err
+	}
+	if len(notes) == 0 {
+		return tool.Result{ToolID: call.ToolID, Output: "no notes", Data: map[string]any{"count": 0}}, nil
+	}
+	lines := make([]string, 0, len(notes))
+	for _, note := range notes {
+		lines = append(lines, note.Key+": "+note.Value)
+	}
+	return tool.Result{ToolID: call.ToolID, Output: strings.Join(lines, "\n"), Data: map[string]any{"count": len(notes)}}, nil
+}
+
+// MemoryWriteTool stores or deletes a project note.
+type MemoryWriteTool struct {
+	Stores memory.Stores
+	CWD    string // the project of calls made outside a run
+}
+
+func (MemoryWriteTool) Definition() tool.Definition {
+	return tool.Definition{
+		ID:          "core/memory_write",
+		Description: "Remember a project convention or fact for future sessions. An empty value deletes the note.",
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"key":   map[string]any{"type": "string"},
+				"value": map[string]any{"type": "string"},
+			},
+			"required": []string{"key"},
+		},
+	}
+}
+
+func (t MemoryWriteTool) Run(ctx context.Context, call tool.Call) (tool.Result, error) {
+	store, err := projectMemory(ctx, t.Stores, t.CWD)
+	if err != nil {
+		return tool.Result{}, err
+	}
+	key, err := argString(call.Arguments, "key")
+	if err != nil {
+		return tool.Result{}, err
+	}
+	value, _ := call.Arguments["value"].(string)
+	if strings.TrimSpace(value) == "" {
+		if err := store.Delete(ctx, key); err != nil {
+			return tool.Result{}, err
+		}
+		return tool.Result{ToolID: call.ToolID, Output: "forgot " + key, Data: map[string]any{"key": key, "deleted": true}}, nil
+	}
+	if err := store.Put(ctx, key, value); err != nil {
+		return tool.Result{}, err
+	}
+	return tool.Result{ToolID: call.ToolID, Output: "remembered " + key, Data: map[string]any{"key": key}}, nil
+}
+
+// projectMemory returns the store of the calling run's working directory,
+// or of cwd when the call has none.
+func projectMemory(ctx context.Context, stores memory.Stores, cwd string) (memory.Store, error) {
+	if stores == nil {
+		return nil, fmt.Errorf("memory store is not configured")
+	}
+	if cc, ok := tool.CallContextFrom(ctx); ok && cc.CWD != "" {
+		cwd = cc.CWD
+	}
+	return stores.For(cwd), nil
+}
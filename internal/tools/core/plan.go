@@ -0,0 +1,99 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bitop-dev/agent/pkg/session"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// PlanToolID is the ID of PlanTool. The runtime tracks the plan from its
+// calls.
+const PlanToolID = "core/plan"
+
+// PlanTool records the model's task list for the current work. Each call
+// replaces the whole list; the runtime keeps the latest one, reports it in
+// events and the session, and can restate it in the system prompt.
+type PlanTool struct{}
+
+func (PlanTool) Definition() tool.Definition {
+	return tool.Definition{
+		ID:          PlanToolID,
+		Description: "Record or update the task list for multi-step work. Send the whole list each time, marking each task pending, in_progress, or completed; keep one task in_progress at a time.",
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"items": map[string]any{
+					"type": "array",
+					"items": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"content": map[string]any{"type": "string"},
+							"status":  map[string]any{"type": "string", "enum": []string{session.PlanPending, session.PlanInProgress, session.PlanCompleted}},
+						},
+						"required": []string{"content", "status"},
+					},
+				},
+			},
+			"required": []string{"items"},
+		},
+	}
+}
+
+func (PlanTool) Run(_ context.Context, call tool.Call) (tool.Result, error) {
+	items, err := PlanItems(call.Arguments)
+	if err != nil {
+		return tool.Result{}, err
+	}
+	done := 0
+	for _, item := range items {
+		if item.Status == session.PlanCompleted {
+			done++
+		}
+	}
+	output := fmt.Sprintf("plan updated: %d of %d tasks completed\n%s", done, len(items), FormatPlan(items))
+	return tool.Result{ToolID: call.ToolID, Output: output, Data: map[string]any{"plan": items}}, nil
+}
+
+// PlanItems decodes the items argument of a core/plan call.
+func PlanItems(args map[string]any) ([]session.PlanItem, error) {
+	raw, ok := args["items"].([]any)
+	if !ok {
+		return nil, tool.Errorf(tool.ErrInvalidArgs, "argument %q must be a list of tasks", "items")
+	}
+	items := make([]session.PlanItem, 0, len(raw))
+	for i, entry := range raw {
+		fields, _ := entry.(map[string]any)
+		content, _ := fields["content"].(string)
+		status, _ := fields["status"].(string)
+		if strings.TrimSpace(content) == "" {
+			return nil, tool.Errorf(tool.ErrInvalidArgs, "task %d has no content", i+1)
+		}
+		switch status {
+		case session.PlanPending, session.PlanInProgress, session.PlanCompleted:
+		default:
+			return nil, tool.Errorf(tool.ErrInvalidArgs, "task %d: status must be pending, in_progress, or completed, not %q", i+1, status)
+		}
+		items = append(items, session.PlanItem{Content: strings.TrimSpace(content), Status: status})
+	}
+	return items, nil
+}
+
+// FormatPlan renders items as a checklist: "[x]" completed, "[>]" in
+// progress, "[ ]" pending.
+func FormatPlan(items []session.PlanItem) string {
+	lines := make([]string, len(items))
+	for i, item := range items {
+		mark := "[ ]"
+		switch item.Status {
+		case session.PlanCompleted:
+			mark = "[x]"
+		case session.PlanInProgress:
+			mark = "[>]"
+		}
+		lines[i] = mark + " " + item.Content
+	}
+	return strings.Join(lines, "\n")
+}
@@ -3,37 +3,80 @@ package core
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
+	"strings"
 
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
 	"github.com/bitop-dev/agent/pkg/tool"
 )
 
 type ReadTool struct{}
 
 func (ReadTool) Definition() tool.Definition {
-	return tool.Definition{ID: "core/read", Description: "Read a file from the local workspace"}
+	return tool.Definition{ID: "core/read", Description: "Read a file from the local workspace. Images (PNG, JPEG, GIF, WebP) are shown to models that can view them"}
 }
 
-func (ReadTool) Run(_ context.Context, call tool.Call) (tool.Result, error) {
+func (ReadTool) Capabilities() tool.Capabilities {
+	return tool.Capabilities{Cacheable: true}
+}
+
+func (ReadTool) Run(ctx context.Context, call tool.Call) (tool.Result, error) {
 	path, err := argString(call.Arguments, "path")
 	if err != nil {
 		return tool.Result{}, err
 	}
+	path = workspacePath(ctx, path)
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return tool.Result{}, err
 	}
+	if mediaType := http.DetectContentType(data); strings.HasPrefix(mediaType, "image/") {
+		return readImage(ctx, call, path, data)
+	}
 	return tool.Result{ToolID: call.ToolID, Output: string(data), Data: map[string]any{"path": path, "bytes": len(data)}}, nil
 }
 
+// readImage returns an image file for the model to look at, scaled down to
+// the provider limits, or an error when the model cannot view images.
+func readImage(ctx context.Context, call tool.Call, path string, data []byte) (tool.Result, error) {
+	model, _ := tool.ModelFrom(ctx)
+	if !model.Vision {
+		name := "the current model"
+		if model.Name != "" {
+			name = "model " + model.Name
+		}
+		return tool.Result{}, tool.Errorf(tool.ErrInvalidArgs, "%s is an image and %s cannot view images; switch to a vision model to read it", path, name)
+	}
+	prepared, err := pkgruntime.PrepareAttachments("", []pkgruntime.Attachment{{Name: path, Data: data}})
+	if err != nil {
+		return tool.Result{}, tool.WrapError(tool.ErrInvalidArgs, err)
+	}
+	img := prepared.Images[0]
+	return tool.Result{
+		ToolID: call.ToolID,
+		Output: fmt.Sprintf("image %s (%s, %d bytes), attached below", path, img.MediaType, len(img.Data)),
+		Data:   map[string]any{"path": path, "bytes": len(data), "mediaType": img.MediaType},
+		Images: []tool.Image{{MediaType: img.MediaType, Data: img.Data}},
+	}, nil
+}
+
+// workspacePath resolves a relative path argument against the working
+// directory of the run making the call, which may differ from the
+// process's; outside a run it is left relative to the process's.
+func workspacePath(ctx context.Context, path string) string {
+	cc, _ := tool.CallContextFrom(ctx)
+	return cc.Path(path)
+}
+
 func argString(args map[string]any, key string) (string, error) {
 	v, ok := args[key]
 	if !ok {
-		return "", fmt.Errorf("missing argument %q", key)
+		return "", tool.Errorf(tool.ErrInvalidArgs, "missing argument %q", key)
 	}
 	s, ok := v.(string)
 	if !ok || s == "" {
-		return "", fmt.Errorf("argument %q must be a non-empty string", key)
+		return "", tool.Errorf(tool.ErrInvalidArgs, "argument %q must be a non-empty string", key)
 	}
 	return s, nil
 }
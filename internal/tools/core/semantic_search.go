@@ -0,0 +1,135 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+	"github.com/bitop-dev/agent/pkg/retrieval"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// maxIndexedFiles bounds how many files a single index walk considers.
+const maxIndexedFiles = 5000
+
+// SemanticSearchTool indexes the workspace with an embedding model and
+// returns the chunks most relevant to a natural-language query. The index is
+// refreshed incrementally on every call, so only changed files are re-embedded.
+type SemanticSearchTool struct {
+	Embedder  provider.Embedder
+	Model     string
+	Root      string // directory to index
+	IndexPath string // gob file holding the persisted index
+
+	mu sync.Mutex
+}
+
+func (*SemanticSearchTool) Definition() tool.Definition {
+	return tool.Definition{
+		ID:          "core/semantic_search",
+		Description: "Search the workspace by meaning rather than exact text. Returns the most relevant file chunks with line ranges.",
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"query":   map[string]any{"type": "string"},
+				"topK":    map[string]any{"type": "integer"},
+				"reindex": map[string]any{"type": "boolean", "description": "Discard the index and re-embed every file"},
+			},
+			"required": []string{"query"},
+		},
+	}
+}
+
+func (t *SemanticSearchTool) Run(ctx context.Context, call tool.Call) (tool.Result, error) {
+	if t.Embedder == nil {
+		return tool.Result{}, fmt.Errorf("embeddings are not configured")
+	}
+	query, err := argString(call.Arguments, "query")
+	if err != nil {
+		return tool.Result{}, err
+	}
+	topK := 5
+	if v, ok := call.Arguments["topK"].(float64); ok && v > 0 {
+		topK = int(v)
+	}
+	reindex, _ := call.Arguments["reindex"].(bool)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	indexPath := t.IndexPath
+	if reindex {
+		indexPath = ""
+	}
+	ix, err := retrieval.Open(indexPath, t.Root, t.Embedder, t.Model)
+	if err != nil {
+		return tool.Result{}, fmt.Errorf("open index: %w", err)
+	}
+	ix.Path = t.IndexPath
+	files, err := indexableFiles(t.Root)
+	if err != nil {
+		return tool.Result{}, err
+	}
+	updated, err := ix.Update(ctx, files)
+	if err != nil {
+		return tool.Result{}, err
+	}
+	if updated > 0 && t.IndexPath != "" {
+		if err := ix.Save(); err != nil {
+			return tool.Result{}, fmt.Errorf("save index: %w", err)
+		}
+	}
+	results, err := ix.Search(ctx, query, topK)
+	if err != nil {
+		return tool.Result{}, err
+	}
+	var b strings.Builder
+	paths := make([]string, 0, len(results))
+	for _, r := range results {
+		fmt.Fprintf(&b, "%s:%d-%d (%.3f)\n%s\n\n", r.Path, r.StartLine, r.EndLine, r.Score, r.Text)
+		paths = append(paths, fmt.Sprintf("%s:%d-%d", r.Path, r.StartLine, r.EndLine))
+	}
+	output := strings.TrimSpace(b.String())
+	if output == "" {
+		output = "no results"
+	}
+	return tool.Result{
+		ToolID: call.ToolID,
+		Output: output,
+		Data:   map[string]any{"matches": paths, "count": len(results), "indexedChunks": ix.Len(), "reembeddedFiles": updated},
+	}, nil
+}
+
+// indexableFiles lists files under root, honoring .gitignore and the default
+// ignore set, as paths relative to root.
+func indexableFiles(root string) ([]string, error) {
+	matcher := loadIgnoreMatcher(root)
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if path != root && matcher.shouldIgnore(path, d.IsDir()) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		files = append(files, rel)
+		if len(files) >= maxIndexedFiles {
+			return fs.SkipAll
+		}
+		return nil
+	})
+	return files, err
+}
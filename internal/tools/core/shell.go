@@ -0,0 +1,229 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bitop-dev/agent/internal/tools/procenv"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// shellIdleTimeout closes a persistent shell that has run nothing for this
+// long.
+const shellIdleTimeout = 30 * time.Minute
+
+// errShellExited reports a command that ended its shell, as exit does.
+var errShellExited = errors.New("the shell exited; the next command starts a new one, without the previous directory and environment")
+
+// shells holds the persistent shells of core/bash, one per session.
+var shells = &shellPool{shells: map[string]*persistentShell{}}
+
+type shellPool struct {
+	mu     sync.Mutex
+	shells map[string]*persistentShell
+}
+
+// persistentShell is a long-lived /bin/sh reading commands from a pipe.
+// Each command is written to a script the shell sources, so a cd or an
+// export in it stays in effect, followed by a marker line carrying the
+// exit status that ends the command's output.
+type persistentShell struct {
+	mu     sync.Mutex // held while a command runs
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *os.File
+	marker []byte
+	idle   *time.Timer
+	closed sync.Once
+}
+
+// run runs command in the session's shell, starting one if needed, and
+// returns its combined output. A nonzero exit status is returned as an
+// error, as for a command run on its own.
+func (p *shellPool) run(ctx context.Context, sessionID, command string) (string, error) {
+	sh, err := p.get(ctx, sessionID)
+	if err != nil {
+		return "", err
+	}
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.idle.Stop()
+	output, status, err := sh.exec(ctx, command)
+	if err != nil {
+		p.drop(sessionID, sh)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = tool.WrapError(tool.ErrTimeout, err)
+		}
+		return output, err
+	}
+	sh.idle.Reset(shellIdleTimeout)
+	if status != 0 {
+		return output, fmt.Errorf("exit status %d", status)
+	}
+	return output, nil
+}
+
+// reset kills the session's shell, if it has one.
+func (p *shellPool) reset(sessionID string) {
+	p.mu.Lock()
+	sh := p.shells[sessionID]
+	p.mu.Unlock()
+	if sh != nil {
+		p.drop(sessionID, sh)
+	}
+}
+
+func (p *shellPool) get(ctx context.Context, sessionID string) (*persistentShell, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if sh := p.shells[sessionID]; sh != nil {
+		return sh, nil
+	}
+	sh, err := startShell(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sh.idle = time.AfterFunc(shellIdleTimeout, func() { p.drop(sessionID, sh) })
+	p.shells[sessionID] = sh
+	return sh, nil
+}
+
+// drop removes sh from the pool and kills it. A command it is running
+// ends with it.
+func (p *shellPool) drop(sessionID string, sh *persistentShell) {
+	p.mu.Lock()
+	if p.shells[sessionID] == sh {
+		delete(p.shells, sessionID)
+	}
+	p.mu.Unlock()
+	sh.idle.Stop()
+	sh.close()
+}
+
+// close kills the shell and closes its pipes. Closing its output also ends
+// a read left waiting by a child process that still holds the pipe.
+func (sh *persistentShell) close() {
+	sh.closed.Do(func() {
+		_ = sh.cmd.Process.Kill()
+		_ = sh.cmd.Wait()
+		sh.stdin.Close()
+		sh.stdout.Close()
+	})
+}
+
+// startShell starts a shell with the call's ExecOptions. It outlives the
+// call, so it is not canceled with ctx.
+func startShell(ctx context.Context) (*persistentShell, error) {
+	cmd, err := procenv.Command(context.WithoutCancel(ctx), "/bin/sh", "-l")
+	if err != nil {
+		return nil, err
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout, cmd.Stderr = w, w
+	if err := cmd.Start(); err != nil {
+		r.Close()
+		w.Close()
+		return nil, err
+	}
+	w.Close()
+	nonce := make([]byte, 8)
+	_, _ = rand.Read(nonce)
+	return &persistentShell{cmd: cmd, stdin: stdin, stdout: r, marker: []byte("\x1e" + hex.EncodeToString(nonce) + " ")}, nil
+}
+
+// exec runs one command and returns its output and exit status. An error
+// means the shell is no longer usable: it exited, or ctx ended the
+// command and the shell was killed with it.
+func (sh *persistentShell) exec(ctx context.Context, command string) (string, int, error) {
+	script, err := os.CreateTemp("", "agent-shell-*.sh")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(script.Name())
+	_, err = script.WriteString(command + "\n")
+	if closeErr := script.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	// The command reads no input: stdin carries the next commands.
+	line := fmt.Sprintf(". '%s' </dev/null; printf '%s%%d\\n' \"$?\"\n", script.Name(), sh.marker)
+	if _, err := io.WriteString(sh.stdin, line); err != nil {
+		return "", 0, errShellExited
+	}
+
+	type outcome struct {
+		output []byte
+		status int
+		err    error
+	}
+	done := make(chan outcome, 1)
+	update := tool.UpdatesFrom(ctx)
+	go func() {
+		output, status, err := sh.readOutput(update)
+		done <- outcome{output, status, err}
+	}()
+	select {
+	case o := <-done:
+		return string(o.output), o.status, o.err
+	case <-ctx.Done():
+	}
+	if grace, ok := tool.AbortGraceFrom(ctx); ok && grace > 0 {
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case o := <-done:
+			return string(o.output), o.status, o.err
+		case <-timer.C:
+		}
+	}
+	sh.close()
+	o := <-done
+	return string(o.output), 0, fmt.Errorf("%w; the shell was killed and the next command starts a new one", ctx.Err())
+}
+
+// readOutput reads up to the marker, passing output on as it arrives. The
+// last len(marker) bytes are held back until more arrive, since they may
+// be the start of the marker.
+func (sh *persistentShell) readOutput(update tool.UpdateFunc) ([]byte, int, error) {
+	var buf []byte
+	sent := 0
+	chunk := make([]byte, 32*1024)
+	for {
+		n, err := sh.stdout.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+		if i := bytes.Index(buf, sh.marker); i >= 0 {
+			rest := buf[i+len(sh.marker):]
+			if end := bytes.IndexByte(rest, '\n'); end >= 0 {
+				status, _ := strconv.Atoi(string(rest[:end]))
+				update(string(buf[sent:i]))
+				return buf[:i], status, nil
+			}
+		} else if safe := len(buf) - len(sh.marker); safe > sent {
+			update(string(buf[sent:safe]))
+			sent = safe
+		}
+		if err != nil {
+			update(string(buf[sent:]))
+			return buf, 0, errShellExited
+		}
+	}
+}
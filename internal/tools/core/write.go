@@ -2,9 +2,12 @@ package core
 
 import (
 	"context"
+	"errors"
+	"io/fs"
 	"os"
 	"path/filepath"
 
+	"github.com/bitop-dev/agent/internal/diff"
 	"github.com/bitop-dev/agent/pkg/tool"
 )
 
@@ -14,11 +17,16 @@ func (WriteTool) Definition() tool.Definition {
 	return tool.Definition{ID: "core/write", Description: "Write a file inside the local workspace"}
 }
 
-func (WriteTool) Run(_ context.Context, call tool.Call) (tool.Result, error) {
+func (WriteTool) Capabilities() tool.Capabilities {
+	return tool.Capabilities{Exclusive: []string{tool.ExclusiveWorkspace}}
+}
+
+func (WriteTool) Run(ctx context.Context, call tool.Call) (tool.Result, error) {
 	path, err := argString(call.Arguments, "path")
 	if err != nil {
 		return tool.Result{}, err
 	}
+	path = workspacePath(ctx, path)
 	content, err := argString(call.Arguments, "content")
 	if err != nil {
 		return tool.Result{}, err
@@ -26,8 +34,30 @@ func (WriteTool) Run(_ context.Context, call tool.Call) (tool.Result, error) {
 	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
 		return tool.Result{}, err
 	}
+	change := "modified"
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		change = "created"
+	}
 	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
 		return tool.Result{}, err
 	}
-	return tool.Result{ToolID: call.ToolID, Output: "wrote file", Data: map[string]any{"path": path}}, nil
+	return tool.Result{ToolID: call.ToolID, Output: "wrote file", Data: map[string]any{"path": path, "bytes_written": len(content)}, Artifacts: []tool.Artifact{{Path: path, Type: "file", Description: change}}}, nil
+}
+
+// DryRun returns the diff from the file as it is, or from nothing when it
+// does not exist, to the content the call would write.
+func (WriteTool) DryRun(ctx context.Context, call tool.Call) (string, error) {
+	name, err := argString(call.Arguments, "path")
+	if err != nil {
+		return "", err
+	}
+	content, err := argString(call.Arguments, "content")
+	if err != nil {
+		return "", err
+	}
+	old, err := os.ReadFile(workspacePath(ctx, name))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	return diff.Unified(filepath.Clean(name), string(old), content), nil
 }
@@ -0,0 +1,78 @@
+// Package procenv builds subprocess commands that honor the per-tool
+// execution settings (tool.ExecOptions) attached to a call's context.
+package procenv
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// Command is exec.CommandContext with the call's ExecOptions applied: the
+// working directory and environment are set on the command, and nice, ionice,
+// and umask are applied by wrapping argv. Wrappers whose binaries are not
+// installed are skipped.
+func Command(ctx context.Context, name string, args ...string) (*exec.Cmd, error) {
+	opts, ok := tool.ExecOptionsFrom(ctx)
+	if !ok {
+		return exec.CommandContext(ctx, name, args...), nil
+	}
+	argv, err := Wrap(opts, append([]string{name}, args...))
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Dir = opts.Dir
+	if len(opts.Env) > 0 {
+		cmd.Env = append(os.Environ(), opts.Env...)
+	}
+	return cmd, nil
+}
+
+// Wrap prefixes argv with umask, nice, and ionice wrappers as configured.
+func Wrap(opts tool.ExecOptions, argv []string) ([]string, error) {
+	if opts.IONice != "" {
+		prefix, err := ioniceArgs(opts.IONice)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := exec.LookPath("ionice"); err == nil {
+			argv = append(prefix, argv...)
+		}
+	}
+	if opts.Nice != 0 {
+		if _, err := exec.LookPath("nice"); err == nil {
+			argv = append([]string{"nice", "-n", strconv.Itoa(opts.Nice)}, argv...)
+		}
+	}
+	if opts.Umask != "" {
+		if _, err := strconv.ParseUint(opts.Umask, 8, 32); err != nil {
+			return nil, fmt.Errorf("invalid umask %q: must be octal", opts.Umask)
+		}
+		argv = append([]string{"/bin/sh", "-c", "umask " + opts.Umask + ` && exec "$@"`, "sh"}, argv...)
+	}
+	return argv, nil
+}
+
+func ioniceArgs(spec string) ([]string, error) {
+	class, level, hasLevel := strings.Cut(spec, ":")
+	classes := map[string]string{"realtime": "1", "best-effort": "2", "idle": "3"}
+	c, ok := classes[class]
+	if !ok {
+		return nil, fmt.Errorf("invalid ionice class %q (expected idle, best-effort, or realtime)", class)
+	}
+	args := []string{"ionice", "-c", c}
+	if hasLevel && class != "idle" {
+		n, err := strconv.Atoi(level)
+		if err != nil || n < 0 || n > 7 {
+			return nil, fmt.Errorf("invalid ionice level %q (expected 0-7)", level)
+		}
+		args = append(args, "-n", level)
+	}
+	return args, nil
+}
@@ -0,0 +1,214 @@
+// Package skill exposes a skill directory as tools: <skill>/load returns
+// the skill's full instructions on demand, <skill>/read returns one of its
+// resource files, and each helper script runs as <skill>/<script>.
+package skill
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bitop-dev/agent/internal/tools/procenv"
+	"github.com/bitop-dev/agent/pkg/skills"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// maxOutput bounds what a resource read or script run returns to the model.
+const maxOutput = 64 * 1024
+
+// Tools returns the tools s provides.
+func Tools(s skills.Skill) []tool.Tool {
+	out := []tool.Tool{LoadTool{Skill: s}}
+	if len(s.Resources) > 0 {
+		out = append(out, ReadTool{Skill: s})
+	}
+	for _, script := range s.Scripts {
+		out = append(out, ScriptTool{Skill: s, Script: script})
+	}
+	return out
+}
+
+// LoadTool returns the skill body, so only its one-line description costs
+// context until the model decides the skill is relevant.
+type LoadTool struct {
+	Skill skills.Skill
+}
+
+func (t LoadTool) Definition() tool.Definition {
+	return tool.Definition{
+		ID:          t.Skill.ToolID(skills.LoadTool),
+		Description: fmt.Sprintf("Load the %q skill before doing work it covers: %s", t.Skill.Name, t.Skill.Description),
+		Schema:      map[string]any{"type": "object", "properties": map[string]any{}},
+	}
+}
+
+func (t LoadTool) Capabilities() tool.Capabilities {
+	confirm := false
+	return tool.Capabilities{NeedsConfirmation: &confirm, Tags: []string{"skill"}}
+}
+
+func (t LoadTool) Run(_ context.Context, call tool.Call) (tool.Result, error) {
+	var b strings.Builder
+	b.WriteString(t.Skill.Body)
+	if len(t.Skill.Scripts) > 0 {
+		b.WriteString("\n\n## Scripts\nRun these with their tools; arguments are passed through as given.\n")
+		for _, script := range t.Skill.Scripts {
+			fmt.Fprintf(&b, "- %s (%s)", t.Skill.ToolID(script.Name), script.Path)
+			if script.Description != "" {
+				b.WriteString(": " + script.Description)
+			}
+			b.WriteString("\n")
+		}
+	}
+	if len(t.Skill.Resources) > 0 {
+		fmt.Fprintf(&b, "\n\n## Resources\nRead these with %s when the instructions refer to them.\n", t.Skill.ToolID(skills.ReadTool))
+		for _, path := range t.Skill.Resources {
+			b.WriteString("- " + path + "\n")
+		}
+	}
+	return tool.Result{ToolID: call.ToolID, Output: strings.TrimSpace(b.String()), Data: map[string]any{"skill": t.Skill.Name}}, nil
+}
+
+// ReadTool returns a resource file from the skill directory, which usually
+// lies outside the workspace core/read may access.
+type ReadTool struct {
+	Skill skills.Skill
+}
+
+func (t ReadTool) Definition() tool.Definition {
+	return tool.Definition{
+		ID:          t.Skill.ToolID(skills.ReadTool),
+		Description: fmt.Sprintf("Read a resource file of the %q skill, by the path its instructions list", t.Skill.Name),
+		Schema: map[string]any{
+			"type":       "object",
+			"properties": map[string]any{"path": map[string]any{"type": "string", "description": "path relative to the skill directory"}},
+			"required":   []string{"path"},
+		},
+	}
+}
+
+func (t ReadTool) Capabilities() tool.Capabilities {
+	confirm := false
+	return tool.Capabilities{NeedsConfirmation: &confirm, Tags: []string{"skill"}}
+}
+
+func (t ReadTool) Run(_ context.Context, call tool.Call) (tool.Result, error) {
+	rel, _ := call.Arguments["path"].(string)
+	path, err := skills.Resolve(t.Skill.Dir, rel)
+	if err != nil {
+		return tool.Result{}, tool.WrapError(tool.ErrInvalidArgs, err)
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return tool.Result{}, tool.Errorf(tool.ErrNotFound, "skill %s has no resource %q", t.Skill.Name, rel)
+	}
+	if err != nil {
+		return tool.Result{}, err
+	}
+	if !utf8.Valid(data) {
+		return tool.Result{ToolID: call.ToolID, Output: fmt.Sprintf("%s is a binary file (%d bytes); pass its path to a script instead", rel, len(data))}, nil
+	}
+	output, truncated := truncate(string(data))
+	return tool.Result{ToolID: call.ToolID, Output: output, Data: map[string]any{"path": path, "truncated": truncated}}, nil
+}
+
+// ScriptTool runs one of the skill's helper scripts with SKILL_DIR set to
+// the skill directory. Like other commands it runs in the agent's working
+// directory, or the tool's configured cwd.
+type ScriptTool struct {
+	Skill  skills.Skill
+	Script skills.Script
+}
+
+func (t ScriptTool) Definition() tool.Definition {
+	description := t.Script.Description
+	if description == "" {
+		description = fmt.Sprintf("Run %s from the %q skill", t.Script.Path, t.Skill.Name)
+	}
+	return tool.Definition{
+		ID:          t.Skill.ToolID(t.Script.Name),
+		Description: description,
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"args":  map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "command-line arguments"},
+				"stdin": map[string]any{"type": "string", "description": "optional standard input"},
+			},
+		},
+	}
+}
+
+// Capabilities asks for confirmation by default, since a script can do
+// anything the user can; scripts not declared read-only count as writes.
+func (t ScriptTool) Capabilities() tool.Capabilities {
+	tags := []string{"skill"}
+	if !t.Script.ReadOnly {
+		tags = append(tags, tool.TagWrites)
+	}
+	confirm := !t.Script.ReadOnly
+	return tool.Capabilities{NeedsConfirmation: &confirm, Tags: tags}
+}
+
+func (t ScriptTool) Run(ctx context.Context, call tool.Call) (tool.Result, error) {
+	args, err := stringList(call.Arguments["args"])
+	if err != nil {
+		return tool.Result{}, err
+	}
+	path, err := skills.Resolve(t.Skill.Dir, t.Script.Path)
+	if err != nil {
+		return tool.Result{}, err
+	}
+	cmd, err := procenv.Command(ctx, path, args...)
+	if err != nil {
+		return tool.Result{}, err
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, "SKILL_DIR="+t.Skill.Dir)
+	if stdin, _ := call.Arguments["stdin"].(string); stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	runErr := cmd.Run()
+	output, truncated := truncate(out.String())
+	result := tool.Result{ToolID: call.ToolID, Output: output, Data: map[string]any{"skill": t.Skill.Name, "script": t.Script.Path, "truncated": truncated}}
+	if runErr != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return result, tool.WrapError(tool.ErrTimeout, runErr)
+		}
+		return result, fmt.Errorf("%s: %w", t.Script.Path, runErr)
+	}
+	return result, nil
+}
+
+func stringList(v any) ([]string, error) {
+	if v == nil {
+		return nil, nil
+	}
+	items, ok := v.([]any)
+	if !ok {
+		return nil, tool.Errorf(tool.ErrInvalidArgs, "args must be an array of strings")
+	}
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		s, ok := item.(string)
+		if !ok {
+			return nil, tool.Errorf(tool.ErrInvalidArgs, "args must be an array of strings")
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+func truncate(s string) (string, bool) {
+	if len(s) <= maxOutput {
+		return s, false
+	}
+	return s[:maxOutput] + "\n[output truncated]", true
+}
@@ -0,0 +1,103 @@
+package skill
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bitop-dev/agent/pkg/skills"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+func writeSkill(t *testing.T, root string) string {
+	t.Helper()
+	dir := filepath.Join(root, "changelog")
+	files := map[string]string{
+		skills.FileName:        "---\nname: changelog\ndescription: Write release notes from git history\n---\n# Changelog\n\nGroup changes by type. Follow reference/style.md.\n",
+		"reference/style.md":   "Use past tense.",
+		"scripts/collect.sh":   "#!/bin/sh\necho \"$SKILL_DIR|$1|$(cat)\"\n",
+		"scripts/notes.txt":    "not executable, so a resource",
+		".git/ignored-by-scan": "x",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		mode := os.FileMode(0o644)
+		if strings.HasSuffix(name, ".sh") {
+			mode = 0o755
+		}
+		if err := os.WriteFile(path, []byte(content), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestSkillLoadsLazilyAndRunsScripts(t *testing.T) {
+	root := t.TempDir()
+	dir := writeSkill(t, root)
+	found, err := skills.Discover(filepath.Join(root, "missing"), root)
+	if err != nil || len(found) != 1 {
+		t.Fatalf("discover: %v %+v", err, found)
+	}
+	s := found[0]
+	if s.Name != "changelog" || !strings.HasPrefix(s.Body, "# Changelog") || len(s.Scripts) != 1 || s.Scripts[0].Name != "collect" {
+		t.Fatalf("unexpected skill: %+v", s)
+	}
+	if strings.Join(s.Resources, ",") != "reference/style.md,scripts/notes.txt" {
+		t.Fatalf("unexpected resources: %v", s.Resources)
+	}
+	if got := strings.Join(s.ToolIDs(), ","); got != "changelog/load,changelog/read,changelog/collect" {
+		t.Fatalf("unexpected tool IDs: %s", got)
+	}
+
+	tools := map[string]tool.Tool{}
+	for _, tl := range Tools(s) {
+		tools[tl.Definition().ID] = tl
+	}
+	ctx := context.Background()
+	loaded, err := tools["changelog/load"].Run(ctx, tool.Call{ToolID: "changelog/load"})
+	if err != nil || !strings.Contains(loaded.Output, "Group changes by type") || !strings.Contains(loaded.Output, "changelog/collect") || !strings.Contains(loaded.Output, "reference/style.md") {
+		t.Fatalf("unexpected load output: %v %q", err, loaded.Output)
+	}
+
+	read, err := tools["changelog/read"].Run(ctx, tool.Call{ToolID: "changelog/read", Arguments: map[string]any{"path": "reference/style.md"}})
+	if err != nil || read.Output != "Use past tense." {
+		t.Fatalf("unexpected read: %v %q", err, read.Output)
+	}
+	if _, err := tools["changelog/read"].Run(ctx, tool.Call{Arguments: map[string]any{"path": "../../etc/passwd"}}); tool.CodeOf(err) != tool.ErrInvalidArgs {
+		t.Fatalf("expected an escape to be rejected, got %v", err)
+	}
+
+	run, err := tools["changelog/collect"].Run(ctx, tool.Call{ToolID: "changelog/collect", Arguments: map[string]any{"args": []any{"v1.2"}, "stdin": "feat: x"}})
+	if err != nil || strings.TrimSpace(run.Output) != dir+"|v1.2|feat: x" {
+		t.Fatalf("unexpected script run: %v %q", err, run.Output)
+	}
+	if caps := tool.CapabilitiesOf(tools["changelog/collect"]); caps.NeedsConfirmation == nil || !*caps.NeedsConfirmation {
+		t.Fatalf("expected scripts to need confirmation by default, got %+v", caps)
+	}
+}
+
+func TestSkillRejectsInvalidDefinitions(t *testing.T) {
+	for name, content := range map[string]string{
+		"no front matter":  "# Just text",
+		"no description":   "---\nname: x\n---\nbody",
+		"bad name":         "---\nname: Bad Name\ndescription: d\n---\n",
+		"escaping script":  "---\nname: x\ndescription: d\nscripts:\n  - name: run\n    path: ../run.sh\n---\n",
+		"reserved script":  "---\nname: x\ndescription: d\nscripts:\n  - name: load\n    path: SKILL.md\n---\n",
+		"missing script":   "---\nname: x\ndescription: d\nscripts:\n  - name: run\n    path: run.sh\n---\n",
+		"unterminated yml": "---\nname: x\ndescription: d\n",
+	} {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, skills.FileName), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := skills.Load(dir); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package transcript
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+	"github.com/bitop-dev/agent/pkg/session"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// DatasetFormat selects the fine-tuning file layout.
+type DatasetFormat string
+
+const (
+	// FormatOpenAIJSONL is OpenAI's chat fine-tuning format: one
+	// {"messages": [...]} object per line.
+	FormatOpenAIJSONL DatasetFormat = "openai"
+	// FormatMessagesJSON is a JSON array of Anthropic-style conversations
+	// with typed content blocks, usable as a provider-neutral interchange.
+	FormatMessagesJSON DatasetFormat = "messages"
+)
+
+// DatasetOptions controls which sessions are exported and how they are cleaned.
+type DatasetOptions struct {
+	Format         DatasetFormat
+	System         string // optional system prompt prepended to every example
+	OnlySuccessful bool   // skip sessions that ended without a final answer or hit tool errors
+	StripThinking  bool   // drop <think>…</think> blocks from assistant text
+	Anonymize      bool   // redact emails, IPs, API keys, and home directories
+}
+
+// WriteDataset converts sessions to the requested format and writes them to
+// w. It returns the number of examples written.
+func WriteDataset(w io.Writer, sessions []session.Session, opts DatasetOptions) (int, error) {
+	var examples [][]provider.Message
+	for _, s := range sessions {
+		messages := FromEntries(s.Entries)
+		if len(messages) == 0 {
+			continue
+		}
+		if opts.OnlySuccessful && !Successful(messages) {
+			continue
+		}
+		examples = append(examples, cleanMessages(messages, opts))
+	}
+	switch opts.Format {
+	case FormatOpenAIJSONL, "":
+		enc := json.NewEncoder(w)
+		for _, messages := range examples {
+			if err := enc.Encode(map[string]any{"messages": openAIMessages(opts.System, messages)}); err != nil {
+				return 0, err
+			}
+		}
+	case FormatMessagesJSON:
+		out := make([]map[string]any, 0, len(examples))
+		for _, messages := range examples {
+			item := map[string]any{"messages": blockMessages(messages)}
+			if opts.System != "" {
+				item["system"] = opts.System
+			}
+			out = append(out, item)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(out); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("unknown dataset format %q (expected openai or messages)", opts.Format)
+	}
+	return len(examples), nil
+}
+
+// Successful reports whether a transcript ends in a non-empty assistant
+// answer and none of its tool calls failed.
+func Successful(messages []provider.Message) bool {
+	for _, msg := range messages {
+		if msg.Role == "tool" && strings.HasPrefix(msg.Content, "tool error") {
+			return false
+		}
+	}
+	last := messages[len(messages)-1]
+	return last.Role == "assistant" && len(last.ToolCalls) == 0 && strings.TrimSpace(last.Content) != ""
+}
+
+var (
+	thinkPattern  = regexp.MustCompile(`(?s)<think(?:ing)?>.*?</think(?:ing)?>\s*`)
+	emailPattern  = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	ipPattern     = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+	secretPattern = regexp.MustCompile(`\b(?:sk-[A-Za-z0-9_-]{16,}|ghp_[A-Za-z0-9]{20,}|AKIA[0-9A-Z]{16}|xox[abpr]-[A-Za-z0-9-]{10,})`)
+	homePattern   = regexp.MustCompile(`(/home/|/Users/)[^/\s"']+`)
+)
+
+func cleanMessages(messages []provider.Message, opts DatasetOptions) []provider.Message {
+	out := make([]provider.Message, 0, len(messages))
+	for _, msg := range messages {
+		if opts.StripThinking && msg.Role == "assistant" {
+			msg.Content = strings.TrimSpace(thinkPattern.ReplaceAllString(msg.Content, ""))
+		}
+		if opts.Anonymize {
+			msg.Content = anonymize(msg.Content)
+			msg.ToolCalls = anonymizeCalls(msg.ToolCalls)
+		}
+		out = append(out, msg)
+	}
+	return out
+}
+
+func anonymize(text string) string {
+	text = secretPattern.ReplaceAllString(text, "[REDACTED_KEY]")
+	text = emailPattern.ReplaceAllString(text, "[EMAIL]")
+	text = ipPattern.ReplaceAllString(text, "[IP]")
+	return homePattern.ReplaceAllString(text, "${1}user")
+}
+
+func anonymizeCalls(calls []tool.Call) []tool.Call {
+	if len(calls) == 0 {
+		return calls
+	}
+	out := make([]tool.Call, len(calls))
+	for i, call := range calls {
+		out[i] = call
+		if args, ok := anonymizeValue(call.Arguments).(map[string]any); ok {
+			out[i].Arguments = args
+		}
+	}
+	return out
+}
+
+func anonymizeValue(v any) any {
+	switch value := v.(type) {
+	case string:
+		return anonymize(value)
+	case map[string]any:
+		out := make(map[string]any, len(value))
+		for k, item := range value {
+			out[k] = anonymizeValue(item)
+		}
+		return out
+	case []any:
+		out := make([]any, len(value))
+		for i, item := range value {
+			out[i] = anonymizeValue(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// openAIMessages renders a transcript in OpenAI chat format. Tool names are
+// sanitized the same way the OpenAI provider does on the wire.
+func openAIMessages(system string, messages []provider.Message) []map[string]any {
+	out := make([]map[string]any, 0, len(messages)+1)
+	if system != "" {
+		out = append(out, map[string]any{"role": "system", "content": system})
+	}
+	for _, msg := range messages {
+		item := map[string]any{"role": msg.Role, "content": msg.Content}
+		switch msg.Role {
+		case "assistant":
+			if len(msg.ToolCalls) > 0 {
+				calls := make([]map[string]any, 0, len(msg.ToolCalls))
+				for _, call := range msg.ToolCalls {
+					args, _ := json.Marshal(call.Arguments)
+					calls = append(calls, map[string]any{
+						"id":   call.ID,
+						"type": "function",
+						"function": map[string]any{
+							"name":      wireToolName(call.ToolID),
+							"arguments": string(args),
+						},
+					})
+				}
+				item["tool_calls"] = calls
+			}
+		case "tool":
+			item["tool_call_id"] = msg.ToolCallID
+		}
+		out = append(out, item)
+	}
+	return out
+}
+
+// blockMessages renders a transcript with typed content blocks. Tool results
+// become tool_result blocks on a user turn, as the Messages API expects.
+func blockMessages(messages []provider.Message) []map[string]any {
+	out := make([]map[string]any, 0, len(messages))
+	for _, msg := range messages {
+		switch msg.Role {
+		case "user":
+			out = append(out, map[string]any{"role": "user", "content": []map[string]any{{"type": "text", "text": msg.Content}}})
+		case "assistant":
+			var blocks []map[string]any
+			if msg.Content != "" {
+				blocks = append(blocks, map[string]any{"type": "text", "text": msg.Content})
+			}
+			for _, call := range msg.ToolCalls {
+				blocks = append(blocks, map[string]any{"type": "tool_use", "id": call.ID, "name": wireToolName(call.ToolID), "input": call.Arguments})
+			}
+			out = append(out, map[string]any{"role": "assistant", "content": blocks})
+		case "tool":
+			block := map[string]any{"type": "tool_result", "tool_use_id": msg.ToolCallID, "content": msg.Content}
+			// Consecutive tool results share one user turn.
+			if n := len(out); n > 0 && out[n-1]["role"] == "user" && isToolResultTurn(out[n-1]) {
+				out[n-1]["content"] = append(out[n-1]["content"].([]map[string]any), block)
+				continue
+			}
+			out = append(out, map[string]any{"role": "user", "content": []map[string]any{block}})
+		}
+	}
+	return out
+}
+
+func isToolResultTurn(msg map[string]any) bool {
+	blocks, _ := msg["content"].([]map[string]any)
+	return len(blocks) > 0 && blocks[0]["type"] == "tool_result"
+}
+
+func wireToolName(id string) string {
+	var b strings.Builder
+	for _, r := range id {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || r == '-' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
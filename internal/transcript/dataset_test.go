@@ -0,0 +1,86 @@
+package transcript
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/bitop-dev/agent/pkg/session"
+)
+
+func testSession(id string, entries ...session.Entry) session.Session {
+	return session.Session{Metadata: session.Metadata{ID: id}, Entries: entries}
+}
+
+func message(role, content, metadata string) session.Entry {
+	return session.Entry{Kind: session.EntryMessage, Role: role, Content: content, Metadata: metadata}
+}
+
+func TestWriteDatasetOpenAIJSONL(t *testing.T) {
+	sessions := []session.Session{
+		testSession("ok",
+			message("user", "read /home/alice/notes.txt", ""),
+			message("assistant", "", `{"toolCalls":[{"ID":"call_1","ToolID":"core/read","Arguments":{"path":"/home/alice/notes.txt"}}]}`),
+			message("tool", "mail bob@example.com", `{"toolCallId":"call_1","toolName":"core/read"}`),
+			message("assistant", "<think>hmm</think>It mentions an email.", ""),
+		),
+		testSession("failed",
+			message("user", "hi", ""),
+			message("tool", "tool error: boom", `{"toolCallId":"call_2","toolName":"core/bash"}`),
+		),
+	}
+	var buf bytes.Buffer
+	count, err := WriteDataset(&buf, sessions, DatasetOptions{Format: FormatOpenAIJSONL, OnlySuccessful: true, StripThinking: true, Anonymize: true})
+	if err != nil {
+		t.Fatalf("write dataset: %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("expected 1 example, got %d", count)
+	}
+	line := strings.TrimSpace(buf.String())
+	var example struct {
+		Messages []map[string]any `json:"messages"`
+	}
+	if err := json.Unmarshal([]byte(line), &example); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(example.Messages) != 4 {
+		t.Fatalf("expected 4 messages, got %d", len(example.Messages))
+	}
+	for _, leaked := range []string{"alice", "bob@example.com", "<think>"} {
+		if strings.Contains(line, leaked) {
+			t.Fatalf("expected %q to be removed: %s", leaked, line)
+		}
+	}
+	if !strings.Contains(line, `"name":"core_read"`) {
+		t.Fatalf("expected sanitized tool name: %s", line)
+	}
+}
+
+func TestWriteDatasetMessagesGroupsToolResults(t *testing.T) {
+	sessions := []session.Session{testSession("s",
+		message("user", "go", ""),
+		message("assistant", "", `{"toolCalls":[{"ID":"a","ToolID":"core/read"},{"ID":"b","ToolID":"core/glob"}]}`),
+		message("tool", "one", `{"toolCallId":"a"}`),
+		message("tool", "two", `{"toolCallId":"b"}`),
+		message("assistant", "done", ""),
+	)}
+	var buf bytes.Buffer
+	if _, err := WriteDataset(&buf, sessions, DatasetOptions{Format: FormatMessagesJSON}); err != nil {
+		t.Fatalf("write dataset: %v", err)
+	}
+	var out []struct {
+		Messages []struct {
+			Role    string           `json:"role"`
+			Content []map[string]any `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	msgs := out[0].Messages
+	if len(msgs) != 4 || msgs[2].Role != "user" || len(msgs[2].Content) != 2 {
+		t.Fatalf("expected tool results grouped into one user turn, got %+v", msgs)
+	}
+}
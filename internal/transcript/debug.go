@@ -0,0 +1,56 @@
+package transcript
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+	"github.com/bitop-dev/agent/pkg/session"
+)
+
+// Step is one provider call of a recorded session: the context it was sent
+// and the messages it produced.
+type Step struct {
+	Context  session.TurnContext
+	At       time.Time
+	System   string             // the system prompt in effect, resolved from earlier steps
+	Messages []provider.Message // exactly what the provider received
+	Response []provider.Message // the assistant reply and the tool results that answered it
+}
+
+// Steps rebuilds every provider call of a session from its turn_context
+// entries. Sessions recorded before contexts were stored yield no steps.
+func Steps(entries []session.Entry) []Step {
+	var steps []Step
+	var history []provider.Message
+	systems := map[string]string{}
+	open := false // a user message ends the last step's response
+	for _, entry := range entries {
+		switch {
+		case entry.Kind == session.EntryMessage:
+			msgs := FromEntries([]session.Entry{entry})
+			history = append(history, msgs...)
+			if entry.Role == "user" {
+				open = false
+			}
+			if open {
+				last := &steps[len(steps)-1]
+				last.Response = append(last.Response, msgs...)
+			}
+		case entry.Kind == session.EntryEvent && entry.EventType == session.EventTurnContext:
+			var tc session.TurnContext
+			if err := json.Unmarshal([]byte(entry.Metadata), &tc); err != nil {
+				continue
+			}
+			if tc.System != "" {
+				systems[tc.SystemHash] = tc.System
+			}
+			step := Step{Context: tc, At: entry.CreatedAt, System: systems[tc.SystemHash]}
+			start := max(len(history)-tc.History, 0)
+			step.Messages = append(append(append(step.Messages, tc.Prefix...), history[start:]...), tc.Suffix...)
+			steps = append(steps, step)
+			open = true
+		}
+	}
+	return steps
+}
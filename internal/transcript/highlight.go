@@ -0,0 +1,93 @@
+package transcript
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+// A deliberately small, language-agnostic highlighter: exports must stay
+// self-contained, so there is no JavaScript and no third-party lexer.
+// It colors comments, strings, numbers, and common keywords.
+
+var (
+	fencePattern = regexp.MustCompile("(?s)```([A-Za-z0-9_+-]*)[^\\n]*\\n(.*?)```")
+
+	slashTokens = regexp.MustCompile("(?s)(//[^\\n]*|/\\*.*?\\*/)|(\"(?:[^\"\\\\\\n]|\\\\.)*\"|'(?:[^'\\\\\\n]|\\\\.)*'|`[^`]*`)|(\\b\\d+(?:\\.\\d+)?\\b)|(\\b[A-Za-z_][A-Za-z0-9_]*\\b)")
+	hashTokens  = regexp.MustCompile("(?s)(#[^\\n]*)|(\"(?:[^\"\\\\\\n]|\\\\.)*\"|'(?:[^'\\\\\\n]|\\\\.)*')|(\\b\\d+(?:\\.\\d+)?\\b)|(\\b[A-Za-z_][A-Za-z0-9_]*\\b)")
+
+	hashCommentLangs = map[string]bool{"python": true, "py": true, "sh": true, "bash": true, "shell": true, "zsh": true, "yaml": true, "yml": true, "ruby": true, "rb": true, "toml": true, "dockerfile": true, "makefile": true}
+
+	keywords = toSet(`break case catch class const continue def default defer do elif else enum except export extends false finally fn for from func function go if impl import in interface let match mod mut new nil none null package pass pub raise return select self static struct super switch then this throw true try type use var while with yield async await lambda fi done esac echo local`)
+)
+
+func toSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(words) {
+		set[w] = true
+	}
+	return set
+}
+
+// highlightCode returns escaped HTML for code with token spans.
+func highlightCode(lang, code string) template.HTML {
+	pattern := slashTokens
+	if hashCommentLangs[strings.ToLower(lang)] {
+		pattern = hashTokens
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range pattern.FindAllStringSubmatchIndex(code, -1) {
+		b.WriteString(html.EscapeString(code[last:m[0]]))
+		token := code[m[0]:m[1]]
+		class := ""
+		switch {
+		case m[2] >= 0:
+			class = "tok-comment"
+		case m[4] >= 0:
+			class = "tok-string"
+		case m[6] >= 0:
+			class = "tok-number"
+		case m[8] >= 0 && keywords[token]:
+			class = "tok-keyword"
+		}
+		if class == "" {
+			b.WriteString(html.EscapeString(token))
+		} else {
+			b.WriteString(`<span class="` + class + `">` + html.EscapeString(token) + `</span>`)
+		}
+		last = m[1]
+	}
+	b.WriteString(html.EscapeString(code[last:]))
+	return template.HTML(b.String())
+}
+
+// renderText escapes prose and turns fenced code blocks into highlighted
+// <pre> elements.
+func renderText(text string) template.HTML {
+	var b strings.Builder
+	last := 0
+	for _, m := range fencePattern.FindAllStringSubmatchIndex(text, -1) {
+		writeProse(&b, text[last:m[0]])
+		lang := text[m[2]:m[3]]
+		b.WriteString(`<pre class="code"`)
+		if lang != "" {
+			b.WriteString(` data-lang="` + html.EscapeString(lang) + `"`)
+		}
+		b.WriteString(`><code>`)
+		b.WriteString(string(highlightCode(lang, text[m[4]:m[5]])))
+		b.WriteString(`</code></pre>`)
+		last = m[1]
+	}
+	writeProse(&b, text[last:])
+	return template.HTML(b.String())
+}
+
+func writeProse(b *strings.Builder, text string) {
+	text = strings.Trim(text, "\n")
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	b.WriteString(`<pre>` + html.EscapeString(text) + `</pre>`)
+}
@@ -0,0 +1,302 @@
+package transcript
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bitop-dev/agent/internal/models"
+	"github.com/bitop-dev/agent/pkg/session"
+)
+
+// HTML export themes.
+const (
+	ThemeLight = "light"
+	ThemeDark  = "dark"
+	ThemeAuto  = "auto" // follows the viewer's prefers-color-scheme
+)
+
+// collapseToolLines is the tool-result length above which results are folded.
+const collapseToolLines = 12
+
+// HTMLOptions controls ExportHTML.
+type HTMLOptions struct {
+	Title             string // defaults to the session ID
+	Theme             string // light (default), dark, or auto
+	ExpandThinking    bool   // thinking blocks are folded unless set
+	ExpandToolResults bool   // long tool results are folded unless set
+}
+
+// TurnCost is the usage annotation for one assistant turn.
+type TurnCost struct {
+	Turn         int
+	Model        string
+	InputTokens  int
+	OutputTokens int
+	Reasoning    int // part of OutputTokens spent on hidden reasoning
+	CacheRead    int // part of InputTokens read from the prompt cache
+	CostUSD      float64
+	Priced       bool // false when the model has no known pricing
+	DurationMs   int64
+}
+
+// Costs totals per-turn usage recorded on a session's assistant messages.
+func Costs(entries []session.Entry) (turns []TurnCost, total TurnCost) {
+	total.Priced = true
+	for _, entry := range entries {
+		if entry.Kind != session.EntryMessage || entry.Role != "assistant" {
+			continue
+		}
+		usage := DecodeMetadata(entry.Metadata).Usage
+		if usage == nil {
+			continue
+		}
+		cost, priced := models.CostWithCache(usage.Provider, usage.Model, usage.InputTokens, usage.OutputTokens, usage.CacheReadTokens, usage.CacheWriteTokens)
+		turn := TurnCost{
+			Turn:         len(turns) + 1,
+			Model:        usage.Model,
+			InputTokens:  usage.InputTokens,
+			OutputTokens: usage.OutputTokens,
+			Reasoning:    usage.ReasoningTokens,
+			CacheRead:    usage.CacheReadTokens,
+			CostUSD:      cost,
+			Priced:       priced,
+			DurationMs:   usage.DurationMs,
+		}
+		turns = append(turns, turn)
+		total.InputTokens += turn.InputTokens
+		total.OutputTokens += turn.OutputTokens
+		total.Reasoning += turn.Reasoning
+		total.CacheRead += turn.CacheRead
+		total.CostUSD += turn.CostUSD
+		total.DurationMs += turn.DurationMs
+		total.Priced = total.Priced && priced
+	}
+	total.Turn = len(turns)
+	return turns, total
+}
+
+type diffLine struct {
+	Kind string // hdr, del, add
+	Text string
+}
+
+type htmlToolCall struct {
+	Name string
+	Args string
+	Diff []diffLine // set for core/edit calls
+}
+
+type htmlMessage struct {
+	Role      string
+	Time      string
+	ToolName  string
+	Thinking  []string
+	Body      template.HTML
+	Collapse  bool   // fold the body behind a summary line
+	Summary   string // first line shown when folded
+	ToolCalls []htmlToolCall
+	Usage     *TurnCost
+}
+
+type htmlView struct {
+	Title          string
+	Theme          string
+	Meta           session.Metadata
+	Created        string
+	Items          []htmlMessage
+	Turns          []TurnCost
+	Total          TurnCost
+	Artifacts      []session.Artifact
+	ExpandThinking bool
+}
+
+// ExportHTML renders a session as a self-contained HTML page: a cost/usage
+// header, per-turn token, cost, and latency annotations, foldable thinking
+// and tool-result sections, highlighted code fences, and inline diffs for
+// edit-tool calls.
+func ExportHTML(w io.Writer, s session.Session, opts HTMLOptions) error {
+	turns, total := Costs(s.Entries)
+	view := htmlView{
+		Title:          opts.Title,
+		Theme:          opts.Theme,
+		Meta:           s.Metadata,
+		Created:        s.Metadata.CreatedAt.Format(time.RFC3339),
+		Turns:          turns,
+		Total:          total,
+		Artifacts:      session.Artifacts(s.Entries),
+		ExpandThinking: opts.ExpandThinking,
+	}
+	switch view.Theme {
+	case ThemeLight, ThemeDark, ThemeAuto:
+	case "":
+		view.Theme = ThemeLight
+	default:
+		return fmt.Errorf("unknown theme %q (expected light, dark, or auto)", opts.Theme)
+	}
+	if view.Title == "" {
+		view.Title = s.Metadata.Title
+	}
+	if view.Title == "" {
+		view.Title = "Session " + s.Metadata.ID
+	}
+	turnIndex := 0
+	for _, entry := range s.Entries {
+		switch entry.Kind {
+		case session.EntryMessage:
+			meta := DecodeMetadata(entry.Metadata)
+			item := htmlMessage{Role: entry.Role, Time: entry.CreatedAt.Format("15:04:05"), ToolName: meta.ToolName}
+			content := entry.Content
+			if entry.Role == "assistant" {
+				for _, m := range thinkPattern.FindAllString(content, -1) {
+					item.Thinking = append(item.Thinking, strings.TrimSpace(stripThinkTags(m)))
+				}
+				content = strings.TrimSpace(thinkPattern.ReplaceAllString(content, ""))
+			}
+			item.Body = renderText(content)
+			if entry.Role == "tool" && !opts.ExpandToolResults {
+				if lines := strings.Count(strings.TrimRight(content, "\n"), "\n") + 1; lines > collapseToolLines {
+					item.Collapse = true
+					first, _, _ := strings.Cut(strings.TrimSpace(content), "\n")
+					item.Summary = fmt.Sprintf("%s (%d lines)", compact(first, 80), lines)
+				}
+			}
+			for _, call := range meta.ToolCalls {
+				args, _ := json.Marshal(call.Arguments)
+				item.ToolCalls = append(item.ToolCalls, htmlToolCall{Name: call.ToolID, Args: string(args), Diff: editDiff(call.ToolID, call.Arguments)})
+			}
+			if entry.Role == "assistant" && meta.Usage != nil && turnIndex < len(turns) {
+				item.Usage = &turns[turnIndex]
+				turnIndex++
+			}
+			view.Items = append(view.Items, item)
+		case session.EntryCompaction:
+			view.Items = append(view.Items, htmlMessage{Role: "compaction", Time: entry.CreatedAt.Format("15:04:05"), Body: renderText(entry.Content)})
+		case session.EntryHandoff:
+			view.Items = append(view.Items, htmlMessage{Role: "handoff", Time: entry.CreatedAt.Format("15:04:05"), Body: renderText(entry.Content)})
+		}
+	}
+	return htmlTemplate.Execute(w, view)
+}
+
+func stripThinkTags(block string) string {
+	block = strings.TrimSpace(block)
+	for _, tag := range []string{"<thinking>", "</thinking>", "<think>", "</think>"} {
+		block = strings.ReplaceAll(block, tag, "")
+	}
+	return block
+}
+
+// editDiff renders a core/edit call's old/new text as removed and added lines.
+func editDiff(toolID string, args map[string]any) []diffLine {
+	if toolID != "core/edit" {
+		return nil
+	}
+	oldText, ok1 := args["old"].(string)
+	newText, ok2 := args["new"].(string)
+	if !ok1 || !ok2 {
+		return nil
+	}
+	path, _ := args["path"].(string)
+	lines := []diffLine{{Kind: "hdr", Text: "@@ " + path}}
+	for _, l := range strings.Split(oldText, "\n") {
+		lines = append(lines, diffLine{Kind: "del", Text: "- " + l})
+	}
+	for _, l := range strings.Split(newText, "\n") {
+		lines = append(lines, diffLine{Kind: "add", Text: "+ " + l})
+	}
+	return lines
+}
+
+func compact(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	cut := max - 1
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "…"
+}
+
+var htmlTemplate = template.Must(template.New("session").Funcs(template.FuncMap{
+	"usd": func(t TurnCost) string {
+		if !t.Priced {
+			return "n/a"
+		}
+		return fmt.Sprintf("$%.4f", t.CostUSD)
+	},
+	"secs": func(ms int64) string { return fmt.Sprintf("%.1fs", float64(ms)/1000) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en" data-theme="{{.Theme}}">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+:root, [data-theme="light"] { --fg: #1f2328; --muted: #57606a; --bg: #ffffff; --panel: #f6f8fa; --border: #d0d7de; --user: #0969da; --assistant: #1a7f37; --tool: #9a6700; --compaction: #8250df; --add: #dafbe1; --del: #ffebe9; --kw: #cf222e; --str: #0a3069; --num: #0550ae; --com: #6e7781; }
+[data-theme="dark"] { --fg: #e6edf3; --muted: #8d96a0; --bg: #0d1117; --panel: #161b22; --border: #30363d; --user: #4493f8; --assistant: #3fb950; --tool: #d29922; --compaction: #ab7df8; --add: #12261e; --del: #25171c; --kw: #ff7b72; --str: #a5d6ff; --num: #79c0ff; --com: #8b949e; }
+@media (prefers-color-scheme: dark) { [data-theme="auto"] { --fg: #e6edf3; --muted: #8d96a0; --bg: #0d1117; --panel: #161b22; --border: #30363d; --user: #4493f8; --assistant: #3fb950; --tool: #d29922; --compaction: #ab7df8; --add: #12261e; --del: #25171c; --kw: #ff7b72; --str: #a5d6ff; --num: #79c0ff; --com: #8b949e; } }
+body { font-family: system-ui, sans-serif; max-width: 960px; margin: 2rem auto; padding: 0 1rem; color: var(--fg); background: var(--bg); }
+.summary { display: flex; gap: 1.5rem; flex-wrap: wrap; padding: .75rem 1rem; background: var(--panel); border: 1px solid var(--border); border-radius: 6px; }
+.summary b { display: block; font-size: 1.1rem; }
+.msg { border-left: 4px solid var(--border); margin: 1rem 0; padding: .5rem 1rem; }
+.msg.user { border-color: var(--user); }
+.msg.assistant { border-color: var(--assistant); }
+.msg.tool { border-color: var(--tool); background: var(--panel); }
+.msg.compaction, .msg.handoff { border-color: var(--compaction); font-style: italic; }
+.role { font-weight: 600; text-transform: uppercase; font-size: .75rem; color: var(--muted); }
+.usage { font-size: .75rem; color: var(--muted); margin-top: .5rem; }
+details > summary { cursor: pointer; color: var(--muted); font-size: .85rem; }
+.thinking { color: var(--muted); font-style: italic; }
+pre { white-space: pre-wrap; word-break: break-word; margin: .25rem 0; }
+pre.code { background: var(--panel); border: 1px solid var(--border); border-radius: 6px; padding: .5rem .75rem; white-space: pre; overflow-x: auto; }
+.tok-keyword { color: var(--kw); } .tok-string { color: var(--str); } .tok-number { color: var(--num); } .tok-comment { color: var(--com); font-style: italic; }
+.diff { font-family: ui-monospace, monospace; font-size: .85rem; border: 1px solid var(--border); border-radius: 6px; margin: .25rem 0; }
+.diff div { white-space: pre-wrap; padding: 0 .5rem; }
+.diff .hdr { color: var(--muted); background: var(--panel); }
+.diff .add { background: var(--add); } .diff .del { background: var(--del); }
+table { border-collapse: collapse; margin: 1rem 0; }
+th, td { border: 1px solid var(--border); padding: .25rem .75rem; text-align: right; }
+th:first-child, td:first-child, td.model { text-align: left; }
+tfoot td { font-weight: 600; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Meta.Summary}}<p>{{.Meta.Summary}}</p>
+{{end}}<p>Profile <code>{{.Meta.Profile}}</code> · {{.Meta.CWD}} · {{.Created}}</p>
+{{if .Turns}}<div class="summary">
+<div>Cost<b>{{usd .Total}}</b></div>
+<div>Turns<b>{{.Total.Turn}}</b></div>
+<div>Input tokens<b>{{.Total.InputTokens}}</b></div>
+<div>Output tokens<b>{{.Total.OutputTokens}}</b></div>
+<div>Model time<b>{{secs .Total.DurationMs}}</b></div>
+</div>
+<details><summary>Per-turn usage</summary>
+<table>
+<thead><tr><th>Turn</th><th>Model</th><th>Input</th><th>Output</th><th>Cost</th><th>Duration</th></tr></thead>
+<tbody>{{range .Turns}}
+<tr><td>{{.Turn}}</td><td class="model">{{.Model}}</td><td>{{.InputTokens}}</td><td>{{.OutputTokens}}</td><td>{{usd .}}</td><td>{{secs .DurationMs}}</td></tr>{{end}}
+</tbody>
+<tfoot><tr><td>Total</td><td></td><td>{{.Total.InputTokens}}</td><td>{{.Total.OutputTokens}}</td><td>{{usd .Total}}</td><td>{{secs .Total.DurationMs}}</td></tr></tfoot>
+</table>
+</details>{{end}}
+{{if .Artifacts}}<h2>Artifacts</h2>
+<ul>{{range .Artifacts}}
+<li><code>{{.Path}}</code>{{with .Description}} · {{.}}{{end}}{{with .Type}} · {{.}}{{end}} · turn {{.Turn}}{{with .ToolID}} · {{.}}{{end}}</li>{{end}}
+</ul>
+{{end}}<h2>Transcript</h2>
+{{range .Items}}<div class="msg {{.Role}}">
+<div class="role">{{.Role}}{{if .ToolName}} · {{.ToolName}}{{end}} · {{.Time}}</div>
+{{range .Thinking}}<details class="thinking"{{if $.ExpandThinking}} open{{end}}><summary>thinking</summary><pre>{{.}}</pre></details>
+{{end}}{{if .Collapse}}<details><summary>{{.Summary}}</summary>{{.Body}}</details>{{else}}{{.Body}}{{end}}
+{{range .ToolCalls}}{{if .Diff}}<div class="diff">{{range .Diff}}<div class="{{.Kind}}">{{.Text}}</div>{{end}}</div>{{else}}<pre>→ {{.Name}} {{.Args}}</pre>{{end}}
+{{end}}{{with .Usage}}<div class="usage">turn {{.Turn}} · {{.Model}} · {{.InputTokens}} in{{if .CacheRead}} ({{.CacheRead}} cached){{end}} / {{.OutputTokens}} out · {{usd .}} · {{secs .DurationMs}}</div>{{end}}
+</div>
+{{end}}</body>
+</html>
+`))
@@ -0,0 +1,75 @@
+package transcript
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestExportHTMLAnnotatesTurns(t *testing.T) {
+	s := testSession("s1",
+		message("user", "hello <world>", ""),
+		message("assistant", "", `{"toolCalls":[{"ID":"c1","ToolID":"core/read","Arguments":{"path":"a.go"}}],"usage":{"model":"gpt-4o","inputTokens":1000000,"outputTokens":0,"durationMs":1500}}`),
+		message("tool", "package a", `{"toolCallId":"c1","toolName":"core/read"}`),
+		message("assistant", "done", `{"usage":{"model":"unknown-model","inputTokens":10,"outputTokens":5,"durationMs":500}}`),
+	)
+	turns, total := Costs(s.Entries)
+	if len(turns) != 2 || total.InputTokens != 1000010 || total.Priced {
+		t.Fatalf("unexpected totals: %+v %+v", turns, total)
+	}
+	if !turns[0].Priced || turns[0].CostUSD != 2.5 {
+		t.Fatalf("unexpected first turn cost: %+v", turns[0])
+	}
+
+	var buf bytes.Buffer
+	if err := ExportHTML(&buf, s, HTMLOptions{}); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	page := buf.String()
+	for _, want := range []string{"hello &lt;world&gt;", "$2.5000", "turn 2 · unknown-model", "n/a", "core/read"} {
+		if !strings.Contains(page, want) {
+			t.Fatalf("expected %q in export:\n%s", want, page)
+		}
+	}
+}
+
+func TestExportHTMLThemesFoldingAndDiffs(t *testing.T) {
+	s := testSession("s2",
+		message("assistant", "<thinking>plan the edit</thinking>Fixing it:\n```go\nfunc f() int { return 42 } // answer\n```", `{"toolCalls":[{"ID":"c1","ToolID":"core/edit","Arguments":{"path":"a.go","old":"x := 1","new":"x := 2"}}]}`),
+		message("tool", strings.Repeat("line\n", 20), `{"toolCallId":"c1","toolName":"core/edit"}`),
+	)
+	var buf bytes.Buffer
+	if err := ExportHTML(&buf, s, HTMLOptions{Theme: ThemeDark}); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	page := buf.String()
+	for _, want := range []string{
+		`data-theme="dark"`,
+		`<details class="thinking"><summary>thinking</summary><pre>plan the edit</pre>`,
+		`<span class="tok-keyword">func</span>`,
+		`<span class="tok-number">42</span>`,
+		`<span class="tok-comment">// answer</span>`,
+		`<div class="del">- x := 1</div>`,
+		`<div class="add">&#43; x := 2</div>`,
+		`<summary>line (20 lines)</summary>`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Fatalf("expected %q in export:\n%s", want, page)
+		}
+	}
+	if strings.Contains(page, "&lt;thinking&gt;") {
+		t.Fatalf("thinking tags leaked into the body:\n%s", page)
+	}
+
+	if err := ExportHTML(&buf, s, HTMLOptions{Theme: "sepia"}); err == nil {
+		t.Fatal("expected unknown theme to fail")
+	}
+}
+
+func TestCompactKeepsRunesWhole(t *testing.T) {
+	got := compact(strings.Repeat("é", 50), 80)
+	if !utf8.ValidString(got) || !strings.HasSuffix(got, "…") {
+		t.Fatalf("expected whole runes and an ellipsis, got %q", got)
+	}
+}
@@ -0,0 +1,652 @@
+package transcript
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// ImportFormat names a conversation format Import reads.
+type ImportFormat string
+
+const (
+	// ImportClaudeCode is a Claude Code session file, one JSON record per
+	// line (~/.claude/projects/<project>/<session>.jsonl).
+	ImportClaudeCode ImportFormat = "claude-code"
+	// ImportCodex is a Codex CLI rollout file, one JSON record per line
+	// (~/.codex/sessions/.../rollout-*.jsonl).
+	ImportCodex ImportFormat = "codex"
+	// ImportChatGPT is conversations.json from a ChatGPT data export, or
+	// one conversation from it.
+	ImportChatGPT ImportFormat = "chatgpt"
+	// ImportMarkdown is a transcript that starts each message with a role
+	// heading ("## User", "## Assistant"), a bold role ("**User:**"), or a
+	// role prefix ("User: ...").
+	ImportMarkdown ImportFormat = "markdown"
+)
+
+// Conversation is one conversation read by Import.
+type Conversation struct {
+	Title     string
+	CWD       string    // the directory the conversation ran in, if recorded
+	CreatedAt time.Time // zero if not recorded
+	Messages  []provider.Message
+}
+
+// noResult answers a tool call whose result the source did not record.
+const noResult = "[no result was recorded for this tool call]"
+
+// DetectFormat guesses the format of data from its first record.
+func DetectFormat(data []byte) (ImportFormat, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return "", errors.New("nothing to import")
+	}
+	switch trimmed[0] {
+	case '[':
+		return ImportChatGPT, nil
+	case '{':
+		line, _, _ := bytes.Cut(trimmed, []byte("\n"))
+		var probe map[string]json.RawMessage
+		if err := json.Unmarshal(line, &probe); err != nil {
+			if json.Valid(trimmed) {
+				return ImportChatGPT, nil
+			}
+			return "", fmt.Errorf("unrecognized JSON: %w", err)
+		}
+		if _, ok := probe["mapping"]; ok {
+			return ImportChatGPT, nil
+		}
+		if _, ok := probe["payload"]; ok {
+			return ImportCodex, nil
+		}
+		var kind string
+		_ = json.Unmarshal(probe["type"], &kind)
+		switch {
+		case codexItem(kind), kind == "session_meta":
+			return ImportCodex, nil
+		case probe["sessionId"] != nil, probe["parentUuid"] != nil, probe["message"] != nil, kind == "summary":
+			return ImportClaudeCode, nil
+		case probe["instructions"] != nil:
+			return ImportCodex, nil // the metadata line of older rollouts
+		}
+		return "", errors.New("unrecognized JSON records (expected a Claude Code or Codex CLI session, or a ChatGPT export)")
+	}
+	return ImportMarkdown, nil
+}
+
+// Import reads the conversations in data. Messages map onto user,
+// assistant, and tool messages; system prompts, reasoning, and records of
+// other kinds are left out. Tool calls keep the source tool's name, and
+// each is answered, with a placeholder if the source has no result, so the
+// history passes Validate.
+func Import(data []byte, format ImportFormat) ([]Conversation, error) {
+	var (
+		conversations []Conversation
+		err           error
+	)
+	switch format {
+	case ImportClaudeCode:
+		conversations, err = importClaudeCode(data)
+	case ImportCodex:
+		conversations, err = importCodex(data)
+	case ImportChatGPT:
+		conversations, err = importChatGPT(data)
+	case ImportMarkdown:
+		conversations = importMarkdown(data)
+	default:
+		return nil, fmt.Errorf("unknown import format %q (expected claude-code, codex, chatgpt, or markdown)", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []Conversation
+	for _, c := range conversations {
+		if c.Messages = balance(c.Messages); len(c.Messages) > 0 {
+			out = append(out, c)
+		}
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no messages found to import")
+	}
+	return out, nil
+}
+
+// builder accumulates messages, merging consecutive user or assistant
+// messages so roles alternate as providers expect.
+type builder struct {
+	messages []provider.Message
+}
+
+func (b *builder) add(msg provider.Message) {
+	if msg.Content == "" && len(msg.ToolCalls) == 0 && len(msg.Images) == 0 && msg.Role != "tool" {
+		return
+	}
+	if n := len(b.messages); n > 0 && msg.Role != "tool" && b.messages[n-1].Role == msg.Role {
+		last := &b.messages[n-1]
+		last.Content = joinText(last.Content, msg.Content)
+		last.ToolCalls = append(last.ToolCalls, msg.ToolCalls...)
+		last.Images = append(last.Images, msg.Images...)
+		return
+	}
+	b.messages = append(b.messages, msg)
+}
+
+func joinText(a, b string) string {
+	if a == "" || b == "" {
+		return a + b
+	}
+	return a + "\n\n" + b
+}
+
+// balance makes a history Validate accepts: tool calls without an ID get
+// one, calls left unanswered get a placeholder result, and results that
+// answer no pending call are dropped.
+func balance(messages []provider.Message) []provider.Message {
+	var out []provider.Message
+	var pending []tool.Call
+	seen := map[string]bool{}
+	answer := func() {
+		for _, call := range pending {
+			out = append(out, provider.Message{Role: "tool", Content: noResult, ToolCallID: call.ID, ToolName: call.ToolID})
+		}
+		pending = nil
+	}
+	for _, msg := range messages {
+		switch msg.Role {
+		case "tool":
+			i := -1
+			for j, call := range pending {
+				if call.ID == msg.ToolCallID {
+					i = j
+				}
+			}
+			if i < 0 {
+				continue
+			}
+			msg.ToolName = pending[i].ToolID
+			pending = append(pending[:i], pending[i+1:]...)
+			out = append(out, msg)
+		case "user", "assistant":
+			answer()
+			for i := range msg.ToolCalls {
+				call := &msg.ToolCalls[i]
+				if call.ID == "" || seen[call.ID] {
+					call.ID = "call_" + strconv.Itoa(len(seen)+1)
+				}
+				if call.ToolID == "" {
+					call.ToolID = "unknown"
+				}
+				seen[call.ID] = true
+				pending = append(pending, *call)
+			}
+			out = append(out, msg)
+		}
+	}
+	answer()
+	return out
+}
+
+// contentBlock is a content block of an Anthropic-style message, as Claude
+// Code records them.
+type contentBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text"`
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Input     map[string]any  `json:"input"`
+	ToolUseID string          `json:"tool_use_id"`
+	Content   json.RawMessage `json:"content"`
+	Source    struct {
+		Type      string `json:"type"`
+		MediaType string `json:"media_type"`
+		Data      string `json:"data"`
+	} `json:"source"`
+}
+
+// decodeBlocks reads content that is either a string or a list of blocks.
+func decodeBlocks(raw json.RawMessage) []contentBlock {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return []contentBlock{{Type: "text", Text: text}}
+	}
+	var blocks []contentBlock
+	_ = json.Unmarshal(raw, &blocks)
+	return blocks
+}
+
+// blockImage returns the image of a base64 image block.
+func blockImage(block contentBlock) (provider.Image, bool) {
+	if block.Type != "image" || block.Source.Type != "base64" {
+		return provider.Image{}, false
+	}
+	data, err := base64.StdEncoding.DecodeString(block.Source.Data)
+	if err != nil {
+		return provider.Image{}, false
+	}
+	return provider.Image{MediaType: block.Source.MediaType, Data: data}, true
+}
+
+type claudeCodeRecord struct {
+	Type        string `json:"type"`
+	CWD         string `json:"cwd"`
+	Timestamp   string `json:"timestamp"`
+	IsSidechain bool   `json:"isSidechain"`
+	IsMeta      bool   `json:"isMeta"`
+	Summary     string `json:"summary"`
+	Message     struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	} `json:"message"`
+}
+
+// importClaudeCode reads a Claude Code session. Sub-agent (sidechain) and
+// meta records are skipped; an assistant reply recorded one block per line
+// is merged back into one message.
+func importClaudeCode(data []byte) ([]Conversation, error) {
+	var c Conversation
+	var b builder
+	err := eachLine(data, func(line []byte) error {
+		var rec claudeCodeRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		if rec.Type == "summary" && c.Title == "" {
+			c.Title = rec.Summary
+		}
+		if (rec.Type != "user" && rec.Type != "assistant") || rec.IsSidechain || rec.IsMeta {
+			return nil
+		}
+		if c.CWD == "" {
+			c.CWD = rec.CWD
+		}
+		if at, err := time.Parse(time.RFC3339Nano, rec.Timestamp); err == nil && c.CreatedAt.IsZero() {
+			c.CreatedAt = at
+		}
+		msg := provider.Message{Role: rec.Message.Role}
+		for _, block := range decodeBlocks(rec.Message.Content) {
+			switch block.Type {
+			case "text":
+				msg.Content = joinText(msg.Content, block.Text)
+			case "image":
+				if img, ok := blockImage(block); ok {
+					msg.Images = append(msg.Images, img)
+				}
+			case "tool_use":
+				msg.ToolCalls = append(msg.ToolCalls, tool.Call{ID: block.ID, ToolID: block.Name, Arguments: block.Input})
+			case "tool_result":
+				result := provider.Message{Role: "tool", ToolCallID: block.ToolUseID}
+				for _, part := range decodeBlocks(block.Content) {
+					if img, ok := blockImage(part); ok {
+						result.Images = append(result.Images, img)
+					} else {
+						result.Content = joinText(result.Content, part.Text)
+					}
+				}
+				b.add(result)
+			}
+		}
+		if msg.Role == "user" || msg.Role == "assistant" {
+			b.add(msg)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read Claude Code session: %w", err)
+	}
+	c.Messages = b.messages
+	return []Conversation{c}, nil
+}
+
+// codexItem reports whether a record type is a Responses API item that
+// Codex CLI records in its rollouts.
+func codexItem(kind string) bool {
+	switch kind {
+	case "message", "function_call", "function_call_output", "custom_tool_call", "custom_tool_call_output", "local_shell_call", "reasoning":
+		return true
+	}
+	return false
+}
+
+type codexRecord struct {
+	Type      string          `json:"type"`
+	Timestamp string          `json:"timestamp"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+type codexItemRecord struct {
+	Type    string `json:"type"`
+	Role    string `json:"role"`
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	Name      string          `json:"name"`
+	Arguments string          `json:"arguments"`
+	Input     string          `json:"input"`
+	CallID    string          `json:"call_id"`
+	Output    json.RawMessage `json:"output"`
+	Action    struct {
+		Command []string `json:"command"`
+	} `json:"action"`
+	// Session metadata.
+	CWD       string `json:"cwd"`
+	Timestamp string `json:"timestamp"`
+}
+
+// codexContext marks the text Codex CLI adds to the first user message:
+// the environment and the project's instructions, not what the user typed.
+var codexContext = []string{"<environment_context>", "<user_instructions>"}
+
+// importCodex reads a Codex CLI rollout, either as session_meta and
+// response_item records or, in older rollouts, as bare items.
+func importCodex(data []byte) ([]Conversation, error) {
+	var c Conversation
+	var b builder
+	err := eachLine(data, func(line []byte) error {
+		var rec codexRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		var item codexItemRecord
+		switch {
+		case rec.Type == "response_item" || rec.Type == "session_meta":
+			if err := json.Unmarshal(rec.Payload, &item); err != nil {
+				return err
+			}
+		case codexItem(rec.Type):
+			if err := json.Unmarshal(line, &item); err != nil {
+				return err
+			}
+		default:
+			if rec.Type == "" {
+				// The metadata line of older rollouts.
+				_ = json.Unmarshal(line, &item)
+				rec.Type = "session_meta"
+			} else {
+				return nil
+			}
+		}
+		if rec.Type == "session_meta" {
+			if c.CWD == "" {
+				c.CWD = item.CWD
+			}
+			if at, err := time.Parse(time.RFC3339Nano, item.Timestamp); err == nil && c.CreatedAt.IsZero() {
+				c.CreatedAt = at
+			}
+			return nil
+		}
+		switch item.Type {
+		case "message":
+			if item.Role != "user" && item.Role != "assistant" {
+				return nil
+			}
+			msg := provider.Message{Role: item.Role}
+			for _, part := range item.Content {
+				if !hasAnyPrefix(strings.TrimSpace(part.Text), codexContext) {
+					msg.Content = joinText(msg.Content, part.Text)
+				}
+			}
+			b.add(msg)
+		case "function_call", "custom_tool_call", "local_shell_call":
+			call := tool.Call{ID: item.CallID, ToolID: item.Name, Arguments: map[string]any{}}
+			switch item.Type {
+			case "function_call":
+				_ = json.Unmarshal([]byte(item.Arguments), &call.Arguments)
+			case "custom_tool_call":
+				call.Arguments["input"] = item.Input
+			case "local_shell_call":
+				call.ToolID = "local_shell"
+				call.Arguments["command"] = item.Action.Command
+			}
+			b.add(provider.Message{Role: "assistant", ToolCalls: []tool.Call{call}})
+		case "function_call_output", "custom_tool_call_output":
+			b.add(provider.Message{Role: "tool", ToolCallID: item.CallID, Content: codexOutput(item.Output)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read Codex CLI rollout: %w", err)
+	}
+	c.Messages = b.messages
+	return []Conversation{c}, nil
+}
+
+// codexOutput returns a tool output recorded as a string, or as an object
+// with an output field.
+func codexOutput(raw json.RawMessage) string {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text
+	}
+	var wrapped struct {
+		Output string `json:"output"`
+	}
+	if err := json.Unmarshal(raw, &wrapped); err == nil && wrapped.Output != "" {
+		return wrapped.Output
+	}
+	return string(raw)
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+type chatGPTConversation struct {
+	Title       string  `json:"title"`
+	CreateTime  float64 `json:"create_time"`
+	CurrentNode string  `json:"current_node"`
+	Mapping     map[string]struct {
+		Parent   string   `json:"parent"`
+		Children []string `json:"children"`
+		Message  *struct {
+			Author struct {
+				Role string `json:"role"`
+				Name string `json:"name"`
+			} `json:"author"`
+			Content struct {
+				ContentType string            `json:"content_type"`
+				Parts       []json.RawMessage `json:"parts"`
+				Text        string            `json:"text"`
+			} `json:"content"`
+			Metadata struct {
+				Hidden bool `json:"is_visually_hidden_from_conversation"`
+			} `json:"metadata"`
+		} `json:"message"`
+	} `json:"mapping"`
+}
+
+// importChatGPT reads conversations from a ChatGPT export. Each follows the
+// branch that was current when it was exported. ChatGPT's own tools
+// (browsing, code interpreter) record no calls, so their output is kept as
+// assistant text.
+func importChatGPT(data []byte) ([]Conversation, error) {
+	var exported []chatGPTConversation
+	if err := json.Unmarshal(data, &exported); err != nil {
+		var one chatGPTConversation
+		if err := json.Unmarshal(data, &one); err != nil {
+			return nil, fmt.Errorf("read ChatGPT export: %w", err)
+		}
+		exported = []chatGPTConversation{one}
+	}
+	var out []Conversation
+	for _, conv := range exported {
+		c := Conversation{Title: conv.Title}
+		if conv.CreateTime > 0 {
+			c.CreatedAt = time.Unix(0, int64(conv.CreateTime*float64(time.Second))).UTC()
+		}
+		var b builder
+		for _, id := range chatGPTBranch(conv) {
+			msg := conv.Mapping[id].Message
+			if msg == nil || msg.Metadata.Hidden {
+				continue
+			}
+			var text string
+			switch msg.Content.ContentType {
+			case "text", "multimodal_text":
+				for _, part := range msg.Content.Parts {
+					var s string
+					if json.Unmarshal(part, &s) == nil {
+						text = joinText(text, s)
+					}
+				}
+			case "code", "execution_output":
+				text = msg.Content.Text
+			}
+			switch msg.Author.Role {
+			case "user":
+				b.add(provider.Message{Role: "user", Content: text})
+			case "assistant":
+				b.add(provider.Message{Role: "assistant", Content: text})
+			case "tool":
+				if text != "" {
+					b.add(provider.Message{Role: "assistant", Content: "[" + msg.Author.Name + " output]\n" + text})
+				}
+			}
+		}
+		c.Messages = b.messages
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// chatGPTBranch returns the node IDs from the root to the conversation's
+// current node, or to its latest leaf if the export names none.
+func chatGPTBranch(conv chatGPTConversation) []string {
+	node := conv.CurrentNode
+	if _, ok := conv.Mapping[node]; !ok {
+		node = ""
+		for id, n := range conv.Mapping {
+			if n.Parent == "" {
+				node = id
+				break
+			}
+		}
+		for node != "" && len(conv.Mapping[node].Children) > 0 {
+			children := conv.Mapping[node].Children
+			node = children[len(children)-1]
+		}
+	}
+	var branch []string
+	for node != "" && len(branch) <= len(conv.Mapping) {
+		if _, ok := conv.Mapping[node]; !ok {
+			break
+		}
+		branch = append(branch, node)
+		node = conv.Mapping[node].Parent
+	}
+	for i, j := 0, len(branch)-1; i < j; i, j = i+1, j-1 {
+		branch[i], branch[j] = branch[j], branch[i]
+	}
+	return branch
+}
+
+var (
+	markdownRoles   = `(user|human|you|assistant|ai|model|claude|chatgpt|system)`
+	markdownHeading = regexp.MustCompile(`(?i)^#{1,6}\s+` + markdownRoles + `\s*:?\s*$`)
+	markdownBold    = regexp.MustCompile(`(?i)^\*\*` + markdownRoles + `\s*:?\s*\*\*\s*:?\s*(.*)$`)
+	// A plain prefix is only recognized for the unambiguous role names, so
+	// that lines such as "Model: gpt-4" stay text.
+	markdownPrefix = regexp.MustCompile(`(?i)^(user|human|assistant|system):\s*(.*)$`)
+	markdownTitle  = regexp.MustCompile(`^#\s+(.+)$`)
+)
+
+// importMarkdown reads a markdown or plain text transcript. Text before
+// the first role marker is skipped, except a "# Title" line; system
+// sections are skipped too. Markers inside fenced code blocks are text.
+func importMarkdown(data []byte) []Conversation {
+	var c Conversation
+	var b builder
+	role := ""
+	var text []string
+	flush := func() {
+		if role == "user" || role == "assistant" {
+			b.add(provider.Message{Role: role, Content: strings.TrimSpace(strings.Join(text, "\n"))})
+		}
+		text = nil
+	}
+	fenced := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			fenced = !fenced
+		}
+		if !fenced {
+			if name, rest, ok := markdownRole(line); ok {
+				flush()
+				role = name
+				if rest != "" {
+					text = append(text, rest)
+				}
+				continue
+			}
+			if role == "" {
+				if m := markdownTitle.FindStringSubmatch(line); m != nil && c.Title == "" {
+					c.Title = strings.TrimSpace(m[1])
+				}
+				continue
+			}
+		}
+		text = append(text, line)
+	}
+	flush()
+	c.Messages = b.messages
+	return []Conversation{c}
+}
+
+// markdownRole returns the role a line starts a message as, and any text
+// that follows the marker on the line.
+func markdownRole(line string) (role, rest string, ok bool) {
+	var m []string
+	if m = markdownHeading.FindStringSubmatch(line); m != nil {
+		m = append(m, "")
+	} else if m = markdownBold.FindStringSubmatch(line); m == nil {
+		if m = markdownPrefix.FindStringSubmatch(line); m == nil {
+			return "", "", false
+		}
+	}
+	switch strings.ToLower(m[1]) {
+	case "user", "human", "you":
+		role = "user"
+	case "system":
+		role = "system"
+	default:
+		role = "assistant"
+	}
+	return role, strings.TrimSpace(m[2]), true
+}
+
+// eachLine calls fn with each non-blank line of a JSON Lines file.
+func eachLine(data []byte, fn func(line []byte) error) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	return scanner.Err()
+}
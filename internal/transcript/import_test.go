@@ -0,0 +1,132 @@
+package transcript
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+)
+
+// roles summarizes messages as "role:content" lines, with tool calls as
+// "assistant->tool(id)" and results as "tool(id):content".
+func roles(messages []provider.Message) string {
+	var lines []string
+	for _, msg := range messages {
+		line := msg.Role
+		for _, call := range msg.ToolCalls {
+			line += fmt.Sprintf("->%s(%s)", call.ToolID, call.ID)
+		}
+		if msg.ToolCallID != "" {
+			line += "(" + msg.ToolCallID + ")"
+		}
+		lines = append(lines, line+":"+msg.Content)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func importOne(t *testing.T, data string, want ImportFormat) Conversation {
+	t.Helper()
+	format, err := DetectFormat([]byte(data))
+	if err != nil || format != want {
+		t.Fatalf("expected %s detected, got %q, %v", want, format, err)
+	}
+	conversations, err := Import([]byte(data), format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conversations) != 1 {
+		t.Fatalf("expected one conversation, got %d", len(conversations))
+	}
+	if err := Validate(conversations[0].Messages); err != nil {
+		t.Fatalf("imported history does not validate: %v", err)
+	}
+	return conversations[0]
+}
+
+func TestImportClaudeCodeSession(t *testing.T) {
+	data := `{"type":"summary","summary":"Fix the flaky test","leafUuid":"x"}
+{"type":"user","cwd":"/src/app","timestamp":"2026-03-01T10:00:00.000Z","sessionId":"s","message":{"role":"user","content":"why does TestSync flake?"}}
+{"type":"user","isMeta":true,"cwd":"/src/app","message":{"role":"user","content":"<command-name>/clear</command-name>"}}
+{"type":"assistant","cwd":"/src/app","message":{"id":"m1","role":"assistant","content":[{"type":"thinking","thinking":"look at it"},{"type":"text","text":"Let me read it."}]}}
+{"type":"assistant","cwd":"/src/app","message":{"id":"m1","role":"assistant","content":[{"type":"tool_use","id":"toolu_1","name":"Read","input":{"file_path":"sync_test.go"}}]}}
+{"type":"user","cwd":"/src/app","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"toolu_1","content":[{"type":"text","text":"func TestSync"}]}]}}
+{"type":"assistant","isSidechain":true,"message":{"role":"assistant","content":[{"type":"text","text":"sub-agent chatter"}]}}
+{"type":"assistant","cwd":"/src/app","message":{"id":"m2","role":"assistant","content":[{"type":"text","text":"It races on the clock."},{"type":"tool_use","id":"toolu_2","name":"Edit","input":{}}]}}
+`
+	c := importOne(t, data, ImportClaudeCode)
+	if c.Title != "Fix the flaky test" || c.CWD != "/src/app" || c.CreatedAt.IsZero() {
+		t.Fatalf("unexpected conversation details: %q %q %v", c.Title, c.CWD, c.CreatedAt)
+	}
+	want := `user:why does TestSync flake?
+assistant->Read(toolu_1):Let me read it.
+tool(toolu_1):func TestSync
+assistant->Edit(toolu_2):It races on the clock.
+tool(toolu_2):` + noResult
+	if got := roles(c.Messages); got != want {
+		t.Fatalf("messages:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestImportCodexRollout(t *testing.T) {
+	data := `{"timestamp":"2026-03-02T09:00:00Z","type":"session_meta","payload":{"id":"r1","timestamp":"2026-03-02T09:00:00Z","cwd":"/src/cli"}}
+{"type":"response_item","payload":{"type":"message","role":"developer","content":[{"type":"input_text","text":"system rules"}]}}
+{"type":"response_item","payload":{"type":"message","role":"user","content":[{"type":"input_text","text":"<environment_context>cwd</environment_context>"},{"type":"input_text","text":"list the files"}]}}
+{"type":"response_item","payload":{"type":"reasoning","summary":[]}}
+{"type":"response_item","payload":{"type":"function_call","name":"shell","arguments":"{\"command\":[\"ls\"]}","call_id":"call_a"}}
+{"type":"event_msg","payload":{"type":"token_count"}}
+{"type":"response_item","payload":{"type":"function_call_output","call_id":"call_a","output":"{\"output\":\"main.go\\n\",\"metadata\":{\"exit_code\":0}}"}}
+{"type":"response_item","payload":{"type":"message","role":"assistant","content":[{"type":"output_text","text":"Just main.go."}]}}
+`
+	c := importOne(t, data, ImportCodex)
+	if c.CWD != "/src/cli" || c.CreatedAt.IsZero() {
+		t.Fatalf("unexpected conversation details: %q %v", c.CWD, c.CreatedAt)
+	}
+	want := "user:list the files\nassistant->shell(call_a):\ntool(call_a):{\"output\":\"main.go\\n\",\"metadata\":{\"exit_code\":0}}\nassistant:Just main.go."
+	if got := roles(c.Messages); got != want {
+		t.Fatalf("messages:\n got %s\nwant %s", got, want)
+	}
+	if args := c.Messages[1].ToolCalls[0].Arguments["command"]; fmt.Sprint(args) != "[ls]" {
+		t.Fatalf("expected the call's arguments decoded, got %v", args)
+	}
+}
+
+func TestImportChatGPTExportFollowsTheCurrentBranch(t *testing.T) {
+	data := `[{"title":"Trip ideas","create_time":1767225600.5,"current_node":"a2","mapping":{
+  "root":{"parent":null,"children":["s"],"message":null},
+  "s":{"parent":"root","children":["u1"],"message":{"author":{"role":"system"},"content":{"content_type":"text","parts":[""]},"metadata":{"is_visually_hidden_from_conversation":true}}},
+  "u1":{"parent":"s","children":["a1","t1"],"message":{"author":{"role":"user"},"content":{"content_type":"text","parts":["Where should I go in May?"]}}},
+  "a1":{"parent":"u1","children":[],"message":{"author":{"role":"assistant"},"content":{"content_type":"text","parts":["A discarded draft"]}}},
+  "t1":{"parent":"u1","children":["a2"],"message":{"author":{"role":"tool","name":"web"},"content":{"content_type":"text","parts":["weather data"]}}},
+  "a2":{"parent":"t1","children":[],"message":{"author":{"role":"assistant"},"content":{"content_type":"text","parts":["Try Lisbon."]}}}
+}}]`
+	c := importOne(t, data, ImportChatGPT)
+	if c.Title != "Trip ideas" || c.CreatedAt.Year() != 2026 {
+		t.Fatalf("unexpected conversation details: %q %v", c.Title, c.CreatedAt)
+	}
+	want := "user:Where should I go in May?\nassistant:[web output]\nweather data\n\nTry Lisbon."
+	if got := roles(c.Messages); got != want {
+		t.Fatalf("messages:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestImportMarkdownTranscript(t *testing.T) {
+	data := "# Refactoring notes\n\nExported from somewhere.\n\n## User\n\nSplit this function:\n\n```\n## Assistant\n```\n\n## Assistant\n\nDone.\n\n**User:** thanks\nUser: and tests?\n## System\nignored\n"
+	c := importOne(t, data, ImportMarkdown)
+	if c.Title != "Refactoring notes" {
+		t.Fatalf("unexpected title %q", c.Title)
+	}
+	want := "user:Split this function:\n\n```\n## Assistant\n```\nassistant:Done.\nuser:thanks\n\nand tests?"
+	if got := roles(c.Messages); got != want {
+		t.Fatalf("messages:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestImportRejectsUnknownInput(t *testing.T) {
+	if _, err := DetectFormat([]byte(`{"kind":"other"}`)); err == nil {
+		t.Fatal("expected unrecognized JSON rejected")
+	}
+	if _, err := Import([]byte("no roles here"), ImportMarkdown); err == nil {
+		t.Fatal("expected a transcript without messages rejected")
+	}
+}
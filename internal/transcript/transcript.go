@@ -0,0 +1,153 @@
+// Package transcript converts persisted session entries to and from provider
+// messages and external formats.
+package transcript
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+	"github.com/bitop-dev/agent/pkg/session"
+)
+
+// FromEntries rebuilds the provider transcript from a session's message
+// entries, with the messages pinned by its EventMessagePinned entries
+// labeled pkgruntime.LabelPinned.
+func FromEntries(entries []session.Entry) []provider.Message {
+	transcript := make([]provider.Message, 0, len(entries))
+	pins := map[string]bool{}
+	for _, entry := range entries {
+		if entry.Kind == session.EntryEvent && entry.EventType == session.EventMessagePinned {
+			var pin session.Pin
+			if json.Unmarshal([]byte(entry.Metadata), &pin) == nil && pin.Digest != "" {
+				pins[pin.Digest] = pin.Pinned
+			}
+			continue
+		}
+		if entry.Kind != session.EntryMessage {
+			continue
+		}
+		meta := DecodeMetadata(entry.Metadata)
+		switch entry.Role {
+		case "user", "assistant", "tool":
+			transcript = append(transcript, provider.Message{
+				Role:       entry.Role,
+				Content:    entry.Content,
+				ToolCallID: meta.ToolCallID,
+				ToolName:   meta.ToolName,
+				ToolCalls:  meta.ToolCalls,
+				Raw:        meta.Raw,
+				Images:     meta.Images,
+				Documents:  meta.Documents,
+				Labels:     meta.Labels,
+			})
+		}
+	}
+	if len(pins) > 0 {
+		digests := session.MessageDigests(transcript)
+		for i, msg := range transcript {
+			if pinned, ok := pins[digests[i]]; ok && pinned != pkgruntime.IsPinned(msg) {
+				_ = pkgruntime.PinMessage(context.Background(), nil, "", transcript, i, pinned)
+			}
+		}
+	}
+	return transcript
+}
+
+// State snapshots a stored session in the portable format of
+// pkgruntime.ExportState, so a conversation can move from the JSONL store
+// into an embedder's own storage.
+func State(s session.Session) pkgruntime.State {
+	state := pkgruntime.State{SessionID: s.Metadata.ID, Messages: FromEntries(s.Entries), UpdatedAt: s.Metadata.UpdatedAt}
+	state.Profile, state.Model = s.Active()
+	_, total := Costs(s.Entries)
+	state.InputTokens, state.OutputTokens, state.ReasoningTokens, state.CostUSD = total.InputTokens, total.OutputTokens, total.Reasoning, total.CostUSD
+	for i, entry := range s.Entries {
+		switch {
+		case entry.Kind == session.EntryCompaction:
+			state.Summary = entry.Content
+		case entry.Kind == session.EntryMessage && (entry.Role == "user" || entry.Role == "assistant" || entry.Role == "tool"):
+			// The messages FromEntries rebuilt, in order.
+			state.EntryIDs = append(state.EntryIDs, i)
+		}
+	}
+	return state
+}
+
+// ToEntries converts provider messages to session entries stamped at, so an
+// externally built history can be persisted and later resumed with
+// FromEntries.
+func ToEntries(messages []provider.Message, at time.Time) []session.Entry {
+	entries := make([]session.Entry, 0, len(messages))
+	for _, msg := range messages {
+		entry := session.Entry{Kind: session.EntryMessage, Role: msg.Role, Content: msg.Content, CreatedAt: at}
+		meta := session.MessageMetadata{ToolCallID: msg.ToolCallID, ToolName: msg.ToolName, ToolCalls: msg.ToolCalls, Raw: msg.Raw, Images: msg.Images, Documents: msg.Documents, Labels: msg.Labels}
+		if meta.ToolCallID != "" || meta.ToolName != "" || len(meta.ToolCalls) > 0 || len(meta.Raw) > 0 || len(meta.Images) > 0 || len(meta.Documents) > 0 || len(meta.Labels) > 0 {
+			if data, err := json.Marshal(meta); err == nil {
+				entry.Metadata = string(data)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Validate checks that an externally constructed history is one providers
+// will accept: known roles, tool calls with IDs and tool names, and every
+// tool call answered by exactly one tool message before the next user or
+// assistant message.
+func Validate(messages []provider.Message) error {
+	pending := map[string]bool{}
+	checkAnswered := func(i int) error {
+		for id := range pending {
+			return fmt.Errorf("message %d: tool call %q has no tool result", i, id)
+		}
+		return nil
+	}
+	for i, msg := range messages {
+		switch msg.Role {
+		case "user":
+			if err := checkAnswered(i); err != nil {
+				return err
+			}
+		case "assistant":
+			if err := checkAnswered(i); err != nil {
+				return err
+			}
+			for _, call := range msg.ToolCalls {
+				if call.ID == "" || call.ToolID == "" {
+					return fmt.Errorf("message %d: tool calls need an id and a tool id", i)
+				}
+				if pending[call.ID] {
+					return fmt.Errorf("message %d: duplicate tool call id %q", i, call.ID)
+				}
+				pending[call.ID] = true
+			}
+		case "tool":
+			if !pending[msg.ToolCallID] {
+				return fmt.Errorf("message %d: tool result %q does not answer a preceding tool call", i, msg.ToolCallID)
+			}
+			delete(pending, msg.ToolCallID)
+		default:
+			return fmt.Errorf("message %d: unsupported role %q (expected user, assistant, or tool)", i, msg.Role)
+		}
+	}
+	return checkAnswered(len(messages))
+}
+
+// DecodeMetadata parses an entry's metadata column. Malformed or empty
+// metadata yields the zero value.
+func DecodeMetadata(raw string) session.MessageMetadata {
+	if strings.TrimSpace(raw) == "" {
+		return session.MessageMetadata{}
+	}
+	var meta session.MessageMetadata
+	if err := json.Unmarshal([]byte(raw), &meta); err != nil {
+		return session.MessageMetadata{}
+	}
+	return meta
+}
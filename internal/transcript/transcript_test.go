@@ -0,0 +1,97 @@
+package transcript
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+	pkgruntime "github.com/bitop-dev/agent/pkg/runtime"
+	"github.com/bitop-dev/agent/pkg/session"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+func TestValidateAndRoundTripSeedHistory(t *testing.T) {
+	history := []provider.Message{
+		{Role: "user", Content: "read a.go"},
+		{Role: "assistant", ToolCalls: []tool.Call{{ID: "c1", ToolID: "core/read", Arguments: map[string]any{"path": "a.go"}}}},
+		{Role: "tool", Content: "package a", ToolCallID: "c1", ToolName: "core/read"},
+		{Role: "assistant", Content: "it declares package a"},
+	}
+	if err := Validate(history); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	back := FromEntries(ToEntries(history, time.Now()))
+	if len(back) != len(history) || back[1].ToolCalls[0].ID != "c1" || back[2].ToolCallID != "c1" || back[2].ToolName != "core/read" {
+		t.Fatalf("round trip lost data: %+v", back)
+	}
+
+	for name, tc := range map[string]struct {
+		messages []provider.Message
+		want     string
+	}{
+		"role":       {[]provider.Message{{Role: "system", Content: "x"}}, "unsupported role"},
+		"orphan":     {[]provider.Message{{Role: "tool", ToolCallID: "c9"}}, "does not answer"},
+		"unanswered": {history[:2], `tool call "c1" has no tool result`},
+		"no id":      {[]provider.Message{{Role: "assistant", ToolCalls: []tool.Call{{ToolID: "core/read"}}}}, "need an id"},
+	} {
+		err := Validate(tc.messages)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: expected error containing %q, got %v", name, tc.want, err)
+		}
+	}
+}
+
+func TestStateExportImportRoundTrip(t *testing.T) {
+	history := []provider.Message{
+		{Role: "user", Content: "read a.go"},
+		{Role: "assistant", ToolCalls: []tool.Call{{ID: "c1", ToolID: "core/read", Arguments: map[string]any{"path": "a.go"}}}, Raw: []provider.RawContent{{Provider: "openai", Type: "reasoning", Data: []byte(`{"type":"reasoning","id":"rs_1"}`)}}},
+		{Role: "tool", Content: "package a", ToolCallID: "c1", ToolName: "core/read"},
+		{Role: "assistant", Content: "it declares package a"},
+	}
+	entries := ToEntries(history, time.Now())
+	entries[3].Metadata = `{"usage":{"model":"gpt-4.1","inputTokens":100,"outputTokens":20}}`
+	entries = append(entries, session.Entry{Kind: session.EntryCompaction, Role: "system", Content: "read a.go"})
+	state := State(session.Session{Metadata: session.Metadata{ID: "s1", Profile: "coder"}, Entries: entries})
+	state.Metadata = map[string]string{"tenant": "acme"}
+
+	data, err := pkgruntime.ExportState(state)
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	back, err := pkgruntime.ImportState(data)
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if back.SessionID != "s1" || back.Profile != "coder" || back.Summary != "read a.go" || back.InputTokens != 100 || back.OutputTokens != 20 || back.Metadata["tenant"] != "acme" {
+		t.Fatalf("state lost data: %+v", back)
+	}
+	if !slices.Equal(back.EntryIDs, []int{0, 1, 2, 3}) {
+		t.Fatalf("expected the messages matched to their entries, got %v", back.EntryIDs)
+	}
+	if err := Validate(back.Messages); err != nil || back.Messages[1].ToolCalls[0].Arguments["path"] != "a.go" {
+		t.Fatalf("messages did not survive the round trip: %v %+v", err, back.Messages)
+	}
+	if raw := back.Messages[1].Raw; len(raw) != 1 || raw[0].Type != "reasoning" || string(raw[0].Data) != `{"type":"reasoning","id":"rs_1"}` {
+		t.Fatalf("raw content did not survive the round trip: %+v", raw)
+	}
+
+	if _, err := pkgruntime.ImportState([]byte(`{"version":99,"messages":[]}`)); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Fatalf("expected a version error, got %v", err)
+	}
+}
+
+func TestRepeatedMessagesArePinnedOnTheirOwn(t *testing.T) {
+	history := []provider.Message{
+		{Role: "user", Content: "yes"},
+		{Role: "assistant", Content: "done"},
+		{Role: "user", Content: "yes"},
+	}
+	entries := ToEntries(history, time.Now())
+	entries = append(entries, session.Entry{Kind: session.EntryEvent, EventType: session.EventMessagePinned, Metadata: `{"digest":"` + session.MessageDigests(history)[2] + `","pinned":true}`})
+	back := FromEntries(entries)
+	if pkgruntime.IsPinned(back[0]) || !pkgruntime.IsPinned(back[2]) {
+		t.Fatalf("expected only the second %q pinned, got %+v", "yes", back)
+	}
+}
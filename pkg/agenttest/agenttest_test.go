@@ -0,0 +1,162 @@
+package agenttest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/events"
+	"github.com/bitop-dev/agent/pkg/provider"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+func drain(t *testing.T, ch <-chan provider.StreamEvent) []provider.StreamEvent {
+	t.Helper()
+	var got []provider.StreamEvent
+	for event := range ch {
+		got = append(got, event)
+	}
+	return got
+}
+
+func TestScriptedProviderReplaysRepliesInOrder(t *testing.T) {
+	p := NewScriptedProvider(
+		Reply{Reasoning: "look first", ToolCalls: []tool.Call{{ToolID: "core/read", Arguments: map[string]any{"path": "/a"}}}, InputTokens: 10, OutputTokens: 3},
+		Text("done"),
+	)
+	ch, err := p.Stream(context.Background(), provider.CompletionRequest{System: "one"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := drain(t, ch)
+	if len(got) != 3 || got[0].Type != provider.StreamEventReasoning || got[1].ToolCall.ID != "call_1_1" || got[2].Type != provider.StreamEventDone || got[2].InputTokens != 10 {
+		t.Fatalf("unexpected first stream: %+v", got)
+	}
+	ch, err = p.Stream(context.Background(), provider.CompletionRequest{System: "two"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := drain(t, ch); len(got) != 2 || got[0].Text != "done" {
+		t.Fatalf("unexpected second stream: %+v", got)
+	}
+	if _, err := p.Stream(context.Background(), provider.CompletionRequest{}); err == nil {
+		t.Fatal("expected a call past the script to fail")
+	}
+	if reqs := p.Requests(); len(reqs) != 3 || reqs[1].System != "two" || p.Remaining() != 0 {
+		t.Fatalf("unexpected requests recorded: %+v", reqs)
+	}
+}
+
+func TestScriptedProviderErrorsAndDelays(t *testing.T) {
+	rejected := errors.New("rate limited")
+	dropped := errors.New("connection reset")
+	p := NewScriptedProvider(Fail(rejected), Reply{Text: "partial", StreamErr: dropped}, Reply{Text: "slow", Delay: time.Hour})
+	if _, err := p.Stream(context.Background(), provider.CompletionRequest{}); !errors.Is(err, rejected) {
+		t.Fatalf("expected the scripted error, got %v", err)
+	}
+	ch, err := p.Stream(context.Background(), provider.CompletionRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := drain(t, ch); len(got) != 2 || got[0].Text != "partial" || !errors.Is(got[1].Err, dropped) {
+		t.Fatalf("expected the stream to end with the scripted error, got %+v", got)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := p.Stream(ctx, provider.CompletionRequest{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the delay to end with the context, got %v", err)
+	}
+}
+
+type namespacedTool struct{ tool.Tool }
+
+func (namespacedTool) Namespace() string { return "mcp" }
+
+func (namespacedTool) Capabilities() tool.Capabilities { return tool.Capabilities{Cacheable: true} }
+
+func (namespacedTool) CloseSession(string) {}
+
+func TestRecordingRegistryRecordsCalls(t *testing.T) {
+	echo := Func(tool.Definition{ID: "test/echo"}, func(_ context.Context, call tool.Call) (tool.Result, error) {
+		if call.Arguments["fail"] == true {
+			return tool.Result{}, errors.New("asked to fail")
+		}
+		return tool.Result{Output: fmt.Sprint(call.Arguments["text"])}, nil
+	})
+	search := namespacedTool{Func(tool.Definition{ID: "search"}, func(context.Context, tool.Call) (tool.Result, error) {
+		return tool.Result{Output: "found"}, nil
+	})}
+	r := NewRecordingRegistry(search, echo)
+	if ids := r.List(); len(ids) != 2 || ids[0].ID != "search" || ids[1].ID != "test/echo" {
+		t.Fatalf("unexpected definitions: %+v", ids)
+	}
+	got, ok := r.Get("mcp:search")
+	if !ok || tool.NamespaceOf(got) != "mcp" || !tool.CapabilitiesOf(got).Cacheable {
+		t.Fatal("expected the wrapped tool to keep its namespace and capabilities")
+	}
+	if _, ok := tool.As[tool.SessionCloser](got); !ok {
+		t.Fatal("expected the wrapped tool's optional interfaces found through the wrapper")
+	}
+	echoed, _ := r.Get("test/echo")
+	if _, ok := tool.As[tool.DryRunner](echoed); ok {
+		t.Fatal("expected a wrapper not to add interfaces its tool lacks")
+	}
+	if _, ok := r.Get("builtin:search"); ok {
+		t.Fatal("expected a lookup in another namespace to fail")
+	}
+
+	tools := r.Tools()
+	_, _ = tools[1].Run(context.Background(), tool.Call{ID: "c1", ToolID: "test/echo", Arguments: map[string]any{"text": "hi"}})
+	_, _ = tools[1].Run(context.Background(), tool.Call{ID: "c2", ToolID: "test/echo", Arguments: map[string]any{"fail": true}})
+	_, _ = tools[0].Run(context.Background(), tool.Call{ID: "c3", ToolID: "search"})
+	calls := r.CallsTo("test/echo")
+	if len(calls) != 2 || calls[0].Result.Output != "hi" || calls[1].Err == nil {
+		t.Fatalf("unexpected calls: %+v", calls)
+	}
+	if len(r.Calls()) != 3 {
+		t.Fatalf("expected 3 calls, got %d", len(r.Calls()))
+	}
+	r.Reset()
+	if len(r.Calls()) != 0 {
+		t.Fatal("expected Reset to forget the calls")
+	}
+}
+
+// fakeT records failures instead of stopping the test.
+type fakeT struct {
+	testing.TB
+	failures []string
+}
+
+func (f *fakeT) Helper() {}
+
+func (f *fakeT) Fatalf(format string, args ...any) {
+	f.failures = append(f.failures, fmt.Sprintf(format, args...))
+}
+
+func TestEventAssertions(t *testing.T) {
+	r := &Recorder{}
+	for _, typ := range []events.Type{events.TypeRunStarted, events.TypeToolRequested, events.TypeToolFinished, events.TypeRunFinished} {
+		_ = r.Publish(context.Background(), events.Event{Type: typ, Message: string(typ)})
+	}
+
+	ok := &fakeT{}
+	AssertEvents(ok, r, events.TypeRunStarted, events.TypeToolFinished, events.TypeRunFinished)
+	AssertNoEvent(ok, r, events.TypeError)
+	if event := RequireEvent(ok, r, events.TypeToolRequested); event.Message != "tool_requested" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	if len(ok.failures) != 0 {
+		t.Fatalf("unexpected failures: %v", ok.failures)
+	}
+
+	bad := &fakeT{}
+	AssertEvents(bad, r, events.TypeToolFinished, events.TypeToolRequested)
+	AssertNoEvent(bad, r, events.TypeRunFinished)
+	RequireEvent(bad, r, events.TypeError)
+	if len(bad.failures) != 3 {
+		t.Fatalf("expected 3 failures, got %v", bad.failures)
+	}
+}
@@ -0,0 +1,99 @@
+package agenttest
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bitop-dev/agent/pkg/events"
+)
+
+// Recorder is an events.Sink that keeps every event published to it. The
+// zero value is ready to use and it is safe for concurrent use.
+type Recorder struct {
+	mu     sync.Mutex
+	events []events.Event
+}
+
+func (r *Recorder) Publish(_ context.Context, event events.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+	return nil
+}
+
+// Events returns the events published so far, in order.
+func (r *Recorder) Events() []events.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]events.Event(nil), r.events...)
+}
+
+// Types returns the types of the events published so far, in order.
+func (r *Recorder) Types() []events.Type {
+	all := r.Events()
+	types := make([]events.Type, len(all))
+	for i, event := range all {
+		types[i] = event.Type
+	}
+	return types
+}
+
+// OfType returns the events of type typ, in order.
+func (r *Recorder) OfType(typ events.Type) []events.Event {
+	var matched []events.Event
+	for _, event := range r.Events() {
+		if event.Type == typ {
+			matched = append(matched, event)
+		}
+	}
+	return matched
+}
+
+// AssertEvents fails t unless r recorded events of each of types, in that
+// order; other events may come between them.
+func AssertEvents(t testing.TB, r *Recorder, types ...events.Type) {
+	t.Helper()
+	seen := r.Types()
+	next := 0
+	for _, typ := range seen {
+		if next < len(types) && typ == types[next] {
+			next++
+		}
+	}
+	if next < len(types) {
+		t.Fatalf("expected events %s in order, missing %s; recorded %s", describe(types), types[next], describe(seen))
+	}
+}
+
+// AssertNoEvent fails t if r recorded an event of type typ.
+func AssertNoEvent(t testing.TB, r *Recorder, typ events.Type) {
+	t.Helper()
+	if matched := r.OfType(typ); len(matched) > 0 {
+		t.Fatalf("expected no %s event, got %d (first: %q)", typ, len(matched), matched[0].Message)
+	}
+}
+
+// RequireEvent returns the first event of type typ r recorded, failing t
+// if there is none.
+func RequireEvent(t testing.TB, r *Recorder, typ events.Type) events.Event {
+	t.Helper()
+	matched := r.OfType(typ)
+	if len(matched) == 0 {
+		t.Fatalf("expected a %s event; recorded %s", typ, describe(r.Types()))
+		return events.Event{}
+	}
+	return matched[0]
+}
+
+func describe(types []events.Type) string {
+	if len(types) == 0 {
+		return "none"
+	}
+	names := make([]string, len(types))
+	for i, typ := range types {
+		names[i] = string(typ)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,168 @@
+// Package agenttest helps unit-test agents without a model: a provider
+// that replays a script of replies, a tool registry that records the calls
+// made to its tools, and a sink with assertions over the events of a run.
+//
+//	model := agenttest.NewScriptedProvider(
+//		agenttest.Call("core/read", map[string]any{"path": "/tmp/notes.txt"}),
+//		agenttest.Text("The notes say hello."),
+//	)
+//	tools := agenttest.NewRecordingRegistry(coretools.ReadTool{})
+//	sink := &agenttest.Recorder{}
+//	result, err := runner.Run(ctx, runtime.RunRequest{Provider: model, Tools: tools.Tools(), Events: sink, ...})
+//	agenttest.AssertEvents(t, sink, events.TypeToolFinished, events.TypeRunFinished)
+//	if got := tools.CallsTo("core/read"); len(got) != 1 { ... }
+package agenttest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// Reply is one scripted model turn. Reasoning, Raw, Text, and then each of
+// ToolCalls are streamed in that order, followed by the done event
+// carrying the token counts.
+type Reply struct {
+	Text      string
+	Reasoning string
+	ToolCalls []tool.Call
+	// Raw are content blocks the runtime does not model, such as signed
+	// thinking, attached to the assistant message unchanged.
+	Raw []provider.RawContent
+	// InputTokens and OutputTokens are reported with the done event.
+	InputTokens  int
+	OutputTokens int
+	// Delay is waited before the stream starts, or until the request's
+	// context ends.
+	Delay time.Duration
+	// Err is returned by Stream instead of a stream, as for a request the
+	// provider rejects.
+	Err error
+	// StreamErr ends the stream after the reply's content instead of the
+	// done event, as for a connection dropped mid-generation.
+	StreamErr error
+}
+
+// Text returns a reply answering with text.
+func Text(text string) Reply {
+	return Reply{Text: text}
+}
+
+// Call returns a reply calling the tool toolID with args. The call's ID
+// is filled in when the reply is streamed.
+func Call(toolID string, args map[string]any) Reply {
+	return Reply{ToolCalls: []tool.Call{{ToolID: toolID, Arguments: args}}}
+}
+
+// Fail returns a reply whose Stream call fails with err.
+func Fail(err error) Reply {
+	return Reply{Err: err}
+}
+
+// ScriptedProvider is a provider.Provider that answers each Stream call
+// with the next Reply of its script and records the requests it gets. A
+// call past the end of the script fails. It is safe for concurrent use.
+type ScriptedProvider struct {
+	// ProviderName is returned by Name; empty means "scripted".
+	ProviderName string
+
+	mu       sync.Mutex
+	replies  []Reply
+	requests []provider.CompletionRequest
+}
+
+// NewScriptedProvider returns a provider replaying replies in order.
+func NewScriptedProvider(replies ...Reply) *ScriptedProvider {
+	return &ScriptedProvider{replies: replies}
+}
+
+// Append adds replies to the end of the script.
+func (p *ScriptedProvider) Append(replies ...Reply) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.replies = append(p.replies, replies...)
+}
+
+func (p *ScriptedProvider) Name() string {
+	if p.ProviderName != "" {
+		return p.ProviderName
+	}
+	return "scripted"
+}
+
+func (p *ScriptedProvider) Stream(ctx context.Context, req provider.CompletionRequest) (<-chan provider.StreamEvent, error) {
+	p.mu.Lock()
+	n := len(p.requests)
+	p.requests = append(p.requests, req)
+	if n >= len(p.replies) {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("agenttest: no reply scripted for call %d (the script has %d)", n+1, len(p.replies))
+	}
+	reply := p.replies[n]
+	p.mu.Unlock()
+
+	if reply.Delay > 0 {
+		timer := time.NewTimer(reply.Delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if reply.Err != nil {
+		return nil, reply.Err
+	}
+	ch := make(chan provider.StreamEvent, len(reply.Raw)+len(reply.ToolCalls)+3)
+	if reply.Reasoning != "" {
+		ch <- provider.StreamEvent{Type: provider.StreamEventReasoning, Text: reply.Reasoning}
+	}
+	for _, raw := range reply.Raw {
+		ch <- provider.StreamEvent{Type: provider.StreamEventRaw, Raw: raw}
+	}
+	if reply.Text != "" {
+		ch <- provider.StreamEvent{Type: provider.StreamEventText, Text: reply.Text}
+	}
+	for i, call := range reply.ToolCalls {
+		if call.ID == "" {
+			call.ID = fmt.Sprintf("call_%d_%d", n+1, i+1)
+		}
+		ch <- provider.StreamEvent{Type: provider.StreamEventToolCall, ToolCall: call}
+	}
+	if reply.StreamErr != nil {
+		ch <- provider.StreamEvent{Err: reply.StreamErr}
+	} else {
+		ch <- provider.StreamEvent{Type: provider.StreamEventDone, InputTokens: reply.InputTokens, OutputTokens: reply.OutputTokens}
+	}
+	close(ch)
+	return ch, nil
+}
+
+// Requests returns the requests received so far, in order.
+func (p *ScriptedProvider) Requests() []provider.CompletionRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]provider.CompletionRequest(nil), p.requests...)
+}
+
+// LastRequest returns the most recent request, or the zero value before
+// the first.
+func (p *ScriptedProvider) LastRequest() provider.CompletionRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.requests) == 0 {
+		return provider.CompletionRequest{}
+	}
+	return p.requests[len(p.requests)-1]
+}
+
+// Remaining returns how many scripted replies have not been used.
+func (p *ScriptedProvider) Remaining() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return max(len(p.replies)-len(p.requests), 0)
+}
@@ -0,0 +1,145 @@
+package agenttest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// RecordedCall is one call made to a tool of a RecordingRegistry.
+type RecordedCall struct {
+	Call   tool.Call
+	Result tool.Result
+	Err    error
+}
+
+// RecordingRegistry is a tool.Registry whose tools record each call made
+// to them, with its result. Tools returns them for a RunRequest. It is
+// safe for concurrent use.
+type RecordingRegistry struct {
+	mu    sync.Mutex
+	tools map[string]*recordingTool
+	calls []RecordedCall
+}
+
+// NewRecordingRegistry returns a registry holding tools. It panics on a
+// tool without an ID.
+func NewRecordingRegistry(tools ...tool.Tool) *RecordingRegistry {
+	r := &RecordingRegistry{tools: map[string]*recordingTool{}}
+	for _, t := range tools {
+		if err := r.Register(t); err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
+
+func (r *RecordingRegistry) Register(t tool.Tool) error {
+	id := t.Definition().ID
+	if id == "" {
+		return fmt.Errorf("tool id is required")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tools[id] = &recordingTool{Tool: t, registry: r}
+	return nil
+}
+
+func (r *RecordingRegistry) Get(id string) (tool.Tool, bool) {
+	ns, id := tool.SplitQualified(id)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.tools[id]
+	if !ok || (ns != "" && tool.NamespaceOf(t) != ns) {
+		return nil, false
+	}
+	return t, true
+}
+
+func (r *RecordingRegistry) List() []tool.Definition {
+	tools := r.Tools()
+	defs := make([]tool.Definition, len(tools))
+	for i, t := range tools {
+		defs[i] = t.Definition()
+	}
+	return defs
+}
+
+// Tools returns the registered tools, sorted by ID.
+func (r *RecordingRegistry) Tools() []tool.Tool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tools := make([]tool.Tool, 0, len(r.tools))
+	for _, t := range r.tools {
+		tools = append(tools, t)
+	}
+	sort.Slice(tools, func(i, j int) bool { return tools[i].Definition().ID < tools[j].Definition().ID })
+	return tools
+}
+
+// Calls returns every call made so far, in the order they finished.
+func (r *RecordingRegistry) Calls() []RecordedCall {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]RecordedCall(nil), r.calls...)
+}
+
+// CallsTo returns the calls made to the tool toolID.
+func (r *RecordingRegistry) CallsTo(toolID string) []RecordedCall {
+	var calls []RecordedCall
+	for _, c := range r.Calls() {
+		if c.Call.ToolID == toolID {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// Reset forgets the calls recorded so far.
+func (r *RecordingRegistry) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = nil
+}
+
+// recordingTool wraps a registered tool; its optional interfaces are found
+// through Unwrap.
+type recordingTool struct {
+	tool.Tool
+	registry *RecordingRegistry
+}
+
+func (t *recordingTool) Run(ctx context.Context, call tool.Call) (tool.Result, error) {
+	result, err := t.Tool.Run(ctx, call)
+	if call.ToolID == "" {
+		call.ToolID = t.Definition().ID
+	}
+	t.registry.mu.Lock()
+	t.registry.calls = append(t.registry.calls, RecordedCall{Call: call, Result: result, Err: err})
+	t.registry.mu.Unlock()
+	return result, err
+}
+
+func (t *recordingTool) Unwrap() tool.Tool {
+	return t.Tool
+}
+
+// Func returns a tool with definition def that runs fn, for a stand-in
+// tool in a test.
+func Func(def tool.Definition, fn func(ctx context.Context, call tool.Call) (tool.Result, error)) tool.Tool {
+	return funcTool{def: def, fn: fn}
+}
+
+type funcTool struct {
+	def tool.Definition
+	fn  func(ctx context.Context, call tool.Call) (tool.Result, error)
+}
+
+func (t funcTool) Definition() tool.Definition { return t.def }
+
+func (t funcTool) Run(ctx context.Context, call tool.Call) (tool.Result, error) {
+	return t.fn(ctx, call)
+}
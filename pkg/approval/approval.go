@@ -1,6 +1,11 @@
 package approval
 
-import "context"
+import (
+	"context"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
 
 type Mode string
 
@@ -8,6 +13,9 @@ const (
 	ModeNever     Mode = "never"
 	ModeOnRequest Mode = "on-request"
 	ModeAlways    Mode = "always"
+	// ModeWebhook posts each request to the configured webhook and waits for
+	// an out-of-band decision.
+	ModeWebhook Mode = "webhook"
 )
 
 type Request struct {
@@ -15,11 +23,24 @@ type Request struct {
 	ToolID string
 	Reason string
 	Risk   string
+
+	// Call context for resolvers that need more than the tool ID. Transcript
+	// is a snapshot of the conversation up to this call and must not be mutated.
+	ToolCallID string
+	Definition tool.Definition
+	Arguments  map[string]any
+	Transcript []provider.Message
+	// Diff is the unified diff of the files the call would write, for
+	// tools that can tell (tool.DryRunner); "" otherwise.
+	Diff string
 }
 
 type Decision struct {
 	Approved bool
 	Reason   string
+	// Arguments, when non-nil, replaces the call's arguments (e.g. to rewrite
+	// a path or add --dry-run). The rewritten call is re-checked by policy.
+	Arguments map[string]any
 }
 
 type Resolver interface {
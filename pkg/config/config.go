@@ -2,10 +2,20 @@ package config
 
 import (
 	"errors"
+	"fmt"
+	"maps"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/bitop-dev/agent/pkg/profile"
+	"github.com/bitop-dev/agent/pkg/session"
 )
 
 type Paths struct {
@@ -16,8 +26,16 @@ type Paths struct {
 	UserProfilesDir  string
 	UserPluginsDir   string
 	SessionsDir      string
+	MemoryDir        string
+	ApprovalsDir     string
+	SpendFile        string
+	UsageFile        string
+	AuditFile        string
+	WorkflowsDir     string
 	LocalProfilesDir string
 	LocalPluginsDir  string
+	UserSkillsDir    string
+	LocalSkillsDir   string
 }
 
 type Config struct {
@@ -27,6 +45,261 @@ type Config struct {
 	Providers      map[string]ProviderConfig `yaml:"providers"`
 	Plugins        map[string]PluginConfig   `yaml:"plugins"`
 	PluginSources  []PluginSource            `yaml:"pluginSources,omitempty"`
+	Embeddings     EmbeddingsConfig          `yaml:"embeddings,omitempty"`
+	Approvals      ApprovalsConfig           `yaml:"approvals,omitempty"`
+	Serve          ServeConfig               `yaml:"serve,omitempty"`
+	// ModelAliases maps short names, such as "fast" or "smart", to model
+	// IDs. An alias can be used anywhere a model is named: --model, profile
+	// models and fallbacks, and ModelFallbacks.
+	ModelAliases map[string]string `yaml:"modelAliases,omitempty"`
+	// ModelFallbacks lists, per model, the models a turn moves on to in
+	// order when the model fails with an error it would repeat (model not
+	// found, content filter, quota exhausted). They follow the profile's
+	// own fallbacks.
+	ModelFallbacks map[string][]string `yaml:"modelFallbacks,omitempty"`
+	Sessions       SessionsConfig      `yaml:"sessions,omitempty"`
+	Tools          ToolsConfig         `yaml:"tools,omitempty"`
+	Audit          AuditConfig         `yaml:"audit,omitempty"`
+	// MaxTurnDuration bounds one model turn, its tool calls included, and
+	// MaxWallClock a whole run; both are Go durations such as "10m", and
+	// "" leaves the bound off. A run that reaches either stops after
+	// recording the turn it cut short.
+	MaxTurnDuration string `yaml:"maxTurnDuration,omitempty"`
+	MaxWallClock    string `yaml:"maxWallClock,omitempty"`
+	// Environments are named overlays, such as "cheap" or "azure-prod",
+	// merged over the rest of the file by LoadEnvironment. Each holds any
+	// of the settings above.
+	Environments map[string]yaml.Node `yaml:"environments,omitempty"`
+	// Environment is the name of the overlay LoadEnvironment merged, if
+	// any.
+	Environment string `yaml:"-"`
+}
+
+// RunLimits parses MaxTurnDuration and MaxWallClock.
+func (c Config) RunLimits() (turn, wallClock time.Duration, err error) {
+	if turn, err = parseLimit(c.MaxTurnDuration); err != nil {
+		return 0, 0, fmt.Errorf("maxTurnDuration: %w", err)
+	}
+	if wallClock, err = parseLimit(c.MaxWallClock); err != nil {
+		return 0, 0, fmt.Errorf("maxWallClock: %w", err)
+	}
+	return turn, wallClock, nil
+}
+
+func parseLimit(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return d, nil
+}
+
+// ToolsConfig shapes the tools every profile gets without changing the
+// profiles: custom presets they can enable, and filters on the result.
+type ToolsConfig struct {
+	// Presets are enabled as "@name" in a profile's tools.enabled, like
+	// the built-in @git.
+	Presets map[string]ToolPreset `yaml:"presets,omitempty"`
+	// Include, if set, keeps only the tools that match one of its
+	// patterns; Exclude then drops the tools that match one of its. They
+	// apply once plugin and MCP tools are loaded. Patterns are tool IDs or
+	// globs such as "core/git*", or, with a namespace, qualified IDs such
+	// as "mcp:*" for every MCP tool.
+	Include []string `yaml:"include,omitempty"`
+	Exclude []string `yaml:"exclude,omitempty"`
+}
+
+// ToolPreset is a named group of tools with settings for them. A profile
+// that enables the preset gets the settings for tools it does not
+// configure itself.
+type ToolPreset struct {
+	Tools    []string                        `yaml:"tools"` // tool IDs and built-in presets
+	Settings map[string]profile.ToolSettings `yaml:"settings,omitempty"`
+}
+
+// Filter applies Include and Exclude to tool IDs. qualify returns the
+// namespace-qualified ID ("mcp:search") that qualified patterns match.
+func (t ToolsConfig) Filter(ids []string, qualify func(id string) string) ([]string, error) {
+	for _, pattern := range append(append([]string{}, t.Include...), t.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("tools filter %q: %w", pattern, err)
+		}
+	}
+	var out []string
+	for _, id := range ids {
+		qualified := qualify(id)
+		if (len(t.Include) == 0 || matchTool(t.Include, id, qualified)) && !matchTool(t.Exclude, id, qualified) {
+			out = append(out, id)
+		}
+	}
+	return out, nil
+}
+
+func matchTool(patterns []string, id, qualified string) bool {
+	for _, pattern := range patterns {
+		name := id
+		if strings.Contains(pattern, ":") {
+			name = qualified
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// AuditConfig turns on the tool audit log: a hash-chained JSONL record of
+// every tool call, kept apart from sessions for compliance review.
+type AuditConfig struct {
+	Enabled bool   `yaml:"enabled,omitempty"`
+	Path    string `yaml:"path,omitempty"` // relative to the config directory; defaults to audit.jsonl
+}
+
+// SessionsConfig configures the session store.
+type SessionsConfig struct {
+	Retention RetentionConfig `yaml:"retention,omitempty"`
+	Titles    TitlesConfig    `yaml:"titles,omitempty"`
+	// Compress writes new session entries to a zstd-compressed log per
+	// session, which survives a crash mid-write, instead of to the
+	// database. Logged entries are not matched by "sessions search".
+	Compress bool `yaml:"compress,omitempty"`
+	// Remote mirrors sessions to object storage.
+	Remote RemoteSessionsConfig `yaml:"remote,omitempty"`
+}
+
+// RemoteSessionsConfig mirrors sessions to an S3-compatible bucket, so
+// runs in ephemeral CI containers keep their transcripts. Sessions are
+// written through when a run ends and every SyncInterval during it, and
+// ones missing locally are read from the bucket.
+type RemoteSessionsConfig struct {
+	// URL names the bucket and key prefix: s3://bucket/prefix, or
+	// gs://bucket/prefix for Google Cloud Storage with HMAC keys. "" turns
+	// mirroring off.
+	URL string `yaml:"url,omitempty"`
+	// Endpoint overrides the URL's service, such as a MinIO or R2 server,
+	// and Region the region requests are signed for.
+	Endpoint string `yaml:"endpoint,omitempty"`
+	Region   string `yaml:"region,omitempty"`
+	// AccessKey and SecretKey default to AWS_ACCESS_KEY_ID and
+	// AWS_SECRET_ACCESS_KEY, with AWS_SESSION_TOKEN.
+	AccessKey string `yaml:"accessKey,omitempty"`
+	SecretKey string `yaml:"secretKey,omitempty"`
+	// SyncInterval is a Go duration; "" is one minute and "0" writes
+	// sessions through only when their run ends.
+	SyncInterval string `yaml:"syncInterval,omitempty"`
+}
+
+// TitlesConfig names each new session with a short title and a one-line
+// summary after its first exchange, at the cost of one small model call.
+type TitlesConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Model writes the titles, from the run's provider; "" uses the run's
+	// model. A small, cheap model is enough.
+	Model string `yaml:"model,omitempty"`
+}
+
+// RetentionConfig is the retention policy applied by "sessions gc". Ages
+// are Go durations or a number of days ("90d"); sizes are bytes with an
+// optional KB, MB, or GB suffix.
+type RetentionConfig struct {
+	MaxAge        string `yaml:"maxAge,omitempty"`
+	MaxSize       string `yaml:"maxSize,omitempty"`
+	MaxCount      int    `yaml:"maxCount,omitempty"`
+	CompressAfter string `yaml:"compressAfter,omitempty"`
+	// Auto applies the policy when the CLI starts, at most once a day.
+	Auto bool `yaml:"auto,omitempty"`
+}
+
+// Policy parses the configured limits.
+func (r RetentionConfig) Policy() (session.RetentionPolicy, error) {
+	var policy session.RetentionPolicy
+	var err error
+	if policy.MaxAge, err = ParseAge(r.MaxAge); err != nil {
+		return policy, fmt.Errorf("sessions.retention.maxAge: %w", err)
+	}
+	if policy.CompressAfter, err = ParseAge(r.CompressAfter); err != nil {
+		return policy, fmt.Errorf("sessions.retention.compressAfter: %w", err)
+	}
+	if policy.MaxBytes, err = ParseSize(r.MaxSize); err != nil {
+		return policy, fmt.Errorf("sessions.retention.maxSize: %w", err)
+	}
+	policy.MaxCount = r.MaxCount
+	return policy, nil
+}
+
+// ParseAge parses a Go duration or a whole number of days such as "30d".
+// "" is zero.
+func ParseAge(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q", value)
+	}
+	return d, nil
+}
+
+// ParseSize parses a byte count with an optional KB, MB, or GB suffix
+// (powers of 1024). "" is zero.
+func ParseSize(value string) (int64, error) {
+	raw := value
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return 0, nil
+	}
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		bytes  int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if number, ok := strings.CutSuffix(value, unit.suffix); ok {
+			value, multiplier = strings.TrimSpace(number), unit.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", raw)
+	}
+	return n * multiplier, nil
+}
+
+// ServeConfig configures the HTTP worker started by "serve --addr".
+type ServeConfig struct {
+	// KeysFile lists API clients with their keys, model allowlists, rate
+	// limits, and monthly quotas. When set, every task request must carry a
+	// client's key as a bearer token.
+	KeysFile string `yaml:"keysFile,omitempty"`
+}
+
+// ApprovalsConfig configures approvalMode "webhook", where high-risk tool
+// calls wait for a human decision delivered out of band.
+type ApprovalsConfig struct {
+	WebhookURL  string `yaml:"webhookURL,omitempty"`
+	Format      string `yaml:"format,omitempty"`      // json (default) or slack
+	Secret      string `yaml:"secret,omitempty"`      // signs webhooks; required as a bearer token by /v1/approvals, which is off without it
+	CallbackURL string `yaml:"callbackURL,omitempty"` // serve worker that accepts decisions
+	Timeout     string `yaml:"timeout,omitempty"`     // Go duration; default 10m
+}
+
+// EmbeddingsConfig selects the embedding model used by retrieval tools.
+// Credentials come from the matching providers.<name> entry.
+type EmbeddingsConfig struct {
+	Provider string `yaml:"provider,omitempty"` // openai, voyage, or google
+	Model    string `yaml:"model,omitempty"`
 }
 
 type PluginSource struct {
@@ -44,6 +317,46 @@ type ProviderConfig struct {
 	APIMode string            `yaml:"apiMode"`
 	Model   string            `yaml:"model"`            // global default model
 	Models  map[string]string `yaml:"models,omitempty"` // per-profile model overrides
+	// PromptCacheKey and ServerState tune the OpenAI responses API: the
+	// prompt_cache_key sent (empty derives one per profile), and whether to
+	// continue conversations with previous_response_id.
+	PromptCacheKey string `yaml:"promptCacheKey,omitempty"`
+	ServerState    bool   `yaml:"serverState,omitempty"`
+	// ThinkingBudget turns on Anthropic extended thinking with up to this
+	// many thinking tokens per turn.
+	ThinkingBudget int `yaml:"thinkingBudget,omitempty"`
+	// NoCache turns off Anthropic prompt caching, which bills the first
+	// write of each prefix above the input rate.
+	NoCache bool `yaml:"noCache,omitempty"`
+	// ServiceTier is the processing tier requested from OpenAI, Groq, or
+	// Cerebras, such as "flex"; empty leaves the account's default.
+	ServiceTier string `yaml:"serviceTier,omitempty"`
+	// StallTimeout aborts a response stream that receives nothing, not
+	// even a keepalive, for this long (a Go duration such as "60s"), and
+	// retries it as a transient failure. Empty waits for the HTTP client's
+	// timeout.
+	StallTimeout string `yaml:"stallTimeout,omitempty"`
+	// Organization and Project are sent to OpenAI as OpenAI-Organization
+	// and OpenAI-Project.
+	Organization string `yaml:"organization,omitempty"`
+	Project      string `yaml:"project,omitempty"`
+	// Beta lists the Anthropic beta features sent in anthropic-beta.
+	Beta []string `yaml:"beta,omitempty"`
+	// Headers and Query are added to every request to the provider, such
+	// as the HTTP-Referer and X-Title OpenRouter ranks apps by, or what an
+	// enterprise gateway routes on.
+	Headers map[string]string `yaml:"headers,omitempty"`
+	Query   map[string]string `yaml:"query,omitempty"`
+}
+
+// StallTimeout parses the stall timeout of the named provider; 0 means
+// none.
+func (c Config) StallTimeout(name string) (time.Duration, error) {
+	d, err := parseLimit(c.Providers[name].StallTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("providers.%s.stallTimeout: %w", name, err)
+	}
+	return d, nil
 }
 
 type PluginConfig struct {
@@ -74,8 +387,16 @@ func DefaultPaths(cwd string) (Paths, error) {
 		UserProfilesDir:  filepath.Join(configDir, "profiles"),
 		UserPluginsDir:   filepath.Join(configDir, "plugins"),
 		SessionsDir:      filepath.Join(configDir, "sessions"),
+		MemoryDir:        filepath.Join(configDir, "memory"),
+		ApprovalsDir:     filepath.Join(configDir, "approvals"),
+		SpendFile:        filepath.Join(configDir, "spend.json"),
+		UsageFile:        filepath.Join(configDir, "usage.json"),
+		AuditFile:        filepath.Join(configDir, "audit.jsonl"),
+		WorkflowsDir:     filepath.Join(configDir, "workflows"),
 		LocalProfilesDir: filepath.Join(absCWD, ".agent", "profiles"),
 		LocalPluginsDir:  filepath.Join(absCWD, ".agent", "plugins"),
+		UserSkillsDir:    filepath.Join(configDir, "skills"),
+		LocalSkillsDir:   filepath.Join(absCWD, ".agent", "skills"),
 	}, nil
 }
 
@@ -136,6 +457,53 @@ func applyEnvOverrides(cfg *Config) {
 	}
 }
 
+// ExpandModelAlias returns the model ID aliases maps name to, following
+// aliases of aliases, or name itself when it is not an alias.
+func ExpandModelAlias(aliases map[string]string, name string) string {
+	for range len(aliases) {
+		target, ok := aliases[name]
+		if !ok || target == "" || target == name {
+			break
+		}
+		name = target
+	}
+	return name
+}
+
+// ExpandModelChain expands aliases in a model chain (primary model first,
+// then fallbacks) and appends the configured fallbacks of each model in
+// it, so a chain configured for a fallback model applies too; a fallback
+// configured under an alias applies to the model it names. Fallbacks
+// serves rejects, such as models of another provider, are dropped, as are
+// duplicates. A nil serves keeps every model.
+func ExpandModelChain(aliases map[string]string, fallbacks map[string][]string, chain []string, serves func(model string) bool) []string {
+	expanded := make(map[string][]string, len(fallbacks))
+	for _, model := range slices.Sorted(maps.Keys(fallbacks)) {
+		target := ExpandModelAlias(aliases, model)
+		expanded[target] = append(expanded[target], fallbacks[model]...)
+	}
+	out := make([]string, 0, len(chain))
+	add := func(model string) {
+		model = ExpandModelAlias(aliases, model)
+		if model == "" || slices.Contains(out, model) {
+			return
+		}
+		if len(out) > 0 && serves != nil && !serves(model) {
+			return
+		}
+		out = append(out, model)
+	}
+	for _, model := range chain {
+		add(model)
+	}
+	for i := 0; i < len(out); i++ {
+		for _, next := range expanded[out[i]] {
+			add(next)
+		}
+	}
+	return out
+}
+
 // ResolveModel determines the model to use with the following priority:
 //  1. cliModel — explicit --model flag (highest priority)
 //  2. AGENT_MODEL env var
@@ -143,7 +511,13 @@ func applyEnvOverrides(cfg *Config) {
 //  4. Global default in config: providers.<name>.model
 //  5. Profile's own model: spec.provider.model
 //  6. Hardcoded fallback: gpt-4o
+//
+// The result has model aliases expanded.
 func ResolveModel(cfg Config, providerName, profileName, profileModel, cliModel string) string {
+	return ExpandModelAlias(cfg.ModelAliases, resolveModel(cfg, providerName, profileName, profileModel, cliModel))
+}
+
+func resolveModel(cfg Config, providerName, profileName, profileModel, cliModel string) string {
 	// 1. CLI flag
 	if cliModel != "" {
 		return cliModel
@@ -0,0 +1,22 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestToolsFilterMatchesQualifiedPatterns(t *testing.T) {
+	qualify := func(id string) string {
+		if id == "search" {
+			return "mcp:search"
+		}
+		return "builtin:" + id
+	}
+	got, err := ToolsConfig{Exclude: []string{"mcp:*", "core/git*"}}.Filter([]string{"core/read", "core/git_log", "search"}, qualify)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"core/read"}; !slices.Equal(got, want) {
+		t.Fatalf("Filter = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadEnvironment loads the config the way a run sees it: the file with
+// the named entry of its environments section deep-merged over it, then
+// ${VAR} references in its values replaced with the environment variables
+// they name. Mappings merge key by key; any other value in the overlay,
+// lists included, replaces the file's. An empty name merges nothing.
+//
+// Load, by contrast, returns the file as written, for commands that edit
+// and save it.
+func LoadEnvironment(paths Paths, name string) (Config, error) {
+	data, err := os.ReadFile(paths.ConfigFile)
+	if errors.Is(err, os.ErrNotExist) {
+		if name != "" {
+			return Config{}, fmt.Errorf("environment %q: no config file at %s", name, paths.ConfigFile)
+		}
+		return Load(paths)
+	}
+	if err != nil {
+		return Config{}, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return Config{}, err
+	}
+	var root *yaml.Node
+	if len(doc.Content) > 0 && doc.Content[0].Kind == yaml.MappingNode {
+		root = doc.Content[0]
+	} else {
+		root = &yaml.Node{Kind: yaml.MappingNode}
+	}
+	if name != "" {
+		overlay, err := environment(root, name)
+		if err != nil {
+			return Config{}, err
+		}
+		mergeNodes(root, overlay)
+	}
+	// The overlays have done their part; those not chosen may refer to
+	// variables that are only set where they are used.
+	removeKey(root, "environments")
+	expandNode(root)
+	var cfg Config
+	if err := root.Decode(&cfg); err != nil {
+		return Config{}, err
+	}
+	cfg.Environment = name
+	applyEnvOverrides(&cfg)
+	return cfg, nil
+}
+
+// environment returns the overlay named name in root's environments.
+func environment(root *yaml.Node, name string) (*yaml.Node, error) {
+	var names []string
+	if envs := mappingValue(root, "environments"); envs != nil && envs.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(envs.Content); i += 2 {
+			if envs.Content[i].Value == name {
+				if overlay := envs.Content[i+1]; overlay.Kind == yaml.MappingNode {
+					return overlay, nil
+				}
+				return nil, fmt.Errorf("environment %q: not a mapping", name)
+			}
+			names = append(names, envs.Content[i].Value)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("environment %q: the config defines no environments", name)
+	}
+	slices.Sort(names)
+	return nil, fmt.Errorf("environment %q not found (have %s)", name, strings.Join(names, ", "))
+}
+
+// mappingValue returns the value of key in the mapping node m, or nil.
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// removeKey deletes key and its value from the mapping node m.
+func removeKey(m *yaml.Node, key string) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			m.Content = slices.Delete(m.Content, i, i+2)
+			return
+		}
+	}
+}
+
+// mergeNodes merges the mapping overlay into the mapping base in place.
+func mergeNodes(base, overlay *yaml.Node) {
+	for i := 0; i+1 < len(overlay.Content); i += 2 {
+		key, value := overlay.Content[i], overlay.Content[i+1]
+		existing := mappingValue(base, key.Value)
+		switch {
+		case existing == nil:
+			base.Content = append(base.Content, key, value)
+		case existing.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode:
+			mergeNodes(existing, value)
+		default:
+			*existing = *value
+		}
+	}
+}
+
+// envReference matches ${NAME} in a config value.
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandNode replaces ${NAME} in the scalar values under n; a variable
+// that is not set expands to "", as in a shell.
+func expandNode(n *yaml.Node) {
+	if n.Kind == yaml.ScalarNode {
+		n.Value = envReference.ReplaceAllStringFunc(n.Value, func(ref string) string {
+			return os.Getenv(ref[2 : len(ref)-1])
+		})
+		return
+	}
+	for i, child := range n.Content {
+		if n.Kind == yaml.MappingNode && i%2 == 0 {
+			continue // keys are left alone
+		}
+		expandNode(child)
+	}
+}
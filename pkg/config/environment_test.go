@@ -0,0 +1,86 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const environmentsConfig = `defaultProfile: coder
+providers:
+  openai:
+    apiKey: ${TEST_OPENAI_KEY}
+    model: gpt-4o
+    serviceTier: default
+modelFallbacks:
+  gpt-4o: [gpt-4o-mini]
+environments:
+  cheap:
+    providers:
+      openai:
+        model: gpt-4o-mini
+    modelFallbacks:
+      gpt-4o-mini: []
+  azure-prod:
+    providers:
+      openai:
+        baseURL: https://${TEST_AZURE_HOST}/openai
+`
+
+func TestLoadEnvironmentMergesTheOverlayAndExpandsVariables(t *testing.T) {
+	dir := t.TempDir()
+	paths := Paths{ConfigDir: dir, ConfigFile: filepath.Join(dir, "config.yaml")}
+	if err := os.WriteFile(paths.ConfigFile, []byte(environmentsConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_OPENAI_KEY", "sk-test")
+	t.Setenv("TEST_AZURE_HOST", "example.azure.com")
+	for _, name := range []string{"OPENAI_BASE_URL", "OPENAI_API_KEY", "OPENAI_API_MODE", "OPENAI_MODEL"} {
+		t.Setenv(name, "")
+	}
+
+	cfg, err := LoadEnvironment(paths, "cheap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	openai := cfg.Providers["openai"]
+	if openai.Model != "gpt-4o-mini" || openai.APIKey != "sk-test" || openai.ServiceTier != "default" || cfg.DefaultProfile != "coder" {
+		t.Fatalf("expected the overlay merged over the file, got %+v", cfg)
+	}
+	if len(cfg.ModelFallbacks["gpt-4o"]) != 1 || cfg.ModelFallbacks["gpt-4o-mini"] == nil || cfg.Environment != "cheap" || cfg.Environments != nil {
+		t.Fatalf("unexpected merged config %+v", cfg)
+	}
+
+	cfg, err = LoadEnvironment(paths, "azure-prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Providers["openai"]; got.BaseURL != "https://example.azure.com/openai" || got.Model != "gpt-4o" {
+		t.Fatalf("unexpected azure-prod provider %+v", got)
+	}
+	if _, err := LoadEnvironment(paths, "staging"); err == nil || !strings.Contains(err.Error(), "azure-prod, cheap") {
+		t.Fatalf("expected an unknown environment error listing the others, got %v", err)
+	}
+
+	// The file as written survives an edit: variables stay unexpanded and
+	// the environments are kept.
+	raw, err := Load(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw.ApprovalMode = "always"
+	if err := Save(paths, raw); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := os.ReadFile(paths.ConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(saved), "${TEST_OPENAI_KEY}") || !strings.Contains(string(saved), "azure-prod:") {
+		t.Fatalf("expected the saved file to keep references and environments:\n%s", saved)
+	}
+	if cfg, err := LoadEnvironment(paths, "cheap"); err != nil || cfg.Providers["openai"].Model != "gpt-4o-mini" {
+		t.Fatalf("expected the saved environments to load, got %+v: %v", cfg, err)
+	}
+}
@@ -21,6 +21,86 @@ const (
 	TypeApprovalResult  Type = "approval_resolved"
 	TypeSessionSaved    Type = "session_saved"
 	TypeError           Type = "error"
+	TypeEmptyResponse   Type = "empty_response"
+	TypeSteering        Type = "steering_received"
+	TypeFollowUp        Type = "follow_up_received"
+	TypeStreamResumed   Type = "stream_resumed"
+	TypeBudgetWarning   Type = "budget_warning"
+	TypeBudgetExceeded  Type = "budget_exceeded"
+	TypeRetryGuidance   Type = "retry_guidance"
+	TypeUsageUpdate     Type = "usage_update"
+	TypeToolRetry       Type = "tool_retry"
+	TypeToolBudget      Type = "tool_budget"
+	TypeWorkflowStep    Type = "workflow_step"
+	TypeToolSelection   Type = "tool_selection"
+	TypeLanguage        Type = "language_detected"
+	TypeModelDeprecated Type = "model_deprecated"
+	TypeToolsetChanged  Type = "toolset_changed"
+	TypeConfigReloaded  Type = "config_reloaded"
+	// TypeArgumentsRejected reports a tool call not run because its
+	// arguments did not match the tool's schema; the model is asked to retry.
+	TypeArgumentsRejected Type = "arguments_rejected"
+	// TypeModelFallback reports a turn retried with the next model in the
+	// fallback chain after an error the failing model would repeat.
+	TypeModelFallback Type = "model_fallback"
+	// TypeReasoningDelta carries a chunk of the reasoning a model streams
+	// before its answer, for models that expose it.
+	TypeReasoningDelta Type = "reasoning_delta"
+	// TypeProgress carries running estimates of a turn's context and
+	// output tokens, and their cost, every few streamed deltas, so a UI
+	// can show a live meter before TypeTurnFinished reports the counts.
+	TypeProgress Type = "progress"
+	// TypeGuardrailTriggered reports a guardrail match in an assistant
+	// reply or tool result and the action taken; the matched text is not
+	// included.
+	TypeGuardrailTriggered Type = "guardrail_triggered"
+	// TypeToolOutput carries a chunk of output a tool streams while it
+	// runs, such as a shell command's; TypeToolFinished still carries the
+	// whole result.
+	TypeToolOutput Type = "tool_output"
+	// TypePlanUpdated carries the task list the model recorded with
+	// core/plan, as a session.Plan.
+	TypePlanUpdated Type = "plan_updated"
+	// TypeProviderSwitched marks a conversation continuing on another
+	// provider, with a session.ProviderSwitch.
+	TypeProviderSwitched Type = "provider_switched"
+	// TypeDeadlineExceeded marks a run stopped by its turn or wall-clock
+	// limit, with a session.Deadline.
+	TypeDeadlineExceeded Type = "deadline_exceeded"
+	// TypeToolLog carries a diagnostic line a tool logged through its
+	// tool.CallContext.
+	TypeToolLog Type = "tool_log"
+	// TypeSessionTitled carries the session.Title given to a new session
+	// after its first exchange.
+	TypeSessionTitled Type = "session_titled"
+	// TypeMessageEdited precedes the rerun of a conversation from an edited
+	// message, with a session.MessageEdit.
+	TypeMessageEdited Type = "message_edited"
+	// TypeHostedTool reports a call to, or result of, a tool the provider
+	// ran itself, such as web search. Data holds the tool, the block type,
+	// and the provider's block.
+	TypeHostedTool Type = "hosted_tool"
+	// TypeStreamStalled reports a response stream aborted after its
+	// provider's stall timeout passed without data; the turn is resumed
+	// or retried.
+	TypeStreamStalled Type = "stream_stalled"
+	// TypeSecurityWarning reports likely prompt injections found in an
+	// untrusted tool result and whether they were stripped; the matched
+	// text is not included.
+	TypeSecurityWarning Type = "security_warning"
+	// TypeThinkingAdjusted reports the thinking level of a run lowered as
+	// it nears its budget or slows down. Data holds the levels, the
+	// reason, and the turn after which it changed.
+	TypeThinkingAdjusted Type = "thinking_adjusted"
+	// TypeToolArgsPartial reports the arguments of a tool call as far as
+	// the model has streamed them, at most every 100ms per call. Data
+	// holds the tool and call IDs, the parsed args with long strings
+	// clipped, the chunk of text received since the previous event, the
+	// bytes received, and the key whose value is still streaming, if any.
+	TypeToolArgsPartial Type = "tool_args_partial"
+	// TypeFollowUpWaiting reports a run whose model is done waiting for
+	// scheduled follow-ups to come due. Data lists what they wait for.
+	TypeFollowUpWaiting Type = "follow_up_waiting"
 )
 
 type Event struct {
@@ -28,6 +108,9 @@ type Event struct {
 	Time    time.Time
 	Message string
 	Data    any
+	// Conversation identifies the conversation of a runtime.Manager the
+	// event belongs to; empty otherwise.
+	Conversation string
 }
 
 type Sink interface {
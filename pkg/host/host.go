@@ -74,6 +74,7 @@ type PipelineApprover interface {
 type SubRunRequest struct {
 	Task         string
 	Profile      string
+	Model        string // overrides the profile's model
 	MaxTurns     int
 	AllowedTools []string
 	Context      map[string]any // structured context passed from parent to child
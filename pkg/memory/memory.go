@@ -0,0 +1,28 @@
+package memory
+
+import (
+	"context"
+	"time"
+)
+
+// Note is one remembered fact, keyed so the agent can update it in place.
+type Note struct {
+	Key       string    `json:"key"`
+	Value     string    `json:"value"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Store persists notes across sessions. Implementations are scoped to a
+// single project (working directory).
+type Store interface {
+	Get(ctx context.Context, key string) (Note, bool, error)
+	List(ctx context.Context) ([]Note, error)
+	Put(ctx context.Context, key, value string) error
+	Delete(ctx context.Context, key string) error
+}
+
+// Stores opens the Store of each project.
+type Stores interface {
+	// For returns the store of the project in working directory cwd.
+	For(cwd string) Store
+}
@@ -119,6 +119,8 @@ type ToolDescriptor struct {
 	InputSchema map[string]any `yaml:"inputSchema,omitempty"`
 	Execution   ToolExecution  `yaml:"execution,omitempty"`
 	Risk        ToolRisk       `yaml:"risk,omitempty"`
+	// Capabilities is the tool's self-description; see tool.Capabilities.
+	Capabilities ToolCapabilities `yaml:"capabilities,omitempty"`
 }
 
 type ToolExecution struct {
@@ -131,3 +133,15 @@ type ToolExecution struct {
 type ToolRisk struct {
 	Level string `yaml:"level,omitempty"`
 }
+
+type ToolCapabilities struct {
+	SupportsStreaming bool     `yaml:"supportsStreaming,omitempty"`
+	SupportsCancel    bool     `yaml:"supportsCancel,omitempty"`
+	Concurrency       int      `yaml:"concurrency,omitempty"`
+	NeedsConfirmation *bool    `yaml:"needsConfirmation,omitempty"`
+	Tags              []string `yaml:"tags,omitempty"`
+	Cacheable         bool     `yaml:"cacheable,omitempty"`
+	Idempotent        bool     `yaml:"idempotent,omitempty"`
+	Serial            bool     `yaml:"serial,omitempty"`
+	Exclusive         []string `yaml:"exclusive,omitempty"`
+}
@@ -31,17 +31,24 @@ const (
 )
 
 type CheckRequest struct {
-	Action  Action
-	ToolID  string
-	Path    string
-	Command []string
-	Risk    RiskLevel
+	Action    Action
+	ToolID    string
+	Path      string
+	Command   []string
+	Risk      RiskLevel
+	Arguments map[string]any
+	// Tags and NeedsConfirmation come from the tool's declared capabilities.
+	Tags              []string
+	NeedsConfirmation *bool
 }
 
 type Decision struct {
 	Kind   DecisionKind
 	Reason string
 	Risk   RiskLevel
+	// Arguments, when non-nil, sanitizes the call: it runs with these
+	// arguments instead of the ones the model produced.
+	Arguments map[string]any `json:",omitempty"`
 }
 
 type Engine interface {
@@ -1,6 +1,10 @@
 package profile
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"time"
+)
 
 type Manifest struct {
 	APIVersion string   `yaml:"apiVersion"`
@@ -29,6 +33,13 @@ type Spec struct {
 	Workspace    WorkspaceSpec `yaml:"workspace"`
 	Session      SessionSpec   `yaml:"session"`
 	Policy       PolicySpec    `yaml:"policy"`
+	Budget       BudgetSpec    `yaml:"budget,omitempty"`
+	Language     LanguageSpec  `yaml:"language,omitempty"`
+	Guardrails   GuardrailSpec `yaml:"guardrails,omitempty"`
+	Injection    InjectionSpec `yaml:"injection,omitempty"`
+	// Skills names the skills offered to the model; "*" offers every
+	// discovered skill. Each adds its <skill>/* tools to Tools.Enabled.
+	Skills []string `yaml:"skills,omitempty"`
 }
 
 // Trigger defines an event that activates a service-mode agent.
@@ -38,17 +49,163 @@ type Trigger struct {
 }
 
 type Instructions struct {
-	System []string `yaml:"system"`
+	System   []string        `yaml:"system"`
+	Sections []PromptSection `yaml:"sections,omitempty"` // ordered prompt layout; defaults to instructions only
+	// ContextFiles controls the project context files (AGENTS.md,
+	// CLAUDE.md, .agent.md) of the "context" section.
+	ContextFiles ContextFilesSpec `yaml:"contextFiles,omitempty"`
+}
+
+// ContextFilesSpec controls the context files read from the working
+// directory up to its repository root.
+type ContextFilesSpec struct {
+	Disabled bool `yaml:"disabled,omitempty"`
+	MaxBytes int  `yaml:"maxBytes,omitempty"` // total cap; 0 uses 32KB
+}
+
+// PromptSection is one block of the composed system prompt. Built-in names
+// are instructions, context, date, cwd, os, tools and memory; any other
+// name needs a Template.
+type PromptSection struct {
+	Name     string `yaml:"name"`
+	Template string `yaml:"template,omitempty"` // Go template rendered with sysprompt.Data
+	Disabled bool   `yaml:"disabled,omitempty"`
 }
 
 type ProviderSpec struct {
-	Default  string   `yaml:"default"`
-	Model    string   `yaml:"model"`
-	Fallback []string `yaml:"fallback,omitempty"` // fallback models tried on failure
+	Default    string   `yaml:"default"`
+	Model      string   `yaml:"model"`
+	Fallback   []string `yaml:"fallback,omitempty"`   // fallback models tried on failure
+	RetryEmpty bool     `yaml:"retryEmpty,omitempty"` // retry once with a nudge when the model returns nothing
+	// HostedTools are tools the provider runs itself, such as web search,
+	// offered to the model alongside the enabled tools.
+	HostedTools []HostedTool `yaml:"hostedTools,omitempty"`
+	// Thinking is the reasoning effort asked of models that reason:
+	// "high", "medium", or "low"; empty leaves the provider's default.
+	Thinking          string            `yaml:"thinking,omitempty"`
+	ThinkingDowngrade ThinkingDowngrade `yaml:"thinkingDowngrade,omitempty"`
+	// Headers and Query are added to the provider's requests for this
+	// profile's runs, over those of the provider's configuration.
+	Headers map[string]string `yaml:"headers,omitempty"`
+	Query   map[string]string `yaml:"query,omitempty"`
+}
+
+// ThinkingDowngrade lowers the thinking level of a run a step at a time,
+// down to Min, so a long unattended run spends less and answers sooner
+// instead of stopping at its budget. A level is dropped when spend first
+// reaches AtBudget of a session or daily cap, again when it reaches
+// halfway from there to the cap, and after any turn whose model response,
+// not counting the tools it called, took longer than MaxTurnLatency. An unset Thinking is left to the provider and never
+// lowered.
+type ThinkingDowngrade struct {
+	AtBudget       float64 `yaml:"atBudget,omitempty"`       // fraction of a cap, e.g. 0.6
+	MaxTurnLatency string  `yaml:"maxTurnLatency,omitempty"` // a Go duration, e.g. "45s"
+	Min            string  `yaml:"min,omitempty"`            // the lowest level; default "low"
+}
+
+// Enabled reports whether anything lowers the level.
+func (t ThinkingDowngrade) Enabled() bool {
+	return t.AtBudget > 0 || t.MaxTurnLatency != ""
+}
+
+// HostedTool is a provider-native tool. Type is the provider's tool type
+// ("web_search", "code_execution", "file_search", ...) and Options are
+// sent with it unchanged.
+type HostedTool struct {
+	Type    string         `yaml:"type"`
+	Options map[string]any `yaml:"options,omitempty"`
 }
 
 type ToolSpec struct {
-	Enabled []string `yaml:"enabled"`
+	Enabled  []string                `yaml:"enabled"`
+	Settings map[string]ToolSettings `yaml:"settings,omitempty"` // keyed by tool ID
+	Budget   ToolBudget              `yaml:"budget,omitempty"`
+	Select   ToolSelect              `yaml:"select,omitempty"`
+	// ArgumentRetries checks tool arguments against each tool's schema and
+	// lets the model correct a rejected call this many times per turn
+	// without using up a turn. 0 passes arguments to tools unchecked.
+	ArgumentRetries int `yaml:"argumentRetries,omitempty"`
+	// Cache reuses the result of a repeated call to a cacheable tool (file
+	// reads and searches) for the rest of the run, until a tool that may
+	// change the workspace runs.
+	Cache bool `yaml:"cache,omitempty"`
+	// AbortGrace is how long a running tool may take to wind down and
+	// return its partial result when the run is aborted, as a Go duration
+	// ("5s"). Empty waits for the tool however long it takes.
+	AbortGrace string `yaml:"abortGrace,omitempty"`
+	// InjectPlan restates the task list kept with core/plan at the end of
+	// the system prompt each turn, so it survives compaction. The prompt,
+	// and with it the cached prefix, changes whenever the plan does.
+	InjectPlan bool `yaml:"injectPlan,omitempty"`
+}
+
+// AbortGraceDuration parses AbortGrace; 0 means no limit.
+func (t ToolSpec) AbortGraceDuration() (time.Duration, error) {
+	if t.AbortGrace == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(t.AbortGrace)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("tools.abortGrace: invalid duration %q", t.AbortGrace)
+	}
+	return d, nil
+}
+
+// Tool budget strategies.
+const (
+	ToolBudgetAuto   = "auto"   // trim, then fall back to search (default)
+	ToolBudgetTrim   = "trim"   // shorten descriptions and schemas only
+	ToolBudgetSearch = "search" // send pinned tools plus a search_tools meta-tool
+)
+
+// ToolBudget caps how much of the context tool definitions may take when
+// many tools are enabled, for example several MCP servers.
+type ToolBudget struct {
+	MaxTokens int      `yaml:"maxTokens,omitempty"` // estimated; 0 sends every definition in full
+	Strategy  string   `yaml:"strategy,omitempty"`
+	Pinned    []string `yaml:"pinned,omitempty"` // tool IDs always sent in full
+}
+
+// Tool selection strategies.
+const (
+	ToolSelectEmbedding = "embedding" // rank tools by similarity to the request
+	ToolSelectModel     = "model"     // ask a (cheap) model to pick tools
+)
+
+// ToolSelect sends only the tools most relevant to the current request,
+// chosen before each turn, for profiles with many tools enabled.
+type ToolSelect struct {
+	Strategy string   `yaml:"strategy,omitempty"` // embedding or model; empty disables selection
+	TopK     int      `yaml:"topK,omitempty"`     // tools chosen per request (default 8)
+	MinTools int      `yaml:"minTools,omitempty"` // select only when more tools than this are enabled (default 20)
+	Model    string   `yaml:"model,omitempty"`    // model strategy: the model to ask; defaults to the run's model
+	Pinned   []string `yaml:"pinned,omitempty"`   // tool IDs always sent
+}
+
+// ToolSettings adjust how a tool's subprocesses run, e.g. running core/bash
+// in a test directory with CI=1 while file tools stay at the workspace root.
+type ToolSettings struct {
+	CWD    string            `yaml:"cwd,omitempty"` // relative to the run's working directory
+	Env    map[string]string `yaml:"env,omitempty"`
+	Nice   int               `yaml:"nice,omitempty"`
+	IONice string            `yaml:"ionice,omitempty"` // idle, best-effort[:0-7], realtime[:0-7]
+	Umask  string            `yaml:"umask,omitempty"`  // octal, e.g. "027"
+	// MaxBytes truncates the tool's output to this many bytes; 0 keeps it
+	// whole.
+	MaxBytes int `yaml:"maxBytes,omitempty"`
+	// AllowedCommands restricts a shell tool (core/bash) to commands that
+	// run only these programs; empty allows any.
+	AllowedCommands []string `yaml:"allowedCommands,omitempty"`
+	// Persistent keeps one shell per session for core/bash, so directory
+	// changes and exported variables persist across calls. The shell
+	// starts in CWD with Env.
+	Persistent bool `yaml:"persistent,omitempty"`
+	// Serial, Exclusive, and MaxParallel add to what the tool declares
+	// about running in parallel (tool.Capabilities): MaxParallel replaces
+	// its Concurrency, and Exclusive groups are added to its own.
+	Serial      bool     `yaml:"serial,omitempty"`
+	Exclusive   []string `yaml:"exclusive,omitempty"`
+	MaxParallel int      `yaml:"maxParallel,omitempty"`
 }
 
 type ApprovalSpec struct {
@@ -59,6 +216,9 @@ type ApprovalSpec struct {
 type WorkspaceSpec struct {
 	Required   bool   `yaml:"required"`
 	WriteScope string `yaml:"writeScope"`
+	// Watch compares the workspace before and after each turn and records
+	// the files that changed, whichever tool changed them.
+	Watch bool `yaml:"watch,omitempty"`
 }
 
 type SessionSpec struct {
@@ -66,6 +226,84 @@ type SessionSpec struct {
 	Compaction  string `yaml:"compaction"`
 }
 
+// LanguageSpec handles multilingual conversations, where models tend to
+// drift back to English after a few tool calls.
+type LanguageSpec struct {
+	Detect bool `yaml:"detect,omitempty"` // detect the user's language and report it in events
+	Match  bool `yaml:"match,omitempty"`  // also tell the model to reply in it; implies detect
+}
+
+// Enabled reports whether the user's language is detected.
+func (l LanguageSpec) Enabled() bool {
+	return l.Detect || l.Match
+}
+
+// BudgetSpec caps model spend in USD, priced from the built-in model catalog.
+// Zero disables a cap. Daily spend is a rolling 24h window shared by all
+// sessions on the machine.
+type BudgetSpec struct {
+	MaxTurnUSD    float64            `yaml:"maxTurnUSD,omitempty"`
+	MaxSessionUSD float64            `yaml:"maxSessionUSD,omitempty"`
+	MaxDailyUSD   float64            `yaml:"maxDailyUSD,omitempty"`
+	MaxToolUSD    map[string]float64 `yaml:"maxToolUSD,omitempty"` // per tool ID, for tools that report costUSD in result data
+	WarnAt        float64            `yaml:"warnAt,omitempty"`     // fraction of a cap that triggers a warning; default 0.8
+	OnExceeded    string             `yaml:"onExceeded,omitempty"` // "stop" (default) or "approve" to ask the approval resolver
+}
+
+// Enabled reports whether any cap is set.
+func (b BudgetSpec) Enabled() bool {
+	return b.MaxTurnUSD > 0 || b.MaxSessionUSD > 0 || b.MaxDailyUSD > 0 || len(b.MaxToolUSD) > 0
+}
+
+// GuardrailSpec scans assistant replies and tool results for leaked
+// secrets, personal data, and denied phrases before they are kept in the
+// session or passed to the model.
+type GuardrailSpec struct {
+	Secrets bool `yaml:"secrets,omitempty"` // credential formats and high-entropy tokens
+	PII     bool `yaml:"pii,omitempty"`     // email addresses, phone, card, and US social security numbers
+	// Patterns are regular expressions to match; a first capture group,
+	// if any, is kept, so the name of a key=value pair stays readable.
+	Patterns []string `yaml:"patterns,omitempty"`
+	Deny     []string `yaml:"deny,omitempty"` // phrases matched case-insensitively
+	// Action is what a match does: "redact" (the default) replaces each
+	// match, "block" withholds a tool result or ends the run on an
+	// assistant reply, and "approve" asks the approval resolver and blocks
+	// if it declines.
+	Action string `yaml:"action,omitempty"`
+	Scope  string `yaml:"scope,omitempty"` // "all" (default), "assistant", or "tools"
+}
+
+// Enabled reports whether anything is scanned for.
+func (g GuardrailSpec) Enabled() bool {
+	return g.Secrets || g.PII || len(g.Patterns) > 0 || len(g.Deny) > 0
+}
+
+// Injection modes; see InjectionSpec.
+const (
+	InjectionStrip = "strip" // wrap, and remove likely injected instructions (default)
+	InjectionWrap  = "wrap"  // wrap, and only report likely injected instructions
+	InjectionOff   = "off"
+)
+
+// InjectionSpec defends against instructions planted in tool results from
+// sources the user does not control. An untrusted result is wrapped in
+// delimiters the system prompt tells the model to treat as data, and each
+// likely injection found in it is reported as a security_warning event.
+type InjectionSpec struct {
+	// Tools maps tool IDs, or path.Match patterns of them ("web/*"), to
+	// the mode for their output: "strip", "wrap", or "off". An empty mode
+	// is "strip".
+	Tools map[string]string `yaml:"tools,omitempty"`
+	// OutsideWorkspace is the mode for files core/read returns from
+	// outside the run's working directory; empty leaves them trusted.
+	OutsideWorkspace string `yaml:"outsideWorkspace,omitempty"`
+}
+
+// Enabled reports whether any tool output is treated as untrusted.
+func (i InjectionSpec) Enabled() bool {
+	return len(i.Tools) > 0 || i.OutsideWorkspace != ""
+}
+
 type PolicySpec struct {
 	Overlays []string `yaml:"overlays"`
 }
@@ -0,0 +1,114 @@
+package provider
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrorKind classifies a failed API request by what retrying it can do.
+type ErrorKind string
+
+const (
+	ErrorRateLimited ErrorKind = "rate_limited" // 429: wait, as long as Retry-After says when it is given
+	ErrorOverloaded  ErrorKind = "overloaded"   // 503, 529: the provider is busy; back off longer
+	ErrorServer      ErrorKind = "server"       // other 5xx: retry soon
+	ErrorAuth        ErrorKind = "auth"         // 401, 403: the key is wrong; retrying will not help
+	ErrorQuota       ErrorKind = "quota"        // credit or quota spent; retrying will not help
+	ErrorRequest     ErrorKind = "request"      // other 4xx: the request itself was refused
+)
+
+// APIError is a provider API request answered with an error status.
+// Providers return it, wrapped with their name, for a completion that
+// fails before streaming starts.
+type APIError struct {
+	Status  int    // the HTTP status code
+	Code    string // the provider's error code or type, such as "rate_limit_exceeded" or "overloaded_error"
+	Message string // the provider's error message, when the body has one
+	Body    string // the response body
+	// RetryAfter is how long the provider asked to wait, from Retry-After
+	// or retry-after-ms; 0 when it did not say.
+	RetryAfter time.Duration
+}
+
+// NewAPIError builds the error of resp, whose body has been read.
+func NewAPIError(resp *http.Response, body []byte) *APIError {
+	e := &APIError{Status: resp.StatusCode, Body: strings.TrimSpace(string(body)), RetryAfter: RetryAfter(resp.Header, time.Now())}
+	e.Code, e.Message = errorCode(body)
+	return e
+}
+
+func (e *APIError) Error() string {
+	status := strings.TrimSpace(strconv.Itoa(e.Status) + " " + http.StatusText(e.Status))
+	return status + ": " + e.Body
+}
+
+// Kind classifies the error by its status and code.
+func (e *APIError) Kind() ErrorKind {
+	code := strings.ToLower(e.Code)
+	switch {
+	case e.Status == http.StatusUnauthorized || e.Status == http.StatusForbidden:
+		return ErrorAuth
+	case strings.Contains(code, "quota") || strings.Contains(code, "billing") || e.Status == http.StatusPaymentRequired:
+		return ErrorQuota
+	case e.Status == http.StatusTooManyRequests || strings.Contains(code, "rate_limit") || code == "resource_exhausted":
+		return ErrorRateLimited
+	case e.Status == http.StatusServiceUnavailable || e.Status == 529 || strings.Contains(code, "overloaded"):
+		return ErrorOverloaded
+	case e.Status >= 500:
+		return ErrorServer
+	}
+	return ErrorRequest
+}
+
+// ErrorKindOf returns the kind of the APIError in err's chain, or "" when
+// there is none.
+func ErrorKindOf(err error) ErrorKind {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Kind()
+	}
+	return ""
+}
+
+// RetryAfter reads how long a response asks the client to wait: the
+// retry-after-ms header some providers send, or Retry-After as seconds or
+// an HTTP date. It is 0 when neither is set.
+func RetryAfter(header http.Header, now time.Time) time.Duration {
+	if ms, err := strconv.ParseFloat(header.Get("retry-after-ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	value := strings.TrimSpace(header.Get("Retry-After"))
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+// errorCode reads the code and message of an error body in the shapes
+// providers use: {"error": {"code" or "type" or "status", "message"}}, or
+// the same fields at the top level.
+func errorCode(body []byte) (code, message string) {
+	var parsed map[string]any
+	if json.Unmarshal(body, &parsed) != nil {
+		return "", ""
+	}
+	fields := parsed
+	if nested, ok := parsed["error"].(map[string]any); ok {
+		fields = nested
+	}
+	for _, key := range []string{"code", "type", "status"} {
+		if s, ok := fields[key].(string); ok && s != "" && s != "error" {
+			code = s
+			break
+		}
+	}
+	message, _ = fields["message"].(string)
+	return code, message
+}
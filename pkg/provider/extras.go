@@ -0,0 +1,37 @@
+package provider
+
+import (
+	"maps"
+	"net/http"
+)
+
+// ApplyExtras sets headers and adds query parameters, configured for a
+// provider or asked for by a request, on an HTTP request the provider
+// makes. Headers replace any of the same name the provider set itself.
+func ApplyExtras(req *http.Request, headers, query map[string]string) {
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	if len(query) == 0 {
+		return
+	}
+	values := req.URL.Query()
+	for name, value := range query {
+		values.Set(name, value)
+	}
+	req.URL.RawQuery = values.Encode()
+}
+
+// MergeExtras returns base with the entries of extra added over its own.
+// base is not modified.
+func MergeExtras(base, extra map[string]string) map[string]string {
+	if len(extra) == 0 {
+		return base
+	}
+	merged := maps.Clone(base)
+	if merged == nil {
+		merged = make(map[string]string, len(extra))
+	}
+	maps.Copy(merged, extra)
+	return merged
+}
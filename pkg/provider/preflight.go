@@ -0,0 +1,87 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Validator is implemented by providers that can check their API key, base
+// URL, and access to a model with a cheap request, so misconfiguration is
+// reported before the first prompt rather than when it fails mid-stream.
+type Validator interface {
+	Validate(ctx context.Context, model string) error
+}
+
+// PreflightKind classifies why a preflight check failed.
+type PreflightKind string
+
+const (
+	PreflightAuth          PreflightKind = "auth"            // the API key is missing, invalid, or not allowed
+	PreflightModelNotFound PreflightKind = "model_not_found" // the endpoint does not serve the model
+	PreflightNetwork       PreflightKind = "network"         // the base URL could not be reached
+	PreflightOther         PreflightKind = "other"           // any other failure, such as a server error
+	// PreflightUnverified is not a failure: the endpoint could not confirm
+	// the model, as with a compatible server that does not list its models.
+	// Callers report it as a warning.
+	PreflightUnverified PreflightKind = "unverified"
+)
+
+// PreflightError is a failed preflight check.
+type PreflightError struct {
+	Provider string
+	Model    string
+	Kind     PreflightKind
+	Err      error
+}
+
+func (e *PreflightError) Error() string {
+	switch e.Kind {
+	case PreflightAuth:
+		return fmt.Sprintf("%s: authentication failed (check the API key): %v", e.Provider, e.Err)
+	case PreflightModelNotFound:
+		return fmt.Sprintf("%s: model %q not found or not available to this key: %v", e.Provider, e.Model, e.Err)
+	case PreflightNetwork:
+		return fmt.Sprintf("%s: cannot reach the API (check the base URL and network): %v", e.Provider, e.Err)
+	case PreflightUnverified:
+		return fmt.Sprintf("%s: could not confirm model %q: %v", e.Provider, e.Model, e.Err)
+	}
+	return fmt.Sprintf("%s: preflight failed: %v", e.Provider, e.Err)
+}
+
+func (e *PreflightError) Unwrap() error { return e.Err }
+
+// PreflightStatus returns the kind of failure an HTTP status reports.
+func PreflightStatus(status int) PreflightKind {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return PreflightAuth
+	case http.StatusNotFound:
+		return PreflightModelNotFound
+	}
+	return PreflightOther
+}
+
+// Preflight checks that p can serve model. Providers that are not
+// Validators pass unchecked. Failures are returned as a *PreflightError;
+// errors a Validator returns of another type are classified as network
+// errors unless ctx was canceled.
+func Preflight(ctx context.Context, p Provider, model string) error {
+	v, ok := p.(Validator)
+	if !ok {
+		return nil
+	}
+	err := v.Validate(ctx, model)
+	if err == nil {
+		return nil
+	}
+	var preflight *PreflightError
+	if errors.As(err, &preflight) {
+		return err
+	}
+	if ctx.Err() != nil {
+		return err
+	}
+	return &PreflightError{Provider: p.Name(), Model: model, Kind: PreflightNetwork, Err: err}
+}
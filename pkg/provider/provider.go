@@ -2,6 +2,8 @@ package provider
 
 import (
 	"context"
+	"encoding/json"
+	"time"
 
 	"github.com/bitop-dev/agent/pkg/tool"
 )
@@ -17,6 +19,49 @@ type Message struct {
 	ToolCallID string
 	ToolName   string
 	ToolCalls  []tool.Call
+	// Raw holds content blocks of types the runtime does not model, in the
+	// order the provider returned them.
+	Raw []RawContent `json:",omitempty"`
+	// Images are sent with a user message or a tool result: to Anthropic
+	// ahead of the message's text, as its API recommends, and to OpenAI and
+	// Cohere after it.
+	Images []Image `json:",omitempty"`
+	// Documents are sent with a user message, ahead of its text.
+	Documents []Document `json:",omitempty"`
+	// Labels attribute a message, such as its source, user, or git commit.
+	// They are stored with the session and never sent to the model.
+	Labels map[string]string `json:",omitempty"`
+}
+
+// Image is an image attached to a message. Data holds the encoded image
+// (PNG, JPEG, GIF, or WebP); it is base64 encoded in JSON.
+type Image struct {
+	MediaType string `json:"mediaType"`
+	Data      []byte `json:"data"`
+}
+
+// Document is a file the model reads directly, such as a PDF, attached to
+// a message. Data holds the file, base64 encoded in JSON, or FileID names
+// a file the caller already uploaded to the provider's files API.
+type Document struct {
+	Name      string `json:"name,omitempty"`
+	MediaType string `json:"mediaType"`
+	Data      []byte `json:"data,omitempty"`
+	FileID    string `json:"fileId,omitempty"`
+}
+
+// RawContent is a provider content block of a type the runtime does not
+// model yet (citations, annotations, server-side tool results, signed
+// thinking and redacted thinking). It is kept
+// verbatim so it survives sessions and round-trips; only the provider that
+// produced it sends it back.
+type RawContent struct {
+	Provider string          `json:"provider"`
+	Type     string          `json:"type"`
+	Data     json.RawMessage `json:"data"`
+	// HostedTool names the hosted tool a block is a call to or a result
+	// of, such as "web_search"; empty for other blocks.
+	HostedTool string `json:"hostedTool,omitempty"`
 }
 
 type StreamEventType string
@@ -25,6 +70,21 @@ const (
 	StreamEventText     StreamEventType = "text"
 	StreamEventToolCall StreamEventType = "tool_call"
 	StreamEventDone     StreamEventType = "done"
+	// StreamEventUsage carries running token counts for the turn so far,
+	// for providers that report usage during generation. The final counts
+	// still arrive on StreamEventDone.
+	StreamEventUsage StreamEventType = "usage"
+	// StreamEventRaw carries a content block the runtime does not model in
+	// Raw; it is attached to the assistant message unchanged.
+	StreamEventRaw StreamEventType = "raw"
+	// StreamEventReasoning carries a chunk of the reasoning a model streams
+	// before its answer (DeepSeek's reasoning_content) in Text. It is not
+	// part of the answer and is not sent back.
+	StreamEventReasoning StreamEventType = "reasoning"
+	// StreamEventToolCallDelta carries a chunk of a tool call's arguments
+	// JSON in Text as the model streams it, with the ID and ToolID of
+	// ToolCall. The whole call still arrives as StreamEventToolCall.
+	StreamEventToolCallDelta StreamEventType = "tool_call_delta"
 )
 
 type StreamEvent struct {
@@ -32,15 +92,127 @@ type StreamEvent struct {
 	Text         string
 	ToolCall     tool.Call
 	Err          error
-	InputTokens  int // set on StreamEventDone if provider reports usage
-	OutputTokens int // set on StreamEventDone if provider reports usage
+	InputTokens  int // set on StreamEventDone and StreamEventUsage if provider reports usage
+	OutputTokens int // set on StreamEventDone and StreamEventUsage if provider reports usage
+	// ReasoningTokens is the part of OutputTokens spent on hidden reasoning
+	// (thinking) rather than visible output. It is already included in
+	// OutputTokens and so billed at the output rate.
+	ReasoningTokens int
+	// CacheReadTokens is the part of InputTokens served from the
+	// provider's prompt cache, when reported. Set alongside InputTokens.
+	CacheReadTokens int
+	// CacheWriteTokens is the part of InputTokens written to the
+	// provider's prompt cache, billed at its cache write rate.
+	CacheWriteTokens int
+	Raw              RawContent // set on StreamEventRaw
+	// Timing is the server-side timing of the call, set on StreamEventDone
+	// by providers that report it.
+	Timing *Timing
+}
+
+// Timing is how long a provider spent on a call, as it reports it.
+type Timing struct {
+	Queue      time.Duration // waiting for capacity before processing started
+	Prompt     time.Duration // processing the input
+	Completion time.Duration // generating the output
+}
+
+// PromptThroughput returns the input tokens processed per second, or 0
+// without a prompt time.
+func (t Timing) PromptThroughput(inputTokens int) float64 {
+	if t.Prompt <= 0 {
+		return 0
+	}
+	return float64(inputTokens) / t.Prompt.Seconds()
+}
+
+// OutputThroughput returns the output tokens generated per second, or 0
+// without a completion time.
+func (t Timing) OutputThroughput(outputTokens int) float64 {
+	if t.Completion <= 0 {
+		return 0
+	}
+	return float64(outputTokens) / t.Completion.Seconds()
 }
 
 type CompletionRequest struct {
 	Model    ModelRef
 	System   string
 	Messages []Message
+	// Trailing is how many of the last Messages are sent with this request
+	// only, such as a retry nudge or a note on the reply language. Providers
+	// that mark the conversation for caching mark it before them, so the
+	// next request's prefix still matches.
+	Trailing int
 	Tools    []tool.Definition
+	// ToolChoice controls whether the model calls one of Tools. Empty
+	// leaves it to the model.
+	ToolChoice ToolChoice
+	// HostedTools are tools the provider runs itself, offered alongside
+	// Tools. Their calls and results come back as StreamEventRaw blocks
+	// with RawContent.HostedTool set.
+	HostedTools []HostedTool
+	// Thinking is how much the model reasons before answering, for models
+	// that do. Empty leaves it to the provider's configuration.
+	Thinking ThinkingLevel
+	// ExtraHeaders and ExtraQuery are added to the provider's HTTP
+	// requests for this completion, over those it is configured with.
+	ExtraHeaders map[string]string
+	ExtraQuery   map[string]string
+	// NoCache asks the provider not to write this request to its prompt
+	// cache, for one-off requests whose prefix no later request reuses.
+	NoCache bool
+}
+
+// ThinkingLevel is how much reasoning a model is asked for: one of the
+// ThinkingLevel constants, or "" for the provider's default.
+type ThinkingLevel string
+
+const (
+	ThinkingHigh   ThinkingLevel = "high"
+	ThinkingMedium ThinkingLevel = "medium"
+	ThinkingLow    ThinkingLevel = "low"
+)
+
+// Lower returns the level below l, or false when l is ThinkingLow or the
+// provider's default, which may leave thinking off: lowering it could turn
+// thinking on, or send a level to a model that does not reason.
+func (l ThinkingLevel) Lower() (ThinkingLevel, bool) {
+	switch l {
+	case ThinkingHigh:
+		return ThinkingMedium, true
+	case ThinkingMedium:
+		return ThinkingLow, true
+	}
+	return l, false
+}
+
+// HostedTool is a provider-native tool, such as Anthropic's or OpenAI's
+// web search. Type is the provider's tool type; "web_search" maps to the
+// current version of each provider's search tool. Options are sent with
+// it as the provider documents them (max_uses, allowed_domains, ...).
+type HostedTool struct {
+	Type    string         `json:"type"`
+	Options map[string]any `json:"options,omitempty"`
+}
+
+// ToolChoice controls whether the model calls a tool on a turn: one of the
+// ToolChoice constants, or the ID of the tool it must call.
+type ToolChoice string
+
+const (
+	ToolChoiceAuto     ToolChoice = "auto"     // the model decides
+	ToolChoiceNone     ToolChoice = "none"     // the model answers without calling tools
+	ToolChoiceRequired ToolChoice = "required" // the model calls at least one tool
+)
+
+// Tool returns the ID of the tool c forces, if it forces one.
+func (c ToolChoice) Tool() (string, bool) {
+	switch c {
+	case "", ToolChoiceAuto, ToolChoiceNone, ToolChoiceRequired:
+		return "", false
+	}
+	return string(c), true
 }
 
 type Provider interface {
@@ -48,6 +220,40 @@ type Provider interface {
 	Stream(ctx context.Context, req CompletionRequest) (<-chan StreamEvent, error)
 }
 
+// Prefiller is implemented by providers that continue a trailing assistant
+// message rather than starting a new turn (assistant "pre-fill"). The runtime
+// uses it to resume a stream that died mid-generation without regenerating
+// the text already received.
+type Prefiller interface {
+	SupportsPrefill(model string) bool
+}
+
+// VisionSupporter is implemented by providers that can tell which models
+// accept images. Tools only return images to models it reports true for.
+type VisionSupporter interface {
+	SupportsVision(model string) bool
+}
+
+// ToolCallIDChecker is implemented by providers that accept only some tool
+// call IDs in the history they are sent. A history carried over from
+// another provider has the IDs it rejects rewritten; see
+// runtime.NormalizeHistory.
+type ToolCallIDChecker interface {
+	ValidToolCallID(id string) bool
+}
+
+// EmbeddingRequest asks for one vector per input, in order.
+type EmbeddingRequest struct {
+	Model  string
+	Inputs []string
+}
+
+// Embedder turns text into vectors for semantic retrieval. It is separate
+// from Provider because some embedding vendors offer no chat models.
+type Embedder interface {
+	Embed(ctx context.Context, req EmbeddingRequest) ([][]float32, error)
+}
+
 type Registry interface {
 	Register(provider Provider) error
 	Get(name string) (Provider, bool)
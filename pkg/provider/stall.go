@@ -0,0 +1,66 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// ErrStreamStalled matches, with errors.Is, the error of a stream that went
+// quiet mid-response for longer than its provider's stall timeout. The
+// runtime treats it as a transient failure and retries the turn.
+var ErrStreamStalled = errors.New("stream stalled")
+
+// StallError is the error a stalled stream fails with.
+type StallError struct {
+	Timeout time.Duration
+}
+
+func (e *StallError) Error() string {
+	return fmt.Sprintf("stream stalled: nothing received for %s", e.Timeout)
+}
+
+func (e *StallError) Is(target error) bool { return target == ErrStreamStalled }
+
+// WatchStalls returns body wrapped so that when no data arrives for
+// timeout, cancel is called to abort the request and the pending read
+// fails with a *StallError. Any data, SSE keepalives and comments
+// included, resets the clock. A timeout of 0 returns body unchanged.
+func WatchStalls(body io.ReadCloser, timeout time.Duration, cancel context.CancelFunc) io.ReadCloser {
+	if timeout <= 0 {
+		return body
+	}
+	w := &stallWatcher{body: body, err: &StallError{Timeout: timeout}, timeout: timeout}
+	w.timer = time.AfterFunc(timeout, func() {
+		w.stalled.Store(true)
+		cancel()
+	})
+	return w
+}
+
+type stallWatcher struct {
+	body    io.ReadCloser
+	err     *StallError
+	timeout time.Duration
+	timer   *time.Timer
+	stalled atomic.Bool
+}
+
+func (w *stallWatcher) Read(p []byte) (int, error) {
+	n, err := w.body.Read(p)
+	if w.stalled.Load() {
+		return n, w.err
+	}
+	if n > 0 {
+		w.timer.Reset(w.timeout)
+	}
+	return n, err
+}
+
+func (w *stallWatcher) Close() error {
+	w.timer.Stop()
+	return w.body.Close()
+}
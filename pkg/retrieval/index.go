@@ -0,0 +1,248 @@
+// Package retrieval provides a small on-disk vector index over workspace
+// files. It is deliberately simple — brute-force cosine similarity over a gob
+// file — which is fast enough for repositories with tens of thousands of
+// chunks and needs no external service.
+package retrieval
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+)
+
+const (
+	chunkLines   = 60
+	chunkOverlap = 10
+	embedBatch   = 64
+	maxFileBytes = 256 * 1024
+)
+
+// Chunk is one embedded span of a file.
+type Chunk struct {
+	Path      string // relative to the index root
+	StartLine int
+	EndLine   int
+	Text      string
+	Vector    []float32
+}
+
+// Result is a search hit.
+type Result struct {
+	Chunk
+	Score float64
+}
+
+type fileState struct {
+	ModTime int64
+	Size    int64
+}
+
+type snapshot struct {
+	Model  string
+	Files  map[string]fileState
+	Chunks []Chunk
+}
+
+// Index is a persisted set of embedded chunks for one root directory.
+type Index struct {
+	Path     string // gob file location
+	Root     string
+	Model    string
+	Embedder provider.Embedder
+
+	files  map[string]fileState
+	chunks []Chunk
+}
+
+// Open loads an existing index from path, or starts an empty one. An index
+// built with a different embedding model is discarded.
+func Open(path, root string, embedder provider.Embedder, model string) (*Index, error) {
+	ix := &Index{Path: path, Root: root, Model: model, Embedder: embedder, files: map[string]fileState{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ix, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snap snapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snap); err != nil {
+		return ix, nil // corrupt index — rebuild from scratch
+	}
+	if snap.Model != model {
+		return ix, nil
+	}
+	ix.files = snap.Files
+	ix.chunks = snap.Chunks
+	if ix.files == nil {
+		ix.files = map[string]fileState{}
+	}
+	return ix, nil
+}
+
+// Len reports the number of indexed chunks.
+func (ix *Index) Len() int { return len(ix.chunks) }
+
+// Update re-embeds files (relative to Root) whose size or mtime changed and
+// drops files that are no longer listed. It returns the number of files
+// re-embedded.
+func (ix *Index) Update(ctx context.Context, files []string) (int, error) {
+	if ix.Embedder == nil {
+		return 0, errors.New("retrieval: embedder is not configured")
+	}
+	listed := make(map[string]bool, len(files))
+	var changed []string
+	for _, rel := range files {
+		listed[rel] = true
+		info, err := os.Stat(filepath.Join(ix.Root, rel))
+		if err != nil {
+			continue
+		}
+		state := fileState{ModTime: info.ModTime().UnixNano(), Size: info.Size()}
+		if prev, ok := ix.files[rel]; ok && prev == state {
+			continue
+		}
+		changed = append(changed, rel)
+		ix.files[rel] = state
+	}
+	drop := make(map[string]bool)
+	for rel := range ix.files {
+		if !listed[rel] {
+			drop[rel] = true
+			delete(ix.files, rel)
+		}
+	}
+	for _, rel := range changed {
+		drop[rel] = true
+	}
+	kept := ix.chunks[:0]
+	for _, c := range ix.chunks {
+		if !drop[c.Path] {
+			kept = append(kept, c)
+		}
+	}
+	ix.chunks = kept
+
+	var pending []Chunk
+	for _, rel := range changed {
+		data, err := os.ReadFile(filepath.Join(ix.Root, rel))
+		if err != nil || len(data) > maxFileBytes || bytes.IndexByte(data, 0) >= 0 {
+			continue
+		}
+		pending = append(pending, chunkFile(rel, string(data))...)
+	}
+	for start := 0; start < len(pending); start += embedBatch {
+		end := min(start+embedBatch, len(pending))
+		inputs := make([]string, 0, end-start)
+		for _, c := range pending[start:end] {
+			inputs = append(inputs, c.Path+"\n"+c.Text)
+		}
+		vectors, err := ix.Embedder.Embed(ctx, provider.EmbeddingRequest{Model: ix.Model, Inputs: inputs})
+		if err != nil {
+			return 0, fmt.Errorf("retrieval: embed: %w", err)
+		}
+		for i := range vectors {
+			if len(vectors[i]) == 0 {
+				continue
+			}
+			c := pending[start+i]
+			c.Vector = normalize(vectors[i])
+			ix.chunks = append(ix.chunks, c)
+		}
+	}
+	return len(changed), nil
+}
+
+// Search returns the k chunks most similar to query.
+func (ix *Index) Search(ctx context.Context, query string, k int) ([]Result, error) {
+	if ix.Embedder == nil {
+		return nil, errors.New("retrieval: embedder is not configured")
+	}
+	vectors, err := ix.Embedder.Embed(ctx, provider.EmbeddingRequest{Model: ix.Model, Inputs: []string{query}})
+	if err != nil {
+		return nil, fmt.Errorf("retrieval: embed query: %w", err)
+	}
+	if len(vectors) == 0 || len(vectors[0]) == 0 {
+		return nil, errors.New("retrieval: empty query embedding")
+	}
+	q := normalize(vectors[0])
+	results := make([]Result, 0, len(ix.chunks))
+	for _, c := range ix.chunks {
+		if len(c.Vector) != len(q) {
+			continue
+		}
+		results = append(results, Result{Chunk: c, Score: dot(q, c.Vector)})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if k > 0 && len(results) > k {
+		results = results[:k]
+	}
+	return results, nil
+}
+
+// Save writes the index atomically.
+func (ix *Index) Save() error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(snapshot{Model: ix.Model, Files: ix.files, Chunks: ix.chunks}); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ix.Path), 0o755); err != nil {
+		return err
+	}
+	tmp := ix.Path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ix.Path)
+}
+
+// chunkFile splits text into overlapping line windows.
+func chunkFile(rel, text string) []Chunk {
+	lines := strings.Split(text, "\n")
+	var out []Chunk
+	for start := 0; start < len(lines); start += chunkLines - chunkOverlap {
+		end := min(start+chunkLines, len(lines))
+		body := strings.TrimSpace(strings.Join(lines[start:end], "\n"))
+		if body != "" {
+			out = append(out, Chunk{Path: rel, StartLine: start + 1, EndLine: end, Text: body})
+		}
+		if end == len(lines) {
+			break
+		}
+	}
+	return out
+}
+
+func normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return v
+	}
+	norm := float32(1 / math.Sqrt(sum))
+	out := make([]float32, len(v))
+	for i, x := range v {
+		out[i] = x * norm
+	}
+	return out
+}
+
+func dot(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
@@ -0,0 +1,97 @@
+package retrieval
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+)
+
+// keywordEmbedder maps text onto a tiny bag-of-words space so similarity is
+// predictable in tests.
+type keywordEmbedder struct {
+	vocab []string
+	calls int
+}
+
+func (e *keywordEmbedder) Embed(_ context.Context, req provider.EmbeddingRequest) ([][]float32, error) {
+	e.calls++
+	out := make([][]float32, len(req.Inputs))
+	for i, input := range req.Inputs {
+		vec := make([]float32, len(e.vocab))
+		for j, word := range e.vocab {
+			vec[j] = float32(strings.Count(strings.ToLower(input), word))
+		}
+		out[i] = vec
+	}
+	return out, nil
+}
+
+func TestIndexSearchAndIncrementalUpdate(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "auth.go", "func login() { checkPassword() }\n")
+	writeFile(t, root, "db.go", "func query() { openDatabase() }\n")
+	embedder := &keywordEmbedder{vocab: []string{"password", "database"}}
+	indexFile := filepath.Join(t.TempDir(), "index.gob")
+
+	ix, err := Open(indexFile, root, embedder, "test-model")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if n, err := ix.Update(context.Background(), []string{"auth.go", "db.go"}); err != nil || n != 2 {
+		t.Fatalf("Update = %d, %v; want 2 files", n, err)
+	}
+	results, err := ix.Search(context.Background(), "where is the password checked", 1)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(results) != 1 || results[0].Path != "auth.go" || results[0].StartLine != 1 {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if err := ix.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	reopened, err := Open(indexFile, root, embedder, "test-model")
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if reopened.Len() != 2 {
+		t.Fatalf("reopened index has %d chunks, want 2", reopened.Len())
+	}
+	if n, _ := reopened.Update(context.Background(), []string{"auth.go"}); n != 0 {
+		t.Fatalf("unchanged file re-embedded: %d", n)
+	}
+	if reopened.Len() != 1 {
+		t.Fatalf("removed file still indexed: %d chunks", reopened.Len())
+	}
+
+	other, _ := Open(indexFile, root, embedder, "other-model")
+	if other.Len() != 0 {
+		t.Fatal("index built with a different model should be discarded")
+	}
+}
+
+func TestChunkFileOverlaps(t *testing.T) {
+	lines := make([]string, 130)
+	for i := range lines {
+		lines[i] = "line"
+	}
+	chunks := chunkFile("a.txt", strings.Join(lines, "\n"))
+	if len(chunks) != 3 {
+		t.Fatalf("got %d chunks, want 3", len(chunks))
+	}
+	if chunks[1].StartLine != 51 || chunks[1].EndLine != 110 || chunks[2].EndLine != 130 {
+		t.Fatalf("unexpected chunk ranges: %+v", chunks)
+	}
+}
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,191 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/events"
+	"github.com/bitop-dev/agent/pkg/provider"
+	"github.com/bitop-dev/agent/pkg/session"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// Answer is one question of an Ask and the run of the fork that answered it.
+type Answer struct {
+	Question string
+	Result   RunResult
+	Err      error
+}
+
+// AskResult is the outcome of Ask. Its RunResult describes the merged
+// answer as if it were one run: Output is the merged message, Transcript
+// ends with it, and usage, cost, and tool steps are summed over the forks.
+type AskResult struct {
+	RunResult
+	Answers []Answer // in the order the questions were given
+}
+
+// Ask answers independent questions in parallel, each in a temporary fork
+// of the conversation that sees the history so far but not the other
+// questions. The forks are not saved; the questions and the merged answers
+// are appended to the conversation as one user and one assistant message.
+// Forks stream no text deltas; other events still reach Request.Events and
+// may interleave. Ask fails with ErrBusy while a prompt is running, and
+// with the first error only if every fork failed.
+func (c *Conversation) Ask(ctx context.Context, questions []string) (AskResult, error) {
+	if len(questions) == 0 {
+		return AskResult{}, errors.New("ask requires at least one question")
+	}
+	c.mu.Lock()
+	if c.running {
+		c.mu.Unlock()
+		return AskResult{}, ErrBusy
+	}
+	c.running = true
+	base := c.Request
+	c.mu.Unlock()
+	defer c.next()
+
+	answers := make([]Answer, len(questions))
+	parallel := c.AskParallel
+	if parallel <= 0 || parallel > len(questions) {
+		parallel = len(questions)
+	}
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, question := range questions {
+		answers[i].Question = question
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+				answers[i].Err = ctx.Err()
+				return
+			}
+			answers[i].Result, answers[i].Err = c.Runner.Run(ctx, forkRequest(base, question))
+		}()
+	}
+	wg.Wait()
+
+	var result AskResult
+	result.Answers = answers
+	var firstErr error
+	failed := 0
+	var merged, asked strings.Builder
+	for i, answer := range answers {
+		fmt.Fprintf(&asked, "%d. %s\n", i+1, answer.Question)
+		if i > 0 {
+			merged.WriteString("\n\n")
+		}
+		fmt.Fprintf(&merged, "**%d. %s**\n\n", i+1, answer.Question)
+		if answer.Err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = answer.Err
+			}
+			fmt.Fprintf(&merged, "(no answer: %v)", answer.Err)
+			continue
+		}
+		merged.WriteString(strings.TrimSpace(answer.Result.Output))
+		r := answer.Result
+		result.Model = r.Model
+		result.InputTokens += r.InputTokens
+		result.OutputTokens += r.OutputTokens
+		result.ReasoningTokens += r.ReasoningTokens
+		result.CostUSD += r.CostUSD
+		result.Turns += r.Turns
+		result.ToolSteps = append(result.ToolSteps, r.ToolSteps...)
+		for _, path := range r.FilesChanged {
+			if !slices.Contains(result.FilesChanged, path) {
+				result.FilesChanged = append(result.FilesChanged, path)
+			}
+		}
+		result.Artifacts = session.AddArtifacts(result.Artifacts, r.Artifacts...)
+	}
+	if failed == len(answers) {
+		return result, firstErr
+	}
+	result.Output = merged.String()
+	result.StopReason = StopCompleted
+	prompt := "Answer each of these questions:\n" + asked.String()
+	result.Transcript = append(append([]provider.Message{}, base.Transcript...),
+		provider.Message{Role: "user", Content: prompt, Labels: PromptLabels(ctx, base)},
+		provider.Message{Role: "assistant", Content: result.Output},
+	)
+	sessionID, err := saveAsk(ctx, base, prompt, result)
+	if err != nil {
+		return result, err
+	}
+	result.SessionID = sessionID
+
+	c.mu.Lock()
+	c.Request.Execution.SessionID = sessionID
+	c.Request.Transcript = result.Transcript
+	c.totals.Record(result.RunResult)
+	c.mu.Unlock()
+	return result, nil
+}
+
+// forkRequest is base narrowed to a throwaway run of question: it starts
+// from the same history but saves nothing and takes no queued messages.
+// Tools that keep state in the instance are cloned for it.
+func forkRequest(base RunRequest, question string) RunRequest {
+	fork := base
+	fork.Prompt = question
+	fork.Transcript = append([]provider.Message(nil), base.Transcript...)
+	fork.Tools = tool.Clone(base.Tools)
+	fork.Sessions = nil
+	fork.Execution.SessionID = ""
+	fork.Steering, fork.FollowUps = nil, nil
+	fork.KeepToolSessions = false
+	if base.Events != nil {
+		fork.Events = events.SinkFunc(func(ctx context.Context, event events.Event) error {
+			if event.Type == events.TypeAssistantDelta {
+				return nil
+			}
+			return base.Events.Publish(ctx, event)
+		})
+	}
+	return fork
+}
+
+// saveAsk appends the questions and merged answer to the conversation's
+// session, creating it if the conversation has none yet. A history that
+// was never saved is not backfilled.
+func saveAsk(ctx context.Context, base RunRequest, prompt string, result AskResult) (string, error) {
+	sessionID := base.Execution.SessionID
+	if base.Sessions == nil {
+		return sessionID, nil
+	}
+	now := time.Now()
+	if sessionID == "" {
+		sessionID = session.NewID(now)
+		if _, err := base.Sessions.Create(ctx, session.Metadata{ID: sessionID, Profile: base.Profile.Metadata.Name, CWD: base.Execution.CWD, CreatedAt: now, UpdatedAt: now}); err != nil {
+			return "", err
+		}
+	}
+	var question string
+	if labels := PromptLabels(ctx, base); len(labels) > 0 {
+		data, _ := json.Marshal(session.MessageMetadata{Labels: labels})
+		question = string(data)
+	}
+	meta, _ := json.Marshal(session.MessageMetadata{Usage: &session.TurnUsage{Provider: base.Provider.Name(), Model: result.Model, InputTokens: result.InputTokens, OutputTokens: result.OutputTokens, ReasoningTokens: result.ReasoningTokens}})
+	for _, entry := range []session.Entry{
+		{Kind: session.EntryMessage, Role: "user", Content: prompt, Metadata: question, CreatedAt: now},
+		{Kind: session.EntryMessage, Role: "assistant", Content: result.Output, Metadata: string(meta), CreatedAt: now},
+	} {
+		if err := base.Sessions.Append(ctx, sessionID, entry); err != nil {
+			return sessionID, fmt.Errorf("save answers: %w", err)
+		}
+	}
+	return sessionID, session.Close(ctx, base.Sessions, sessionID)
+}
@@ -0,0 +1,187 @@
+package runtime
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // registers the GIF decoder
+	"image/jpeg"
+	"image/png"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+)
+
+// Limits applied to attachments. Images are scaled down to fit within
+// MaxImageDimension on their long edge, which every supported provider
+// accepts without resizing again, and re-encoded if still over
+// MaxImageBytes.
+const (
+	MaxImageDimension  = 1568
+	MaxImageBytes      = 5 << 20
+	MaxAttachmentBytes = 20 << 20 // read limit for any attached file
+)
+
+// Attachment is a file attached to a prompt: an image or PDF sent to the
+// model with the prompt, or a text file inlined into it.
+type Attachment struct {
+	Name      string // label shown to the model, usually the path
+	MediaType string // "" detects it from Name and Data
+	Data      []byte
+}
+
+// LoadAttachment reads the file at path as an attachment named path.
+func LoadAttachment(path string) (Attachment, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Attachment{}, err
+	}
+	if info.IsDir() {
+		return Attachment{}, fmt.Errorf("attach %s: is a directory", path)
+	}
+	if info.Size() > MaxAttachmentBytes {
+		return Attachment{}, fmt.Errorf("attach %s: %d bytes is over the %d byte limit", path, info.Size(), MaxAttachmentBytes)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Attachment{}, err
+	}
+	return Attachment{Name: path, Data: data}, nil
+}
+
+// Prepared is a prompt with its attachments ready to send.
+type Prepared struct {
+	Text      string              // the prompt with text attachments inlined
+	Images    []provider.Image    // for RunRequest.Images
+	Documents []provider.Document // for RunRequest.Documents
+}
+
+// PrepareAttachments returns text with the text attachments appended as
+// fenced blocks labeled with their names, and the image and PDF
+// attachments ready to send. Other binary files are rejected.
+func PrepareAttachments(text string, attachments []Attachment) (Prepared, error) {
+	var b strings.Builder
+	b.WriteString(text)
+	var out Prepared
+	for _, a := range attachments {
+		mediaType := attachmentType(a)
+		switch {
+		case strings.HasPrefix(mediaType, "image/"):
+			img, err := prepareImage(mediaType, a.Data)
+			if err != nil {
+				return Prepared{}, fmt.Errorf("attach %s: %w", a.Name, err)
+			}
+			out.Images = append(out.Images, img)
+		case mediaType == "application/pdf":
+			out.Documents = append(out.Documents, provider.Document{Name: filepath.Base(a.Name), MediaType: mediaType, Data: a.Data})
+		case isText(a.Data):
+			fence := "```"
+			for strings.Contains(string(a.Data), fence) {
+				fence += "`"
+			}
+			lang := strings.TrimPrefix(filepath.Ext(a.Name), ".")
+			fmt.Fprintf(&b, "\n\nFile: %s\n%s%s\n%s", a.Name, fence, lang, a.Data)
+			if !bytes.HasSuffix(a.Data, []byte("\n")) {
+				b.WriteString("\n")
+			}
+			b.WriteString(fence)
+		default:
+			return Prepared{}, fmt.Errorf("attach %s: unsupported file type %s; attach images, PDFs, or text files", a.Name, mediaType)
+		}
+	}
+	out.Text = b.String()
+	return out, nil
+}
+
+func attachmentType(a Attachment) string {
+	if a.MediaType != "" {
+		return a.MediaType
+	}
+	if detected := http.DetectContentType(a.Data); strings.HasPrefix(detected, "image/") {
+		return detected
+	}
+	if byName := mime.TypeByExtension(filepath.Ext(a.Name)); byName != "" {
+		mediaType, _, _ := strings.Cut(byName, ";")
+		return mediaType
+	}
+	return http.DetectContentType(a.Data)
+}
+
+func isText(data []byte) bool {
+	return utf8.Valid(data) && !bytes.ContainsRune(data, 0)
+}
+
+// prepareImage scales an image down to the provider limits. Formats the
+// standard library cannot decode (WebP) are sent unchanged if small enough.
+func prepareImage(mediaType string, data []byte) (provider.Image, error) {
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		if mediaType == "image/webp" && len(data) <= MaxImageBytes {
+			return provider.Image{MediaType: mediaType, Data: data}, nil
+		}
+		return provider.Image{}, fmt.Errorf("decode image: %w", err)
+	}
+	bounds := src.Bounds()
+	long := max(bounds.Dx(), bounds.Dy())
+	if long <= MaxImageDimension && len(data) <= MaxImageBytes && format != "gif" {
+		return provider.Image{MediaType: "image/" + format, Data: data}, nil
+	}
+	if long > MaxImageDimension {
+		src = scaleDown(src, MaxImageDimension)
+	}
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, src, &jpeg.Options{Quality: 90})
+	} else {
+		// PNG keeps transparency; GIF is sent as its first frame.
+		format = "png"
+		err = png.Encode(&buf, src)
+	}
+	if err == nil && buf.Len() > MaxImageBytes {
+		format = "jpeg"
+		buf.Reset()
+		err = jpeg.Encode(&buf, src, &jpeg.Options{Quality: 80})
+	}
+	if err != nil {
+		return provider.Image{}, err
+	}
+	if buf.Len() > MaxImageBytes {
+		return provider.Image{}, errors.New("image is too large even after resizing")
+	}
+	return provider.Image{MediaType: "image/" + format, Data: buf.Bytes()}, nil
+}
+
+// scaleDown resizes src so its long edge is limit pixels, averaging the
+// source pixels behind each destination pixel.
+func scaleDown(src image.Image, limit int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := limit, h*limit/w
+	if h > w {
+		dw, dh = w*limit/h, limit
+	}
+	dw, dh = max(dw, 1), max(dh, 1)
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := range dh {
+		y0, y1 := b.Min.Y+y*h/dh, b.Min.Y+max((y+1)*h/dh, y*h/dh+1)
+		for x := range dw {
+			x0, x1 := b.Min.X+x*w/dw, b.Min.X+max((x+1)*w/dw, x*w/dw+1)
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := color.NRGBA64Model.Convert(src.At(sx, sy)).(color.NRGBA64)
+					r, g, bl, a, n = r+uint64(c.R), g+uint64(c.G), bl+uint64(c.B), a+uint64(c.A), n+1
+				}
+			}
+			dst.SetNRGBA(x, y, color.NRGBA{R: uint8(r / n >> 8), G: uint8(g / n >> 8), B: uint8(bl / n >> 8), A: uint8(a / n >> 8)})
+		}
+	}
+	return dst
+}
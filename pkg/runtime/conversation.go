@@ -0,0 +1,273 @@
+package runtime
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/session"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// ErrBusy is returned by Conversation.Prompt while another prompt runs.
+var ErrBusy = errors.New("a prompt is already running")
+
+// ErrPromptCanceled is delivered for a queued prompt removed by Cancel or
+// Clear before it ran.
+var ErrPromptCanceled = errors.New("queued prompt canceled")
+
+// PromptOutcome is the result of a queued prompt.
+type PromptOutcome struct {
+	Result RunResult
+	Err    error
+}
+
+// Conversation runs successive prompts against one session, carrying the
+// session ID and transcript from each run into the next. Only one prompt
+// runs at a time: Prompt fails with ErrBusy while the loop is streaming,
+// and PromptQueued waits its turn, so servers and bots that receive
+// messages asynchronously need no queue of their own.
+type Conversation struct {
+	Runner Runner
+	// Request is the template for every run; Prompt is set per prompt and
+	// Transcript and Execution.SessionID advance after each run.
+	Request RunRequest
+	// AskParallel bounds the forks Ask runs at once; 0 runs every
+	// question at once.
+	AskParallel int
+
+	mu      sync.Mutex
+	running bool
+	pending []*queuedPrompt
+	totals  State // usage and cost of the runs so far; see Snapshot
+	// artifacts are those declared by the runs so far; see Artifacts.
+	artifacts []session.Artifact
+	closed    bool // see Close
+}
+
+type queuedPrompt struct {
+	msg  QueuedMessage
+	ctx  context.Context
+	done chan PromptOutcome
+}
+
+// Prompt runs prompt now, or returns ErrBusy if a prompt is running or
+// queued prompts are waiting.
+func (c *Conversation) Prompt(ctx context.Context, prompt string) (RunResult, error) {
+	c.mu.Lock()
+	if c.running {
+		c.mu.Unlock()
+		return RunResult{}, ErrBusy
+	}
+	c.running = true
+	c.mu.Unlock()
+	result, err := c.run(ctx, Prepared{Text: prompt})
+	c.next()
+	return result, err
+}
+
+// PromptWithAttachments is Prompt with files attached: text files are
+// inlined into the prompt, and images and PDFs are sent with it, images
+// scaled down to fit provider limits.
+func (c *Conversation) PromptWithAttachments(ctx context.Context, text string, attachments []Attachment) (RunResult, error) {
+	prepared, err := PrepareAttachments(text, attachments)
+	if err != nil {
+		return RunResult{}, err
+	}
+	c.mu.Lock()
+	if c.running {
+		c.mu.Unlock()
+		return RunResult{}, ErrBusy
+	}
+	c.running = true
+	c.mu.Unlock()
+	result, err := c.run(ctx, prepared)
+	c.next()
+	return result, err
+}
+
+// PromptQueued queues prompt behind any running or waiting prompts and
+// returns its queue entry and a channel that receives the outcome once it
+// has run. Canceling ctx before the prompt starts skips it; canceling it
+// later stops its run.
+func (c *Conversation) PromptQueued(ctx context.Context, prompt string) (QueuedMessage, <-chan PromptOutcome) {
+	item := &queuedPrompt{
+		msg:  QueuedMessage{ID: newPromptID(), Content: prompt, CreatedAt: time.Now().UTC()},
+		ctx:  ctx,
+		done: make(chan PromptOutcome, 1),
+	}
+	c.mu.Lock()
+	c.pending = append(c.pending, item)
+	if !c.running {
+		c.running = true
+		go c.drain()
+	}
+	c.mu.Unlock()
+	return item.msg, item.done
+}
+
+// Busy reports whether a prompt is running.
+func (c *Conversation) Busy() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.running
+}
+
+// Queued returns the prompts waiting to run, oldest first.
+func (c *Conversation) Queued() []QueuedMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]QueuedMessage, 0, len(c.pending))
+	for _, item := range c.pending {
+		out = append(out, item.msg)
+	}
+	return out
+}
+
+// Cancel removes a waiting prompt; its outcome is ErrPromptCanceled. It
+// reports false when id is not waiting, including when it already started.
+func (c *Conversation) Cancel(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, item := range c.pending {
+		if item.msg.ID == id {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			item.done <- PromptOutcome{Err: ErrPromptCanceled}
+			return true
+		}
+	}
+	return false
+}
+
+// Clear cancels every waiting prompt and returns how many there were.
+func (c *Conversation) Clear() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, item := range c.pending {
+		item.done <- PromptOutcome{Err: ErrPromptCanceled}
+	}
+	n := len(c.pending)
+	c.pending = nil
+	return n
+}
+
+// next hands the conversation to the queue after a direct Prompt.
+func (c *Conversation) next() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pending) == 0 {
+		c.idle()
+		return
+	}
+	go c.drain()
+}
+
+func (c *Conversation) drain() {
+	for {
+		c.mu.Lock()
+		if len(c.pending) == 0 {
+			c.idle()
+			c.mu.Unlock()
+			return
+		}
+		item := c.pending[0]
+		c.pending = c.pending[1:]
+		c.mu.Unlock()
+		if err := item.ctx.Err(); err != nil {
+			item.done <- PromptOutcome{Err: err}
+			continue
+		}
+		result, err := c.run(item.ctx, Prepared{Text: item.msg.Content})
+		item.done <- PromptOutcome{Result: result, Err: err}
+	}
+}
+
+func (c *Conversation) run(ctx context.Context, prompt Prepared) (RunResult, error) {
+	c.mu.Lock()
+	if c.Request.ToolCache == nil {
+		c.Request.ToolCache = &ToolCache{}
+	}
+	req := c.Request
+	req.KeepToolSessions = !c.closed
+	c.mu.Unlock()
+	req.Prompt, req.Images, req.Documents = prompt.Text, prompt.Images, prompt.Documents
+	result, err := c.Runner.Run(ctx, req)
+	c.mu.Lock()
+	if result.SessionID != "" {
+		c.Request.Execution.SessionID = result.SessionID
+	}
+	// The next prompt starts from any configuration reloaded during this one.
+	if reload, ok := c.Request.Reloader.Current(); ok {
+		c.Request = reload.Apply(c.Request)
+	}
+	if result.Transcript != nil {
+		c.Request.Transcript = result.Transcript
+	}
+	if result.Plan != nil {
+		c.Request.Plan = result.Plan
+	}
+	c.totals.Record(result)
+	c.artifacts = session.AddArtifacts(c.artifacts, result.Artifacts...)
+	c.mu.Unlock()
+	return result, err
+}
+
+// Close cancels the queued prompts and releases the state tools keep for
+// the conversation's session, such as core/bash's persistent shell, once
+// a prompt already running has finished. The session stays in the store;
+// prompts after Close release the tools' state as they end.
+func (c *Conversation) Close() {
+	c.Clear()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	if !c.running {
+		c.closeTools()
+	}
+}
+
+// idle marks the conversation as running nothing, releasing its tools'
+// state if it was closed meanwhile. c.mu must be held.
+func (c *Conversation) idle() {
+	c.running = false
+	if c.closed {
+		c.closeTools()
+	}
+}
+
+func (c *Conversation) closeTools() {
+	if sessionID := c.Request.Execution.SessionID; sessionID != "" {
+		tool.CloseSession(c.Request.Tools, sessionID)
+	}
+}
+
+// Artifacts returns the files tools declared producing or changing in
+// the conversation's runs so far, one per path in first-declared order.
+// A session's full record, across processes, is session.Artifacts of its
+// entries.
+func (c *Conversation) Artifacts() []session.Artifact {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]session.Artifact{}, c.artifacts...)
+}
+
+// Reconfigure switches the conversation to reload from the next turn, of
+// the running prompt too when the conversation was created with a Reloader.
+func (c *Conversation) Reconfigure(reload Reload) {
+	c.mu.Lock()
+	if c.Request.Reloader == nil {
+		c.Request.Reloader = NewReloader(nil)
+	}
+	reloader := c.Request.Reloader
+	c.mu.Unlock()
+	reloader.Reconfigure(reload)
+}
+
+func newPromptID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,92 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/events"
+	"github.com/bitop-dev/agent/pkg/provider"
+	"github.com/bitop-dev/agent/pkg/session"
+)
+
+// EditMessage replaces user message i of the conversation's transcript
+// with text and runs the conversation again from there, as chat UIs do
+// when an earlier message is edited. The messages from i on leave the
+// conversation but not its session: the rerun starts a new session, a fork
+// holding the history before message i, which the conversation continues
+// with, and the original session is left as it was. The edited message
+// keeps the images, documents, and labels of the one it replaces, and the
+// rerun starts without the task list (Plan), which only a core/plan call
+// in the kept history restores. If the rerun fails, the conversation keeps
+// its transcript, session, and plan as they were before the edit.
+//
+// A TypeMessageEdited event carrying a session.MessageEdit precedes the
+// rerun's events, and the edit is recorded in the new session. EditMessage
+// fails with ErrBusy while a prompt is running.
+func (c *Conversation) EditMessage(ctx context.Context, i int, text string) (RunResult, error) {
+	c.mu.Lock()
+	if c.running {
+		c.mu.Unlock()
+		return RunResult{}, ErrBusy
+	}
+	transcript := c.Request.Transcript
+	if i < 0 || i >= len(transcript) {
+		c.mu.Unlock()
+		return RunResult{}, fmt.Errorf("no message %d in a transcript of %d", i+1, len(transcript))
+	}
+	original := transcript[i]
+	if _, ok := CompactionSummary([]provider.Message{original}); ok || original.Role != "user" {
+		c.mu.Unlock()
+		return RunResult{}, fmt.Errorf("message %d is not a user message", i+1)
+	}
+	c.running = true
+	edit := session.MessageEdit{FromSession: c.Request.Execution.SessionID, Index: i, Dropped: len(transcript) - i}
+	plan := c.Request.Plan
+	c.Request.Transcript = append([]provider.Message(nil), transcript[:i]...)
+	c.Request.Execution.SessionID = ""
+	c.Request.Plan = nil
+	sink, sessions := c.Request.Events, c.Request.Sessions
+	// restore undoes the edit when the rerun does not happen or fails.
+	restore := func() {
+		c.mu.Lock()
+		c.Request.Transcript, c.Request.Execution.SessionID, c.Request.Plan = transcript, edit.FromSession, plan
+		c.mu.Unlock()
+	}
+	c.mu.Unlock()
+	defer c.next()
+
+	if sink != nil {
+		if err := sink.Publish(ctx, events.Event{Type: events.TypeMessageEdited, Time: time.Now(), Message: fmt.Sprintf("message %d edited; rerunning from there", i+1), Data: edit}); err != nil {
+			restore()
+			return RunResult{}, err
+		}
+	}
+	if len(original.Labels) > 0 {
+		labels := maps.Clone(original.Labels)
+		if attached, _ := ctx.Value(labelsKey{}).(map[string]string); attached != nil {
+			maps.Copy(labels, attached)
+		}
+		ctx = WithLabels(ctx, labels)
+	}
+	result, err := c.run(ctx, Prepared{Text: text, Images: original.Images, Documents: original.Documents})
+	if err != nil {
+		restore()
+		return result, err
+	}
+	if sessions != nil && result.SessionID != "" {
+		if data, marshalErr := json.Marshal(edit); marshalErr == nil {
+			_ = sessions.Append(ctx, result.SessionID, session.Entry{
+				Kind:      session.EntryEvent,
+				EventType: session.EventMessageEdited,
+				Content:   fmt.Sprintf("edited message %d", i+1),
+				Metadata:  string(data),
+				CreatedAt: time.Now(),
+			})
+			_ = session.Close(ctx, sessions, result.SessionID)
+		}
+	}
+	return result, nil
+}
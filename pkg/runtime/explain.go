@@ -0,0 +1,131 @@
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// Token estimates use 1 token ≈ 4 characters. A run compacts its
+// transcript once the estimate passes CompactionThreshold less
+// CompactionReserve, kept for the response, summarizing all but the last
+// CompactionKeepRecent tokens of messages.
+const (
+	CompactionThreshold  = 80000
+	CompactionReserve    = 16384
+	CompactionKeepRecent = 20000
+)
+
+// EstimateMessageTokens estimates the prompt tokens of msg: its text and
+// its tool calls. Images and documents are not counted.
+func EstimateMessageTokens(msg provider.Message) int {
+	total := len(msg.Content) / 4
+	for _, tc := range msg.ToolCalls {
+		total += (len(tc.ToolID) + len(fmt.Sprint(tc.Arguments))) / 4
+	}
+	return total
+}
+
+// EstimateToolTokens estimates the prompt tokens of the schemas of defs.
+func EstimateToolTokens(defs []tool.Definition) int {
+	total := 0
+	for _, def := range defs {
+		schema, _ := json.Marshal(def.Schema)
+		total += (len(def.ID) + len(def.Description) + len(schema)) / 4
+	}
+	return total
+}
+
+// ContextReport breaks down, in estimated tokens, the context the next
+// provider call of a run would send, to show what fills it and how far it
+// is from compaction.
+type ContextReport struct {
+	Model      string
+	System     int // the system prompt
+	Tools      int // the schemas of the enabled tools
+	ToolCount  int
+	Messages   []MessageTokens
+	Transcript int // the messages together
+	Total      int // System, Tools, and Transcript
+	// CachedPrefix is the part of Total the previous call sent as well:
+	// the system prompt, the tools, and the messages before the last
+	// reply. Providers that cache prompts can serve it from cache.
+	CachedPrefix int
+	// CompactAt is the Transcript size past which the run compacts, and
+	// Headroom how far below it Transcript is; it is negative when the next
+	// turn ends in compaction. Compaction reports whether the profile
+	// compacts at all.
+	CompactAt  int
+	Headroom   int
+	Compaction bool
+}
+
+// MessageTokens is the estimate for one message of a ContextReport.
+type MessageTokens struct {
+	Role    string
+	Tokens  int
+	Pinned  bool // kept verbatim through compaction
+	Summary bool // a compaction summary of earlier messages
+	Content string
+}
+
+// ExplainContext estimates the context of the next provider call of req:
+// its system prompt, its tools, and its transcript followed by its Prompt,
+// if set. Tools switched off by req.Toggles are left out; those a
+// ToolSelector or tool budget would drop on the turn are counted.
+func ExplainContext(req RunRequest) ContextReport {
+	report := ContextReport{
+		Model:      req.Profile.Spec.Provider.Model,
+		System:     len(req.SystemPrompt) / 4,
+		CompactAt:  CompactionThreshold - CompactionReserve,
+		Compaction: req.Profile.Spec.Session.Compaction == "auto",
+	}
+	if req.ModelOverride != "" {
+		report.Model = req.ModelOverride
+	}
+	var defs []tool.Definition
+	for _, t := range req.Tools {
+		if req.Toggles.Enabled(t) {
+			defs = append(defs, t.Definition())
+		}
+	}
+	report.Tools, report.ToolCount = EstimateToolTokens(defs), len(defs)
+
+	messages := req.Transcript
+	if req.Prompt != "" {
+		messages = append(messages[:len(messages):len(messages)], provider.Message{Role: "user", Content: req.Prompt})
+	}
+	lastReply := -1
+	for i, msg := range req.Transcript {
+		if msg.Role == "assistant" {
+			lastReply = i
+		}
+	}
+	cached := 0
+	for i, msg := range messages {
+		_, summary := CompactionSummary([]provider.Message{msg})
+		tokens := EstimateMessageTokens(msg)
+		report.Messages = append(report.Messages, MessageTokens{Role: msg.Role, Tokens: tokens, Pinned: IsPinned(msg), Summary: summary, Content: msg.Content})
+		report.Transcript += tokens
+		if i < lastReply {
+			cached += tokens
+		}
+	}
+	report.Total = report.System + report.Tools + report.Transcript
+	if lastReply >= 0 {
+		report.CachedPrefix = report.System + report.Tools + cached
+	}
+	report.Headroom = report.CompactAt - report.Transcript
+	return report
+}
+
+// ExplainContext estimates the context of the conversation's next prompt;
+// see the function ExplainContext.
+func (c *Conversation) ExplainContext() ContextReport {
+	c.mu.Lock()
+	req := c.Request
+	c.mu.Unlock()
+	return ExplainContext(req)
+}
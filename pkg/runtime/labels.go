@@ -0,0 +1,30 @@
+package runtime
+
+import (
+	"context"
+	"maps"
+)
+
+type labelsKey struct{}
+
+// WithLabels attaches labels to the prompts run with ctx, such as the
+// user who sent them or the commit they were made at, on top of
+// RunRequest.Labels. Conversation prompts take them from their ctx.
+func WithLabels(ctx context.Context, labels map[string]string) context.Context {
+	return context.WithValue(ctx, labelsKey{}, maps.Clone(labels))
+}
+
+// PromptLabels returns the labels of req's prompt message: req.Labels
+// overridden by those attached with WithLabels.
+func PromptLabels(ctx context.Context, req RunRequest) map[string]string {
+	attached, _ := ctx.Value(labelsKey{}).(map[string]string)
+	if len(req.Labels) == 0 && len(attached) == 0 {
+		return nil
+	}
+	labels := maps.Clone(req.Labels)
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	maps.Copy(labels, attached)
+	return labels
+}
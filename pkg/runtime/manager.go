@@ -0,0 +1,185 @@
+package runtime
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/events"
+)
+
+// Manager owns the conversations of a process that serves many at once,
+// keyed by conversation ID. Conversations share the runner and everything
+// in Template (provider, tools, profile, session store, spend ledger); each
+// has its own session and transcript. Their events go to one sink tagged
+// with the conversation ID, and MaxActive bounds how many prompts run at
+// once across all of them.
+type Manager struct {
+	Runner Runner
+	// Template is the request every new conversation starts from; its
+	// Events sink is replaced by the manager's.
+	Template RunRequest
+	// Events receives the events of every conversation, with
+	// Event.Conversation set. Nil uses Template.Events.
+	Events events.Sink
+	// MaxActive bounds the prompts running at once across conversations;
+	// further prompts wait for a slot. 0 is unlimited.
+	MaxActive int
+	// IdleTimeout is how long a conversation may go without a prompt
+	// before Sweep closes it. 0 keeps conversations until closed.
+	IdleTimeout time.Duration
+
+	mu            sync.Mutex
+	conversations map[string]*managedConversation
+	slots         chan struct{}
+}
+
+type managedConversation struct {
+	conv     *Conversation
+	lastUsed time.Time
+}
+
+// Conversation returns the conversation with id, starting it from Template
+// if it does not exist yet. A new conversation's session is created by its
+// first prompt; set Request.Execution.SessionID before then to continue a
+// stored session instead.
+func (m *Manager) Conversation(id string) *Conversation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.conversations == nil {
+		m.conversations = map[string]*managedConversation{}
+	}
+	if m.slots == nil && m.MaxActive > 0 {
+		m.slots = make(chan struct{}, m.MaxActive)
+	}
+	if managed, ok := m.conversations[id]; ok {
+		managed.lastUsed = time.Now()
+		return managed.conv
+	}
+	req := m.Template
+	sink := m.Events
+	if sink == nil {
+		sink = m.Template.Events
+	}
+	if sink != nil {
+		req.Events = taggedSink{conversation: id, sink: sink}
+	}
+	managed := &managedConversation{lastUsed: time.Now()}
+	managed.conv = &Conversation{
+		Runner:  managedRunner{manager: m, managed: managed, inner: m.Runner},
+		Request: req,
+	}
+	m.conversations[id] = managed
+	return managed.conv
+}
+
+// Lookup returns the conversation with id if it exists.
+func (m *Manager) Lookup(id string) (*Conversation, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	managed, ok := m.conversations[id]
+	if !ok {
+		return nil, false
+	}
+	return managed.conv, true
+}
+
+// IDs returns the IDs of the open conversations, sorted.
+func (m *Manager) IDs() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ids := make([]string, 0, len(m.conversations))
+	for id := range m.conversations {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Close removes the conversation with id and closes it (see
+// Conversation.Close). A prompt already running finishes. Its session
+// stays in the store.
+func (m *Manager) Close(id string) bool {
+	m.mu.Lock()
+	managed, ok := m.conversations[id]
+	delete(m.conversations, id)
+	m.mu.Unlock()
+	if ok {
+		managed.conv.Close()
+	}
+	return ok
+}
+
+// Sweep closes the conversations idle for longer than IdleTimeout at now
+// and returns their IDs. Busy conversations are never idle.
+func (m *Manager) Sweep(now time.Time) []string {
+	if m.IdleTimeout <= 0 {
+		return nil
+	}
+	m.mu.Lock()
+	var idle []string
+	for id, managed := range m.conversations {
+		if now.Sub(managed.lastUsed) > m.IdleTimeout && !managed.conv.Busy() {
+			idle = append(idle, id)
+		}
+	}
+	m.mu.Unlock()
+	sort.Strings(idle)
+	for _, id := range idle {
+		m.Close(id)
+	}
+	return idle
+}
+
+// acquire waits for a slot to run a prompt in.
+func (m *Manager) acquire(ctx context.Context) (func(), error) {
+	m.mu.Lock()
+	slots := m.slots
+	m.mu.Unlock()
+	if slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// managedRunner runs a managed conversation's prompts within the manager's
+// limit and records when the conversation was last used.
+type managedRunner struct {
+	manager *Manager
+	managed *managedConversation
+	inner   Runner
+}
+
+func (r managedRunner) Run(ctx context.Context, req RunRequest) (RunResult, error) {
+	r.touch()
+	release, err := r.manager.acquire(ctx)
+	if err != nil {
+		return RunResult{}, err
+	}
+	defer release()
+	defer r.touch()
+	return r.inner.Run(ctx, req)
+}
+
+func (r managedRunner) touch() {
+	r.manager.mu.Lock()
+	r.managed.lastUsed = time.Now()
+	r.manager.mu.Unlock()
+}
+
+// taggedSink sets the conversation ID on events before forwarding them.
+type taggedSink struct {
+	conversation string
+	sink         events.Sink
+}
+
+func (s taggedSink) Publish(ctx context.Context, event events.Event) error {
+	event.Conversation = s.conversation
+	return s.sink.Publish(ctx, event)
+}
@@ -0,0 +1,67 @@
+package runtime
+
+import "time"
+
+// RunManifest is a machine-readable summary of one run, written at the end
+// so CI and orchestration systems can consume a single file instead of
+// parsing logs or sessions.
+type RunManifest struct {
+	SessionID       string            `json:"sessionId,omitempty"`
+	Profile         string            `json:"profile"`
+	Model           string            `json:"model,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	StopReason      string            `json:"stopReason"`
+	Error           string            `json:"error,omitempty"`
+	StartedAt       time.Time         `json:"startedAt"`
+	FinishedAt      time.Time         `json:"finishedAt"`
+	DurationMs      int64             `json:"durationMs"`
+	Turns           int               `json:"turns"`
+	InputTokens     int               `json:"inputTokens"`
+	OutputTokens    int               `json:"outputTokens"`
+	ReasoningTokens int               `json:"reasoningTokens,omitempty"`
+	CostUSD         float64           `json:"costUSD"`
+	ToolsUsed       map[string]int    `json:"toolsUsed,omitempty"` // calls per tool ID
+	FilesChanged    []string          `json:"filesChanged,omitempty"`
+	Artifacts       []Artifact        `json:"artifacts,omitempty"`
+	Output          string            `json:"output,omitempty"`
+}
+
+// Artifact is a file the caller declared as a run output.
+type Artifact struct {
+	Path   string `json:"path"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256,omitempty"`
+	Error  string `json:"error,omitempty"` // set when the file could not be read
+}
+
+// NewRunManifest summarizes result. A non-nil runErr marks the run failed.
+func NewRunManifest(profile string, labels map[string]string, started time.Time, result RunResult, runErr error) RunManifest {
+	finished := time.Now()
+	m := RunManifest{
+		SessionID:       result.SessionID,
+		Profile:         profile,
+		Model:           result.Model,
+		Labels:          labels,
+		StopReason:      result.StopReason,
+		StartedAt:       started,
+		FinishedAt:      finished,
+		DurationMs:      finished.Sub(started).Milliseconds(),
+		Turns:           result.Turns,
+		InputTokens:     result.InputTokens,
+		OutputTokens:    result.OutputTokens,
+		ReasoningTokens: result.ReasoningTokens,
+		CostUSD:         result.CostUSD,
+		FilesChanged:    result.FilesChanged,
+		Output:          result.Output,
+	}
+	if runErr != nil {
+		m.StopReason, m.Error = StopError, runErr.Error()
+	}
+	for _, step := range result.ToolSteps {
+		if m.ToolsUsed == nil {
+			m.ToolsUsed = map[string]int{}
+		}
+		m.ToolsUsed[step.Tool]++
+	}
+	return m
+}
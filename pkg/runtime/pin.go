@@ -0,0 +1,84 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+	"github.com/bitop-dev/agent/pkg/session"
+)
+
+// LabelPinned is the label, set to "true", of a message compaction keeps
+// verbatim instead of summarizing, such as the original task or a key
+// decision. Set it on a prompt with WithLabels, or on an earlier message
+// with PinMessage.
+const LabelPinned = "pinned"
+
+// IsPinned reports whether msg is pinned.
+func IsPinned(msg provider.Message) bool {
+	return msg.Labels[LabelPinned] == "true"
+}
+
+// PinMessage pins transcript[i], or unpins it, in place. When sessions is
+// set, the pin is also recorded in the session sessionID, so the message is
+// pinned when the session is resumed.
+func PinMessage(ctx context.Context, sessions session.Store, sessionID string, transcript []provider.Message, i int, pinned bool) error {
+	if i < 0 || i >= len(transcript) {
+		return fmt.Errorf("no message %d in a transcript of %d", i+1, len(transcript))
+	}
+	msg := &transcript[i]
+	if _, ok := CompactionSummary([]provider.Message{*msg}); ok {
+		return fmt.Errorf("message %d is a compaction summary, not a message of the history", i+1)
+	}
+	labels := maps.Clone(msg.Labels)
+	if pinned {
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[LabelPinned] = "true"
+	} else {
+		delete(labels, LabelPinned)
+		if len(labels) == 0 {
+			labels = nil
+		}
+	}
+	msg.Labels = labels
+	if sessions == nil || sessionID == "" {
+		return nil
+	}
+	pin := session.Pin{Digest: session.MessageDigests(transcript[:i+1])[i], Pinned: pinned}
+	data, err := json.Marshal(pin)
+	if err != nil {
+		return err
+	}
+	content := "pinned "
+	if !pinned {
+		content = "unpinned "
+	}
+	err = sessions.Append(ctx, sessionID, session.Entry{
+		Kind:      session.EntryEvent,
+		EventType: session.EventMessagePinned,
+		Content:   content + msg.Role + " message",
+		Metadata:  string(data),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	return session.Close(ctx, sessions, sessionID)
+}
+
+// Pin pins message i of the conversation's transcript, or unpins it, and
+// records the pin in its session.
+func (c *Conversation) Pin(ctx context.Context, i int, pinned bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running {
+		return ErrBusy
+	}
+	c.Request.Transcript = append([]provider.Message(nil), c.Request.Transcript...)
+	return PinMessage(ctx, c.Request.Sessions, c.Request.Execution.SessionID, c.Request.Transcript, i, pinned)
+}
@@ -0,0 +1,25 @@
+package runtime
+
+import "context"
+
+type prefillKey struct{}
+
+// WithPrefill sets the text the reply to the prompts run with ctx starts
+// with, in place of RunRequest.Prefill, such as "```json\n" to force a
+// format or the part of a document written before an interruption.
+// Conversation prompts take it from their ctx.
+func WithPrefill(ctx context.Context, text string) context.Context {
+	return context.WithValue(ctx, prefillKey{}, text)
+}
+
+// PromptPrefill returns the text req's first reply starts with: the one set
+// with WithPrefill, or else req.Prefill. Providers implementing
+// provider.Prefiller continue the text natively; others are asked to, and
+// a reply that repeats it has the repetition dropped. Either way the text
+// is part of the reply, in the output, the transcript, and the session.
+func PromptPrefill(ctx context.Context, req RunRequest) string {
+	if text, ok := ctx.Value(prefillKey{}).(string); ok {
+		return text
+	}
+	return req.Prefill
+}
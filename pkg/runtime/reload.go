@@ -0,0 +1,131 @@
+package runtime
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/policy"
+	"github.com/bitop-dev/agent/pkg/profile"
+	"github.com/bitop-dev/agent/pkg/provider"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// Reload is a new configuration for a running conversation. The runtime
+// applies it before the next turn; the transcript and session carry on.
+type Reload struct {
+	Profile      profile.Manifest
+	Model        string      // "" uses the profile's model
+	SystemPrompt string      // "" keeps the current system prompt
+	Tools        []tool.Tool // nil keeps the current tools
+	// Policy, if set, replaces the run's policy, which was built from the
+	// previous profile.
+	Policy policy.Engine
+	// Provider, if set, continues the conversation on another provider;
+	// the runtime carries the history over with NormalizeHistory.
+	Provider provider.Provider
+}
+
+// Apply returns req switched to the reloaded configuration.
+func (r Reload) Apply(req RunRequest) RunRequest {
+	req.Profile = r.Profile
+	req.ModelOverride = r.Model
+	if r.SystemPrompt != "" {
+		req.SystemPrompt = r.SystemPrompt
+	}
+	if r.Tools != nil {
+		req.Tools = r.Tools
+	}
+	if r.Provider != nil {
+		req.Provider = r.Provider
+	}
+	if r.Policy != nil {
+		req.Policy = r.Policy
+	}
+	return req
+}
+
+// Reloader hands configuration changes to the runtime, which checks it
+// before every turn. Changes come from Reconfigure, or from Load when one of
+// the watched files has been modified since it was last read. A Load error
+// leaves the previous configuration in effect.
+type Reloader struct {
+	files []string
+	load  func() (Reload, error)
+
+	mu      sync.Mutex
+	stamps  map[string]time.Time
+	pending *Reload
+	current *Reload
+}
+
+// NewReloader watches files, by modification time, and calls load after
+// any of them changes. load may be nil when only Reconfigure is used.
+func NewReloader(load func() (Reload, error), files ...string) *Reloader {
+	r := &Reloader{files: files, load: load, stamps: make(map[string]time.Time, len(files))}
+	for _, file := range files {
+		r.stamps[file] = modTime(file)
+	}
+	return r
+}
+
+// Reconfigure queues reload for the next turn, ahead of any file change.
+func (r *Reloader) Reconfigure(reload Reload) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending = &reload
+}
+
+// Check returns the configuration to switch to, if it changed since the
+// last Check. It is called by the runtime; callers driving runs themselves
+// do not need it.
+func (r *Reloader) Check() (Reload, bool, error) {
+	if r == nil {
+		return Reload{}, false, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pending != nil {
+		reload := *r.pending
+		r.pending, r.current = nil, &reload
+		return reload, true, nil
+	}
+	changed := false
+	for _, file := range r.files {
+		if stamp := modTime(file); !stamp.Equal(r.stamps[file]) {
+			r.stamps[file] = stamp
+			changed = true
+		}
+	}
+	if !changed || r.load == nil {
+		return Reload{}, false, nil
+	}
+	reload, err := r.load()
+	if err != nil {
+		return Reload{}, false, err
+	}
+	r.current = &reload
+	return reload, true, nil
+}
+
+// Current returns the configuration most recently handed out by Check, so
+// a caller starting the next run can begin from it.
+func (r *Reloader) Current() (Reload, bool) {
+	if r == nil {
+		return Reload{}, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current == nil {
+		return Reload{}, false
+	}
+	return *r.current, true
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
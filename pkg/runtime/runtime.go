@@ -2,6 +2,7 @@ package runtime
 
 import (
 	"context"
+	"time"
 
 	"github.com/bitop-dev/agent/pkg/approval"
 	"github.com/bitop-dev/agent/pkg/events"
@@ -25,18 +26,212 @@ type ExecutionContext struct {
 }
 
 type RunRequest struct {
-	Prompt        string
-	SystemPrompt  string
-	Profile       profile.Manifest
-	Provider      provider.Provider
-	Tools         []tool.Tool
-	Policy        policy.Engine
-	Approvals     approval.Resolver
-	Sessions      session.Store
-	Events        events.Sink
-	Execution     ExecutionContext
-	Transcript    []provider.Message
+	Prompt       string
+	Images       []provider.Image    // sent with Prompt; see PrepareAttachments
+	Documents    []provider.Document // sent with Prompt; see PrepareAttachments
+	Labels       map[string]string   // stored with Prompt's message; see PromptLabels
+	ToolChoice   provider.ToolChoice // applies to the first turn; see PromptToolChoice
+	Prefill      string              // text the first reply starts with; see PromptPrefill
+	SystemPrompt string
+	Profile      profile.Manifest
+	Provider     provider.Provider
+	Tools        []tool.Tool
+	Policy       policy.Engine
+	Approvals    approval.Resolver
+	Sessions     session.Store
+	Events       events.Sink
+	Execution    ExecutionContext
+	Transcript   []provider.Message
+	// Plan seeds the task list the model keeps with core/plan, as saved in
+	// State.Plan; the latest core/plan call in Transcript takes precedence.
+	Plan          []session.PlanItem
 	ModelOverride string // If set, overrides profile's model (from config/CLI/env)
+	// ModelAliases and ModelFallbacks extend the profile's model chain as
+	// configured in config.Config: aliases are expanded, and each model's
+	// configured fallbacks follow the profile's.
+	ModelAliases   map[string]string
+	ModelFallbacks map[string][]string
+	// Steering messages are injected between turns of a running loop;
+	// FollowUps are consumed when the model would otherwise finish. Both
+	// are optional and may be backed by an external store.
+	Steering  MessageQueue
+	FollowUps MessageQueue
+	// Ledger records spend for the profile's rolling daily budget.
+	Ledger SpendLedger
+	// Audit records every tool call the model makes, whether it ran or
+	// was denied. A record that cannot be written ends the run. Nil keeps
+	// no audit log.
+	Audit AuditLog
+	// RetryAdvisor enriches the result of a tool that keeps failing with
+	// guidance for the model's next attempt. Nil uses the schema-based
+	// default.
+	RetryAdvisor RetryAdvisor
+	// ToolSelector narrows the tools sent each turn to those relevant to
+	// the request. Nil sends every tool.
+	ToolSelector ToolSelector
+	// Titler names a session created by the run once the first exchange
+	// is done, recording a session.EntryTitle. Nil leaves it untitled.
+	Titler Titler
+	// Toggles switches tools off and on between turns. Nil offers every
+	// tool in Tools.
+	Toggles *tool.Toggles
+	// ToolCache keeps cacheable tools' results across runs when the
+	// profile sets tools.cache; a Conversation sets it. Nil caches within
+	// the run only.
+	ToolCache *ToolCache
+	// KeepToolSessions leaves open the state tools keep per session, such
+	// as core/bash's persistent shell, when the run ends, for the next run
+	// of the session; a Conversation sets it and releases the state in
+	// Close. False releases it with the run.
+	KeepToolSessions bool
+	// Reloader supplies configuration edited while the conversation runs,
+	// applied from the next turn. Nil keeps the request's configuration.
+	Reloader *Reloader
+	// MaxTurnDuration bounds each model turn, its tool calls included, and
+	// MaxWallClock the whole run; 0 leaves a bound off. Reaching either
+	// stops the run without an error: the turn cut short is recorded as far
+	// as it got, a TypeDeadlineExceeded event is published, and StopReason
+	// is StopTurnTimeout or StopWallClock.
+	MaxTurnDuration time.Duration
+	MaxWallClock    time.Duration
+}
+
+// ToolFailure describes a tool call that failed after repeated attempts.
+type ToolFailure struct {
+	Call       tool.Call
+	Definition tool.Definition
+	Error      string
+	Code       tool.ErrorCode // classification of Error
+	Attempts   int            // consecutive failures of this tool, including this one
+	LastGood   map[string]any // arguments of this tool's last successful call, if any
+}
+
+// RetryAdvisor returns guidance appended to a failing tool's result, or ""
+// to leave the result unchanged.
+type RetryAdvisor interface {
+	Advise(ctx context.Context, failure ToolFailure) string
+}
+
+// RetryAdvisorFunc adapts a function to RetryAdvisor.
+type RetryAdvisorFunc func(ctx context.Context, failure ToolFailure) string
+
+func (f RetryAdvisorFunc) Advise(ctx context.Context, failure ToolFailure) string {
+	return f(ctx, failure)
+}
+
+// ToolSelection asks which of Tools are relevant to Query.
+type ToolSelection struct {
+	Query string // the latest user message
+	Tools []tool.Definition
+	Limit int
+	Model string // the run's model, for selectors that ask one
+	// ExtraHeaders and ExtraQuery are the run's profile's, for selectors
+	// that ask a model.
+	ExtraHeaders map[string]string
+	ExtraQuery   map[string]string
+	// Usage, when set, is told the tokens a selector spent asking a model,
+	// which count against the run's budget.
+	Usage func(providerName, model string, inputTokens, outputTokens int)
+}
+
+// ToolSelector picks the tool IDs to send with a turn, most relevant first.
+// Selectors should return at most Limit IDs; unknown IDs are ignored.
+type ToolSelector interface {
+	Select(ctx context.Context, req ToolSelection) ([]string, error)
+}
+
+// ToolSelectorFunc adapts a function to ToolSelector.
+type ToolSelectorFunc func(ctx context.Context, req ToolSelection) ([]string, error)
+
+func (f ToolSelectorFunc) Select(ctx context.Context, req ToolSelection) ([]string, error) {
+	return f(ctx, req)
+}
+
+// TitleRequest asks for a title for the conversation in Transcript.
+type TitleRequest struct {
+	Transcript []provider.Message
+	Model      string // the run's model, for titlers that ask one
+	// ExtraHeaders and ExtraQuery are the run's profile's, for titlers
+	// that ask a model.
+	ExtraHeaders map[string]string
+	ExtraQuery   map[string]string
+	// Usage, when set, is told the tokens a titler spent asking a model,
+	// which count against the run's budget.
+	Usage func(providerName, model string, inputTokens, outputTokens int)
+}
+
+// Titler writes a short title and a one-line summary of a conversation.
+type Titler interface {
+	Title(ctx context.Context, req TitleRequest) (session.Title, error)
+}
+
+// TitlerFunc adapts a function to Titler.
+type TitlerFunc func(ctx context.Context, req TitleRequest) (session.Title, error)
+
+func (f TitlerFunc) Title(ctx context.Context, req TitleRequest) (session.Title, error) {
+	return f(ctx, req)
+}
+
+// SpendLedger persists model spend across sessions.
+type SpendLedger interface {
+	Spent(ctx context.Context, since time.Time) (float64, error)
+	Record(ctx context.Context, at time.Time, usd float64) error
+}
+
+// Decisions recorded in an AuditRecord.
+const (
+	AuditAuto    = "auto"    // ran without asking anyone
+	AuditAllowed = "allowed" // approved when approval was required
+	AuditDenied  = "denied"  // refused by policy, approval, a disabled tool, or its argument schema
+)
+
+// AuditRecord describes one tool call for compliance review. Arguments are
+// kept only as a hash, so the log holds no file contents or secrets.
+type AuditRecord struct {
+	Time         time.Time `json:"time"`
+	SessionID    string    `json:"session_id,omitempty"`
+	ToolID       string    `json:"tool"`
+	ToolCallID   string    `json:"tool_call_id,omitempty"`
+	ArgsHash     string    `json:"args_sha256"` // of the arguments as run, after any rewrite
+	Decision     string    `json:"decision"`
+	Reason       string    `json:"reason,omitempty"`
+	DurationMS   int64     `json:"duration_ms"`
+	Status       string    `json:"status,omitempty"` // "ok", "error", "aborted", or "cached"; "" when it did not run
+	ExitCode     *int      `json:"exit_code,omitempty"`
+	BytesWritten int       `json:"bytes_written,omitempty"`
+}
+
+// AuditLog persists AuditRecords.
+type AuditLog interface {
+	Record(ctx context.Context, record AuditRecord) error
+}
+
+// ScheduledQueue is a MessageQueue holding messages that come due later,
+// at a time or once a condition holds. Used for FollowUps, it keeps a run
+// whose model is done waiting for them instead of ending.
+type ScheduledQueue interface {
+	MessageQueue
+	// Waiting describes the messages not yet due; it is empty when none
+	// are.
+	Waiting() []string
+	// Wait blocks until a message is due or ctx ends.
+	Wait(ctx context.Context) error
+}
+
+// QueuedMessage is a user message delivered to a run from outside the process.
+type QueuedMessage struct {
+	ID        string    `json:"id"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// MessageQueue delivers operator messages to a running agent in FIFO order
+// with at-least-once semantics: Receive returns pending messages without
+// blocking, and a message is redelivered to the next consumer until it is
+// acknowledged.
+type MessageQueue interface {
+	Receive(ctx context.Context) ([]QueuedMessage, error)
+	Ack(ctx context.Context, ids ...string) error
 }
 
 type ToolStep struct {
@@ -48,11 +243,38 @@ type ToolStep struct {
 }
 
 type RunResult struct {
-	SessionID    string
-	Output       string
-	Transcript   []provider.Message
-	Model        string     // which model was actually used
-	InputTokens  int        // total input tokens across all turns
-	OutputTokens int        // total output tokens across all turns
-	ToolSteps    []ToolStep // tool calls executed during the run
+	SessionID        string
+	Output           string
+	Transcript       []provider.Message
+	Model            string     // which model was actually used
+	InputTokens      int        // total input tokens across all turns
+	OutputTokens     int        // total output tokens across all turns
+	ReasoningTokens  int        // part of OutputTokens spent on hidden reasoning, when reported
+	CacheReadTokens  int        // part of InputTokens read from the provider's prompt cache, when reported
+	CacheWriteTokens int        // part of InputTokens written to the provider's prompt cache, when reported
+	ToolSteps        []ToolStep // tool calls executed during the run
+	CostUSD          float64    // priced spend across all turns; 0 when the model is unpriced
+	StopReason       string     // why the loop ended; one of the Stop constants
+	Turns            int        // model turns taken
+	FilesChanged     []string   // paths written or edited by tools, in first-change order
+	// Artifacts are the files tools declared producing or changing, one
+	// per path; see tool.Result.Artifacts.
+	Artifacts       []session.Artifact
+	ArgumentRetries int // tool calls rejected for invalid arguments and retried by the model
+	ToolCacheHits   int // tool calls answered from the tool cache; see profile tools.cache
+	// Plan is the task list the model kept with core/plan, as of the end
+	// of the run; nil when it kept none.
+	Plan []session.PlanItem
 }
+
+// Stop reasons reported in RunResult.StopReason.
+const (
+	StopCompleted        = "completed"         // the model answered without calling more tools
+	StopMaxTurns         = "max_turns"         // the turn limit was reached
+	StopBudget           = "budget"            // a spend cap stopped the run
+	StopExplorationLimit = "exploration_limit" // many tool calls without any answer text
+	StopGuardrail        = "guardrail"         // a guardrail blocked an assistant reply
+	StopTurnTimeout      = "turn_timeout"      // a turn ran past MaxTurnDuration
+	StopWallClock        = "wall_clock"        // the run ran past MaxWallClock
+	StopError            = "error"             // the run failed; set by callers that record errors
+)
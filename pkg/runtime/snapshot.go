@@ -0,0 +1,170 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+	"github.com/bitop-dev/agent/pkg/session"
+)
+
+// pusher is a MessageQueue that accepts new messages, as the queues in
+// internal/queue do.
+type pusher interface {
+	Push(ctx context.Context, content string) (QueuedMessage, error)
+}
+
+// State returns the conversation as a State: its session, transcript and
+// compaction summary, the usage and cost of its runs so far, and the
+// prompts waiting to run. Steering and follow-up messages are left out;
+// Snapshot reads them from their queues.
+func (c *Conversation) State() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.totals
+	s.SessionID = c.Request.Execution.SessionID
+	s.Profile = c.Request.Profile.Metadata.Name
+	s.Messages = append([]provider.Message{}, c.Request.Transcript...)
+	s.Summary = ""
+	if summary, ok := CompactionSummary(s.Messages); ok {
+		s.Summary = summary
+	}
+	s.Queued = nil
+	for _, item := range c.pending {
+		s.Queued = append(s.Queued, item.msg)
+	}
+	if s.UpdatedAt.IsZero() {
+		s.UpdatedAt = time.Now()
+	}
+	return s
+}
+
+// Snapshot serializes the conversation with ExportState so it can be
+// checkpointed mid-task and resumed with Restore, possibly in another
+// process. Unacknowledged steering and follow-up messages are included;
+// they stay in their queues. With a session store, the snapshot's
+// EntryIDs match its messages to the session log. ImportState reads a
+// snapshot like any other state blob.
+func (c *Conversation) Snapshot(ctx context.Context) ([]byte, error) {
+	s := c.State()
+	var err error
+	if sessions := c.Request.Sessions; sessions != nil && s.SessionID != "" {
+		stored, err := sessions.Load(ctx, s.SessionID)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot session: %w", err)
+		}
+		s.EntryIDs = entryIDs(stored.Entries, s.Messages)
+	}
+	if s.Steering, err = receiveAll(ctx, c.Request.Steering); err != nil {
+		return nil, fmt.Errorf("snapshot steering: %w", err)
+	}
+	if s.FollowUps, err = receiveAll(ctx, c.Request.FollowUps); err != nil {
+		return nil, fmt.Errorf("snapshot follow-ups: %w", err)
+	}
+	return ExportState(s)
+}
+
+// Restore resumes a conversation from a Snapshot blob. The conversation
+// keeps its Runner and Request template and takes the snapshot's session,
+// transcript, plan, and totals. Queued prompts are queued again under ctx and
+// start right away; their outcomes show in the conversation's events.
+// Steering and follow-up messages are pushed into the request's queues
+// when those accept new messages and are empty, so a shared queue that
+// still holds them does not receive them twice. Restore returns ErrBusy
+// while a prompt is running or waiting.
+func (c *Conversation) Restore(ctx context.Context, data []byte) error {
+	s, err := ImportState(data)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	if c.running {
+		c.mu.Unlock()
+		return ErrBusy
+	}
+	c.Request.Execution.SessionID = s.SessionID
+	c.Request.Transcript = s.Messages
+	c.Request.Plan = s.Plan
+	c.totals = State{
+		SessionID:       s.SessionID,
+		Model:           s.Model,
+		Summary:         s.Summary,
+		InputTokens:     s.InputTokens,
+		OutputTokens:    s.OutputTokens,
+		ReasoningTokens: s.ReasoningTokens,
+		CostUSD:         s.CostUSD,
+		Plan:            s.Plan,
+		Metadata:        s.Metadata,
+		UpdatedAt:       s.UpdatedAt,
+	}
+	steering, followUps := c.Request.Steering, c.Request.FollowUps
+	c.mu.Unlock()
+
+	if err := refill(ctx, steering, s.Steering); err != nil {
+		return fmt.Errorf("restore steering: %w", err)
+	}
+	if err := refill(ctx, followUps, s.FollowUps); err != nil {
+		return fmt.Errorf("restore follow-ups: %w", err)
+	}
+	if len(s.Queued) == 0 {
+		return nil
+	}
+	c.mu.Lock()
+	for _, msg := range s.Queued {
+		c.pending = append(c.pending, &queuedPrompt{msg: msg, ctx: ctx, done: make(chan PromptOutcome, 1)})
+	}
+	if !c.running {
+		c.running = true
+		go c.drain()
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+// entryIDs returns the position in entries of the message entry that
+// stored each of messages, or -1 for a message entries does not hold. The
+// messages are matched from the end, as compaction replaces the start of
+// a transcript with a summary the log keeps as a compaction entry.
+func entryIDs(entries []session.Entry, messages []provider.Message) []int {
+	ids := make([]int, len(messages))
+	next := len(entries)
+	for i := len(messages) - 1; i >= 0; i-- {
+		ids[i] = -1
+		for j := next - 1; j >= 0; j-- {
+			if e := entries[j]; e.Kind == session.EntryMessage && e.Role == messages[i].Role && e.Content == messages[i].Content {
+				ids[i], next = j, j
+				break
+			}
+		}
+	}
+	return ids
+}
+
+// receiveAll returns the unacknowledged messages of queue without
+// acknowledging them.
+func receiveAll(ctx context.Context, queue MessageQueue) ([]QueuedMessage, error) {
+	if queue == nil {
+		return nil, nil
+	}
+	return queue.Receive(ctx)
+}
+
+// refill pushes messages into queue when it accepts new messages and holds
+// none.
+func refill(ctx context.Context, queue MessageQueue, messages []QueuedMessage) error {
+	p, ok := queue.(pusher)
+	if !ok || len(messages) == 0 {
+		return nil
+	}
+	waiting, err := queue.Receive(ctx)
+	if err != nil || len(waiting) > 0 {
+		return err
+	}
+	for _, msg := range messages {
+		if _, err := p.Push(ctx, msg.Content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
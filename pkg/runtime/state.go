@@ -0,0 +1,202 @@
+package runtime
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+	"github.com/bitop-dev/agent/pkg/session"
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// StateVersion is the version written by ExportState. ImportState rejects
+// blobs from a newer version.
+const StateVersion = 1
+
+// State is a self-contained snapshot of a conversation, for embedders that
+// keep conversations in their own database instead of the JSONL session
+// store. Export it after a run and pass Messages back as
+// RunRequest.Transcript to continue.
+type State struct {
+	SessionID       string
+	Profile         string
+	Model           string
+	Messages        []provider.Message // includes any compaction summary
+	Summary         string             // latest compaction summary, if the conversation was compacted
+	InputTokens     int                // cumulative across the runs recorded
+	OutputTokens    int
+	ReasoningTokens int
+	CostUSD         float64
+	Metadata        map[string]string // caller-defined, carried unchanged
+	UpdatedAt       time.Time
+	// Plan is the task list the model keeps with core/plan; pass it back
+	// as RunRequest.Plan.
+	Plan []session.PlanItem
+	// EntryIDs, when set, match Messages to the session log: the position
+	// in the session's entries of the entry that stored each message, or
+	// -1 for a message the log does not hold, such as a compaction summary.
+	EntryIDs []int
+	// Queued, Steering, and FollowUps are the messages waiting when a
+	// Conversation was snapshotted; see Conversation.Snapshot.
+	Queued    []QueuedMessage
+	Steering  []QueuedMessage
+	FollowUps []QueuedMessage
+}
+
+// Record folds a finished run into the state: the run's transcript
+// replaces Messages and its usage is added to the totals.
+func (s *State) Record(result RunResult) {
+	if result.SessionID != "" {
+		s.SessionID = result.SessionID
+	}
+	if result.Model != "" {
+		s.Model = result.Model
+	}
+	s.Messages = append([]provider.Message{}, result.Transcript...)
+	if summary, ok := CompactionSummary(s.Messages); ok {
+		s.Summary = summary
+	}
+	s.InputTokens += result.InputTokens
+	s.OutputTokens += result.OutputTokens
+	s.ReasoningTokens += result.ReasoningTokens
+	s.CostUSD += result.CostUSD
+	if result.Plan != nil {
+		s.Plan = result.Plan
+	}
+	s.UpdatedAt = time.Now()
+}
+
+// CompactionPrefix starts the assistant message that replaces compacted
+// history.
+const CompactionPrefix = "[Context compacted — summary of earlier conversation]\n\n"
+
+// CompactionSummary returns the summary text of the first compaction
+// message in messages.
+func CompactionSummary(messages []provider.Message) (string, bool) {
+	for _, msg := range messages {
+		if summary, ok := strings.CutPrefix(msg.Content, CompactionPrefix); ok && msg.Role == "assistant" {
+			return summary, true
+		}
+	}
+	return "", false
+}
+
+// stateBlob is the serialized form of State. Its field names are part of
+// the format; change them only with a new StateVersion.
+type stateBlob struct {
+	Version         int                `json:"version"`
+	SessionID       string             `json:"sessionId,omitempty"`
+	Profile         string             `json:"profile,omitempty"`
+	Model           string             `json:"model,omitempty"`
+	Messages        []stateMessage     `json:"messages"`
+	EntryIDs        []int              `json:"entryIds,omitempty"`
+	Summary         string             `json:"summary,omitempty"`
+	InputTokens     int                `json:"inputTokens"`
+	OutputTokens    int                `json:"outputTokens"`
+	ReasoningTokens int                `json:"reasoningTokens,omitempty"`
+	CostUSD         float64            `json:"costUSD"`
+	Metadata        map[string]string  `json:"metadata,omitempty"`
+	UpdatedAt       time.Time          `json:"updatedAt"`
+	Queued          []QueuedMessage    `json:"queued,omitempty"`
+	Steering        []QueuedMessage    `json:"steering,omitempty"`
+	FollowUps       []QueuedMessage    `json:"followUps,omitempty"`
+	Plan            []session.PlanItem `json:"plan,omitempty"`
+}
+
+type stateMessage struct {
+	Role       string                `json:"role"`
+	Content    string                `json:"content,omitempty"`
+	ToolCallID string                `json:"toolCallId,omitempty"`
+	ToolName   string                `json:"toolName,omitempty"`
+	ToolCalls  []stateToolCall       `json:"toolCalls,omitempty"`
+	Raw        []provider.RawContent `json:"raw,omitempty"`
+	Images     []provider.Image      `json:"images,omitempty"`
+	Documents  []provider.Document   `json:"documents,omitempty"`
+	Labels     map[string]string     `json:"labels,omitempty"`
+}
+
+type stateToolCall struct {
+	ID        string         `json:"id"`
+	Tool      string         `json:"tool"`
+	Arguments map[string]any `json:"arguments,omitempty"`
+}
+
+// ExportState serializes s as a versioned JSON blob.
+func ExportState(s State) ([]byte, error) {
+	blob := stateBlob{
+		Version:         StateVersion,
+		SessionID:       s.SessionID,
+		Profile:         s.Profile,
+		Model:           s.Model,
+		Messages:        make([]stateMessage, 0, len(s.Messages)),
+		EntryIDs:        s.EntryIDs,
+		Summary:         s.Summary,
+		InputTokens:     s.InputTokens,
+		OutputTokens:    s.OutputTokens,
+		ReasoningTokens: s.ReasoningTokens,
+		CostUSD:         s.CostUSD,
+		Metadata:        s.Metadata,
+		UpdatedAt:       s.UpdatedAt,
+		Queued:          s.Queued,
+		Steering:        s.Steering,
+		FollowUps:       s.FollowUps,
+		Plan:            s.Plan,
+	}
+	for _, msg := range s.Messages {
+		m := stateMessage{Role: msg.Role, Content: msg.Content, ToolCallID: msg.ToolCallID, ToolName: msg.ToolName, Raw: msg.Raw, Images: msg.Images, Documents: msg.Documents, Labels: msg.Labels}
+		for _, call := range msg.ToolCalls {
+			m.ToolCalls = append(m.ToolCalls, stateToolCall{ID: call.ID, Tool: call.ToolID, Arguments: call.Arguments})
+		}
+		blob.Messages = append(blob.Messages, m)
+	}
+	return json.Marshal(blob)
+}
+
+// ImportState parses a blob written by ExportState.
+func ImportState(data []byte) (State, error) {
+	var blob stateBlob
+	if err := json.Unmarshal(data, &blob); err != nil {
+		return State{}, fmt.Errorf("parse state: %w", err)
+	}
+	switch {
+	case blob.Version == 0:
+		return State{}, errors.New("parse state: missing version")
+	case blob.Version > StateVersion:
+		return State{}, fmt.Errorf("parse state: version %d is newer than supported version %d", blob.Version, StateVersion)
+	}
+	s := State{
+		SessionID:       blob.SessionID,
+		Profile:         blob.Profile,
+		Model:           blob.Model,
+		Messages:        make([]provider.Message, 0, len(blob.Messages)),
+		EntryIDs:        blob.EntryIDs,
+		Summary:         blob.Summary,
+		InputTokens:     blob.InputTokens,
+		OutputTokens:    blob.OutputTokens,
+		ReasoningTokens: blob.ReasoningTokens,
+		CostUSD:         blob.CostUSD,
+		Metadata:        blob.Metadata,
+		UpdatedAt:       blob.UpdatedAt,
+		Queued:          blob.Queued,
+		Steering:        blob.Steering,
+		FollowUps:       blob.FollowUps,
+		Plan:            blob.Plan,
+	}
+	if len(blob.EntryIDs) > 0 && len(blob.EntryIDs) != len(blob.Messages) {
+		return State{}, fmt.Errorf("parse state: %d entry IDs for %d messages", len(blob.EntryIDs), len(blob.Messages))
+	}
+	for i, m := range blob.Messages {
+		if m.Role == "" {
+			return State{}, fmt.Errorf("parse state: message %d has no role", i)
+		}
+		msg := provider.Message{Role: m.Role, Content: m.Content, ToolCallID: m.ToolCallID, ToolName: m.ToolName, Raw: m.Raw, Images: m.Images, Documents: m.Documents, Labels: m.Labels}
+		for _, call := range m.ToolCalls {
+			msg.ToolCalls = append(msg.ToolCalls, tool.Call{ID: call.ID, ToolID: call.Tool, Arguments: call.Arguments})
+		}
+		s.Messages = append(s.Messages, msg)
+	}
+	return s, nil
+}
@@ -0,0 +1,126 @@
+package runtime
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+)
+
+// HistoryChanges counts what NormalizeHistory changed to carry a history
+// over to another provider.
+type HistoryChanges struct {
+	RewrittenIDs    int // tool call IDs the provider would reject
+	DroppedBlocks   int // Raw blocks of other providers, such as signed thinking
+	DroppedMessages int // assistant messages left empty without those blocks
+}
+
+// NormalizeHistory returns messages as a history to that provider can be
+// sent, for a conversation continuing on it after another provider, such
+// as during an outage:
+//
+//   - tool call IDs it rejects (see provider.ToolCallIDChecker) are
+//     rewritten, in the call and its result alike, to an ID every provider
+//     accepts;
+//   - Raw blocks produced by other providers are dropped, since only their
+//     producer can read them; an assistant message left with nothing else
+//     is dropped with them.
+//
+// It fails on a history no provider accepts: a tool result answering no
+// call before it, or a call without a result. messages is not modified.
+func NormalizeHistory(messages []provider.Message, to provider.Provider) ([]provider.Message, HistoryChanges, error) {
+	var changes HistoryChanges
+	checker, _ := to.(provider.ToolCallIDChecker)
+	valid := func(id string) bool {
+		if checker != nil {
+			return checker.ValidToolCallID(id)
+		}
+		return id != ""
+	}
+	ids := map[string]string{} // original tool call ID -> ID sent
+	pending := map[string]bool{}
+	out := make([]provider.Message, 0, len(messages))
+	for i, msg := range messages {
+		switch msg.Role {
+		case "user", "assistant":
+			for id := range pending {
+				return nil, changes, fmt.Errorf("message %d: tool call %q has no result", i+1, id)
+			}
+		case "tool":
+			id, ok := ids[msg.ToolCallID]
+			if !ok || !pending[msg.ToolCallID] {
+				return nil, changes, fmt.Errorf("message %d: tool result %q answers no tool call before it", i+1, msg.ToolCallID)
+			}
+			delete(pending, msg.ToolCallID)
+			if id != msg.ToolCallID {
+				msg.ToolCallID = id
+			}
+		}
+		if len(msg.Raw) > 0 {
+			var kept []provider.RawContent
+			for _, raw := range msg.Raw {
+				if raw.Provider == to.Name() {
+					kept = append(kept, raw)
+				} else {
+					changes.DroppedBlocks++
+				}
+			}
+			msg.Raw = kept
+			if msg.Role == "assistant" && msg.Content == "" && len(msg.ToolCalls) == 0 && len(kept) == 0 {
+				changes.DroppedMessages++
+				continue
+			}
+		}
+		if len(msg.ToolCalls) > 0 {
+			msg.ToolCalls = append(msg.ToolCalls[:0:0], msg.ToolCalls...)
+			for j, call := range msg.ToolCalls {
+				if _, seen := ids[call.ID]; seen {
+					return nil, changes, fmt.Errorf("message %d: duplicate tool call id %q", i+1, call.ID)
+				}
+				id := call.ID
+				if !valid(id) {
+					id = portableCallID(call.ID)
+					changes.RewrittenIDs++
+				}
+				ids[call.ID] = id
+				pending[call.ID] = true
+				msg.ToolCalls[j].ID = id
+			}
+		}
+		out = append(out, msg)
+	}
+	for id := range pending {
+		return nil, changes, fmt.Errorf("tool call %q has no result", id)
+	}
+	return out, changes, nil
+}
+
+// portableCallID derives from id a tool call ID every provider accepts,
+// the same each time so a history normalized twice agrees with itself.
+func portableCallID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return "call_" + hex.EncodeToString(sum[:12])
+}
+
+// SetProvider switches the conversation to p and model ("" for the
+// profile's model) from its next turn, of the running prompt too. The
+// transcript is checked now, so a history p cannot be sent fails here and
+// the conversation stays on its provider; when the switch applies, the
+// runtime rewrites it as NormalizeHistory does and records the switch in
+// the session.
+func (c *Conversation) SetProvider(p provider.Provider, model string) (HistoryChanges, error) {
+	c.mu.Lock()
+	req := c.Request
+	c.mu.Unlock()
+	_, changes, err := NormalizeHistory(req.Transcript, p)
+	if err != nil {
+		return changes, fmt.Errorf("switch to %s: %w", p.Name(), err)
+	}
+	reload := Reload{Profile: req.Profile, Model: model, Provider: p}
+	if current, ok := req.Reloader.Current(); ok {
+		reload.SystemPrompt, reload.Tools = current.SystemPrompt, current.Tools
+	}
+	c.Reconfigure(reload)
+	return changes, nil
+}
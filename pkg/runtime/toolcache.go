@@ -0,0 +1,42 @@
+package runtime
+
+import (
+	"sync"
+
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// ToolCache holds the results of cacheable tools for the runtime to reuse
+// when the profile sets tools.cache. A Conversation keeps one across its
+// runs, so a file read in one prompt is not read again in the next unless
+// another tool ran in between. It is safe for concurrent use; the zero
+// value is empty.
+type ToolCache struct {
+	mu      sync.Mutex
+	results map[string]tool.Result
+}
+
+// Get returns the result stored under key.
+func (c *ToolCache) Get(key string) (tool.Result, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result, ok := c.results[key]
+	return result, ok
+}
+
+// Put stores result under key.
+func (c *ToolCache) Put(key string, result tool.Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.results == nil {
+		c.results = make(map[string]tool.Result)
+	}
+	c.results[key] = result
+}
+
+// Clear empties the cache.
+func (c *ToolCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.results)
+}
@@ -0,0 +1,26 @@
+package runtime
+
+import (
+	"context"
+
+	"github.com/bitop-dev/agent/pkg/provider"
+)
+
+type toolChoiceKey struct{}
+
+// WithToolChoice sets the tool choice of the prompts run with ctx in place
+// of RunRequest.ToolChoice, such as forcing a structured extraction tool or
+// disabling tools for a final summary. Conversation prompts take it from
+// their ctx.
+func WithToolChoice(ctx context.Context, choice provider.ToolChoice) context.Context {
+	return context.WithValue(ctx, toolChoiceKey{}, choice)
+}
+
+// PromptToolChoice returns the tool choice of req's first turn: the one
+// set with WithToolChoice, or else req.ToolChoice.
+func PromptToolChoice(ctx context.Context, req RunRequest) provider.ToolChoice {
+	if choice, ok := ctx.Value(toolChoiceKey{}).(provider.ToolChoice); ok {
+		return choice
+	}
+	return req.ToolChoice
+}
@@ -0,0 +1,60 @@
+package session
+
+import (
+	"encoding/json"
+
+	"github.com/bitop-dev/agent/pkg/tool"
+)
+
+// EventArtifacts is the EventType of an EntryEvent whose metadata is a
+// TurnArtifacts.
+const EventArtifacts = "artifacts"
+
+// TurnArtifacts records the artifacts tools declared during one turn.
+type TurnArtifacts struct {
+	Turn      int        `json:"turn"`
+	Artifacts []Artifact `json:"artifacts"`
+}
+
+// Artifact is a tool.Artifact with the call that declared it.
+type Artifact struct {
+	tool.Artifact
+	ToolID string `json:"toolId,omitempty"`
+	Turn   int    `json:"turn,omitempty"`
+}
+
+// AddArtifacts adds artifacts to list, one per path: a path already
+// listed keeps its place and takes the newer declaration, except that a
+// file created and then modified stays "created".
+func AddArtifacts(list []Artifact, artifacts ...Artifact) []Artifact {
+next:
+	for _, a := range artifacts {
+		for i, existing := range list {
+			if existing.Path == a.Path {
+				if existing.Description == "created" && a.Description == "modified" {
+					a.Description = "created"
+				}
+				list[i] = a
+				continue next
+			}
+		}
+		list = append(list, a)
+	}
+	return list
+}
+
+// Artifacts returns the artifacts recorded in a session's entries, one
+// per path; see AddArtifacts.
+func Artifacts(entries []Entry) []Artifact {
+	var out []Artifact
+	for _, entry := range entries {
+		if entry.Kind != EntryEvent || entry.EventType != EventArtifacts {
+			continue
+		}
+		var turn TurnArtifacts
+		if json.Unmarshal([]byte(entry.Metadata), &turn) == nil {
+			out = AddArtifacts(out, turn.Artifacts...)
+		}
+	}
+	return out
+}
@@ -0,0 +1,159 @@
+package session
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// Ways Aggregate groups usage.
+const (
+	GroupByDay     = "day"
+	GroupByMonth   = "month"
+	GroupByProject = "project" // the session's working directory
+	GroupByProfile = "profile"
+	GroupByModel   = "model"
+)
+
+// AggregateQuery selects the usage Aggregate totals and how it groups it.
+type AggregateQuery struct {
+	Since   time.Time // only turns at or after Since
+	Until   time.Time // only turns before Until
+	GroupBy string    // one of the GroupBy constants; "" groups by day
+	// Location sets the day and month boundaries; nil is time.Local.
+	Location *time.Location
+	// Price returns the cost of a turn to provider's model, cacheReadTokens
+	// of whose input were read from the prompt cache and cacheWriteTokens
+	// written to it; provider is "" for turns recorded without one. nil
+	// leaves costs at zero.
+	Price func(provider, model string, inputTokens, outputTokens, cacheReadTokens, cacheWriteTokens int) (costUSD float64, priced bool)
+}
+
+// UsageTotals is the usage of one group of turns, or of all of them.
+type UsageTotals struct {
+	Key              string         `json:"key"`
+	Sessions         int            `json:"sessions"`
+	Turns            int            `json:"turns"`
+	InputTokens      int            `json:"inputTokens"`
+	OutputTokens     int            `json:"outputTokens"`
+	ReasoningTokens  int            `json:"reasoningTokens,omitempty"`
+	CacheReadTokens  int            `json:"cacheReadTokens,omitempty"`
+	CacheWriteTokens int            `json:"cacheWriteTokens,omitempty"`
+	CostUSD          float64        `json:"costUSD"`
+	UnpricedTurns    int            `json:"unpricedTurns,omitempty"` // turns of models without known pricing, not in CostUSD
+	Models           map[string]int `json:"models,omitempty"`        // turns per model
+	Tools            map[string]int `json:"tools,omitempty"`         // calls per tool
+}
+
+// Report is the result of Aggregate: one UsageTotals per group, in key
+// order, and their total.
+type Report struct {
+	GroupBy string        `json:"groupBy"`
+	Since   time.Time     `json:"since,omitzero"`
+	Until   time.Time     `json:"until,omitzero"`
+	Groups  []UsageTotals `json:"groups"`
+	Total   UsageTotals   `json:"total"`
+}
+
+// Aggregate totals the usage recorded on the assistant messages of
+// sessions: tokens, cost, the models that answered, and the tools they
+// called, grouped as q asks. A session counts toward every group it has a
+// turn in.
+func Aggregate(sessions []Session, q AggregateQuery) Report {
+	if q.GroupBy == "" {
+		q.GroupBy = GroupByDay
+	}
+	loc := q.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	report := Report{GroupBy: q.GroupBy, Since: q.Since, Until: q.Until, Total: UsageTotals{Key: "total"}}
+	groups := map[string]*UsageTotals{}
+	for _, s := range sessions {
+		seen := map[string]bool{}
+		counted := false
+		for _, entry := range s.Entries {
+			if entry.Kind != EntryMessage || entry.Role != "assistant" {
+				continue
+			}
+			if (!q.Since.IsZero() && entry.CreatedAt.Before(q.Since)) || (!q.Until.IsZero() && !entry.CreatedAt.Before(q.Until)) {
+				continue
+			}
+			var meta MessageMetadata
+			if entry.Metadata != "" {
+				_ = json.Unmarshal([]byte(entry.Metadata), &meta)
+			}
+			if meta.Usage == nil && len(meta.ToolCalls) == 0 {
+				continue
+			}
+			var key string
+			switch q.GroupBy {
+			case GroupByMonth:
+				key = entry.CreatedAt.In(loc).Format("2006-01")
+			case GroupByProject:
+				key = s.Metadata.CWD
+			case GroupByProfile:
+				key = s.Metadata.Profile
+			case GroupByModel:
+				if meta.Usage != nil {
+					key = meta.Usage.Model
+				}
+			default:
+				key = entry.CreatedAt.In(loc).Format("2006-01-02")
+			}
+			group := groups[key]
+			if group == nil {
+				group = &UsageTotals{Key: key}
+				groups[key] = group
+			}
+			if !seen[key] {
+				seen[key] = true
+				group.Sessions++
+			}
+			if !counted {
+				counted = true
+				report.Total.Sessions++
+			}
+			for _, totals := range []*UsageTotals{group, &report.Total} {
+				totals.add(meta, q.Price)
+			}
+		}
+	}
+	for _, group := range groups {
+		report.Groups = append(report.Groups, *group)
+	}
+	sort.Slice(report.Groups, func(i, j int) bool { return report.Groups[i].Key < report.Groups[j].Key })
+	return report
+}
+
+// add counts one assistant message into t.
+func (t *UsageTotals) add(meta MessageMetadata, price func(string, string, int, int, int, int) (float64, bool)) {
+	for _, call := range meta.ToolCalls {
+		if t.Tools == nil {
+			t.Tools = map[string]int{}
+		}
+		t.Tools[call.ToolID]++
+	}
+	usage := meta.Usage
+	if usage == nil {
+		return
+	}
+	t.Turns++
+	t.InputTokens += usage.InputTokens
+	t.OutputTokens += usage.OutputTokens
+	t.ReasoningTokens += usage.ReasoningTokens
+	t.CacheReadTokens += usage.CacheReadTokens
+	t.CacheWriteTokens += usage.CacheWriteTokens
+	if t.Models == nil {
+		t.Models = map[string]int{}
+	}
+	t.Models[usage.Model]++
+	if price == nil {
+		return
+	}
+	if cost, priced := price(usage.Provider, usage.Model, usage.InputTokens, usage.OutputTokens, usage.CacheReadTokens, usage.CacheWriteTokens); priced {
+		t.CostUSD += cost
+	} else {
+		t.UnpricedTurns++
+	}
+}
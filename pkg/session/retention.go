@@ -0,0 +1,89 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+)
+
+// RetentionPolicy decides which sessions GC removes and which it
+// compresses. Zero fields set no limit. Pinned sessions are never removed
+// or compressed, but count toward MaxBytes and MaxCount.
+type RetentionPolicy struct {
+	MaxAge   time.Duration // remove sessions not updated for longer than this
+	MaxBytes int64         // then remove the oldest sessions until the rest fit
+	MaxCount int           // then keep at most this many sessions, newest first
+	// CompressAfter compresses the entries of kept sessions not updated for
+	// longer than this. Compressed sessions load, list, and export as
+	// before, and are decompressed when appended to.
+	CompressAfter time.Duration
+	DryRun        bool // report what would change without changing it
+}
+
+// Stored is a session's metadata with the space its entries take.
+type Stored struct {
+	Metadata
+	Bytes      int64
+	Compressed bool
+}
+
+// GCResult reports what GC removed and compressed.
+type GCResult struct {
+	Removed    []Stored
+	Compressed []Stored
+	Kept       int
+	FreedBytes int64 // entry bytes of removed sessions, before any file compaction
+}
+
+// Collector is implemented by stores that can apply a retention policy
+// and pin sessions.
+type Collector interface {
+	GC(ctx context.Context, policy RetentionPolicy) (GCResult, error)
+	Pin(ctx context.Context, id string, pinned bool) error
+}
+
+// ErrNoCollector is returned for stores that do not implement Collector.
+var ErrNoCollector = errors.New("session store does not support garbage collection")
+
+// GC applies policy to store.
+func GC(ctx context.Context, store Store, policy RetentionPolicy) (GCResult, error) {
+	collector, ok := store.(Collector)
+	if !ok {
+		return GCResult{}, ErrNoCollector
+	}
+	return collector.GC(ctx, policy)
+}
+
+// Select splits sessions into those to remove and those to compress at
+// now. The result keeps the newest sessions first.
+func (p RetentionPolicy) Select(sessions []Stored, now time.Time) (remove, compress []Stored) {
+	sorted := append([]Stored(nil), sessions...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].UpdatedAt.After(sorted[j].UpdatedAt) })
+	var keptBytes int64
+	keptCount := 0
+	for _, s := range sorted {
+		if s.Pinned {
+			keptBytes += s.Bytes
+			keptCount++
+		}
+	}
+	full := false
+	for _, s := range sorted {
+		if s.Pinned {
+			continue
+		}
+		age := now.Sub(s.UpdatedAt)
+		full = full || (p.MaxCount > 0 && keptCount >= p.MaxCount) || (p.MaxBytes > 0 && keptBytes+s.Bytes > p.MaxBytes)
+		if full || (p.MaxAge > 0 && age > p.MaxAge) {
+			remove = append(remove, s)
+			continue
+		}
+		keptBytes += s.Bytes
+		keptCount++
+		if p.CompressAfter > 0 && age > p.CompressAfter && !s.Compressed {
+			compress = append(compress, s)
+		}
+	}
+	return remove, compress
+}
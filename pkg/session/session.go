@@ -2,8 +2,13 @@ package session
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"time"
 
+	"github.com/bitop-dev/agent/pkg/provider"
 	"github.com/bitop-dev/agent/pkg/tool"
 )
 
@@ -13,6 +18,11 @@ type Metadata struct {
 	CWD       string
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	Pinned    bool // kept by GC whatever the retention policy
+	// Title and Summary describe the conversation, from its latest
+	// EntryTitle; both are empty until it is titled.
+	Title   string
+	Summary string
 }
 
 type EntryKind string
@@ -21,6 +31,8 @@ const (
 	EntryMessage    EntryKind = "message"
 	EntryEvent      EntryKind = "event"
 	EntryCompaction EntryKind = "compaction" // structured summary replacing older messages
+	EntryHandoff    EntryKind = "handoff"    // the conversation continues under another profile or model
+	EntryTitle      EntryKind = "title"      // names the session; metadata is a Title
 )
 
 type Entry struct {
@@ -36,6 +48,198 @@ type MessageMetadata struct {
 	ToolCallID string      `json:"toolCallId,omitempty"`
 	ToolName   string      `json:"toolName,omitempty"`
 	ToolCalls  []tool.Call `json:"toolCalls,omitempty"`
+	Usage      *TurnUsage  `json:"usage,omitempty"`
+	// Raw preserves content blocks of types the runtime does not model.
+	Raw []provider.RawContent `json:"raw,omitempty"`
+	// Images attached to a user message.
+	Images []provider.Image `json:"images,omitempty"`
+	// Documents attached to a user message.
+	Documents []provider.Document `json:"documents,omitempty"`
+	// Labels attributing the message; see provider.Message.Labels.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Aborted marks the result of a tool that was still running when the
+	// run was aborted; its content is whatever the tool returned by then.
+	Aborted bool `json:"aborted,omitempty"`
+}
+
+// TurnUsage records what one assistant turn cost, for exports and reports.
+type TurnUsage struct {
+	Provider         string `json:"provider,omitempty"`
+	Model            string `json:"model,omitempty"`
+	InputTokens      int    `json:"inputTokens"`
+	OutputTokens     int    `json:"outputTokens"`
+	ReasoningTokens  int    `json:"reasoningTokens,omitempty"`  // part of OutputTokens spent on hidden reasoning
+	CacheReadTokens  int    `json:"cacheReadTokens,omitempty"`  // part of InputTokens read from the provider's prompt cache
+	CacheWriteTokens int    `json:"cacheWriteTokens,omitempty"` // part of InputTokens written to the provider's prompt cache
+	DurationMs       int64  `json:"durationMs,omitempty"`
+	// ArgumentRetries counts tool calls rejected for invalid arguments and
+	// sent back to the model in this turn.
+	ArgumentRetries int `json:"argumentRetries,omitempty"`
+}
+
+// Handoff is the metadata of an EntryHandoff entry.
+type Handoff struct {
+	FromProfile string `json:"fromProfile"`
+	FromModel   string `json:"fromModel,omitempty"`
+	ToProfile   string `json:"toProfile"`
+	ToModel     string `json:"toModel,omitempty"` // "" uses the profile's model
+	Reason      string `json:"reason,omitempty"`
+}
+
+// Title is the metadata of an EntryTitle entry.
+type Title struct {
+	Title   string `json:"title"`
+	Summary string `json:"summary,omitempty"` // one line on what the conversation is about
+	Model   string `json:"model,omitempty"`   // the model that wrote them
+}
+
+// EventTurnContext is the EventType of an EntryEvent whose metadata is a
+// TurnContext.
+const EventTurnContext = "turn_context"
+
+// TurnContext records what one provider call was sent, so a run can be
+// stepped through after the fact. The conversation history is referenced
+// by count rather than copied: it is the History message entries that
+// precede the entry, plus the synthetic messages the runtime added around
+// them.
+type TurnContext struct {
+	Turn       int                `json:"turn"`
+	Model      string             `json:"model"`
+	System     string             `json:"system,omitempty"` // omitted when unchanged since the previous call of the run
+	SystemHash string             `json:"systemHash"`
+	Tools      []string           `json:"tools,omitempty"`  // tool IDs offered to the model
+	Prefix     []provider.Message `json:"prefix,omitempty"` // sent before the history, e.g. a compaction summary
+	History    int                `json:"history"`
+	Suffix     []provider.Message `json:"suffix,omitempty"` // sent after the history, e.g. a retry nudge
+}
+
+// EventFilesChanged is the EventType of an EntryEvent whose metadata is a
+// TurnFiles.
+const EventFilesChanged = "files_changed"
+
+// TurnFiles records the workspace files one turn changed, as found by
+// comparing the workspace before and after it (profile workspace.watch).
+type TurnFiles struct {
+	Turn  int          `json:"turn"`
+	Files []FileChange `json:"files"`
+}
+
+// FileChange is one changed file, relative to the workspace root.
+type FileChange struct {
+	Path   string `json:"path"`
+	Change string `json:"change"` // "added", "modified", or "deleted"
+}
+
+// EventPlanUpdated is the EventType of an EntryEvent whose metadata is a
+// Plan.
+const EventPlanUpdated = "plan_updated"
+
+// Plan is the task list the model keeps with the core/plan tool, as of
+// one turn.
+type Plan struct {
+	Turn  int        `json:"turn"`
+	Items []PlanItem `json:"items"`
+}
+
+// Plan item statuses.
+const (
+	PlanPending    = "pending"
+	PlanInProgress = "in_progress"
+	PlanCompleted  = "completed"
+)
+
+// PlanItem is one task of a Plan.
+type PlanItem struct {
+	Content string `json:"content"`
+	Status  string `json:"status"` // one of the Plan statuses
+}
+
+// EventMessagePinned is the EventType of an EntryEvent whose metadata is
+// a Pin.
+const EventMessagePinned = "message_pinned"
+
+// Pin marks a message of the history as kept verbatim by compaction, or
+// clears the mark. The message is identified by its digest from
+// MessageDigests, which survives compaction reordering the messages around
+// it.
+type Pin struct {
+	Digest string `json:"digest"`
+	Pinned bool   `json:"pinned"`
+}
+
+// MessageDigests identifies each message of a history by its role,
+// content, and tool calls, for a Pin. A message repeated in the history,
+// such as a second "yes", also mixes in how many copies come before it, so
+// each copy is pinned on its own.
+func MessageDigests(messages []provider.Message) []string {
+	digests := make([]string, len(messages))
+	seen := map[string]int{}
+	for i, msg := range messages {
+		h := sha256.New()
+		fmt.Fprintf(h, "%s\x00%s\x00%s", msg.Role, msg.ToolCallID, msg.Content)
+		for _, call := range msg.ToolCalls {
+			fmt.Fprintf(h, "\x00%s", call.ID)
+		}
+		key := string(h.Sum(nil))
+		if n := seen[key]; n > 0 {
+			fmt.Fprintf(h, "\x00#%d", n)
+		}
+		seen[key]++
+		digests[i] = hex.EncodeToString(h.Sum(nil)[:12])
+	}
+	return digests
+}
+
+// EventProviderSwitched is the EventType of an EntryEvent whose metadata is
+// a ProviderSwitch.
+const EventProviderSwitched = "provider_switched"
+
+// ProviderSwitch marks where a conversation continued on another provider,
+// and what carrying its history over changed; see runtime.NormalizeHistory.
+type ProviderSwitch struct {
+	From            string `json:"from"`
+	To              string `json:"to"`
+	Model           string `json:"model,omitempty"`
+	RewrittenIDs    int    `json:"rewrittenIds,omitempty"`
+	DroppedBlocks   int    `json:"droppedBlocks,omitempty"`
+	DroppedMessages int    `json:"droppedMessages,omitempty"`
+}
+
+// EventDeadlineExceeded is the EventType of an EntryEvent whose metadata is
+// a Deadline.
+const EventDeadlineExceeded = "deadline_exceeded"
+
+// Deadline records a run stopped by one of its time limits.
+type Deadline struct {
+	Limit   string `json:"limit"`   // "turn" or "run"
+	LimitMs int64  `json:"limitMs"` // the limit reached
+	Turn    int    `json:"turn"`    // the turn cut short, or about to start
+	// ElapsedMs is how long the turn, or the run, had been going.
+	ElapsedMs int64 `json:"elapsedMs"`
+}
+
+// EventMessageEdited is the EventType of an EntryEvent whose metadata is a
+// MessageEdit.
+const EventMessageEdited = "message_edited"
+
+// MessageEdit records that a session was forked from another by editing
+// one of its user messages and running the conversation again from there.
+type MessageEdit struct {
+	FromSession string `json:"fromSession,omitempty"` // "" for a conversation that was not saved
+	Index       int    `json:"index"`                 // the edited message, from 0
+	Dropped     int    `json:"dropped"`               // messages replaced by the rerun, the edited one included
+}
+
+// EventLogRecovered is the EventType of an EntryEvent whose metadata is a
+// LogRecovery.
+const EventLogRecovered = "log_recovered"
+
+// LogRecovery records the damaged end of a compressed session log cut off
+// when the session was next loaded, as left by a crash mid-write. The
+// entries of the damaged record are lost; every earlier one is kept.
+type LogRecovery struct {
+	Offset       int64 `json:"offset"` // the end of the last whole record
+	DroppedBytes int64 `json:"droppedBytes"`
 }
 
 type Session struct {
@@ -43,6 +247,22 @@ type Session struct {
 	Entries  []Entry
 }
 
+// Active returns the profile and model the session continues with: the
+// target of its latest handoff, or the profile it was created with and ""
+// for the profile's own model.
+func (s Session) Active() (profile, model string) {
+	for i := len(s.Entries) - 1; i >= 0; i-- {
+		if s.Entries[i].Kind != EntryHandoff {
+			continue
+		}
+		var h Handoff
+		if err := json.Unmarshal([]byte(s.Entries[i].Metadata), &h); err == nil && h.ToProfile != "" {
+			return h.ToProfile, h.ToModel
+		}
+	}
+	return s.Metadata.Profile, ""
+}
+
 type Store interface {
 	Create(ctx context.Context, meta Metadata) (Session, error)
 	Load(ctx context.Context, id string) (Session, error)
@@ -50,6 +270,57 @@ type Store interface {
 	MostRecent(ctx context.Context, cwd string) (Session, error)
 	List(ctx context.Context, cwd string, limit int) ([]Metadata, error)
 	Count(ctx context.Context, cwd string) (int, error)
+	Search(ctx context.Context, query SearchQuery) ([]SearchResult, error)
+}
+
+// Closer is implemented by stores that hold back writes of a session, such
+// as one mirroring sessions to object storage. The runtime closes a
+// session when a run on it ends.
+type Closer interface {
+	Close(ctx context.Context, id string) error
+}
+
+// Close closes session id in store if the store is a Closer. Callers that
+// append to a session outside a run use it to write the entries out.
+func Close(ctx context.Context, store Store, id string) error {
+	if closer, ok := store.(Closer); ok {
+		return closer.Close(ctx, id)
+	}
+	return nil
+}
+
+// Importer is implemented by stores that can add a whole session as it
+// is, keeping its metadata, such as a session copied back from a mirror.
+// A failed import leaves nothing of the session behind.
+type Importer interface {
+	Import(ctx context.Context, sess Session) error
+}
+
+// SearchQuery finds sessions whose user, assistant, and tool messages
+// contain every whitespace-separated term of Text, case-insensitively, or
+// that have messages with the given Labels.
+type SearchQuery struct {
+	Text  string
+	CWD   string    // "" searches every directory
+	Since time.Time // only messages at or after Since
+	Until time.Time // only messages before Until
+	Model string    // prefix of a model that answered in the session
+	// Labels keeps sessions with a message carrying every label. With no
+	// Text, the labeled messages are the hits.
+	Labels map[string]string
+	Limit  int // maximum sessions; defaults to 20
+}
+
+// SearchResult is one matching session, with its matching messages in order.
+type SearchResult struct {
+	Metadata Metadata
+	Hits     []SearchHit
+}
+
+type SearchHit struct {
+	Role      string
+	Snippet   string // the message around its first matching term
+	CreatedAt time.Time
 }
 
 // TaskState represents a persistent long-running task (pipeline or complex workflow).
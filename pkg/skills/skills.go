@@ -0,0 +1,292 @@
+// Package skills loads skill directories: a SKILL.md file whose YAML front
+// matter names and describes the skill and whose body holds its full
+// instructions, plus optional helper scripts and resource files.
+//
+//	pdf/
+//	  SKILL.md
+//	  scripts/extract.py
+//	  reference/forms.md
+//
+// Only the name and description are shown to the model up front; the body
+// is loaded when the model asks for the skill, and scripts become tools
+// namespaced by the skill's name.
+package skills
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileName is the skill definition inside a skill directory.
+const FileName = "SKILL.md"
+
+// ScriptsDir holds executables that are offered as tools when the front
+// matter declares no scripts.
+const ScriptsDir = "scripts"
+
+// Tool names every skill provides; scripts may not use them.
+const (
+	LoadTool = "load" // returns the skill body and what it provides
+	ReadTool = "read" // returns one resource file
+)
+
+// maxResources bounds the resource listing of a skill directory.
+const maxResources = 200
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Skill is a loaded skill directory.
+type Skill struct {
+	Name        string
+	Description string
+	Dir         string // absolute path of the skill directory
+	Body        string // SKILL.md without its front matter
+	Scripts     []Script
+	Resources   []string // slash-separated paths relative to Dir, excluding SKILL.md and scripts
+}
+
+// Script is an executable the skill exposes as the tool <skill>/<name>.
+type Script struct {
+	Name        string `yaml:"name"`
+	Path        string `yaml:"path"` // relative to the skill directory
+	Description string `yaml:"description,omitempty"`
+	// ReadOnly declares that the script does not modify the workspace, so
+	// read-only profiles may run it.
+	ReadOnly bool `yaml:"readOnly,omitempty"`
+}
+
+type frontMatter struct {
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"description"`
+	Scripts     []Script `yaml:"scripts,omitempty"`
+}
+
+// ToolID returns the ID of the skill tool called name.
+func (s Skill) ToolID(name string) string {
+	return s.Name + "/" + name
+}
+
+// ToolIDs returns the IDs of every tool the skill provides.
+func (s Skill) ToolIDs() []string {
+	ids := []string{s.ToolID(LoadTool)}
+	if len(s.Resources) > 0 {
+		ids = append(ids, s.ToolID(ReadTool))
+	}
+	for _, script := range s.Scripts {
+		ids = append(ids, s.ToolID(script.Name))
+	}
+	return ids
+}
+
+// Load reads the skill in dir.
+func Load(dir string) (Skill, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return Skill{}, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, FileName))
+	if err != nil {
+		return Skill{}, err
+	}
+	meta, body, err := splitFrontMatter(data)
+	if err != nil {
+		return Skill{}, fmt.Errorf("skill %s: %w", dir, err)
+	}
+	if meta.Name == "" {
+		meta.Name = filepath.Base(dir)
+	}
+	if !namePattern.MatchString(meta.Name) {
+		return Skill{}, fmt.Errorf("skill %s: invalid name %q (lowercase letters, digits, - and _)", dir, meta.Name)
+	}
+	if strings.TrimSpace(meta.Description) == "" {
+		return Skill{}, fmt.Errorf("skill %s: description is required", meta.Name)
+	}
+	s := Skill{Name: meta.Name, Description: strings.TrimSpace(meta.Description), Dir: dir, Body: strings.TrimSpace(body), Scripts: meta.Scripts}
+	if len(s.Scripts) == 0 {
+		if s.Scripts, err = discoverScripts(dir); err != nil {
+			return Skill{}, fmt.Errorf("skill %s: %w", s.Name, err)
+		}
+	}
+	scriptPaths := map[string]bool{}
+	for i, script := range s.Scripts {
+		if !namePattern.MatchString(script.Name) || script.Name == LoadTool || script.Name == ReadTool {
+			return Skill{}, fmt.Errorf("skill %s: invalid script name %q", s.Name, script.Name)
+		}
+		path, err := Resolve(dir, script.Path)
+		if err != nil {
+			return Skill{}, fmt.Errorf("skill %s: script %s: %w", s.Name, script.Name, err)
+		}
+		if info, err := os.Stat(path); err != nil {
+			return Skill{}, fmt.Errorf("skill %s: script %s: %w", s.Name, script.Name, err)
+		} else if info.IsDir() {
+			return Skill{}, fmt.Errorf("skill %s: script %s is a directory", s.Name, script.Name)
+		}
+		s.Scripts[i].Path = filepath.ToSlash(filepath.Clean(script.Path))
+		scriptPaths[s.Scripts[i].Path] = true
+	}
+	if s.Resources, err = listResources(dir, scriptPaths); err != nil {
+		return Skill{}, fmt.Errorf("skill %s: %w", s.Name, err)
+	}
+	return s, nil
+}
+
+// Discover loads every skill directory directly under roots. A skill found
+// in an earlier root shadows one of the same name in a later root, so
+// project skills override user skills. Missing roots are skipped.
+func Discover(roots ...string) ([]Skill, error) {
+	var out []Skill
+	seen := map[string]bool{}
+	for _, root := range roots {
+		if root == "" {
+			continue
+		}
+		entries, err := os.ReadDir(root)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			dir := filepath.Join(root, entry.Name())
+			if !entry.IsDir() {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(dir, FileName)); err != nil {
+				continue
+			}
+			s, err := Load(dir)
+			if err != nil {
+				return nil, err
+			}
+			if seen[s.Name] {
+				continue
+			}
+			seen[s.Name] = true
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+// Select returns the skills named in names, in catalog order; "*" selects
+// every skill.
+func Select(catalog []Skill, names []string) ([]Skill, error) {
+	if slices.Contains(names, "*") {
+		return catalog, nil
+	}
+	var out []Skill
+	for _, name := range names {
+		i := slices.IndexFunc(catalog, func(s Skill) bool { return s.Name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("skill %q not found", name)
+		}
+		out = append(out, catalog[i])
+	}
+	return out, nil
+}
+
+// Resolve returns the absolute path of rel inside dir, rejecting paths
+// that would leave it.
+func Resolve(dir, rel string) (string, error) {
+	if rel == "" || filepath.IsAbs(rel) {
+		return "", fmt.Errorf("path %q must be relative to the skill directory", rel)
+	}
+	path := filepath.Join(dir, filepath.FromSlash(rel))
+	if inside, err := filepath.Rel(dir, path); err != nil || inside == ".." || strings.HasPrefix(inside, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %q is outside the skill directory", rel)
+	}
+	return path, nil
+}
+
+func splitFrontMatter(data []byte) (frontMatter, string, error) {
+	var meta frontMatter
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	rest, ok := bytes.CutPrefix(data, []byte("---\n"))
+	if !ok {
+		rest, ok = bytes.CutPrefix(data, []byte("---\r\n"))
+	}
+	if !ok {
+		return meta, "", errors.New(FileName + " must start with YAML front matter (---)")
+	}
+	end := bytes.Index(rest, []byte("\n---"))
+	if end < 0 {
+		return meta, "", errors.New(FileName + ": unterminated front matter")
+	}
+	if err := yaml.Unmarshal(rest[:end], &meta); err != nil {
+		return meta, "", fmt.Errorf("front matter: %w", err)
+	}
+	body := rest[end+len("\n---"):]
+	if i := bytes.IndexByte(body, '\n'); i >= 0 {
+		body = body[i+1:]
+	} else {
+		body = nil
+	}
+	return meta, string(body), nil
+}
+
+// discoverScripts offers the executable files in scripts/ as tools named
+// after the file without its extension.
+func discoverScripts(dir string) ([]Script, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, ScriptsDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var scripts []Script
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0o111 == 0 {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())))
+		if !namePattern.MatchString(name) || name == LoadTool || name == ReadTool {
+			continue
+		}
+		scripts = append(scripts, Script{Name: name, Path: ScriptsDir + "/" + entry.Name()})
+	}
+	return scripts, nil
+}
+
+func listResources(dir string, scripts map[string]bool) ([]string, error) {
+	var out []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && path != dir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == FileName || scripts[rel] {
+			return nil
+		}
+		if len(out) == maxResources {
+			return fs.SkipAll
+		}
+		out = append(out, rel)
+		return nil
+	})
+	return out, err
+}
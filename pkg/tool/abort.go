@@ -0,0 +1,21 @@
+package tool
+
+import (
+	"context"
+	"time"
+)
+
+type abortGraceKey struct{}
+
+// WithAbortGrace tells the tool how long it has, once its context is
+// canceled because the run was aborted, to stop cleanly and return what it
+// has so far.
+func WithAbortGrace(ctx context.Context, grace time.Duration) context.Context {
+	return context.WithValue(ctx, abortGraceKey{}, grace)
+}
+
+// AbortGraceFrom returns the grace period attached by WithAbortGrace.
+func AbortGraceFrom(ctx context.Context) (time.Duration, bool) {
+	grace, ok := ctx.Value(abortGraceKey{}).(time.Duration)
+	return grace, ok
+}
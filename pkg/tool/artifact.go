@@ -0,0 +1,35 @@
+package tool
+
+import "encoding/json"
+
+// Artifact is a file a tool call produced or changed, declared in its
+// Result so the run can report what it made.
+type Artifact struct {
+	Path string `json:"path"`
+	// Type is the kind of output, such as "file", "report", or "image".
+	Type string `json:"type,omitempty"`
+	// Description says what the file holds, or "created" or "modified"
+	// for a file a tool wrote.
+	Description string `json:"description,omitempty"`
+}
+
+// DeclaredArtifacts returns the artifacts r declares: its Artifacts, then
+// those listed under Data["artifacts"] by tools that return only data,
+// such as plugins, each an object with the fields of Artifact. Entries
+// without a path are skipped.
+func (r Result) DeclaredArtifacts() []Artifact {
+	out := append([]Artifact{}, r.Artifacts...)
+	if listed, ok := r.Data["artifacts"]; ok {
+		var decoded []Artifact
+		if data, err := json.Marshal(listed); err == nil && json.Unmarshal(data, &decoded) == nil {
+			out = append(out, decoded...)
+		}
+	}
+	kept := out[:0]
+	for _, a := range out {
+		if a.Path != "" {
+			kept = append(kept, a)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,138 @@
+package tool
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// CallContext is the environment of one tool call, given by the runtime
+// so a tool need not fix it when it is registered: the directory the run
+// works in, which can change between the calls of a session, the session,
+// the conversation so far, and where to log and keep temporary files.
+type CallContext struct {
+	CallID    string
+	CWD       string // the run's working directory; "" when unknown
+	SessionID string
+	// ScratchDir is a directory for the session's temporary files; see
+	// Scratch.
+	ScratchDir string
+	// Transcript returns the conversation up to the call. It is nil
+	// outside a run.
+	Transcript func() []Message
+	// Log records a diagnostic line about the call, published as a
+	// tool_log event. It is nil outside a run.
+	Log func(message string)
+	// CheckRead returns an error when the run's policy does not let the
+	// call read path, for tools that read files their arguments were not
+	// checked for, such as a dry run. It is nil when nothing restricts
+	// reads.
+	CheckRead func(ctx context.Context, path string) error
+}
+
+// Message is a conversation message as a tool sees it.
+type Message struct {
+	Role       string // "user", "assistant", or "tool"
+	Content    string
+	ToolCalls  []Call // an assistant message's tool calls
+	ToolCallID string // the call a tool message answers
+}
+
+// Path resolves path against CWD; an absolute path, or any path when CWD
+// is unknown, is returned cleaned.
+func (c CallContext) Path(path string) string {
+	if filepath.IsAbs(path) || c.CWD == "" {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(c.CWD, path)
+}
+
+// Scratch returns ScratchDir, creating it on first use.
+func (c CallContext) Scratch() (string, error) {
+	if c.ScratchDir == "" {
+		return os.MkdirTemp("", "agent-scratch-")
+	}
+	return c.ScratchDir, os.MkdirAll(c.ScratchDir, 0o700)
+}
+
+// Logf calls Log, if set, with a formatted message.
+func (c CallContext) Logf(format string, args ...any) {
+	if c.Log != nil {
+		c.Log(fmt.Sprintf(format, args...))
+	}
+}
+
+type callContextKey struct{}
+
+// WithCallContext attaches cc to ctx for the duration of one tool call.
+func WithCallContext(ctx context.Context, cc CallContext) context.Context {
+	return context.WithValue(ctx, callContextKey{}, cc)
+}
+
+// CallContextFrom returns the context attached by WithCallContext, which
+// any tool's Run can read.
+func CallContextFrom(ctx context.Context) (CallContext, bool) {
+	cc, ok := ctx.Value(callContextKey{}).(CallContext)
+	return cc, ok
+}
+
+// ContextTool is a tool written against CallContext: Execute is given the
+// environment of each call rather than reading it from ctx. Adapt turns it
+// into a Tool for a Registry.
+type ContextTool interface {
+	Definition() Definition
+	Execute(ctx context.Context, cc CallContext, call Call) (Result, error)
+}
+
+// Adapt returns t as a Tool whose Run passes Execute the CallContext
+// attached to ctx, or the zero value outside a run. t's capabilities,
+// namespace, dry run, session state, and Cloner carry over; a ContextTool's
+// Clone returns its copy adapted.
+func Adapt(t ContextTool) Tool {
+	return adapted{t}
+}
+
+type adapted struct{ ContextTool }
+
+func (a adapted) Run(ctx context.Context, call Call) (Result, error) {
+	cc, _ := CallContextFrom(ctx)
+	if cc.CallID == "" {
+		cc.CallID = call.ID
+	}
+	return a.Execute(ctx, cc, call)
+}
+
+func (a adapted) Capabilities() Capabilities {
+	if d, ok := a.ContextTool.(Describer); ok {
+		return d.Capabilities()
+	}
+	return Capabilities{}
+}
+
+func (a adapted) Namespace() string {
+	if n, ok := a.ContextTool.(Namespaced); ok {
+		return n.Namespace()
+	}
+	return ""
+}
+
+func (a adapted) DryRun(ctx context.Context, call Call) (string, error) {
+	if runner, ok := a.ContextTool.(DryRunner); ok {
+		return runner.DryRun(ctx, call)
+	}
+	return "", nil
+}
+
+func (a adapted) Clone() Tool {
+	if c, ok := a.ContextTool.(Cloner); ok {
+		return c.Clone()
+	}
+	return a
+}
+
+func (a adapted) CloseSession(sessionID string) {
+	if closer, ok := a.ContextTool.(SessionCloser); ok {
+		closer.CloseSession(sessionID)
+	}
+}
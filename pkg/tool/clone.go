@@ -0,0 +1,24 @@
+package tool
+
+// Cloner is implemented by tools that keep state in the instance, such as
+// a scratch buffer or a counter, so each run forked from another, as
+// Conversation.Ask forks, gets its own copy instead of sharing it. A tool
+// wrapping another implements Cloner to clone what it wraps.
+type Cloner interface {
+	Clone() Tool
+}
+
+// Clone returns tools with each Cloner replaced by its clone.
+func Clone(tools []Tool) []Tool {
+	if tools == nil {
+		return nil
+	}
+	out := make([]Tool, len(tools))
+	for i, t := range tools {
+		if c, ok := t.(Cloner); ok {
+			t = c.Clone()
+		}
+		out[i] = t
+	}
+	return out
+}
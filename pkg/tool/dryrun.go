@@ -0,0 +1,13 @@
+package tool
+
+import "context"
+
+// DryRunner is implemented by tools that can tell what a call would change
+// without changing it, so an approval prompt shows the change itself
+// rather than the call's arguments.
+type DryRunner interface {
+	// DryRun returns the unified diff of the files call would write, or ""
+	// when it writes none or the tool cannot tell, as for a shell command
+	// other than a heredoc.
+	DryRun(ctx context.Context, call Call) (string, error)
+}
@@ -0,0 +1,92 @@
+package tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+)
+
+// ErrorCode classifies a tool failure so the runtime, approval policies, and
+// the model can react to it without parsing messages.
+type ErrorCode string
+
+const (
+	ErrNotFound         ErrorCode = "not_found"
+	ErrPermissionDenied ErrorCode = "permission_denied"
+	ErrTimeout          ErrorCode = "timeout"
+	ErrInvalidArgs      ErrorCode = "invalid_args"
+	ErrTransient        ErrorCode = "transient" // safe to retry unchanged
+	ErrUnknown          ErrorCode = "unknown"
+)
+
+// Error is a tool failure with a machine-readable code.
+type Error struct {
+	Code ErrorCode
+	Err  error
+}
+
+// Errorf returns an *Error with the given code. The format supports %w.
+func Errorf(code ErrorCode, format string, args ...any) error {
+	return &Error{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+// WrapError attaches code to err. A nil err stays nil.
+func WrapError(code ErrorCode, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Code: code, Err: err}
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// CodeOf classifies err. An *Error anywhere in the chain wins; otherwise
+// well-known standard library errors are mapped, and anything else is
+// ErrUnknown.
+func CodeOf(err error) ErrorCode {
+	var te *Error
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &te):
+		return te.Code
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return ErrTimeout
+	case errors.Is(err, fs.ErrNotExist):
+		return ErrNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return ErrPermissionDenied
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrTimeout
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET):
+		return ErrTransient
+	default:
+		return ErrUnknown
+	}
+}
+
+// CodeForStatus maps an HTTP status from a tool backend to an error code.
+func CodeForStatus(status int) ErrorCode {
+	switch {
+	case status == http.StatusNotFound:
+		return ErrNotFound
+	case status == http.StatusUnauthorized, status == http.StatusForbidden:
+		return ErrPermissionDenied
+	case status == http.StatusBadRequest, status == http.StatusUnprocessableEntity:
+		return ErrInvalidArgs
+	case status == http.StatusRequestTimeout, status == http.StatusGatewayTimeout:
+		return ErrTimeout
+	case status == http.StatusTooManyRequests, status == http.StatusBadGateway, status == http.StatusServiceUnavailable:
+		return ErrTransient
+	default:
+		return ErrUnknown
+	}
+}
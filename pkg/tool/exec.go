@@ -0,0 +1,33 @@
+package tool
+
+import "context"
+
+// ExecOptions are per-tool process settings configured in a profile and
+// applied by tools that start subprocesses. Dir is absolute.
+type ExecOptions struct {
+	Dir    string
+	Env    []string // KEY=VALUE additions to the inherited environment
+	Nice   int
+	IONice string // "idle", "best-effort[:level]", or "realtime[:level]"
+	Umask  string // octal, e.g. "027"
+	// AllowedCommands are the programs a shell tool may run; empty allows
+	// any.
+	AllowedCommands []string
+	// Persistent runs a shell tool's commands in one long-lived shell per
+	// session, so cd, exported variables, and activated virtualenvs carry
+	// over from one call to the next.
+	Persistent bool
+}
+
+type execOptionsKey struct{}
+
+// WithExecOptions attaches opts to ctx for the duration of one tool call.
+func WithExecOptions(ctx context.Context, opts ExecOptions) context.Context {
+	return context.WithValue(ctx, execOptionsKey{}, opts)
+}
+
+// ExecOptionsFrom returns the options attached by WithExecOptions.
+func ExecOptionsFrom(ctx context.Context) (ExecOptions, bool) {
+	opts, ok := ctx.Value(execOptionsKey{}).(ExecOptions)
+	return opts, ok
+}
@@ -0,0 +1,23 @@
+package tool
+
+import "context"
+
+// Model describes the model a tool call is made for.
+type Model struct {
+	Name   string
+	Vision bool // the model accepts images in tool results
+}
+
+type modelKey struct{}
+
+// WithModel attaches the calling model to ctx for the duration of one tool
+// call.
+func WithModel(ctx context.Context, model Model) context.Context {
+	return context.WithValue(ctx, modelKey{}, model)
+}
+
+// ModelFrom returns the model attached by WithModel.
+func ModelFrom(ctx context.Context) (Model, bool) {
+	model, ok := ctx.Value(modelKey{}).(Model)
+	return model, ok
+}
@@ -0,0 +1,130 @@
+package tool
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Namespaces group tools by where they come from. A qualified tool ID is
+// "namespace:id", e.g. "builtin:core/read" or "mcp:search".
+const (
+	NamespaceBuiltin = "builtin"
+	NamespacePlugin  = "plugin"
+	NamespaceMCP     = "mcp"
+)
+
+// Namespaced is implemented by tools that do not belong to the builtin
+// namespace.
+type Namespaced interface {
+	Namespace() string
+}
+
+// NamespaceOf returns t's namespace, NamespaceBuiltin unless it says otherwise.
+func NamespaceOf(t Tool) string {
+	if n, ok := As[Namespaced](t); ok && n.Namespace() != "" {
+		return n.Namespace()
+	}
+	return NamespaceBuiltin
+}
+
+// QualifiedID returns t's ID prefixed with its namespace.
+func QualifiedID(t Tool) string {
+	return NamespaceOf(t) + ":" + t.Definition().ID
+}
+
+// SplitQualified splits a qualified ID into its namespace and tool ID. An
+// unqualified ID has no namespace. Tool IDs may contain "/" but not ":".
+func SplitQualified(id string) (namespace, toolID string) {
+	if ns, rest, ok := strings.Cut(id, ":"); ok {
+		return ns, rest
+	}
+	return "", id
+}
+
+// Toggles switches tools off and on while a conversation is running. The
+// runtime consults them before every turn, so a change takes effect on the
+// next provider call. Keys are qualified tool IDs or a namespace followed by
+// a colon ("mcp:"); a tool's own setting wins over its namespace's. The zero
+// value enables every tool.
+type Toggles struct {
+	mu        sync.Mutex
+	state     map[string]bool // key -> enabled
+	version   int
+	announced int
+}
+
+// Enable turns a tool or namespace on.
+func (t *Toggles) Enable(key string) { t.set(key, true) }
+
+// Disable turns a tool or namespace off.
+func (t *Toggles) Disable(key string) { t.set(key, false) }
+
+func (t *Toggles) set(key string, enabled bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.state == nil {
+		t.state = make(map[string]bool)
+	}
+	current, ok := t.state[key]
+	changed := !ok || current != enabled
+	if strings.HasSuffix(key, ":") {
+		// Switching a whole namespace overrides its tools' own settings.
+		for k := range t.state {
+			if k != key && strings.HasPrefix(k, key) {
+				delete(t.state, k)
+				changed = true
+			}
+		}
+	}
+	t.state[key] = enabled
+	if changed {
+		t.version++
+	}
+}
+
+// Enabled reports whether tool may be offered and called.
+func (t *Toggles) Enabled(tool Tool) bool {
+	if t == nil {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if enabled, ok := t.state[QualifiedID(tool)]; ok {
+		return enabled
+	}
+	if enabled, ok := t.state[NamespaceOf(tool)+":"]; ok {
+		return enabled
+	}
+	return true
+}
+
+// Disabled returns the keys currently switched off, sorted.
+func (t *Toggles) Disabled() []string {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var keys []string
+	for k, enabled := range t.state {
+		if !enabled {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Changed reports whether the toggles changed since the last call to
+// Changed, so a change is announced once.
+func (t *Toggles) Changed() bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	changed := t.version != t.announced
+	t.announced = t.version
+	return changed
+}
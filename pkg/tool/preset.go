@@ -0,0 +1,72 @@
+package tool
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Presets are named groups of built-in tools a profile can enable with one
+// entry in tools.enabled.
+const (
+	PresetGit = "@git" // structured git tools, so policies see git operations instead of opaque shell commands
+)
+
+var presets = map[string][]string{
+	PresetGit: {"git/status", "git/diff", "git/log", "git/branch", "git/commit"},
+}
+
+// Preset returns the tool IDs a preset enables.
+func Preset(name string) ([]string, bool) {
+	ids, ok := presets[name]
+	return append([]string(nil), ids...), ok
+}
+
+// ExpandPresets replaces preset names in ids with their tools, keeping the
+// first occurrence of each ID.
+func ExpandPresets(ids []string) ([]string, error) {
+	return ExpandPresetsWith(ids, nil)
+}
+
+// ExpandPresetsWith is ExpandPresets with custom presets, keyed by name
+// without the "@", alongside the built-in ones. Their members may name
+// built-in presets; a custom preset cannot replace a built-in one.
+func ExpandPresetsWith(ids []string, custom map[string][]string) ([]string, error) {
+	out := make([]string, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	add := func(id string) {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	for _, id := range ids {
+		if !strings.HasPrefix(id, "@") {
+			add(id)
+			continue
+		}
+		if members, ok := presets[id]; ok {
+			for _, member := range members {
+				add(member)
+			}
+			continue
+		}
+		members, ok := custom[strings.TrimPrefix(id, "@")]
+		if !ok {
+			return nil, fmt.Errorf("unknown tool preset %q", id)
+		}
+		for _, member := range members {
+			if !strings.HasPrefix(member, "@") {
+				add(member)
+				continue
+			}
+			builtin, ok := presets[member]
+			if !ok {
+				return nil, fmt.Errorf("tool preset %q: unknown built-in preset %q", id, member)
+			}
+			for _, b := range builtin {
+				add(b)
+			}
+		}
+	}
+	return out, nil
+}
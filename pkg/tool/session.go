@@ -0,0 +1,33 @@
+package tool
+
+import "context"
+
+type sessionKey struct{}
+
+// WithSession attaches the ID of the session a tool call belongs to, for
+// tools that keep state across the calls of one session.
+func WithSession(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, sessionKey{}, sessionID)
+}
+
+// SessionFrom returns the session ID attached by WithSession.
+func SessionFrom(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(sessionKey{}).(string)
+	return id, ok && id != ""
+}
+
+// SessionCloser is implemented by tools that keep state per session, such
+// as a persistent shell, to release it once the session's run or
+// conversation has ended.
+type SessionCloser interface {
+	CloseSession(sessionID string)
+}
+
+// CloseSession releases the state each of tools keeps for sessionID.
+func CloseSession(tools []Tool, sessionID string) {
+	for _, t := range tools {
+		if closer, ok := As[SessionCloser](t); ok {
+			closer.CloseSession(sessionID)
+		}
+	}
+}
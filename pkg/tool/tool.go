@@ -18,6 +18,18 @@ type Result struct {
 	ToolID string
 	Output string
 	Data   map[string]any
+	// Images are sent to the model with Output, for models that can view
+	// them; see ModelFrom.
+	Images []Image
+	// Artifacts are the files the call produced or changed; see
+	// DeclaredArtifacts.
+	Artifacts []Artifact
+}
+
+// Image is an encoded image (PNG, JPEG, GIF, or WebP) in a tool result.
+type Image struct {
+	MediaType string
+	Data      []byte
 }
 
 type Tool interface {
@@ -30,3 +42,71 @@ type Registry interface {
 	Get(id string) (Tool, bool)
 	List() []Definition
 }
+
+// Capabilities are what a tool declares about itself so the runtime and
+// policies can schedule and confirm it sensibly. The zero value claims
+// nothing.
+type Capabilities struct {
+	SupportsStreaming bool
+	SupportsCancel    bool
+	Concurrency       int   // maximum parallel calls; 0 is unlimited
+	NeedsConfirmation *bool // approval default when no policy rule decides
+	Tags              []string
+	// Cacheable marks a tool whose result depends only on its arguments
+	// and the workspace, such as a file read or search. When the profile
+	// enables tools.cache, an identical call later in the run reuses the
+	// result until a tool that is not cacheable runs.
+	Cacheable bool
+	// Idempotent marks a tool whose call can be repeated without repeating
+	// its effects, so a call failing with ErrTransient is retried. Cacheable
+	// tools are retried as well.
+	Idempotent bool
+	// Serial runs one call of the tool at a time, as Concurrency 1 does.
+	Serial bool
+	// Exclusive names groups of tools whose calls never overlap, in this
+	// run or any other in the process: a call waits until no call of a
+	// tool sharing one of its groups is running. Tools that modify the
+	// workspace share ExclusiveWorkspace.
+	Exclusive []string
+}
+
+// ExclusiveWorkspace is the Exclusive group of tools that modify files in
+// the workspace, so parallel calls of them cannot race.
+const ExclusiveWorkspace = "workspace"
+
+// TagWrites marks a tool that modifies the workspace or repository without
+// going through core/write or core/edit. Read-only profiles deny it.
+const TagWrites = "writes"
+
+// Describer is implemented by tools that declare capabilities.
+type Describer interface {
+	Capabilities() Capabilities
+}
+
+// CapabilitiesOf returns t's declared capabilities, or the zero value.
+func CapabilitiesOf(t Tool) Capabilities {
+	if d, ok := As[Describer](t); ok {
+		return d.Capabilities()
+	}
+	return Capabilities{}
+}
+
+// As returns the first tool in t's chain of wrappers, t included, that
+// implements T, following an Unwrap() Tool method as errors.As follows
+// Unwrap. A tool wrapping another implements Unwrap so that the optional
+// interfaces of the tool it wraps (Describer, Namespaced, DryRunner,
+// SessionCloser) are found through it.
+func As[T any](t Tool) (T, bool) {
+	for t != nil {
+		if v, ok := t.(T); ok {
+			return v, true
+		}
+		u, ok := t.(interface{ Unwrap() Tool })
+		if !ok {
+			break
+		}
+		t = u.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
@@ -0,0 +1,24 @@
+package tool
+
+import "context"
+
+// UpdateFunc receives output a tool produces while it runs, in the order
+// it is produced. The tool's Result still carries the whole output.
+type UpdateFunc func(output string)
+
+type updateKey struct{}
+
+// WithUpdates attaches fn to ctx so a long-running tool can stream its
+// output while the call is in progress.
+func WithUpdates(ctx context.Context, fn UpdateFunc) context.Context {
+	return context.WithValue(ctx, updateKey{}, fn)
+}
+
+// UpdatesFrom returns the function attached by WithUpdates, or one that
+// discards its output.
+func UpdatesFrom(ctx context.Context) UpdateFunc {
+	if fn, ok := ctx.Value(updateKey{}).(UpdateFunc); ok && fn != nil {
+		return fn
+	}
+	return func(string) {}
+}
@@ -0,0 +1,235 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitop-dev/agent/pkg/events"
+)
+
+// StepRequest is a step that is ready to run, with its prompt expanded.
+type StepRequest struct {
+	RunID  string
+	Step   Step
+	Prompt string
+}
+
+// StepResult is a finished step's reply.
+type StepResult struct {
+	Output    string
+	SessionID string
+}
+
+// StepRunner runs one step as an agent run.
+type StepRunner interface {
+	RunStep(ctx context.Context, req StepRequest) (StepResult, error)
+}
+
+// StepRunnerFunc adapts a function to StepRunner.
+type StepRunnerFunc func(ctx context.Context, req StepRequest) (StepResult, error)
+
+func (f StepRunnerFunc) RunStep(ctx context.Context, req StepRequest) (StepResult, error) {
+	return f(ctx, req)
+}
+
+// Engine executes workflows. Store and Events are optional.
+type Engine struct {
+	Runner StepRunner
+	Store  Store
+	Events events.Sink
+	Now    func() time.Time // defaults to time.Now
+}
+
+// Start validates w and runs it as a new run.
+func (e Engine) Start(ctx context.Context, w Workflow, inputs map[string]string) (State, error) {
+	if err := w.Validate(); err != nil {
+		return State{}, err
+	}
+	for _, name := range w.Inputs {
+		if _, ok := inputs[name]; !ok {
+			return State{}, fmt.Errorf("missing workflow input %q", name)
+		}
+	}
+	now := e.now()
+	state := State{
+		ID:        "wf-" + now.UTC().Format("20060102T150405.000000"),
+		Workflow:  w,
+		Inputs:    inputs,
+		Status:    StatusRunning,
+		Steps:     make(map[string]*StepState, len(w.Steps)),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	for _, step := range w.Steps {
+		state.Steps[step.ID] = &StepState{Status: StatusPending}
+	}
+	return e.run(ctx, &state)
+}
+
+// Resume continues a stored run. Finished steps keep their outputs; failed,
+// skipped, and interrupted steps run again.
+func (e Engine) Resume(ctx context.Context, id string) (State, error) {
+	if e.Store == nil {
+		return State{}, fmt.Errorf("resume requires a workflow store")
+	}
+	state, err := e.Store.Load(ctx, id)
+	if err != nil {
+		return State{}, err
+	}
+	if err := state.Workflow.Validate(); err != nil {
+		return State{}, err
+	}
+	for _, step := range state.Workflow.Steps {
+		st := state.Steps[step.ID]
+		if st == nil {
+			st = &StepState{}
+			state.Steps[step.ID] = st
+		}
+		if st.Status != StatusDone {
+			st.Status, st.Error = StatusPending, ""
+		}
+	}
+	state.Status = StatusRunning
+	return e.run(ctx, &state)
+}
+
+type stepDone struct {
+	id     string
+	result StepResult
+	err    error
+}
+
+// run schedules steps until none are runnable. Only this goroutine touches
+// state; step goroutines report back on a channel.
+func (e Engine) run(ctx context.Context, state *State) (State, error) {
+	w := state.Workflow
+	order, err := w.order()
+	if err != nil {
+		return *state, err
+	}
+	steps := make(map[string]Step, len(w.Steps))
+	for _, step := range w.Steps {
+		steps[step.ID] = step
+	}
+	limit := w.MaxParallel
+	if limit <= 0 {
+		limit = len(w.Steps)
+	}
+	// Each step starts at most once per run, so no worker blocks on done
+	// when a failed save returns early.
+	done := make(chan stepDone, len(w.Steps))
+	running := 0
+	for {
+		// order is topological, so skips propagate within one pass.
+		for _, id := range order {
+			st := state.Steps[id]
+			if st.Status != StatusPending {
+				continue
+			}
+			blocked, failed := false, false
+			for _, dep := range steps[id].DependsOn {
+				switch state.Steps[dep].Status {
+				case StatusDone:
+				case StatusFailed, StatusSkipped:
+					failed = true
+				default:
+					blocked = true
+				}
+			}
+			if failed {
+				st.Status, st.Error = StatusSkipped, "a dependency did not complete"
+				e.publish(ctx, state, id, st)
+				continue
+			}
+			if blocked || running >= limit || ctx.Err() != nil {
+				continue
+			}
+			prompt, err := expand(steps[id].Prompt, state.Inputs, state.Steps)
+			if err != nil {
+				st.Status, st.Error = StatusFailed, err.Error()
+				e.publish(ctx, state, id, st)
+				continue
+			}
+			st.Status, st.Prompt, st.StartedAt, st.FinishedAt = StatusRunning, prompt, e.now(), time.Time{}
+			st.Attempts++
+			running++
+			e.publish(ctx, state, id, st)
+			go func(req StepRequest) {
+				result, err := e.Runner.RunStep(ctx, req)
+				done <- stepDone{id: req.Step.ID, result: result, err: err}
+			}(StepRequest{RunID: state.ID, Step: steps[id], Prompt: prompt})
+		}
+		if err := e.save(ctx, state); err != nil {
+			return *state, err
+		}
+		if running == 0 {
+			break
+		}
+		finished := <-done
+		running--
+		st := state.Steps[finished.id]
+		st.FinishedAt = e.now()
+		st.SessionID = finished.result.SessionID
+		st.Output = finished.result.Output
+		if finished.err != nil {
+			st.Status, st.Error = StatusFailed, finished.err.Error()
+		} else if data, err := parseOutput(steps[finished.id].Output, finished.result.Output); err != nil {
+			st.Status, st.Error = StatusFailed, err.Error()
+		} else {
+			st.Status, st.Data = StatusDone, data
+		}
+		e.publish(ctx, state, finished.id, st)
+	}
+
+	var incomplete []string
+	for _, id := range order {
+		if state.Steps[id].Status != StatusDone {
+			incomplete = append(incomplete, id+" "+state.Steps[id].Status)
+		}
+	}
+	state.Status = StatusDone
+	if len(incomplete) > 0 {
+		state.Status = StatusFailed
+	}
+	if err := e.save(ctx, state); err != nil {
+		return *state, err
+	}
+	if len(incomplete) > 0 {
+		sort.Strings(incomplete)
+		return *state, fmt.Errorf("workflow %s did not complete: %s", state.ID, strings.Join(incomplete, ", "))
+	}
+	return *state, nil
+}
+
+func (e Engine) save(ctx context.Context, state *State) error {
+	state.UpdatedAt = e.now()
+	if e.Store == nil {
+		return nil
+	}
+	// Persist even when ctx is cancelled so an interrupted run can resume.
+	if err := e.Store.Save(context.WithoutCancel(ctx), *state); err != nil {
+		return fmt.Errorf("save workflow state: %w", err)
+	}
+	return nil
+}
+
+func (e Engine) publish(ctx context.Context, state *State, id string, st *StepState) {
+	if e.Events == nil {
+		return
+	}
+	msg := fmt.Sprintf("%s: step %s %s", state.ID, id, st.Status)
+	if st.Error != "" {
+		msg += ": " + st.Error
+	}
+	_ = e.Events.Publish(ctx, events.Event{Type: events.TypeWorkflowStep, Time: e.now(), Message: msg, Data: map[string]any{"run": state.ID, "step": id, "status": st.Status}})
+}
+
+func (e Engine) now() time.Time {
+	if e.Now != nil {
+		return e.Now()
+	}
+	return time.Now()
+}
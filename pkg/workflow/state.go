@@ -0,0 +1,120 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Step and run statuses.
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped" // a dependency failed
+)
+
+// State is the persisted progress of one workflow run. It carries the
+// workflow definition so a run can resume without the original file.
+type State struct {
+	ID        string                `json:"id"`
+	Workflow  Workflow              `json:"workflow"`
+	Inputs    map[string]string     `json:"inputs,omitempty"`
+	Status    string                `json:"status"`
+	Steps     map[string]*StepState `json:"steps"`
+	CreatedAt time.Time             `json:"createdAt"`
+	UpdatedAt time.Time             `json:"updatedAt"`
+}
+
+// StepState is one step's progress and result.
+type StepState struct {
+	Status     string         `json:"status"`
+	Prompt     string         `json:"prompt,omitempty"` // as expanded for the last attempt
+	Output     string         `json:"output,omitempty"`
+	Data       map[string]any `json:"data,omitempty"` // parsed output of a JSON step
+	Error      string         `json:"error,omitempty"`
+	SessionID  string         `json:"sessionId,omitempty"`
+	Attempts   int            `json:"attempts,omitempty"`
+	StartedAt  time.Time      `json:"startedAt,omitzero"`
+	FinishedAt time.Time      `json:"finishedAt,omitzero"`
+}
+
+// Store persists run states.
+type Store interface {
+	Save(ctx context.Context, state State) error
+	Load(ctx context.Context, id string) (State, error)
+	List(ctx context.Context) ([]State, error)
+}
+
+// FileStore keeps one JSON file per run in Dir.
+type FileStore struct {
+	Dir string
+
+	mu sync.Mutex
+}
+
+func (s *FileStore) Save(_ context.Context, state State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+	path := s.path(state.ID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *FileStore) Load(_ context.Context, id string) (State, error) {
+	if id == "" || filepath.Base(id) != id {
+		return State{}, fmt.Errorf("invalid workflow run id %q", id)
+	}
+	data, err := os.ReadFile(s.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return State{}, fmt.Errorf("workflow run %q not found", id)
+	}
+	if err != nil {
+		return State{}, err
+	}
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return State{}, fmt.Errorf("parse workflow run %s: %w", id, err)
+	}
+	return state, nil
+}
+
+// List returns every run, most recently updated first.
+func (s *FileStore) List(ctx context.Context) ([]State, error) {
+	matches, err := filepath.Glob(filepath.Join(s.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var states []State
+	for _, path := range matches {
+		state, err := s.Load(ctx, filepath.Base(path[:len(path)-len(".json")]))
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].UpdatedAt.After(states[j].UpdatedAt) })
+	return states, nil
+}
+
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.Dir, id+".json")
+}
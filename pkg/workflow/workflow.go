@@ -0,0 +1,238 @@
+// Package workflow runs a directed acyclic graph of agent steps. Each step
+// is a prompt template run on a profile with an optional tool preset and
+// model; steps whose dependencies are done run in parallel, outputs flow
+// into later prompts, and progress is persisted after every step so an
+// interrupted run can resume where it stopped.
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Output types.
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+)
+
+// Workflow is a named graph of steps.
+type Workflow struct {
+	Name        string `yaml:"name" json:"name"`
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+	// Inputs are the names callers must supply; prompts use {{input.NAME}}.
+	Inputs      []string `yaml:"inputs,omitempty" json:"inputs,omitempty"`
+	MaxParallel int      `yaml:"maxParallel,omitempty" json:"maxParallel,omitempty"` // 0 runs every ready step at once
+	Steps       []Step   `yaml:"steps" json:"steps"`
+}
+
+// Step is one agent run in the graph.
+type Step struct {
+	ID string `yaml:"id" json:"id"`
+	// Prompt is expanded before the step runs: {{input.NAME}} is a workflow
+	// input, {{ID}} a text step's output, and {{ID.FIELD}} a field of a JSON
+	// step's output.
+	Prompt    string     `yaml:"prompt" json:"prompt"`
+	Profile   string     `yaml:"profile,omitempty" json:"profile,omitempty"`
+	Tools     []string   `yaml:"tools,omitempty" json:"tools,omitempty"` // restricts the profile's tools
+	Model     string     `yaml:"model,omitempty" json:"model,omitempty"`
+	MaxTurns  int        `yaml:"maxTurns,omitempty" json:"maxTurns,omitempty"`
+	DependsOn []string   `yaml:"dependsOn,omitempty" json:"dependsOn,omitempty"`
+	Output    OutputSpec `yaml:"output,omitempty" json:"output,omitempty"`
+}
+
+// OutputSpec declares what a step produces. A JSON step's reply must
+// contain an object with every Required field; it is parsed so later steps
+// can reference individual fields.
+type OutputSpec struct {
+	Type     string   `yaml:"type,omitempty" json:"type,omitempty"` // text (default) or json
+	Required []string `yaml:"required,omitempty" json:"required,omitempty"`
+}
+
+var placeholder = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.-]+)\s*\}\}`)
+
+// Validate checks step IDs, dependencies, and output types, and rejects
+// cycles and references to steps a prompt does not depend on.
+func (w Workflow) Validate() error {
+	if len(w.Steps) == 0 {
+		return errors.New("workflow has no steps")
+	}
+	steps := make(map[string]Step, len(w.Steps))
+	for _, step := range w.Steps {
+		if step.ID == "" || strings.Contains(step.ID, ".") {
+			return fmt.Errorf("step id %q must be non-empty and must not contain '.'", step.ID)
+		}
+		if step.ID == "input" {
+			return errors.New(`step id "input" is reserved`)
+		}
+		if _, dup := steps[step.ID]; dup {
+			return fmt.Errorf("duplicate step id %q", step.ID)
+		}
+		if strings.TrimSpace(step.Prompt) == "" {
+			return fmt.Errorf("step %s: prompt is required", step.ID)
+		}
+		switch step.Output.Type {
+		case "", OutputText, OutputJSON:
+		default:
+			return fmt.Errorf("step %s: unknown output type %q", step.ID, step.Output.Type)
+		}
+		steps[step.ID] = step
+	}
+	for _, step := range w.Steps {
+		for _, dep := range step.DependsOn {
+			if _, ok := steps[dep]; !ok {
+				return fmt.Errorf("step %s depends on unknown step %q", step.ID, dep)
+			}
+		}
+		for _, ref := range references(step.Prompt) {
+			head, _, _ := strings.Cut(ref, ".")
+			if head == "input" {
+				continue
+			}
+			if _, ok := steps[head]; !ok {
+				return fmt.Errorf("step %s references unknown step %q", step.ID, head)
+			}
+			if !dependsOn(steps, step.ID, head, map[string]bool{}) {
+				return fmt.Errorf("step %s uses the output of %s without depending on it", step.ID, head)
+			}
+		}
+	}
+	if _, err := w.order(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// order returns the step IDs in a topological order.
+func (w Workflow) order() ([]string, error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	steps := make(map[string]Step, len(w.Steps))
+	for _, step := range w.Steps {
+		steps[step.ID] = step
+	}
+	state := map[string]int{}
+	var out []string
+	var visit func(id string, path []string) error
+	visit = func(id string, path []string) error {
+		switch state[id] {
+		case visiting:
+			return fmt.Errorf("dependency cycle: %s", strings.Join(append(path, id), " -> "))
+		case visited:
+			return nil
+		}
+		state[id] = visiting
+		for _, dep := range steps[id].DependsOn {
+			if err := visit(dep, append(path, id)); err != nil {
+				return err
+			}
+		}
+		state[id] = visited
+		out = append(out, id)
+		return nil
+	}
+	for _, step := range w.Steps {
+		if err := visit(step.ID, nil); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// dependsOn reports whether step id transitively depends on target.
+func dependsOn(steps map[string]Step, id, target string, seen map[string]bool) bool {
+	if seen[id] {
+		return false
+	}
+	seen[id] = true
+	for _, dep := range steps[id].DependsOn {
+		if dep == target || dependsOn(steps, dep, target, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+func references(prompt string) []string {
+	var refs []string
+	for _, m := range placeholder.FindAllStringSubmatch(prompt, -1) {
+		refs = append(refs, m[1])
+	}
+	return refs
+}
+
+// expand fills a prompt's placeholders from the inputs and finished steps.
+func expand(prompt string, inputs map[string]string, steps map[string]*StepState) (string, error) {
+	var missing []string
+	out := placeholder.ReplaceAllStringFunc(prompt, func(match string) string {
+		ref := placeholder.FindStringSubmatch(match)[1]
+		head, field, hasField := strings.Cut(ref, ".")
+		if head == "input" {
+			if v, ok := inputs[field]; ok {
+				return v
+			}
+			missing = append(missing, ref)
+			return match
+		}
+		st := steps[head]
+		if st == nil || st.Status != StatusDone {
+			missing = append(missing, ref)
+			return match
+		}
+		if !hasField {
+			return st.Output
+		}
+		v, ok := st.Data[field]
+		if !ok {
+			missing = append(missing, ref)
+			return match
+		}
+		if s, ok := v.(string); ok {
+			return s
+		}
+		data, _ := json.Marshal(v)
+		return string(data)
+	})
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return "", fmt.Errorf("unresolved placeholders: %s", strings.Join(missing, ", "))
+	}
+	return out, nil
+}
+
+// parseOutput extracts a JSON step's object from the reply, accepting a
+// fenced code block or the outermost braces, and checks required fields.
+func parseOutput(spec OutputSpec, reply string) (map[string]any, error) {
+	if spec.Type != OutputJSON {
+		return nil, nil
+	}
+	text := reply
+	if start := strings.Index(text, "```"); start >= 0 {
+		body := text[start+3:]
+		body = strings.TrimPrefix(body, "json")
+		if end := strings.Index(body, "```"); end >= 0 {
+			text = body[:end]
+		}
+	}
+	first, last := strings.Index(text, "{"), strings.LastIndex(text, "}")
+	if first < 0 || last < first {
+		return nil, errors.New("expected a JSON object in the reply")
+	}
+	var data map[string]any
+	if err := json.Unmarshal([]byte(text[first:last+1]), &data); err != nil {
+		return nil, fmt.Errorf("parse JSON output: %w", err)
+	}
+	for _, field := range spec.Required {
+		if _, ok := data[field]; !ok {
+			return nil, fmt.Errorf("JSON output is missing required field %q", field)
+		}
+	}
+	return data, nil
+}
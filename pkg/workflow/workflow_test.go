@@ -0,0 +1,128 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestValidateRejectsBadGraphs(t *testing.T) {
+	cases := map[string]struct {
+		steps []Step
+		want  string
+	}{
+		"cycle": {[]Step{
+			{ID: "a", Prompt: "x", DependsOn: []string{"b"}},
+			{ID: "b", Prompt: "x", DependsOn: []string{"a"}},
+		}, "dependency cycle"},
+		"unknown dependency": {[]Step{{ID: "a", Prompt: "x", DependsOn: []string{"nope"}}}, "unknown step"},
+		"undeclared reference": {[]Step{
+			{ID: "a", Prompt: "x"},
+			{ID: "b", Prompt: "use {{a}}"},
+		}, "without depending on it"},
+		"duplicate": {[]Step{{ID: "a", Prompt: "x"}, {ID: "a", Prompt: "y"}}, "duplicate step"},
+	}
+	for name, tc := range cases {
+		err := Workflow{Name: name, Steps: tc.steps}.Validate()
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected error containing %q, got %v", name, tc.want, err)
+		}
+	}
+}
+
+func TestEngineRunsParallelStepsAndPassesTypedOutputs(t *testing.T) {
+	w := Workflow{
+		Name:   "review",
+		Inputs: []string{"file"},
+		Steps: []Step{
+			{ID: "lint", Prompt: "lint {{input.file}}"},
+			{ID: "risk", Prompt: "rate {{input.file}}", Output: OutputSpec{Type: OutputJSON, Required: []string{"level"}}},
+			{ID: "report", Prompt: "lint said {{lint}}; risk is {{risk.level}}", DependsOn: []string{"lint", "risk"}},
+		},
+	}
+	var inFlight, peak atomic.Int32
+	var mu sync.Mutex
+	prompts := map[string]string{}
+	runner := StepRunnerFunc(func(_ context.Context, req StepRequest) (StepResult, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		prompts[req.Step.ID] = req.Prompt
+		mu.Unlock()
+		switch req.Step.ID {
+		case "lint":
+			return StepResult{Output: "2 warnings"}, nil
+		case "risk":
+			return StepResult{Output: "Here you go:\n```json\n{\"level\": \"low\"}\n```"}, nil
+		default:
+			return StepResult{Output: "done"}, nil
+		}
+	})
+	store := &FileStore{Dir: t.TempDir()}
+	state, err := Engine{Runner: runner, Store: store}.Start(context.Background(), w, map[string]string{"file": "main.go"})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if state.Status != StatusDone {
+		t.Fatalf("expected done, got %s", state.Status)
+	}
+	if peak.Load() != 2 {
+		t.Fatalf("expected lint and risk to run in parallel, peak concurrency %d", peak.Load())
+	}
+	if got := prompts["report"]; got != "lint said 2 warnings; risk is low" {
+		t.Fatalf("unexpected expanded prompt %q", got)
+	}
+	saved, err := store.Load(context.Background(), state.ID)
+	if err != nil || saved.Steps["risk"].Data["level"] != "low" {
+		t.Fatalf("expected persisted typed output, got %+v (%v)", saved.Steps["risk"], err)
+	}
+}
+
+func TestEngineResumesFailedRun(t *testing.T) {
+	w := Workflow{Name: "chain", Steps: []Step{
+		{ID: "fetch", Prompt: "fetch"},
+		{ID: "summarize", Prompt: "summarize {{fetch}}", DependsOn: []string{"fetch"}},
+		{ID: "publish", Prompt: "publish {{summarize}}", DependsOn: []string{"summarize"}},
+	}}
+	runs := map[string]int{}
+	failSummarize := true
+	runner := StepRunnerFunc(func(_ context.Context, req StepRequest) (StepResult, error) {
+		runs[req.Step.ID]++
+		if req.Step.ID == "summarize" && failSummarize {
+			return StepResult{}, errors.New("provider unavailable")
+		}
+		return StepResult{Output: req.Step.ID + " ok"}, nil
+	})
+	store := &FileStore{Dir: t.TempDir()}
+	engine := Engine{Runner: runner, Store: store}
+	state, err := engine.Start(context.Background(), w, nil)
+	if err == nil || state.Status != StatusFailed {
+		t.Fatalf("expected the run to fail, got %s (%v)", state.Status, err)
+	}
+	if state.Steps["publish"].Status != StatusSkipped {
+		t.Fatalf("expected publish skipped, got %s", state.Steps["publish"].Status)
+	}
+
+	failSummarize = false
+	state, err = engine.Resume(context.Background(), state.ID)
+	if err != nil || state.Status != StatusDone {
+		t.Fatalf("resume: %s (%v)", state.Status, err)
+	}
+	if runs["fetch"] != 1 || runs["summarize"] != 2 || runs["publish"] != 1 {
+		t.Fatalf("expected only unfinished steps to rerun, got %v", runs)
+	}
+	if state.Steps["summarize"].Attempts != 2 {
+		t.Fatalf("expected two attempts recorded, got %d", state.Steps["summarize"].Attempts)
+	}
+}